@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jwoglom/faketandem/pkg/api"
@@ -12,6 +18,7 @@ import (
 	"github.com/jwoglom/faketandem/pkg/handler"
 	"github.com/jwoglom/faketandem/pkg/protocol"
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
 	"github.com/jwoglom/faketandem/pkg/state"
 
 	log "github.com/sirupsen/logrus"
@@ -28,6 +35,45 @@ func main() {
 	var jpakeLongTermKey = flag.String("jpake-long-term-key", "", "hex-encoded JPAKE long-term key to pre-seed, letting a previously-paired client quick-pair (reconnect via Jpake3SessionKeyRequest directly) without a fresh full pairing; also displayed/settable in the web UI once derived from a completed pairing")
 	var gradleCmd = flag.String("gradle-cmd", "./gradlew", "gradle command to use")
 	var javaCmd = flag.String("java-cmd", "java", "java command to use")
+	var allowNoBridge = flag.Bool("allow-no-bridge", false, "keep running if pumpX2 can't be found or the bridge fails to start: advertise and serve the API, handle the built-in curated messages and 'encoded' settings responses, and fail everything else with a bridge unavailable error")
+	var reassemblyTimeout = flag.Duration("reassembly-timeout", config.DefaultReassemblyTimeout, "how long to keep a partially received multi-packet message before discarding it")
+	var txTimeout = flag.Duration("tx-timeout", config.DefaultTxTimeout, "how long a pending transaction waits for a response")
+	var maxMessageSize = flag.Int("max-message-size", config.DefaultMaxMessageSize, "reject incoming multi-packet messages that could exceed this many bytes (0 disables)")
+	var historyPageSize = flag.Int("history-page-size", config.DefaultHistoryPageSize, "most history log entries streamed per HistoryLogRequest; larger ranges are fetched a page at a time")
+	var rxWorkers = flag.Int("rx-workers", config.DefaultRXWorkers, "most transactions whose incoming messages are parsed and routed concurrently; messages of one transaction are always handled in order")
+	var seed = flag.Int64("seed", 0, "seed for all randomized simulation behavior, so a session can be reproduced (default picks and logs a random seed)")
+	var cgmNoise = flag.Int("cgm-noise", 0, "random-walk the simulated CGM reading by up to this many mg/dL each simulator update (0 holds it steady)")
+	var cgmBaseline = flag.Int("cgm-baseline", 0, "model the simulated CGM reading as a sine wave around this many mg/dL, lowered by insulin on board, instead of a random walk (0 disables)")
+	var cgmAmplitude = flag.Int("cgm-amplitude", 40, "mg/dL the -cgm-baseline model swings either side of the baseline")
+	var cgmPeriod = flag.Duration("cgm-period", state.DefaultCGMModelPeriod, "length of one glucose cycle of the -cgm-baseline model")
+	var cgmFile = flag.String("cgm-file", "", "replay timestamped glucose readings from a .csv (timestamp,glucose) or .json file as the CGM reading, looping at the end, instead of simulating it")
+	var clockDrift = flag.Float64("clock-drift", 0, "seconds the pump clock gains per hour of real time, reflected in TimeSinceReset and the pump's current time (negative runs slow)")
+	var apiAddr = flag.String("api-addr", api.DefaultListenAddr, "address the web API listens on, e.g. :8081 to run a second emulator on the same host")
+	var readonlyAPI = flag.Bool("readonly-api", false, "serve GET endpoints and events only; reject mutating API endpoints and websocket commands with 403")
+	var requireEncryption = flag.Bool("require-encryption", false, "refuse reads and writes of the pump service characteristics with an insufficient-encryption error, and ignore subscriptions, until the link is marked encrypted (via the setLinkEncrypted websocket command, since the BLE stack doesn't report bonding)")
+	var multiCentralPolicy = flag.String("multi-central-policy", string(bluetooth.MultiCentralReject), "what happens when a second central connects while one is connected: reject (keep the first, like a real pump) or replace (disconnect the first)")
+	var minReconnectInterval = flag.Duration("min-reconnect-interval", 0, "reject a central that reconnects within this long of its previous disconnect (0 disables)")
+	var idleTimeout = flag.Duration("idle-timeout", 0, "drop a connection with no writes or notifications for this long, recording it as an idle disconnect (0 disables)")
+	var authLockoutAttempts = flag.Int("auth-lockout-attempts", 0, "refuse authentication after this many failed attempts on one connection, as a pump does against pairing code guessing (0 disables)")
+	var authLockoutCooldown = flag.Duration("auth-lockout-cooldown", handler.DefaultAuthLockoutCooldown, "how long authentication stays refused after -auth-lockout-attempts failed attempts")
+	var historyLogPacing = flag.Duration("history-log-pacing", bluetooth.DefaultHistoryLogNotifyPacing, "delay between HistoryLog notification packets, so long history streams don't overrun the client (0 sends them back-to-back)")
+	var reorderWindow = flag.Duration("reorder-window", 0, "hold responses to -reorder-types for this long and release them out of arrival order, to fuzz a client's handling of reordered responses (0 disables)")
+	var reorderMode = flag.String("reorder-mode", string(handler.ReorderReverse), "order held responses are released in: reverse or shuffle (seeded by -seed)")
+	var reorderTypes = flag.String("reorder-types", "", "comma-separated request message types whose responses -reorder-window holds")
+	var acceptedAPIVersions = flag.String("accepted-api-versions", "", "inclusive min-max range of API versions (e.g. 2.0-2.5) a session can be negotiated at; while the pump's own API version is outside it, every ApiVersionRequest gets an unsupported version ErrorResponse, to exercise a client's upgrade prompt (default accepts any)")
+	var unsupportedAPIVersionErrorCode = flag.Int("unsupported-api-version-error-code", handler.DefaultUnsupportedAPIVersionErrorCode, "ErrorResponse errorCode sent when the pump's API version is outside -accepted-api-versions")
+	var lowPowerDisconnect = flag.Bool("low-power-disconnect", false, "drop the connection after acknowledging a DisconnectPumpRequest, in addition to entering low power")
+	var connectDelay = flag.Duration("connect-delay", 0, "wait this long after a central connects before finishing connection setup, refusing writes until then, to simulate a pump slow to become ready (0 disables)")
+	var bleServices = flag.String("ble-services", "", "comma-separated auxiliary GATT services to register alongside the pump service: generic-access, generic-attribute, device-information, fdfa, or a custom 16/128-bit UUID (default registers the four named services, matching a real pump)")
+	var logRedactAuth = flag.Bool("log-redact-auth", true, "log only the length of Authorization characteristic packets and JPAKE/challenge key material instead of their contents")
+	var goldenOut = flag.String("golden-out", "", "record the whole session (advertising data, RX/TX packets, parsed messages, and state changes) to this JSON lines archive for later replay")
+	var alertAutoAck = flag.String("alert-auto-ack", "", "auto-acknowledge alerts after a timeout per priority, e.g. 'info=30s,warning=10m' (critical alerts never auto-acknowledge; default never)")
+	var insulinConcentration = flag.String("insulin-concentration", "", "insulin concentration in the reservoir, U-100 or U-200; U-200 uses half the reservoir volume per unit delivered (default from the pump model: U-200 for Mobi, otherwise U-100)")
+	var region = flag.String("region", string(state.RegionUS), "pump region, US or EU, which selects the default therapy limits, glucose units and supported features (EU: mmol/L, 20 unit max bolus, no Basal-IQ)")
+	var quietHours = flag.String("quiet-hours", "", "daily do-not-disturb window, e.g. '22:00-07:00', during which non-critical alerts are stored but not announced with a qualifying event (default none)")
+	var eventSchedule = flag.String("event-schedule", "", "fire synthetic qualifying events on a schedule for soak-testing clients: comma-separated event=interval (repeating) or event@delay (once) entries, e.g. 'bolusComplete=5m,batteryLow@10m'")
+	var startPairing = flag.String("start-pairing", string(bluetooth.PairingStateNotDiscoverable), "pairing state to start advertising in, so the pump is connectable without an API call: NotDiscoverable, DiscoverableOnly, PairStep1, or PairStep2")
+	var traceSample = flag.String("trace-sample", "", "packet log sampling: N logs 1 in N packets per direction/characteristic, 'edges' logs only the first and last packet of each message (default logs all)")
 
 	flag.Parse()
 
@@ -48,8 +94,51 @@ func main() {
 		ForceColors:  true,
 	})
 
+	var longTermKey []byte
+	if *jpakeLongTermKey != "" {
+		var err error
+		if longTermKey, err = api.ParseHex(*jpakeLongTermKey); err != nil {
+			log.Fatalf("Configuration error: invalid jpake-long-term-key: %s", err)
+		}
+	}
+
 	// Initialize configuration
-	cfg, err := config.New(*pumpX2Path, *pumpX2Mode, *jpakeMode, *gradleCmd, *javaCmd, logLevel, *pumpX2JarPath, *jpakeLongTermKey)
+	cfg, err := config.New(*pumpX2Path, *pumpX2Mode, *jpakeMode, *gradleCmd, *javaCmd, logLevel, *pumpX2JarPath, longTermKey)
+	var bridgeErr error
+	if *allowNoBridge && errors.Is(err, config.ErrPumpX2NotFound) {
+		bridgeErr = err
+		cfg, err = config.NewWithoutPumpX2(*pumpX2Mode, *jpakeMode, *gradleCmd, *javaCmd, logLevel, longTermKey)
+	}
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	if err := cfg.SetTimeouts(*reassemblyTimeout, *txTimeout); err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	if err := cfg.SetMaxMessageSize(*maxMessageSize); err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	if err := cfg.SetHistoryPageSize(*historyPageSize); err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	if err := cfg.SetRXWorkers(*rxWorkers); err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+
+	traceSampling, err := protocol.ParseTraceSample(*traceSample)
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	protocol.SetTraceSampling(traceSampling)
+	protocol.SetRedactAuth(*logRedactAuth)
+
+	var auxServices []bluetooth.AuxService
+	if *bleServices != "" {
+		if auxServices, err = bluetooth.ParseAuxServices(*bleServices); err != nil {
+			log.Fatalf("Configuration error: %s", err)
+		}
+	}
+	startPairingState, err := bluetooth.ParsePairingState(*startPairing)
 	if err != nil {
 		log.Fatalf("Configuration error: %s", err)
 	}
@@ -69,19 +158,30 @@ func main() {
 
 	// Initialize pumpX2 bridge
 	log.Info("Initializing pumpX2 bridge...")
-	bridge, err := pumpx2.NewBridge(cfg.PumpX2Path, cfg.PumpX2Mode, cfg.GradleCmd, cfg.JavaCmd, cfg.PumpX2JarPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize pumpX2 bridge: %s", err)
+	var bridge *pumpx2.Bridge
+	if bridgeErr == nil {
+		bridge, bridgeErr = pumpx2.NewBridge(cfg.PumpX2Path, cfg.PumpX2Mode, cfg.GradleCmd, cfg.JavaCmd, cfg.PumpX2JarPath)
+	}
+	if bridgeErr != nil {
+		if !*allowNoBridge {
+			log.Fatalf("Failed to initialize pumpX2 bridge: %s", bridgeErr)
+		}
+		log.Warnf("Running without the pumpX2 bridge: %s", bridgeErr)
+		log.Warn("Only built-in curated messages and 'encoded' settings responses will be handled")
+		bridge = pumpx2.NewUnavailableBridge(bridgeErr, mockrunner.New())
+	} else {
+		log.Info("pumpX2 bridge initialized successfully")
 	}
-	log.Info("pumpX2 bridge initialized successfully")
 
 	// Initialize protocol components
-	reassembler := protocol.NewReassembler(30 * time.Second)
+	reassembler := protocol.NewReassembler(cfg.ReassemblyTimeout)
 	defer reassembler.Stop()
+	reassembler.SetMaxMessageSize(cfg.MaxMessageSize)
 
-	txManager := protocol.NewTransactionManager(10 * time.Second)
+	txManager := protocol.NewTransactionManager(cfg.TxTimeout)
+	dispatcher := protocol.NewDispatcher(cfg.RXWorkers)
 
-	log.Debugf("Protocol components initialized: reassembler timeout=30s, transaction timeout=10s")
+	log.Debugf("Protocol components initialized: reassembler timeout=%s, transaction timeout=%s, rx workers=%d", cfg.ReassemblyTimeout, cfg.TxTimeout, cfg.RXWorkers)
 
 	// Initialize pump state
 	pumpState := state.NewPumpState()
@@ -98,36 +198,196 @@ func main() {
 		log.Infof("Seeded JPAKE long-term key from -jpake-long-term-key flag (%d bytes); quick-pair reconnects will be honored", len(cfg.JPAKELongTermKey))
 	}
 
+	if *insulinConcentration != "" {
+		concentration, err := state.ParseInsulinConcentration(*insulinConcentration)
+		if err != nil {
+			log.Fatalf("Configuration error: %s", err)
+		}
+		if err := pumpState.SetInsulinConcentration(concentration); err != nil {
+			log.Fatalf("Configuration error: %s", err)
+		}
+	}
+	log.Infof("Insulin concentration: %s", pumpState.GetInsulinConcentration())
+
+	pumpRegion, err := state.ParseRegion(*region)
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	if err := pumpState.SetRegion(pumpRegion); err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	log.Infof("Pump region: %s", pumpState.GetRegion())
+
+	quiet, err := state.ParseQuietHours(*quietHours)
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	if quiet != nil {
+		pumpState.SetQuietHours(quiet)
+		log.Infof("Quiet hours: %s", quiet)
+	}
+
+	if *clockDrift != 0 {
+		pumpState.SetClockDrift(*clockDrift)
+		log.Infof("Pump clock drifts %+.1f seconds per hour", *clockDrift)
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Infof("Random seed: %d (rerun with -seed=%d to reproduce)", *seed, *seed)
+	rng := state.NewRand(*seed)
+
 	// Start background simulator
 	simulator := state.NewSimulator(pumpState, 1*time.Second)
 	defer simulator.Stop()
+	simulator.SetRand(rng)
+	simulator.SetCGMNoise(*cgmNoise)
+	if *cgmBaseline > 0 {
+		simulator.SetCGMModel(&state.CGMModel{Baseline: *cgmBaseline, Amplitude: *cgmAmplitude, Period: *cgmPeriod})
+	}
+	autoAck, err := state.ParseAlertAutoAck(*alertAutoAck)
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	simulator.SetAlertAutoAck(autoAck)
+	schedule, err := state.ParseEventSchedule(*eventSchedule)
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	simulator.SetEventSchedule(schedule)
+	if *cgmFile != "" {
+		readings, err := state.LoadCGMFile(*cgmFile)
+		if err != nil {
+			log.Fatalf("Configuration error: %s", err)
+		}
+		simulator.SetCGMReplay(state.NewCGMReplay(readings, true))
+		log.Infof("Replaying %d CGM readings from %s", len(readings), *cgmFile)
+	}
 
-	ble, err := bluetooth.New("hci0")
+	// The golden session recorder must exist before BLE starts so it sees the
+	// first advertisement
+	var golden *handler.GoldenSessionRecorder
+	var advertisingHandler bluetooth.AdvertisingHandler
+	if *goldenOut != "" {
+		f, err := os.Create(*goldenOut)
+		if err != nil {
+			log.Fatalf("Could not create golden session archive: %s", err)
+		}
+		defer f.Close()
+		golden = handler.NewGoldenSessionRecorder(f)
+		advertisingHandler = golden.RecordAdvertising
+	}
+
+	ble, err := bluetooth.New("hci0", auxServices, nil, startPairingState, advertisingHandler)
 	if err != nil {
 		log.Fatalf("Could not start BLE: %s", err)
 	}
 
+	configureWriteValidators(ble)
+	if *requireEncryption {
+		ble.SetRequireEncryption(true)
+		log.Info("Pump service characteristics require an encrypted link")
+	}
+	policy, err := bluetooth.ParseMultiCentralPolicy(*multiCentralPolicy)
+	if err != nil {
+		log.Fatalf("Configuration error: %s", err)
+	}
+	ble.SetMultiCentralPolicy(policy)
+	if *minReconnectInterval > 0 {
+		ble.SetMinReconnectInterval(*minReconnectInterval)
+		log.Infof("Rejecting reconnects within %s of a disconnect", *minReconnectInterval)
+	}
+	ble.SetNotifyPacingFor(bluetooth.CharHistoryLog, *historyLogPacing)
+	if *idleTimeout > 0 {
+		ble.SetIdleTimeout(*idleTimeout)
+		log.Infof("Dropping connections idle for %s", *idleTimeout)
+	}
+	if *connectDelay > 0 {
+		ble.SetConnectDelay(*connectDelay)
+		log.Infof("Finishing connection setup %s after a central connects", *connectDelay)
+	}
+
 	// Create message router
 	router := handler.NewRouter(bridge, pumpState, ble, txManager, cfg.JPAKEMode, cfg.PumpX2Path, cfg.PumpX2Mode, cfg.GradleCmd, cfg.JavaCmd, cfg.PumpX2JarPath)
+	defer router.Close()
+	router.SetHistoryPageSize(cfg.HistoryPageSize)
+	router.GetSettingsManager().SetRand(rng)
+	router.SetLowPowerDisconnect(*lowPowerDisconnect)
+	if *reorderWindow > 0 {
+		mode, err := handler.ParseReorderMode(*reorderMode)
+		if err != nil {
+			log.Fatalf("Configuration error: %s", err)
+		}
+		router.SetResponseReorder(*reorderWindow, mode, splitList(*reorderTypes), state.NewRand(*seed))
+	}
+	if *acceptedAPIVersions != "" {
+		accepted, err := handler.ParseAPIVersionRange(*acceptedAPIVersions, *unsupportedAPIVersionErrorCode)
+		if err != nil {
+			log.Fatalf("Configuration error: %s", err)
+		}
+		router.SetAcceptedAPIVersions(accepted)
+	}
+	if *authLockoutAttempts > 0 {
+		router.GetAuthLockout().Configure(*authLockoutAttempts, *authLockoutCooldown)
+		log.Infof("Locking out authentication for %s after %d failed attempts", *authLockoutCooldown, *authLockoutAttempts)
+	}
 	log.Info("Message router initialized")
 
-	// Connect simulator with qualifying events notifier
-	simulator.SetEventNotifier(router.GetQualifyingEventsNotifier())
-	log.Info("Qualifying events notifier connected to simulator")
+	// Create API server
+	server := api.New(ble)
+	server.SetListenAddr(*apiAddr)
+
+	// Fan pump state changes out to BLE qualifying events and websocket clients
+	events := state.NewEventBus()
+	events.Subscribe(router.GetQualifyingEventsNotifier())
+	events.Subscribe(server.StateChangeNotifier())
+	simulator.SetEventNotifier(events)
+	router.SetEventNotifier(events)
+	pumpState.SetHistoryLogNotifier(events)
+	timeSeries := state.NewTimeSeries()
+	events.Subscribe(state.FieldNotifier(timeSeries.Record))
+	simulator.SetTimeSeries(timeSeries)
+	log.Info("Qualifying events and websocket state changes connected to simulator")
+
+	if golden != nil {
+		router.AddPacketObserver(golden.ObservePacket)
+		events.Subscribe(state.FieldNotifier(golden.RecordState))
+		log.Infof("Recording golden session to %s", *goldenOut)
+	}
 
 	// Start simulator after event notifier is connected
 	simulator.Start()
 	log.Info("Background simulator started (update interval: 1s)")
 
-	// Create API server
-	server := api.New(ble)
 	server.SetSettingsManager(router.GetSettingsManager())
-	configureConnectionHandlers(ble, server, router)
+	server.SetPumpState(pumpState)
+	server.SetBridge(bridge)
+	server.SetConfig(cfg)
+	server.SetReassembler(reassembler)
+	server.SetJPAKESessionManager(router.GetJPAKESessionManager())
+	server.SetStateAuditLog(router.GetStateAuditLog())
+	server.SetQualifyingEventsNotifier(router.GetQualifyingEventsNotifier())
+	server.SetAuthLockout(router.GetAuthLockout())
+	server.SetTimeSeries(timeSeries)
+	if *readonlyAPI {
+		server.SetReadOnly(true)
+		log.Info("Web API is read-only")
+	}
+	server.SetBasalRateHandler(router.SetBasalRate)
+	server.SetSuspendHandler(router.SetPumpingSuspended)
+	router.SetRejectionHandler(func(messageType string, reason handler.RejectReason) {
+		server.SendRejected(messageType, reason.String())
+	})
+	configureConnectionHandlers(ble, server, router, pumpState)
 
 	// Set up write handler to log incoming data and notify websocket clients
 	ble.SetWriteHandler(func(charType bluetooth.CharacteristicType, data []byte) {
 		protocol.LogPacket("RX", charType, data)
 		server.SendWriteEvent(charType, data)
+		if golden != nil {
+			golden.RecordPacket(charType, data)
+		}
 
 		// Reassemble multi-packet messages
 		message, rawPacketsHex, isComplete, err := reassembler.AddPacket(charType, data)
@@ -141,103 +401,240 @@ func main() {
 			return
 		}
 
-		// We have a complete message, parse it
-		log.Infof("Received complete message on %s: %s", charType, hex.EncodeToString(message))
-
-		// Parse the message using pumpX2 bridge
-		parsed, err := bridge.ParseMessage(charType, rawPacketsHex)
+		// We have a complete message. Parse and route it on its
+		// transaction's worker so a slow pumpX2 call doesn't block other
+		// transactions
+		log.Infof("Received complete message on %s: %s", charType, protocol.LogHex(charType, message))
+		header, err := protocol.ParsePacketHeader(data)
 		if err != nil {
-			log.Errorf("Failed to parse message: %v", err)
+			log.Errorf("Failed to read txID of complete message: %v", err)
 			return
 		}
+		dispatcher.Dispatch(charType, header.TxID, func() {
+			// Parse the message using pumpX2 bridge
+			parsed, err := bridge.ParseReceivedMessage(charType, rawPacketsHex)
+			if err != nil {
+				log.Errorf("Failed to parse message: %v", err)
+				return
+			}
 
-		log.Infof("Parsed message: type=%s, txID=%d, opcode=%d",
-			parsed.MessageType, parsed.TxID, parsed.Opcode)
+			log.Infof("Parsed message: type=%s, txID=%d, opcode=%d",
+				parsed.MessageType, parsed.TxID, parsed.Opcode)
+			if golden != nil {
+				golden.RecordMessage(charType, parsed)
+			}
 
-		// Route to handler
-		if err := router.RouteMessage(charType, parsed); err != nil {
-			log.Errorf("Failed to route message: %v", err)
-			if errors.Is(err, handler.ErrJPAKEQuickPairRejected) {
-				log.Warn("Dropping connection to force client back to full pairing (no cached long-term JPAKE key available for this quick-pair reconnect)")
-				ble.ShutdownConnection()
+			// Route to handler
+			if err := router.RouteMessage(charType, parsed); err != nil {
+				log.Errorf("Failed to route message: %v", err)
+				if errors.Is(err, handler.ErrJPAKEQuickPairRejected) {
+					log.Warn("Dropping connection to force client back to full pairing (no cached long-term JPAKE key available for this quick-pair reconnect)")
+					ble.ShutdownConnection()
+				}
 			}
-			return
-		}
+		})
 	})
 
 	// Set up read handler
 	ble.SetReadHandler(func(charType bluetooth.CharacteristicType) []byte {
 		log.Debugf("Read request on %s", charType)
-		// TODO: Return appropriate data based on characteristic
+		// Returning nil serves the data staged with SetCharacteristicData
 		return nil
 	})
 
 	// Set up custom command handler for websocket commands
-	configureWebsocketCommands(server, ble, bridge, pumpState)
+	configureWebsocketCommands(server, ble, bridge, pumpState, router)
 
 	log.Info("Bluetooth device initialized, waiting for connections...")
-	log.Info("Starting API server on :8080")
+	log.Infof("Starting API server on %s", *apiAddr)
+
+	go func() {
+		if err := server.Start(); err != nil {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	// Run until interrupted, then shut down; the remaining deferred cleanup
+	// runs once main returns
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Infof("Received %s, shutting down...", sig)
+	shutdown(server, ble, reassembler, txManager, bridge)
+}
 
-	// Start API server (blocking)
-	go server.Start()
+// shutdownTimeout bounds how long in-flight API requests get to finish
+const shutdownTimeout = 5 * time.Second
+
+// shutdown stops accepting BLE and API traffic, then drops in-flight
+// messages and stops the pumpX2 bridge
+func shutdown(server *api.Server, ble *bluetooth.Ble, reassembler *protocol.Reassembler, txManager *protocol.TransactionManager, bridge *pumpx2.Bridge) {
+	if err := ble.Close(); err != nil {
+		log.Errorf("Failed to close Bluetooth device: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Errorf("Failed to shut down API server: %v", err)
+	}
 
-	// Keep the program running
-	for {
-		time.Sleep(time.Hour)
+	reassembler.Stop()
+	txManager.ClearAll()
+	if err := bridge.Close(); err != nil {
+		log.Errorf("Failed to stop pumpX2 bridge: %v", err)
 	}
 }
 
-func configureConnectionHandlers(ble *bluetooth.Ble, server *api.Server, router *handler.Router) {
+// splitList splits a comma-separated flag value, dropping empty entries so
+// an unset flag is an empty list rather than one empty name
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configureWriteValidators rejects frames larger than each characteristic's
+// chunk size before they reach the reassembler
+func configureWriteValidators(ble *bluetooth.Ble) {
+	for _, charType := range []bluetooth.CharacteristicType{
+		bluetooth.CharCurrentStatus,
+		bluetooth.CharAuthorization,
+		bluetooth.CharControl,
+		bluetooth.CharControlStream,
+	} {
+		ble.SetWriteValidator(charType, bluetooth.MaxFrameSizeValidator(protocol.GetChunkSize(charType)))
+	}
+}
+
+func configureConnectionHandlers(ble *bluetooth.Ble, server *api.Server, router *handler.Router, pumpState *state.PumpState) {
 	ble.SetConnectionHandler(func(connected bool) {
 		server.SendPumpState()
 		if connected {
+			pumpState.SetCentralID(ble.CentralID())
+			router.GetAuthLockout().ResetFailures()
+			// A reconnecting client wakes a pump it left in low power
+			pumpState.SetLowPower(false)
 			log.Info("BLE central connected; updated websocket clients.")
 			return
 		}
-		log.Info("BLE central disconnected; updated websocket clients.")
-		// Clear any in-progress JPAKE authenticator so a stale/broken one
-		// (e.g. a pumpX2 subprocess that died mid-handshake) is never reused
-		// by the next connection attempt.
-		router.ResetJPAKESession()
+		reason, centralID := bluetooth.DisconnectCentral, ""
+		if events := ble.ConnectionEvents(); len(events) > 0 {
+			reason, centralID = events[len(events)-1].Reason, events[len(events)-1].CentralID
+		}
+		log.Infof("BLE central disconnected (%s); updated websocket clients.", reason)
+		// Clear the departed central's in-progress JPAKE authenticators so a
+		// stale/broken one (e.g. a pumpX2 subprocess that died mid-handshake)
+		// is never reused by its next connection attempt.
+		router.ResetJPAKESessionsFor(centralID)
+		router.CancelStatusSnapshot()
+	})
+	ble.SetOnSubscribe(bluetooth.CharCurrentStatus, func(charType bluetooth.CharacteristicType) {
+		if err := router.SendStatusSnapshot(); err != nil {
+			log.Warnf("Failed to send status snapshot: %v", err)
+		}
 	})
 }
 
-func configureWebsocketCommands(server *api.Server, ble *bluetooth.Ble, bridge *pumpx2.Bridge, pumpState *state.PumpState) {
-	server.SetCommandHandler(func(command string, params map[string]interface{}) {
+func configureWebsocketCommands(server *api.Server, ble *bluetooth.Ble, bridge *pumpx2.Bridge, pumpState *state.PumpState, router *handler.Router) {
+	server.SetCommandHandler(func(command string, params map[string]interface{}) (interface{}, bool, error) {
 		log.Infof("Received command from websocket: %s, params: %v", command, params)
-		switch command {
-		case "getPairingState":
-			server.SendPairingState(pumpState.GetPairingCode(), pumpState.IsAuthenticated, pumpState.GetLongTermKey())
-		case "setPairingCode":
-			pairingCode, _ := params["pairingCode"].(string)
-			if pairingCode == "" {
-				log.Warn("Pairing code missing from setPairingCode command")
-				return
-			}
-			pumpState.SetPairingCode(pairingCode)
-			pumpState.ResetAuthentication()
-			bridge.SetPairingCode(pairingCode)
-			server.SendPairingState(pumpState.GetPairingCode(), pumpState.IsAuthenticated, pumpState.GetLongTermKey())
-		case "resetPairing":
-			pumpState.ResetAuthentication()
-			server.SendPairingState(pumpState.GetPairingCode(), pumpState.IsAuthenticated, pumpState.GetLongTermKey())
-		case "setLongTermKey":
-			longTermKeyHex, _ := params["longTermKey"].(string)
-			longTermKey, err := hex.DecodeString(longTermKeyHex)
-			if longTermKeyHex == "" || err != nil {
-				log.Warnf("Invalid long-term key from setLongTermKey command: %q", longTermKeyHex)
-				return
-			}
-			pumpState.SetLongTermKey(longTermKey)
-			server.SendPairingState(pumpState.GetPairingCode(), pumpState.IsAuthenticated, pumpState.GetLongTermKey())
-		case "resetLongTermKey":
-			pumpState.SetLongTermKey(nil)
-			server.SendPairingState(pumpState.GetPairingCode(), pumpState.IsAuthenticated, pumpState.GetLongTermKey())
-		case "disconnectPump":
-			ble.ShutdownConnection()
-			server.SendPumpState()
-		default:
-			log.Warnf("Unhandled websocket command: %s", command)
+		if result, handled, err := handlePairingCommand(command, params, server, bridge, pumpState); handled {
+			return result, true, err
 		}
+		if handled, err := handleEmulatorCommand(command, params, server, ble, router); handled {
+			return nil, true, err
+		}
+		log.Warnf("Unhandled websocket command: %s", command)
+		return nil, false, nil
 	})
 }
+
+// handlePairingCommand handles websocket commands that change pairing state,
+// returning the resulting pairing state, or false if command isn't a
+// pairing command
+func handlePairingCommand(command string, params map[string]interface{}, server *api.Server, bridge *pumpx2.Bridge, pumpState *state.PumpState) (map[string]interface{}, bool, error) {
+	switch command {
+	case "getPairingState":
+	case "setPairingCode":
+		pairingCode, _ := params["pairingCode"].(string)
+		if pairingCode == "" {
+			return nil, true, errors.New("pairingCode missing")
+		}
+		pumpState.SetPairingCode(pairingCode)
+		pumpState.ResetAuthentication()
+		bridge.SetPairingCode(pairingCode)
+	case "resetPairing":
+		pumpState.ResetAuthentication()
+	case "setLongTermKey":
+		longTermKeyHex, _ := params["longTermKey"].(string)
+		if longTermKeyHex == "" {
+			return nil, true, errors.New("longTermKey missing")
+		}
+		longTermKey, err := api.ParseHex(longTermKeyHex)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid longTermKey: %w", err)
+		}
+		pumpState.SetLongTermKey(longTermKey)
+	case "resetLongTermKey":
+		pumpState.SetLongTermKey(nil)
+	default:
+		return nil, false, nil
+	}
+	pairingCode, longTermKey := pumpState.GetPairingCode(), pumpState.GetLongTermKey()
+	server.SendPairingState(pairingCode, pumpState.IsAuthenticated, longTermKey)
+	return map[string]interface{}{
+		"pairingCode":   pairingCode,
+		"authenticated": pumpState.IsAuthenticated,
+		"longTermKey":   hex.EncodeToString(longTermKey),
+	}, true, nil
+}
+
+// handleEmulatorCommand handles websocket commands that change the emulated
+// pump or link, returning false if command isn't recognized
+func handleEmulatorCommand(command string, params map[string]interface{}, server *api.Server, ble *bluetooth.Ble, router *handler.Router) (bool, error) {
+	switch command {
+	case "setLinkEncrypted":
+		encrypted, _ := params["encrypted"].(bool)
+		ble.SetLinkEncrypted(encrypted)
+		log.Infof("Link marked encrypted=%v", encrypted)
+	case "setBasalRate":
+		rate, ok := params["rate"].(float64)
+		if !ok {
+			return true, errors.New("rate missing")
+		}
+		if err := router.SetBasalRate(rate); err != nil {
+			return true, err
+		}
+	case "setBusy":
+		durationMs, ok := params["durationMs"].(float64)
+		if !ok {
+			return true, errors.New("durationMs missing")
+		}
+		router.SetBusy(time.Duration(durationMs) * time.Millisecond)
+	case "setHandlerEnabled":
+		messageType, _ := params["messageType"].(string)
+		enabled, ok := params["enabled"].(bool)
+		if messageType == "" || !ok {
+			return true, errors.New("messageType or enabled missing")
+		}
+		router.SetHandlerEnabled(messageType, enabled)
+	case "setTxIdOffset":
+		messageType, _ := params["messageType"].(string)
+		offset, ok := params["offset"].(float64)
+		if messageType == "" || !ok {
+			return true, errors.New("messageType or offset missing")
+		}
+		router.SetTxIDOffset(messageType, int(offset))
+	case "disconnectPump":
+		ble.ShutdownConnection()
+		server.SendPumpState()
+	default:
+		return false, nil
+	}
+	return true, nil
+}
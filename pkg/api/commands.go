@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CommandFunc runs a custom websocket command registered with
+// RegisterCommand. params is the whole command message. The result is sent
+// back to the client in an ack event; an error is sent as an error event.
+type CommandFunc func(params map[string]interface{}) (interface{}, error)
+
+// commandRegistry holds the custom websocket commands
+type commandRegistry struct {
+	commands map[string]CommandFunc
+	mtx      sync.RWMutex
+}
+
+func (c *commandRegistry) register(name string, fn CommandFunc) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.commands == nil {
+		c.commands = make(map[string]CommandFunc)
+	}
+	c.commands[name] = fn
+}
+
+func (c *commandRegistry) lookup(name string) (CommandFunc, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	fn, ok := c.commands[name]
+	return fn, ok
+}
+
+// RegisterCommand adds a custom websocket command, so emulator-specific
+// commands can be added without editing the server. Built-in commands take
+// precedence; a registered command is tried before the command handler.
+func (s *Server) RegisterCommand(name string, fn CommandFunc) {
+	s.commands.register(name, fn)
+	log.Debugf("Registered websocket command %s", name)
+}
+
+// runCommand runs a registered custom command, acking its result to the
+// client that sent it. ok is false if no command is registered under name.
+func (s *Server) runCommand(client *wsClient, name string, params map[string]interface{}) (ok bool, err error) {
+	result, ok, err := s.callCommand(name, params)
+	if !ok || err != nil {
+		return ok, err
+	}
+	client.send(BleEvent{Type: "ack", Command: name, Result: result})
+	return true, nil
+}
+
+// callCommand runs a registered custom command and returns its result. ok is
+// false if no command is registered under name.
+func (s *Server) callCommand(name string, params map[string]interface{}) (result interface{}, ok bool, err error) {
+	fn, ok := s.commands.lookup(name)
+	if !ok {
+		return nil, false, nil
+	}
+	result, err = fn(params)
+	if err != nil {
+		return nil, true, fmt.Errorf("command %s failed: %w", name, err)
+	}
+	return result, true, nil
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/settings"
+	"github.com/jwoglom/faketandem/pkg/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stateDumpVersion is the version of the state dump format
+const stateDumpVersion = 1
+
+// stateDump is a snapshot of the whole emulator for sharing a repro. Its
+// state and settings sections can be imported back; diagnostics are for
+// reading only and are ignored on import.
+type stateDump struct {
+	Version     int                                 `json:"version"`
+	Time        time.Time                           `json:"time"`
+	State       *dumpedPumpState                    `json:"state,omitempty"`
+	Settings    map[string]*settings.ResponseConfig `json:"settings,omitempty"`
+	Diagnostics map[string]interface{}              `json:"diagnostics,omitempty"`
+}
+
+// dumpedPumpState is the importable pump state of a stateDump. Basal,
+// bolus, CGM and history state is restored as-is from delivery; of live,
+// only the reservoir, battery, suspension and IOB are restored.
+type dumpedPumpState struct {
+	Identity state.PumpIdentity     `json:"identity"`
+	Pump     state.PumpConfig       `json:"pump"`
+	Therapy  state.TherapyConfig    `json:"therapy"`
+	Live     pumpStateBody          `json:"live"`
+	Delivery state.DeliverySnapshot `json:"delivery"`
+	Alerts   []state.Alert          `json:"alerts"`
+}
+
+// dumpState returns a snapshot of everything the server has been given
+func (s *Server) dumpState() stateDump {
+	dump := stateDump{
+		Version:     stateDumpVersion,
+		Time:        time.Now(),
+		Diagnostics: map[string]interface{}{"emulator": s.currentState()},
+	}
+
+	if s.pumpState != nil {
+		dump.State = &dumpedPumpState{
+			Identity: s.pumpState.GetIdentity(),
+			Pump:     s.pumpState.GetPumpConfig(),
+			Therapy:  s.pumpState.GetTherapyConfig(),
+			Live:     s.livePumpState(),
+			Delivery: s.pumpState.GetDeliverySnapshot(),
+			Alerts:   s.pumpState.GetActiveAlerts(),
+		}
+	}
+	if s.settingsManager != nil {
+		dump.Settings = s.settingsManager.GetAllConfigs()
+	}
+
+	if s.config != nil {
+		dump.Diagnostics["config"] = s.config
+	}
+	if s.reassembler != nil {
+		dump.Diagnostics["reassembler"] = map[string]interface{}{
+			"stats":   s.reassembler.GetStats(),
+			"buffers": s.reassembler.BufferDetails(),
+		}
+	}
+	if s.bridge != nil {
+		// Failing packets' hex is left out: a dump is meant to be shared
+		parseFailures := s.bridge.ParseFailureStats()
+		for i := range parseFailures.Recent {
+			parseFailures.Recent[i].Hex = nil
+		}
+		dump.Diagnostics["parseFailures"] = parseFailures
+		dump.Diagnostics["bridgeLog"] = s.bridge.RecentInvocations()
+	}
+	if s.jpakeSessions != nil {
+		dump.Diagnostics["jpakeSessions"] = s.jpakeSessions.Sessions()
+	}
+	if s.stateAudit != nil {
+		dump.Diagnostics["stateAudit"] = s.stateAudit.Entries()
+	}
+	if s.authLockout != nil {
+		dump.Diagnostics["authLockout"] = s.authLockout.Status()
+	}
+	return dump
+}
+
+// importState validates every section of dump and then applies it, so a
+// dump that fails to import leaves the emulator unchanged
+func (s *Server) importState(dump stateDump) error {
+	if dump.Version != stateDumpVersion {
+		return fmt.Errorf("unsupported state dump version %d (expected %d)", dump.Version, stateDumpVersion)
+	}
+
+	var live pumpStateUpdate
+	if dump.State != nil {
+		if s.pumpState == nil {
+			return fmt.Errorf("pump state not initialized")
+		}
+		if err := dump.State.Identity.Validate(); err != nil {
+			return fmt.Errorf("invalid identity: %w", err)
+		}
+		if err := dump.State.Pump.Validate(); err != nil {
+			return fmt.Errorf("invalid pump config: %w", err)
+		}
+		if err := dump.State.Therapy.Validate(); err != nil {
+			return fmt.Errorf("invalid therapy config: %w", err)
+		}
+		live = pumpStateUpdate{
+			Reservoir: &dump.State.Live.Reservoir,
+			Battery:   &dump.State.Live.Battery,
+			Suspended: &dump.State.Live.Suspended,
+			IOB:       &dump.State.Live.IOB,
+		}
+		if err := live.validate(dump.State.Therapy); err != nil {
+			return fmt.Errorf("invalid live state: %w", err)
+		}
+		if err := dump.State.Delivery.Validate(); err != nil {
+			return fmt.Errorf("invalid delivery state: %w", err)
+		}
+	}
+	if dump.Settings != nil {
+		if s.settingsManager == nil {
+			return fmt.Errorf("settings manager not initialized")
+		}
+		// Validate against a scratch manager so a bad config is caught
+		// before any are applied
+		scratch := settings.NewManager()
+		for messageType, config := range dump.Settings {
+			configCopy := *config
+			if err := scratch.SetConfig(messageType, &configCopy); err != nil {
+				return fmt.Errorf("invalid settings for %s: %w", messageType, err)
+			}
+		}
+	}
+
+	// Everything was validated above, so none of the setters can fail
+	if dump.State != nil {
+		_ = s.pumpState.SetIdentity(dump.State.Identity)
+		_ = s.pumpState.SetPumpConfig(dump.State.Pump)
+		_ = s.pumpState.SetTherapyConfig(dump.State.Therapy)
+		_ = s.pumpState.RestoreDeliverySnapshot(dump.State.Delivery)
+		// Restored directly rather than through the suspend handler: the
+		// suspension is already in the restored history
+		s.pumpState.SetReservoirLevel(*live.Reservoir)
+		s.pumpState.SetBatteryLevel(*live.Battery)
+		s.pumpState.SetPumpingSuspended(*live.Suspended)
+		s.pumpState.SetIOB(*live.IOB)
+		s.pumpState.SetActiveAlerts(dump.State.Alerts)
+	}
+	for messageType, config := range dump.Settings {
+		_ = s.settingsManager.SetConfig(messageType, config)
+	}
+	log.Infof("Imported state dump from %s", dump.Time.Format(time.RFC3339))
+	return nil
+}
+
+// handleStateDumpAPI returns the whole emulator as one document for a bug
+// report, or imports one back. Diagnostics aren't imported, and the live
+// IOB is restored as a single dose delivered now.
+// GET /api/state/dump
+// PUT /api/state/dump {"version": 1, "state": {...}, "settings": {...}}
+func (s *Server) handleStateDumpAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var dump stateDump
+		if err := json.NewDecoder(r.Body).Decode(&dump); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+			return
+		}
+		if err := s.importState(dump); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="faketandem-state-%s.json"`,
+			time.Now().Format("20060102-150405")))
+	}
+	if err := json.NewEncoder(w).Encode(s.dumpState()); err != nil {
+		log.Errorf("Failed to encode state dump response: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseHex decodes hex data from a user, ignoring whitespace and colons so
+// dumps pasted as "01 02 03" or "01:02:03" are accepted. The error wraps
+// errInvalidHexData and says what is wrong with the input.
+func ParseHex(s string) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ':' {
+			return -1
+		}
+		return r
+	}, s)
+
+	for i, r := range cleaned {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return nil, fmt.Errorf("%w: non-hex character %q at position %d", errInvalidHexData, r, i)
+		}
+	}
+	if len(cleaned)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd number of hex digits (%d)", errInvalidHexData, len(cleaned))
+	}
+	return hex.DecodeString(cleaned)
+}
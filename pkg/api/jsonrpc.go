@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// jsonRPCVersion is the only JSON-RPC version the websocket speaks
+const jsonRPCVersion = "2.0"
+
+// JSON-RPC 2.0 error codes. The -32000 range is for emulator errors.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcCommandFailed  = -32000 // the method ran and failed
+	rpcReadOnly       = -32001 // the method isn't allowed in read-only mode
+)
+
+var (
+	errUnknownCharacteristic = errors.New("unknown characteristic")
+	errInvalidHexData        = errors.New("invalid hex data")
+)
+
+// rpcRequest is a JSON-RPC 2.0 request. A request without an id is a
+// notification, which gets no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response, carrying either a result or an
+// error
+type rpcResponse struct {
+	ID     json.RawMessage
+	Result interface{}
+	Error  *rpcError
+}
+
+// MarshalJSON includes exactly one of result and error, as the spec requires
+func (r rpcResponse) MarshalJSON() ([]byte, error) {
+	id := r.ID
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	resp := map[string]interface{}{"jsonrpc": jsonRPCVersion, "id": id}
+	if r.Error != nil {
+		resp["error"] = r.Error
+	} else {
+		resp["result"] = r.Result
+	}
+	return json.Marshal(resp)
+}
+
+// isJSONRPC returns whether a websocket message is a JSON-RPC request or
+// batch rather than a legacy command
+func isJSONRPC(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return true
+	}
+	var probe struct {
+		JSONRPC *string `json:"jsonrpc"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.JSONRPC != nil
+}
+
+// handleJSONRPC runs a JSON-RPC request or batch and sends the response, if
+// any, to the client that sent it
+func (s *Server) handleJSONRPC(client *wsClient, data []byte) {
+	if resp, ok := s.jsonRPCResponse(data); ok {
+		client.send(resp)
+	}
+}
+
+// jsonRPCResponse runs a JSON-RPC request or batch and returns what to send
+// back: a response, a slice of responses for a batch, or ok false when only
+// notifications were sent
+func (s *Server) jsonRPCResponse(data []byte) (interface{}, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return rpcResponse{Error: &rpcError{Code: rpcParseError, Message: err.Error()}}, true
+		}
+		if len(batch) == 0 {
+			return rpcResponse{Error: &rpcError{Code: rpcInvalidRequest, Message: "empty batch"}}, true
+		}
+		var responses []rpcResponse
+		for _, raw := range batch {
+			if resp, ok := s.runJSONRPC(raw); ok {
+				responses = append(responses, resp)
+			}
+		}
+		return responses, len(responses) > 0
+	}
+	return s.runJSONRPC(data)
+}
+
+// runJSONRPC runs one JSON-RPC request, returning ok false for a notification
+func (s *Server) runJSONRPC(data []byte) (rpcResponse, bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return rpcResponse{Error: &rpcError{Code: rpcParseError, Message: err.Error()}}, true
+	}
+	if req.JSONRPC != jsonRPCVersion || req.Method == "" {
+		return rpcResponse{ID: req.ID, Error: &rpcError{
+			Code: rpcInvalidRequest, Message: `expected "jsonrpc": "2.0" and a method`,
+		}}, true
+	}
+
+	// Methods take the same named params as the legacy command of the same
+	// name takes alongside "command"
+	params := map[string]interface{}{}
+	if len(req.Params) > 0 && !bytes.Equal(req.Params, []byte("null")) {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return rpcResponse{ID: req.ID, Error: &rpcError{
+				Code: rpcInvalidParams, Message: "params must be an object",
+			}}, req.ID != nil
+		}
+	}
+
+	result, rpcErr := s.callMethod(req.Method, params)
+	if rpcErr != nil {
+		log.Warnf("JSON-RPC %s failed: %s", req.Method, rpcErr.Message)
+	}
+	if req.ID == nil {
+		return rpcResponse{}, false
+	}
+	return rpcResponse{ID: req.ID, Result: result, Error: rpcErr}, true
+}
+
+// callMethod runs a websocket command as a JSON-RPC method, returning its
+// result rather than sending it as an event
+func (s *Server) callMethod(method string, params map[string]interface{}) (interface{}, *rpcError) {
+	if s.readOnly && !readOnlyCommands[method] {
+		return nil, &rpcError{Code: rpcReadOnly, Message: fmt.Sprintf("method %s rejected: API is read-only", method)}
+	}
+
+	var err error
+	switch method {
+	case "getState":
+		return s.currentState(), nil
+	case "dumpState":
+		return s.dumpState(), nil
+	case "notify":
+		charName, _ := params["characteristic"].(string)
+		dataHex, _ := params["data"].(string)
+		err = s.handleNotifyCommand(charName, dataHex)
+	case "setCharacteristic":
+		charName, _ := params["characteristic"].(string)
+		dataHex, _ := params["data"].(string)
+		err = s.handleSetCharacteristicCommand(charName, dataHex)
+	default:
+		result, ok, cmdErr := s.callCommand(method, params)
+		if !ok && s.commandHandler != nil {
+			result, ok, cmdErr = s.commandHandler(method, params)
+		}
+		switch {
+		case ok && cmdErr != nil:
+			return nil, &rpcError{Code: rpcCommandFailed, Message: cmdErr.Error()}
+		case ok:
+			return result, nil
+		default:
+			return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method not found: %s", method)}
+		}
+	}
+
+	if errors.Is(err, errUnknownCharacteristic) || errors.Is(err, errInvalidHexData) {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	} else if err != nil {
+		return nil, &rpcError{Code: rpcCommandFailed, Message: err.Error()}
+	}
+	return nil, nil
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Re-pair flow states reported by GET /api/repair
+const (
+	RepairIdle     = "idle"
+	RepairWaiting  = "waitingForPairing"
+	RepairComplete = "complete"
+	RepairFailed   = "failed"
+)
+
+// Re-pair flow defaults: how long to wait for the client's new JPAKE
+// exchange, and how often to check for it
+const (
+	DefaultRepairTimeout = 5 * time.Minute
+	repairPollInterval   = 250 * time.Millisecond
+)
+
+// errRepairInProgress is returned when a re-pair is started while another
+// is still waiting for the client
+var errRepairInProgress = errors.New("re-pair already in progress")
+
+// RepairStatus reports the progress of the forget and re-pair flow
+type RepairStatus struct {
+	State      string     `json:"state"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// SessionID is the JPAKE session the client re-paired with
+	SessionID string `json:"sessionId,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Completed counts successful re-pairs, for looping stress tests
+	Completed int `json:"completed"`
+}
+
+// pairingTransport is the part of the BLE link the re-pair flow drives
+type pairingTransport interface {
+	ShutdownConnection()
+	SetLinkEncrypted(encrypted bool)
+	SetPairingState(state bluetooth.PairingState) error
+}
+
+// repairFlow holds the state of the forget and re-pair flow
+type repairFlow struct {
+	mutex        sync.Mutex
+	status       RepairStatus
+	transport    pairingTransport // the BLE link if nil
+	pollInterval time.Duration
+}
+
+// repairRequest is the optional POST /api/repair body
+type repairRequest struct {
+	PairingState   bluetooth.PairingState `json:"pairingState"`
+	TimeoutSeconds float64                `json:"timeoutSeconds"`
+}
+
+// handleRepairAPI starts the forget and re-pair flow, or reports its status
+// GET  /api/repair
+// POST /api/repair {"pairingState": "PairStep1", "timeoutSeconds": 300}
+func (s *Server) handleRepairAPI(w http.ResponseWriter, r *http.Request) {
+	if s.pumpState == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Pump state not initialized")
+		return
+	}
+	if s.repair.transport == nil && s.ble == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Bluetooth not initialized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		req := repairRequest{PairingState: bluetooth.PairingStatePairStep1}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+				return
+			}
+		}
+		if req.PairingState != bluetooth.PairingStatePairStep1 && req.PairingState != bluetooth.PairingStatePairStep2 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid pairing state: %v. Valid states: PairStep1, PairStep2", req.PairingState))
+			return
+		}
+		timeout := DefaultRepairTimeout
+		if req.TimeoutSeconds > 0 {
+			timeout = time.Duration(req.TimeoutSeconds * float64(time.Second))
+		}
+
+		if err := s.startRepair(req.PairingState, timeout); errors.Is(err, errRepairInProgress) {
+			writeJSONError(w, http.StatusConflict, "A re-pair is already waiting for the client to pair")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start re-pair: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(s.RepairStatus()); err != nil {
+			log.Errorf("Failed to encode repair response: %v", err)
+		}
+		return
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.RepairStatus()); err != nil {
+		log.Errorf("Failed to encode repair status: %v", err)
+	}
+}
+
+// RepairStatus returns the progress of the forget and re-pair flow
+func (s *Server) RepairStatus() RepairStatus {
+	s.repair.mutex.Lock()
+	defer s.repair.mutex.Unlock()
+	status := s.repair.status
+	if status.State == "" {
+		status.State = RepairIdle
+	}
+	return status
+}
+
+// startRepair does what a client forgetting the pump leaves behind: it
+// drops the connection and bond, clears authentication and the cached
+// long-term key so the client can't quick-pair, and advertises in
+// pairingState. A goroutine then waits up to timeout for a new full JPAKE
+// exchange.
+func (s *Server) startRepair(pairingState bluetooth.PairingState, timeout time.Duration) error {
+	var transport pairingTransport = s.ble
+	if s.repair.transport != nil {
+		transport = s.repair.transport
+	}
+
+	s.repair.mutex.Lock()
+	defer s.repair.mutex.Unlock()
+	if s.repair.status.State == RepairWaiting {
+		return errRepairInProgress
+	}
+
+	log.Info("Re-pair: forgetting the paired client")
+	transport.ShutdownConnection()
+	transport.SetLinkEncrypted(false)
+	s.pumpState.ResetAuthentication()
+	s.pumpState.SetLongTermKey(nil)
+	if s.jpakeSessions != nil {
+		s.jpakeSessions.Forget()
+	}
+	s.SendPairingState(s.pumpState.GetPairingCode(), false, nil)
+
+	started := time.Now()
+	s.repair.status = RepairStatus{
+		State:     RepairWaiting,
+		StartedAt: &started,
+		Completed: s.repair.status.Completed,
+	}
+	if err := transport.SetPairingState(pairingState); err != nil {
+		err = fmt.Errorf("failed to set pairing state: %w", err)
+		s.finishRepair(err, "")
+		return err
+	}
+	log.Infof("Re-pair: advertising in %s, waiting up to %s for the client to pair", pairingState, timeout)
+
+	pollInterval := s.repair.pollInterval
+	if pollInterval <= 0 {
+		pollInterval = repairPollInterval
+	}
+	go s.waitForRepair(started.Add(timeout), pollInterval)
+	return nil
+}
+
+// waitForRepair polls until the client completes a full JPAKE exchange and
+// is authenticated, or the deadline passes
+func (s *Server) waitForRepair(deadline time.Time, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if sessionID, ok := s.repairedSession(); ok {
+			s.repair.mutex.Lock()
+			s.finishRepair(nil, sessionID)
+			s.repair.mutex.Unlock()
+			s.SendPairingState(s.pumpState.GetPairingCode(), true, s.pumpState.GetLongTermKey())
+			return
+		}
+		if time.Now().After(deadline) {
+			s.repair.mutex.Lock()
+			s.finishRepair(fmt.Errorf("timed out waiting for the client to pair"), "")
+			s.repair.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// repairedSession returns the JPAKE session the client re-paired with once
+// it is authenticated again
+func (s *Server) repairedSession() (string, bool) {
+	s.pumpState.RLock()
+	authenticated := s.pumpState.IsAuthenticated
+	s.pumpState.RUnlock()
+	if !authenticated {
+		return "", false
+	}
+	if s.jpakeSessions == nil {
+		return "", true
+	}
+	return s.jpakeSessions.CompletedFullPairing()
+}
+
+// finishRepair records the flow's outcome (must hold repair.mutex)
+func (s *Server) finishRepair(err error, sessionID string) {
+	finished := time.Now()
+	s.repair.status.FinishedAt = &finished
+	if err != nil {
+		s.repair.status.State = RepairFailed
+		s.repair.status.Error = err.Error()
+		log.Warnf("Re-pair failed: %v", err)
+		return
+	}
+	s.repair.status.State = RepairComplete
+	s.repair.status.SessionID = sessionID
+	s.repair.status.Completed++
+	log.Infof("Re-pair complete: client paired with JPAKE session %s", sessionID)
+}
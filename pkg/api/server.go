@@ -2,8 +2,10 @@
 package api
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,7 +13,12 @@ import (
 	"sync"
 
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/config"
+	"github.com/jwoglom/faketandem/pkg/handler"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
 	"github.com/jwoglom/faketandem/pkg/settings"
+	"github.com/jwoglom/faketandem/pkg/state"
 
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
@@ -22,21 +29,57 @@ type Server struct {
 	http.Handler
 
 	ble             *bluetooth.Ble
-	conn            *websocket.Conn
+	clients         map[*wsClient]bool // connected websocket clients, guarded by mtx
 	mtx             sync.Mutex
 	settingsManager *settings.Manager
+	pumpState       *state.PumpState
+	bridge          *pumpx2.Bridge
+	config          *config.Config
+	reassembler     *protocol.Reassembler
+	jpakeSessions   *handler.JPAKESessionManager
+	stateAudit      *handler.StateAuditLog
+	qeNotifier      *handler.QualifyingEventsNotifier
+	authLockout     *handler.AuthLockout
+	timeSeries      *state.TimeSeries
+	readOnly        bool
+	listenAddr      string
+	httpServer      *http.Server // created on first Start or Shutdown, guarded by mtx
 
 	// Callback for when a command is received from the websocket
 	commandHandler CommandHandler
+
+	// Custom websocket commands added with RegisterCommand
+	commands commandRegistry
+
+	// Callback for changing the profile basal rate
+	basalRateHandler BasalRateHandler
+
+	// Callback for suspending or resuming pumping
+	suspendHandler SuspendHandler
+
+	// Forget and re-pair flow started with POST /api/repair
+	repair repairFlow
 }
 
-// CommandHandler is called when a command is received via websocket
-type CommandHandler func(command string, params map[string]interface{})
+// BasalRateHandler changes the simulated profile basal rate (units/hr)
+type BasalRateHandler func(rate float64) error
+
+// SuspendHandler suspends or resumes pumping
+type SuspendHandler func(suspended bool) error
+
+// CommandHandler is called when a command is received via websocket. It
+// returns the command's result, false if it doesn't know the command, and
+// an error if the command failed.
+type CommandHandler func(command string, params map[string]interface{}) (result interface{}, handled bool, err error)
 
 // PumpState represents the current state of the pump emulator
 type PumpState struct {
 	Connected       bool              `json:"connected"`
 	Characteristics map[string]string `json:"characteristics"`
+
+	// MinutesUntilEmpty is the reservoir's projected time to empty at the
+	// current delivery rate, omitted when nothing is being delivered
+	MinutesUntilEmpty *int `json:"minutes_until_empty,omitempty"`
 }
 
 // BleEvent represents a BLE event sent to websocket clients
@@ -48,51 +91,236 @@ type BleEvent struct {
 	PairingCode    string `json:"pairing_code,omitempty"`
 	Authenticated  *bool  `json:"authenticated,omitempty"`
 	LongTermKey    string `json:"long_term_key,omitempty"`
+
+	// Fields holds the changed pump state fields of a stateChange event
+	Fields map[string]interface{} `json:"fields,omitempty"`
+
+	// MessageType and Reason describe the message of a rejected event and
+	// why the router refused it
+	MessageType string `json:"message_type,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+
+	// Command and Result are the custom command and its result in an ack
+	// event
+	Command string      `json:"command,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
 }
 
+// DefaultListenAddr is the address the API listens on unless configured
+// otherwise
+const DefaultListenAddr = ":8080"
+
 // New creates a new API server
 func New(ble *bluetooth.Ble) *Server {
 	return &Server{
-		ble: ble,
+		ble:        ble,
+		listenAddr: DefaultListenAddr,
 	}
 }
 
+// SetListenAddr sets the address Start listens on, so several emulators can
+// run on one host
+func (s *Server) SetListenAddr(addr string) {
+	s.listenAddr = addr
+}
+
 // SetSettingsManager sets the settings manager for this server
 func (s *Server) SetSettingsManager(manager *settings.Manager) {
 	s.settingsManager = manager
 }
 
+// SetPumpState sets the pump state exposed by the globals API
+func (s *Server) SetPumpState(pumpState *state.PumpState) {
+	s.pumpState = pumpState
+}
+
+// SetBridge sets the pumpX2 bridge used by the parse API
+func (s *Server) SetBridge(bridge *pumpx2.Bridge) {
+	s.bridge = bridge
+}
+
+// SetConfig sets the effective configuration exposed by the config API
+func (s *Server) SetConfig(cfg *config.Config) {
+	s.config = cfg
+}
+
+// SetReassembler sets the reassembler exposed by the reassembler API
+func (s *Server) SetReassembler(reassembler *protocol.Reassembler) {
+	s.reassembler = reassembler
+}
+
+// SetJPAKESessionManager sets the JPAKE session manager exposed by the JPAKE
+// API
+func (s *Server) SetJPAKESessionManager(manager *handler.JPAKESessionManager) {
+	s.jpakeSessions = manager
+}
+
+// SetStateAuditLog sets the state change audit log exposed by the state
+// audit API
+func (s *Server) SetStateAuditLog(audit *handler.StateAuditLog) {
+	s.stateAudit = audit
+}
+
+// SetTimeSeries sets the state field history exposed by the time series API
+func (s *Server) SetTimeSeries(ts *state.TimeSeries) {
+	s.timeSeries = ts
+}
+
+// SetQualifyingEventsNotifier sets the notifier POST /api/events sends
+// injected qualifying events with
+func (s *Server) SetQualifyingEventsNotifier(notifier *handler.QualifyingEventsNotifier) {
+	s.qeNotifier = notifier
+}
+
+// SetAuthLockout sets the failed authentication lockout exposed by the auth
+// lockout API
+func (s *Server) SetAuthLockout(lockout *handler.AuthLockout) {
+	s.authLockout = lockout
+}
+
+// SetReadOnly puts the server in observer mode: GET endpoints and event
+// streams keep working, but mutating endpoints and commands are rejected
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// SetBasalRateHandler sets the callback used by the basal rate API
+func (s *Server) SetBasalRateHandler(handler BasalRateHandler) {
+	s.basalRateHandler = handler
+}
+
+// SetSuspendHandler sets the callback the pump state API suspends or
+// resumes pumping with
+func (s *Server) SetSuspendHandler(handler SuspendHandler) {
+	s.suspendHandler = handler
+}
+
 // SetCommandHandler sets the callback for when commands are received
 func (s *Server) SetCommandHandler(handler CommandHandler) {
 	s.commandHandler = handler
 }
 
-// Start starts the HTTP/WebSocket server
-func (s *Server) Start() {
-	fmt.Println("Pump emulator web API listening on :8080")
-	s.setupRoutes()
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("HTTP server failed: %v", err)
+// Start serves the HTTP/WebSocket API on the listen address, returning the
+// error that stops it, or nil once Shutdown is called
+func (s *Server) Start() error {
+	fmt.Printf("Pump emulator web API listening on %s\n", s.listenAddr)
+	if err := s.server().ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the API gracefully: it stops accepting connections, closes
+// websocket clients and waits for in-flight requests until ctx is done. A
+// server shut down before Start never starts listening.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mtx.Lock()
+	clients := make([]*wsClient, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mtx.Unlock()
+
+	// Websocket connections are hijacked, so http.Server.Shutdown doesn't
+	// track them
+	for _, client := range clients {
+		s.removeClient(client)
+	}
+	return s.server().Shutdown(ctx)
+}
+
+// server returns the http.Server Start and Shutdown share
+func (s *Server) server() *http.Server {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.httpServer == nil {
+		s.httpServer = &http.Server{Addr: s.listenAddr, Handler: s.routes()}
+	}
+	return s.httpServer
+}
+
+// wsClient is a connected websocket client. Writes are serialized per
+// connection, since a websocket.Conn allows only one concurrent writer.
+type wsClient struct {
+	conn     *websocket.Conn
+	writeMtx sync.Mutex
+}
+
+// write sends data as a text message to the client
+func (c *wsClient) write(data []byte) error {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// send sends v as JSON to the client
+func (c *wsClient) send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Failed to marshal websocket message: %v", err)
+		return
+	}
+	if err := c.write(data); err != nil {
+		log.Errorf("Failed to send websocket message: %v", err)
 	}
 }
 
 // SendEvent sends a BLE event to connected websocket clients
 func (s *Server) SendEvent(event BleEvent) {
+	s.sendMessage(event)
+}
+
+// sendMessage sends v as JSON to every connected websocket client, dropping
+// any client the write fails for
+func (s *Server) sendMessage(v interface{}) {
 	s.mtx.Lock()
-	defer s.mtx.Unlock()
+	clients := make([]*wsClient, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mtx.Unlock()
 
-	if s.conn == nil {
+	if len(clients) == 0 {
 		return
 	}
 
-	data, err := json.Marshal(event)
+	data, err := json.Marshal(v)
 	if err != nil {
-		log.Errorf("Failed to marshal event: %v", err)
+		log.Errorf("Failed to marshal websocket message: %v", err)
 		return
 	}
 
-	if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		log.Errorf("Failed to send websocket message: %v", err)
+	for _, client := range clients {
+		if err := client.write(data); err != nil {
+			log.Errorf("Failed to send websocket message, dropping client: %v", err)
+			s.removeClient(client)
+		}
+	}
+}
+
+// addClient registers a newly connected websocket client
+func (s *Server) addClient(client *wsClient) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.clients == nil {
+		s.clients = make(map[*wsClient]bool)
+	}
+	s.clients[client] = true
+}
+
+// removeClient unregisters client and closes its connection, if it's still
+// registered
+func (s *Server) removeClient(client *wsClient) {
+	s.mtx.Lock()
+	registered := s.clients[client]
+	delete(s.clients, client)
+	s.mtx.Unlock()
+
+	if !registered {
+		return
+	}
+	if err := client.conn.Close(); err != nil {
+		log.Debugf("Error closing websocket: %v", err)
 	}
 }
 
@@ -146,26 +374,67 @@ func (s *Server) SendPairingState(pairingCode string, authenticated bool, longTe
 	})
 }
 
+// SendStateChange sends the pump state fields that changed, independent of
+// any BLE traffic
+func (s *Server) SendStateChange(fields map[string]interface{}) {
+	s.SendEvent(BleEvent{
+		Type:   "stateChange",
+		Fields: fields,
+	})
+}
+
+// SendRejected sends the type of a message the router rejected and the
+// reason it was rejected
+func (s *Server) SendRejected(messageType, reason string) {
+	s.SendEvent(BleEvent{
+		Type:        "rejected",
+		MessageType: messageType,
+		Reason:      reason,
+	})
+}
+
 // SendPumpState sends the latest pump connection status to websocket clients
 func (s *Server) SendPumpState() {
 	s.sendState()
 }
 
-func (s *Server) setupRoutes() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+// routes returns the server's own mux, so several servers in one process
+// don't collide registering routes on http.DefaultServeMux
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if _, err := fmt.Fprintf(w, "Pump Emulator API - Connect via WebSocket at /ws\n\nSettings API:\n  GET    /api/settings\n  GET    /api/settings/{messageType}\n  PUT    /api/settings/{messageType}\n  POST   /api/settings/{messageType}/reset\n\nBluetooth Pairing API:\n  GET    /api/bluetooth/pairingstate\n  POST   /api/bluetooth/pairingstate\n  States: NotDiscoverable, DiscoverableOnly, PairStep1, PairStep2"); err != nil {
 			log.Warnf("Failed to write response: %v", err)
 		}
 	})
 	uiHandler := http.FileServer(http.Dir("ui"))
-	http.Handle("/ui/", http.StripPrefix("/ui/", uiHandler))
-	http.HandleFunc("/ui", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/ui/", http.StripPrefix("/ui/", uiHandler))
+	mux.HandleFunc("/ui", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
 	})
-	http.Handle("/ws", s)
-	http.HandleFunc("/api/settings", s.handleSettingsAPI)
-	http.HandleFunc("/api/settings/", s.handleSettingsAPI)
-	http.HandleFunc("/api/bluetooth/pairingstate", s.handlePairingStateAPI)
+	mux.Handle("/ws", s)
+	mux.HandleFunc("/api/settings", s.rejectWritesIfReadOnly(s.handleSettingsAPI))
+	mux.HandleFunc("/api/settings/", s.rejectWritesIfReadOnly(s.handleSettingsAPI))
+	mux.HandleFunc("/api/bluetooth/pairingstate", s.rejectWritesIfReadOnly(s.handlePairingStateAPI))
+	mux.HandleFunc("/api/hexdump", s.handleHexdumpAPI)
+	mux.HandleFunc("/api/basalrate", s.rejectWritesIfReadOnly(s.handleBasalRateAPI))
+	mux.HandleFunc("/api/globals", s.rejectWritesIfReadOnly(s.handleGlobalsAPI))
+	mux.HandleFunc("/api/units", s.rejectWritesIfReadOnly(s.handleUnitsAPI))
+	mux.HandleFunc("/api/pumpstate", s.rejectWritesIfReadOnly(s.handlePumpStateAPI))
+	mux.HandleFunc("/api/parse", s.handleParseAPI)
+	mux.HandleFunc("/api/parse/failures", s.handleParseFailuresAPI)
+	mux.HandleFunc("/api/bridge/log", s.handleBridgeLogAPI)
+	mux.HandleFunc("/api/config", s.handleConfigAPI)
+	mux.HandleFunc("/api/reassembler", s.handleReassemblerAPI)
+	mux.HandleFunc("/api/reassembler/reset", s.rejectWritesIfReadOnly(s.handleReassemblerResetAPI))
+	mux.HandleFunc("/api/jpake", s.handleJPAKEAPI)
+	mux.HandleFunc("/api/repair", s.rejectWritesIfReadOnly(s.handleRepairAPI))
+	mux.HandleFunc("/api/events/", s.rejectWritesIfReadOnly(s.handleEventsAPI))
+	mux.HandleFunc("/api/state/audit", s.handleStateAuditAPI)
+	mux.HandleFunc("/api/state/dump", s.rejectWritesIfReadOnly(s.handleStateDumpAPI))
+	mux.HandleFunc("/api/timeseries", s.handleTimeSeriesAPI)
+	mux.HandleFunc("/api/auth/lockout", s.rejectWritesIfReadOnly(s.handleAuthLockoutAPI))
+	return mux
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -177,155 +446,179 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.mtx.Lock()
-	s.conn = ws
-	s.mtx.Unlock()
+	client := &wsClient{conn: ws}
+	s.addClient(client)
 
 	// Send initial state
-	s.sendState()
+	client.send(s.currentState())
 
 	// Listen for messages
-	s.reader(ws)
+	s.reader(client)
 }
 
 func (s *Server) sendState() {
+	s.sendMessage(s.currentState())
+}
+
+// currentState returns the emulator state sent to websocket clients
+func (s *Server) currentState() PumpState {
 	state := PumpState{
 		Connected:       s.ble.IsConnected(),
 		Characteristics: make(map[string]string),
 	}
-
-	data, err := json.Marshal(state)
-	if err != nil {
-		log.Errorf("Failed to marshal state: %v", err)
-		return
+	if s.pumpState != nil {
+		if minutes, ok := s.pumpState.MinutesUntilEmpty(); ok {
+			state.MinutesUntilEmpty = &minutes
+		}
 	}
+	return state
+}
 
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
+// readOnlyCommands are the websocket commands allowed in read-only mode
+var readOnlyCommands = map[string]bool{
+	"getState":        true,
+	"getPairingState": true,
+	"dumpState":       true,
+}
 
-	if s.conn != nil {
-		if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Errorf("Failed to send state: %v", err)
+// rejectWritesIfReadOnly wraps a REST handler so that in read-only mode
+// anything but GET/HEAD is refused with 403
+func (s *Server) rejectWritesIfReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusForbidden, "API is read-only")
+			return
 		}
+		next(w, r)
 	}
 }
 
-func (s *Server) reader(conn *websocket.Conn) {
-	defer func() {
-		s.mtx.Lock()
-		s.conn = nil
-		s.mtx.Unlock()
-		if err := conn.Close(); err != nil {
-			log.Debugf("Error closing websocket: %v", err)
-		}
-	}()
+// reader handles messages from client until it disconnects, then removes it
+func (s *Server) reader(client *wsClient) {
+	defer s.removeClient(client)
 
 	for {
-		_, p, err := conn.ReadMessage()
+		_, p, err := client.conn.ReadMessage()
 		if err != nil {
 			log.Infof("WebSocket read error: %v", err)
 			return
 		}
 		log.Debugf("Received WebSocket message: %s", string(p))
-		s.handleCommand(p)
+		if isJSONRPC(p) {
+			s.handleJSONRPC(client, p)
+			continue
+		}
+		if err := s.handleCommand(client, p); err != nil {
+			log.Errorf("WebSocket command failed: %v", err)
+			client.send(BleEvent{Type: "error", Message: err.Error()})
+		}
 	}
 }
 
-func (s *Server) handleCommand(data []byte) {
+// handleCommand dispatches a legacy websocket command from client, returning
+// an error if it couldn't be parsed, isn't allowed or failed. Acks, like the
+// error the reader reports, go to client alone.
+func (s *Server) handleCommand(client *wsClient, data []byte) error {
 	var msg map[string]interface{}
 	if err := json.Unmarshal(data, &msg); err != nil {
-		log.Errorf("Failed to parse command: %v", err)
-		return
+		return fmt.Errorf("failed to parse command: %w", err)
 	}
 
 	command, ok := msg["command"].(string)
 	if !ok {
-		log.Error("Command field missing or not a string")
-		return
+		return fmt.Errorf("command field missing or not a string")
+	}
+	if s.readOnly && !readOnlyCommands[command] {
+		return fmt.Errorf("command %s rejected: API is read-only", command)
 	}
 
 	// Handle built-in commands
 	switch command {
 	case "getState":
 		s.sendState()
-		return
+		return nil
+	case "dumpState":
+		client.send(BleEvent{Type: "ack", Command: command, Result: s.dumpState()})
+		return nil
 	case "notify":
 		// Send a notification on a characteristic
 		charName, _ := msg["characteristic"].(string)
 		dataHex, _ := msg["data"].(string)
-		s.handleNotifyCommand(charName, dataHex)
-		return
+		return s.handleNotifyCommand(charName, dataHex)
 	case "setCharacteristic":
 		// Set data for a characteristic (for reads)
 		charName, _ := msg["characteristic"].(string)
 		dataHex, _ := msg["data"].(string)
-		s.handleSetCharacteristicCommand(charName, dataHex)
-		return
+		return s.handleSetCharacteristicCommand(charName, dataHex)
 	}
 
-	// Pass to custom handler
+	// Run a registered custom command, or pass to the command handler
+	if ok, err := s.runCommand(client, command, msg); ok {
+		return err
+	}
 	if s.commandHandler != nil {
-		s.commandHandler(command, msg)
+		if _, handled, err := s.commandHandler(command, msg); handled && err != nil {
+			return fmt.Errorf("command %s failed: %w", command, err)
+		}
 	}
+	return nil
 }
 
-func (s *Server) handleNotifyCommand(charName string, dataHex string) {
+func (s *Server) handleNotifyCommand(charName string, dataHex string) error {
 	charType := s.parseCharacteristicName(charName)
 	if charType < 0 {
-		log.Errorf("Unknown characteristic: %s", charName)
-		return
+		return fmt.Errorf("%w: %q", errUnknownCharacteristic, charName)
 	}
 
-	data, err := hex.DecodeString(dataHex)
+	data, err := ParseHex(dataHex)
 	if err != nil {
-		log.Errorf("Invalid hex data: %v", err)
-		return
+		return err
 	}
 
 	if err := s.ble.Notify(charType, data); err != nil {
-		log.Errorf("Failed to send notification: %v", err)
+		return fmt.Errorf("failed to send notification: %w", err)
 	}
+	return nil
 }
 
-func (s *Server) handleSetCharacteristicCommand(charName string, dataHex string) {
+func (s *Server) handleSetCharacteristicCommand(charName string, dataHex string) error {
 	charType := s.parseCharacteristicName(charName)
 	if charType < 0 {
-		log.Errorf("Unknown characteristic: %s", charName)
-		return
+		return fmt.Errorf("%w: %q", errUnknownCharacteristic, charName)
 	}
 
-	data, err := hex.DecodeString(dataHex)
+	data, err := ParseHex(dataHex)
 	if err != nil {
-		log.Errorf("Invalid hex data: %v", err)
-		return
+		return err
 	}
 
 	s.ble.SetCharacteristicData(charType, data)
+	return nil
 }
 
 func (s *Server) parseCharacteristicName(name string) bluetooth.CharacteristicType {
-	switch name {
-	case "CurrentStatus":
-		return bluetooth.CharCurrentStatus
-	case "QualifyingEvents":
-		return bluetooth.CharQualifyingEvents
-	case "HistoryLog":
-		return bluetooth.CharHistoryLog
-	case "Authorization":
-		return bluetooth.CharAuthorization
-	case "Control":
-		return bluetooth.CharControl
-	case "ControlStream":
-		return bluetooth.CharControlStream
-	default:
+	charType, ok := bluetooth.ParseCharacteristicName(name)
+	if !ok {
 		return -1
 	}
+	return charType
+}
+
+// writeJSONError writes {"error": message} with the given status code, so
+// API clients can parse failures the same way as successes
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": message}); err != nil {
+		log.Errorf("Failed to encode error response: %v", err)
+	}
 }
 
 // handleSettingsAPI handles the RESTful settings API
 func (s *Server) handleSettingsAPI(w http.ResponseWriter, r *http.Request) {
 	if s.settingsManager == nil {
-		http.Error(w, "Settings manager not initialized", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Settings manager not initialized")
 		return
 	}
 
@@ -355,11 +648,11 @@ func (s *Server) handleSettingsAPI(w http.ResponseWriter, r *http.Request) {
 			messageType := strings.TrimSuffix(path, "/reset")
 			s.handleResetSetting(w, r, messageType)
 		} else {
-			http.Error(w, "Invalid POST endpoint", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "Invalid POST endpoint")
 		}
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -370,7 +663,7 @@ func (s *Server) handleGetAllSettings(w http.ResponseWriter, _ *http.Request) {
 
 	if err := json.NewEncoder(w).Encode(configs); err != nil {
 		log.Errorf("Failed to encode settings: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to encode response")
 	}
 }
 
@@ -379,27 +672,27 @@ func (s *Server) handleGetAllSettings(w http.ResponseWriter, _ *http.Request) {
 func (s *Server) handleGetSetting(w http.ResponseWriter, _ *http.Request, messageType string) {
 	config, err := s.settingsManager.GetConfig(messageType)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Configuration not found: %s", err), http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Configuration not found: %s", err))
 		return
 	}
 
 	if err := json.NewEncoder(w).Encode(config); err != nil {
 		log.Errorf("Failed to encode setting: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to encode response")
 	}
 }
 
 // handleUpdateSetting updates a settings configuration
 func (s *Server) handleUpdateSetting(w http.ResponseWriter, r *http.Request, messageType string) {
 	if messageType == "" {
-		http.Error(w, "Message type is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Message type is required")
 		return
 	}
 
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
 		return
 	}
 	defer func() {
@@ -411,13 +704,13 @@ func (s *Server) handleUpdateSetting(w http.ResponseWriter, r *http.Request, mes
 	// Parse the configuration
 	var config settings.ResponseConfig
 	if err := json.Unmarshal(body, &config); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse configuration: %v", err), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse configuration: %v", err))
 		return
 	}
 
 	// Update the configuration
 	if err := s.settingsManager.SetConfig(messageType, &config); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to set configuration: %v", err), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to set configuration: %v", err))
 		return
 	}
 
@@ -435,12 +728,12 @@ func (s *Server) handleUpdateSetting(w http.ResponseWriter, r *http.Request, mes
 //nolint:unparam // r is required by http.HandlerFunc interface
 func (s *Server) handleResetSetting(w http.ResponseWriter, _ *http.Request, messageType string) {
 	if messageType == "" {
-		http.Error(w, "Message type is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Message type is required")
 		return
 	}
 
 	if err := s.settingsManager.ResetState(messageType); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to reset state: %v", err), http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Failed to reset state: %v", err))
 		return
 	}
 
@@ -465,14 +758,14 @@ func (s *Server) handlePairingStateAPI(w http.ResponseWriter, r *http.Request) {
 			"pairingState": state,
 		}); err != nil {
 			log.Errorf("Failed to encode pairing state: %v", err)
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to encode response")
 		}
 
 	case http.MethodPost:
 		// POST /api/bluetooth/pairingstate - set pairing state
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
 			return
 		}
 		defer func() {
@@ -485,7 +778,7 @@ func (s *Server) handlePairingStateAPI(w http.ResponseWriter, r *http.Request) {
 			PairingState bluetooth.PairingState `json:"pairingState"`
 		}
 		if err := json.Unmarshal(body, &req); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to parse request: %v", err), http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse request: %v", err))
 			return
 		}
 
@@ -497,12 +790,12 @@ func (s *Server) handlePairingStateAPI(w http.ResponseWriter, r *http.Request) {
 			bluetooth.PairingStatePairStep2:        true,
 		}
 		if !validStates[req.PairingState] {
-			http.Error(w, fmt.Sprintf("Invalid pairing state: %v. Valid states: NotDiscoverable, DiscoverableOnly, PairStep1, PairStep2", req.PairingState), http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid pairing state: %v. Valid states: NotDiscoverable, DiscoverableOnly, PairStep1, PairStep2", req.PairingState))
 			return
 		}
 
 		if err := s.ble.SetPairingState(req.PairingState); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to set pairing state: %v", err), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to set pairing state: %v", err))
 			return
 		}
 
@@ -516,7 +809,7 @@ func (s *Server) handlePairingStateAPI(w http.ResponseWriter, r *http.Request) {
 		}
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -525,3 +818,599 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
+
+// handleHexdumpAPI renders a hex string as an offset/hex/ASCII dump
+// GET /api/hexdump?hex=0102...
+func (s *Server) handleHexdumpAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	data, err := ParseHex(r.URL.Query().Get("hex"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.WriteString(w, protocol.Hexdump(data)+"\n"); err != nil {
+		log.Errorf("Failed to write hexdump response: %v", err)
+	}
+}
+
+// handleBasalRateAPI changes the profile basal rate
+// PUT /api/basalrate {"rate": 1.2}
+func (s *Server) handleBasalRateAPI(w http.ResponseWriter, r *http.Request) {
+	if s.basalRateHandler == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Basal rate handler not initialized")
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Rate *float64 `json:"rate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Rate == nil {
+		writeJSONError(w, http.StatusBadRequest, "Request body must be JSON with a numeric 'rate'")
+		return
+	}
+
+	if err := s.basalRateHandler(*req.Rate); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to set basal rate: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"rate":   *req.Rate,
+	}); err != nil {
+		log.Errorf("Failed to encode basal rate response: %v", err)
+	}
+}
+
+// globalsBody is the request/response body of the globals API
+type globalsBody struct {
+	Pump    state.PumpConfig    `json:"pump"`
+	Therapy state.TherapyConfig `json:"therapy"`
+}
+
+// handleGlobalsAPI reads or updates the pump's global preferences and
+// therapy limits. PUT bodies may omit fields to leave them unchanged.
+// GET /api/globals
+// PUT /api/globals {"pump": {"settingsLocked": true}, "therapy": {"maxIob": 10}}
+func (s *Server) handleGlobalsAPI(w http.ResponseWriter, r *http.Request) {
+	if s.pumpState == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Pump state not initialized")
+		return
+	}
+
+	body := globalsBody{
+		Pump:    s.pumpState.GetPumpConfig(),
+		Therapy: s.pumpState.GetTherapyConfig(),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		data, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(data, &body)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+			return
+		}
+		// A new region starts from its defaults, with the fields given in
+		// the body applied on top. An empty region is taken as US.
+		if body.Pump.Region == "" {
+			body.Pump.Region = state.RegionUS
+		}
+		if region := body.Pump.Region; region != s.pumpState.GetRegion() {
+			defaults, err := state.DefaultsForRegion(region)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid pump config: %v", err))
+				return
+			}
+			body.Pump = s.pumpState.GetPumpConfig()
+			body.Pump.GlucoseUnit = defaults.GlucoseUnit
+			body.Pump.Features = defaults.Features
+			body.Therapy = defaults.Therapy
+			// Already decoded once above, so this can't fail
+			_ = json.Unmarshal(data, &body)
+		}
+		if err := body.Pump.Validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid pump config: %v", err))
+			return
+		}
+		if err := body.Therapy.Validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid therapy config: %v", err))
+			return
+		}
+		// Both were validated above, so neither setter can fail
+		_ = s.pumpState.SetPumpConfig(body.Pump)
+		_ = s.pumpState.SetTherapyConfig(body.Therapy)
+		log.Infof("Updated globals: pump=%+v, therapy=%+v", body.Pump, body.Therapy)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Failed to encode globals response: %v", err)
+	}
+}
+
+// pumpStateBody is the response body of the pump state API
+type pumpStateBody struct {
+	Reservoir        float64 `json:"reservoir"`        // units
+	Battery          int     `json:"battery"`          // percent
+	ProfileBasalRate float64 `json:"profileBasalRate"` // units/hr the profile sets
+	BasalRate        float64 `json:"basalRate"`        // units/hr being delivered, including any temp rate
+	Suspended        bool    `json:"suspended"`
+	IOB              float64 `json:"iob"` // units
+}
+
+// pumpStateUpdate is the request body of the pump state API; omitted fields
+// are left unchanged. basalRate is the effective rate, which follows from
+// the profile rate, so it can't be set.
+type pumpStateUpdate struct {
+	Reservoir        *float64 `json:"reservoir"`
+	Battery          *int     `json:"battery"`
+	ProfileBasalRate *float64 `json:"profileBasalRate"`
+	BasalRate        *float64 `json:"basalRate"`
+	Suspended        *bool    `json:"suspended"`
+	IOB              *float64 `json:"iob"`
+}
+
+// validate returns an error if any field in the update is out of range
+func (u pumpStateUpdate) validate(therapy state.TherapyConfig) error {
+	if u.Reservoir != nil && *u.Reservoir < 0 {
+		return fmt.Errorf("reservoir must not be negative: %.2f", *u.Reservoir)
+	}
+	if u.Battery != nil && (*u.Battery < 0 || *u.Battery > 100) {
+		return fmt.Errorf("battery must be 0-100: %d", *u.Battery)
+	}
+	if u.BasalRate != nil {
+		return fmt.Errorf("basalRate is the effective rate and can't be set; set profileBasalRate")
+	}
+	if u.ProfileBasalRate != nil && (*u.ProfileBasalRate < 0 || *u.ProfileBasalRate > therapy.MaxBasalRate) {
+		return fmt.Errorf("profileBasalRate must be 0-%.2f: %.2f", therapy.MaxBasalRate, *u.ProfileBasalRate)
+	}
+	if u.IOB != nil && *u.IOB < 0 {
+		return fmt.Errorf("iob must not be negative: %.2f", *u.IOB)
+	}
+	return nil
+}
+
+// livePumpState returns the pump state the pump state API exposes
+func (s *Server) livePumpState() pumpStateBody {
+	return pumpStateBody{
+		Reservoir:        s.pumpState.GetReservoirLevel(),
+		Battery:          s.pumpState.GetBatteryLevel(),
+		ProfileBasalRate: s.pumpState.GetProfileBasalRate(),
+		BasalRate:        s.pumpState.GetBasalRate(),
+		Suspended:        s.pumpState.IsPumpingSuspended(),
+		IOB:              s.pumpState.GetIOB(),
+	}
+}
+
+// applyPumpStateUpdate sets the fields given in a validated update. The
+// profile basal rate and suspension go through the basal rate and suspend
+// handlers when set, so they're recorded in history and notified like the
+// same change made over BLE.
+func (s *Server) applyPumpStateUpdate(update pumpStateUpdate) error {
+	if update.Reservoir != nil {
+		s.pumpState.SetReservoirLevel(*update.Reservoir)
+	}
+	if update.Battery != nil {
+		s.pumpState.SetBatteryLevel(*update.Battery)
+	}
+	if update.ProfileBasalRate != nil {
+		if s.basalRateHandler != nil {
+			if err := s.basalRateHandler(*update.ProfileBasalRate); err != nil {
+				return fmt.Errorf("failed to set profile basal rate: %w", err)
+			}
+		} else {
+			s.pumpState.SetBasalRate(*update.ProfileBasalRate)
+		}
+	}
+	if update.Suspended != nil {
+		if s.suspendHandler != nil {
+			if err := s.suspendHandler(*update.Suspended); err != nil {
+				return fmt.Errorf("failed to set suspended: %w", err)
+			}
+		} else {
+			s.pumpState.SetPumpingSuspended(*update.Suspended)
+		}
+	}
+	if update.IOB != nil {
+		s.pumpState.SetIOB(*update.IOB)
+	}
+	log.Infof("Updated pump state: %+v", update)
+	return nil
+}
+
+// handlePumpStateAPI reads or directly sets the live pump state, so a
+// scenario can be scripted without fabricating BLE messages. Changes show up
+// in the next status response a client requests. Setting iob replaces the
+// insulin on board with a single dose delivered now.
+// GET /api/pumpstate
+// PUT /api/pumpstate {"reservoir": 50, "battery": 20, "suspended": true}
+func (s *Server) handlePumpStateAPI(w http.ResponseWriter, r *http.Request) {
+	if s.pumpState == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Pump state not initialized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var update pumpStateUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+			return
+		}
+		if err := update.validate(s.pumpState.GetTherapyConfig()); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.applyPumpStateUpdate(update); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.livePumpState()); err != nil {
+		log.Errorf("Failed to encode pump state response: %v", err)
+	}
+}
+
+// handleUnitsAPI reads or sets the glucose units preference
+// GET /api/units
+// PUT /api/units {"unit": "mmol/L"}
+func (s *Server) handleUnitsAPI(w http.ResponseWriter, r *http.Request) {
+	if s.pumpState == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Pump state not initialized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			Unit string `json:"unit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+			return
+		}
+		unit, err := state.ParseGlucoseUnit(req.Unit)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// ParseGlucoseUnit only returns valid units, so this can't fail
+		_ = s.pumpState.SetGlucoseUnit(unit)
+		log.Infof("Updated glucose units: %s", req.Unit)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	unit := s.pumpState.GetGlucoseUnit()
+	minBG, maxBG := state.BGRange(unit)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":  state.GlucoseUnitName(unit),
+		"minBg": minBG,
+		"maxBg": maxBG,
+	}); err != nil {
+		log.Errorf("Failed to encode units response: %v", err)
+	}
+}
+
+// handleParseAPI parses captured BLE fragments with the live bridge. hex
+// holds one or more raw fragments (including framing), separated by
+// whitespace or commas, in receive order.
+// POST /api/parse {"characteristic": "CurrentStatus", "hex": "0001200100..."}
+func (s *Server) handleParseAPI(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		writeJSONError(w, http.StatusInternalServerError, "pumpX2 bridge not initialized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Characteristic string `json:"characteristic"`
+		Hex            string `json:"hex"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	charType := s.parseCharacteristicName(req.Characteristic)
+	if charType < 0 {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unknown characteristic: %q", req.Characteristic))
+		return
+	}
+
+	fragments := strings.Fields(strings.ReplaceAll(req.Hex, ",", " "))
+	if len(fragments) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Request body must include at least one hex fragment")
+		return
+	}
+	for i, fragment := range fragments {
+		data, err := ParseHex(fragment)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Fragment %d: %v", i, err))
+			return
+		}
+		fragments[i] = hex.EncodeToString(data)
+	}
+
+	parsed, err := s.bridge.ParseMessage(charType, fragments)
+	if errors.Is(err, pumpx2.ErrBridgeUnavailable) {
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to parse: %v", err))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Failed to parse: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(parsed); err != nil {
+		log.Errorf("Failed to encode parse response: %v", err)
+	}
+}
+
+// handleParseFailuresAPI returns how many received messages failed to parse,
+// by characteristic, and the raw fragments of the most recent failures
+// GET /api/parse/failures
+func (s *Server) handleParseFailuresAPI(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		writeJSONError(w, http.StatusInternalServerError, "pumpX2 bridge not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.bridge.ParseFailureStats()); err != nil {
+		log.Errorf("Failed to encode parse failures response: %v", err)
+	}
+}
+
+// handleBridgeLogAPI returns the raw command, stdout, stderr and exit code of
+// the bridge's most recent cliparser runs, oldest first
+// GET /api/bridge/log
+func (s *Server) handleBridgeLogAPI(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		writeJSONError(w, http.StatusInternalServerError, "pumpX2 bridge not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	invocations := s.bridge.RecentInvocations()
+	if invocations == nil {
+		invocations = []pumpx2.Invocation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(invocations); err != nil {
+		log.Errorf("Failed to encode bridge log response: %v", err)
+	}
+}
+
+// handleConfigAPI returns the effective configuration with secrets redacted
+// GET /api/config
+func (s *Server) handleConfigAPI(w http.ResponseWriter, r *http.Request) {
+	if s.config == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Config not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.config); err != nil {
+		log.Errorf("Failed to encode config response: %v", err)
+	}
+}
+
+// handleReassemblerAPI returns the reassembler stats and in-flight buffers
+// GET /api/reassembler
+func (s *Server) handleReassemblerAPI(w http.ResponseWriter, r *http.Request) {
+	if s.reassembler == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Reassembler not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"stats":   s.reassembler.GetStats(),
+		"buffers": s.reassembler.BufferDetails(),
+	}); err != nil {
+		log.Errorf("Failed to encode reassembler response: %v", err)
+	}
+}
+
+// handleReassemblerResetAPI drops the reassembler's in-flight buffers
+// POST /api/reassembler/reset
+func (s *Server) handleReassemblerResetAPI(w http.ResponseWriter, r *http.Request) {
+	if s.reassembler == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Reassembler not initialized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	cleared := len(s.reassembler.BufferDetails())
+	s.reassembler.Reset()
+	log.Infof("Cleared %d in-flight reassembler buffer(s) via API", cleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"cleared": cleared}); err != nil {
+		log.Errorf("Failed to encode reassembler reset response: %v", err)
+	}
+}
+
+// handleJPAKEAPI returns the in-progress and completed JPAKE sessions
+// GET /api/jpake
+func (s *Server) handleJPAKEAPI(w http.ResponseWriter, r *http.Request) {
+	if s.jpakeSessions == nil {
+		writeJSONError(w, http.StatusInternalServerError, "JPAKE session manager not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.jpakeSessions.Sessions()); err != nil {
+		log.Errorf("Failed to encode JPAKE sessions response: %v", err)
+	}
+}
+
+// handleStateAuditAPI returns the state changes applied by handlers, with
+// the pump state before and after each, oldest first
+// GET /api/state/audit
+func (s *Server) handleStateAuditAPI(w http.ResponseWriter, r *http.Request) {
+	if s.stateAudit == nil {
+		writeJSONError(w, http.StatusInternalServerError, "state audit log not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.stateAudit.Entries()); err != nil {
+		log.Errorf("Failed to encode state audit response: %v", err)
+	}
+}
+
+// handleTimeSeriesAPI returns the recorded history of a state field's
+// values, or the fields with history when no field is given
+// GET /api/timeseries?field=reservoirLevel
+func (s *Server) handleTimeSeriesAPI(w http.ResponseWriter, r *http.Request) {
+	if s.timeSeries == nil {
+		writeJSONError(w, http.StatusInternalServerError, "time series not initialized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var response interface{}
+	if field := r.URL.Query().Get("field"); field != "" {
+		response = map[string]interface{}{
+			"field":  field,
+			"points": s.timeSeries.Series(field),
+		}
+	} else {
+		response = map[string]interface{}{"fields": s.timeSeries.Fields()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Failed to encode time series response: %v", err)
+	}
+}
+
+// handleEventsAPI sends a qualifying event on demand, with optional params
+// overriding the pump state it describes, and returns the packet sent
+// POST /api/events/{eventType} {"percentage": 5}
+func (s *Server) handleEventsAPI(w http.ResponseWriter, r *http.Request) {
+	if s.qeNotifier == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Qualifying events notifier not initialized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	eventType := strings.TrimPrefix(r.URL.Path, "/api/events/")
+	params := map[string]interface{}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+			return
+		}
+	}
+
+	packet, err := s.qeNotifier.InjectEvent(eventType, params)
+	if errors.Is(err, handler.ErrUnknownQualifyingEvent) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event type: %q. Valid types: %s",
+			eventType, strings.Join(handler.InjectableEventTypes(), ", ")))
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to send %s: %v", eventType, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"eventType":  eventType,
+		"packet":     hex.EncodeToString(packet),
+		"suppressed": packet == nil,
+	}); err != nil {
+		log.Errorf("Failed to encode event response: %v", err)
+	}
+}
+
+// handleAuthLockoutAPI reports the failed authentication lockout, or clears
+// it so the client can authenticate again without waiting out the cooldown
+// GET    /api/auth/lockout
+// DELETE /api/auth/lockout
+func (s *Server) handleAuthLockoutAPI(w http.ResponseWriter, r *http.Request) {
+	if s.authLockout == nil {
+		writeJSONError(w, http.StatusInternalServerError, "Auth lockout not initialized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		s.authLockout.Clear()
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.authLockout.Status()); err != nil {
+		log.Errorf("Failed to encode auth lockout status: %v", err)
+	}
+}
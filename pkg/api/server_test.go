@@ -0,0 +1,1378 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/config"
+	"github.com/jwoglom/faketandem/pkg/handler"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/settings"
+	"github.com/jwoglom/faketandem/pkg/state"
+
+	"github.com/gorilla/websocket"
+)
+
+// assertJSONError verifies a recorded response is a JSON error body with status
+func assertJSONError(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Errorf("Expected status %d, got %d", status, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json content type, got %q", ct)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Error body is not JSON: %v (%q)", err, rec.Body.String())
+	}
+	if body["error"] == "" {
+		t.Errorf("Expected non-empty error field, got %v", body)
+	}
+}
+
+// TestSettingsAPINotFoundReturnsJSONError verifies an unknown setting 404s with a JSON body
+func TestSettingsAPINotFoundReturnsJSONError(t *testing.T) {
+	s := New(nil)
+	s.SetSettingsManager(settings.NewManager())
+
+	rec := httptest.NewRecorder()
+	s.handleSettingsAPI(rec, httptest.NewRequest(http.MethodGet, "/api/settings/NoSuchRequest", nil))
+
+	assertJSONError(t, rec, http.StatusNotFound)
+}
+
+// TestSettingsAPIBadRequestReturnsJSONError verifies an unparseable body 400s with a JSON body
+func TestSettingsAPIBadRequestReturnsJSONError(t *testing.T) {
+	s := New(nil)
+	s.SetSettingsManager(settings.NewManager())
+
+	rec := httptest.NewRecorder()
+	s.handleSettingsAPI(rec, httptest.NewRequest(http.MethodPut, "/api/settings/ApiVersionRequest", strings.NewReader("{not json")))
+
+	assertJSONError(t, rec, http.StatusBadRequest)
+}
+
+// TestHexdumpAPIInvalidHexReturnsJSONError verifies non-JSON endpoints also use JSON errors
+func TestHexdumpAPIInvalidHexReturnsJSONError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(nil).handleHexdumpAPI(rec, httptest.NewRequest(http.MethodGet, "/api/hexdump?hex=zz", nil))
+
+	assertJSONError(t, rec, http.StatusBadRequest)
+}
+
+// TestParseHexNormalizesSeparators verifies hex is accepted with colons or
+// whitespace between bytes, and that odd-length or non-hex input is refused
+// with a descriptive error
+func TestParseHexNormalizesSeparators(t *testing.T) {
+	for _, tc := range []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: "01:ab:FF", want: "01abff"},
+		{input: " 01 ab\tff\n", want: "01abff"},
+		{input: "", want: ""},
+		{input: "01a", wantErr: "odd number of hex digits (3)"},
+		{input: "01zz", wantErr: "non-hex character 'z' at position 2"},
+	} {
+		data, err := ParseHex(tc.input)
+		if tc.wantErr != "" {
+			if !errors.Is(err, errInvalidHexData) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ParseHex(%q): expected error containing %q, got %v", tc.input, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || hex.EncodeToString(data) != tc.want {
+			t.Errorf("ParseHex(%q) = %x, %v; want %s", tc.input, data, err, tc.want)
+		}
+	}
+}
+
+// TestSetCharacteristicCommandRejectsInvalidHex verifies a websocket command
+// with bad hex fails with the invalid hex error sent back to the client
+func TestSetCharacteristicCommandRejectsInvalidHex(t *testing.T) {
+	err := New(nil).handleCommand(nil, []byte(`{"command": "setCharacteristic", "characteristic": "CurrentStatus", "data": "abc"}`))
+	if !errors.Is(err, errInvalidHexData) {
+		t.Errorf("Expected an invalid hex error, got %v", err)
+	}
+}
+
+// TestParseAPIReturnsParsedMessage verifies posted fragments are parsed on the
+// chosen characteristic into ParsedMessage JSON
+func TestParseAPIReturnsParsedMessage(t *testing.T) {
+	bridge := pumpx2.NewBridgeWithRunner(mockrunner.New())
+	encoded, err := bridge.EncodeMessage(9, "CurrentBasalStatusResponse", map[string]interface{}{
+		"profileBasalRate": 800, "currentBasalRate": 1600, "basalModifiedBitmask": 1,
+	})
+	if err != nil {
+		t.Fatalf("EncodeMessage failed: %v", err)
+	}
+
+	s := New(nil)
+	s.SetBridge(bridge)
+	body, _ := json.Marshal(map[string]string{
+		"characteristic": bluetooth.CharCurrentStatus.String(),
+		"hex":            strings.Join(encoded.Packets, ","),
+	})
+	rec := httptest.NewRecorder()
+	s.handleParseAPI(rec, httptest.NewRequest(http.MethodPost, "/api/parse", strings.NewReader(string(body))))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var parsed pumpx2.ParsedMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &parsed); err != nil {
+		t.Fatalf("Response is not a ParsedMessage: %v", err)
+	}
+	if parsed.MessageType != "CurrentBasalStatusResponse" || parsed.TxID != 9 {
+		t.Errorf("Expected CurrentBasalStatusResponse txID=9, got %s txID=%d", parsed.MessageType, parsed.TxID)
+	}
+	if parsed.Cargo["currentBasalRate"] != 1600.0 || parsed.Cargo["basalModifiedBitmask"] != 1.0 {
+		t.Errorf("Unexpected cargo: %v", parsed.Cargo)
+	}
+}
+
+// TestParseFailuresAPIReportsReceivedFailures verifies a received message
+// that fails to parse shows up in the counts with its hex
+func TestParseFailuresAPIReportsReceivedFailures(t *testing.T) {
+	bridge := pumpx2.NewUnavailableBridge(errors.New("cliparser jar not found"), mockrunner.New())
+	if _, err := bridge.ParseReceivedMessage(bluetooth.CharCurrentStatus, []string{"0001fe0100c0d6"}); err == nil {
+		t.Fatal("Expected the unknown message to fail to parse")
+	}
+
+	s := New(nil)
+	s.SetBridge(bridge)
+	rec := httptest.NewRecorder()
+	s.handleParseFailuresAPI(rec, httptest.NewRequest(http.MethodGet, "/api/parse/failures", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var stats pumpx2.ParseFailureStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Response is not ParseFailureStats: %v", err)
+	}
+	if stats.Total != 1 || stats.ByCharacteristic[bluetooth.CharCurrentStatus.String()] != 1 {
+		t.Errorf("Expected one CurrentStatus failure, got %+v", stats)
+	}
+	if len(stats.Recent) != 1 || stats.Recent[0].Hex[0] != "0001fe0100c0d6" {
+		t.Errorf("Expected the failing hex, got %+v", stats.Recent)
+	}
+}
+
+// TestParseAPIRejectsUnknownCharacteristic verifies a bad characteristic 400s
+func TestParseAPIRejectsUnknownCharacteristic(t *testing.T) {
+	s := New(nil)
+	s.SetBridge(pumpx2.NewBridgeWithRunner(mockrunner.New()))
+
+	rec := httptest.NewRecorder()
+	s.handleParseAPI(rec, httptest.NewRequest(http.MethodPost, "/api/parse",
+		strings.NewReader(`{"characteristic": "Bogus", "hex": "00012001"}`)))
+
+	assertJSONError(t, rec, http.StatusBadRequest)
+}
+
+// TestParseAPIWithoutBridge verifies that without the bridge a message the
+// built-in decoder doesn't know 503s with the reason the bridge is missing
+func TestParseAPIWithoutBridge(t *testing.T) {
+	s := New(nil)
+	s.SetBridge(pumpx2.NewUnavailableBridge(errors.New("cliparser jar not found"), mockrunner.New()))
+
+	rec := httptest.NewRecorder()
+	s.handleParseAPI(rec, httptest.NewRequest(http.MethodPost, "/api/parse",
+		strings.NewReader(`{"characteristic": "CurrentStatus", "hex": "0001fe0100c0d6"}`)))
+	assertJSONError(t, rec, http.StatusServiceUnavailable)
+	if !strings.Contains(rec.Body.String(), "cliparser jar not found") {
+		t.Errorf("Expected the error to name the cause, got %s", rec.Body.String())
+	}
+}
+
+// TestReadOnlyModeRejectsMutations verifies GETs and getState still work in
+// read-only mode while a settings PUT and a notify command are rejected
+func TestReadOnlyModeRejectsMutations(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.SetSettingsManager(settings.NewManager())
+	s.SetReadOnly(true)
+	settingsAPI := s.rejectWritesIfReadOnly(s.handleSettingsAPI)
+
+	rec := httptest.NewRecorder()
+	settingsAPI(rec, httptest.NewRequest(http.MethodGet, "/api/settings", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected GET to succeed in read-only mode, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	settingsAPI(rec, httptest.NewRequest(http.MethodPut, "/api/settings/ApiVersionRequest", strings.NewReader(`{}`)))
+	assertJSONError(t, rec, http.StatusForbidden)
+
+	if err := s.handleCommand(nil, []byte(`{"command": "getState"}`)); err != nil {
+		t.Errorf("Expected getState to be allowed in read-only mode, got %v", err)
+	}
+	handled := false
+	s.SetCommandHandler(func(string, map[string]interface{}) (interface{}, bool, error) { handled = true; return nil, true, nil })
+	if err := s.handleCommand(nil, []byte(`{"command": "notify", "characteristic": "CurrentStatus", "data": "00"}`)); err == nil {
+		t.Error("Expected notify to be rejected in read-only mode")
+	}
+	if err := s.handleCommand(nil, []byte(`{"command": "setBasalRate", "rate": 1.0}`)); err == nil || handled {
+		t.Error("Expected custom commands to be rejected in read-only mode")
+	}
+}
+
+// TestUnitsAPISetsGlucoseUnit verifies a PUT switches the units preference
+// and an unknown unit is rejected
+func TestUnitsAPISetsGlucoseUnit(t *testing.T) {
+	s := New(nil)
+	s.SetPumpState(state.NewPumpState())
+
+	rec := httptest.NewRecorder()
+	s.handleUnitsAPI(rec, httptest.NewRequest(http.MethodPut, "/api/units", strings.NewReader(`{"unit": "mmol/L"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if body["unit"] != "mmol/L" || body["maxBg"] != state.MaxBGMmol {
+		t.Errorf("Unexpected units response: %v", body)
+	}
+	if s.pumpState.GetGlucoseUnit() != state.GlucoseUnitMmol {
+		t.Errorf("Expected pump state in mmol/L, got %d", s.pumpState.GetGlucoseUnit())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleUnitsAPI(rec, httptest.NewRequest(http.MethodPut, "/api/units", strings.NewReader(`{"unit": "furlongs"}`)))
+	assertJSONError(t, rec, http.StatusBadRequest)
+}
+
+// TestGlobalsAPIAppliesRegionDefaults verifies changing the region through
+// PUT /api/globals applies the region's defaults under any fields given
+func TestGlobalsAPIAppliesRegionDefaults(t *testing.T) {
+	s := New(nil)
+	s.SetPumpState(state.NewPumpState())
+
+	rec := httptest.NewRecorder()
+	s.handleGlobalsAPI(rec, httptest.NewRequest(http.MethodPut, "/api/globals", strings.NewReader(`{"pump": {"region": "EU"}, "therapy": {"maxIob": 10}}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	therapy := s.pumpState.GetTherapyConfig()
+	if s.pumpState.GetRegion() != state.RegionEU || s.pumpState.GetGlucoseUnit() != state.GlucoseUnitMmol || therapy.MaxBolus != 20.0 {
+		t.Errorf("Expected EU defaults, got region %s, therapy %+v", s.pumpState.GetRegion(), therapy)
+	}
+	if therapy.MaxIOB != 10 {
+		t.Errorf("Expected the given max IOB over the EU defaults, got %.1f", therapy.MaxIOB)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleGlobalsAPI(rec, httptest.NewRequest(http.MethodPut, "/api/globals", strings.NewReader(`{"pump": {"region": ""}}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected an empty region to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if s.pumpState.GetRegion() != state.RegionUS || s.pumpState.GetTherapyConfig().MaxBolus != 25.0 {
+		t.Errorf("Expected an empty region to apply US defaults, got region %s, therapy %+v", s.pumpState.GetRegion(), s.pumpState.GetTherapyConfig())
+	}
+}
+
+// TestPumpStateAPIAppliesPartialUpdate verifies PUT /api/pumpstate changes
+// only the fields given, and GET returns the live values
+func TestPumpStateAPIAppliesPartialUpdate(t *testing.T) {
+	s := New(nil)
+	s.SetPumpState(state.NewPumpState())
+	basal := s.pumpState.GetBasalRate()
+
+	rec := httptest.NewRecorder()
+	s.handlePumpStateAPI(rec, httptest.NewRequest(http.MethodPut, "/api/pumpstate",
+		strings.NewReader(`{"reservoir": 42.5, "battery": 15, "suspended": true, "iob": 3}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePumpStateAPI(rec, httptest.NewRequest(http.MethodGet, "/api/pumpstate", nil))
+	var body pumpStateBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if body.Reservoir != 42.5 || body.Battery != 15 || !body.Suspended || body.BasalRate != basal {
+		t.Errorf("Unexpected pump state: %+v", body)
+	}
+	if body.IOB < 2.99 || body.IOB > 3 {
+		t.Errorf("Expected 3 units IOB, got %.4f", body.IOB)
+	}
+	if s.pumpState.GetBatteryLevel() != 15 || !s.pumpState.IsPumpingSuspended() {
+		t.Error("Expected the update to reach pump state")
+	}
+}
+
+// TestPumpStateAPISeparatesProfileAndEffectiveBasal verifies the profile
+// rate can be set during a temp rate without changing the effective rate,
+// and suspending goes through the suspend handler
+func TestPumpStateAPISeparatesProfileAndEffectiveBasal(t *testing.T) {
+	s := New(nil)
+	s.SetPumpState(state.NewPumpState())
+	s.pumpState.SetBasalState(&state.BasalState{CurrentRate: 1.0, TempBasalActive: true, TempBasalRate: 0.5})
+	var suspends []bool
+	s.SetSuspendHandler(func(suspended bool) error {
+		suspends = append(suspends, suspended)
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	s.handlePumpStateAPI(rec, httptest.NewRequest(http.MethodPut, "/api/pumpstate",
+		strings.NewReader(`{"profileBasalRate": 1.2, "suspended": true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body pumpStateBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if body.ProfileBasalRate != 1.2 || body.BasalRate != 0.5 {
+		t.Errorf("Expected profile rate 1.2 and effective temp rate 0.5, got %+v", body)
+	}
+	if len(suspends) != 1 || !suspends[0] {
+		t.Errorf("Expected the suspend handler to suspend, got %v", suspends)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePumpStateAPI(rec, httptest.NewRequest(http.MethodPut, "/api/pumpstate",
+		strings.NewReader(`{"basalRate": 1.2}`)))
+	assertJSONError(t, rec, http.StatusBadRequest)
+}
+
+// TestPumpStateAPIRejectsInvalidUpdate verifies an out of range field fails
+// the whole update without applying any of it
+func TestPumpStateAPIRejectsInvalidUpdate(t *testing.T) {
+	s := New(nil)
+	s.SetPumpState(state.NewPumpState())
+	reservoir := s.pumpState.GetReservoirLevel()
+
+	for _, body := range []string{
+		`{"reservoir": 10, "battery": 101}`,
+		`{"reservoir": 10, "profileBasalRate": 1000}`,
+		`{"reservoir": -1}`,
+		`{"iob": -2}`,
+	} {
+		rec := httptest.NewRecorder()
+		s.handlePumpStateAPI(rec, httptest.NewRequest(http.MethodPut, "/api/pumpstate", strings.NewReader(body)))
+		assertJSONError(t, rec, http.StatusBadRequest)
+	}
+	if got := s.pumpState.GetReservoirLevel(); got != reservoir {
+		t.Errorf("Expected reservoir to be unchanged at %.2f, got %.2f", reservoir, got)
+	}
+}
+
+// TestSimulatedBatteryChangeSendsStateChangeEvent verifies a battery drain in
+// the simulator reaches a connected websocket client as a stateChange event
+func TestSimulatedBatteryChangeSendsStateChangeEvent(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadMessage(); err != nil { // initial state
+		t.Fatalf("Reading initial state failed: %v", err)
+	}
+
+	pumpState := state.NewPumpState()
+	events := state.NewEventBus()
+	events.Subscribe(s.StateChangeNotifier())
+	// A long update interval drains the battery by whole percent in one tick
+	sim := state.NewSimulator(pumpState, time.Hour)
+	sim.SetEventNotifier(events)
+	sim.Tick()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	for {
+		var event BleEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("No battery stateChange event received: %v", err)
+		}
+		if event.Type != "stateChange" {
+			continue
+		}
+		if pct, ok := event.Fields["batteryPercentage"]; ok {
+			if int(pct.(float64)) != pumpState.GetBatteryLevel() {
+				t.Errorf("Expected batteryPercentage %d, got %v", pumpState.GetBatteryLevel(), pct)
+			}
+			return
+		}
+	}
+}
+
+// TestConfigAPIRedactsSecrets verifies the effective config is returned with
+// the long-term key masked
+func TestConfigAPIRedactsSecrets(t *testing.T) {
+	cfg := &config.Config{
+		PumpX2Mode:       "jar",
+		JPAKEMode:        "go",
+		JPAKELongTermKey: []byte{0xde, 0xad, 0xbe, 0xef},
+		RXWorkers:        4,
+	}
+	if err := cfg.SetTimeouts(5*time.Second, 2*time.Second); err != nil {
+		t.Fatalf("SetTimeouts failed: %v", err)
+	}
+	s := New(nil)
+	s.SetConfig(cfg)
+
+	rec := httptest.NewRecorder()
+	s.handleConfigAPI(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "deadbeef") {
+		t.Errorf("Expected long-term key to be redacted, got %s", rec.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if body["jpakeLongTermKey"] != "<redacted>" {
+		t.Errorf("Expected masked long-term key, got %v", body["jpakeLongTermKey"])
+	}
+	if body["pumpX2Mode"] != "jar" || body["rxWorkers"] != 4.0 || body["reassemblyTimeout"] != "5s" {
+		t.Errorf("Expected resolved config values, got %v", body)
+	}
+	if len(cfg.JPAKELongTermKey) != 4 {
+		t.Error("Expected redacting to leave the running config's key alone")
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleConfigAPI(rec, httptest.NewRequest(http.MethodPost, "/api/config", nil))
+	assertJSONError(t, rec, http.StatusMethodNotAllowed)
+}
+
+// TestRegisteredCommandAcksResult verifies a registered custom command gets
+// the command's params and its result reaches the client as an ack
+func TestRegisteredCommandAcksResult(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	var got map[string]interface{}
+	s.RegisterCommand("setOcclusion", func(params map[string]interface{}) (interface{}, error) {
+		got = params
+		return map[string]interface{}{"occluded": params["occluded"]}, nil
+	})
+	handled := false
+	s.SetCommandHandler(func(string, map[string]interface{}) (interface{}, bool, error) { handled = true; return nil, true, nil })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadMessage(); err != nil { // initial state
+		t.Fatalf("Reading initial state failed: %v", err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command": "setOcclusion", "occluded": true}`)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	var event BleEvent
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("No ack received: %v", err)
+	}
+
+	if event.Type != "ack" || event.Command != "setOcclusion" {
+		t.Fatalf("Expected setOcclusion ack, got %+v", event)
+	}
+	if result, ok := event.Result.(map[string]interface{}); !ok || result["occluded"] != true {
+		t.Errorf("Expected result {occluded: true}, got %v", event.Result)
+	}
+	if got["occluded"] != true {
+		t.Errorf("Expected command to get its params, got %v", got)
+	}
+	if handled {
+		t.Error("Expected a registered command not to reach the command handler")
+	}
+}
+
+// TestCommandAckSentOnlyToSender verifies a command's ack goes to the client
+// that sent the command, not to every connected client
+func TestCommandAckSentOnlyToSender(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.RegisterCommand("ping", func(map[string]interface{}) (interface{}, error) { return "pong", nil })
+	sender, other := dialSenderAndOther(t, s)
+
+	event := sendCommand(t, sender, `{"command": "ping"}`)
+	if event.Type != "ack" || event.Result != "pong" {
+		t.Fatalf("Expected the sender to get the ack, got %+v", event)
+	}
+	expectNoMessage(t, other)
+}
+
+// TestCommandErrorSentOnlyToSender verifies a failed command's error goes to
+// the client that sent the command, not to every connected client
+func TestCommandErrorSentOnlyToSender(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.RegisterCommand("fail", func(map[string]interface{}) (interface{}, error) { return nil, errors.New("boom") })
+	sender, other := dialSenderAndOther(t, s)
+
+	event := sendCommand(t, sender, `{"command": "fail"}`)
+	if event.Type != "error" || !strings.Contains(event.Message, "boom") {
+		t.Fatalf("Expected the sender to get the error, got %+v", event)
+	}
+	expectNoMessage(t, other)
+}
+
+// dialSenderAndOther serves s and connects two websocket clients to it,
+// having read each one's initial state
+func dialSenderAndOther(t *testing.T, s *Server) (sender, other *websocket.Conn) {
+	t.Helper()
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if _, _, err := conn.ReadMessage(); err != nil { // initial state
+			t.Fatalf("Reading initial state failed: %v", err)
+		}
+		return conn
+	}
+	return dial(), dial()
+}
+
+// sendCommand writes command from conn and returns the event it gets back
+func sendCommand(t *testing.T, conn *websocket.Conn, command string) BleEvent {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(command)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	var event BleEvent
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	return event
+}
+
+// expectNoMessage verifies conn receives nothing for a short while
+func expectNoMessage(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Errorf("Expected no message for another client, got %s", data)
+	}
+}
+
+// TestRegisteredCommandErrorReturned verifies a failing custom command's
+// error is reported
+func TestRegisteredCommandErrorReturned(t *testing.T) {
+	s := New(nil)
+	s.RegisterCommand("fail", func(map[string]interface{}) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+
+	if err := s.handleCommand(nil, []byte(`{"command": "fail"}`)); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected the command's error, got %v", err)
+	}
+}
+
+// TestReassemblerAPIShowsAndResetsBuffers verifies an in-flight buffer is
+// listed by GET /api/reassembler and cleared by POST /api/reassembler/reset
+func TestReassemblerAPIShowsAndResetsBuffers(t *testing.T) {
+	reassembler := protocol.NewLazyReassembler(time.Minute, time.Now)
+	if _, _, _, err := reassembler.AddPacket(bluetooth.CharControl, append([]byte{1, 4}, make([]byte, 16)...)); err != nil {
+		t.Fatalf("AddPacket failed: %v", err)
+	}
+	s := New(nil)
+	s.SetReassembler(reassembler)
+
+	rec := httptest.NewRecorder()
+	s.handleReassemblerAPI(rec, httptest.NewRequest(http.MethodGet, "/api/reassembler", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Buffers []protocol.BufferDetail `json:"buffers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if len(body.Buffers) != 1 || body.Buffers[0].Key != "Control-4" || body.Buffers[0].Packets != 1 || body.Buffers[0].ExpectedPackets != 2 {
+		t.Fatalf("Expected the in-flight Control-4 buffer, got %+v", body.Buffers)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleReassemblerResetAPI(rec, httptest.NewRequest(http.MethodPost, "/api/reassembler/reset", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"cleared":1`) {
+		t.Fatalf("Expected one buffer cleared, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if details := reassembler.BufferDetails(); len(details) != 0 {
+		t.Errorf("Expected no buffers after reset, got %+v", details)
+	}
+}
+
+// TestJPAKEAPIListsSessions verifies GET /api/jpake reports each session's
+// round and mode
+func TestJPAKEAPIListsSessions(t *testing.T) {
+	manager := handler.NewJPAKESessionManager("pumpx2", "/tmp", "gradle", "./gradlew", "java", "", state.NewPumpState())
+	if _, err := manager.GetOrCreate("central-1/100", "123456", &pumpx2.Bridge{}, 1); err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+	defer manager.CloseAll()
+	manager.Advance("central-1/100", 2, "Jpake2Request")
+	s := New(nil)
+	s.SetJPAKESessionManager(manager)
+
+	rec := httptest.NewRecorder()
+	s.handleJPAKEAPI(rec, httptest.NewRequest(http.MethodGet, "/api/jpake", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var sessions []handler.JPAKESessionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Mode != "pumpx2" || sessions[0].Round != 2 || sessions[0].Complete {
+		t.Errorf("Expected an in-progress pumpx2 session at round 2, got %+v", sessions)
+	}
+}
+
+// TestParseCharacteristicNameRoundTrips verifies the API parses the name of
+// every characteristic back to it, and rejects unknown names
+func TestParseCharacteristicNameRoundTrips(t *testing.T) {
+	s := New(nil)
+	for _, info := range bluetooth.Characteristics() {
+		if got := s.parseCharacteristicName(info.Type.String()); got != info.Type {
+			t.Errorf("Expected %q to parse to %d, got %d", info.Type.String(), info.Type, got)
+		}
+	}
+	if got := s.parseCharacteristicName("Unknown"); got >= 0 {
+		t.Errorf("Expected an unknown name to be rejected, got %d", got)
+	}
+}
+
+// TestServersHaveIndependentRoutes verifies two servers in one process each
+// route requests to their own state, and that Start reports a listen failure
+func TestServersHaveIndependentRoutes(t *testing.T) {
+	first, second := New(nil), New(nil)
+	first.SetAuthLockout(&handler.AuthLockout{})
+	lockout := &handler.AuthLockout{}
+	lockout.Configure(3, time.Minute)
+	second.SetAuthLockout(lockout)
+
+	for i, s := range []*Server{first, second} {
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/lockout", nil))
+		var status handler.AuthLockoutStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status.Threshold != 3*i {
+			t.Errorf("Server %d: expected threshold %d, got %+v (err=%v)", i, 3*i, status, err)
+		}
+	}
+
+	second.SetListenAddr("127.0.0.1:-1")
+	if err := second.Start(); err == nil {
+		t.Error("Expected Start to return the listen error")
+	}
+}
+
+// TestShutdownStopsStart verifies Start returns nil once the server is shut
+// down, whether or not it was already listening
+func TestShutdownStopsStart(t *testing.T) {
+	s := New(nil)
+	s.SetListenAddr("127.0.0.1:0")
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Start to return nil after Shutdown, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start didn't return after Shutdown")
+	}
+}
+
+// TestStateAuditAPIListsEntries verifies the audit API returns a JSON list
+// and rejects non-GET requests
+func TestStateAuditAPIListsEntries(t *testing.T) {
+	s := New(nil)
+	s.SetStateAuditLog(&handler.StateAuditLog{})
+
+	rec := httptest.NewRecorder()
+	s.handleStateAuditAPI(rec, httptest.NewRequest(http.MethodGet, "/api/state/audit", nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("Expected 200 with an empty list, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleStateAuditAPI(rec, httptest.NewRequest(http.MethodPost, "/api/state/audit", nil))
+	assertJSONError(t, rec, http.StatusMethodNotAllowed)
+}
+
+// TestTimeSeriesAPIReturnsFieldHistory verifies the time series API lists the
+// recorded fields and returns one field's points
+func TestTimeSeriesAPIReturnsFieldHistory(t *testing.T) {
+	s := New(nil)
+	ts := state.NewTimeSeries()
+	ts.Record(map[string]interface{}{"reservoirLevel": 200.0})
+	ts.Record(map[string]interface{}{"reservoirLevel": 199.5})
+	s.SetTimeSeries(ts)
+
+	rec := httptest.NewRecorder()
+	s.handleTimeSeriesAPI(rec, httptest.NewRequest(http.MethodGet, "/api/timeseries?field=reservoirLevel", nil))
+	var series struct {
+		Field  string                  `json:"field"`
+		Points []state.TimeSeriesPoint `json:"points"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&series); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with a series, got %d (err=%v)", rec.Code, err)
+	}
+	if series.Field != "reservoirLevel" || len(series.Points) != 2 || series.Points[1].Value != 199.5 {
+		t.Errorf("Expected two reservoirLevel points ending at 199.5, got %+v", series)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleTimeSeriesAPI(rec, httptest.NewRequest(http.MethodGet, "/api/timeseries", nil))
+	if !strings.Contains(rec.Body.String(), `"fields":["reservoirLevel"]`) {
+		t.Errorf("Expected the recorded fields, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleTimeSeriesAPI(rec, httptest.NewRequest(http.MethodPost, "/api/timeseries", nil))
+	assertJSONError(t, rec, http.StatusMethodNotAllowed)
+}
+
+// TestAuthLockoutAPIReportsAndClears verifies the auth lockout API reports
+// the lockout configuration and accepts DELETE to clear it
+func TestAuthLockoutAPIReportsAndClears(t *testing.T) {
+	s := New(nil)
+	lockout := &handler.AuthLockout{}
+	lockout.Configure(5, 30*time.Second)
+	s.SetAuthLockout(lockout)
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := httptest.NewRecorder()
+		s.handleAuthLockoutAPI(rec, httptest.NewRequest(method, "/api/auth/lockout", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", method, rec.Code, rec.Body.String())
+		}
+		var status handler.AuthLockoutStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", method, err)
+		}
+		if status.Threshold != 5 || status.CooldownSeconds != 30 || status.LockedOut {
+			t.Errorf("%s: unexpected status %+v", method, status)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleAuthLockoutAPI(rec, httptest.NewRequest(http.MethodPost, "/api/auth/lockout", nil))
+	assertJSONError(t, rec, http.StatusMethodNotAllowed)
+}
+
+// dialTestServer connects a websocket client to s and reads the initial state
+func dialTestServer(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, _, err := conn.ReadMessage(); err != nil { // initial state
+		t.Fatalf("Reading initial state failed: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	return conn
+}
+
+// TestJSONRPCResultCorrelatedByID verifies each JSON-RPC request's result
+// comes back under its own id, and a notification gets no response
+func TestJSONRPCResultCorrelatedByID(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.RegisterCommand("double", func(params map[string]interface{}) (interface{}, error) {
+		return params["value"].(float64) * 2, nil
+	})
+	conn := dialTestServer(t, s)
+
+	requests := []string{
+		`{"jsonrpc": "2.0", "method": "double", "params": {"value": 1}}`,
+		`{"jsonrpc": "2.0", "id": "a", "method": "double", "params": {"value": 2}}`,
+		`{"jsonrpc": "2.0", "id": 7, "method": "double", "params": {"value": 3}}`,
+	}
+	for _, req := range requests {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+	}
+
+	expected := []struct {
+		id     interface{}
+		result float64
+	}{{"a", 4}, {7.0, 6}}
+	for _, want := range expected {
+		var resp map[string]interface{}
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("No response for id %v: %v", want.id, err)
+		}
+		if resp["jsonrpc"] != "2.0" || resp["id"] != want.id || resp["result"] != want.result {
+			t.Errorf("Expected result %v for id %v, got %v", want.result, want.id, resp)
+		}
+		if _, ok := resp["error"]; ok {
+			t.Errorf("Expected no error alongside a result, got %v", resp)
+		}
+	}
+}
+
+// TestJSONRPCUnknownMethod verifies an unknown method gets a method not found
+// error under the request's id
+func TestJSONRPCUnknownMethod(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.SetCommandHandler(func(string, map[string]interface{}) (interface{}, bool, error) { return nil, false, nil })
+
+	resp, ok := s.jsonRPCResponse([]byte(`{"jsonrpc": "2.0", "id": 3, "method": "noSuchMethod"}`))
+	if !ok {
+		t.Fatal("Expected a response")
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var body struct {
+		ID     int                    `json:"id"`
+		Result *json.RawMessage       `json:"result"`
+		Error  map[string]interface{} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("Response is not JSON: %v (%s)", err, data)
+	}
+	if body.ID != 3 || body.Error["code"] != float64(rpcMethodNotFound) || body.Result != nil {
+		t.Errorf("Expected method not found for id 3, got %s", data)
+	}
+}
+
+// TestJSONRPCErrors verifies malformed requests, bad params and read-only
+// rejections get structured errors
+func TestJSONRPCErrors(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	cases := []struct {
+		request string
+		code    int
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "getState"`, rpcParseError},
+		{`{"jsonrpc": "1.0", "id": 1, "method": "getState"}`, rpcInvalidRequest},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "notify", "params": [1]}`, rpcInvalidParams},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "notify", "params": {"characteristic": "Nope", "data": "00"}}`, rpcInvalidParams},
+	}
+	for _, c := range cases {
+		resp, ok := s.jsonRPCResponse([]byte(c.request))
+		if r, isResp := resp.(rpcResponse); !ok || !isResp || r.Error == nil || r.Error.Code != c.code {
+			t.Errorf("%s: expected error %d, got %+v", c.request, c.code, resp)
+		}
+	}
+
+	s.SetReadOnly(true)
+	resp, _ := s.jsonRPCResponse([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "notify", "params": {"characteristic": "Control", "data": "00"}}`))
+	if r := resp.(rpcResponse); r.Error == nil || r.Error.Code != rpcReadOnly {
+		t.Errorf("Expected notify rejected in read-only mode, got %+v", r)
+	}
+	resp, _ = s.jsonRPCResponse([]byte(`{"jsonrpc": "2.0", "id": 2, "method": "getState"}`))
+	if r := resp.(rpcResponse); r.Error != nil {
+		t.Errorf("Expected getState allowed in read-only mode, got %+v", r.Error)
+	}
+}
+
+// TestJSONRPCCommandHandlerResult verifies a method run by the command
+// handler returns the handler's result, and fails with its error
+func TestJSONRPCCommandHandlerResult(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.SetCommandHandler(func(command string, _ map[string]interface{}) (interface{}, bool, error) {
+		if command == "setBasalRate" {
+			return nil, true, errors.New("rate missing")
+		}
+		return map[string]interface{}{"pairingCode": "123456"}, true, nil
+	})
+
+	resp, _ := s.jsonRPCResponse([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "getPairingState"}`))
+	r := resp.(rpcResponse)
+	if result, ok := r.Result.(map[string]interface{}); r.Error != nil || !ok || result["pairingCode"] != "123456" {
+		t.Errorf("Expected the handler's result, got %+v", r)
+	}
+	resp, _ = s.jsonRPCResponse([]byte(`{"jsonrpc": "2.0", "id": 2, "method": "setBasalRate"}`))
+	if r := resp.(rpcResponse); r.Error == nil || r.Error.Code != rpcCommandFailed || !strings.Contains(r.Error.Message, "rate missing") {
+		t.Errorf("Expected the handler's error, got %+v", r)
+	}
+	if err := s.handleCommand(nil, []byte(`{"command": "setBasalRate"}`)); err == nil {
+		t.Error("Expected the legacy command to return the handler's error")
+	}
+}
+
+// TestJSONRPCBatch verifies a batch gets one response per request with an
+// id, in order
+func TestJSONRPCBatch(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	s.SetCommandHandler(func(command string, _ map[string]interface{}) (interface{}, bool, error) {
+		return nil, command == "resetPairing", nil
+	})
+
+	resp, ok := s.jsonRPCResponse([]byte(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "resetPairing"},
+		{"jsonrpc": "2.0", "method": "resetPairing"},
+		{"jsonrpc": "2.0", "id": 2, "method": "bogus"}
+	]`))
+	responses, isBatch := resp.([]rpcResponse)
+	if !ok || !isBatch || len(responses) != 2 {
+		t.Fatalf("Expected 2 batch responses, got %+v", resp)
+	}
+	if string(responses[0].ID) != "1" || responses[0].Error != nil {
+		t.Errorf("Expected success for id 1, got %+v", responses[0])
+	}
+	if string(responses[1].ID) != "2" || responses[1].Error == nil || responses[1].Error.Code != rpcMethodNotFound {
+		t.Errorf("Expected method not found for id 2, got %+v", responses[1])
+	}
+}
+
+// TestLegacyCommandStillHandled verifies a message without "jsonrpc" is
+// still run as a legacy command, reaching the command handler and getting
+// the legacy state message back
+func TestLegacyCommandStillHandled(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	var got string
+	s.SetCommandHandler(func(command string, params map[string]interface{}) (interface{}, bool, error) {
+		got = command + ":" + params["pairingCode"].(string)
+		return nil, true, nil
+	})
+	conn := dialTestServer(t, s)
+
+	if isJSONRPC([]byte(`{"command": "setPairingCode", "pairingCode": "123456"}`)) {
+		t.Fatal("Expected a legacy command not to be taken for JSON-RPC")
+	}
+	if err := s.handleCommand(nil, []byte(`{"command": "setPairingCode", "pairingCode": "123456"}`)); err != nil {
+		t.Fatalf("handleCommand failed: %v", err)
+	}
+	if got != "setPairingCode:123456" {
+		t.Errorf("Expected the command handler to get setPairingCode, got %q", got)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command": "getState"}`)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	var state PumpState
+	if err := conn.ReadJSON(&state); err != nil || state.Characteristics == nil {
+		t.Errorf("Expected the legacy state message, got %+v (%v)", state, err)
+	}
+}
+
+// fakePairingTransport records what the re-pair flow does to the BLE link
+type fakePairingTransport struct {
+	disconnects  int
+	encrypted    bool
+	pairingState bluetooth.PairingState
+}
+
+func (f *fakePairingTransport) ShutdownConnection() { f.disconnects++ }
+
+func (f *fakePairingTransport) SetLinkEncrypted(encrypted bool) { f.encrypted = encrypted }
+
+func (f *fakePairingTransport) SetPairingState(state bluetooth.PairingState) error {
+	f.pairingState = state
+	return nil
+}
+
+// newRepairTestServer creates a server whose re-pair flow drives transport,
+// with an authenticated pump and a completed pairing to forget
+func newRepairTestServer(t *testing.T, transport pairingTransport) (*Server, *handler.JPAKESessionManager) {
+	t.Helper()
+	pumpState := state.NewPumpState()
+	pumpState.SetAuthenticated([]byte("old-key"))
+	pumpState.SetLongTermKey([]byte("old-long-term-key"))
+
+	manager := handler.NewJPAKESessionManager("go", "", "", "", "", "", pumpState)
+	if _, err := manager.GetOrCreate("central-1/1", "123456", &pumpx2.Bridge{}, 1); err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+	manager.Complete("central-1/1")
+	t.Cleanup(manager.CloseAll)
+
+	s := New(nil)
+	s.SetPumpState(pumpState)
+	s.SetJPAKESessionManager(manager)
+	s.repair.transport = transport
+	s.repair.pollInterval = time.Millisecond
+	return s, manager
+}
+
+// waitForRepairState polls GET /api/repair until the flow reaches want
+func waitForRepairState(t *testing.T, s *Server, want string) RepairStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		s.handleRepairAPI(rec, httptest.NewRequest(http.MethodGet, "/api/repair", nil))
+		var status RepairStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Status is not JSON: %v (%q)", err, rec.Body.String())
+		}
+		if status.State == want {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected re-pair state %s, still %+v", want, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRepairAPIForgetsAndWaitsForNewPairing verifies POST /api/repair clears
+// auth and the bond, advertises for pairing, and reports completion once the
+// client finishes a new JPAKE exchange
+func TestRepairAPIForgetsAndWaitsForNewPairing(t *testing.T) {
+	transport := &fakePairingTransport{encrypted: true, pairingState: bluetooth.PairingStateNotDiscoverable}
+	s, manager := newRepairTestServer(t, transport)
+
+	rec := httptest.NewRecorder()
+	s.handleRepairAPI(rec, httptest.NewRequest(http.MethodPost, "/api/repair", strings.NewReader(`{"pairingState": "PairStep2"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if s.pumpState.IsAuthenticated || s.pumpState.GetLongTermKey() != nil {
+		t.Error("Expected authentication and the long-term key to be cleared")
+	}
+	if transport.disconnects != 1 || transport.encrypted || transport.pairingState != bluetooth.PairingStatePairStep2 {
+		t.Errorf("Expected a disconnect, cleared bond and PairStep2, got %+v", transport)
+	}
+	if len(manager.Sessions()) != 0 {
+		t.Errorf("Expected the old JPAKE session to be forgotten, got %+v", manager.Sessions())
+	}
+	waitForRepairState(t, s, RepairWaiting)
+
+	rec = httptest.NewRecorder()
+	s.handleRepairAPI(rec, httptest.NewRequest(http.MethodPost, "/api/repair", nil))
+	assertJSONError(t, rec, http.StatusConflict)
+
+	// The client runs a new JPAKE exchange
+	if _, err := manager.GetOrCreate("central-1/2", "123456", &pumpx2.Bridge{}, 1); err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+	manager.Complete("central-1/2")
+	s.pumpState.SetAuthenticated([]byte("new-key"))
+
+	status := waitForRepairState(t, s, RepairComplete)
+	if status.SessionID != "central-1/2" || status.Completed != 1 || status.FinishedAt == nil {
+		t.Errorf("Expected completion with session central-1/2, got %+v", status)
+	}
+}
+
+// TestRepairAPITimesOut verifies the flow fails if the client never pairs
+func TestRepairAPITimesOut(t *testing.T) {
+	s, _ := newRepairTestServer(t, &fakePairingTransport{})
+
+	rec := httptest.NewRecorder()
+	s.handleRepairAPI(rec, httptest.NewRequest(http.MethodPost, "/api/repair", strings.NewReader(`{"timeoutSeconds": 0.01}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	status := waitForRepairState(t, s, RepairFailed)
+	if status.Error == "" || status.Completed != 0 {
+		t.Errorf("Expected a timeout error, got %+v", status)
+	}
+}
+
+// clientCount returns how many websocket clients are connected to s
+func clientCount(s *Server) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.clients)
+}
+
+// TestEventsSentToEveryClient verifies concurrent events reach every
+// connected client intact, JSON-RPC responses go only to the requesting
+// client, and a disconnecting client doesn't stop events to the others
+func TestEventsSentToEveryClient(t *testing.T) {
+	s := New(&bluetooth.Ble{})
+	monitor := dialTestServer(t, s)
+	script := dialTestServer(t, s)
+	if n := clientCount(s); n != 2 {
+		t.Fatalf("Expected 2 clients, got %d", n)
+	}
+
+	const events = 20
+	for i := 0; i < events; i++ {
+		go s.SendEvent(BleEvent{Type: "notify", Data: strings.Repeat("ab", 64)})
+	}
+	for _, conn := range []*websocket.Conn{monitor, script} {
+		for i := 0; i < events; i++ {
+			var event BleEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				t.Fatalf("Reading event %d failed: %v", i, err)
+			}
+			if event.Type != "notify" || len(event.Data) != 128 {
+				t.Fatalf("Expected an intact notify event, got %+v", event)
+			}
+		}
+	}
+
+	if err := script.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc": "2.0", "id": 1, "method": "getState"}`)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	var resp map[string]interface{}
+	if err := script.ReadJSON(&resp); err != nil || resp["id"] != float64(1) {
+		t.Fatalf("Expected the JSON-RPC response, got %v (%v)", resp, err)
+	}
+	s.SendEvent(BleEvent{Type: "marker"})
+	var event BleEvent
+	if err := monitor.ReadJSON(&event); err != nil || event.Type != "marker" {
+		t.Fatalf("Expected the monitor to skip the script's response and get the marker, got %+v (%v)", event, err)
+	}
+	if err := script.ReadJSON(&event); err != nil || event.Type != "marker" {
+		t.Fatalf("Expected the script to get the marker, got %+v (%v)", event, err)
+	}
+
+	monitor.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for clientCount(s) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the closed client to be removed, still %d clients", clientCount(s))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.SendEvent(BleEvent{Type: "connected"})
+	if err := script.ReadJSON(&event); err != nil || event.Type != "connected" {
+		t.Errorf("Expected the remaining client to get events, got %+v (%v)", event, err)
+	}
+}
+
+// TestEventsAPIInjectsQualifyingEvents verifies POST /api/events/{eventType}
+// rejects unknown event types, reports a send failure, and returns the
+// packet sent (none for an alert held back by quiet hours)
+func TestEventsAPIInjectsQualifyingEvents(t *testing.T) {
+	pumpState := state.NewPumpState()
+	s := New(nil)
+	s.SetQualifyingEventsNotifier(handler.NewQualifyingEventsNotifier(&bluetooth.Ble{}, pumpState))
+
+	rec := httptest.NewRecorder()
+	s.handleEventsAPI(rec, httptest.NewRequest(http.MethodPost, "/api/events/noSuchEvent", nil))
+	assertJSONError(t, rec, http.StatusBadRequest)
+
+	rec = httptest.NewRecorder()
+	s.handleEventsAPI(rec, httptest.NewRequest(http.MethodGet, "/api/events/batteryLow", nil))
+	assertJSONError(t, rec, http.StatusMethodNotAllowed)
+
+	// No central is subscribed to QualifyingEvents
+	rec = httptest.NewRecorder()
+	s.handleEventsAPI(rec, httptest.NewRequest(http.MethodPost, "/api/events/batteryLow", strings.NewReader(`{"percentage": 5}`)))
+	assertJSONError(t, rec, http.StatusInternalServerError)
+
+	pumpState.SetQuietHours(&state.QuietHours{}) // all day
+	rec = httptest.NewRecorder()
+	s.handleEventsAPI(rec, httptest.NewRequest(http.MethodPost, "/api/events/alert", strings.NewReader(`{"priority": 1, "message": "Low insulin"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if body["eventType"] != "alert" || body["packet"] != "" || body["suppressed"] != true {
+		t.Errorf("Expected a suppressed alert with no packet, got %v", body)
+	}
+}
+
+// newDumpTestServer returns a server with pump state, default settings and
+// diagnostics sources set, as main sets them up
+func newDumpTestServer() *Server {
+	s := New(&bluetooth.Ble{})
+	s.SetPumpState(state.NewPumpState())
+	manager := settings.NewManager()
+	settings.RegisterDefaults(manager)
+	s.SetSettingsManager(manager)
+	s.SetBridge(pumpx2.NewBridgeWithRunner(mockrunner.New()))
+	s.SetReassembler(protocol.NewLazyReassembler(time.Minute, time.Now))
+	return s
+}
+
+// getStateDump fetches GET /api/state/dump from s
+func getStateDump(t *testing.T, s *Server) (*httptest.ResponseRecorder, stateDump) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleStateDumpAPI(rec, httptest.NewRequest(http.MethodGet, "/api/state/dump", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var dump stateDump
+	if err := json.Unmarshal(rec.Body.Bytes(), &dump); err != nil {
+		t.Fatalf("Response is not a state dump: %v", err)
+	}
+	return rec, dump
+}
+
+// TestStateDumpHasSections verifies the dump is a download holding the pump
+// state, settings configs and diagnostics
+func TestStateDumpHasSections(t *testing.T) {
+	s := newDumpTestServer()
+	rec, dump := getStateDump(t, s)
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("Expected the dump to download as an attachment, got %q", rec.Header().Get("Content-Disposition"))
+	}
+	if dump.Version != stateDumpVersion || dump.State == nil {
+		t.Fatalf("Expected a version %d dump with state, got %+v", stateDumpVersion, dump)
+	}
+	if dump.State.Identity.SerialNumber != "11223344" || dump.State.Live.Battery != s.pumpState.GetBatteryLevel() {
+		t.Errorf("Unexpected state section: %+v", dump.State)
+	}
+	if len(dump.Settings) == 0 || len(dump.Settings) != len(s.settingsManager.GetAllConfigs()) {
+		t.Errorf("Expected every settings config, got %d", len(dump.Settings))
+	}
+	for _, section := range []string{"emulator", "reassembler", "parseFailures", "bridgeLog"} {
+		if _, ok := dump.Diagnostics[section]; !ok {
+			t.Errorf("Expected diagnostics to include %s, got %v", section, dump.Diagnostics)
+		}
+	}
+}
+
+// TestStateDumpLeavesOutParseFailureHex verifies failing packets are counted
+// in the dump without their hex
+func TestStateDumpLeavesOutParseFailureHex(t *testing.T) {
+	s := newDumpTestServer()
+	if _, err := s.bridge.ParseReceivedMessage(bluetooth.CharControl, []string{"00ff20ffdeadbeef"}); err == nil {
+		t.Fatal("Expected the message to fail to parse")
+	}
+
+	rec, _ := getStateDump(t, s)
+	if body := rec.Body.String(); strings.Contains(body, "deadbeef") || !strings.Contains(body, `"total":1`) {
+		t.Errorf("Expected the failure counted without its hex, got %s", body)
+	}
+}
+
+// TestStateDumpReimports verifies a dump imported into a fresh emulator
+// reproduces its state, alerts and settings
+func TestStateDumpReimports(t *testing.T) {
+	src := newDumpTestServer()
+	src.pumpState.SetBatteryLevel(15)
+	src.pumpState.SetReservoirLevel(42.5)
+	src.pumpState.SetPumpingSuspended(true)
+	src.pumpState.SetBasalState(&state.BasalState{CurrentRate: 1.1, TempBasalActive: true, TempBasalRate: 0.55, TempBasalPercent: 50})
+	if err := src.pumpState.StartBolus(2.0, 42); err != nil {
+		t.Fatalf("StartBolus failed: %v", err)
+	}
+	if err := src.pumpState.StartBolus(1.0, 43); err != nil {
+		t.Fatalf("StartBolus failed: %v", err)
+	}
+	src.pumpState.UpdateBolusDelivery(0.5)
+	src.pumpState.SetCurrentEGV(180)
+	src.pumpState.AddHistoryLogEntryWithTypeID(state.HistoryBolusActivated, "BolusActivated", map[string]interface{}{"bolusId": 42})
+	therapy := src.pumpState.GetTherapyConfig()
+	therapy.MaxBolus = 10
+	if err := src.pumpState.SetTherapyConfig(therapy); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+	alert := src.pumpState.AddAlert(state.Alert{Type: state.AlertLowBattery, Message: "Low battery"})
+	if err := src.settingsManager.SetConfig("PumpVersionRequest", &settings.ResponseConfig{
+		Mode: settings.ModeConstant, Value: map[string]interface{}{"serialNum": 90817263},
+	}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	rec, dump := getStateDump(t, src)
+
+	dst := newDumpTestServer()
+	put := httptest.NewRecorder()
+	dst.handleStateDumpAPI(put, httptest.NewRequest(http.MethodPut, "/api/state/dump", strings.NewReader(rec.Body.String())))
+	if put.Code != http.StatusOK {
+		t.Fatalf("Expected the dump to import, got %d: %s", put.Code, put.Body.String())
+	}
+
+	_, reimported := getStateDump(t, dst)
+	want, _ := json.Marshal(dump.State)
+	got, _ := json.Marshal(reimported.State)
+	if string(got) != string(want) {
+		t.Errorf("Expected imported state\n%s\ngot\n%s", want, got)
+	}
+	if boluses := dst.pumpState.GetActiveBoluses(); len(boluses) != 2 || boluses[0].UnitsDelivered != 0.5 || boluses[1].BolusID != 43 {
+		t.Errorf("Expected the delivering and stacked boluses to be restored, got %+v", boluses)
+	}
+	if dst.pumpState.GetBasalRate() != 0.55 || dst.pumpState.GetHistoryLogCount() != src.pumpState.GetHistoryLogCount() {
+		t.Errorf("Expected the temp rate and history to be restored")
+	}
+	if alerts := dst.pumpState.GetActiveAlerts(); len(alerts) != 1 || alerts[0].ID != alert.ID {
+		t.Errorf("Expected alert %d to be restored, got %+v", alert.ID, alerts)
+	}
+	config, err := dst.settingsManager.GetConfig("PumpVersionRequest")
+	if err != nil || config.Value["serialNum"] != 90817263.0 {
+		t.Errorf("Expected the settings config to be restored, got %+v (err=%v)", config, err)
+	}
+}
+
+// TestStateDumpImportRejectsInvalidDump verifies a dump with a bad version
+// or an invalid section fails without changing anything
+func TestStateDumpImportRejectsInvalidDump(t *testing.T) {
+	s := newDumpTestServer()
+	_, dump := getStateDump(t, s)
+	battery := s.pumpState.GetBatteryLevel()
+
+	badVersion := dump
+	badVersion.Version = stateDumpVersion + 1
+	badState := dump
+	live := *dump.State
+	live.Live.Battery = 500
+	badState.State = &live
+	for _, bad := range []stateDump{badVersion, badState} {
+		body, _ := json.Marshal(bad)
+		rec := httptest.NewRecorder()
+		s.handleStateDumpAPI(rec, httptest.NewRequest(http.MethodPut, "/api/state/dump", strings.NewReader(string(body))))
+		assertJSONError(t, rec, http.StatusBadRequest)
+	}
+	if got := s.pumpState.GetBatteryLevel(); got != battery {
+		t.Errorf("Expected battery to be unchanged at %d, got %d", battery, got)
+	}
+}
+
+// TestDumpStateCommand verifies the dumpState JSON-RPC method returns the
+// dump, including in read-only mode
+func TestDumpStateCommand(t *testing.T) {
+	s := newDumpTestServer()
+	s.SetReadOnly(true)
+
+	resp, ok := s.jsonRPCResponse([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "dumpState"}`))
+	r, isResp := resp.(rpcResponse)
+	if !ok || !isResp || r.Error != nil {
+		t.Fatalf("Expected a dumpState result, got %+v", resp)
+	}
+	dump, isDump := r.Result.(stateDump)
+	if !isDump || dump.State == nil || dump.Settings == nil || dump.Diagnostics == nil {
+		t.Errorf("Expected a dump with every section, got %+v", r.Result)
+	}
+}
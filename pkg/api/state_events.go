@@ -0,0 +1,9 @@
+package api
+
+import "github.com/jwoglom/faketandem/pkg/state"
+
+// StateChangeNotifier returns a notifier to subscribe to the pump's event bus
+// that forwards pump state changes to websocket clients as stateChange events
+func (s *Server) StateChangeNotifier() state.FieldNotifier {
+	return state.FieldNotifier(s.SendStateChange)
+}
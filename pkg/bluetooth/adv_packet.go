@@ -0,0 +1,82 @@
+package bluetooth
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// BLE advertising data (EIR) field types
+const (
+	advTypeFlags            = 0x01
+	advTypeSomeUUID16       = 0x02
+	advTypeAllUUID16        = 0x03
+	advTypeSomeUUID128      = 0x06
+	advTypeShortName        = 0x08
+	advTypeCompleteName     = 0x09
+	advTypeTxPower          = 0x0A
+	advTypeManufacturerData = 0xFF
+)
+
+// AdvData holds the fields decoded from an advertising or scan response
+// packet. Fields absent from the packet are left at their zero value.
+type AdvData struct {
+	Flags            byte
+	UUID16s          []uint16
+	TxPower          int8
+	ManufacturerID   uint16
+	ManufacturerData []byte // after the 2-byte manufacturer ID
+	Name             string
+}
+
+// ParseAdvPacket decodes the [len][type][data...] fields of an advertising or
+// scan response packet. Decoding stops at a zero length byte, so the
+// zero-padded 31 byte form is accepted; unknown field types are skipped.
+func ParseAdvPacket(packet []byte) (*AdvData, error) {
+	adv := &AdvData{}
+	for i := 0; i < len(packet); {
+		fieldLen := int(packet[i])
+		if fieldLen == 0 {
+			break
+		}
+		if i+1+fieldLen > len(packet) {
+			return nil, fmt.Errorf("advertising field at offset %d overruns packet: length %d, %d bytes left", i, fieldLen, len(packet)-i-1)
+		}
+		if err := adv.parseField(packet[i+1], packet[i+2:i+1+fieldLen]); err != nil {
+			return nil, fmt.Errorf("advertising field at offset %d: %w", i, err)
+		}
+		i += 1 + fieldLen
+	}
+	return adv, nil
+}
+
+// parseField decodes one field's data into adv
+func (adv *AdvData) parseField(fieldType byte, data []byte) error {
+	switch fieldType {
+	case advTypeFlags:
+		if len(data) != 1 {
+			return fmt.Errorf("flags must be 1 byte, got %d", len(data))
+		}
+		adv.Flags = data[0]
+	case advTypeSomeUUID16, advTypeAllUUID16:
+		if len(data)%2 != 0 {
+			return fmt.Errorf("16-bit UUID list has odd length %d", len(data))
+		}
+		for j := 0; j < len(data); j += 2 {
+			adv.UUID16s = append(adv.UUID16s, binary.LittleEndian.Uint16(data[j:]))
+		}
+	case advTypeTxPower:
+		if len(data) != 1 {
+			return fmt.Errorf("TX power must be 1 byte, got %d", len(data))
+		}
+		adv.TxPower = int8(data[0])
+	case advTypeManufacturerData:
+		if len(data) < 2 {
+			return fmt.Errorf("manufacturer data too short for an ID: %d bytes", len(data))
+		}
+		adv.ManufacturerID = binary.LittleEndian.Uint16(data)
+		adv.ManufacturerData = append([]byte(nil), data[2:]...)
+	case advTypeShortName, advTypeCompleteName:
+		adv.Name = string(data)
+	}
+	return nil
+}
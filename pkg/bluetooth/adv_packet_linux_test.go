@@ -0,0 +1,61 @@
+package bluetooth
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/paypal/gatt"
+)
+
+// TestAdvertisingPacketsRoundTrip verifies the assembled advertising and scan
+// response packets decode to the expected fields for every pairing state
+func TestAdvertisingPacketsRoundTrip(t *testing.T) {
+	tests := []struct {
+		state    PairingState
+		flags    byte
+		lastByte byte
+	}{
+		{PairingStateNotDiscoverable, 0x04, 0x10},
+		{PairingStateDiscoverableOnly, 0x06, 0x10},
+		{PairingStatePairStep1, 0x06, 0x11},
+		{PairingStatePairStep2, 0x06, 0x12},
+	}
+	for _, tt := range tests {
+		advPacket, scanPacket := advertisingPackets(tt.state, pumpName, gatt.UUID16(0xFDFB))
+
+		advBytes := advPacket.Bytes()
+		adv, err := ParseAdvPacket(advBytes[:])
+		if err != nil {
+			t.Fatalf("%s: ParseAdvPacket(adv) failed: %v", tt.state, err)
+		}
+		if adv.Flags != tt.flags {
+			t.Errorf("%s: expected flags 0x%02x, got 0x%02x", tt.state, tt.flags, adv.Flags)
+		}
+		if len(adv.UUID16s) != 1 || adv.UUID16s[0] != 0xFDFB {
+			t.Errorf("%s: expected UUIDs [0xFDFB], got %x", tt.state, adv.UUID16s)
+		}
+		if adv.TxPower != 4 {
+			t.Errorf("%s: expected TX power 4, got %d", tt.state, adv.TxPower)
+		}
+		if adv.ManufacturerID != 0x059D || !bytes.Equal(adv.ManufacturerData, []byte{0x00, 0x01, tt.lastByte}) {
+			t.Errorf("%s: expected manufacturer 0x059D data 0001%02x, got 0x%04x %x", tt.state, tt.lastByte, adv.ManufacturerID, adv.ManufacturerData)
+		}
+
+		scanBytes := scanPacket.Bytes()
+		scan, err := ParseAdvPacket(scanBytes[:scanPacket.Len()])
+		if err != nil {
+			t.Fatalf("%s: ParseAdvPacket(scan) failed: %v", tt.state, err)
+		}
+		if scan.Name != pumpName {
+			t.Errorf("%s: expected name %q, got %q", tt.state, pumpName, scan.Name)
+		}
+	}
+}
+
+// TestParseAdvPacketRejectsTruncatedField verifies a field running past the
+// end of the packet is an error
+func TestParseAdvPacketRejectsTruncatedField(t *testing.T) {
+	if _, err := ParseAdvPacket([]byte{0x02, 0x01, 0x06, 0x05, 0xFF, 0x9D}); err == nil {
+		t.Error("Expected error for truncated manufacturer data field")
+	}
+}
@@ -1,8 +1,17 @@
 package bluetooth
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Service UUID for the Tandem pump
 const (
 	PumpServiceUUID = "0000fdfb-0000-1000-8000-00805f9b34fb"
+
+	// PumpServiceUUID16 is the short form the pump service is registered
+	// and advertised under
+	PumpServiceUUID16 = "FDFB"
 )
 
 // Standard service UUIDs.
@@ -52,25 +61,16 @@ const (
 	CharAuthorization
 	CharControl
 	CharControlStream
+
+	// numCharacteristicTypes counts the types above; keep it last
+	numCharacteristicTypes
 )
 
 func (c CharacteristicType) String() string {
-	switch c {
-	case CharCurrentStatus:
-		return "CurrentStatus"
-	case CharQualifyingEvents:
-		return "QualifyingEvents"
-	case CharHistoryLog:
-		return "HistoryLog"
-	case CharAuthorization:
-		return "Authorization"
-	case CharControl:
-		return "Control"
-	case CharControlStream:
-		return "ControlStream"
-	default:
-		return "Unknown"
+	if info, ok := c.Info(); ok {
+		return info.Name
 	}
+	return "Unknown"
 }
 
 // ToBtChar returns the pumpX2 cliparser Characteristic enum constant name for
@@ -83,20 +83,55 @@ func (c CharacteristicType) String() string {
 // enum constant for (QualifyingEvents is notify-only and never parsed), so
 // the caller knows not to set the environment variable at all.
 func (c CharacteristicType) ToBtChar() string {
-	switch c {
-	case CharCurrentStatus:
-		return "CURRENT_STATUS"
-	case CharHistoryLog:
-		return "HISTORY_LOG"
-	case CharAuthorization:
-		return "AUTHORIZATION"
-	case CharControl:
-		return "CONTROL"
-	case CharControlStream:
-		return "CONTROL_STREAM"
-	default:
-		return ""
+	info, _ := c.Info()
+	return info.BtChar
+}
+
+// ServiceConfig holds the UUIDs the pump service and its characteristics are
+// registered under, so a pump variant with different UUIDs can be emulated.
+// Characteristics are keyed by type, so String and ToBtChar are unaffected
+// by the UUIDs chosen.
+type ServiceConfig struct {
+	ServiceUUID string
+	CharUUIDs   map[CharacteristicType]string
+}
+
+// DefaultServiceConfig returns the UUIDs of a real Tandem pump
+func DefaultServiceConfig() ServiceConfig {
+	charUUIDs := make(map[CharacteristicType]string, len(characteristicTable))
+	for _, info := range characteristicTable {
+		charUUIDs[info.Type] = info.UUID
 	}
+	return ServiceConfig{
+		ServiceUUID: PumpServiceUUID16,
+		CharUUIDs:   charUUIDs,
+	}
+}
+
+// Validate returns an error unless the service and every pump characteristic
+// have a distinct 16 or 128-bit UUID
+func (c ServiceConfig) Validate() error {
+	if !validServiceUUID(c.ServiceUUID) {
+		return fmt.Errorf("invalid pump service UUID %q", c.ServiceUUID)
+	}
+	seen := map[string]CharacteristicType{}
+	for _, charType := range pumpCharacteristics {
+		uuid := c.CharUUIDs[charType]
+		if !validServiceUUID(uuid) {
+			return fmt.Errorf("invalid %s characteristic UUID %q", charType, uuid)
+		}
+		key := normalizeUUID(uuid)
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("%s and %s characteristics share UUID %s", other, charType, uuid)
+		}
+		seen[key] = charType
+	}
+	return nil
+}
+
+// normalizeUUID lowercases a UUID and strips its dashes for comparison
+func normalizeUUID(uuid string) string {
+	return strings.ToLower(strings.Replace(uuid, "-", "", -1))
 }
 
 // WriteHandler is called when data is written to a characteristic
@@ -107,3 +142,7 @@ type ReadHandler func(charType CharacteristicType) []byte
 
 // ConnectionHandler is called when a central device connects or disconnects
 type ConnectionHandler func(connected bool)
+
+// AdvertisingHandler is called with the advertising and scan response data
+// each time the pump starts or updates advertising
+type AdvertisingHandler func(advData, scanData []byte)
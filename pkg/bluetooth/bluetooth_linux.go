@@ -3,7 +3,6 @@
 package bluetooth
 
 import (
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"strings"
@@ -15,15 +14,18 @@ import (
 )
 
 const (
-	advTypeSomeUUID16 = 0x02
-	advTypeTxPower    = 0x0A
-	pumpName          = "Tandem Mobi 123"
+	pumpName = "Tandem Mobi 123"
 )
 
 // Ble represents the Bluetooth Low Energy device
 type Ble struct {
-	device  *gatt.Device
-	central *gatt.Central
+	device *gatt.Device
+
+	// The connected central, and whether advertising is paused while it's
+	// connected
+	central           *gatt.Central
+	advertisingPaused bool
+	centralMtx        sync.Mutex
 
 	// Notifiers for each characteristic
 	notifiers    map[CharacteristicType]gatt.Notifier
@@ -42,10 +44,35 @@ type Ble struct {
 	unknownWriteOnlyChars   map[string]*gatt.Characteristic
 
 	// Handlers
+	linkSecurity      linkSecurity
+	reconnectGuard    reconnectGuard
+	multiCentral      multiCentral
+	connectSetup      connectSetup
+	writeValidators   writeValidators
 	writeHandler      WriteHandler
 	readHandler       ReadHandler
 	connectionHandler ConnectionHandler
 
+	advertisingHandler    AdvertisingHandler
+	advertisingHandlerMtx sync.RWMutex
+
+	// Connection tracking
+	connLog       connectionLog
+	idle          idleTimer
+	subscriptions subscriptions
+
+	// Delay between notifications, per characteristic
+	pacing notifyPacing
+
+	// Characteristics sent as indications rather than notifications
+	indications indicateChars
+
+	// Auxiliary services registered alongside the pump service
+	services []AuxService
+
+	// UUIDs of the pump service and its characteristics
+	serviceConfig ServiceConfig
+
 	// Pairing state
 	pairingState    PairingState
 	pairingStateMtx sync.RWMutex
@@ -54,7 +81,10 @@ type Ble struct {
 
 // DefaultServerOptions contains the default options for the BLE server on Linux
 var DefaultServerOptions = []gatt.Option{
-	gatt.LnxMaxConnections(1),
+	// gatt stops advertising at the connection limit, so allow a second
+	// connection for the replace policy to accept. Under the reject policy
+	// advertising is paused while a central is connected instead.
+	gatt.LnxMaxConnections(2),
 	gatt.LnxDeviceID(-1, true),
 	gatt.LnxSetAdvertisingParameters(&cmd.LESetAdvertisingParameters{
 		AdvertisingIntervalMin: 0x00f4,
@@ -63,56 +93,36 @@ var DefaultServerOptions = []gatt.Option{
 	}),
 }
 
-// New creates a new BLE device with the Tandem pump service
-func New(adapterID string) (*Ble, error) {
+// New creates a new BLE device with the Tandem pump service under the UUIDs
+// in serviceConfig (nil uses DefaultServiceConfig) and the given auxiliary
+// services (nil registers DefaultAuxServices), advertising in pairingState
+// from startup ("" starts not discoverable). advertisingHandler, if not nil,
+// sees the advertising data from the first advertisement on.
+func New(adapterID string, services []AuxService, serviceConfig *ServiceConfig, pairingState PairingState, advertisingHandler AdvertisingHandler) (*Ble, error) {
+	if services == nil {
+		services = DefaultAuxServices
+	}
+	if serviceConfig == nil {
+		defaults := DefaultServiceConfig()
+		serviceConfig = &defaults
+	}
+	if err := serviceConfig.Validate(); err != nil {
+		return nil, err
+	}
+
 	d, err := gatt.NewDevice(DefaultServerOptions...)
 	if err != nil {
 		log.Fatalf("pkg bluetooth; failed to open device, err: %s", err)
 		return nil, err
 	}
 
-	b := &Ble{
-		device:        &d,
-		notifiers:     make(map[CharacteristicType]gatt.Notifier),
-		charData:      make(map[CharacteristicType][]byte),
-		extraCharData: make(map[string][]byte),
-		pairingState:  PairingStateNotDiscoverable,
-		writeNotifyChars:       make(map[CharacteristicType]*gatt.Characteristic),
-		notifyOnlyChars:        make(map[CharacteristicType]*gatt.Characteristic),
-		unknownWriteNotifyChars: make(map[string]*gatt.Characteristic),
-		unknownWriteOnlyChars:   make(map[string]*gatt.Characteristic),
-	}
+	b := newBle(services, *serviceConfig, pairingState)
+	b.device = &d
+	b.advertisingHandler = advertisingHandler
 
 	d.Handle(
-		gatt.CentralConnected(func(c gatt.Central) {
-			fmt.Println("pkg bluetooth; ** New connection from:", c.ID())
-			
-			// Reject connection if not discoverable
-			b.pairingStateMtx.RLock()
-			state := b.pairingState
-			b.pairingStateMtx.RUnlock()
-			
-			if state == PairingStateNotDiscoverable {
-				log.Warnf("pkg bluetooth; rejecting connection from %s - not discoverable", c.ID())
-				if err := c.Close(); err != nil {
-					log.Debugf("Error closing rejected connection: %v", err)
-				}
-				return
-			}
-			
-			b.central = &c
-			b.reenableCharacteristicHandlers()
-			if b.connectionHandler != nil {
-				b.connectionHandler(true)
-			}
-		}),
-		gatt.CentralDisconnected(func(c gatt.Central) {
-			log.Debugf("pkg bluetooth; ** disconnect: %s", c.ID())
-			b.central = nil
-			if b.connectionHandler != nil {
-				b.connectionHandler(false)
-			}
-		}),
+		gatt.CentralConnected(b.onCentralConnected),
+		gatt.CentralDisconnected(b.onCentralDisconnected),
 	)
 
 	// Handler for when the device is powered on
@@ -134,48 +144,247 @@ func New(adapterID string) (*Ble, error) {
 	return b, nil
 }
 
-// setupService creates the pump service and all characteristics
-func (b *Ble) setupService(d gatt.Device) {
-	b.pumpNameForAdv = pumpName
+// newBle creates a Ble with no device yet, starting in pairingState (""
+// starts not discoverable)
+func newBle(services []AuxService, serviceConfig ServiceConfig, pairingState PairingState) *Ble {
+	if pairingState == "" {
+		pairingState = PairingStateNotDiscoverable
+	}
+	return &Ble{
+		notifiers:               make(map[CharacteristicType]gatt.Notifier),
+		charData:                make(map[CharacteristicType][]byte),
+		extraCharData:           make(map[string][]byte),
+		pairingState:            pairingState,
+		services:                services,
+		serviceConfig:           serviceConfig,
+		writeNotifyChars:        make(map[CharacteristicType]*gatt.Characteristic),
+		notifyOnlyChars:         make(map[CharacteristicType]*gatt.Characteristic),
+		unknownWriteNotifyChars: make(map[string]*gatt.Characteristic),
+		unknownWriteOnlyChars:   make(map[string]*gatt.Characteristic),
+	}
+}
 
-	// Registration order and UUID form (16-bit vs 128-bit) here match a btsnoop
-	// capture of a real Tandem Mobi pairing exactly: Generic Access, Generic
-	// Attribute, Device Information, then the Tandem Pump service, then the
-	// unknown FDFA service, with FDFB/FDFA both declared as short 16-bit UUIDs
-	// (0xFDFB is a Bluetooth SIG-assigned 16-bit UUID for Tandem Diabetes Care).
-	// A real client's single "Read By Group Type" service-discovery request
-	// combines all five services into one response only when they share the
-	// same UUID length, since ATT can't mix UUID lengths within one PDU.
-	b.addGenericAccessService(d)
-	b.addGenericAttributeService(d)
-	b.addDeviceInformationService(d)
-
-	serviceUUID := gatt.UUID16(0xFDFB)
-	s := gatt.NewService(serviceUUID)
+// onCentralConnected accepts a connecting central unless the pump isn't
+// discoverable, the central is reconnecting too quickly, or another central
+// is connected and the multi-central policy rejects it
+func (b *Ble) onCentralConnected(c gatt.Central) {
+	fmt.Println("pkg bluetooth; ** New connection from:", c.ID())
 
-	// Add all characteristics
-	b.addWriteNotifyCharacteristic(s, CurrentStatusCharUUID, CharCurrentStatus)
-	b.addWriteNotifyCharacteristic(s, QualifyingEventsCharUUID, CharQualifyingEvents)
-	b.addNotifyOnlyCharacteristic(s, HistoryLogCharUUID, CharHistoryLog)
-	b.addWriteNotifyCharacteristic(s, AuthorizationCharUUID, CharAuthorization)
-	b.addWriteNotifyCharacteristic(s, ControlCharUUID, CharControl)
-	b.addWriteNotifyCharacteristic(s, ControlStreamCharUUID, CharControlStream)
+	// Reject connection if not discoverable
+	b.pairingStateMtx.RLock()
+	state := b.pairingState
+	b.pairingStateMtx.RUnlock()
 
-	err := d.AddService(s)
-	if err != nil {
-		log.Fatalf("pkg bluetooth; could not add service: %s", err)
+	if state == PairingStateNotDiscoverable {
+		log.Warnf("pkg bluetooth; rejecting connection from %s - not discoverable", c.ID())
+		if err := c.Close(); err != nil {
+			log.Debugf("Error closing rejected connection: %v", err)
+		}
+		return
+	}
+
+	if !b.reconnectGuard.allow(c.ID()) {
+		log.Warnf("pkg bluetooth; rejecting connection from %s - reconnected within min reconnect interval", c.ID())
+		if err := c.Close(); err != nil {
+			log.Debugf("Error closing rejected connection: %v", err)
+		}
+		return
+	}
+
+	b.centralMtx.Lock()
+	previous := b.central
+	replace := b.multiCentral.replaces()
+	if previous != nil && !replace {
+		b.centralMtx.Unlock()
+		log.Warnf("pkg bluetooth; rejecting connection from %s - %s is already connected", c.ID(), (*previous).ID())
+		if err := c.Close(); err != nil {
+			log.Debugf("Error closing rejected connection: %v", err)
+		}
+		return
+	}
+	b.central = &c
+	// A real pump stops advertising once connected; only keep advertising
+	// when a second central is allowed to replace this one
+	b.advertisingPaused = !replace
+	b.centralMtx.Unlock()
+
+	if !replace {
+		b.setAdvertisingEnabled(false)
+	}
+
+	if previous != nil {
+		// Tear the previous central down now, so its disconnect callback,
+		// which may arrive after c is connected, is ignored
+		current := *previous
+		log.Warnf("pkg bluetooth; replacing connected central %s with %s", current.ID(), c.ID())
+		b.connLog.disconnecting(DisconnectRequested)
+		b.clearCentral(current)
+		if err := current.Close(); err != nil {
+			log.Debugf("Error closing replaced central connection: %v", err)
+		}
+	}
+
+	b.connLog.connected(c.ID())
+	b.idle.touch()
+	b.reenableCharacteristicHandlers()
+	b.connectSetup.begin(func() {
+		if b.connectionHandler != nil {
+			b.connectionHandler(true)
+		}
+	})
+}
+
+// onCentralDisconnected clears the connection state of a departed central,
+// ignoring centrals that were rejected or already replaced, and resumes
+// advertising if it was paused for the connection
+func (b *Ble) onCentralDisconnected(c gatt.Central) {
+	b.centralMtx.Lock()
+	if b.central == nil || *b.central != c {
+		b.centralMtx.Unlock()
+		log.Debugf("pkg bluetooth; ** disconnect: %s (not the connected central)", c.ID())
+		return
+	}
+	b.central = nil
+	paused := b.advertisingPaused
+	b.advertisingPaused = false
+	b.centralMtx.Unlock()
+
+	b.clearCentral(c)
+	if paused {
+		b.setAdvertisingEnabled(true)
+	}
+}
+
+// clearCentral clears the connection state of central c, which is no longer
+// b.central
+func (b *Ble) clearCentral(c gatt.Central) {
+	reason := b.connLog.disconnected(c.ID())
+	log.Debugf("pkg bluetooth; ** disconnect: %s (%s)", c.ID(), reason)
+	// A central that left during setup was never reported as connected, so
+	// its disconnect isn't reported either
+	setupPending := b.connectSetup.cancel()
+	b.linkSecurity.setEncrypted(false)
+	b.subscriptions.reset()
+	b.reconnectGuard.disconnected(c.ID())
+	if b.connectionHandler != nil && !setupPending {
+		b.connectionHandler(false)
+	}
+}
+
+// connectedCentral returns the connected central, or nil if none
+func (b *Ble) connectedCentral() gatt.Central {
+	b.centralMtx.Lock()
+	defer b.centralMtx.Unlock()
+	if b.central == nil {
+		return nil
+	}
+	return *b.central
+}
+
+// isAdvertisingPaused returns true while advertising is paused for a
+// connected central
+func (b *Ble) isAdvertisingPaused() bool {
+	b.centralMtx.Lock()
+	defer b.centralMtx.Unlock()
+	return b.advertisingPaused
+}
+
+// setAdvertisingEnabled turns advertising on or off, if the device is open
+func (b *Ble) setAdvertisingEnabled(enabled bool) {
+	if b.device == nil {
+		return
+	}
+	if err := (*b.device).Option(gatt.LnxSetAdvertisingEnable(enabled)); err != nil {
+		log.Debugf("Error setting advertising enabled to %v: %v", enabled, err)
 	}
+}
 
-	b.addUnknownServiceFDFA(d)
+// setupService creates the pump service and all characteristics
+func (b *Ble) setupService(d gatt.Device) {
+	b.pumpNameForAdv = pumpName
+
+	b.registerServices(d)
 
-	err = b.advertisePump(d, pumpName)
+	err := b.advertisePump(d, pumpName)
 	if err != nil {
 		log.Fatalf("pkg bluetooth; could not advertise: %s", err)
 	}
 
 	log.Info("pkg bluetooth; Pump service is now advertising")
-	log.Info("pkg bluetooth; Service UUID:", PumpServiceUUID)
-	log.Info("pkg bluetooth; Ready for connections (discoverable: false)")
+	log.Info("pkg bluetooth; Service UUID:", b.pumpServiceConfig().ServiceUUID)
+	log.Infof("pkg bluetooth; Ready for connections (pairing state: %s)", b.GetPairingState())
+}
+
+// registerServices registers the configured auxiliary services around the
+// pump service.
+//
+// Registration order and UUID form (16-bit vs 128-bit) by default match a
+// btsnoop capture of a real Tandem Mobi pairing exactly: Generic Access, Generic
+// Attribute, Device Information, then the Tandem Pump service, then the
+// unknown FDFA service, with FDFB/FDFA both declared as short 16-bit UUIDs
+// (0xFDFB is a Bluetooth SIG-assigned 16-bit UUID for Tandem Diabetes Care).
+// A real client's single "Read By Group Type" service-discovery request
+// combines all five services into one response only when they share the
+// same UUID length, since ATT can't mix UUID lengths within one PDU.
+func (b *Ble) registerServices(d gatt.Device) {
+	for _, service := range b.services {
+		if service.registeredBeforePump() {
+			b.addAuxService(d, service)
+		}
+	}
+
+	cfg := b.pumpServiceConfig()
+	s := gatt.NewService(gatt.MustParseUUID(cfg.ServiceUUID))
+
+	// Add all characteristics; HistoryLog is the only notify-only one. Every
+	// characteristic supports both notify and indicate; which one is sent is
+	// set per characteristic with SetIndicate.
+	for _, charType := range pumpCharacteristics {
+		if b.indications.uses(charType) {
+			log.Debugf("pkg bluetooth; %s sends indications", charType)
+		}
+		if charType == CharHistoryLog {
+			b.addNotifyOnlyCharacteristic(s, cfg.CharUUIDs[charType], charType)
+		} else {
+			b.addWriteNotifyCharacteristic(s, cfg.CharUUIDs[charType], charType)
+		}
+	}
+
+	if err := d.AddService(s); err != nil {
+		log.Fatalf("pkg bluetooth; could not add service: %s", err)
+	}
+
+	for _, service := range b.services {
+		if !service.registeredBeforePump() {
+			b.addAuxService(d, service)
+		}
+	}
+}
+
+// pumpServiceConfig returns the configured pump service UUIDs, or the
+// defaults if none were configured
+func (b *Ble) pumpServiceConfig() ServiceConfig {
+	if b.serviceConfig.ServiceUUID == "" {
+		return DefaultServiceConfig()
+	}
+	return b.serviceConfig
+}
+
+// addAuxService registers a named auxiliary service, or an empty primary
+// service for a custom UUID
+func (b *Ble) addAuxService(d gatt.Device, service AuxService) {
+	switch service {
+	case ServiceGenericAccess:
+		b.addGenericAccessService(d)
+	case ServiceGenericAttribute:
+		b.addGenericAttributeService(d)
+	case ServiceDeviceInformation:
+		b.addDeviceInformationService(d)
+	case ServiceUnknownFDFA:
+		b.addUnknownServiceFDFA(d)
+	default:
+		b.addService(d, gatt.NewService(gatt.MustParseUUID(string(service))), "custom "+string(service))
+	}
 }
 
 func (b *Ble) addGenericAttributeService(d gatt.Device) {
@@ -226,23 +435,74 @@ func (b *Ble) addUnknownServiceFDFA(d gatt.Device) {
 	b.addService(d, s, "Unknown FDFA")
 }
 
+// uuidBytes returns u in the little-endian byte order used on the air
+func uuidBytes(u gatt.UUID) []byte {
+	b, _ := hex.DecodeString(u.String())
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
 func (b *Ble) advertisePump(d gatt.Device, name string) error {
 	b.pairingStateMtx.RLock()
 	state := b.pairingState
 	b.pairingStateMtx.RUnlock()
 
-	advPacket := &gatt.AdvPacket{}
-	
+	serviceUUID := gatt.MustParseUUID(b.pumpServiceConfig().ServiceUUID)
+	advPacket, scanPacket := advertisingPackets(state, name, serviceUUID)
+
+	advData := &cmd.LESetAdvertisingData{
+		AdvertisingDataLength: uint8(advPacket.Len()),
+		AdvertisingData:       advPacket.Bytes(),
+	}
+	scanData := &cmd.LESetScanResponseData{
+		ScanResponseDataLength: uint8(scanPacket.Len()),
+		ScanResponseData:       scanPacket.Bytes(),
+	}
+
+	if err := d.Option(
+		gatt.LnxSetAdvertisingData(advData),
+		gatt.LnxSetScanResponseData(scanData),
+	); err != nil {
+		return err
+	}
+	b.advertisingHandlerMtx.RLock()
+	handler := b.advertisingHandler
+	b.advertisingHandlerMtx.RUnlock()
+	if handler != nil {
+		adv, scan := advPacket.Bytes(), scanPacket.Bytes()
+		handler(adv[:advPacket.Len()], scan[:scanPacket.Len()])
+	}
+
+	// The new data goes out once advertising resumes after the central
+	// disconnects
+	if b.isAdvertisingPaused() {
+		return nil
+	}
+	return d.Option(gatt.LnxSetAdvertisingEnable(true))
+}
+
+// advertisingPackets builds the advertising and scan response packets for
+// state: flags reflect discoverability and the last manufacturer data byte
+// reflects the pairing step. serviceUUID is advertised as the pump service.
+func advertisingPackets(state PairingState, name string, serviceUUID gatt.UUID) (advPacket, scanPacket *gatt.AdvPacket) {
+	advPacket = &gatt.AdvPacket{}
+
 	// Set flags based on discoverable state
 	if state == PairingStateNotDiscoverable {
 		advPacket.AppendFlags(0x04) // BR/EDR Not Supported (not discoverable)
 	} else {
 		advPacket.AppendFlags(0x06) // LE General Discoverable + BR/EDR Not Supported
 	}
-	
-	advPacket.AppendField(advTypeSomeUUID16, uint16ToBytes(0xFDFB))
+
+	if serviceUUID.Len() == 2 {
+		advPacket.AppendField(advTypeSomeUUID16, uuidBytes(serviceUUID))
+	} else {
+		advPacket.AppendField(advTypeSomeUUID128, uuidBytes(serviceUUID))
+	}
 	advPacket.AppendField(advTypeTxPower, []byte{0x04})
-	
+
 	// Set manufacturer data based on pairing state
 	var lastByte byte
 	switch state {
@@ -260,26 +520,10 @@ func (b *Ble) advertisePump(d gatt.Device, name string) error {
 	mfgData := []byte{0x00, 0x01, lastByte}
 	advPacket.AppendManufacturerData(0x059D, mfgData)
 
-	scanPacket := &gatt.AdvPacket{}
+	scanPacket = &gatt.AdvPacket{}
 	scanPacket.AppendName(name)
 
-	advData := &cmd.LESetAdvertisingData{
-		AdvertisingDataLength: uint8(advPacket.Len()),
-		AdvertisingData:       advPacket.Bytes(),
-	}
-	scanData := &cmd.LESetScanResponseData{
-		ScanResponseDataLength: uint8(scanPacket.Len()),
-		ScanResponseData:       scanPacket.Bytes(),
-	}
-
-	if err := d.Option(
-		gatt.LnxSetAdvertisingData(advData),
-		gatt.LnxSetScanResponseData(scanData),
-	); err != nil {
-		return err
-	}
-
-	return d.Option(gatt.LnxSetAdvertisingEnable(true))
+	return advPacket, scanPacket
 }
 
 func (b *Ble) updateAdvertising(d gatt.Device, name string) error {
@@ -296,12 +540,6 @@ func (b *Ble) updateAdvertising(d gatt.Device, name string) error {
 	return nil
 }
 
-func uint16ToBytes(value uint16) []byte {
-	bytes := make([]byte, 2)
-	binary.LittleEndian.PutUint16(bytes, value)
-	return bytes
-}
-
 
 func (b *Ble) addService(d gatt.Device, s *gatt.Service, name string) {
 	if err := d.AddService(s); err != nil {
@@ -382,29 +620,95 @@ func (b *Ble) addReadWriteCharacteristic(s *gatt.Service, uuidStr string, initia
 
 func (b *Ble) bindWriteNotifyHandlers(char *gatt.Characteristic, charType CharacteristicType) {
 	char.HandleWriteFunc(func(r gatt.Request, data []byte) (status byte) {
-		log.Debugf("pkg bluetooth; received write on %s: %s", charType, hex.EncodeToString(data))
-
-		dataCopy := make([]byte, len(data))
-		copy(dataCopy, data)
-
-		if b.writeHandler != nil {
-			b.writeHandler(charType, dataCopy)
+		log.Debugf("pkg bluetooth; received write on %s: %s", charType, LogHex(charType, data))
+		return b.handleWrite(charType, data)
+	})
+	char.HandleReadFunc(func(rsp gatt.ResponseWriter, req *gatt.ReadRequest) {
+		data, status := b.handleRead(charType)
+		if status != StatusSuccess {
+			rsp.SetStatus(status)
+			return
+		}
+		log.Debugf("pkg bluetooth; read request on %s, responding with: %s", charType, LogHex(charType, data))
+		if _, err := rsp.Write(data); err != nil {
+			log.Warnf("Failed to write BLE response: %v", err)
 		}
-		return 0
 	})
 
 	b.bindNotifyHandlers(char, charType)
 }
 
+// handleRead returns the value of charType for a read and its ATT status:
+// the read handler's data if it returns any, otherwise the data set with
+// SetCharacteristicData
+func (b *Ble) handleRead(charType CharacteristicType) ([]byte, byte) {
+	if !b.linkSecurity.allowed() {
+		log.Warnf("pkg bluetooth; refusing read on %s: link is not encrypted", charType)
+		return nil, StatusInsufficientEncryption
+	}
+	if b.readHandler != nil {
+		if data := b.readHandler(charType); data != nil {
+			return data, StatusSuccess
+		}
+	}
+	b.charDataMtx.RLock()
+	defer b.charDataMtx.RUnlock()
+	data := make([]byte, len(b.charData[charType]))
+	copy(data, b.charData[charType])
+	return data, StatusSuccess
+}
+
+// handleWrite validates a write and passes it to the write handler, returning
+// the ATT status to report back to the central
+func (b *Ble) handleWrite(charType CharacteristicType, data []byte) byte {
+	if !b.connectSetup.ready() {
+		log.Warnf("pkg bluetooth; refusing write on %s: connection setup not finished", charType)
+		return StatusUnlikelyError
+	}
+	if !b.linkSecurity.allowed() {
+		log.Warnf("pkg bluetooth; refusing write on %s: link is not encrypted", charType)
+		return StatusInsufficientEncryption
+	}
+	if status, err := b.writeValidators.check(charType, data); err != nil {
+		log.Warnf("pkg bluetooth; refusing write on %s (status 0x%02x): %v", charType, status, err)
+		return status
+	}
+
+	b.idle.touch()
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+
+	if b.writeHandler != nil {
+		b.writeHandler(charType, dataCopy)
+	}
+	return StatusSuccess
+}
+
 func (b *Ble) bindNotifyHandlers(char *gatt.Characteristic, charType CharacteristicType) {
 	char.HandleNotifyFunc(func(r gatt.Request, n gatt.Notifier) {
-		b.notifiersMtx.Lock()
-		b.notifiers[charType] = n
-		b.notifiersMtx.Unlock()
-		log.Infof("pkg bluetooth; notifications enabled for %s from %s", charType, r.Central.ID())
+		b.onSubscribe(charType, r.Central.ID(), n)
 	})
 }
 
+// onSubscribe registers n for notifications on charType, running the
+// subscribe handler if this is the central's first subscription to it.
+// Subscriptions made before a required encrypted link are ignored.
+func (b *Ble) onSubscribe(charType CharacteristicType, centralID string, n gatt.Notifier) {
+	if !b.linkSecurity.allowed() {
+		log.Warnf("pkg bluetooth; ignoring subscription to %s from %s: link is not encrypted", charType, centralID)
+		return
+	}
+	b.notifiersMtx.Lock()
+	b.notifiers[charType] = n
+	b.notifiersMtx.Unlock()
+	log.Infof("pkg bluetooth; notifications enabled for %s from %s", charType, centralID)
+
+	if handler := b.subscriptions.subscribe(charType); handler != nil {
+		handler(charType)
+	}
+}
+
 func (b *Ble) bindUnknownWriteNotifyHandlers(char *gatt.Characteristic, uuidStr string) {
 	char.HandleWriteFunc(func(r gatt.Request, data []byte) (status byte) {
 		log.Debugf("pkg bluetooth; received write on %s: %s", uuidStr, hex.EncodeToString(data))
@@ -458,6 +762,8 @@ func (b *Ble) setExtraCharacteristicData(uuidStr string, data []byte) {
 		return
 	}
 	key := strings.ToLower(uuidStr)
+	b.idle.touch()
+
 	dataCopy := make([]byte, len(data))
 	copy(dataCopy, data)
 	b.extraCharDataMtx.Lock()
@@ -473,6 +779,8 @@ func (b *Ble) getExtraCharacteristicData(uuidStr string) []byte {
 	if data == nil {
 		return nil
 	}
+	b.idle.touch()
+
 	dataCopy := make([]byte, len(data))
 	copy(dataCopy, data)
 	return dataCopy
@@ -483,6 +791,12 @@ func (b *Ble) SetWriteHandler(handler WriteHandler) {
 	b.writeHandler = handler
 }
 
+// SetWriteValidator registers a validator run on every write to charType
+// before the write handler; pass nil to remove it
+func (b *Ble) SetWriteValidator(charType CharacteristicType, fn WriteValidator) {
+	b.writeValidators.set(charType, fn)
+}
+
 // SetReadHandler sets the callback for when data is read from any characteristic
 func (b *Ble) SetReadHandler(handler ReadHandler) {
 	b.readHandler = handler
@@ -493,6 +807,15 @@ func (b *Ble) SetConnectionHandler(handler ConnectionHandler) {
 	b.connectionHandler = handler
 }
 
+// SetAdvertisingHandler sets the callback for when advertising data is set.
+// Advertising starts as soon as the adapter powers on, so pass the handler to
+// New to see the first advertisement.
+func (b *Ble) SetAdvertisingHandler(handler AdvertisingHandler) {
+	b.advertisingHandlerMtx.Lock()
+	defer b.advertisingHandlerMtx.Unlock()
+	b.advertisingHandler = handler
+}
+
 // SetCharacteristicData sets the data that will be returned when a characteristic is read
 func (b *Ble) SetCharacteristicData(charType CharacteristicType, data []byte) {
 	b.charDataMtx.Lock()
@@ -500,37 +823,88 @@ func (b *Ble) SetCharacteristicData(charType CharacteristicType, data []byte) {
 	b.charData[charType] = data
 }
 
-// Notify sends a notification on the specified characteristic
-func (b *Ble) Notify(charType CharacteristicType, data []byte) error {
+// notifier returns the open notifier the central subscribed to charType with
+func (b *Ble) notifier(charType CharacteristicType) (gatt.Notifier, error) {
 	b.notifiersMtx.Lock()
 	notifier, exists := b.notifiers[charType]
 	b.notifiersMtx.Unlock()
 
 	if !exists || notifier == nil {
-		return fmt.Errorf("no notifier registered for %s", charType)
+		return nil, fmt.Errorf("no notifier registered for %s", charType)
 	}
 
 	if notifier.Done() {
-		return fmt.Errorf("notifier for %s is closed", charType)
+		return nil, fmt.Errorf("notifier for %s is closed", charType)
 	}
+	return notifier, nil
+}
 
-	log.Debugf("pkg bluetooth; sending notification on %s: %s", charType, hex.EncodeToString(data))
-	_, err := notifier.Write(data)
+// Notify sends a notification on the specified characteristic
+func (b *Ble) Notify(charType CharacteristicType, data []byte) error {
+	notifier, err := b.notifier(charType)
+	if err != nil {
+		return err
+	}
+
+	b.pacing.wait(charType)
+	log.Debugf("pkg bluetooth; sending notification on %s: %s", charType, LogHex(charType, data))
+	b.idle.touch()
+	_, err = notifier.Write(data)
 	return err
 }
 
 // IsConnected returns true if a central device is connected
 func (b *Ble) IsConnected() bool {
-	return b.central != nil
+	return b.connectedCentral() != nil
+}
+
+// CentralID returns the ID of the connected central, or "" if none
+func (b *Ble) CentralID() string {
+	central := b.connectedCentral()
+	if central == nil {
+		return ""
+	}
+	return central.ID()
 }
 
 // ShutdownConnection closes the connection with the central device
 func (b *Ble) ShutdownConnection() {
-	if b.central != nil {
-		if err := (*b.central).Close(); err != nil {
-			log.Debugf("Error closing central connection: %v", err)
+	b.disconnect(DisconnectRequested)
+}
+
+// Close stops advertising, drops any connected central and closes the HCI
+// device, so the adapter is left idle on exit
+func (b *Ble) Close() error {
+	b.ShutdownConnection()
+	if b.device == nil {
+		return nil
+	}
+	d := *b.device
+	if err := d.StopAdvertising(); err != nil {
+		log.Debugf("Error stopping advertising: %v", err)
+	}
+	// gatt.Device doesn't declare Stop, though the Linux device implements it
+	if stopper, ok := d.(interface{ Stop() error }); ok {
+		if err := stopper.Stop(); err != nil {
+			return fmt.Errorf("failed to close device: %w", err)
 		}
 	}
+	return nil
+}
+
+// disconnect closes the connection with the central device, recording reason
+// for the connection event log. gatt always terminates the link with HCI
+// reason 0x13 (remote user terminated), which a central can still tell
+// apart from link loss, so reason can't be sent any more specifically.
+func (b *Ble) disconnect(reason DisconnectReason) {
+	central := b.connectedCentral()
+	if central == nil {
+		return
+	}
+	b.connLog.disconnecting(reason)
+	if err := central.Close(); err != nil {
+		log.Debugf("Error closing central connection: %v", err)
+	}
 }
 
 // SetPairingState sets the pairing/discoverable state
@@ -544,7 +918,7 @@ func (b *Ble) SetPairingState(state PairingState) error {
 	}
 
 	// If setting to not discoverable, disconnect any existing connection
-	if state == PairingStateNotDiscoverable && b.central != nil {
+	if state == PairingStateNotDiscoverable && b.IsConnected() {
 		log.Info("pkg bluetooth; disconnecting existing connection due to non-discoverable mode")
 		b.ShutdownConnection()
 	}
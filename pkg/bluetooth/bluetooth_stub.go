@@ -16,16 +16,45 @@ type Ble struct {
 	charDataMtx sync.RWMutex
 
 	// Handlers
+	linkSecurity      linkSecurity
+	reconnectGuard    reconnectGuard
+	multiCentral      multiCentral
+	connectSetup      connectSetup
+	writeValidators   writeValidators
 	writeHandler      WriteHandler
 	readHandler       ReadHandler
 	connectionHandler ConnectionHandler
+
+	advertisingHandler    AdvertisingHandler
+	advertisingHandlerMtx sync.Mutex
+
+	// Connection tracking
+	connLog       connectionLog
+	idle          idleTimer
+	subscriptions subscriptions
+
+	// Delay between notifications, per characteristic
+	pacing notifyPacing
+
+	// Characteristics sent as indications rather than notifications
+	indications indicateChars
+
+	// Pairing state, only reported back on non-Linux
+	pairingState PairingState
 }
 
-// New creates a new BLE device (stub for non-Linux platforms)
-func New(adapterID string) (*Ble, error) {
+// New creates a new BLE device (stub for non-Linux platforms; services and
+// service UUIDs are ignored, the pairing state is only reported back, and
+// the advertising handler is never called)
+func New(adapterID string, services []AuxService, serviceConfig *ServiceConfig, pairingState PairingState, advertisingHandler AdvertisingHandler) (*Ble, error) {
 	log.Warn("Bluetooth is only supported on Linux. Creating stub BLE instance.")
+	if pairingState == "" {
+		pairingState = PairingStateNotDiscoverable
+	}
 	return &Ble{
-		charData: make(map[CharacteristicType][]byte),
+		charData:           make(map[CharacteristicType][]byte),
+		pairingState:       pairingState,
+		advertisingHandler: advertisingHandler,
 	}, nil
 }
 
@@ -34,6 +63,55 @@ func (b *Ble) SetWriteHandler(handler WriteHandler) {
 	b.writeHandler = handler
 }
 
+// SetWriteValidator registers a validator run on every write to charType
+// before the write handler; pass nil to remove it
+func (b *Ble) SetWriteValidator(charType CharacteristicType, fn WriteValidator) {
+	b.writeValidators.set(charType, fn)
+}
+
+// handleWrite validates a write and passes it to the write handler, returning
+// the ATT status (the stub never receives real writes, but tests drive this)
+func (b *Ble) handleWrite(charType CharacteristicType, data []byte) byte {
+	if !b.connectSetup.ready() {
+		log.Warnf("refusing write on %s: connection setup not finished", charType)
+		return StatusUnlikelyError
+	}
+	if !b.linkSecurity.allowed() {
+		log.Warnf("refusing write on %s: link is not encrypted", charType)
+		return StatusInsufficientEncryption
+	}
+	if status, err := b.writeValidators.check(charType, data); err != nil {
+		log.Warnf("refusing write on %s (status 0x%02x): %v", charType, status, err)
+		return status
+	}
+	b.idle.touch()
+	if b.writeHandler != nil {
+		b.writeHandler(charType, data)
+	}
+	return StatusSuccess
+}
+
+// handleRead returns the value of charType for a read and its ATT status:
+// the read handler's data if it returns any, otherwise the data set with
+// SetCharacteristicData
+// (the stub never receives real reads, but tests drive this)
+func (b *Ble) handleRead(charType CharacteristicType) ([]byte, byte) {
+	if !b.linkSecurity.allowed() {
+		log.Warnf("refusing read on %s: link is not encrypted", charType)
+		return nil, StatusInsufficientEncryption
+	}
+	if b.readHandler != nil {
+		if data := b.readHandler(charType); data != nil {
+			return data, StatusSuccess
+		}
+	}
+	b.charDataMtx.RLock()
+	defer b.charDataMtx.RUnlock()
+	data := make([]byte, len(b.charData[charType]))
+	copy(data, b.charData[charType])
+	return data, StatusSuccess
+}
+
 // SetReadHandler sets the callback for when data is read from any characteristic
 func (b *Ble) SetReadHandler(handler ReadHandler) {
 	b.readHandler = handler
@@ -44,6 +122,13 @@ func (b *Ble) SetConnectionHandler(handler ConnectionHandler) {
 	b.connectionHandler = handler
 }
 
+// SetAdvertisingHandler sets the callback for when advertising data is set (never called on non-Linux)
+func (b *Ble) SetAdvertisingHandler(handler AdvertisingHandler) {
+	b.advertisingHandlerMtx.Lock()
+	defer b.advertisingHandlerMtx.Unlock()
+	b.advertisingHandler = handler
+}
+
 // SetCharacteristicData sets the data that will be returned when a characteristic is read
 func (b *Ble) SetCharacteristicData(charType CharacteristicType, data []byte) {
 	b.charDataMtx.Lock()
@@ -57,14 +142,35 @@ func (b *Ble) Notify(charType CharacteristicType, data []byte) error {
 	return fmt.Errorf("bluetooth not supported on this platform")
 }
 
+// NotifyIndicate sends an indication on the specified characteristic (stub)
+func (b *Ble) NotifyIndicate(charType CharacteristicType, data []byte) error {
+	log.Debugf("NotifyIndicate called on non-Linux platform for %s (no-op)", charType)
+	return fmt.Errorf("bluetooth not supported on this platform")
+}
+
 // IsConnected returns true if a central device is connected (always false on non-Linux)
 func (b *Ble) IsConnected() bool {
 	return false
 }
 
+// CentralID returns the ID of the connected central (always "" on non-Linux)
+func (b *Ble) CentralID() string {
+	return ""
+}
+
 // ShutdownConnection closes the connection with the central device (no-op)
 func (b *Ble) ShutdownConnection() {
-	log.Debug("ShutdownConnection called on non-Linux platform (no-op)")
+	b.disconnect(DisconnectRequested)
+}
+
+// Close stops advertising and closes the device (no-op)
+func (b *Ble) Close() error {
+	return nil
+}
+
+// disconnect closes the connection with the central device (no-op)
+func (b *Ble) disconnect(reason DisconnectReason) {
+	log.Debugf("disconnect (%s) called on non-Linux platform (no-op)", reason)
 }
 
 // SetPairingState sets the pairing/discoverable state (stub)
@@ -73,7 +179,10 @@ func (b *Ble) SetPairingState(state PairingState) error {
 	return fmt.Errorf("bluetooth not supported on this platform")
 }
 
-// GetPairingState returns the current pairing state (always NotDiscoverable on non-Linux)
+// GetPairingState returns the pairing state the stub was created with
 func (b *Ble) GetPairingState() PairingState {
-	return PairingStateNotDiscoverable
+	if b.pairingState == "" {
+		return PairingStateNotDiscoverable
+	}
+	return b.pairingState
 }
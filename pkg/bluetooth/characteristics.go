@@ -0,0 +1,78 @@
+package bluetooth
+
+// CharacteristicInfo describes one of the pump service's characteristics
+type CharacteristicInfo struct {
+	Type CharacteristicType
+	// Name is the display name String returns
+	Name string
+	// APIName is the name the web API accepts for the characteristic
+	APIName string
+	// BtChar is the pumpX2 Characteristic enum constant name ToBtChar
+	// returns, or "" if pumpX2 has none
+	BtChar string
+	// UUID is the characteristic's UUID on a real pump
+	UUID string
+}
+
+// characteristicTable is the single source of characteristic metadata,
+// listing the pump service's characteristics in registration order. Every
+// CharacteristicType must have exactly one entry.
+var characteristicTable = []CharacteristicInfo{
+	{CharCurrentStatus, "CurrentStatus", "CurrentStatus", "CURRENT_STATUS", CurrentStatusCharUUID},
+	// QualifyingEvents is notify-only and never parsed by pumpX2
+	{CharQualifyingEvents, "QualifyingEvents", "QualifyingEvents", "", QualifyingEventsCharUUID},
+	{CharHistoryLog, "HistoryLog", "HistoryLog", "HISTORY_LOG", HistoryLogCharUUID},
+	{CharAuthorization, "Authorization", "Authorization", "AUTHORIZATION", AuthorizationCharUUID},
+	{CharControl, "Control", "Control", "CONTROL", ControlCharUUID},
+	{CharControlStream, "ControlStream", "ControlStream", "CONTROL_STREAM", ControlStreamCharUUID},
+}
+
+// pumpCharacteristics lists the pump service's characteristics in
+// registration order
+var pumpCharacteristics = func() []CharacteristicType {
+	types := make([]CharacteristicType, len(characteristicTable))
+	for i, info := range characteristicTable {
+		types[i] = info.Type
+	}
+	return types
+}()
+
+// Characteristics returns the metadata of every pump characteristic, in
+// registration order
+func Characteristics() []CharacteristicInfo {
+	infos := make([]CharacteristicInfo, len(characteristicTable))
+	copy(infos, characteristicTable)
+	return infos
+}
+
+// Info returns c's metadata, and false if c isn't a pump characteristic
+func (c CharacteristicType) Info() (CharacteristicInfo, bool) {
+	for _, info := range characteristicTable {
+		if info.Type == c {
+			return info, true
+		}
+	}
+	return CharacteristicInfo{}, false
+}
+
+// ParseCharacteristicName returns the characteristic the web API knows as
+// name, and false if there is none
+func ParseCharacteristicName(name string) (CharacteristicType, bool) {
+	for _, info := range characteristicTable {
+		if info.APIName == name {
+			return info.Type, true
+		}
+	}
+	return 0, false
+}
+
+// ParseBtChar returns the characteristic for a pumpX2 Characteristic enum
+// constant name, and false if there is none
+func ParseBtChar(btChar string) (CharacteristicType, bool) {
+	for _, info := range characteristicTable {
+		if btChar != "" && info.BtChar == btChar {
+			return info.Type, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package bluetooth
+
+import "testing"
+
+// TestCharacteristicTableIsComplete verifies every CharacteristicType has
+// exactly one complete table entry, that names, pumpX2 names and UUIDs are
+// distinct, and that the functions derived from the table agree with it
+func TestCharacteristicTableIsComplete(t *testing.T) {
+	if len(characteristicTable) != int(numCharacteristicTypes) {
+		t.Fatalf("Expected %d table entries, got %d", numCharacteristicTypes, len(characteristicTable))
+	}
+
+	names, btChars, uuids := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for c := CharacteristicType(0); c < numCharacteristicTypes; c++ {
+		info, ok := c.Info()
+		if !ok {
+			t.Errorf("Characteristic %d has no table entry", c)
+			continue
+		}
+		if info.Name == "" || info.APIName == "" || !validServiceUUID(info.UUID) {
+			t.Errorf("%d: incomplete entry %+v", c, info)
+		}
+		if names[info.Name] || uuids[normalizeUUID(info.UUID)] || (info.BtChar != "" && btChars[info.BtChar]) {
+			t.Errorf("%d: entry %+v duplicates another characteristic's", c, info)
+		}
+		names[info.Name], btChars[info.BtChar], uuids[normalizeUUID(info.UUID)] = true, true, true
+
+		if c.String() != info.Name || c.ToBtChar() != info.BtChar {
+			t.Errorf("%d: String()=%q ToBtChar()=%q disagree with %+v", c, c.String(), c.ToBtChar(), info)
+		}
+		if parsed, ok := ParseCharacteristicName(info.APIName); !ok || parsed != c {
+			t.Errorf("%s: API name %q parsed to %v (ok=%v)", c, info.APIName, parsed, ok)
+		}
+		if parsed, ok := ParseBtChar(info.BtChar); info.BtChar != "" && (!ok || parsed != c) {
+			t.Errorf("%s: pumpX2 name %q parsed to %v (ok=%v)", c, info.BtChar, parsed, ok)
+		}
+		if DefaultServiceConfig().CharUUIDs[c] != info.UUID {
+			t.Errorf("%s: default UUID %q, table has %q", c, DefaultServiceConfig().CharUUIDs[c], info.UUID)
+		}
+	}
+
+	if err := DefaultServiceConfig().Validate(); err != nil {
+		t.Errorf("Expected the default service config to be valid: %v", err)
+	}
+	if _, ok := ParseCharacteristicName("Unknown"); ok {
+		t.Error("Expected an unknown name not to parse")
+	}
+	if _, ok := ParseBtChar(""); ok {
+		t.Error("Expected an empty pumpX2 name not to parse")
+	}
+	if numCharacteristicTypes.String() != "Unknown" || numCharacteristicTypes.ToBtChar() != "" {
+		t.Error("Expected an out of range characteristic to be unknown")
+	}
+}
@@ -0,0 +1,37 @@
+package bluetooth
+
+import (
+	"testing"
+
+	"github.com/paypal/gatt"
+)
+
+// TestCloseStopsAdvertisingAndDevice verifies Close drops the central, stops
+// advertising and stops the device
+func TestCloseStopsAdvertisingAndDevice(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	var d gatt.Device = &fakeDevice{}
+	b.device = &d
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	device := d.(*fakeDevice)
+	if central.closed != 1 || b.IsConnected() {
+		t.Errorf("Expected central to be closed once, closed %d times", central.closed)
+	}
+	if device.stoppedAdvertising != 1 || device.stopped != 1 {
+		t.Errorf("Expected advertising and device stopped once, got %d and %d", device.stoppedAdvertising, device.stopped)
+	}
+}
+
+// TestCloseWithoutDevice verifies Close is safe before the device is opened
+func TestCloseWithoutDevice(t *testing.T) {
+	if err := newServicesTestBle(nil).Close(); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+}
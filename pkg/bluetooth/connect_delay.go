@@ -0,0 +1,97 @@
+package bluetooth
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// connectSetup holds back finishing connection setup for a delay after a
+// central connects, to simulate a pump slow to become ready. Until it
+// finishes, the connection handler isn't told of the connection and writes
+// are refused.
+type connectSetup struct {
+	delay     time.Duration
+	settingUp bool
+	gen       int // bumped to drop a pending finish on disconnect
+	stop      func() bool
+
+	// afterFunc runs f after d, returning a func that cancels it; defaults
+	// to time.AfterFunc
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+	mtx       sync.Mutex
+}
+
+func (s *connectSetup) setDelay(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.delay = d
+}
+
+// begin starts setting up a new connection, running finish once the delay
+// has passed unless the connection ends first
+func (s *connectSetup) begin(finish func()) {
+	s.mtx.Lock()
+	s.cancelLocked()
+	if s.delay <= 0 {
+		s.mtx.Unlock()
+		finish()
+		return
+	}
+
+	s.settingUp = true
+	gen, delay := s.gen, s.delay
+	afterFunc := s.afterFunc
+	if afterFunc == nil {
+		afterFunc = func(d time.Duration, f func()) func() bool { return time.AfterFunc(d, f).Stop }
+	}
+	s.stop = afterFunc(delay, func() {
+		s.mtx.Lock()
+		if s.gen != gen {
+			s.mtx.Unlock()
+			return
+		}
+		s.settingUp = false
+		s.stop = nil
+		s.mtx.Unlock()
+
+		log.Infof("pkg bluetooth; connection setup finished after %s", delay)
+		finish()
+	})
+	s.mtx.Unlock()
+}
+
+// cancel drops any pending setup when the connection ends, reporting whether
+// setup was still pending, in which case the connection was never reported
+func (s *connectSetup) cancel() (pending bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	pending = s.settingUp
+	s.cancelLocked()
+	return pending
+}
+
+func (s *connectSetup) cancelLocked() {
+	s.gen++
+	s.settingUp = false
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+}
+
+// ready reports whether connection setup has finished, so writes are
+// accepted
+func (s *connectSetup) ready() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return !s.settingUp
+}
+
+// SetConnectDelay makes the pump take d after a central connects before
+// telling the connection handler and accepting writes. Zero finishes setup
+// immediately.
+func (b *Ble) SetConnectDelay(d time.Duration) {
+	b.connectSetup.setDelay(d)
+}
@@ -0,0 +1,89 @@
+package bluetooth
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeTimer is a pending connectSetup.afterFunc call fired by the test
+type fakeTimer struct {
+	delay   time.Duration
+	fire    func()
+	stopped bool
+}
+
+// TestConnectDelayHoldsBackConnectedCallback verifies the connection
+// handler only hears of a connection, and writes are only accepted, once the
+// connect delay has passed, and then hears of its disconnect
+func TestConnectDelayHoldsBackConnectedCallback(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	var timers []*fakeTimer
+	b.connectSetup.afterFunc = func(d time.Duration, f func()) func() bool {
+		timer := &fakeTimer{delay: d, fire: f}
+		timers = append(timers, timer)
+		return func() bool { timer.stopped = true; return true }
+	}
+	b.SetConnectDelay(2 * time.Second)
+	var connected []bool
+	b.SetConnectionHandler(func(c bool) { connected = append(connected, c) })
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	if len(connected) != 0 {
+		t.Fatalf("Expected no connected callback before the delay, got %v", connected)
+	}
+	if status := b.handleWrite(CharControl, []byte{0x00}); status != StatusUnlikelyError {
+		t.Errorf("Expected a write during setup to be refused, got status 0x%02x", status)
+	}
+	if len(timers) != 1 || timers[0].delay != 2*time.Second {
+		t.Fatalf("Expected setup to finish after 2s, got %+v", timers)
+	}
+
+	timers[0].fire()
+	if len(connected) != 1 || !connected[0] {
+		t.Fatalf("Expected the connected callback once the delay passed, got %v", connected)
+	}
+	if status := b.handleWrite(CharControl, []byte{0x00}); status != StatusSuccess {
+		t.Errorf("Expected a write after setup to succeed, got status 0x%02x", status)
+	}
+
+	if err := central.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(connected) != 2 || connected[1] {
+		t.Errorf("Expected a disconnected callback after the connected one, got %v", connected)
+	}
+}
+
+// TestConnectDelayDroppedOnDisconnect verifies a central that disconnects
+// during setup produces neither a connected nor a disconnected callback
+func TestConnectDelayDroppedOnDisconnect(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	var timer *fakeTimer
+	b.connectSetup.afterFunc = func(d time.Duration, f func()) func() bool {
+		timer = &fakeTimer{delay: d, fire: f}
+		return func() bool { timer.stopped = true; return true }
+	}
+	b.SetConnectDelay(time.Second)
+	var connected []bool
+	b.SetConnectionHandler(func(c bool) { connected = append(connected, c) })
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	if err := central.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !timer.stopped {
+		t.Error("Expected the pending setup to be stopped on disconnect")
+	}
+
+	timer.fire()
+	if len(connected) != 0 {
+		t.Fatalf("Expected no connection callbacks for a central that left during setup, got %v", connected)
+	}
+	if status := b.handleWrite(CharControl, []byte{0x00}); status != StatusSuccess {
+		t.Errorf("Expected writes to be accepted again once setup was dropped, got status 0x%02x", status)
+	}
+}
@@ -0,0 +1,60 @@
+package bluetooth
+
+import "sync"
+
+// StatusInsufficientEncryption is the ATT status returned when a pump service
+// characteristic is accessed before the link is encrypted
+const StatusInsufficientEncryption byte = 0x0F
+
+// linkSecurity tracks whether pump service access requires an encrypted link
+// and whether the current link is encrypted. paypal/gatt doesn't run SMP or
+// report link encryption on Linux, so the encrypted flag is set externally
+// (SetLinkEncrypted, e.g. from the websocket API) and cleared on disconnect.
+type linkSecurity struct {
+	required  bool
+	encrypted bool
+	mtx       sync.RWMutex
+}
+
+// allowed reports whether pump service characteristics may be accessed
+func (l *linkSecurity) allowed() bool {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	return !l.required || l.encrypted
+}
+
+func (l *linkSecurity) setRequired(required bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.required = required
+}
+
+func (l *linkSecurity) setEncrypted(encrypted bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.encrypted = encrypted
+}
+
+func (l *linkSecurity) isEncrypted() bool {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	return l.encrypted
+}
+
+// SetRequireEncryption makes pump service characteristic reads and writes
+// fail with StatusInsufficientEncryption, and subscriptions be ignored, until
+// the link is marked encrypted. GAP, GATT and Device Information
+// characteristics remain accessible.
+func (b *Ble) SetRequireEncryption(required bool) {
+	b.linkSecurity.setRequired(required)
+}
+
+// SetLinkEncrypted records whether the current link is encrypted (bonded)
+func (b *Ble) SetLinkEncrypted(encrypted bool) {
+	b.linkSecurity.setEncrypted(encrypted)
+}
+
+// IsLinkEncrypted returns whether the current link is marked encrypted
+func (b *Ble) IsLinkEncrypted() bool {
+	return b.linkSecurity.isEncrypted()
+}
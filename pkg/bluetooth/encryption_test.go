@@ -0,0 +1,54 @@
+package bluetooth
+
+import "testing"
+
+// TestRequireEncryptionRefusesUnencryptedWrites verifies pump service writes are
+// refused until the link is encrypted, then proceed normally
+func TestRequireEncryptionRefusesUnencryptedWrites(t *testing.T) {
+	b := &Ble{}
+	writes := 0
+	b.SetWriteHandler(func(charType CharacteristicType, data []byte) {
+		writes++
+	})
+	b.SetRequireEncryption(true)
+
+	if status := b.handleWrite(CharAuthorization, []byte{0x00, 0x01}); status != StatusInsufficientEncryption {
+		t.Errorf("Expected status 0x%02x for unencrypted write, got 0x%02x", StatusInsufficientEncryption, status)
+	}
+	if writes != 0 {
+		t.Error("Expected unencrypted write not to reach the write handler")
+	}
+
+	b.SetLinkEncrypted(true)
+	if status := b.handleWrite(CharAuthorization, []byte{0x00, 0x01}); status != StatusSuccess {
+		t.Errorf("Expected encrypted write to succeed, got status 0x%02x", status)
+	}
+	if writes != 1 {
+		t.Errorf("Expected encrypted write to reach the write handler, got %d writes", writes)
+	}
+}
+
+// TestRequireEncryptionRefusesUnencryptedReads verifies pump service reads
+// are refused until the link is encrypted
+func TestRequireEncryptionRefusesUnencryptedReads(t *testing.T) {
+	b := &Ble{charData: make(map[CharacteristicType][]byte)}
+	b.SetCharacteristicData(CharCurrentStatus, []byte{0x01})
+	b.SetRequireEncryption(true)
+
+	if data, status := b.handleRead(CharCurrentStatus); status != StatusInsufficientEncryption || data != nil {
+		t.Errorf("Expected status 0x%02x and no data for unencrypted read, got 0x%02x %x", StatusInsufficientEncryption, status, data)
+	}
+
+	b.SetLinkEncrypted(true)
+	if data, status := b.handleRead(CharCurrentStatus); status != StatusSuccess || len(data) != 1 {
+		t.Errorf("Expected encrypted read to succeed, got 0x%02x %x", status, data)
+	}
+}
+
+// TestEncryptionNotRequiredByDefault verifies writes proceed when encryption isn't required
+func TestEncryptionNotRequiredByDefault(t *testing.T) {
+	b := &Ble{}
+	if status := b.handleWrite(CharControl, []byte{0x00, 0x01}); status != StatusSuccess {
+		t.Errorf("Expected write to succeed without encryption requirement, got status 0x%02x", status)
+	}
+}
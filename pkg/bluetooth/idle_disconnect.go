@@ -0,0 +1,185 @@
+package bluetooth
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DisconnectReason says why a connection ended
+type DisconnectReason int
+
+// Disconnect reason constants
+const (
+	// DisconnectCentral means the central disconnected or the link was lost
+	DisconnectCentral DisconnectReason = iota
+	// DisconnectRequested means the emulator was asked to drop the connection
+	DisconnectRequested
+	// DisconnectIdle means the pump dropped a connection with no traffic
+	// for the idle timeout
+	DisconnectIdle
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectCentral:
+		return "central"
+	case DisconnectRequested:
+		return "requested"
+	case DisconnectIdle:
+		return "idle"
+	default:
+		return "unknown"
+	}
+}
+
+// maxConnectionEvents bounds the connection event log
+const maxConnectionEvents = 100
+
+// ConnectionEvent is an entry in the connection event log. Reason is only
+// meaningful for disconnects.
+type ConnectionEvent struct {
+	Time      time.Time
+	CentralID string
+	Connected bool
+	Reason    DisconnectReason
+}
+
+// connectionLog records connects and disconnects, attributing each
+// disconnect to the reason the pump gave when it initiated one. The BLE stack
+// always closes with the same HCI reason (remote user terminated), so the
+// reason is kept here rather than sent to the central.
+type connectionLog struct {
+	events  []ConnectionEvent
+	pending *DisconnectReason
+	now     func() time.Time
+	mtx     sync.Mutex
+}
+
+func (l *connectionLog) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+func (l *connectionLog) add(event ConnectionEvent) {
+	l.events = append(l.events, event)
+	if len(l.events) > maxConnectionEvents {
+		l.events = l.events[len(l.events)-maxConnectionEvents:]
+	}
+}
+
+// connected records centralID connecting
+func (l *connectionLog) connected(centralID string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.pending = nil
+	l.add(ConnectionEvent{Time: l.clock(), CentralID: centralID, Connected: true})
+}
+
+// disconnecting notes the reason for a disconnect the pump is initiating
+func (l *connectionLog) disconnecting(reason DisconnectReason) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.pending = &reason
+}
+
+// disconnected records centralID disconnecting, for the pending reason if
+// the pump initiated it
+func (l *connectionLog) disconnected(centralID string) DisconnectReason {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	reason := DisconnectCentral
+	if l.pending != nil {
+		reason = *l.pending
+		l.pending = nil
+	}
+	l.add(ConnectionEvent{Time: l.clock(), CentralID: centralID, Reason: reason})
+	return reason
+}
+
+func (l *connectionLog) snapshot() []ConnectionEvent {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	events := make([]ConnectionEvent, len(l.events))
+	copy(events, l.events)
+	return events
+}
+
+// idleTimer tracks the last traffic on the link so an idle connection can be
+// dropped
+type idleTimer struct {
+	timeout  time.Duration
+	last     time.Time
+	now      func() time.Time
+	watching sync.Once
+	mtx      sync.Mutex
+}
+
+func (t *idleTimer) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *idleTimer) setTimeout(d time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.timeout = d
+	t.last = t.clock()
+}
+
+func (t *idleTimer) getTimeout() time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.timeout
+}
+
+// touch records traffic on the link
+func (t *idleTimer) touch() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.last = t.clock()
+}
+
+// expired reports whether the link has been idle for the timeout
+func (t *idleTimer) expired() bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.timeout > 0 && t.clock().Sub(t.last) >= t.timeout
+}
+
+// SetIdleTimeout makes the pump drop a connection with no writes or
+// notifications for d. Zero disables the check.
+func (b *Ble) SetIdleTimeout(d time.Duration) {
+	b.idle.setTimeout(d)
+	if d > 0 {
+		b.idle.watching.Do(func() { go b.watchIdle() })
+	}
+}
+
+// watchIdle periodically drops the connection once it goes idle
+func (b *Ble) watchIdle() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.checkIdle()
+	}
+}
+
+// checkIdle drops the connection if it has gone idle
+func (b *Ble) checkIdle() {
+	if b.IsConnected() && b.idle.expired() {
+		log.Infof("pkg bluetooth; idle disconnect: no traffic for %s", b.idle.getTimeout())
+		b.disconnect(DisconnectIdle)
+	}
+}
+
+// ConnectionEvents returns the most recent connects and disconnects, oldest
+// first
+func (b *Ble) ConnectionEvents() []ConnectionEvent {
+	return b.connLog.snapshot()
+}
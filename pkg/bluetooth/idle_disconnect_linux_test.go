@@ -0,0 +1,74 @@
+package bluetooth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt"
+)
+
+// fakeCentral is a gatt.Central whose Close reports the disconnect back to
+// the Ble like gatt does; any other method panics via the nil embedded
+// interface
+type fakeCentral struct {
+	gatt.Central
+	id     string
+	ble    *Ble
+	closed int
+}
+
+func (c *fakeCentral) ID() string { return c.id }
+
+func (c *fakeCentral) Close() error {
+	c.closed++
+	c.ble.onCentralDisconnected(c)
+	return nil
+}
+
+// TestIdleDisconnectRecordsIdleReason verifies a connection with no traffic
+// for the idle timeout is dropped and logged with the idle reason, while one
+// the central ends itself is not
+func TestIdleDisconnectRecordsIdleReason(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	b.idle.now = func() time.Time { return now }
+	b.connLog.now = b.idle.now
+	b.idle.setTimeout(time.Minute)
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	if !b.IsConnected() {
+		t.Fatal("Expected central to be connected")
+	}
+
+	now = now.Add(50 * time.Second)
+	if status := b.handleWrite(CharControl, []byte{0x00}); status != StatusSuccess {
+		t.Fatalf("Expected write to succeed, got status 0x%02x", status)
+	}
+	now = now.Add(50 * time.Second)
+	b.checkIdle()
+	if central.closed != 0 {
+		t.Fatal("Expected a write to reset the idle timer")
+	}
+
+	now = now.Add(10 * time.Second)
+	b.checkIdle()
+	if central.closed != 1 || b.IsConnected() {
+		t.Fatalf("Expected idle connection to be closed, closed %d times", central.closed)
+	}
+
+	events := b.ConnectionEvents()
+	if len(events) != 2 || !events[0].Connected || events[1].Connected {
+		t.Fatalf("Expected a connect then a disconnect, got %+v", events)
+	}
+	if events[1].Reason != DisconnectIdle || events[1].CentralID != "central-1" || !events[1].Time.Equal(now) {
+		t.Errorf("Expected idle disconnect of central-1 at %s, got %+v", now, events[1])
+	}
+
+	b.onCentralConnected(central)
+	b.onCentralDisconnected(central)
+	if last := b.ConnectionEvents()[3]; last.Reason != DisconnectCentral {
+		t.Errorf("Expected a central-initiated disconnect, got %s", last.Reason)
+	}
+}
@@ -0,0 +1,50 @@
+package bluetooth
+
+import "sync"
+
+// defaultIndicateChars are the characteristics sent as indications unless
+// changed with SetIndicate. HistoryLog streams large amounts of data, so it
+// benefits from the central confirming each packet before the next.
+var defaultIndicateChars = map[CharacteristicType]bool{
+	CharHistoryLog: true,
+}
+
+// indicateChars records which characteristics are sent as indications
+// rather than notifications
+type indicateChars struct {
+	overrides map[CharacteristicType]bool // overrides of defaultIndicateChars
+	mtx       sync.Mutex
+}
+
+func (i *indicateChars) set(charType CharacteristicType, indicate bool) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if i.overrides == nil {
+		i.overrides = make(map[CharacteristicType]bool)
+	}
+	i.overrides[charType] = indicate
+}
+
+func (i *indicateChars) uses(charType CharacteristicType) bool {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if indicate, ok := i.overrides[charType]; ok {
+		return indicate
+	}
+	return defaultIndicateChars[charType]
+}
+
+// SetIndicate sets whether packets on charType are sent as indications,
+// which the central confirms, rather than unacknowledged notifications. By
+// default only HistoryLog uses indications.
+func (b *Ble) SetIndicate(charType CharacteristicType, indicate bool) {
+	b.indications.set(charType, indicate)
+}
+
+// UsesIndications returns true if packets on charType should be sent with
+// NotifyIndicate rather than Notify
+func (b *Ble) UsesIndications(charType CharacteristicType) bool {
+	return b.indications.uses(charType)
+}
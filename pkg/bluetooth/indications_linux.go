@@ -0,0 +1,37 @@
+package bluetooth
+
+import (
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// indicator is a gatt.Notifier that can also send an indication, returning
+// once the central has confirmed it. The vendored gatt server's notifiers
+// are indicators.
+type indicator interface {
+	Indicate(data []byte) error
+	IndicationsEnabled() bool
+}
+
+// NotifyIndicate sends an indication on the specified characteristic,
+// returning once the central confirms it, so consecutive packets are flow
+// controlled. If the central only enabled notifications when it subscribed,
+// the packet is notified and the characteristic's pacing stands in for the
+// confirmation round trip.
+func (b *Ble) NotifyIndicate(charType CharacteristicType, data []byte) error {
+	notifier, err := b.notifier(charType)
+	if err != nil {
+		return err
+	}
+
+	b.pacing.wait(charType)
+	b.idle.touch()
+	if ind, ok := notifier.(indicator); ok && ind.IndicationsEnabled() {
+		log.Debugf("pkg bluetooth; sending indication on %s: %s", charType, hex.EncodeToString(data))
+		return ind.Indicate(data)
+	}
+	log.Debugf("pkg bluetooth; sending notification for indication on %s: %s", charType, hex.EncodeToString(data))
+	_, err = notifier.Write(data)
+	return err
+}
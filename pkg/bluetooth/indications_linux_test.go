@@ -0,0 +1,60 @@
+package bluetooth
+
+import "testing"
+
+// fakeIndicator is a notifier that can also send confirmed indications,
+// once enabled
+type fakeIndicator struct {
+	fakeNotifier
+	enabled     bool
+	indications [][]byte
+}
+
+func (n *fakeIndicator) Indicate(data []byte) error {
+	n.indications = append(n.indications, data)
+	return nil
+}
+
+func (n *fakeIndicator) IndicationsEnabled() bool {
+	return n.enabled
+}
+
+// TestNotifyIndicate verifies NotifyIndicate sends an indication when the
+// central enabled them and falls back to a notification otherwise
+func TestNotifyIndicate(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.SetNotifyPacingFor(CharHistoryLog, 0)
+	historyLog := &fakeIndicator{enabled: true}
+	control := &fakeNotifier{}
+	b.notifiers[CharHistoryLog] = historyLog
+	b.notifiers[CharControl] = control
+
+	if err := b.NotifyIndicate(CharHistoryLog, []byte{1}); err != nil {
+		t.Fatalf("NotifyIndicate failed: %v", err)
+	}
+	if len(historyLog.indications) != 1 || len(historyLog.writes) != 0 {
+		t.Errorf("Expected an indication, got %d indications and %d notifications",
+			len(historyLog.indications), len(historyLog.writes))
+	}
+
+	if err := b.NotifyIndicate(CharControl, []byte{2}); err != nil {
+		t.Fatalf("NotifyIndicate failed: %v", err)
+	}
+	if len(control.writes) != 1 {
+		t.Errorf("Expected a notification from a notifier that can't indicate, got %d", len(control.writes))
+	}
+
+	notifyOnly := &fakeIndicator{}
+	b.notifiers[CharCurrentStatus] = notifyOnly
+	if err := b.NotifyIndicate(CharCurrentStatus, []byte{3}); err != nil {
+		t.Fatalf("NotifyIndicate failed: %v", err)
+	}
+	if len(notifyOnly.indications) != 0 || len(notifyOnly.writes) != 1 {
+		t.Errorf("Expected a notification to a central that only enabled notifications, got %d indications and %d notifications",
+			len(notifyOnly.indications), len(notifyOnly.writes))
+	}
+
+	if err := b.NotifyIndicate(CharAuthorization, []byte{4}); err == nil {
+		t.Error("Expected an error without a subscribed notifier")
+	}
+}
@@ -0,0 +1,21 @@
+package bluetooth
+
+import "testing"
+
+// TestIndicationDefaults verifies only HistoryLog uses indications by default
+// and SetIndicate overrides that per characteristic
+func TestIndicationDefaults(t *testing.T) {
+	var b Ble
+	if !b.UsesIndications(CharHistoryLog) {
+		t.Error("Expected HistoryLog to use indications")
+	}
+	if b.UsesIndications(CharControl) {
+		t.Error("Expected Control to use notifications")
+	}
+
+	b.SetIndicate(CharHistoryLog, false)
+	b.SetIndicate(CharControl, true)
+	if b.UsesIndications(CharHistoryLog) || !b.UsesIndications(CharControl) {
+		t.Error("Expected SetIndicate to override the defaults")
+	}
+}
@@ -0,0 +1,54 @@
+package bluetooth
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MultiCentralPolicy is what happens when a second central connects while
+// one is already connected
+type MultiCentralPolicy string
+
+const (
+	// MultiCentralReject keeps the connected central and closes the new
+	// one, like a real pump
+	MultiCentralReject MultiCentralPolicy = "reject"
+
+	// MultiCentralReplace disconnects the connected central in favor of
+	// the new one
+	MultiCentralReplace MultiCentralPolicy = "replace"
+)
+
+// ParseMultiCentralPolicy parses a multi-central policy name
+func ParseMultiCentralPolicy(policy string) (MultiCentralPolicy, error) {
+	switch MultiCentralPolicy(policy) {
+	case MultiCentralReject, MultiCentralReplace:
+		return MultiCentralPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown multi-central policy %q (expected %s or %s)", policy, MultiCentralReject, MultiCentralReplace)
+}
+
+// multiCentral holds the policy for a second connecting central
+type multiCentral struct {
+	policy MultiCentralPolicy // "" rejects
+	mtx    sync.Mutex
+}
+
+func (m *multiCentral) set(policy MultiCentralPolicy) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.policy = policy
+}
+
+// replaces returns true if a second central replaces the connected one
+func (m *multiCentral) replaces() bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.policy == MultiCentralReplace
+}
+
+// SetMultiCentralPolicy sets what happens when a second central connects
+// while one is already connected. By default it is rejected.
+func (b *Ble) SetMultiCentralPolicy(policy MultiCentralPolicy) {
+	b.multiCentral.set(policy)
+}
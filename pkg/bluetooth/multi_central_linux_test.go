@@ -0,0 +1,86 @@
+package bluetooth
+
+import (
+	"testing"
+
+	"github.com/paypal/gatt"
+)
+
+// connectTwoCentrals connects two centrals under policy, returning them
+func connectTwoCentrals(t *testing.T, policy MultiCentralPolicy) (*Ble, *fakeCentral, *fakeCentral) {
+	t.Helper()
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	b.SetMultiCentralPolicy(policy)
+
+	first := &fakeCentral{id: "central-1", ble: b}
+	second := &fakeCentral{id: "central-2", ble: b}
+	b.onCentralConnected(first)
+	b.onCentralConnected(second)
+	return b, first, second
+}
+
+// TestMultiCentralRejectKeepsFirst verifies the reject policy closes a
+// second central and keeps the first connected
+func TestMultiCentralRejectKeepsFirst(t *testing.T) {
+	b, first, second := connectTwoCentrals(t, MultiCentralReject)
+
+	if first.closed != 0 || second.closed != 1 {
+		t.Errorf("Expected only the second central closed, closed %d and %d times", first.closed, second.closed)
+	}
+	if b.CentralID() != "central-1" {
+		t.Errorf("Expected central-1 to stay connected, got %q", b.CentralID())
+	}
+}
+
+// TestMultiCentralReplaceKeepsSecond verifies the replace policy closes the
+// first central in favor of the second, logging the first as disconnected
+func TestMultiCentralReplaceKeepsSecond(t *testing.T) {
+	b, first, second := connectTwoCentrals(t, MultiCentralReplace)
+
+	if first.closed != 1 || second.closed != 0 {
+		t.Errorf("Expected only the first central closed, closed %d and %d times", first.closed, second.closed)
+	}
+	if b.CentralID() != "central-2" {
+		t.Errorf("Expected central-2 to be connected, got %q", b.CentralID())
+	}
+	events := b.ConnectionEvents()
+	if len(events) != 3 || events[1].CentralID != "central-1" || events[1].Connected || events[1].Reason != DisconnectRequested {
+		t.Errorf("Expected central-1's disconnect to be logged as requested, got %+v", events)
+	}
+
+	// A late disconnect callback for the replaced central changes nothing
+	b.onCentralDisconnected(first)
+	if b.CentralID() != "central-2" {
+		t.Errorf("Expected central-2 to stay connected, got %q", b.CentralID())
+	}
+}
+
+// TestMultiCentralAdvertisingPausedWhileConnected verifies the reject policy
+// stops advertising while a central is connected and resumes it once the
+// central leaves, while the replace policy keeps advertising
+func TestMultiCentralAdvertisingPausedWhileConnected(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	var d gatt.Device = &fakeDevice{}
+	b.device = &d
+	device := d.(*fakeDevice)
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	if !b.isAdvertisingPaused() || device.options != 1 {
+		t.Errorf("Expected advertising paused once connected, paused=%v options=%d", b.isAdvertisingPaused(), device.options)
+	}
+
+	b.ShutdownConnection()
+	if b.isAdvertisingPaused() || device.options != 2 {
+		t.Errorf("Expected advertising resumed after disconnect, paused=%v options=%d", b.isAdvertisingPaused(), device.options)
+	}
+
+	b.SetMultiCentralPolicy(MultiCentralReplace)
+	b.reconnectGuard.setMinInterval(0)
+	b.onCentralConnected(&fakeCentral{id: "central-2", ble: b})
+	if b.isAdvertisingPaused() || device.options != 2 {
+		t.Errorf("Expected the replace policy to keep advertising, paused=%v options=%d", b.isAdvertisingPaused(), device.options)
+	}
+}
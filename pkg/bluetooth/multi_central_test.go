@@ -0,0 +1,13 @@
+package bluetooth
+
+import "testing"
+
+// TestParseMultiCentralPolicy verifies unknown policies are refused
+func TestParseMultiCentralPolicy(t *testing.T) {
+	if policy, err := ParseMultiCentralPolicy("replace"); err != nil || policy != MultiCentralReplace {
+		t.Errorf("Expected replace, got %q (err=%v)", policy, err)
+	}
+	if _, err := ParseMultiCentralPolicy("both"); err == nil {
+		t.Error("Expected an unknown policy to be refused")
+	}
+}
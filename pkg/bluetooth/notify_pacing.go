@@ -0,0 +1,90 @@
+package bluetooth
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHistoryLogNotifyPacing is the default delay between HistoryLog
+// notifications, so a long history stream doesn't overrun the central's
+// notification queue
+const DefaultHistoryLogNotifyPacing = 10 * time.Millisecond
+
+// defaultNotifyPacing is the delay between notifications on characteristics
+// without one set by SetNotifyPacingFor; any other characteristic, such as
+// Control, notifies back-to-back
+var defaultNotifyPacing = map[CharacteristicType]time.Duration{
+	CharHistoryLog: DefaultHistoryLogNotifyPacing,
+}
+
+// notifyPacing spaces consecutive notifications on a characteristic at least
+// its configured delay apart
+type notifyPacing struct {
+	delays map[CharacteristicType]time.Duration // overrides of defaultNotifyPacing
+	next   map[CharacteristicType]time.Time     // earliest time of the next notification
+	now    func() time.Time
+	sleep  func(time.Duration)
+	mtx    sync.Mutex
+}
+
+func (p *notifyPacing) setDelay(charType CharacteristicType, d time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.delays == nil {
+		p.delays = make(map[CharacteristicType]time.Duration)
+	}
+	p.delays[charType] = d
+}
+
+// delay returns the pacing for charType (must hold mtx)
+func (p *notifyPacing) delay(charType CharacteristicType) time.Duration {
+	if d, ok := p.delays[charType]; ok {
+		return d
+	}
+	return defaultNotifyPacing[charType]
+}
+
+// wait blocks until a notification on charType may be sent. The slot is
+// reserved before sleeping, so concurrent notifications on one
+// characteristic are spaced out too, without holding up other characteristics.
+func (p *notifyPacing) wait(charType CharacteristicType) {
+	p.mtx.Lock()
+	d := p.delay(charType)
+	if d <= 0 {
+		p.mtx.Unlock()
+		return
+	}
+	now := p.clock()
+	at := now
+	if next, ok := p.next[charType]; ok && next.After(now) {
+		at = next
+	}
+	if p.next == nil {
+		p.next = make(map[CharacteristicType]time.Time)
+	}
+	p.next[charType] = at.Add(d)
+	p.mtx.Unlock()
+
+	if wait := at.Sub(now); wait > 0 {
+		if p.sleep != nil {
+			p.sleep(wait)
+		} else {
+			time.Sleep(wait)
+		}
+	}
+}
+
+func (p *notifyPacing) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// SetNotifyPacingFor spaces notifications on charType at least d apart, so
+// the packets of a multi-packet message are paced. Zero sends them
+// back-to-back. By default only HistoryLog is paced.
+func (b *Ble) SetNotifyPacingFor(charType CharacteristicType, d time.Duration) {
+	b.pacing.setDelay(charType, d)
+}
@@ -0,0 +1,55 @@
+package bluetooth
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNotifyPacingPerCharacteristic verifies HistoryLog packets are spaced
+// by the configured delay while Control packets are sent back-to-back
+func TestNotifyPacingPerCharacteristic(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var slept []time.Duration
+	b := newServicesTestBle(nil)
+	b.pacing.now = func() time.Time { return now }
+	b.pacing.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+	historyLog := &fakeNotifier{}
+	control := &fakeNotifier{}
+	b.notifiers[CharHistoryLog] = historyLog
+	b.notifiers[CharControl] = control
+
+	b.SetNotifyPacingFor(CharHistoryLog, 25*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := b.Notify(CharHistoryLog, []byte{byte(i)}); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	if len(historyLog.writes) != 3 {
+		t.Fatalf("Expected 3 HistoryLog packets, got %d", len(historyLog.writes))
+	}
+	if len(slept) != 2 || slept[0] != 25*time.Millisecond || slept[1] != 25*time.Millisecond {
+		t.Fatalf("Expected HistoryLog packets paced 25ms apart, slept %v", slept)
+	}
+
+	slept = nil
+	for i := 0; i < 3; i++ {
+		if err := b.Notify(CharControl, []byte{byte(i)}); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	if len(control.writes) != 3 || len(slept) != 0 {
+		t.Errorf("Expected Control packets back-to-back, got %d packets and slept %v", len(control.writes), slept)
+	}
+
+	// A gap longer than the delay needs no wait
+	now = now.Add(time.Second)
+	if err := b.Notify(CharHistoryLog, []byte{3}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if len(slept) != 0 {
+		t.Errorf("Expected no wait after an idle gap, slept %v", slept)
+	}
+}
@@ -0,0 +1,19 @@
+package bluetooth
+
+import "testing"
+
+// TestNotifyPacingDefaults verifies only HistoryLog is paced by default
+func TestNotifyPacingDefaults(t *testing.T) {
+	var p notifyPacing
+	if d := p.delay(CharHistoryLog); d != DefaultHistoryLogNotifyPacing {
+		t.Errorf("Expected HistoryLog pacing %s, got %s", DefaultHistoryLogNotifyPacing, d)
+	}
+	if d := p.delay(CharControl); d != 0 {
+		t.Errorf("Expected no Control pacing, got %s", d)
+	}
+
+	p.setDelay(CharHistoryLog, 0)
+	if d := p.delay(CharHistoryLog); d != 0 {
+		t.Errorf("Expected HistoryLog pacing to be disabled, got %s", d)
+	}
+}
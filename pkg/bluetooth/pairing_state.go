@@ -1,5 +1,10 @@
 package bluetooth
 
+import (
+	"fmt"
+	"strings"
+)
+
 // PairingState represents the current pairing/discoverable state
 type PairingState string
 
@@ -13,3 +18,25 @@ const (
 	// PairingStatePairStep2 - discoverable, manufacturer data 0x12
 	PairingStatePairStep2 PairingState = "PairStep2"
 )
+
+// pairingStates are the known pairing states, in pairing order
+var pairingStates = []PairingState{
+	PairingStateNotDiscoverable,
+	PairingStateDiscoverableOnly,
+	PairingStatePairStep1,
+	PairingStatePairStep2,
+}
+
+// ParsePairingState returns the known pairing state named value
+func ParsePairingState(value string) (PairingState, error) {
+	for _, state := range pairingStates {
+		if string(state) == value {
+			return state, nil
+		}
+	}
+	names := make([]string, len(pairingStates))
+	for i, state := range pairingStates {
+		names[i] = string(state)
+	}
+	return "", fmt.Errorf("invalid pairing state: %q (must be one of %s)", value, strings.Join(names, ", "))
+}
@@ -0,0 +1,28 @@
+package bluetooth
+
+import "testing"
+
+// TestStartPairingStateAcceptsConnections verifies a Ble created in a pair
+// step reports it and accepts a central without the state being changed,
+// while the default still rejects one
+func TestStartPairingStateAcceptsConnections(t *testing.T) {
+	b := newBle(nil, DefaultServiceConfig(), PairingStatePairStep1)
+	if state := b.GetPairingState(); state != PairingStatePairStep1 {
+		t.Fatalf("Expected initial pairing state PairStep1, got %s", state)
+	}
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	if !b.IsConnected() || central.closed != 0 {
+		t.Error("Expected connection to be accepted")
+	}
+
+	b = newBle(nil, DefaultServiceConfig(), "")
+	if state := b.GetPairingState(); state != PairingStateNotDiscoverable {
+		t.Fatalf("Expected default pairing state NotDiscoverable, got %s", state)
+	}
+	central = &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	if b.IsConnected() || central.closed != 1 {
+		t.Error("Expected connection to be rejected while not discoverable")
+	}
+}
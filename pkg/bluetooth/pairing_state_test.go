@@ -0,0 +1,19 @@
+package bluetooth
+
+import "testing"
+
+// TestParsePairingState verifies known states parse and anything else is
+// rejected
+func TestParsePairingState(t *testing.T) {
+	for _, want := range pairingStates {
+		got, err := ParsePairingState(string(want))
+		if err != nil || got != want {
+			t.Errorf("ParsePairingState(%q) = %q, %v", want, got, err)
+		}
+	}
+	for _, value := range []string{"", "pairstep1", "Discoverable"} {
+		if _, err := ParsePairingState(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
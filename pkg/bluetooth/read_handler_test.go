@@ -0,0 +1,60 @@
+package bluetooth
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// TestHandleReadPrefersReadHandler verifies a read is answered by the read
+// handler, falling back to the staged characteristic data when it returns nil
+func TestHandleReadPrefersReadHandler(t *testing.T) {
+	b := &Ble{charData: make(map[CharacteristicType][]byte)}
+	b.SetCharacteristicData(CharCurrentStatus, []byte{0x01, 0x02})
+
+	if data, _ := b.handleRead(CharCurrentStatus); !bytes.Equal(data, []byte{0x01, 0x02}) {
+		t.Errorf("Expected staged data without a read handler, got %x", data)
+	}
+
+	b.SetReadHandler(func(charType CharacteristicType) []byte {
+		if charType == CharControl {
+			return []byte{0xaa}
+		}
+		return nil
+	})
+	if data, _ := b.handleRead(CharControl); !bytes.Equal(data, []byte{0xaa}) {
+		t.Errorf("Expected read handler data, got %x", data)
+	}
+	if data, _ := b.handleRead(CharCurrentStatus); !bytes.Equal(data, []byte{0x01, 0x02}) {
+		t.Errorf("Expected staged data when the read handler returns nil, got %x", data)
+	}
+	if data, _ := b.handleRead(CharHistoryLog); data == nil || len(data) != 0 {
+		t.Errorf("Expected an empty value for a characteristic with no data, got %x", data)
+	}
+}
+
+// TestHandleReadConcurrentWithSetCharacteristicData verifies reads are safe
+// against concurrent updates (run with -race)
+func TestHandleReadConcurrentWithSetCharacteristicData(t *testing.T) {
+	b := &Ble{charData: make(map[CharacteristicType][]byte)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.SetCharacteristicData(CharCurrentStatus, []byte{byte(i), byte(j)})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if data, _ := b.handleRead(CharCurrentStatus); len(data) != 0 && len(data) != 2 {
+					t.Errorf("Read torn value %x", data)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
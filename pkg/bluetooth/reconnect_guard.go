@@ -0,0 +1,64 @@
+package bluetooth
+
+import (
+	"sync"
+	"time"
+)
+
+// reconnectGuard rejects a central that reconnects sooner than minInterval
+// after its last connection ended or its last attempt was rejected, so a
+// client reconnecting in a tight loop backs off instead of thrashing setup
+type reconnectGuard struct {
+	minInterval time.Duration
+	lastSeen    map[string]time.Time // keyed by central ID
+	now         func() time.Time
+	mtx         sync.Mutex
+}
+
+func (g *reconnectGuard) setMinInterval(d time.Duration) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.minInterval = d
+}
+
+// allow reports whether centralID may connect now. A rejected attempt
+// restarts the window.
+func (g *reconnectGuard) allow(centralID string) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.minInterval <= 0 {
+		return true
+	}
+	now := g.clock()
+	last, seen := g.lastSeen[centralID]
+	if seen && now.Sub(last) < g.minInterval {
+		g.lastSeen[centralID] = now
+		return false
+	}
+	return true
+}
+
+// disconnected records when centralID's connection ended
+func (g *reconnectGuard) disconnected(centralID string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.lastSeen == nil {
+		g.lastSeen = make(map[string]time.Time)
+	}
+	g.lastSeen[centralID] = g.clock()
+}
+
+func (g *reconnectGuard) clock() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// SetMinReconnectInterval rejects a central reconnecting within d of its
+// previous disconnect. Zero disables the check.
+func (b *Ble) SetMinReconnectInterval(d time.Duration) {
+	b.reconnectGuard.setMinInterval(d)
+}
@@ -0,0 +1,47 @@
+package bluetooth
+
+import (
+	"testing"
+	"time"
+)
+
+// TestReconnectGuardRejectsFastReconnect verifies a reconnect within the window
+// is rejected and restarts it, while one after the window is accepted
+func TestReconnectGuardRejectsFastReconnect(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := &Ble{}
+	b.reconnectGuard.now = func() time.Time { return now }
+	b.SetMinReconnectInterval(5 * time.Second)
+
+	if !b.reconnectGuard.allow("central-1") {
+		t.Fatal("Expected first connection to be accepted")
+	}
+	b.reconnectGuard.disconnected("central-1")
+
+	now = now.Add(2 * time.Second)
+	if b.reconnectGuard.allow("central-1") {
+		t.Error("Expected reconnect within the window to be rejected")
+	}
+	if !b.reconnectGuard.allow("central-2") {
+		t.Error("Expected a different central to be accepted")
+	}
+
+	now = now.Add(4 * time.Second)
+	if b.reconnectGuard.allow("central-1") {
+		t.Error("Expected rejected attempt to restart the window")
+	}
+
+	now = now.Add(5 * time.Second)
+	if !b.reconnectGuard.allow("central-1") {
+		t.Error("Expected reconnect after the window to be accepted")
+	}
+}
+
+// TestReconnectGuardDisabledByDefault verifies a zero interval never rejects
+func TestReconnectGuardDisabledByDefault(t *testing.T) {
+	b := &Ble{}
+	b.reconnectGuard.disconnected("central-1")
+	if !b.reconnectGuard.allow("central-1") {
+		t.Error("Expected immediate reconnect to be accepted with no interval set")
+	}
+}
@@ -0,0 +1,37 @@
+package bluetooth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+)
+
+// redactAuth is 1 while authentication payloads are redacted from logs. It
+// lives here rather than in protocol so this package can redact its own logs.
+var redactAuth int32 = 1
+
+// SetRedactAuth turns redaction of authentication payloads in logs on or
+// off. JPAKE and challenge messages carry key material, so it's on by
+// default.
+func SetRedactAuth(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&redactAuth, v)
+}
+
+// RedactingAuth reports whether authentication payloads are redacted
+func RedactingAuth() bool {
+	return atomic.LoadInt32(&redactAuth) == 1
+}
+
+// LogHex returns data as hex for logging, or a placeholder with its length
+// if it was sent on the Authorization characteristic and auth payloads are
+// being redacted
+func LogHex(charType CharacteristicType, data []byte) string {
+	if charType == CharAuthorization && RedactingAuth() {
+		return fmt.Sprintf("<redacted %d bytes>", len(data))
+	}
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,75 @@
+package bluetooth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// AuxService names a GATT service registered alongside the pump service.
+// Besides the named services below, a 16-bit or 128-bit UUID string registers
+// an empty custom primary service.
+type AuxService string
+
+// Auxiliary services registered by a real Tandem Mobi
+const (
+	ServiceGenericAccess     AuxService = "generic-access"
+	ServiceGenericAttribute  AuxService = "generic-attribute"
+	ServiceDeviceInformation AuxService = "device-information"
+	ServiceUnknownFDFA       AuxService = "fdfa"
+)
+
+// DefaultAuxServices reproduces the GATT table of a real Tandem Mobi
+var DefaultAuxServices = []AuxService{
+	ServiceGenericAccess,
+	ServiceGenericAttribute,
+	ServiceDeviceInformation,
+	ServiceUnknownFDFA,
+}
+
+// registeredBeforePump reports whether s is registered ahead of the pump
+// service; the SIG-standard services come first in a real pump's GATT table
+func (s AuxService) registeredBeforePump() bool {
+	switch s {
+	case ServiceGenericAccess, ServiceGenericAttribute, ServiceDeviceInformation:
+		return true
+	}
+	return false
+}
+
+// isCustom reports whether s is a custom service UUID rather than a named service
+func (s AuxService) isCustom() bool {
+	switch s {
+	case ServiceGenericAccess, ServiceGenericAttribute, ServiceDeviceInformation, ServiceUnknownFDFA:
+		return false
+	}
+	return true
+}
+
+// ParseAuxServices parses a comma-separated list of service names and custom
+// service UUIDs. An empty string yields no auxiliary services.
+func ParseAuxServices(s string) ([]AuxService, error) {
+	services := []AuxService{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		service := AuxService(strings.ToLower(field))
+		if service.isCustom() && !validServiceUUID(field) {
+			return nil, fmt.Errorf("unknown service %q: want one of generic-access, generic-attribute, device-information, fdfa or a 16/128-bit UUID", field)
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// validServiceUUID reports whether s is a 16-bit or 128-bit UUID in hex
+func validServiceUUID(s string) bool {
+	s = strings.Replace(s, "-", "", -1)
+	if len(s) != 4 && len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
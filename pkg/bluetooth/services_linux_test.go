@@ -0,0 +1,169 @@
+package bluetooth
+
+import (
+	"testing"
+
+	"github.com/paypal/gatt"
+)
+
+// fakeDevice records the services registered on it and counts
+// StopAdvertising and Stop calls; any other gatt.Device method panics via
+// the nil embedded interface
+type fakeDevice struct {
+	gatt.Device
+	services           []*gatt.Service
+	stoppedAdvertising int
+	stopped            int
+	options            int
+}
+
+// Option counts the options set rather than applying them, since gatt's
+// options only apply to its own device
+func (d *fakeDevice) Option(opts ...gatt.Option) error {
+	d.options += len(opts)
+	return nil
+}
+
+func (d *fakeDevice) StopAdvertising() error {
+	d.stoppedAdvertising++
+	return nil
+}
+
+func (d *fakeDevice) Stop() error {
+	d.stopped++
+	return nil
+}
+
+func (d *fakeDevice) AddService(s *gatt.Service) error {
+	d.services = append(d.services, s)
+	return nil
+}
+
+func (d *fakeDevice) uuids() []gatt.UUID {
+	uuids := make([]gatt.UUID, len(d.services))
+	for i, s := range d.services {
+		uuids[i] = s.UUID()
+	}
+	return uuids
+}
+
+func newServicesTestBle(services []AuxService) *Ble {
+	return &Ble{
+		notifiers:               make(map[CharacteristicType]gatt.Notifier),
+		charData:                make(map[CharacteristicType][]byte),
+		extraCharData:           make(map[string][]byte),
+		writeNotifyChars:        make(map[CharacteristicType]*gatt.Characteristic),
+		notifyOnlyChars:         make(map[CharacteristicType]*gatt.Characteristic),
+		unknownWriteNotifyChars: make(map[string]*gatt.Characteristic),
+		unknownWriteOnlyChars:   make(map[string]*gatt.Characteristic),
+		services:                services,
+	}
+}
+
+// TestRegisterServicesOmitsFDFA verifies dropping the FDFA service from the
+// config leaves it unregistered while the pump service still is
+func TestRegisterServicesOmitsFDFA(t *testing.T) {
+	d := &fakeDevice{}
+	b := newServicesTestBle([]AuxService{ServiceGenericAccess, ServiceGenericAttribute, ServiceDeviceInformation})
+	b.registerServices(d)
+
+	var sawPump bool
+	for _, u := range d.uuids() {
+		if u.Equal(gatt.UUID16(0xFDFA)) {
+			t.Error("FDFA service registered despite being omitted from config")
+		}
+		if u.Equal(gatt.UUID16(0xFDFB)) {
+			sawPump = true
+		}
+	}
+	if !sawPump {
+		t.Errorf("pump service not registered; got %v", d.uuids())
+	}
+}
+
+// TestRegisterServicesDefaultOrder verifies the default config matches a real
+// pump's GATT table order, with custom services registered after the pump
+func TestRegisterServicesDefaultOrder(t *testing.T) {
+	d := &fakeDevice{}
+	b := newServicesTestBle(append(append([]AuxService{}, DefaultAuxServices...), "fff0"))
+	b.registerServices(d)
+
+	expected := []gatt.UUID{
+		gatt.UUID16(0x1800),
+		gatt.UUID16(0x1801),
+		gatt.UUID16(0x180A),
+		gatt.UUID16(0xFDFB),
+		gatt.UUID16(0xFDFA),
+		gatt.UUID16(0xFFF0),
+	}
+	got := d.uuids()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d services, got %v", len(expected), got)
+	}
+	for i := range expected {
+		if !got[i].Equal(expected[i]) {
+			t.Errorf("service %d: expected %s, got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+// pumpServiceChars returns the characteristic UUIDs of the registered
+// service with uuid, or nil if none was registered
+func (d *fakeDevice) pumpServiceChars(uuid gatt.UUID) []gatt.UUID {
+	for _, s := range d.services {
+		if !s.UUID().Equal(uuid) {
+			continue
+		}
+		var chars []gatt.UUID
+		for _, c := range s.Characteristics() {
+			chars = append(chars, c.UUID())
+		}
+		return chars
+	}
+	return nil
+}
+
+// TestRegisterServicesUsesCustomUUIDs verifies a custom service config is
+// used for the pump service and its characteristics, and that a Ble without
+// one registers the real pump's UUIDs
+func TestRegisterServicesUsesCustomUUIDs(t *testing.T) {
+	cfg := ServiceConfig{
+		ServiceUUID: "f000aa00-0451-4000-b000-000000000000",
+		CharUUIDs: map[CharacteristicType]string{
+			CharCurrentStatus:    "f000aa01-0451-4000-b000-000000000000",
+			CharQualifyingEvents: "f000aa02-0451-4000-b000-000000000000",
+			CharHistoryLog:       "f000aa03-0451-4000-b000-000000000000",
+			CharAuthorization:    "f000aa04-0451-4000-b000-000000000000",
+			CharControl:          "f000aa05-0451-4000-b000-000000000000",
+			CharControlStream:    "f000aa06-0451-4000-b000-000000000000",
+		},
+	}
+
+	for _, tt := range []struct {
+		name string
+		cfg  ServiceConfig
+	}{
+		{"custom", cfg},
+		{"default", DefaultServiceConfig()},
+	} {
+		d := &fakeDevice{}
+		b := newServicesTestBle(nil)
+		if tt.name == "custom" {
+			b.serviceConfig = tt.cfg
+		}
+		b.registerServices(d)
+
+		chars := d.pumpServiceChars(gatt.MustParseUUID(tt.cfg.ServiceUUID))
+		if len(chars) != len(pumpCharacteristics) {
+			t.Fatalf("%s: expected %d pump characteristics, got %v", tt.name, len(pumpCharacteristics), chars)
+		}
+		for i, charType := range pumpCharacteristics {
+			if want := gatt.MustParseUUID(tt.cfg.CharUUIDs[charType]); !chars[i].Equal(want) {
+				t.Errorf("%s: %s registered as %s, expected %s", tt.name, charType, chars[i], want)
+			}
+		}
+		if _, ok := b.notifyOnlyChars[CharHistoryLog]; !ok {
+			t.Errorf("%s: HistoryLog not registered notify-only", tt.name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package bluetooth
+
+import "testing"
+
+// TestParseAuxServices verifies named services and custom UUIDs are accepted
+// and anything else is rejected
+func TestParseAuxServices(t *testing.T) {
+	services, err := ParseAuxServices("generic-access, Device-Information,fff0,7B83FFF6-9F77-4E5C-8064-AAE2C24838B9")
+	if err != nil {
+		t.Fatalf("ParseAuxServices failed: %v", err)
+	}
+	expected := []AuxService{ServiceGenericAccess, ServiceDeviceInformation, "fff0", "7b83fff6-9f77-4e5c-8064-aae2c24838b9"}
+	if len(services) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, services)
+	}
+	for i := range expected {
+		if services[i] != expected[i] {
+			t.Errorf("service %d: expected %q, got %q", i, expected[i], services[i])
+		}
+	}
+
+	if services, err := ParseAuxServices(""); err != nil || len(services) != 0 {
+		t.Errorf("expected empty list for empty string, got %v, %v", services, err)
+	}
+	if _, err := ParseAuxServices("generic-access,bogus"); err == nil {
+		t.Error("expected error for unknown service name")
+	}
+}
+
+// TestServiceConfigMapsUUIDsToCharacteristics verifies the default config
+// keeps the real pump's UUIDs and duplicate or malformed UUIDs are rejected
+func TestServiceConfigMapsUUIDsToCharacteristics(t *testing.T) {
+	cfg := DefaultServiceConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+	if uuid := cfg.CharUUIDs[CharControl]; normalizeUUID(uuid) != "7b83fffc9f774e5c8064aae2c24838b9" || CharControl.ToBtChar() != "CONTROL" {
+		t.Errorf("expected CONTROL to keep the real Control UUID, got %s", uuid)
+	}
+
+	cfg.CharUUIDs = map[CharacteristicType]string{}
+	for charType, uuid := range DefaultServiceConfig().CharUUIDs {
+		cfg.CharUUIDs[charType] = uuid
+	}
+	cfg.CharUUIDs[CharControlStream] = ControlCharUUID
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for characteristics sharing a UUID")
+	}
+	cfg.CharUUIDs[CharControlStream] = "not-a-uuid"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a malformed UUID")
+	}
+}
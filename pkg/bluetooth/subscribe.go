@@ -0,0 +1,59 @@
+package bluetooth
+
+import "sync"
+
+// SubscribeHandler is called the first time a central enables notifications
+// on a characteristic during a connection
+type SubscribeHandler func(charType CharacteristicType)
+
+// subscriptions tracks which characteristics the connected central has
+// subscribed to, so a subscribe handler only runs on the first subscription
+// of each connection
+type subscriptions struct {
+	handlers   map[CharacteristicType]SubscribeHandler
+	subscribed map[CharacteristicType]bool
+	mtx        sync.Mutex
+}
+
+func (s *subscriptions) setHandler(charType CharacteristicType, fn SubscribeHandler) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[CharacteristicType]SubscribeHandler)
+	}
+	if fn == nil {
+		delete(s.handlers, charType)
+	} else {
+		s.handlers[charType] = fn
+	}
+}
+
+// subscribe records a subscription to charType, returning its handler if
+// this is the first subscription of the connection
+func (s *subscriptions) subscribe(charType CharacteristicType) SubscribeHandler {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.subscribed == nil {
+		s.subscribed = make(map[CharacteristicType]bool)
+	}
+	if s.subscribed[charType] {
+		return nil
+	}
+	s.subscribed[charType] = true
+	return s.handlers[charType]
+}
+
+// reset forgets the subscriptions of a connection that ended
+func (s *subscriptions) reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.subscribed = nil
+}
+
+// SetOnSubscribe sets fn to be called the first time the central enables
+// notifications on charType in each connection, e.g. to push an initial
+// snapshot the way a real pump does. fn may Notify on charType. Pass nil to
+// remove it.
+func (b *Ble) SetOnSubscribe(charType CharacteristicType, fn SubscribeHandler) {
+	b.subscriptions.setHandler(charType, fn)
+}
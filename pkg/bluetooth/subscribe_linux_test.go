@@ -0,0 +1,80 @@
+package bluetooth
+
+import "testing"
+
+// fakeNotifier is a gatt.Notifier recording what is written to it
+type fakeNotifier struct {
+	writes [][]byte
+}
+
+func (n *fakeNotifier) Write(data []byte) (int, error) {
+	n.writes = append(n.writes, data)
+	return len(data), nil
+}
+
+func (n *fakeNotifier) Done() bool { return false }
+
+func (n *fakeNotifier) Cap() int { return 20 }
+
+// TestSubscribeSendsInitialStatus verifies subscribing to CurrentStatus runs
+// the subscribe handler once per connection, and that it can notify the
+// central of the initial status
+func TestSubscribeSendsInitialStatus(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	snapshot := []byte{0x00, 0x00, 0x29, 0x00, 0x05}
+	b.SetOnSubscribe(CharCurrentStatus, func(charType CharacteristicType) {
+		if err := b.Notify(charType, snapshot); err != nil {
+			t.Errorf("Notify failed: %v", err)
+		}
+	})
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	status := &fakeNotifier{}
+	b.onSubscribe(CharCurrentStatus, central.ID(), status)
+	b.onSubscribe(CharControl, central.ID(), &fakeNotifier{})
+
+	if len(status.writes) != 1 || string(status.writes[0]) != string(snapshot) {
+		t.Fatalf("Expected one initial status notification, got %x", status.writes)
+	}
+
+	b.onSubscribe(CharCurrentStatus, central.ID(), status)
+	if len(status.writes) != 1 {
+		t.Errorf("Expected no snapshot on resubscribing in the same connection, got %d", len(status.writes))
+	}
+
+	central.Close()
+	b.onCentralConnected(central)
+	b.onSubscribe(CharCurrentStatus, central.ID(), status)
+	if len(status.writes) != 2 {
+		t.Errorf("Expected a snapshot on the next connection's subscription, got %d", len(status.writes))
+	}
+}
+
+// TestSubscribeRefusedUntilEncrypted verifies a subscription made before a
+// required encrypted link neither registers the notifier nor runs the
+// subscribe handler
+func TestSubscribeRefusedUntilEncrypted(t *testing.T) {
+	b := newServicesTestBle(nil)
+	b.pairingState = PairingStateDiscoverableOnly
+	b.SetRequireEncryption(true)
+	subscribed := 0
+	b.SetOnSubscribe(CharCurrentStatus, func(CharacteristicType) { subscribed++ })
+
+	central := &fakeCentral{id: "central-1", ble: b}
+	b.onCentralConnected(central)
+	b.onSubscribe(CharCurrentStatus, central.ID(), &fakeNotifier{})
+	if subscribed != 0 {
+		t.Error("Expected an unencrypted subscription not to run the subscribe handler")
+	}
+	if err := b.Notify(CharCurrentStatus, []byte{0x00}); err == nil {
+		t.Error("Expected no notifier registered for an unencrypted subscription")
+	}
+
+	b.SetLinkEncrypted(true)
+	b.onSubscribe(CharCurrentStatus, central.ID(), &fakeNotifier{})
+	if subscribed != 1 {
+		t.Errorf("Expected the encrypted subscription to run the subscribe handler, got %d", subscribed)
+	}
+}
@@ -0,0 +1,76 @@
+package bluetooth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ATT status codes returned to the central from a write request
+const (
+	StatusSuccess                     byte = 0x00
+	StatusInvalidAttributeValueLength byte = 0x0D
+	StatusUnlikelyError               byte = 0x0E
+)
+
+// ErrInvalidLength is returned (or wrapped) by a WriteValidator to refuse a
+// write with StatusInvalidAttributeValueLength instead of StatusUnlikelyError
+var ErrInvalidLength = errors.New("invalid attribute value length")
+
+// WriteValidator inspects a raw write before it reaches the write handler and
+// reassembly; a non-nil error refuses the write
+type WriteValidator func(data []byte) error
+
+// MaxFrameSizeValidator returns a validator that rejects empty frames and
+// frames larger than maxSize bytes
+func MaxFrameSizeValidator(maxSize int) WriteValidator {
+	return func(data []byte) error {
+		if len(data) == 0 {
+			return fmt.Errorf("%w: empty frame", ErrInvalidLength)
+		}
+		if len(data) > maxSize {
+			return fmt.Errorf("%w: %d bytes exceeds max frame size %d", ErrInvalidLength, len(data), maxSize)
+		}
+		return nil
+	}
+}
+
+// writeValidators is the per-characteristic validator registry shared by the
+// Linux and stub Ble implementations
+type writeValidators struct {
+	fns map[CharacteristicType]WriteValidator
+	mtx sync.RWMutex
+}
+
+// set registers fn for charType, or removes the validator if fn is nil
+func (v *writeValidators) set(charType CharacteristicType, fn WriteValidator) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if v.fns == nil {
+		v.fns = make(map[CharacteristicType]WriteValidator)
+	}
+	if fn == nil {
+		delete(v.fns, charType)
+		return
+	}
+	v.fns[charType] = fn
+}
+
+// check runs the validator for charType and returns the ATT status to report
+func (v *writeValidators) check(charType CharacteristicType, data []byte) (byte, error) {
+	v.mtx.RLock()
+	fn := v.fns[charType]
+	v.mtx.RUnlock()
+
+	if fn == nil {
+		return StatusSuccess, nil
+	}
+	if err := fn(data); err != nil {
+		if errors.Is(err, ErrInvalidLength) {
+			return StatusInvalidAttributeValueLength, err
+		}
+		return StatusUnlikelyError, err
+	}
+	return StatusSuccess, nil
+}
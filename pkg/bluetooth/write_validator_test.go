@@ -0,0 +1,60 @@
+package bluetooth
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestWriteValidatorRefusesOversizedControlFrame verifies a too-large Control
+// frame is refused with the invalid-length status and never reaches the write handler
+func TestWriteValidatorRefusesOversizedControlFrame(t *testing.T) {
+	b := &Ble{}
+	called := false
+	b.SetWriteHandler(func(charType CharacteristicType, data []byte) {
+		called = true
+	})
+	b.SetWriteValidator(CharControl, MaxFrameSizeValidator(18))
+
+	status := b.handleWrite(CharControl, make([]byte, 19))
+	if status != StatusInvalidAttributeValueLength {
+		t.Errorf("Expected status 0x%02x, got 0x%02x", StatusInvalidAttributeValueLength, status)
+	}
+	if called {
+		t.Error("Expected write handler not to be called for refused write")
+	}
+
+	status = b.handleWrite(CharControl, make([]byte, 18))
+	if status != StatusSuccess {
+		t.Errorf("Expected 18-byte frame to be accepted, got status 0x%02x", status)
+	}
+	if !called {
+		t.Error("Expected write handler to be called for accepted write")
+	}
+}
+
+// TestWriteValidatorIsPerCharacteristic verifies validators only apply to their characteristic
+func TestWriteValidatorIsPerCharacteristic(t *testing.T) {
+	b := &Ble{}
+	b.SetWriteValidator(CharControl, MaxFrameSizeValidator(18))
+
+	if status := b.handleWrite(CharAuthorization, make([]byte, 40)); status != StatusSuccess {
+		t.Errorf("Expected Authorization write to be unaffected, got status 0x%02x", status)
+	}
+
+	b.SetWriteValidator(CharControl, nil)
+	if status := b.handleWrite(CharControl, make([]byte, 40)); status != StatusSuccess {
+		t.Errorf("Expected removed validator to accept write, got status 0x%02x", status)
+	}
+}
+
+// TestWriteValidatorGenericError verifies non-length errors map to the unlikely-error status
+func TestWriteValidatorGenericError(t *testing.T) {
+	b := &Ble{}
+	b.SetWriteValidator(CharControl, func(data []byte) error {
+		return errors.New("bad frame")
+	})
+
+	if status := b.handleWrite(CharControl, []byte{0x00, 0x01}); status != StatusUnlikelyError {
+		t.Errorf("Expected status 0x%02x, got 0x%02x", StatusUnlikelyError, status)
+	}
+}
@@ -1,65 +1,145 @@
 package config
 
 import (
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// Default protocol timeouts
+const (
+	DefaultReassemblyTimeout = 30 * time.Second
+	DefaultTxTimeout         = 10 * time.Second
+)
+
+// DefaultMaxMessageSize bounds an incoming multi-packet message in bytes. The
+// largest real message -- a 255 byte cargo plus header, CRC and signature
+// trailer -- is well under this.
+const DefaultMaxMessageSize = 512
+
+// DefaultHistoryPageSize is how many history log entries are streamed in
+// response to one HistoryLogRequest
+const DefaultHistoryPageSize = 32
+
+// DefaultRXWorkers is how many transactions' incoming messages are parsed
+// and routed at once
+const DefaultRXWorkers = 4
+
 // Config holds the simulator configuration
 type Config struct {
 	// pumpX2 configuration
-	PumpX2Path    string
-	PumpX2Mode    string // "gradle" or "jar"
-	PumpX2JarPath string // path to a prebuilt cliparser jar; if set, skips gradle entirely
-	GradleCmd     string
-	JavaCmd       string
+	PumpX2Path    string `json:"pumpX2Path"`
+	PumpX2Mode    string `json:"pumpX2Mode"`    // "gradle" or "jar"
+	PumpX2JarPath string `json:"pumpX2JarPath"` // path to a prebuilt cliparser jar; if set, skips gradle entirely
+	GradleCmd     string `json:"gradleCmd"`
+	JavaCmd       string `json:"javaCmd"`
 
 	// JPAKE configuration
-	JPAKEMode        string // "go" or "pumpx2"
-	JPAKELongTermKey []byte // pre-seeded long-term key for quick-pair reconnects, if provided
+	JPAKEMode        string `json:"jpakeMode"` // "go" or "pumpx2"
+	JPAKELongTermKey []byte `json:"-"`         // pre-seeded long-term key for quick-pair reconnects, if provided
+
+	// Protocol timeouts
+	ReassemblyTimeout time.Duration `json:"reassemblyTimeout"` // how long a partial multi-packet message is kept
+	TxTimeout         time.Duration `json:"txTimeout"`         // how long a pending transaction waits for a response
+	MaxMessageSize    int           `json:"maxMessageSize"`    // largest incoming message accepted, in bytes (0 = unlimited)
+
+	// History log paging
+	HistoryPageSize int `json:"historyPageSize"` // most history log entries returned per request
+
+	// RX dispatch
+	RXWorkers int `json:"rxWorkers"` // most transactions parsed and routed concurrently
 
 	// Logging configuration
-	LogLevel string
+	LogLevel string `json:"logLevel"`
+}
+
+// redactedValue replaces a secret that is set when the config is marshaled
+const redactedValue = "<redacted>"
+
+// MarshalJSON encodes every config field, with durations as strings. Secrets
+// are never encoded: the long-term key is "<redacted>" if set and empty
+// otherwise, so the config can be shown without leaking it.
+func (c Config) MarshalJSON() ([]byte, error) {
+	type plainConfig Config
+	longTermKey := ""
+	if len(c.JPAKELongTermKey) > 0 {
+		longTermKey = redactedValue
+	}
+	return json.Marshal(struct {
+		plainConfig
+		JPAKELongTermKey  string `json:"jpakeLongTermKey"`
+		ReassemblyTimeout string `json:"reassemblyTimeout"`
+		TxTimeout         string `json:"txTimeout"`
+	}{
+		plainConfig:       plainConfig(c),
+		JPAKELongTermKey:  longTermKey,
+		ReassemblyTimeout: c.ReassemblyTimeout.String(),
+		TxTimeout:         c.TxTimeout.String(),
+	})
 }
 
+// ErrPumpX2NotFound is returned by New when neither a pumpX2 repository nor
+// a prebuilt cliparser jar could be found
+var ErrPumpX2NotFound = errors.New("pumpX2 not found")
+
 // New creates a new configuration
-func New(pumpX2Path, pumpX2Mode, jpakeMode, gradleCmd, javaCmd, logLevel, pumpX2JarPath, jpakeLongTermKeyHex string) (*Config, error) {
+func New(pumpX2Path, pumpX2Mode, jpakeMode, gradleCmd, javaCmd, logLevel, pumpX2JarPath string, jpakeLongTermKey []byte) (*Config, error) {
 	// A prebuilt jar needs neither a pumpX2 checkout nor gradle, so skip all of
 	// that validation and force jar mode when one is given.
 	if pumpX2JarPath != "" {
-		if _, err := os.Stat(pumpX2JarPath); os.IsNotExist(err) {
-			return nil, fmt.Errorf("pumpx2-jar-path does not exist: %s", pumpX2JarPath)
-		}
 		pumpX2Mode = "jar"
-	} else {
-		// Check for environment variable if path not provided
-		if pumpX2Path == "" {
-			pumpX2Path = os.Getenv("PUMPX2_PATH")
-		}
+	}
 
-		if pumpX2Path == "" {
-			return nil, fmt.Errorf("pumpX2 path is required (use -pumpx2-path flag, -pumpx2-jar-path flag, or PUMPX2_PATH environment variable)")
-		}
+	cfg, err := NewWithoutPumpX2(pumpX2Mode, jpakeMode, gradleCmd, javaCmd, logLevel, jpakeLongTermKey)
+	if err != nil {
+		return nil, err
+	}
 
-		// Validate that the path exists
-		if _, err := os.Stat(pumpX2Path); os.IsNotExist(err) {
-			return nil, fmt.Errorf("pumpX2 path does not exist: %s", pumpX2Path)
+	if pumpX2JarPath != "" {
+		if _, err := os.Stat(pumpX2JarPath); os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: pumpx2-jar-path does not exist: %s", ErrPumpX2NotFound, pumpX2JarPath)
 		}
+		cfg.PumpX2JarPath = pumpX2JarPath
+		return cfg, nil
+	}
 
-		// Validate that it looks like a pumpX2 repository
-		cliparserPath := filepath.Join(pumpX2Path, "cliparser")
-		if _, err := os.Stat(cliparserPath); os.IsNotExist(err) {
-			return nil, fmt.Errorf("path does not appear to be a pumpX2 repository (missing cliparser directory): %s", pumpX2Path)
-		}
+	// Check for environment variable if path not provided
+	if pumpX2Path == "" {
+		pumpX2Path = os.Getenv("PUMPX2_PATH")
+	}
 
+	if pumpX2Path == "" {
+		return nil, fmt.Errorf("%w: pumpX2 path is required (use -pumpx2-path flag, -pumpx2-jar-path flag, or PUMPX2_PATH environment variable)", ErrPumpX2NotFound)
+	}
+
+	// Validate that the path exists
+	if _, err := os.Stat(pumpX2Path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: pumpX2 path does not exist: %s", ErrPumpX2NotFound, pumpX2Path)
+	}
+
+	// Validate that it looks like a pumpX2 repository
+	cliparserPath := filepath.Join(pumpX2Path, "cliparser")
+	if _, err := os.Stat(cliparserPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: path does not appear to be a pumpX2 repository (missing cliparser directory): %s", ErrPumpX2NotFound, pumpX2Path)
+	}
+
+	if pumpX2Mode == "gradle" {
 		gradlePath := filepath.Join(pumpX2Path, "gradlew")
 		if _, err := os.Stat(gradlePath); os.IsNotExist(err) {
-			return nil, fmt.Errorf("path does not appear to be a pumpX2 repository (missing gradlew): %s", pumpX2Path)
+			return nil, fmt.Errorf("%w: path does not appear to be a pumpX2 repository (missing gradlew): %s", ErrPumpX2NotFound, pumpX2Path)
 		}
 	}
 
+	cfg.PumpX2Path = pumpX2Path
+	return cfg, nil
+}
+
+// NewWithoutPumpX2 creates a configuration with no pumpX2 repository or
+// cliparser jar, for running without the bridge
+func NewWithoutPumpX2(pumpX2Mode, jpakeMode, gradleCmd, javaCmd, logLevel string, jpakeLongTermKey []byte) (*Config, error) {
 	// Validate mode
 	if pumpX2Mode != "gradle" && pumpX2Mode != "jar" {
 		return nil, fmt.Errorf("invalid pumpx2-mode: %s (must be 'gradle' or 'jar')", pumpX2Mode)
@@ -70,23 +150,57 @@ func New(pumpX2Path, pumpX2Mode, jpakeMode, gradleCmd, javaCmd, logLevel, pumpX2
 		return nil, fmt.Errorf("invalid jpake-mode: %s (must be 'go' or 'pumpx2')", jpakeMode)
 	}
 
-	var longTermKey []byte
-	if jpakeLongTermKeyHex != "" {
-		var err error
-		longTermKey, err = hex.DecodeString(jpakeLongTermKeyHex)
-		if err != nil {
-			return nil, fmt.Errorf("invalid jpake-long-term-key (must be hex-encoded): %w", err)
-		}
-	}
-
 	return &Config{
-		PumpX2Path:       pumpX2Path,
-		PumpX2Mode:       pumpX2Mode,
-		PumpX2JarPath:    pumpX2JarPath,
-		JPAKEMode:        jpakeMode,
-		JPAKELongTermKey: longTermKey,
-		GradleCmd:        gradleCmd,
-		JavaCmd:          javaCmd,
-		LogLevel:         logLevel,
+		PumpX2Mode:        pumpX2Mode,
+		JPAKEMode:         jpakeMode,
+		JPAKELongTermKey:  jpakeLongTermKey,
+		GradleCmd:         gradleCmd,
+		JavaCmd:           javaCmd,
+		ReassemblyTimeout: DefaultReassemblyTimeout,
+		TxTimeout:         DefaultTxTimeout,
+		MaxMessageSize:    DefaultMaxMessageSize,
+		HistoryPageSize:   DefaultHistoryPageSize,
+		RXWorkers:         DefaultRXWorkers,
+		LogLevel:          logLevel,
 	}, nil
 }
+
+// SetTimeouts sets the reassembly and transaction timeouts, which must be positive
+func (c *Config) SetTimeouts(reassemblyTimeout, txTimeout time.Duration) error {
+	if reassemblyTimeout <= 0 {
+		return fmt.Errorf("invalid reassembly-timeout: %s (must be positive)", reassemblyTimeout)
+	}
+	if txTimeout <= 0 {
+		return fmt.Errorf("invalid tx-timeout: %s (must be positive)", txTimeout)
+	}
+	c.ReassemblyTimeout = reassemblyTimeout
+	c.TxTimeout = txTimeout
+	return nil
+}
+
+// SetMaxMessageSize sets the largest incoming message accepted; 0 disables the limit
+func (c *Config) SetMaxMessageSize(maxBytes int) error {
+	if maxBytes < 0 {
+		return fmt.Errorf("invalid max-message-size: %d (must not be negative)", maxBytes)
+	}
+	c.MaxMessageSize = maxBytes
+	return nil
+}
+
+// SetHistoryPageSize sets the most history log entries returned per request
+func (c *Config) SetHistoryPageSize(pageSize int) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("invalid history-page-size: %d (must be positive)", pageSize)
+	}
+	c.HistoryPageSize = pageSize
+	return nil
+}
+
+// SetRXWorkers sets the most transactions parsed and routed concurrently
+func (c *Config) SetRXWorkers(workers int) error {
+	if workers <= 0 {
+		return fmt.Errorf("invalid rx-workers: %d (must be positive)", workers)
+	}
+	c.RXWorkers = workers
+	return nil
+}
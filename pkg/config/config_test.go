@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/protocol"
+)
+
+// TestSetTimeoutsReflectedInStats verifies configured timeouts reach the protocol components
+func TestSetTimeoutsReflectedInStats(t *testing.T) {
+	cfg := &Config{}
+	if err := cfg.SetTimeouts(5*time.Second, 2500*time.Millisecond); err != nil {
+		t.Fatalf("SetTimeouts failed: %v", err)
+	}
+
+	reassembler := protocol.NewReassembler(cfg.ReassemblyTimeout)
+	defer reassembler.Stop()
+	if got := reassembler.GetStats()["timeout"]; got != "5s" {
+		t.Errorf("Expected reassembler timeout 5s, got %v", got)
+	}
+
+	txManager := protocol.NewTransactionManager(cfg.TxTimeout)
+	if got := txManager.GetStats()["defaultTimeout"]; got != "2.5s" {
+		t.Errorf("Expected transaction timeout 2.5s, got %v", got)
+	}
+}
+
+// TestSetTimeoutsRejectsNonPositive verifies zero and negative timeouts are rejected
+func TestSetTimeoutsRejectsNonPositive(t *testing.T) {
+	cfg := &Config{}
+	if err := cfg.SetTimeouts(0, time.Second); err == nil {
+		t.Error("Expected error for zero reassembly timeout")
+	}
+	if err := cfg.SetTimeouts(time.Second, -time.Second); err == nil {
+		t.Error("Expected error for negative tx timeout")
+	}
+}
+
+// TestMarshalJSONRedactsSecrets verifies a marshaled config never carries the
+// long-term key, and has every field whether or not it is set
+func TestMarshalJSONRedactsSecrets(t *testing.T) {
+	for _, key := range [][]byte{nil, {0xde, 0xad, 0xbe, 0xef}} {
+		data, err := json.Marshal(Config{JPAKELongTermKey: key})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if strings.Contains(string(data), "deadbeef") {
+			t.Errorf("Expected the long-term key to be redacted, got %s", data)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		for _, field := range []string{"pumpX2Path", "pumpX2Mode", "pumpX2JarPath", "gradleCmd", "javaCmd",
+			"jpakeMode", "jpakeLongTermKey", "reassemblyTimeout", "txTimeout", "maxMessageSize",
+			"historyPageSize", "rxWorkers", "logLevel"} {
+			if _, ok := body[field]; !ok {
+				t.Errorf("Expected %s in the marshaled config, got %s", field, data)
+			}
+		}
+		if len(key) > 0 && body["jpakeLongTermKey"] != redactedValue {
+			t.Errorf("Expected a set long-term key to be masked, got %v", body["jpakeLongTermKey"])
+		}
+	}
+}
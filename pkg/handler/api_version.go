@@ -2,6 +2,9 @@ package handler
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
 	"github.com/jwoglom/faketandem/pkg/state"
@@ -9,9 +12,86 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultUnsupportedAPIVersionErrorCode is the ErrorResponse errorCode sent
+// to a client proposing an API version outside the accepted range
+const DefaultUnsupportedAPIVersionErrorCode = unsupportedCommandErrorCode
+
+// APIVersion is an ApiVersion major.minor pair
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// ParseAPIVersion parses a "major.minor" API version
+func ParseAPIVersion(version string) (APIVersion, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 {
+		return APIVersion{}, fmt.Errorf("invalid API version %q (expected major.minor)", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return APIVersion{}, fmt.Errorf("invalid API major version %q", parts[0])
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return APIVersion{}, fmt.Errorf("invalid API minor version %q", parts[1])
+	}
+	return APIVersion{Major: major, Minor: minor}, nil
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// less returns true if v is an older version than other
+func (v APIVersion) less(other APIVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+// APIVersionRange is the range of API versions a session can be negotiated
+// at, and the ErrorResponse errorCode sent when the pump's is outside it
+type APIVersionRange struct {
+	Min       APIVersion
+	Max       APIVersion
+	ErrorCode int
+}
+
+// ParseAPIVersionRange parses an inclusive "min-max" range of API versions,
+// e.g. "2.0-2.5"
+func ParseAPIVersionRange(versions string, errorCode int) (*APIVersionRange, error) {
+	parts := strings.Split(versions, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid API version range %q (expected min-max)", versions)
+	}
+	min, err := ParseAPIVersion(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	max, err := ParseAPIVersion(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if max.less(min) {
+		return nil, fmt.Errorf("invalid API version range %q: %s is older than %s", versions, max, min)
+	}
+	return &APIVersionRange{Min: min, Max: max, ErrorCode: errorCode}, nil
+}
+
+// accepts returns true if v is within the range
+func (r *APIVersionRange) accepts(v APIVersion) bool {
+	return !v.less(r.Min) && !r.Max.less(v)
+}
+
 // APIVersionHandler handles ApiVersionRequest messages
 type APIVersionHandler struct {
 	bridge *pumpx2.Bridge
+
+	// accepted, if set, limits the API versions negotiated normally
+	accepted *APIVersionRange
+	mutex    sync.Mutex
 }
 
 // NewAPIVersionHandler creates a new API version handler
@@ -31,6 +111,23 @@ func (h *APIVersionHandler) RequiresAuth() bool {
 	return false // ApiVersion doesn't require authentication
 }
 
+// SetAcceptedVersions makes ApiVersionRequest get an unsupported version
+// ErrorResponse instead of the pump's version while the pump's version is
+// outside accepted, exercising a client's upgrade prompt. pumpX2's
+// ApiVersionRequest has no cargo, so the client can't propose a version of
+// its own: the pump's is the only one negotiated. Nil accepts any version.
+func (h *APIVersionHandler) SetAcceptedVersions(accepted *APIVersionRange) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.accepted = accepted
+}
+
+func (h *APIVersionHandler) getAcceptedVersions() *APIVersionRange {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.accepted
+}
+
 // HandleMessage processes an ApiVersionRequest
 func (h *APIVersionHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling ApiVersionRequest: txID=%d", msg.TxID)
@@ -39,6 +136,12 @@ func (h *APIVersionHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *
 	major := pumpState.GetAPIVersionMajor()
 	minor := pumpState.GetAPIVersionMinor()
 
+	if accepted := h.getAcceptedVersions(); accepted != nil {
+		if version := (APIVersion{Major: major, Minor: minor}); !accepted.accepts(version) {
+			return h.unsupportedVersion(msg, version, accepted)
+		}
+	}
+
 	log.Debugf("Responding with API version: %d.%d", major, minor)
 
 	// Build response using pumpX2 bridge
@@ -60,3 +163,23 @@ func (h *APIVersionHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *
 		Immediate:       true,
 	}, nil
 }
+
+// unsupportedVersion answers an ApiVersionRequest that can't be negotiated at
+// version with an ErrorResponse
+func (h *APIVersionHandler) unsupportedVersion(msg *pumpx2.ParsedMessage, version APIVersion, accepted *APIVersionRange) (*Response, error) {
+	log.Warnf("Refusing to negotiate API version %s (accepting %s-%s)", version, accepted.Min, accepted.Max)
+
+	// ErrorResponse(int requestCodeId, ErrorCode errorCode)
+	response, err := h.bridge.EncodeMessage(msg.TxID, "ErrorResponse", map[string]interface{}{
+		"requestCodeId": msg.Opcode,
+		"errorCode":     accepted.ErrorCode,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode unsupported API version ErrorResponse: %w", err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// handleAPIVersion sends an ApiVersionRequest, which has no cargo, to a
+// handler accepting 2.0-2.5 on a pump at API version major.minor
+func handleAPIVersion(t *testing.T, major, minor int) *Response {
+	t.Helper()
+	accepted, err := ParseAPIVersionRange("2.0-2.5", DefaultUnsupportedAPIVersionErrorCode)
+	if err != nil {
+		t.Fatalf("ParseAPIVersionRange failed: %v", err)
+	}
+	h := NewAPIVersionHandler(pumpx2.NewBridgeWithRunner(mockrunner.New()))
+	h.SetAcceptedVersions(accepted)
+	pumpState := state.NewPumpState()
+	pumpState.APIVersionMajor = major
+	pumpState.APIVersionMinor = minor
+
+	response, err := h.HandleMessage(&pumpx2.ParsedMessage{
+		MessageType: "ApiVersionRequest",
+		Opcode:      32,
+		TxID:        1,
+		Cargo:       map[string]interface{}{},
+	}, pumpState)
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+	return response
+}
+
+// TestAPIVersionRejectsVersionBelowMinimum verifies a pump whose version is
+// older than the accepted range answers with an unsupported version error
+func TestAPIVersionRejectsVersionBelowMinimum(t *testing.T) {
+	response := handleAPIVersion(t, 1, 9)
+	if response.ResponseMessage.MessageType != "ErrorResponse" {
+		t.Errorf("Expected an ErrorResponse, got %s", response.ResponseMessage.MessageType)
+	}
+}
+
+// TestAPIVersionAnswersVersionInRange verifies a pump whose version is
+// within the accepted range answers with its version
+func TestAPIVersionAnswersVersionInRange(t *testing.T) {
+	response := handleAPIVersion(t, 2, 5)
+	if response.ResponseMessage.MessageType != "ApiVersionResponse" {
+		t.Errorf("Expected an ApiVersionResponse, got %s", response.ResponseMessage.MessageType)
+	}
+}
+
+// TestParseAPIVersionRangeRejectsInvalid verifies malformed or inverted
+// ranges are refused
+func TestParseAPIVersionRangeRejectsInvalid(t *testing.T) {
+	for _, versions := range []string{"", "2.5", "2-3", "2.5-2.0", "a.b-2.0"} {
+		if _, err := ParseAPIVersionRange(versions, 0); err == nil {
+			t.Errorf("Expected %q to be rejected", versions)
+		}
+	}
+}
@@ -11,6 +11,32 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// appInstanceIDFromCargo extracts the appInstanceId sent by the app, if any
+func appInstanceIDFromCargo(cargo map[string]interface{}) (uint32, bool) {
+	for _, key := range []string{"appInstanceId", "appInstanceID"} {
+		switch val := cargo[key].(type) {
+		case int:
+			return uint32(val), true
+		case float64:
+			return uint32(val), true
+		}
+	}
+	return 0, false
+}
+
+// bindAppInstance scopes the auth session to msg's appInstanceId, if it has
+// one, and returns the current app session. When the instance changed, the
+// previous app's in-progress JPAKE session is dropped from sessions (if
+// non-nil) along with its authentication.
+func bindAppInstance(msg *pumpx2.ParsedMessage, pumpState *state.PumpState, sessions *JPAKESessionManager) state.AppSession {
+	if appInstanceID, ok := appInstanceIDFromCargo(msg.Cargo); ok {
+		if previous, changed := pumpState.BindAppInstance(appInstanceID); changed && sessions != nil {
+			sessions.Remove(previous.Key())
+		}
+	}
+	return pumpState.GetAppSession()
+}
+
 // CentralChallengeHandler handles CentralChallengeRequest messages
 // This is the first step in the authentication flow
 type CentralChallengeHandler struct {
@@ -39,13 +65,8 @@ func (h *CentralChallengeHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpS
 	log.Infof("Handling CentralChallengeRequest: txID=%d", msg.TxID)
 	log.Info("Client is initiating authentication")
 
-	// Extract the appInstanceID from the request if present
-	appInstanceID := uint32(0)
-	if val, ok := msg.Cargo["appInstanceId"].(float64); ok {
-		appInstanceID = uint32(val)
-	} else if val, ok := msg.Cargo["appInstanceID"].(float64); ok {
-		appInstanceID = uint32(val)
-	}
+	session := bindAppInstance(msg, pumpState, nil)
+	appInstanceID := session.AppInstanceID
 
 	log.Debugf("App instance ID: %d", appInstanceID)
 
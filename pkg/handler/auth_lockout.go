@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultAuthLockoutCooldown is how long authentication is refused after too
+// many failed attempts, unless configured otherwise
+const DefaultAuthLockoutCooldown = time.Minute
+
+// authMessageTypes are the requests that attempt authentication, which are
+// counted when they fail and refused during a lockout
+var authMessageTypes = map[string]bool{
+	"CentralChallengeRequest":      true,
+	"PumpChallengeRequest":         true,
+	"Jpake1aRequest":               true,
+	"Jpake1bRequest":               true,
+	"Jpake2Request":                true,
+	"Jpake3SessionKeyRequest":      true,
+	"Jpake4KeyConfirmationRequest": true,
+}
+
+// AuthLockout counts failed authentication attempts on the current
+// connection and, once the threshold is reached, refuses authentication for
+// a cooldown, simulating a pump's protection against pairing code guessing
+type AuthLockout struct {
+	threshold int // 0 disables the lockout
+	cooldown  time.Duration
+	failures  int
+	until     time.Time
+	now       func() time.Time
+	mtx       sync.Mutex
+}
+
+// AuthLockoutStatus is the lockout state reported by the API
+type AuthLockoutStatus struct {
+	Threshold       int        `json:"threshold"`
+	CooldownSeconds float64    `json:"cooldownSeconds"`
+	Failures        int        `json:"failures"`
+	LockedOut       bool       `json:"lockedOut"`
+	LockedUntil     *time.Time `json:"lockedUntil,omitempty"`
+}
+
+func (l *AuthLockout) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// Configure locks out authentication for cooldown after threshold failed
+// attempts on one connection; a zero threshold disables the lockout
+func (l *AuthLockout) Configure(threshold int, cooldown time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.threshold = threshold
+	l.cooldown = cooldown
+}
+
+// recordFailure counts a failed attempt, starting a lockout once the
+// threshold is reached
+func (l *AuthLockout) recordFailure() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.threshold <= 0 {
+		return
+	}
+	l.failures++
+	if l.failures < l.threshold {
+		log.Warnf("Failed authentication attempt %d of %d", l.failures, l.threshold)
+		return
+	}
+	l.until = l.clock().Add(l.cooldown)
+	l.failures = 0
+	log.Warnf("Authentication locked out for %s after %d failed attempts", l.cooldown, l.threshold)
+}
+
+// lockedOut returns true while authentication is refused
+func (l *AuthLockout) lockedOut() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.clock().Before(l.until)
+}
+
+// ResetFailures forgets the failed attempts counted so far, as on a new
+// connection or a successful authentication. A lockout in progress still
+// runs out its cooldown.
+func (l *AuthLockout) ResetFailures() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.failures = 0
+}
+
+// Clear ends any lockout and forgets the failed attempts
+func (l *AuthLockout) Clear() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.failures = 0
+	l.until = time.Time{}
+	log.Info("Authentication lockout cleared")
+}
+
+// Status returns the lockout's configuration and current state
+func (l *AuthLockout) Status() AuthLockoutStatus {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	status := AuthLockoutStatus{
+		Threshold:       l.threshold,
+		CooldownSeconds: l.cooldown.Seconds(),
+		Failures:        l.failures,
+	}
+	if l.clock().Before(l.until) {
+		until := l.until
+		status.LockedOut = true
+		status.LockedUntil = &until
+	}
+	return status
+}
+
+// GetAuthLockout returns the failed authentication lockout
+func (r *Router) GetAuthLockout() *AuthLockout {
+	return r.authLockout
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
 	"github.com/jwoglom/faketandem/pkg/state"
@@ -9,6 +10,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// BolusPermissionResponse nackReasonId values. 0 grants permission;
+// pumpX2's NackReason names 1 INVALID_PUMPING_STATE, which the pump reports
+// both while suspended and while a bolus is already active.
+const (
+	nackReasonPermissionGranted   = 0
+	nackReasonInvalidPumpingState = 1
+)
+
 // BolusPermissionHandler handles BolusPermissionRequest messages
 type BolusPermissionHandler struct {
 	bridge *pumpx2.Bridge
@@ -35,22 +44,27 @@ func (h *BolusPermissionHandler) RequiresAuth() bool {
 func (h *BolusPermissionHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling BolusPermissionRequest: txID=%d", msg.TxID)
 
-	// Check if pump is in a state where bolus is allowed
-	if pumpState.Bolus.Active {
-		log.Warn("Bolus permission denied: bolus already active")
-		// TODO: Return proper denial response
+	// Like the real pump, refuse permission for a second bolus while one is
+	// outstanding, and for any bolus while suspended
+	status, nackReason := 0, nackReasonPermissionGranted
+	switch {
+	case pumpState.IsPumpingSuspended():
+		log.Warn("Bolus permission denied: pumping is suspended")
+		status, nackReason = 1, nackReasonInvalidPumpingState
+	case pumpState.IsBolusActive():
+		log.Warn("Bolus permission denied: a bolus is already active")
+		status, nackReason = 1, nackReasonInvalidPumpingState
+	default:
+		log.Info("Granting bolus permission")
 	}
 
-	// Grant bolus permission
-	log.Info("Granting bolus permission")
-
 	response, err := h.bridge.EncodeMessage(
 		msg.TxID,
 		"BolusPermissionResponse",
 		map[string]interface{}{
-			"status":       0,
+			"status":       status,
 			"bolusId":      pumpState.GetNextBolusID(),
-			"nackReasonId": 0,
+			"nackReasonId": nackReason,
 		},
 	)
 
@@ -93,24 +107,26 @@ func (h *BolusCalcDataSnapshotHandler) HandleMessage(msg *pumpx2.ParsedMessage,
 	// Provide current bolus calculation data. BolusCalcDataSnapshotResponse's
 	// real constructor takes 13 fields (int/long amounts scaled by 1000, per
 	// pumpX2's convention elsewhere) -- see BolusCalcDataSnapshotResponse.java.
+	therapy := pumpState.GetTherapyConfig()
+	iob := pumpState.GetIOB()
 	calcData := map[string]interface{}{
 		"isUnacked":                 false,
-		"correctionFactor":          50,                          // mg/dL/U - placeholder
-		"iob":                       int64(pumpState.IOB * 1000), // milli-units
-		"cartridgeRemainingInsulin": 20000,                       // milli-units - placeholder
-		"targetBg":                  100,                         // mg/dL - placeholder
-		"isf":                       50,                          // mg/dL/U - placeholder
+		"correctionFactor":          50,                // mg/dL/U - placeholder
+		"iob":                       int64(iob * 1000), // milli-units
+		"cartridgeRemainingInsulin": 20000,             // milli-units - placeholder
+		"targetBg":                  100,               // mg/dL - placeholder
+		"isf":                       50,                // mg/dL/U - placeholder
 		"carbEntryEnabled":          true,
-		"carbRatio":                 int64(12000), // g/U * 1000 - placeholder
-		"maxBolusAmount":            25000,        // milli-units - placeholder
-		"maxBolusHourlyTotal":       int64(25000), // milli-units - placeholder
+		"carbRatio":                 int64(12000),                           // g/U * 1000 - placeholder
+		"maxBolusAmount":            int(therapy.MaxBolus * 1000),           // milli-units
+		"maxBolusHourlyTotal":       int64(therapy.MaxHourlyInsulin * 1000), // milli-units
 		"maxBolusEventsExceeded":    false,
-		"maxIobEventsExceeded":      false,
+		"maxIobEventsExceeded":      iob >= therapy.MaxIOB,
 		"isAutopopAllowed":          true,
 	}
 
 	log.Debugf("Bolus calc data: IOB=%.2f, basal=%.2f, bolusID=%d",
-		pumpState.IOB, pumpState.GetBasalRate(), pumpState.GetNextBolusID())
+		iob, pumpState.GetBasalRate(), pumpState.GetNextBolusID())
 
 	response, err := h.bridge.EncodeMessage(
 		msg.TxID,
@@ -155,18 +171,11 @@ func (h *InitiateBolusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpStat
 	log.Infof("Handling InitiateBolusRequest: txID=%d", msg.TxID)
 
 	// Extract bolus parameters
-	bolusUnits := 0.0
+	bolusUnits := bolusRequestUnits(msg.Cargo)
 	bolusID := uint32(0)
 
-	if val, ok := msg.Cargo["insulin"].(float64); ok {
-		bolusUnits = val
-	} else if val, ok := msg.Cargo["units"].(float64); ok {
-		bolusUnits = val
-	}
-
-	if val, ok := msg.Cargo["bolusId"].(float64); ok {
-		bolusID = uint32(val)
-	} else if val, ok := msg.Cargo["bolusID"].(float64); ok {
+	// pumpX2 parses the real request's bolusID as an int
+	if val, ok := cargoNumber(msg.Cargo, "bolusId", "bolusID"); ok {
 		bolusID = uint32(val)
 	}
 
@@ -174,11 +183,18 @@ func (h *InitiateBolusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpStat
 		return nil, fmt.Errorf("invalid bolus units: %.2f", bolusUnits)
 	}
 
-	log.Infof("Initiating bolus: %.2f units, bolusID=%d", bolusUnits, bolusID)
+	// Deny a bolus that would take the last hour's delivery over the limit
+	status := 0
+	var stateChanges []StateChange
+	if remaining, limited := pumpState.HourlyInsulinRemaining(time.Now()); limited && bolusUnits > remaining {
+		log.Warnf("Denying bolus of %.2f units: only %.2f units left under the hourly limit", bolusUnits, remaining)
+		status = 1
+		stateChanges = append(stateChanges, hourlyLimitAlert())
+	} else {
+		log.Infof("Initiating bolus: %.2f units, bolusID=%d", bolusUnits, bolusID)
 
-	// Start the bolus
-	stateChanges := []StateChange{
-		{
+		// Start the bolus
+		stateChanges = append(stateChanges, StateChange{
 			Type: StateChangeBolus,
 			Data: &state.BolusState{
 				Active:         true,
@@ -186,14 +202,14 @@ func (h *InitiateBolusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpStat
 				UnitsTotal:     bolusUnits,
 				BolusID:        bolusID,
 			},
-		},
+		})
 	}
 
 	response, err := h.bridge.EncodeMessage(
 		msg.TxID,
 		"InitiateBolusResponse",
 		map[string]interface{}{
-			"status":       0,
+			"status":       status,
 			"bolusId":      bolusID,
 			"statusTypeId": 0,
 		},
@@ -210,6 +226,33 @@ func (h *InitiateBolusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpStat
 	}, nil
 }
 
+// bolusRequestUnits returns the units an InitiateBolusRequest asks for, or
+// 0 if it names none. pumpX2 parses the real request's totalVolume as int
+// milli-units.
+func bolusRequestUnits(cargo map[string]interface{}) float64 {
+	if val, ok := cargoNumber(cargo, "insulin", "units"); ok {
+		return val
+	}
+	if val, ok := cargoNumber(cargo, "totalVolume"); ok {
+		return val / 1000
+	}
+	return 0
+}
+
+// hourlyLimitAlert is the state change raising the alert for delivery
+// denied by the hourly insulin limit
+func hourlyLimitAlert() StateChange {
+	return StateChange{
+		Type: StateChangeAlert,
+		Data: state.Alert{
+			Type:      state.AlertHourlyInsulinLimit,
+			Priority:  state.PriorityWarning,
+			Message:   "Hourly insulin limit reached",
+			Timestamp: time.Now(),
+		},
+	}
+}
+
 // RemoteBgEntryHandler handles RemoteBgEntryRequest messages
 type RemoteBgEntryHandler struct {
 	bridge *pumpx2.Bridge
@@ -236,20 +279,25 @@ func (h *RemoteBgEntryHandler) RequiresAuth() bool {
 func (h *RemoteBgEntryHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling RemoteBgEntryRequest: txID=%d", msg.TxID)
 
-	bgValue := 0.0
-	if val, ok := msg.Cargo["bgValue"].(float64); ok {
-		bgValue = val
-	} else if val, ok := msg.Cargo["bg"].(float64); ok {
-		bgValue = val
-	}
+	bgValue, _ := cargoNumber(msg.Cargo, "bgValue", "bg")
 
-	log.Infof("Remote BG entry: %.0f mg/dL", bgValue)
+	// BG is entered in the pump's display units, so the accepted range
+	// depends on the units preference
+	unit := pumpState.GetGlucoseUnit()
+	status := 0
+	if minBG, maxBG := state.BGRange(unit); bgValue < minBG || bgValue > maxBG {
+		log.Warnf("Rejecting remote BG entry %.1f %s: outside %.1f-%.1f",
+			bgValue, state.GlucoseUnitName(unit), minBG, maxBG)
+		status = 1
+	} else {
+		log.Infof("Remote BG entry: %.1f %s", bgValue, state.GlucoseUnitName(unit))
+	}
 
 	response, err := h.bridge.EncodeMessage(
 		msg.TxID,
 		"RemoteBgEntryResponse",
 		map[string]interface{}{
-			"status": 0,
+			"status": status,
 		},
 	)
 
@@ -388,18 +436,37 @@ func (h *CancelBolusHandler) RequiresAuth() bool {
 func (h *CancelBolusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling CancelBolusRequest: txID=%d", msg.TxID)
 
-	if !pumpState.Bolus.Active {
-		log.Warn("No active bolus to cancel")
-	} else {
-		log.Infof("Canceling bolus: delivered %.2f of %.2f units",
-			pumpState.Bolus.UnitsDelivered, pumpState.Bolus.UnitsTotal)
+	// Cancel the requested bolus, which may be stacked behind the one
+	// delivering, or the delivering bolus if none is named
+	// CancelBolusRequest(int bolusId)
+	bolusID := uint32(0)
+	if val, ok := cargoNumber(msg.Cargo, "bolusId"); ok {
+		bolusID = uint32(val)
+	}
+	if bolusID == 0 {
+		if boluses := pumpState.GetActiveBoluses(); len(boluses) > 0 {
+			bolusID = boluses[0].BolusID
+		}
+	}
+
+	active := false
+	for _, bolus := range pumpState.GetActiveBoluses() {
+		if bolus.BolusID == bolusID {
+			active = true
+			log.Infof("Canceling bolus %d: delivered %.2f of %.2f units",
+				bolusID, bolus.UnitsDelivered, bolus.UnitsTotal)
+		}
+	}
+	if !active {
+		log.Warnf("No active bolus %d to cancel", bolusID)
 	}
 
 	stateChanges := []StateChange{
 		{
 			Type: StateChangeBolus,
 			Data: &state.BolusState{
-				Active: false,
+				Active:  false,
+				BolusID: bolusID,
 			},
 		},
 	}
@@ -409,7 +476,7 @@ func (h *CancelBolusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState
 		"CancelBolusResponse",
 		map[string]interface{}{
 			"statusId": 0,
-			"bolusId":  pumpState.Bolus.BolusID,
+			"bolusId":  bolusID,
 			"reasonId": 0,
 		},
 	)
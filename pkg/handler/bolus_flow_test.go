@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// bolusFlowClient drives a router the way a real client does: each request is
+// encoded and framed by the mock runner, parsed back off the wire and routed,
+// and the response is reassembled from the captured packets
+type bolusFlowClient struct {
+	t    *testing.T
+	r    *Router
+	sent *[]sentPacket
+	txID int
+}
+
+// request sends messageName on charType and returns the parsed response and
+// any qualifying event bitmasks sent while handling it
+func (c *bolusFlowClient) request(charType bluetooth.CharacteristicType, messageName string, params map[string]interface{}) (*pumpx2.ParsedMessage, []uint32) {
+	c.t.Helper()
+	txID := c.txID
+	c.txID++
+
+	request, err := c.r.bridge.EncodeMessage(txID, messageName, params)
+	if err != nil {
+		c.t.Fatalf("EncodeMessage(%s) failed: %v", messageName, err)
+	}
+	msg, err := c.r.bridge.ParseMessage(charType, request.Packets)
+	if err != nil {
+		c.t.Fatalf("ParseMessage(%s) failed: %v", messageName, err)
+	}
+
+	start := len(*c.sent)
+	if err := c.r.RouteMessage(charType, msg); err != nil {
+		c.t.Fatalf("RouteMessage(%s) failed: %v", messageName, err)
+	}
+
+	var packets []string
+	sent := (*c.sent)[start:]
+	for _, p := range sent {
+		if p.charType == charType {
+			packets = append(packets, hex.EncodeToString(p.data))
+		}
+	}
+	if len(packets) == 0 {
+		c.t.Fatalf("No response sent for %s", messageName)
+	}
+	response, err := c.r.bridge.ParseMessage(charType, packets)
+	if err != nil {
+		c.t.Fatalf("ParseMessage of %s response failed: %v", messageName, err)
+	}
+	if response.TxID != txID {
+		c.t.Errorf("%s: expected response txID=%d, got %d", response.MessageType, txID, response.TxID)
+	}
+	return response, qualifyingEvents(sent)
+}
+
+// hasBolusChange returns true if any of events has the BOLUS_CHANGE bit set
+func hasBolusChange(events []uint32) bool {
+	for _, bits := range events {
+		if bits&QEBolusChange != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAuthenticatedBolusFlow drives the full sequence a client performs to
+// deliver a bolus -- handshake, legacy authentication, bolus permission,
+// calculator snapshot and initiation -- then advances the simulator's clock
+// until the bolus completes
+func TestAuthenticatedBolusFlow(t *testing.T) {
+	r, sent := newCapturingRouter(t, mockrunner.New())
+	c := &bolusFlowClient{t: t, r: r, sent: sent}
+
+	now := time.Now()
+	sim := state.NewSimulator(r.pumpState, time.Second)
+	sim.SetEventNotifier(r.GetQualifyingEventsNotifier())
+	sim.SetClock(func() time.Time { return now })
+
+	// Handshake
+	resp, _ := c.request(bluetooth.CharCurrentStatus, "ApiVersionRequest", nil)
+	if resp.MessageType != "ApiVersionResponse" || resp.Cargo["majorVersion"] != r.pumpState.GetAPIVersionMajor() {
+		t.Fatalf("Unexpected ApiVersion response: %s %v", resp.MessageType, resp.Cargo)
+	}
+	resp, _ = c.request(bluetooth.CharCurrentStatus, "TimeSinceResetRequest", nil)
+	if resp.MessageType != "TimeSinceResetResponse" {
+		t.Fatalf("Expected TimeSinceResetResponse, got %s", resp.MessageType)
+	}
+
+	// Bolusing is refused until the client authenticates
+	if r.pumpState.IsAuthenticated {
+		t.Fatal("Pump should not be authenticated before the challenge")
+	}
+
+	// Legacy authentication
+	resp, _ = c.request(bluetooth.CharAuthorization, "CentralChallengeRequest", map[string]interface{}{
+		"appInstanceId": 7, "centralChallenge": "0001020304050607",
+	})
+	if resp.MessageType != "CentralChallengeResponse" || resp.Cargo["appInstanceId"] != 7 {
+		t.Fatalf("Unexpected CentralChallenge response: %s %v", resp.MessageType, resp.Cargo)
+	}
+	resp, _ = c.request(bluetooth.CharAuthorization, "PumpChallengeRequest", map[string]interface{}{
+		"appInstanceId": 7, "pumpChallengeHash": "000102030405060708090a0b0c0d0e0f10111213",
+	})
+	if resp.MessageType != "PumpChallengeResponse" {
+		t.Fatalf("Expected PumpChallengeResponse, got %s", resp.MessageType)
+	}
+	if !r.pumpState.IsAuthenticated {
+		t.Fatal("Pump should be authenticated after the pump challenge")
+	}
+
+	// Bolus permission
+	resp, _ = c.request(bluetooth.CharControl, "BolusPermissionRequest", nil)
+	if resp.MessageType != "BolusPermissionResponse" || resp.Cargo["status"] != 0 ||
+		resp.Cargo["nackReasonId"] != nackReasonPermissionGranted {
+		t.Fatalf("Unexpected BolusPermission response: %s %v", resp.MessageType, resp.Cargo)
+	}
+	bolusID, ok := resp.Cargo["bolusId"].(int)
+	if !ok || bolusID == 0 {
+		t.Fatalf("Expected a bolus ID, got %v", resp.Cargo["bolusId"])
+	}
+
+	// Calculator snapshot
+	resp, _ = c.request(bluetooth.CharCurrentStatus, "BolusCalcDataSnapshotRequest", nil)
+	if resp.MessageType != "BolusCalcDataSnapshotResponse" || resp.Cargo["iob"] != 0 {
+		t.Fatalf("Unexpected BolusCalcDataSnapshot response: %s %v", resp.MessageType, resp.Cargo)
+	}
+
+	// Initiate a 2 unit bolus, which starts delivering
+	resp, events := c.request(bluetooth.CharControl, "InitiateBolusRequest", map[string]interface{}{
+		"totalVolume": 2000, "bolusID": bolusID,
+	})
+	if resp.MessageType != "InitiateBolusResponse" || resp.Cargo["status"] != 0 || resp.Cargo["bolusId"] != bolusID {
+		t.Fatalf("Unexpected InitiateBolus response: %s %v", resp.MessageType, resp.Cargo)
+	}
+	if !hasBolusChange(events) {
+		t.Errorf("Expected a BOLUS_CHANGE qualifying event on bolus start, got %v", events)
+	}
+	if !r.pumpState.Bolus.Active || r.pumpState.Bolus.UnitsTotal != 2.0 || r.pumpState.Bolus.BolusID != uint32(bolusID) {
+		t.Fatalf("Expected an active 2 unit bolus %d, got %+v", bolusID, r.pumpState.Bolus)
+	}
+
+	// Partway through, the bolus is still delivering
+	start := len(*sent)
+	now = now.Add(time.Duration(1.0 / state.BolusDeliveryRate * float64(time.Second)))
+	sim.Tick()
+	if !r.pumpState.Bolus.Active || hasBolusChange(qualifyingEvents((*sent)[start:])) {
+		t.Fatalf("Bolus should still be delivering halfway, got %+v", r.pumpState.Bolus)
+	}
+
+	// Once its delivery time has passed, it completes
+	start = len(*sent)
+	now = now.Add(time.Duration(2.0 / state.BolusDeliveryRate * float64(time.Second)))
+	sim.Tick()
+	if r.pumpState.Bolus.Active {
+		t.Fatal("Bolus should be complete")
+	}
+	if !hasBolusChange(qualifyingEvents((*sent)[start:])) {
+		t.Errorf("Expected a BOLUS_CHANGE qualifying event on bolus complete, got %v", qualifyingEvents((*sent)[start:]))
+	}
+	if r.pumpState.Bolus.UnitsDelivered != 2.0 {
+		t.Errorf("Expected 2 units delivered, got %.2f", r.pumpState.Bolus.UnitsDelivered)
+	}
+	// Basal delivered on the same ticks adds slightly to IOB
+	if iob := r.pumpState.GetIOB(); math.Abs(iob-2.0) > 0.1 {
+		t.Errorf("Expected about 2 units IOB, got %.4f", iob)
+	}
+
+	var completed *state.HistoryLogEntry
+	entries := r.pumpState.GetHistoryLogEntries(0, ^uint32(0))
+	for i := range entries {
+		if entries[i].TypeID == state.HistoryBolusCompleted {
+			completed = &entries[i]
+		}
+	}
+	if completed == nil {
+		t.Fatal("Expected a BolusCompleted history entry")
+	}
+	if completed.Data["bolusId"] != uint32(bolusID) || completed.Data["unitsDelivered"] != 2.0 {
+		t.Errorf("Unexpected BolusCompleted entry: %v", completed.Data)
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// TestInitiateBolusDeniedOverHourlyLimit verifies a bolus that would exceed
+// the hourly insulin limit is denied and raises an alert instead of starting
+func TestInitiateBolusDeniedOverHourlyLimit(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	r.pumpState.RecordDelivery(time.Now(), r.pumpState.GetTherapyConfig().MaxHourlyInsulin-1)
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "InitiateBolusRequest",
+		Cargo:       map[string]interface{}{"insulin": 2.0, "bolusId": 3},
+	})
+	if params["status"] != 1 {
+		t.Errorf("Expected bolus denied with status 1, got %v", params["status"])
+	}
+	if r.pumpState.IsBolusActive() {
+		t.Error("Expected no bolus to start")
+	}
+	if alerts := r.pumpState.ActiveAlerts; len(alerts) != 1 || alerts[0].Type != state.AlertHourlyInsulinLimit || alerts[0].ID == 0 {
+		t.Errorf("Expected an hourly limit alert with an ID, got %+v", alerts)
+	}
+
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "InitiateBolusRequest",
+		Cargo:       map[string]interface{}{"insulin": 0.5, "bolusId": 4},
+	})
+	if params["status"] != 0 || !r.pumpState.IsBolusActive() {
+		t.Errorf("Expected a bolus within the limit to start, got status %v", params["status"])
+	}
+}
+
+// TestBolusPermissionDeniedWhileSuspendedOrBolusActive verifies permission
+// is granted normally, and denied with a nack reason while suspended or
+// while a bolus is active
+func TestBolusPermissionDeniedWhileSuspendedOrBolusActive(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	request := &pumpx2.ParsedMessage{MessageType: "BolusPermissionRequest"}
+
+	params := routeGlobals(t, r, runner, request)
+	if params["status"] != 0 || params["nackReasonId"] != nackReasonPermissionGranted {
+		t.Errorf("Expected permission granted, got %v", params)
+	}
+
+	r.pumpState.SetPumpingSuspended(true)
+	params = routeGlobals(t, r, runner, request)
+	if params["status"] != 1 || params["nackReasonId"] != nackReasonInvalidPumpingState {
+		t.Errorf("Expected permission denied while suspended, got %v", params)
+	}
+	r.pumpState.SetPumpingSuspended(false)
+
+	if err := r.pumpState.StartBolus(1.0, 1); err != nil {
+		t.Fatalf("StartBolus failed: %v", err)
+	}
+	params = routeGlobals(t, r, runner, request)
+	if params["status"] != 1 || params["nackReasonId"] != nackReasonInvalidPumpingState {
+		t.Errorf("Expected permission denied with a bolus active, got %v", params)
+	}
+	if n := len(r.pumpState.GetActiveBoluses()); n != 1 {
+		t.Errorf("Expected no bolus started by the denied request, got %d active", n)
+	}
+
+	r.pumpState.CancelBolus(1)
+	params = routeGlobals(t, r, runner, request)
+	if params["status"] != 0 || params["nackReasonId"] != nackReasonPermissionGranted {
+		t.Errorf("Expected permission granted once the bolus ended, got %v", params)
+	}
+}
+
+// TestCancelBolusRequestCancelsNamedBolus verifies CancelBolusRequest
+// cancels the bolus its int bolusId names, even one stacked behind the bolus
+// delivering
+func TestCancelBolusRequestCancelsNamedBolus(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	for _, id := range []int{5, 6} {
+		routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+			MessageType: "InitiateBolusRequest",
+			Cargo:       map[string]interface{}{"totalVolume": 1000, "bolusID": id},
+		})
+	}
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "CancelBolusRequest",
+		Cargo:       map[string]interface{}{"bolusId": 6},
+	})
+	if params["bolusId"] != uint32(6) {
+		t.Errorf("Expected bolus 6 canceled, got %v", params)
+	}
+	if boluses := r.pumpState.GetActiveBoluses(); len(boluses) != 1 || boluses[0].BolusID != 5 {
+		t.Errorf("Expected only bolus 5 left active, got %+v", boluses)
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// busyErrorCode is the ErrorResponse errorCode sent while the pump is busy.
+// pumpX2's ErrorResponse.ErrorCode has no dedicated busy value, so this is
+// its generic UNDEFINED_ERROR.
+const busyErrorCode = 0
+
+// unsupportedCommandErrorCode is the ErrorResponse errorCode sent for a
+// request the pump has no command for, the first code after UNDEFINED_ERROR
+const unsupportedCommandErrorCode = 1
+
+// busyGate tracks a simulated busy window (e.g. a firmware update) during
+// which the pump refuses requests
+type busyGate struct {
+	until time.Time
+	now   func() time.Time
+	mtx   sync.Mutex
+}
+
+func (g *busyGate) clock() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// set starts a busy window lasting d from now; zero or negative d ends it
+func (g *busyGate) set(d time.Duration) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if d <= 0 {
+		g.until = time.Time{}
+		return
+	}
+	g.until = g.clock().Add(d)
+}
+
+// busy returns true while inside the busy window
+func (g *busyGate) busy() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.clock().Before(g.until)
+}
+
+// SetBusy makes the pump answer authenticated requests with a busy
+// ErrorResponse for d, then resume normal handling. Authentication messages
+// are still handled so a client can reconnect while the pump is busy.
+func (r *Router) SetBusy(d time.Duration) {
+	r.busy.set(d)
+	if d > 0 {
+		log.Infof("Pump busy for %s", d)
+	} else {
+		log.Info("Pump no longer busy")
+	}
+}
+
+// IsBusy returns true while a simulated busy window is active
+func (r *Router) IsBusy() bool {
+	return r.busy.busy()
+}
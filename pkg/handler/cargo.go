@@ -0,0 +1,18 @@
+package handler
+
+// cargoNumber returns the first of keys present in cargo as a number.
+// Parsed cargo carries integers as int, while cargo decoded from JSON (e.g.
+// the API and tests) carries them as float64, so both are accepted.
+func cargoNumber(cargo map[string]interface{}, keys ...string) (float64, bool) {
+	for _, key := range keys {
+		switch val := cargo[key].(type) {
+		case int:
+			return float64(val), true
+		case int64:
+			return float64(val), true
+		case float64:
+			return val, true
+		}
+	}
+	return 0, false
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
 	"github.com/jwoglom/faketandem/pkg/state"
@@ -39,10 +40,19 @@ func (h *CartridgeHandler) RequiresAuth() bool {
 	return true
 }
 
-// HandleMessage processes a cartridge mode request
+// HandleMessage processes a cartridge mode request. Entering and leaving
+// change cartridge mode log the old cartridge's removal and the new one's
+// insertion to history.
 func (h *CartridgeHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling %s: txID=%d", h.msgType, msg.TxID)
 
+	switch h.msgType {
+	case "EnterChangeCartridgeModeRequest":
+		pumpState.RemoveCartridge()
+	case "ExitChangeCartridgeModeRequest":
+		pumpState.InsertCartridge()
+	}
+
 	response, err := h.bridge.EncodeMessage(
 		msg.TxID,
 		h.responseType,
@@ -59,3 +69,60 @@ func (h *CartridgeHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *s
 		Immediate:       true,
 	}, nil
 }
+
+// PrimeHandler handles FillCannulaRequest, priming the cannula with insulin
+// from the reservoir as the last step of a cartridge change before pumping
+// resumes
+type PrimeHandler struct {
+	bridge *pumpx2.Bridge
+	now    func() time.Time
+}
+
+// NewPrimeHandler creates a new prime handler
+func NewPrimeHandler(bridge *pumpx2.Bridge) *PrimeHandler {
+	return &PrimeHandler{bridge: bridge, now: time.Now}
+}
+
+// MessageType returns the message type this handler processes
+func (h *PrimeHandler) MessageType() string {
+	return "FillCannulaRequest"
+}
+
+// RequiresAuth returns true if this message requires authentication
+func (h *PrimeHandler) RequiresAuth() bool {
+	return true
+}
+
+// HandleMessage processes a FillCannulaRequest
+func (h *PrimeHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	log.Infof("Handling FillCannulaRequest: txID=%d cargo=%v", msg.TxID, msg.Cargo)
+
+	// FillCannulaRequest(int primeSizeMilliUnits)
+	primeSize, _ := cargoNumber(msg.Cargo, "primeSizeMilliUnits")
+	primeUnits := primeSize / 1000
+
+	status := 0
+	if err := pumpState.Prime(primeUnits, h.now()); err != nil {
+		log.Warnf("Rejecting cannula fill: %v", err)
+		status = 1
+	} else {
+		log.Infof("Primed cannula with %.2f units", primeUnits)
+	}
+
+	// FillCannulaResponse(int status)
+	response, err := h.bridge.EncodeMessage(
+		msg.TxID,
+		"FillCannulaResponse",
+		map[string]interface{}{
+			"status": status,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode FillCannulaResponse: %w", err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+)
+
+// TestPrimeHandlerFillsCannula verifies FillCannulaRequest primes from the
+// reservoir, and is refused once the reservoir can't cover the prime. The
+// prime size is an int, as pumpX2 parses it.
+func TestPrimeHandlerFillsCannula(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	r.pumpState.SetReservoirLevel(0.5)
+	primedAt := time.Unix(1700000000, 0)
+	r.handlers["FillCannulaRequest"].(*PrimeHandler).now = func() time.Time { return primedAt }
+
+	fill := &pumpx2.ParsedMessage{
+		MessageType: "FillCannulaRequest",
+		Cargo:       map[string]interface{}{"primeSizeMilliUnits": 300},
+	}
+	if params := routeGlobals(t, r, runner, fill); params["status"] != 0 {
+		t.Errorf("Expected prime to succeed, got status %v", params["status"])
+	}
+	if units := r.pumpState.Reservoir.CurrentUnits; units < 0.1999 || units > 0.2001 {
+		t.Errorf("Expected 0.2 units left, got %.4f", units)
+	}
+	if !r.pumpState.Cartridge.LastPrime.Equal(primedAt) {
+		t.Errorf("Expected LastPrime %s, got %s", primedAt, r.pumpState.Cartridge.LastPrime)
+	}
+
+	if params := routeGlobals(t, r, runner, fill); params["status"] != 1 {
+		t.Errorf("Expected prime beyond the reservoir to be refused, got status %v", params["status"])
+	}
+}
@@ -2,13 +2,20 @@ package handler
 
 import (
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/settings"
 	"github.com/jwoglom/faketandem/pkg/state"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// bolusSourceControlIQAutoBolus is pumpX2's BolusSource id for a Control-IQ
+// automatic correction bolus
+const bolusSourceControlIQAutoBolus = 7
+
 // CurrentBolusStatusHandler returns dynamic bolus status from pump state
 type CurrentBolusStatusHandler struct {
 	bridge *pumpx2.Bridge
@@ -29,30 +36,32 @@ func (h *CurrentBolusStatusHandler) RequiresAuth() bool {
 	return true
 }
 
-// HandleMessage returns dynamic bolus status from pump state
+// HandleMessage returns the status of the delivering bolus. With boluses
+// stacked, the next one is reported once it takes over.
 func (h *CurrentBolusStatusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
-	pumpState.RLock()
-	bolus := pumpState.Bolus
+	boluses := pumpState.GetActiveBoluses()
 	// CurrentBolusStatusResponse(int statusId, int bolusId, long timestamp,
 	// long requestedVolume, int bolusSourceId, int bolusTypeBitmask)
 	cargo := map[string]interface{}{
+		"statusId":         0,
+		"bolusId":          0,
+		"timestamp":        0,
+		"requestedVolume":  0,
 		"bolusSourceId":    0,
 		"bolusTypeBitmask": 0,
 	}
-	if bolus.Active {
+	if len(boluses) > 0 {
+		bolus := boluses[0]
+		if bolus.Automatic {
+			cargo["bolusSourceId"] = bolusSourceControlIQAutoBolus
+		}
 		cargo["statusId"] = 1
 		cargo["bolusId"] = bolus.BolusID
-		cargo["timestamp"] = pumpState.CurrentTime.Unix()
+		cargo["timestamp"] = pumpState.PumpTime(bolus.StartTime).Unix()
 		cargo["requestedVolume"] = int(bolus.UnitsTotal * 1000)
-	} else {
-		cargo["statusId"] = 0
-		cargo["bolusId"] = 0
-		cargo["timestamp"] = pumpState.CurrentTime.Unix()
-		cargo["requestedVolume"] = 0
 	}
-	pumpState.RUnlock()
 
-	log.Debugf("CurrentBolusStatus: active=%v, bolusId=%v", bolus.Active, cargo["bolusId"])
+	log.Debugf("CurrentBolusStatus: active=%d, bolusId=%v", len(boluses), cargo["bolusId"])
 
 	response, err := h.bridge.EncodeMessage(msg.TxID, "CurrentBolusStatusResponse", cargo)
 	if err != nil {
@@ -65,6 +74,86 @@ func (h *CurrentBolusStatusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pum
 	}, nil
 }
 
+// pumpX2 LastBolusStatus bolusStatusId values
+const (
+	lastBolusStopped   = 0
+	lastBolusCompleted = 3
+)
+
+// LastBolusStatusHandler returns the bolus that most recently finished or
+// was stopped, with how much of it was delivered, for LastBolusStatusRequest
+// and LastBolusStatusV2Request
+type LastBolusStatusHandler struct {
+	bridge      *pumpx2.Bridge
+	messageType string
+}
+
+// NewLastBolusStatusHandler creates a last bolus status handler for
+// messageType, LastBolusStatusRequest or LastBolusStatusV2Request
+func NewLastBolusStatusHandler(bridge *pumpx2.Bridge, messageType string) *LastBolusStatusHandler {
+	return &LastBolusStatusHandler{bridge: bridge, messageType: messageType}
+}
+
+// MessageType returns the message type this handler processes
+func (h *LastBolusStatusHandler) MessageType() string {
+	return h.messageType
+}
+
+// RequiresAuth returns true
+func (h *LastBolusStatusHandler) RequiresAuth() bool {
+	return true
+}
+
+// HandleMessage returns the last bolus's status from pump state
+func (h *LastBolusStatusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	// LastBolusStatusResponse(int status, int bolusId, long timestamp,
+	// long deliveredVolume, int bolusStatusId, int bolusSourceId,
+	// int bolusTypeBitmask, long extendedBolusDuration, byte[] unknown);
+	// V2 has long requestedVolume in place of unknown
+	cargo := map[string]interface{}{
+		"status":                0,
+		"bolusId":               0,
+		"timestamp":             0,
+		"deliveredVolume":       0,
+		"bolusStatusId":         lastBolusStopped,
+		"bolusSourceId":         0,
+		"bolusTypeBitmask":      0,
+		"extendedBolusDuration": 0,
+		"requestedVolume":       0,
+	}
+	if bolus, ok := pumpState.GetLastBolus(); ok {
+		if bolus.UnitsDelivered >= bolus.UnitsTotal {
+			cargo["bolusStatusId"] = lastBolusCompleted
+		}
+		if bolus.Automatic {
+			cargo["bolusSourceId"] = bolusSourceControlIQAutoBolus
+		}
+		cargo["bolusId"] = bolus.BolusID
+		cargo["timestamp"] = pumpState.PumpTime(bolus.StartTime).Unix()
+		cargo["deliveredVolume"] = int(math.Round(bolus.UnitsDelivered * 1000))
+		cargo["requestedVolume"] = int(bolus.UnitsTotal * 1000)
+	}
+
+	responseType := "LastBolusStatusV2Response"
+	if h.messageType == "LastBolusStatusRequest" {
+		responseType = "LastBolusStatusResponse"
+		delete(cargo, "requestedVolume")
+		cargo["unknown"] = "0000"
+	}
+
+	log.Debugf("LastBolusStatus: bolusId=%v, delivered=%v", cargo["bolusId"], cargo["deliveredVolume"])
+
+	response, err := h.bridge.EncodeMessage(msg.TxID, responseType, cargo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", responseType, err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
+
 // CurrentBasalStatusHandler returns dynamic basal status from pump state
 type CurrentBasalStatusHandler struct {
 	bridge *pumpx2.Bridge
@@ -87,6 +176,8 @@ func (h *CurrentBasalStatusHandler) RequiresAuth() bool {
 
 // HandleMessage returns dynamic basal status from pump state
 func (h *CurrentBasalStatusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	// Control-IQ basal adjustments change the delivered rate without a temp rate
+	adjustedRate := pumpState.GetControlIQ().AdjustedBasalRate
 	pumpState.RLock()
 	basal := pumpState.Basal
 	currentRate := basal.CurrentRate
@@ -94,6 +185,8 @@ func (h *CurrentBasalStatusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pum
 	if basal.TempBasalActive {
 		currentRate = basal.TempBasalRate
 		basalModifiedBitmask |= 1
+	} else if adjustedRate > 0 {
+		currentRate = adjustedRate
 	}
 	suspended := pumpState.PumpingSuspended
 	if suspended {
@@ -124,6 +217,66 @@ func (h *CurrentBasalStatusHandler) HandleMessage(msg *pumpx2.ParsedMessage, pum
 	}, nil
 }
 
+// TempRateHandler returns the active temp rate from pump state
+type TempRateHandler struct {
+	bridge *pumpx2.Bridge
+	now    func() time.Time
+}
+
+// NewTempRateHandler creates a new temp rate handler
+func NewTempRateHandler(bridge *pumpx2.Bridge) *TempRateHandler {
+	return &TempRateHandler{
+		bridge: bridge,
+		now:    time.Now,
+	}
+}
+
+// MessageType returns the message type this handler processes
+func (h *TempRateHandler) MessageType() string {
+	return "TempRateRequest"
+}
+
+// RequiresAuth returns true
+func (h *TempRateHandler) RequiresAuth() bool {
+	return true
+}
+
+// HandleMessage returns the temp rate set with SetTempRateRequest, or an
+// inactive one once it has ended or been stopped
+func (h *TempRateHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	pumpState.RLock()
+	basal := *pumpState.Basal
+	pumpState.RUnlock()
+
+	// TempRateResponse(boolean active, int percentage, long startTimeRaw,
+	// long duration), with duration in minutes as SetTempRateRequest takes it
+	cargo := map[string]interface{}{
+		"active":       false,
+		"percentage":   0,
+		"startTimeRaw": 0,
+		"duration":     0,
+	}
+	active := basal.TempBasalActive && h.now().Before(basal.TempBasalEnd)
+	if active {
+		cargo["active"] = true
+		cargo["percentage"] = basal.TempBasalPercent
+		cargo["startTimeRaw"] = pumpState.PumpTime(basal.TempBasalStart).Unix()
+		cargo["duration"] = int(basal.TempBasalEnd.Sub(basal.TempBasalStart).Minutes())
+	}
+
+	log.Debugf("TempRate: active=%v, percentage=%v, duration=%v", active, cargo["percentage"], cargo["duration"])
+
+	response, err := h.bridge.EncodeMessage(msg.TxID, "TempRateResponse", cargo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode TempRateResponse: %w", err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
+
 // TempRateStatusHandler returns dynamic temp rate status from pump state
 type TempRateStatusHandler struct {
 	bridge *pumpx2.Bridge
@@ -278,6 +431,27 @@ func (h *CurrentBatteryHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpSta
 	}, nil
 }
 
+// NewControlIQInfoHandler creates a handler for ControlIQInfoV1Request or
+// ControlIQInfoV2Request, reporting whether simulated Control-IQ is making
+// treatment decisions and its current mode over the configured settings
+func NewControlIQInfoHandler(bridge *pumpx2.Bridge, settingsManager *settings.Manager, msgType string) *GenericSettingsHandler {
+	h := NewGenericSettingsHandler(bridge, settingsManager, msgType, true)
+	h.overlay = controlIQInfoFromState
+	return h
+}
+
+// controlIQInfoFromState overlays the Control-IQ state onto a configured
+// ControlIQInfo response
+func controlIQInfoFromState(values map[string]interface{}, pumpState *state.PumpState) map[string]interface{} {
+	cargo := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		cargo[k] = v
+	}
+	cargo["closedLoopEnabled"] = pumpState.GetControlIQ().Enabled
+	cargo["currentUserModeType"] = pumpState.GetControlIQMode()
+	return cargo
+}
+
 // ControlIQIOBHandler returns dynamic IOB from pump state
 type ControlIQIOBHandler struct {
 	bridge  *pumpx2.Bridge
@@ -299,8 +473,8 @@ func (h *ControlIQIOBHandler) RequiresAuth() bool { return true }
 
 // HandleMessage returns dynamic IOB
 func (h *ControlIQIOBHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	iob := int(pumpState.GetIOB() * 1000)
 	pumpState.RLock()
-	iob := int(pumpState.IOB * 1000)
 	timeOffset := pumpState.TimeSinceReset
 	pumpState.RUnlock()
 
@@ -334,3 +508,51 @@ func (h *ControlIQIOBHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState
 		Immediate:       true,
 	}, nil
 }
+
+// CurrentEGVHandler returns the dynamic CGM reading and trend from pump state
+type CurrentEGVHandler struct {
+	bridge  *pumpx2.Bridge
+	msgType string
+	resType string
+}
+
+// NewCurrentEGVHandler creates a CGM reading handler for
+// CurrentEGVGuiDataRequest or CurrentEgvGuiDataV2Request
+func NewCurrentEGVHandler(bridge *pumpx2.Bridge, msgType string) *CurrentEGVHandler {
+	resType := msgType[:len(msgType)-7] + "Response"
+	return &CurrentEGVHandler{bridge: bridge, msgType: msgType, resType: resType}
+}
+
+// MessageType returns the message type
+func (h *CurrentEGVHandler) MessageType() string { return h.msgType }
+
+// RequiresAuth returns true
+func (h *CurrentEGVHandler) RequiresAuth() bool { return true }
+
+// HandleMessage returns the current CGM reading
+func (h *CurrentEGVHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	// CurrentEGVGuiDataResponse(long bgReadingTimestampSeconds, int cgmReading,
+	// int egvStatusId, int trendRate)
+	pumpState.RLock()
+	readingTime := pumpState.CGM.LastReading
+	if readingTime.IsZero() {
+		readingTime = pumpState.CurrentTime
+	}
+	cargo := map[string]interface{}{
+		"bgReadingTimestampSeconds": readingTime.Unix(),
+		"cgmReading":                pumpState.CGM.CurrentEGV,
+		"egvStatusId":               0,
+		"trendRate":                 int(math.Round(pumpState.CGM.TrendRate)),
+	}
+	pumpState.RUnlock()
+
+	response, err := h.bridge.EncodeMessage(msg.TxID, h.resType, cargo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", h.resType, err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// TestCurrentBolusStatusReportsControlIQAutoBolus verifies an automatic
+// correction bolus is reported with the Control-IQ bolus source
+func TestCurrentBolusStatusReportsControlIQAutoBolus(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte{0x01})
+	r.pumpState.SetCurrentEGV(260)
+	state.NewSimulator(r.pumpState, time.Second).Tick()
+
+	msg := &pumpx2.ParsedMessage{MessageType: "CurrentBolusStatusRequest", TxID: 1}
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+
+	params := runner.params[len(runner.params)-1]
+	if params["bolusSourceId"] != bolusSourceControlIQAutoBolus {
+		t.Errorf("Expected bolusSourceId %d, got %v", bolusSourceControlIQAutoBolus, params["bolusSourceId"])
+	}
+	if params["statusId"] != 1 {
+		t.Errorf("Expected active bolus statusId 1, got %v", params["statusId"])
+	}
+}
+
+// TestCurrentBolusStatusFollowsStackedBoluses verifies the current bolus
+// status reports the delivering bolus, and the stacked one once it takes over
+func TestCurrentBolusStatusFollowsStackedBoluses(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte{0x01})
+	for _, bolus := range []struct {
+		units float64
+		id    int
+	}{{2.0, 42}, {1.0, 43}} {
+		params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+			MessageType: "InitiateBolusRequest",
+			Cargo:       map[string]interface{}{"insulin": bolus.units, "bolusId": bolus.id},
+		})
+		if params["status"] != 0 {
+			t.Fatalf("Expected bolus %v to start, got status %v", bolus.id, params["status"])
+		}
+	}
+	r.pumpState.UpdateBolusDelivery(0.5)
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "CurrentBolusStatusRequest", TxID: 1})
+	if params["statusId"] != 1 || params["bolusId"] != uint32(42) || params["requestedVolume"] != 2000 {
+		t.Errorf("Expected bolus 42 delivering 2000, got %v", params)
+	}
+
+	r.pumpState.UpdateBolusDelivery(2.0)
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "CurrentBolusStatusRequest", TxID: 2})
+	if params["bolusId"] != uint32(43) || params["requestedVolume"] != 1000 {
+		t.Errorf("Expected bolus 43 delivering after 42 completed, got %v", params)
+	}
+
+	r.pumpState.StopBolus()
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "CurrentBolusStatusRequest", TxID: 3})
+	if params["statusId"] != 0 || params["bolusId"] != 0 {
+		t.Errorf("Expected no bolus delivering, got %v", params)
+	}
+}
+
+// TestLastBolusStatusReportsDeliveredVolume verifies the last bolus status
+// reports how much of a completed or stopped bolus was delivered
+func TestLastBolusStatusReportsDeliveredVolume(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte{0x01})
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "LastBolusStatusV2Request", TxID: 1})
+	if params["bolusId"] != 0 || params["deliveredVolume"] != 0 {
+		t.Errorf("Expected no last bolus, got %v", params)
+	}
+
+	r.pumpState.StartBolus(2.0, 42)
+	r.pumpState.UpdateBolusDelivery(2.0)
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "LastBolusStatusV2Request", TxID: 2})
+	if params["bolusId"] != uint32(42) || params["bolusStatusId"] != lastBolusCompleted {
+		t.Errorf("Expected bolus 42 completed, got %v", params)
+	}
+	if params["deliveredVolume"] != 2000 || params["requestedVolume"] != 2000 {
+		t.Errorf("Expected 2000 of 2000 milliunits delivered, got %v", params)
+	}
+
+	r.pumpState.StartBolus(1.0, 43)
+	r.pumpState.UpdateBolusDelivery(0.4)
+	r.pumpState.StopBolus()
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "LastBolusStatusRequest", TxID: 3})
+	if runner.encoded[len(runner.encoded)-1] != "LastBolusStatusResponse" {
+		t.Errorf("Expected a LastBolusStatusResponse, got %s", runner.encoded[len(runner.encoded)-1])
+	}
+	if params["bolusId"] != uint32(43) || params["bolusStatusId"] != lastBolusStopped || params["deliveredVolume"] != 400 {
+		t.Errorf("Expected bolus 43 stopped after 400 milliunits, got %v", params)
+	}
+	if _, ok := params["requestedVolume"]; ok {
+		t.Errorf("Expected no requestedVolume in a LastBolusStatusResponse, got %v", params)
+	}
+}
+
+// TestTempRateReflectsSetTempRate verifies a temp rate set with
+// SetTempRateRequest is reported by TempRateRequest and changes the current
+// basal rate
+func TestTempRateReflectsSetTempRate(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte{0x01})
+	profileRate := r.pumpState.GetBasalRate()
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "TempRateRequest", TxID: 1})
+	if params["active"] != false {
+		t.Errorf("Expected no temp rate before one is set, got %v", params)
+	}
+
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "SetTempRateRequest",
+		TxID:        2,
+		Cargo:       map[string]interface{}{"percent": 150, "minutes": 30},
+	})
+
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "TempRateRequest", TxID: 3})
+	if params["active"] != true || params["percentage"] != 150 || params["duration"] != 30 {
+		t.Errorf("Expected active 150%% temp rate for 30 minutes, got %v", params)
+	}
+
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "CurrentBasalStatusRequest", TxID: 4})
+	if params["currentBasalRate"] != int(profileRate*1.5*1000) || params["basalModifiedBitmask"] != 1 {
+		t.Errorf("Expected current rate %d with temp rate bit set, got %v", int(profileRate*1.5*1000), params)
+	}
+}
+
+// TestCurrentEGVReportsSimulatedReading verifies both CGM reading requests
+// report the simulator's current reading and trend
+func TestCurrentEGVReportsSimulatedReading(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte{0x01})
+	r.pumpState.SetControlIQEnabled(false)
+	sim := state.NewSimulator(r.pumpState, time.Second)
+	sim.SetCGMModel(&state.CGMModel{Baseline: 180})
+	sim.Tick()
+
+	for i, msgType := range []string{"CurrentEGVGuiDataRequest", "CurrentEgvGuiDataV2Request"} {
+		params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: msgType, TxID: i + 1})
+		if params["cgmReading"] != 180 || params["trendRate"] != 0 {
+			t.Errorf("%s: expected a steady reading of 180, got %v", msgType, params)
+		}
+	}
+}
+
+// TestControlIQInfoReportsState verifies ControlIQInfo responses report
+// whether simulated Control-IQ is enabled and its current mode
+func TestControlIQInfoReportsState(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte{0x01})
+	r.pumpState.SetControlIQEnabled(false)
+	r.pumpState.SetControlIQMode(1)
+
+	for _, msgType := range []string{"ControlIQInfoV1Request", "ControlIQInfoV2Request"} {
+		msg := &pumpx2.ParsedMessage{MessageType: msgType, TxID: 1}
+		if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+			t.Fatalf("RouteMessage(%s) failed: %v", msgType, err)
+		}
+		params := runner.params[len(runner.params)-1]
+		if params["closedLoopEnabled"] != false || params["currentUserModeType"] != 1 {
+			t.Errorf("%s: expected Control-IQ disabled in mode 1, got %v", msgType, params)
+		}
+		if params["totalDailyInsulin"] != 40 {
+			t.Errorf("%s: expected configured fields kept, got %v", msgType, params)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EchoHandler sends a request back to the client unchanged, as a cheap
+// liveness check. pumpX2 has no ping message, so this is used for any
+// message type without a handler whose settings are in echo mode.
+type EchoHandler struct {
+	messageType string
+}
+
+// NewEchoHandler creates a handler echoing messageType requests
+func NewEchoHandler(messageType string) *EchoHandler {
+	return &EchoHandler{messageType: messageType}
+}
+
+// MessageType returns the message type this handler processes
+func (h *EchoHandler) MessageType() string {
+	return h.messageType
+}
+
+// RequiresAuth returns false so liveness can be checked before pairing
+func (h *EchoHandler) RequiresAuth() bool {
+	return false
+}
+
+// HandleMessage echoes the request's raw packets back with the same txID
+func (h *EchoHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	log.Infof("Echoing %s: txID=%d", h.messageType, msg.TxID)
+
+	if len(msg.RawPacketsHex) == 0 {
+		return nil, fmt.Errorf("no raw packets to echo for %s", h.messageType)
+	}
+	packets := make([]string, len(msg.RawPacketsHex))
+	copy(packets, msg.RawPacketsHex)
+
+	return &Response{
+		ResponseMessage: &pumpx2.EncodedMessage{
+			MessageType: msg.MessageType,
+			TxID:        msg.TxID,
+			Opcode:      msg.Opcode,
+			Packets:     packets,
+		},
+		Immediate: true,
+	}, nil
+}
@@ -25,6 +25,10 @@ type GenericSettingsHandler struct {
 	settingsManager *settings.Manager
 	messageType     string
 	requiresAuth    bool
+
+	// overlay, if set, replaces configured response fields with live pump
+	// state
+	overlay func(values map[string]interface{}, pumpState *state.PumpState) map[string]interface{}
 }
 
 // NewGenericSettingsHandler creates a new generic settings handler
@@ -51,6 +55,22 @@ func (h *GenericSettingsHandler) RequiresAuth() bool {
 func (h *GenericSettingsHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling %s: txID=%d", h.messageType, msg.TxID)
 
+	if h.settingsManager.Echoes(h.messageType) {
+		return NewEchoHandler(h.messageType).HandleMessage(msg, pumpState)
+	}
+	if packets, ok := h.settingsManager.EncodedPackets(h.messageType); ok {
+		log.Debugf("Sending pre-encoded %s response: %d packets", h.messageType, len(packets))
+		return &Response{
+			ResponseMessage: &pumpx2.EncodedMessage{
+				MessageType: msg.MessageType,
+				TxID:        msg.TxID,
+				Opcode:      msg.Opcode,
+				Packets:     packets,
+			},
+			Immediate: true,
+		}, nil
+	}
+
 	// Get response from settings manager
 	responseData, err := h.settingsManager.GetResponse(h.messageType)
 	if err != nil {
@@ -59,6 +79,13 @@ func (h *GenericSettingsHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpSt
 
 	log.Debugf("Settings response for %s: %v", h.messageType, responseData)
 
+	if errorCode, ok := settings.ErrorCode(responseData); ok {
+		return h.errorResponse(msg, errorCode)
+	}
+	if h.overlay != nil {
+		responseData = h.overlay(responseData, pumpState)
+	}
+
 	// Determine response type (replace "Request" with "Response"), unless a
 	// real pumpX2 response class name override applies.
 	responseType, ok := genericSettingsResponseTypeOverrides[h.messageType]
@@ -85,3 +112,23 @@ func (h *GenericSettingsHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpSt
 		Immediate:       true,
 	}, nil
 }
+
+// errorResponse answers msg with an ErrorResponse carrying a configured
+// errorCode
+func (h *GenericSettingsHandler) errorResponse(msg *pumpx2.ParsedMessage, errorCode int) (*Response, error) {
+	log.Infof("Sending configured ErrorResponse for %s: errorCode=%d", h.messageType, errorCode)
+
+	// ErrorResponse(int requestCodeId, ErrorCode errorCode)
+	response, err := h.bridge.EncodeMessage(msg.TxID, "ErrorResponse", map[string]interface{}{
+		"requestCodeId": msg.Opcode,
+		"errorCode":     errorCode,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s ErrorResponse: %w", h.messageType, err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
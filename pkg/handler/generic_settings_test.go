@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/settings"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// TestGenericSettingsProbabilisticErrors verifies a config erroring half the
+// time answers with both ErrorResponses and normal responses
+func TestGenericSettingsProbabilisticErrors(t *testing.T) {
+	manager := settings.NewManager()
+	if err := manager.SetConfig("CurrentBasalStatusRequest", &settings.ResponseConfig{
+		Mode: settings.ModeProbabilistic,
+		Value: map[string]interface{}{
+			"profileBasalRate":     85,
+			"currentBasalRate":     85,
+			"basalModifiedBitmask": 0,
+		},
+		ErrorCode:        3,
+		ErrorProbability: 0.5,
+	}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	h := NewGenericSettingsHandler(pumpx2.NewBridgeWithRunner(mockrunner.New()), manager, "CurrentBasalStatusRequest", false)
+
+	seen := make(map[string]int)
+	for txID := 0; txID < 50; txID++ {
+		response, err := h.HandleMessage(&pumpx2.ParsedMessage{MessageType: "CurrentBasalStatusRequest", Opcode: 40, TxID: txID}, state.NewPumpState())
+		if err != nil {
+			t.Fatalf("HandleMessage failed: %v", err)
+		}
+		seen[response.ResponseMessage.MessageType]++
+	}
+
+	if seen["ErrorResponse"] == 0 || seen["CurrentBasalStatusResponse"] == 0 {
+		t.Errorf("Expected both error and normal responses, got %v", seen)
+	}
+}
+
+// TestGenericSettingsErrorMode verifies an error config always answers with
+// an ErrorResponse, and that out of range errors are refused
+func TestGenericSettingsErrorMode(t *testing.T) {
+	manager := settings.NewManager()
+	if err := manager.SetConfig("CurrentBasalStatusRequest", &settings.ResponseConfig{Mode: settings.ModeError, ErrorCode: 256}); err == nil {
+		t.Error("Expected an errorCode over 255 to be refused")
+	}
+	if err := manager.SetConfig("CurrentBasalStatusRequest", &settings.ResponseConfig{Mode: settings.ModeError, ErrorCode: 7}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	h := NewGenericSettingsHandler(pumpx2.NewBridgeWithRunner(mockrunner.New()), manager, "CurrentBasalStatusRequest", false)
+
+	response, err := h.HandleMessage(&pumpx2.ParsedMessage{MessageType: "CurrentBasalStatusRequest", Opcode: 40, TxID: 1}, state.NewPumpState())
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+	if response.ResponseMessage.MessageType != "ErrorResponse" {
+		t.Errorf("Expected an ErrorResponse, got %s", response.ResponseMessage.MessageType)
+	}
+}
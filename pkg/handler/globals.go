@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// globalsResponseBuilder builds a globals response's params from the pump's
+// global preferences and therapy limits
+type globalsResponseBuilder func(pump state.PumpConfig, therapy state.TherapyConfig) map[string]interface{}
+
+// globalsResponses maps each globals request to its response params. Limits
+// keep the same scaling as the settings defaults these replaced (U * 100).
+// No pumpX2 message carries the max IOB limit itself; it's served through
+// BolusCalcDataSnapshotResponse's maxIobEventsExceeded.
+var globalsResponses = map[string]globalsResponseBuilder{
+	// GlobalMaxBolusSettingsResponse(int maxBolus, int maxBolusDefault)
+	"GlobalMaxBolusSettingsRequest": func(pump state.PumpConfig, therapy state.TherapyConfig) map[string]interface{} {
+		return map[string]interface{}{
+			"maxBolus":        int(therapy.MaxBolus * 100),
+			"maxBolusDefault": int(regionTherapyDefaults(pump.Region).MaxBolus * 100),
+		}
+	},
+	// BasalLimitSettingsResponse(long basalLimit, long basalLimitDefault)
+	"BasalLimitSettingsRequest": func(pump state.PumpConfig, therapy state.TherapyConfig) map[string]interface{} {
+		return map[string]interface{}{
+			"basalLimit":        int(therapy.MaxBasalRate * 100),
+			"basalLimitDefault": int(regionTherapyDefaults(pump.Region).MaxBasalRate * 100),
+		}
+	},
+	// LocalizationResponse(int glucoseUOM, int languageSelected, int regionSetting,
+	// long languagesAvailableBitmask)
+	"LocalizationRequest": func(pump state.PumpConfig, _ state.TherapyConfig) map[string]interface{} {
+		return map[string]interface{}{
+			"glucoseUOM":                pump.GlucoseUnit,
+			"languageSelected":          0, // English
+			"regionSetting":             regionSetting(pump.Region),
+			"languagesAvailableBitmask": 1,
+		}
+	},
+	// PumpFeaturesV1Response's real constructor takes a BigInteger, but it has
+	// an ambiguous 1-arg raw byte[] constructor that cliparser's constructor
+	// lookup resolves to first -- pass the little-endian bitmask as raw cargo
+	// bytes instead (size=8).
+	"PumpFeaturesV1Request": func(pump state.PumpConfig, _ state.TherapyConfig) map[string]interface{} {
+		raw := make([]byte, 8)
+		binary.LittleEndian.PutUint64(raw, pump.Features)
+		return map[string]interface{}{
+			"raw": hex.EncodeToString(raw),
+		}
+	},
+	// PumpSettingsResponse(int lowInsulinThreshold, int cannulaPrimeSize,
+	// int autoShutdownEnabled, int autoShutdownDuration, int featureLock,
+	// int oledTimeout, int status)
+	"PumpSettingsRequest": func(pump state.PumpConfig, _ state.TherapyConfig) map[string]interface{} {
+		return map[string]interface{}{
+			"lowInsulinThreshold":  pump.LowInsulinThreshold,
+			"cannulaPrimeSize":     0,
+			"autoShutdownEnabled":  boolToInt(pump.AutoShutdownEnabled),
+			"autoShutdownDuration": pump.AutoShutdownHours,
+			"featureLock":          boolToInt(pump.SettingsLocked),
+			"oledTimeout":          0,
+			"status":               0,
+		}
+	},
+}
+
+// regionTherapyDefaults returns the therapy limits a pump in region starts
+// with, which globals responses report as the limits' defaults
+func regionTherapyDefaults(region state.Region) state.TherapyConfig {
+	defaults, _ := state.DefaultsForRegion(region)
+	return defaults.Therapy
+}
+
+// regionSetting returns LocalizationResponse's regionSetting for region
+func regionSetting(region state.Region) int {
+	defaults, _ := state.DefaultsForRegion(region)
+	return defaults.RegionSetting
+}
+
+// boolToInt converts a flag to the 0/1 int the pumpX2 constructors take
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GlobalsHandler serves global limits and preferences from pump state
+type GlobalsHandler struct {
+	bridge      *pumpx2.Bridge
+	messageType string
+}
+
+// NewGlobalsHandler creates a globals handler for one of the globalsResponses
+// request types
+func NewGlobalsHandler(bridge *pumpx2.Bridge, messageType string) *GlobalsHandler {
+	return &GlobalsHandler{
+		bridge:      bridge,
+		messageType: messageType,
+	}
+}
+
+// MessageType returns the message type this handler processes
+func (h *GlobalsHandler) MessageType() string {
+	return h.messageType
+}
+
+// RequiresAuth returns true
+func (h *GlobalsHandler) RequiresAuth() bool {
+	return true
+}
+
+// HandleMessage returns the current global values from pump state
+func (h *GlobalsHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	log.Infof("Handling %s: txID=%d", h.messageType, msg.TxID)
+
+	build, ok := globalsResponses[h.messageType]
+	if !ok {
+		return nil, fmt.Errorf("no globals response for %s", h.messageType)
+	}
+	params := build(pumpState.GetPumpConfig(), pumpState.GetTherapyConfig())
+	responseType := h.messageType[:len(h.messageType)-7] + "Response"
+
+	response, err := h.bridge.EncodeMessage(msg.TxID, responseType, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", responseType, err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
+
+// globalsLimitWrites maps each therapy limit write request to the cargo
+// field holding the new limit in milliunits and how to apply it
+var globalsLimitWrites = map[string]struct {
+	field string
+	apply func(cfg *state.TherapyConfig, units float64)
+}{
+	"SetMaxBolusLimitRequest": {"maxBolusMilliunits", func(cfg *state.TherapyConfig, units float64) {
+		cfg.MaxBolus = units
+	}},
+	"SetMaxBasalLimitRequest": {"maxHourlyBasalMilliunits", func(cfg *state.TherapyConfig, units float64) {
+		cfg.MaxBasalRate = units
+	}},
+}
+
+// GlobalsWriteHandler updates therapy limits in pump state so subsequent
+// globals reads reflect the write
+type GlobalsWriteHandler struct {
+	bridge      *pumpx2.Bridge
+	messageType string
+}
+
+// NewGlobalsWriteHandler creates a write handler for one of the
+// globalsLimitWrites request types
+func NewGlobalsWriteHandler(bridge *pumpx2.Bridge, messageType string) *GlobalsWriteHandler {
+	return &GlobalsWriteHandler{
+		bridge:      bridge,
+		messageType: messageType,
+	}
+}
+
+// MessageType returns the message type this handler processes
+func (h *GlobalsWriteHandler) MessageType() string {
+	return h.messageType
+}
+
+// RequiresAuth returns true
+func (h *GlobalsWriteHandler) RequiresAuth() bool {
+	return true
+}
+
+// HandleMessage applies the new limit and returns success
+func (h *GlobalsWriteHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	log.Infof("Handling %s: txID=%d cargo=%v", h.messageType, msg.TxID, msg.Cargo)
+
+	write, ok := globalsLimitWrites[h.messageType]
+	if !ok {
+		return nil, fmt.Errorf("no globals limit write for %s", h.messageType)
+	}
+	// A write without the limit leaves it unchanged
+	if milliunits, ok := cargoNumber(msg.Cargo, write.field); ok {
+		cfg := pumpState.GetTherapyConfig()
+		write.apply(&cfg, milliunits/1000)
+		if err := pumpState.SetTherapyConfig(cfg); err != nil {
+			return nil, fmt.Errorf("failed to apply %s: %w", h.messageType, err)
+		}
+	} else {
+		log.Warnf("%s missing %s; leaving the limit unchanged", h.messageType, write.field)
+	}
+
+	responseType := h.messageType[:len(h.messageType)-7] + "Response"
+	response, err := h.bridge.EncodeMessage(msg.TxID, responseType, map[string]interface{}{"status": 0})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", responseType, err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// routeGlobals routes an authenticated request and returns the params of the
+// response the handler encoded
+func routeGlobals(t *testing.T, r *Router, runner *fakeRunner, msg *pumpx2.ParsedMessage) map[string]interface{} {
+	t.Helper()
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+		t.Fatalf("RouteMessage(%s) failed: %v", msg.MessageType, err)
+	}
+	if len(runner.params) == 0 {
+		t.Fatalf("No response encoded for %s", msg.MessageType)
+	}
+	return runner.params[len(runner.params)-1]
+}
+
+// TestGlobalsHandlersReadConfiguredValues verifies each global is served from
+// the configured PumpConfig and TherapyConfig
+func TestGlobalsHandlersReadConfiguredValues(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	if err := r.pumpState.SetPumpConfig(state.PumpConfig{
+		GlucoseUnit:         state.GlucoseUnitMmol,
+		SettingsLocked:      true,
+		LowInsulinThreshold: 35,
+		AutoShutdownEnabled: true,
+		AutoShutdownHours:   16,
+		Region:              state.RegionUS,
+	}); err != nil {
+		t.Fatalf("SetPumpConfig failed: %v", err)
+	}
+	if err := r.pumpState.SetTherapyConfig(state.TherapyConfig{MaxBolus: 12.5, MaxBasalRate: 3.0, MaxIOB: 8.0}); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+
+	tests := []struct {
+		request string
+		field   string
+		want    int
+	}{
+		{"GlobalMaxBolusSettingsRequest", "maxBolus", 1250},
+		{"BasalLimitSettingsRequest", "basalLimit", 300},
+		{"LocalizationRequest", "glucoseUOM", state.GlucoseUnitMmol},
+		{"PumpSettingsRequest", "featureLock", 1},
+		{"PumpSettingsRequest", "lowInsulinThreshold", 35},
+		{"PumpSettingsRequest", "autoShutdownEnabled", 1},
+		{"PumpSettingsRequest", "autoShutdownDuration", 16},
+	}
+	for _, tt := range tests {
+		params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: tt.request})
+		if params[tt.field] != tt.want {
+			t.Errorf("%s %s: expected %d, got %v", tt.request, tt.field, tt.want, params[tt.field])
+		}
+	}
+}
+
+// TestGlobalsWriteHandlerUpdatesTherapyLimits verifies limit writes are read
+// back by the globals handlers and enforced by SetBasalRate
+func TestGlobalsWriteHandlerUpdatesTherapyLimits(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "SetMaxBasalLimitRequest",
+		Cargo:       map[string]interface{}{"maxHourlyBasalMilliunits": 2000},
+	})
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "SetMaxBolusLimitRequest",
+		Cargo:       map[string]interface{}{"maxBolusMilliunits": 10000},
+	})
+
+	therapy := r.pumpState.GetTherapyConfig()
+	if therapy.MaxBasalRate != 2.0 || therapy.MaxBolus != 10.0 {
+		t.Errorf("Expected max basal 2.0 and max bolus 10.0, got %+v", therapy)
+	}
+	if params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "BasalLimitSettingsRequest"}); params["basalLimit"] != 200 {
+		t.Errorf("Expected basalLimit 200, got %v", params["basalLimit"])
+	}
+	if err := r.SetBasalRate(2.5); err == nil {
+		t.Error("Expected SetBasalRate above the new limit to fail")
+	}
+}
+
+// TestGlobalsWriteHandlerMissingLimit verifies a limit write without the
+// limit succeeds and leaves the limit unchanged
+func TestGlobalsWriteHandlerMissingLimit(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	before := r.pumpState.GetTherapyConfig()
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "SetMaxBolusLimitRequest", Cargo: map[string]interface{}{}})
+	if params["status"] != 0 {
+		t.Errorf("Expected success status, got %v", params["status"])
+	}
+	if after := r.pumpState.GetTherapyConfig(); after != before {
+		t.Errorf("Expected therapy limits unchanged, got %+v (was %+v)", after, before)
+	}
+}
+
+// TestBolusCalcSnapshotReportsMaxIOB verifies the snapshot flags IOB over
+// the configured max
+func TestBolusCalcSnapshotReportsMaxIOB(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	// IOB starts decaying as soon as the dose is recorded, so deliver more
+	// than the max rather than exactly it
+	r.pumpState.RecordDelivery(time.Now(), 9.0)
+	if err := r.pumpState.SetTherapyConfig(state.TherapyConfig{MaxBolus: 25, MaxBasalRate: 5, MaxIOB: 8.0}); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "BolusCalcDataSnapshotRequest"})
+	if params["maxIobEventsExceeded"] != true {
+		t.Errorf("Expected maxIobEventsExceeded, got %v", params["maxIobEventsExceeded"])
+	}
+
+	if err := r.pumpState.SetTherapyConfig(state.TherapyConfig{MaxBolus: 25, MaxBasalRate: 5, MaxIOB: 12.0}); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+	params = routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "BolusCalcDataSnapshotRequest"})
+	if params["maxIobEventsExceeded"] != false {
+		t.Errorf("Expected maxIobEventsExceeded cleared under a raised max IOB, got %v", params["maxIobEventsExceeded"])
+	}
+	if params["maxBolusAmount"] != 25000 {
+		t.Errorf("Expected maxBolusAmount 25000, got %v", params["maxBolusAmount"])
+	}
+}
+
+// TestGlucoseUnitAdjustsRemoteBGRange verifies switching to mmol/L is
+// reported by LocalizationRequest and changes the accepted remote BG range,
+// for a BG parsed as an int or as a float
+func TestGlucoseUnitAdjustsRemoteBGRange(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	remoteBG := func(bg interface{}) interface{} {
+		return routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+			MessageType: "RemoteBgEntryRequest",
+			Cargo:       map[string]interface{}{"bg": bg},
+		})["status"]
+	}
+
+	if status := remoteBG(120); status != 0 {
+		t.Errorf("Expected 120 mg/dL accepted, got status %v", status)
+	}
+	if status := remoteBG(5.5); status != 1 {
+		t.Errorf("Expected 5.5 rejected in mg/dL, got status %v", status)
+	}
+
+	if err := r.pumpState.SetGlucoseUnit(state.GlucoseUnitMmol); err != nil {
+		t.Fatalf("SetGlucoseUnit failed: %v", err)
+	}
+	if params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "LocalizationRequest"}); params["glucoseUOM"] != state.GlucoseUnitMmol {
+		t.Errorf("Expected glucoseUOM mmol/L, got %v", params["glucoseUOM"])
+	}
+
+	if status := remoteBG(5.5); status != 0 {
+		t.Errorf("Expected 5.5 mmol/L accepted, got status %v", status)
+	}
+	if status := remoteBG(120); status != 1 {
+		t.Errorf("Expected 120 rejected in mmol/L, got status %v", status)
+	}
+}
+
+// TestGlobalsReflectRegion verifies the EU region's defaults and features are
+// reported in place of the US ones
+func TestGlobalsReflectRegion(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	us := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "PumpFeaturesV1Request"})
+	if err := r.pumpState.SetRegion(state.RegionEU); err != nil {
+		t.Fatalf("SetRegion failed: %v", err)
+	}
+
+	tests := []struct {
+		request string
+		field   string
+		want    interface{}
+	}{
+		{"GlobalMaxBolusSettingsRequest", "maxBolus", 2000},
+		{"GlobalMaxBolusSettingsRequest", "maxBolusDefault", 2000},
+		{"LocalizationRequest", "glucoseUOM", state.GlucoseUnitMmol},
+		{"LocalizationRequest", "regionSetting", 1},
+		{"PumpFeaturesV1Request", "raw", "0204000000000000"},
+	}
+	for _, tt := range tests {
+		params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: tt.request})
+		if params[tt.field] != tt.want {
+			t.Errorf("%s %s: expected %v, got %v", tt.request, tt.field, tt.want, params[tt.field])
+		}
+	}
+	if us["raw"] != "0504000000000000" {
+		t.Errorf("Expected US features G5, Basal-IQ and Control-IQ, got %v", us["raw"])
+	}
+}
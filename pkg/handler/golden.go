@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+)
+
+// GoldenResponse is the serialized form of a handler response kept in a
+// golden file
+type GoldenResponse struct {
+	MessageType    string                 `json:"messageType"`
+	Characteristic string                 `json:"characteristic"`
+	Params         map[string]interface{} `json:"params"`
+	Packets        []string               `json:"packets"`
+}
+
+// GoldenRecorder wraps a pumpx2.Runner to capture the params each handler
+// encodes, and compares handler responses against golden files in dir so
+// accidental changes to handler output are flagged. With update set, golden
+// files are rewritten instead of compared.
+type GoldenRecorder struct {
+	runner pumpx2.Runner
+	dir    string
+	update bool
+
+	mutex  sync.Mutex
+	params map[string]map[string]interface{} // last encoded params by message name
+}
+
+var _ pumpx2.Runner = (*GoldenRecorder)(nil)
+
+// NewGoldenRecorder creates a recorder encoding with runner and keeping
+// golden files in dir
+func NewGoldenRecorder(runner pumpx2.Runner, dir string, update bool) *GoldenRecorder {
+	return &GoldenRecorder{
+		runner: runner,
+		dir:    dir,
+		update: update,
+		params: make(map[string]map[string]interface{}),
+	}
+}
+
+// Parse delegates to the wrapped runner
+func (g *GoldenRecorder) Parse(btChar string, rawPacketsHex []string) (string, error) {
+	return g.runner.Parse(btChar, rawPacketsHex)
+}
+
+// Encode records params and delegates to the wrapped runner
+func (g *GoldenRecorder) Encode(txID int, messageName string, params map[string]interface{}) (string, error) {
+	g.mutex.Lock()
+	g.params[messageName] = params
+	g.mutex.Unlock()
+	return g.runner.Encode(txID, messageName, params)
+}
+
+// Check serializes response and compares it against the golden file for
+// name, returning an error describing any drift
+func (g *GoldenRecorder) Check(name string, response *Response) error {
+	if response == nil || response.ResponseMessage == nil {
+		return fmt.Errorf("golden %s: no response message", name)
+	}
+	msg := response.ResponseMessage
+
+	g.mutex.Lock()
+	params := g.params[msg.MessageType]
+	g.mutex.Unlock()
+
+	// json sorts map keys, so equal responses serialize identically
+	actual, err := json.MarshalIndent(GoldenResponse{
+		MessageType:    msg.MessageType,
+		Characteristic: msg.Characteristic,
+		Params:         params,
+		Packets:        msg.Packets,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("golden %s: failed to serialize response: %w", name, err)
+	}
+	actual = append(actual, '\n')
+
+	path := filepath.Join(g.dir, name+".json")
+	if g.update {
+		if err := os.MkdirAll(g.dir, 0755); err != nil {
+			return fmt.Errorf("golden %s: %w", name, err)
+		}
+		if err := os.WriteFile(path, actual, 0644); err != nil {
+			return fmt.Errorf("golden %s: failed to write: %w", name, err)
+		}
+		return nil
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("golden %s: failed to read %s (run with -update-golden to create it): %w", name, path, err)
+	}
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("golden %s: response drifted from %s\nexpected:\n%s\nactual:\n%s", name, path, expected, actual)
+	}
+	return nil
+}
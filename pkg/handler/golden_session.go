@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Golden session event kinds
+const (
+	GoldenEventAdvertising = "advertising"
+	GoldenEventPacket      = "packet"
+	GoldenEventMessage     = "message"
+	GoldenEventState       = "state"
+)
+
+// GoldenSessionEvent is one entry of a golden session archive: the
+// advertising data, a raw RX or TX packet, a parsed RX message, or a pump
+// state change, in the order they happened
+type GoldenSessionEvent struct {
+	Seq            int                    `json:"seq"`
+	Kind           string                 `json:"kind"`
+	Direction      string                 `json:"direction,omitempty"`
+	Characteristic string                 `json:"characteristic,omitempty"`
+	Packets        []string               `json:"packets,omitempty"`
+	Message        *pumpx2.ParsedMessage  `json:"message,omitempty"`
+	State          map[string]interface{} `json:"state,omitempty"`
+}
+
+// GoldenSessionRecorder writes a complete session to a JSON lines archive, one
+// GoldenSessionEvent per line, so a real client interaction can later be
+// replayed against the emulator with ReplayGoldenSession. Events are written
+// as they happen, so the archive is usable even if the emulator is killed.
+type GoldenSessionRecorder struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	seq     int
+}
+
+// NewGoldenSessionRecorder creates a recorder writing the archive to w
+func NewGoldenSessionRecorder(w io.Writer) *GoldenSessionRecorder {
+	return &GoldenSessionRecorder{encoder: json.NewEncoder(w)}
+}
+
+// record assigns event the next sequence number and writes it
+func (g *GoldenSessionRecorder) record(event GoldenSessionEvent) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.seq++
+	event.Seq = g.seq
+	if err := g.encoder.Encode(event); err != nil {
+		log.Errorf("Failed to write golden session event %d: %v", event.Seq, err)
+	}
+}
+
+// RecordAdvertising records the advertising and scan response data; pass it
+// to Ble.SetAdvertisingHandler
+func (g *GoldenSessionRecorder) RecordAdvertising(advData, scanData []byte) {
+	g.record(GoldenSessionEvent{
+		Kind:    GoldenEventAdvertising,
+		Packets: []string{hex.EncodeToString(advData), hex.EncodeToString(scanData)},
+	})
+}
+
+// RecordPacket records a raw packet received from the central
+func (g *GoldenSessionRecorder) RecordPacket(charType bluetooth.CharacteristicType, data []byte) {
+	g.ObservePacket(protocol.PacketEvent{Direction: "RX", CharType: charType, Data: data})
+}
+
+// ObservePacket records a raw packet; pass it to Router.AddPacketObserver
+func (g *GoldenSessionRecorder) ObservePacket(event protocol.PacketEvent) {
+	g.record(GoldenSessionEvent{
+		Kind:           GoldenEventPacket,
+		Direction:      event.Direction,
+		Characteristic: event.CharType.String(),
+		Packets:        []string{hex.EncodeToString(event.Data)},
+	})
+}
+
+// RecordMessage records a parsed message received from the central
+func (g *GoldenSessionRecorder) RecordMessage(charType bluetooth.CharacteristicType, msg *pumpx2.ParsedMessage) {
+	g.record(GoldenSessionEvent{
+		Kind:           GoldenEventMessage,
+		Direction:      "RX",
+		Characteristic: charType.String(),
+		Message:        msg,
+	})
+}
+
+// RecordState records changed pump state fields; subscribe it to the event
+// bus as a state.FieldNotifier
+func (g *GoldenSessionRecorder) RecordState(fields map[string]interface{}) {
+	g.record(GoldenSessionEvent{Kind: GoldenEventState, State: fields})
+}
+
+// LoadGoldenSession reads the events of a golden session archive
+func LoadGoldenSession(path string) ([]GoldenSessionEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open golden session: %w", err)
+	}
+	defer f.Close()
+
+	var events []GoldenSessionEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event GoldenSessionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read golden session: %w", err)
+	}
+	return events, nil
+}
+
+// characteristicByName returns the pump characteristic whose String() is name
+func characteristicByName(name string) (bluetooth.CharacteristicType, bool) {
+	for charType := bluetooth.CharCurrentStatus; charType <= bluetooth.CharControlStream; charType++ {
+		if charType.String() == name {
+			return charType, true
+		}
+	}
+	return 0, false
+}
+
+// ReplayGoldenSession routes each recorded RX message through r in order and
+// returns an error describing the first TX packet that differs from the
+// recording, so a change in emulator behavior shows up as a diff against a
+// real session. r should be freshly created with the same pump state the
+// session started from; responses that depend on the wall clock will
+// differ unless the handlers' clocks are pinned.
+func ReplayGoldenSession(r *Router, events []GoldenSessionEvent) error {
+	var expected, actual []GoldenSessionEvent
+	for _, event := range events {
+		if event.Kind == GoldenEventPacket && event.Direction == "TX" {
+			expected = append(expected, event)
+		}
+	}
+
+	r.AddPacketObserver(func(event protocol.PacketEvent) {
+		actual = append(actual, GoldenSessionEvent{
+			Kind:           GoldenEventPacket,
+			Direction:      event.Direction,
+			Characteristic: event.CharType.String(),
+			Packets:        []string{hex.EncodeToString(event.Data)},
+		})
+	})
+
+	for _, event := range events {
+		if event.Kind != GoldenEventMessage || event.Message == nil {
+			continue
+		}
+		charType, ok := characteristicByName(event.Characteristic)
+		if !ok {
+			return fmt.Errorf("golden session event %d: unknown characteristic %q", event.Seq, event.Characteristic)
+		}
+		if err := r.RouteMessage(charType, event.Message); err != nil {
+			log.Warnf("Replaying golden session event %d (%s): %v", event.Seq, event.Message.MessageType, err)
+		}
+	}
+
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			return fmt.Errorf("replay sent %d TX packets, recording has %d; first missing is event %d on %s: %s",
+				len(actual), len(expected), expected[i].Seq, expected[i].Characteristic, expected[i].Packets[0])
+		case i >= len(expected):
+			return fmt.Errorf("replay sent %d TX packets, recording has %d; first extra is on %s: %s",
+				len(actual), len(expected), actual[i].Characteristic, actual[i].Packets[0])
+		case expected[i].Characteristic != actual[i].Characteristic || expected[i].Packets[0] != actual[i].Packets[0]:
+			return fmt.Errorf("TX packet %d differs from recorded event %d:\nexpected: %s %s\nactual:   %s %s",
+				i+1, expected[i].Seq, expected[i].Characteristic, expected[i].Packets[0], actual[i].Characteristic, actual[i].Packets[0])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// goldenSessionScript is a short status polling session. Every message is
+// one the mock runner can encode and parse, and none of the responses depend
+// on the wall clock or randomness, so a replay reproduces them exactly.
+var goldenSessionScript = []struct {
+	charType    bluetooth.CharacteristicType
+	messageType string
+}{
+	{bluetooth.CharCurrentStatus, "ApiVersionRequest"},
+	{bluetooth.CharCurrentStatus, "CurrentBasalStatusRequest"},
+	{bluetooth.CharCurrentStatus, "CurrentBolusStatusRequest"},
+	{bluetooth.CharCurrentStatus, "BolusCalcDataSnapshotRequest"},
+}
+
+// newGoldenRouter creates a mock runner backed router in the state the
+// scripted session starts from
+func newGoldenRouter(t *testing.T) *Router {
+	t.Helper()
+	r, _ := newCapturingRouter(t, mockrunner.New())
+	r.pumpState.IsAuthenticated = true
+	return r
+}
+
+// receiveGolden sends messageType to r the way the emulator receives it from
+// a central: encoded to packets, recorded, parsed back, and routed
+func receiveGolden(t *testing.T, r *Router, recorder *GoldenSessionRecorder, charType bluetooth.CharacteristicType, txID int, messageType string) {
+	t.Helper()
+	encoded, err := r.bridge.EncodeMessage(txID, messageType, nil)
+	if err != nil {
+		t.Fatalf("EncodeMessage(%s) failed: %v", messageType, err)
+	}
+	for _, packetHex := range encoded.Packets {
+		packet, err := hex.DecodeString(packetHex)
+		if err != nil {
+			t.Fatalf("Encoded packet is not hex: %v", err)
+		}
+		recorder.RecordPacket(charType, packet)
+	}
+	parsed, err := r.bridge.ParseReceivedMessage(charType, encoded.Packets)
+	if err != nil {
+		t.Fatalf("ParseReceivedMessage(%s) failed: %v", messageType, err)
+	}
+	recorder.RecordMessage(charType, parsed)
+	if err := r.RouteMessage(charType, parsed); err != nil {
+		t.Fatalf("RouteMessage(%s) failed: %v", messageType, err)
+	}
+}
+
+// recordGoldenSession runs goldenSessionScript through a test router, writing
+// the session archive to a temp file, and returns its path
+func recordGoldenSession(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	r := newGoldenRouter(t)
+	recorder := NewGoldenSessionRecorder(f)
+	recorder.RecordAdvertising([]byte{0x02, 0x01, 0x06}, []byte{0x02, 0x09, 0x54})
+	r.AddPacketObserver(recorder.ObservePacket)
+	r.SetEventNotifier(state.FieldNotifier(recorder.RecordState))
+
+	for txID, step := range goldenSessionScript {
+		receiveGolden(t, r, recorder, step.charType, txID, step.messageType)
+	}
+	if err := r.SetBasalRate(1.25); err != nil {
+		t.Fatalf("SetBasalRate failed: %v", err)
+	}
+	return path
+}
+
+// TestGoldenSessionRecordsFullSession verifies the archive holds advertising
+// data, RX and TX packets, parsed messages, and state changes in order
+func TestGoldenSessionRecordsFullSession(t *testing.T) {
+	events, err := LoadGoldenSession(recordGoldenSession(t))
+	if err != nil {
+		t.Fatalf("LoadGoldenSession failed: %v", err)
+	}
+
+	counts := map[string]int{}
+	for i, event := range events {
+		if event.Seq != i+1 {
+			t.Errorf("Expected event %d to have seq %d, got %d", i, i+1, event.Seq)
+		}
+		counts[event.Kind+" "+event.Direction]++
+	}
+	expected := map[string]int{
+		"advertising ": 1,
+		"packet RX":    len(goldenSessionScript),
+		"message RX":   len(goldenSessionScript),
+		"state ":       1,
+	}
+	for kind, n := range expected {
+		if counts[kind] != n {
+			t.Errorf("Expected %d %q events, got %d (%v)", n, kind, counts[kind], counts)
+		}
+	}
+	// Some responses span several packets
+	if counts["packet TX"] < len(goldenSessionScript) {
+		t.Errorf("Expected at least %d TX packets, got %d", len(goldenSessionScript), counts["packet TX"])
+	}
+
+	if events[0].Kind != GoldenEventAdvertising || events[0].Packets[0] != "020106" {
+		t.Errorf("Expected advertising data first, got %+v", events[0])
+	}
+	if last := events[len(events)-1]; last.Kind != GoldenEventState || last.State["basalRate"] != 1.25 {
+		t.Errorf("Expected basal rate state change last, got %+v", last)
+	}
+}
+
+// TestGoldenSessionReplayReproducesTX verifies replaying a recorded session
+// against a fresh router sends the same TX packets
+func TestGoldenSessionReplayReproducesTX(t *testing.T) {
+	events, err := LoadGoldenSession(recordGoldenSession(t))
+	if err != nil {
+		t.Fatalf("LoadGoldenSession failed: %v", err)
+	}
+
+	if err := ReplayGoldenSession(newGoldenRouter(t), events); err != nil {
+		t.Errorf("Replay diverged from recording: %v", err)
+	}
+}
+
+// TestGoldenSessionReplayFlagsDrift verifies a TX packet differing from the
+// recording fails the replay
+func TestGoldenSessionReplayFlagsDrift(t *testing.T) {
+	events, err := LoadGoldenSession(recordGoldenSession(t))
+	if err != nil {
+		t.Fatalf("LoadGoldenSession failed: %v", err)
+	}
+	for i := range events {
+		if events[i].Kind == GoldenEventPacket && events[i].Direction == "TX" {
+			events[i].Packets[0] = "ffffff"
+			break
+		}
+	}
+
+	err = ReplayGoldenSession(newGoldenRouter(t), events)
+	if err == nil || !strings.Contains(err.Error(), "ffffff") {
+		t.Errorf("Expected replay to report the drifted packet, got %v", err)
+	}
+}
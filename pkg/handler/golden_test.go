@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite handler golden files in testdata/golden")
+
+// newGoldenBridge creates a mock runner bridge whose encodes are recorded
+func newGoldenBridge() (*pumpx2.Bridge, *GoldenRecorder) {
+	recorder := NewGoldenRecorder(mockrunner.New(), "testdata/golden", *updateGolden)
+	return pumpx2.NewBridgeWithRunner(recorder), recorder
+}
+
+// TestGoldenAPIVersion locks in the ApiVersionResponse for the default pump
+func TestGoldenAPIVersion(t *testing.T) {
+	bridge, recorder := newGoldenBridge()
+
+	response, err := NewAPIVersionHandler(bridge).HandleMessage(
+		&pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", TxID: 1}, state.NewPumpState())
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+	if err := recorder.Check("ApiVersion", response); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGoldenTimeSinceReset locks in the TimeSinceResetResponse at a fixed
+// clock and uptime
+func TestGoldenTimeSinceReset(t *testing.T) {
+	bridge, recorder := newGoldenBridge()
+	now := time.Unix(1700000000, 0)
+	pumpState := state.NewPumpState()
+	pumpState.StartTime = now.Add(-time.Hour)
+
+	h := NewTimeSinceResetHandler(bridge)
+	h.now = func() time.Time { return now }
+
+	response, err := h.HandleMessage(&pumpx2.ParsedMessage{MessageType: "TimeSinceResetRequest", TxID: 2}, pumpState)
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+	if err := recorder.Check("TimeSinceReset", response); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGoldenRecorderFlagsDrift verifies a changed response fails the check
+func TestGoldenRecorderFlagsDrift(t *testing.T) {
+	if *updateGolden {
+		t.Skip("not comparing while updating golden files")
+	}
+	bridge, recorder := newGoldenBridge()
+	pumpState := state.NewPumpState()
+	pumpState.APIVersionMinor = 1
+
+	response, err := NewAPIVersionHandler(bridge).HandleMessage(
+		&pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", TxID: 1}, pumpState)
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+	if err := recorder.Check("ApiVersion", response); err == nil {
+		t.Error("Expected a changed API version to drift from the golden file")
+	}
+}
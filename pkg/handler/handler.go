@@ -69,4 +69,31 @@ const (
 	StateChangeTime
 	// StateChangeSuspend indicates pump suspend/resume
 	StateChangeSuspend
+	// StateChangeLowPower indicates the pump entered or left low power
+	StateChangeLowPower
 )
+
+func (t StateChangeType) String() string {
+	switch t {
+	case StateChangeAuth:
+		return "auth"
+	case StateChangeBasal:
+		return "basal"
+	case StateChangeBolus:
+		return "bolus"
+	case StateChangeReservoir:
+		return "reservoir"
+	case StateChangeBattery:
+		return "battery"
+	case StateChangeAlert:
+		return "alert"
+	case StateChangeTime:
+		return "time"
+	case StateChangeSuspend:
+		return "suspend"
+	case StateChangeLowPower:
+		return "lowPower"
+	default:
+		return "unknown"
+	}
+}
@@ -1,27 +1,50 @@
 package handler
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"sync"
 
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/config"
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
 	"github.com/jwoglom/faketandem/pkg/state"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// historyStreamID identifies the stream carrying HistoryLogRequest pages
+const historyStreamID = 1
+
 // HistoryLogHandler handles HistoryLogRequest messages
 type HistoryLogHandler struct {
-	bridge *pumpx2.Bridge
+	bridge   *pumpx2.Bridge
+	pageSize int
+	mutex    sync.Mutex
 }
 
 // NewHistoryLogHandler creates a new history log handler
 func NewHistoryLogHandler(bridge *pumpx2.Bridge) *HistoryLogHandler {
 	return &HistoryLogHandler{
-		bridge: bridge,
+		bridge:   bridge,
+		pageSize: config.DefaultHistoryPageSize,
 	}
 }
 
+// SetPageSize sets the most entries streamed in response to one request
+func (h *HistoryLogHandler) SetPageSize(pageSize int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.pageSize = pageSize
+}
+
+func (h *HistoryLogHandler) getPageSize() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.pageSize
+}
+
 // MessageType returns the message type this handler processes
 func (h *HistoryLogHandler) MessageType() string {
 	return "HistoryLogRequest"
@@ -32,7 +55,10 @@ func (h *HistoryLogHandler) RequiresAuth() bool {
 	return true // History log requires authentication
 }
 
-// HandleMessage processes a HistoryLogRequest
+// HandleMessage processes a HistoryLogRequest. Like the real pump, each entry
+// is streamed as its own HistoryLogStreamResponse. At most one page of
+// entries is streamed per request; a client wanting the rest of the range
+// requests again from the sequence after the last record it received.
 func (h *HistoryLogHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling HistoryLogRequest: txID=%d", msg.TxID)
 
@@ -40,45 +66,85 @@ func (h *HistoryLogHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *
 	startSeq := uint32(0)
 	endSeq := uint32(100)
 
-	if val, ok := msg.Cargo["startSequence"].(float64); ok {
+	if val, ok := cargoNumber(msg.Cargo, "startSequence"); ok {
 		startSeq = uint32(val)
 	}
-	if val, ok := msg.Cargo["endSequence"].(float64); ok {
+	if val, ok := cargoNumber(msg.Cargo, "endSequence"); ok {
 		endSeq = uint32(val)
 	}
 
 	log.Debugf("History log requested: start=%d, end=%d", startSeq, endSeq)
 
-	// Get entries from pump state. The real HistoryLogResponse has no field
-	// for embedded entries -- actual log entries go out separately via
-	// HistoryLogStreamResponse messages on the history log characteristic.
-	// This response just acknowledges the request and reports a stream ID.
-	entries := pumpState.GetHistoryLogEntries(startSeq, endSeq)
-	log.Debugf("History log entries matched: %d", len(entries))
+	page, next, more := pumpState.GetHistoryLogPage(startSeq, endSeq, h.getPageSize())
+	log.Debugf("History log page: %d entries, more=%v, next=%d", len(page), more, next)
 
-	// HistoryLogResponse(int status, int streamId)
+	// HistoryLogResponse(int status, int streamId) just acknowledges the
+	// request -- the entries themselves go out separately as
+	// HistoryLogStreamResponses on the history log characteristic.
 	response, err := h.bridge.EncodeMessage(
 		msg.TxID,
 		"HistoryLogResponse",
 		map[string]interface{}{
 			"status":   0,
-			"streamId": 1,
+			"streamId": historyStreamID,
 		},
 	)
-
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode HistoryLogResponse: %w", err)
 	}
 
-	log.Debugf("Sent history log response with %d entries", len(entries))
+	// An empty range still gets one, empty, stream response so the client
+	// isn't left waiting for entries
+	chunks := [][]state.HistoryLogEntry{nil}
+	if len(page) > 0 {
+		chunks = make([][]state.HistoryLogEntry, len(page))
+		for i := range page {
+			chunks[i] = page[i : i+1]
+		}
+	}
+
+	notifications := make([]*Notification, 0, len(chunks))
+	for _, chunk := range chunks {
+		// HistoryLogStreamResponse(int numberOfHistoryLogs, int streamId,
+		// List<byte[]> historyLogStreamBytes)
+		stream, err := h.bridge.EncodeMessage(msg.TxID, "HistoryLogStreamResponse", map[string]interface{}{
+			"numberOfHistoryLogs":   len(chunk),
+			"streamId":              historyStreamID,
+			"historyLogStreamBytes": historyLogStreamBytes(chunk, pumpState),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode HistoryLogStreamResponse: %w", err)
+		}
+		notifications = append(notifications, &Notification{Characteristic: bluetooth.CharHistoryLog, Message: stream})
+	}
 
 	return &Response{
 		ResponseMessage: response,
 		Characteristic:  bluetooth.CharHistoryLog,
 		Immediate:       true,
+		Notifications:   notifications,
 	}, nil
 }
 
+// historyLogRecordSize is the size of one pumpX2 history log record
+const historyLogRecordSize = 26
+
+// historyLogStreamBytes encodes history entries as pumpX2 history log
+// records, hex encoded for the bridge: the type ID (uint16), the pump time in
+// seconds (uint32) and the sequence number (uint32), all little-endian,
+// followed by 16 bytes of type-specific data which are left zeroed
+func historyLogStreamBytes(entries []state.HistoryLogEntry, pumpState *state.PumpState) []string {
+	records := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		record := make([]byte, historyLogRecordSize)
+		binary.LittleEndian.PutUint16(record[0:2], uint16(entry.TypeID)&0x0FFF)
+		binary.LittleEndian.PutUint32(record[2:6], uint32(pumpState.PumpTime(entry.Timestamp).Unix()))
+		binary.LittleEndian.PutUint32(record[6:10], entry.Sequence)
+		records = append(records, hex.EncodeToString(record))
+	}
+	return records
+}
+
 // HistoryLogStatusHandler handles HistoryLogStatusRequest messages
 type HistoryLogStatusHandler struct {
 	bridge *pumpx2.Bridge
@@ -176,11 +242,33 @@ func (h *DefaultHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *sta
 
 	if err != nil {
 		log.Errorf("Failed to encode generic response: %v", err)
+		return h.unsupportedCommand(msg)
+	}
+
+	log.Infof("Sent generic response: %s", responseType)
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
+
+// unsupportedCommand NACKs a message no response could be built for with an
+// ErrorResponse naming its opcode, like a real pump does for commands it
+// doesn't implement, rather than leaving the client waiting on silence
+func (h *DefaultHandler) unsupportedCommand(msg *pumpx2.ParsedMessage) (*Response, error) {
+	// ErrorResponse(int requestCodeId, ErrorCode errorCode)
+	response, err := h.bridge.EncodeMessage(msg.TxID, "ErrorResponse", map[string]interface{}{
+		"requestCodeId": msg.Opcode,
+		"errorCode":     unsupportedCommandErrorCode,
+	})
+	if err != nil {
+		log.Errorf("Failed to encode unsupported command ErrorResponse: %v", err)
 		// Don't return error - just log it
 		return nil, nil
 	}
 
-	log.Infof("Sent generic response: %s", responseType)
+	log.Infof("Sent unsupported command ErrorResponse for opcode=%d", msg.Opcode)
 
 	return &Response{
 		ResponseMessage: response,
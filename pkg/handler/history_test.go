@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// requestHistoryPage routes a HistoryLogRequest and returns the params of the
+// HistoryLogStreamResponses it streamed, in order
+func requestHistoryPage(t *testing.T, r *Router, runner *fakeRunner, startSeq, endSeq uint32) []map[string]interface{} {
+	t.Helper()
+	first := len(runner.encoded)
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "HistoryLogRequest",
+		Cargo: map[string]interface{}{
+			"startSequence": int(startSeq),
+			"endSequence":   int(endSeq),
+		},
+	})
+	var streams []map[string]interface{}
+	for i, messageType := range runner.encoded[first:] {
+		if messageType == "HistoryLogStreamResponse" {
+			streams = append(streams, runner.params[first+i])
+		}
+	}
+	if len(streams) == 0 {
+		t.Fatalf("Expected HistoryLogStreamResponses, got %v", runner.encoded[first:])
+	}
+	return streams
+}
+
+// streamRecords decodes the history log records carried by a stream response
+func streamRecords(t *testing.T, params map[string]interface{}) [][]byte {
+	t.Helper()
+	var records [][]byte
+	for _, encoded := range params["historyLogStreamBytes"].([]string) {
+		record, err := hex.DecodeString(encoded)
+		if err != nil || len(record) != historyLogRecordSize {
+			t.Fatalf("Expected a %d byte history log record, got %q", historyLogRecordSize, encoded)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// pageSequences returns the sequence of each entry streamed for a page,
+// checking each stream response carries a single entry
+func pageSequences(t *testing.T, streams []map[string]interface{}) []uint32 {
+	t.Helper()
+	var seqs []uint32
+	for _, params := range streams {
+		if params["numberOfHistoryLogs"] != 1 {
+			t.Errorf("Expected one entry per stream response, got %v", params["numberOfHistoryLogs"])
+		}
+		for _, record := range streamRecords(t, params) {
+			seqs = append(seqs, binary.LittleEndian.Uint32(record[6:10]))
+		}
+	}
+	return seqs
+}
+
+// TestHistoryLogRequestPagesLargeRange verifies a large range is split into
+// pages and resuming after each page's last record yields the rest without
+// gaps or overlaps
+func TestHistoryLogRequestPagesLargeRange(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	r.SetHistoryPageSize(4)
+	for i := 0; i < 10; i++ {
+		r.pumpState.AddHistoryLogEntry("entry", nil)
+	}
+
+	var got []uint32
+	var pageSizes []int
+	start := uint32(1)
+	for {
+		streams := requestHistoryPage(t, r, runner, start, 1000)
+		seqs := pageSequences(t, streams)
+		got = append(got, seqs...)
+		pageSizes = append(pageSizes, len(seqs))
+
+		if len(seqs) < 4 {
+			break
+		}
+		if len(pageSizes) > 10 {
+			t.Fatal("Paging did not terminate")
+		}
+		start = seqs[len(seqs)-1] + 1
+	}
+
+	if len(pageSizes) != 3 || pageSizes[0] != 4 || pageSizes[1] != 4 || pageSizes[2] != 2 {
+		t.Errorf("Expected pages of [4 4 2], got %v", pageSizes)
+	}
+	for i, seq := range got {
+		if seq != uint32(i+1) {
+			t.Fatalf("Expected sequences 1..10 in order, got %v", got)
+		}
+	}
+	if len(got) != 10 {
+		t.Errorf("Expected 10 entries, got %v", got)
+	}
+}
+
+// TestHistoryLogRequestWithinPage verifies a range that fits in one page is
+// returned whole
+func TestHistoryLogRequestWithinPage(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	for i := 0; i < 10; i++ {
+		r.pumpState.AddHistoryLogEntry("entry", nil)
+	}
+
+	streams := requestHistoryPage(t, r, runner, 3, 5)
+	if seqs := pageSequences(t, streams); len(seqs) != 3 || seqs[0] != 3 || seqs[2] != 5 {
+		t.Errorf("Expected sequences [3 4 5], got %v", seqs)
+	}
+}
+
+// TestHistoryLogRequestEmptyRange verifies a range with no entries gets a
+// single empty stream response
+func TestHistoryLogRequestEmptyRange(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	streams := requestHistoryPage(t, r, runner, 1, 100)
+	if len(streams) != 1 || streams[0]["numberOfHistoryLogs"] != 0 || len(streamRecords(t, streams[0])) != 0 {
+		t.Errorf("Expected one empty stream response, got %v", streams)
+	}
+}
+
+// TestHistoryLogIncludesTriggeredEvents verifies a client downloading history
+// sees the bolus it started, the alert it raised and a cartridge change
+func TestHistoryLogIncludesTriggeredEvents(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "InitiateBolusRequest",
+		Cargo:       map[string]interface{}{"insulin": 1.5, "bolusId": 9},
+	})
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{Type: state.AlertLowReservoir}})
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "EnterChangeCartridgeModeRequest"})
+	routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "ExitChangeCartridgeModeRequest"})
+
+	var types []interface{}
+	for _, params := range requestHistoryPage(t, r, runner, 1, 100) {
+		for _, record := range streamRecords(t, params) {
+			types = append(types, int(binary.LittleEndian.Uint16(record[0:2])))
+		}
+	}
+	expected := []interface{}{
+		state.HistoryBolusActivated, state.HistoryAlertActivated,
+		state.HistoryCartridgeRemoved, state.HistoryCartridgeInserted,
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected history types %v, got %v", expected, types)
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// identityResponses maps each version request to its response params. Fields
+// not covered by PumpIdentity keep the values the settings defaults served;
+// PumpVersionResponse's are from a real captured Tandem Mobi in pumpX2's test
+// fixtures (PumpVersionResponseTest.testPumpVersionResponse_Mobi).
+var identityResponses = map[string]func(id state.PumpIdentity, serial int64) map[string]interface{}{
+	// PumpVersionResponse(long armSwVer, long mspSwVer, long configABits,
+	// long configBBits, long serialNum, long partNum, String pumpRev,
+	// long pcbaSN, String pcbaRev, long modelNum)
+	"PumpVersionRequest": func(id state.PumpIdentity, serial int64) map[string]interface{} {
+		return map[string]interface{}{
+			"armSwVer":    int64(3628697757),
+			"mspSwVer":    0,
+			"configABits": 0,
+			"configBBits": 0,
+			"serialNum":   serial,
+			"partNum":     1013045,
+			"pumpRev":     "0",
+			"pcbaSN":      232700077,
+			"pcbaRev":     "0",
+			"modelNum":    id.ModelNumber,
+		}
+	},
+	// PumpVersionBResponse(String softwareName, long configurationBitsA,
+	// long configurationBitsB, long serialNumber, long modelNumber,
+	// String pumpRevision, long pcbPartNumberA, long pcbSerialNumberA,
+	// String pcbRevisionNumberA)
+	"PumpVersionBRequest": func(id state.PumpIdentity, serial int64) map[string]interface{} {
+		return map[string]interface{}{
+			"softwareName":       id.FirmwareVersion,
+			"configurationBitsA": 0,
+			"configurationBitsB": 0,
+			"serialNumber":       serial,
+			"modelNumber":        id.ModelNumber,
+			"pumpRevision":       "A",
+			"pcbPartNumberA":     0,
+			"pcbSerialNumberA":   0,
+			"pcbRevisionNumberA": "A",
+		}
+	},
+}
+
+// IdentityHandler serves the pump's model, serial and firmware from pump state
+type IdentityHandler struct {
+	bridge      *pumpx2.Bridge
+	messageType string
+}
+
+// NewIdentityHandler creates an identity handler for one of the
+// identityResponses request types
+func NewIdentityHandler(bridge *pumpx2.Bridge, messageType string) *IdentityHandler {
+	return &IdentityHandler{
+		bridge:      bridge,
+		messageType: messageType,
+	}
+}
+
+// MessageType returns the message type this handler processes
+func (h *IdentityHandler) MessageType() string {
+	return h.messageType
+}
+
+// RequiresAuth returns false; apps read the pump version before pairing
+func (h *IdentityHandler) RequiresAuth() bool {
+	return false
+}
+
+// HandleMessage returns the pump's identity from pump state
+func (h *IdentityHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	log.Infof("Handling %s: txID=%d", h.messageType, msg.TxID)
+
+	build, ok := identityResponses[h.messageType]
+	if !ok {
+		return nil, fmt.Errorf("no identity response for %s", h.messageType)
+	}
+	id := pumpState.GetIdentity()
+	serial, err := id.SerialNumberValue()
+	if err != nil {
+		return nil, fmt.Errorf("invalid pump identity: %w", err)
+	}
+	responseType := h.messageType[:len(h.messageType)-7] + "Response"
+
+	response, err := h.bridge.EncodeMessage(msg.TxID, responseType, build(id, serial))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", responseType, err)
+	}
+
+	return &Response{
+		ResponseMessage: response,
+		Immediate:       true,
+	}, nil
+}
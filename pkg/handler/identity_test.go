@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// TestIdentityHandlersReadConfiguredIdentity verifies both version responses
+// report the configured model, serial and firmware without authentication
+func TestIdentityHandlersReadConfiguredIdentity(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+
+	if err := r.pumpState.SetIdentity(state.PumpIdentity{
+		SerialNumber:    "90817263",
+		Model:           "Tandem Mobi",
+		ModelNumber:     1004000,
+		FirmwareVersion: "7.8.1.0",
+	}); err != nil {
+		t.Fatalf("SetIdentity failed: %v", err)
+	}
+
+	tests := []struct {
+		request string
+		field   string
+		want    interface{}
+	}{
+		{"PumpVersionRequest", "serialNum", int64(90817263)},
+		{"PumpVersionRequest", "modelNum", int64(1004000)},
+		{"PumpVersionBRequest", "serialNumber", int64(90817263)},
+		{"PumpVersionBRequest", "modelNumber", int64(1004000)},
+		{"PumpVersionBRequest", "softwareName", "7.8.1.0"},
+	}
+	for _, tt := range tests {
+		params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: tt.request})
+		if params[tt.field] != tt.want {
+			t.Errorf("%s %s: expected %v, got %v", tt.request, tt.field, tt.want, params[tt.field])
+		}
+	}
+}
+
+// TestSetIdentityRejectsNonNumericSerial verifies a serial the protocol
+// can't carry is rejected up front
+func TestSetIdentityRejectsNonNumericSerial(t *testing.T) {
+	ps := state.NewPumpState()
+	if err := ps.SetIdentity(state.PumpIdentity{SerialNumber: "bi 976", ModelNumber: 1004000}); err == nil {
+		t.Error("expected error for non-numeric serial number")
+	}
+	if got := ps.GetIdentity().SerialNumber; got != "11223344" {
+		t.Errorf("expected serial to be unchanged, got %q", got)
+	}
+}
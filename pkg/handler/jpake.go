@@ -3,6 +3,8 @@ package handler
 import (
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
 
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
@@ -35,6 +37,8 @@ var ErrJPAKEQuickPairRejected = errors.New("quick-pair reconnect rejected: no ca
 // JPAKESessionManager manages JPAKE authentication sessions
 type JPAKESessionManager struct {
 	authenticators map[string]JPAKEAuthenticatorInterface
+	sessions       map[string]*JPAKESessionInfo // reported by Sessions
+	completed      []string                     // completed session IDs, oldest first
 	mutex          sync.RWMutex
 
 	// Configuration for creating authenticators
@@ -54,6 +58,7 @@ type JPAKESessionManager struct {
 func NewJPAKESessionManager(jpakeMode, pumpX2Path, pumpX2Mode, gradleCmd, javaCmd, pumpX2JarPath string, pumpState *state.PumpState) *JPAKESessionManager {
 	return &JPAKESessionManager{
 		authenticators: make(map[string]JPAKEAuthenticatorInterface),
+		sessions:       make(map[string]*JPAKESessionInfo),
 		jpakeMode:      jpakeMode,
 		pumpX2Path:     pumpX2Path,
 		pumpX2Mode:     pumpX2Mode,
@@ -91,6 +96,7 @@ func (m *JPAKESessionManager) GetOrCreate(sessionID string, pairingCode string,
 		log.Infof("Quick-pair reconnect detected for session %s (Jpake3SessionKeyRequest with no prior rounds); resuming from cached long-term key", sessionID)
 		auth := NewQuickReconnectJPAKEAuthenticator(longTermKey)
 		m.authenticators[sessionID] = auth
+		m.sessions[sessionID] = &JPAKESessionInfo{SessionID: sessionID, Mode: "quickPair"}
 		return auth, nil
 	}
 
@@ -105,26 +111,22 @@ func (m *JPAKESessionManager) GetOrCreate(sessionID string, pairingCode string,
 	}
 
 	m.authenticators[sessionID] = auth
+	m.sessions[sessionID] = &JPAKESessionInfo{SessionID: sessionID, Mode: m.sessionMode()}
 	log.Debugf("Created new JPAKE authenticator (%s mode) for session: %s", m.jpakeMode, sessionID)
 
 	return auth, nil
 }
 
-// jpakeCloser is implemented by authenticators that hold a live resource
-// needing explicit cleanup -- currently PumpX2JPAKEAuthenticator's spawned
-// jpake-server subprocess. Checked via type assertion since most
-// authenticators (Go-based, quick-reconnect) hold no such resource.
-type jpakeCloser interface {
-	Close() error
-}
-
 // closeAuthenticator releases any resources (e.g. a jpake-server subprocess)
-// held by auth. Without this, every completed or abandoned JPAKE handshake
-// using the pumpx2 mode would leak its "java -jar ... jpake-server" process
-// for the lifetime of the emulator, degrading performance (and eventually
-// handshake latency/reliability) the longer the emulator runs.
+// held by auth. Only authenticators holding a live resource implement
+// io.Closer -- currently PumpX2JPAKEAuthenticator's spawned jpake-server
+// subprocess -- so this is checked via type assertion. Without this, every
+// completed or abandoned JPAKE handshake using the pumpx2 mode would leak its
+// "java -jar ... jpake-server" process for the lifetime of the emulator,
+// degrading performance (and eventually handshake latency/reliability) the
+// longer the emulator runs.
 func closeAuthenticator(sessionID string, auth JPAKEAuthenticatorInterface) {
-	closer, ok := auth.(jpakeCloser)
+	closer, ok := auth.(io.Closer)
 	if !ok {
 		return
 	}
@@ -142,9 +144,24 @@ func (m *JPAKESessionManager) Remove(sessionID string) {
 		closeAuthenticator(sessionID, auth)
 	}
 	delete(m.authenticators, sessionID)
+	delete(m.sessions, sessionID)
 	log.Debugf("Removed JPAKE authenticator for session: %s", sessionID)
 }
 
+// Fail removes and closes the authenticator for a session whose handshake
+// failed, so a retry starts from a fresh authenticator (and process)
+func (m *JPAKESessionManager) Fail(sessionID string, cause error) {
+	log.Warnf("JPAKE session %s failed: %v", sessionID, cause)
+	m.Remove(sessionID)
+}
+
+// CloseAll closes every remaining authenticator; call on emulator shutdown
+// so no jpake-server JVM outlives the process
+func (m *JPAKESessionManager) CloseAll() {
+	m.RemoveAll()
+	log.Debug("Closed all JPAKE authenticators")
+}
+
 // RemoveAll clears every in-progress authenticator. Called on BLE disconnect
 // so a stale/broken authenticator (e.g. one whose pumpX2 subprocess died
 // mid-handshake) is never reused by the next connection attempt.
@@ -157,11 +174,31 @@ func (m *JPAKESessionManager) RemoveAll() {
 	}
 	for sessionID, auth := range m.authenticators {
 		closeAuthenticator(sessionID, auth)
+		delete(m.sessions, sessionID)
 	}
 	m.authenticators = make(map[string]JPAKEAuthenticatorInterface)
 	log.Debug("Cleared all in-progress JPAKE authenticators")
 }
 
+// RemoveCentral clears the in-progress authenticators of every app session
+// on centralID (see state.AppSession.Key), leaving other centrals'
+// handshakes alone
+func (m *JPAKESessionManager) RemoveCentral(centralID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	prefix := centralID + "/"
+	for sessionID, auth := range m.authenticators {
+		if !strings.HasPrefix(sessionID, prefix) {
+			continue
+		}
+		closeAuthenticator(sessionID, auth)
+		delete(m.authenticators, sessionID)
+		delete(m.sessions, sessionID)
+		log.Debugf("Removed JPAKE authenticator for session: %s", sessionID)
+	}
+}
+
 // JPAKEHandler handles JPAKE authentication messages
 // JPAKE is a password-authenticated key exchange protocol
 type JPAKEHandler struct {
@@ -195,9 +232,9 @@ func (h *JPAKEHandler) RequiresAuth() bool {
 func (h *JPAKEHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling %s (round %d): txID=%d", h.messageType, h.round, msg.TxID)
 
-	// Get or create JPAKE authenticator for this session
-	// Using a simplified session ID for now (could be based on BLE connection)
-	sessionID := "default"
+	// Get or create the JPAKE authenticator for this central and app instance
+	session := bindAppInstance(msg, pumpState, h.sessionManager)
+	sessionID := session.Key()
 	pairingCode := pumpState.GetPairingCode()
 
 	auth, err := h.sessionManager.GetOrCreate(sessionID, pairingCode, h.bridge, h.round)
@@ -222,10 +259,16 @@ func (h *JPAKEHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state
 	// Process this round
 	responseParams, err := auth.ProcessRound(h.round, requestData)
 	if err != nil {
-		return nil, fmt.Errorf("JPAKE round %d failed: %w", h.round, err)
+		err = fmt.Errorf("JPAKE round %d failed: %w", h.round, err)
+		h.sessionManager.Fail(sessionID, err)
+		return nil, err
 	}
 
 	log.Debugf("JPAKE round %d processed successfully", h.round)
+	h.sessionManager.Advance(sessionID, h.round, h.messageType)
+	if _, ok := responseParams["appInstanceId"]; ok {
+		responseParams["appInstanceId"] = session.AppInstanceID
+	}
 
 	// Determine the response message type
 	responseType := h.getResponseType()
@@ -266,7 +309,7 @@ func (h *JPAKEHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state
 		}
 
 		// Clean up the authenticator
-		h.sessionManager.Remove(sessionID)
+		h.sessionManager.Complete(sessionID)
 	}
 
 	return &Response{
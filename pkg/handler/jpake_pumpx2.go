@@ -15,6 +15,7 @@ import (
 	expect "github.com/google/goexpect"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/jwoglom/faketandem/pkg/protocol"
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
 )
 
@@ -271,23 +272,23 @@ func (j *PumpX2JPAKEAuthenticator) readServerRound1aResponse() error {
 	output, _, err := j.gexp.Expect(round1aRegex, 30*time.Second)
 	if err != nil {
 		// Try to get any remaining output for debugging
-		log.Errorf("Failed to read JPAKE_1A. Last output captured: %s", output)
+		log.Errorf("Failed to read JPAKE_1A. Last output captured: %s", protocol.LogAuthText(output))
 		return fmt.Errorf("failed to read JPAKE_1A from pumpX2: %w", err)
 	}
 
 	matches := round1aRegex.FindStringSubmatch(output)
 	if len(matches) < 2 {
-		log.Errorf("Failed to parse JPAKE_1A. Full output: %s", output)
+		log.Errorf("Failed to parse JPAKE_1A. Full output: %s", protocol.LogAuthText(output))
 		return fmt.Errorf("failed to parse JPAKE_1A output: %s", output)
 	}
 
 	// Parse the JSON response
 	if err := json.Unmarshal([]byte(matches[1]), &j.round1aResponse); err != nil {
-		log.Errorf("Failed to unmarshal JPAKE_1A JSON: %s. Error: %v", matches[1], err)
+		log.Errorf("Failed to unmarshal JPAKE_1A JSON: %s. Error: %v", protocol.LogAuthText(matches[1]), err)
 		return fmt.Errorf("failed to unmarshal JPAKE_1A response: %w", err)
 	}
 
-	log.Debugf("Got server Round1a response: %+v", j.round1aResponse)
+	log.Debugf("Got server Round1a response: %s", protocol.LogAuthText(fmt.Sprintf("%+v", j.round1aResponse)))
 
 	return nil
 }
@@ -299,22 +300,22 @@ func (j *PumpX2JPAKEAuthenticator) readServerRound1bResponse() error {
 	round1bRegex := regexp.MustCompile(`(?s).*?JPAKE_1B:\s*(\{.*?\})`)
 	output, _, err := j.gexp.Expect(round1bRegex, 30*time.Second)
 	if err != nil {
-		log.Errorf("Failed to read JPAKE_1B. Last output captured: %s", output)
+		log.Errorf("Failed to read JPAKE_1B. Last output captured: %s", protocol.LogAuthText(output))
 		return fmt.Errorf("failed to read JPAKE_1B from pumpX2: %w", err)
 	}
 
 	matches := round1bRegex.FindStringSubmatch(output)
 	if len(matches) < 2 {
-		log.Errorf("Failed to parse JPAKE_1B. Full output: %s", output)
+		log.Errorf("Failed to parse JPAKE_1B. Full output: %s", protocol.LogAuthText(output))
 		return fmt.Errorf("failed to parse JPAKE_1B output: %s", output)
 	}
 
 	if err := json.Unmarshal([]byte(matches[1]), &j.round1bResponse); err != nil {
-		log.Errorf("Failed to unmarshal JPAKE_1B JSON: %s. Error: %v", matches[1], err)
+		log.Errorf("Failed to unmarshal JPAKE_1B JSON: %s. Error: %v", protocol.LogAuthText(matches[1]), err)
 		return fmt.Errorf("failed to unmarshal JPAKE_1B response: %w", err)
 	}
 
-	log.Debugf("Got server Round1b response: %+v", j.round1bResponse)
+	log.Debugf("Got server Round1b response: %s", protocol.LogAuthText(fmt.Sprintf("%+v", j.round1bResponse)))
 
 	return nil
 }
@@ -327,11 +328,8 @@ func (j *PumpX2JPAKEAuthenticator) processRound1(requestData map[string]interfac
 
 	if j.round == 0 {
 		// First call - send client's Jpake1aRequest
-		requestHex := j.encodeClientRequest(requestData)
-
-		log.Debugf("Sending client Jpake1aRequest to pumpX2: %s", requestHex)
-		if err := j.gexp.Send(requestHex + "\n"); err != nil {
-			log.Warnf("Failed to send to pumpX2: %v", err)
+		if err := j.sendClientRequest(requestData); err != nil {
+			return nil, err
 		}
 
 		// Now read JPAKE_1B (pumpX2 outputs it after receiving client's 1a)
@@ -344,11 +342,8 @@ func (j *PumpX2JPAKEAuthenticator) processRound1(requestData map[string]interfac
 	}
 
 	// Second call - send client's Jpake1bRequest
-	requestHex := j.encodeClientRequest(requestData)
-
-	log.Debugf("Sending client Jpake1bRequest to pumpX2: %s", requestHex)
-	if err := j.gexp.Send(requestHex + "\n"); err != nil {
-		log.Warnf("Failed to send to pumpX2: %v", err)
+	if err := j.sendClientRequest(requestData); err != nil {
+		return nil, err
 	}
 
 	// Read server's round 2 response (pumpX2 sends it after receiving round 1b)
@@ -356,23 +351,23 @@ func (j *PumpX2JPAKEAuthenticator) processRound1(requestData map[string]interfac
 	output, _, err := j.gexp.Expect(round2Regex, 30*time.Second)
 	if err != nil {
 		if j.gexp != nil {
-			log.Errorf("Failed to read JPAKE_2. Last output captured: %s", output)
+			log.Errorf("Failed to read JPAKE_2. Last output captured: %s", protocol.LogAuthText(output))
 		}
 		return nil, fmt.Errorf("failed to read JPAKE_2 from pumpX2: %w", err)
 	}
 
 	matches := round2Regex.FindStringSubmatch(output)
 	if len(matches) < 2 {
-		log.Errorf("Failed to parse JPAKE_2. Full output: %s", output)
+		log.Errorf("Failed to parse JPAKE_2. Full output: %s", protocol.LogAuthText(output))
 		return nil, fmt.Errorf("failed to parse JPAKE_2 output: %s", output)
 	}
 
 	if err := json.Unmarshal([]byte(matches[1]), &j.round2Response); err != nil {
-		log.Errorf("Failed to unmarshal JPAKE_2 JSON: %s. Error: %v", matches[1], err)
+		log.Errorf("Failed to unmarshal JPAKE_2 JSON: %s. Error: %v", protocol.LogAuthText(matches[1]), err)
 		return nil, fmt.Errorf("failed to unmarshal JPAKE_2 response: %w", err)
 	}
 
-	log.Debugf("Got server Round2 response: %+v", j.round2Response)
+	log.Debugf("Got server Round2 response: %s", protocol.LogAuthText(fmt.Sprintf("%+v", j.round2Response)))
 
 	return convertServerResponseToParams(j.round1bResponse)
 }
@@ -391,11 +386,8 @@ func (j *PumpX2JPAKEAuthenticator) processRound1(requestData map[string]interfac
 // this code did) deadlocks, since jpake-server won't produce it until the
 // round 3 request (sent by processRound3, on a later call) has also arrived.
 func (j *PumpX2JPAKEAuthenticator) processRound2(requestData map[string]interface{}) (map[string]interface{}, error) {
-	requestHex := j.encodeClientRequest(requestData)
-
-	log.Debugf("Sending client Jpake2Request to pumpX2: %s", requestHex)
-	if err := j.gexp.Send(requestHex + "\n"); err != nil {
-		log.Warnf("Failed to send Jpake2Request to pumpX2: %v", err)
+	if err := j.sendClientRequest(requestData); err != nil {
+		return nil, err
 	}
 
 	j.round = 2
@@ -408,34 +400,31 @@ func (j *PumpX2JPAKEAuthenticator) processRound3(requestData map[string]interfac
 	// Send client's Jpake3SessionKeyRequest. jpake-server has been blocked
 	// waiting for exactly this since it finished reading round 2 (see
 	// processRound2) -- only once it arrives does jpake-server print "JPAKE_3:".
-	requestHex := j.encodeClientRequest(requestData)
-
-	log.Debugf("Sending client Jpake3SessionKeyRequest to pumpX2: %s", requestHex)
-	if err := j.gexp.Send(requestHex + "\n"); err != nil {
-		log.Warnf("Failed to send to pumpX2: %v", err)
+	if err := j.sendClientRequest(requestData); err != nil {
+		return nil, err
 	}
 
 	round3Regex := regexp.MustCompile(`JPAKE_3:\s*({.+})`)
 	output, _, err := j.gexp.Expect(round3Regex, 30*time.Second)
 	if err != nil {
 		if j.gexp != nil {
-			log.Errorf("Failed to read JPAKE_3. Last output captured: %s", output)
+			log.Errorf("Failed to read JPAKE_3. Last output captured: %s", protocol.LogAuthText(output))
 		}
 		return nil, fmt.Errorf("failed to read JPAKE_3 from pumpX2: %w", err)
 	}
 
 	matches := round3Regex.FindStringSubmatch(output)
 	if len(matches) < 2 {
-		log.Errorf("Failed to parse JPAKE_3. Full output: %s", output)
+		log.Errorf("Failed to parse JPAKE_3. Full output: %s", protocol.LogAuthText(output))
 		return nil, fmt.Errorf("failed to parse JPAKE_3 output: %s", output)
 	}
 
 	if err := json.Unmarshal([]byte(matches[1]), &j.round3Response); err != nil {
-		log.Errorf("Failed to unmarshal JPAKE_3 JSON: %s. Error: %v", matches[1], err)
+		log.Errorf("Failed to unmarshal JPAKE_3 JSON: %s. Error: %v", protocol.LogAuthText(matches[1]), err)
 		return nil, fmt.Errorf("failed to unmarshal JPAKE_3 response: %w", err)
 	}
 
-	log.Debugf("Got server Round3 response: %+v", j.round3Response)
+	log.Debugf("Got server Round3 response: %s", protocol.LogAuthText(fmt.Sprintf("%+v", j.round3Response)))
 
 	j.round = 3
 
@@ -454,11 +443,8 @@ func (j *PumpX2JPAKEAuthenticator) processRound3(requestData map[string]interfac
 // processRound4 handles round 4
 func (j *PumpX2JPAKEAuthenticator) processRound4(requestData map[string]interface{}) (map[string]interface{}, error) {
 	// Send client's Jpake4KeyConfirmationRequest
-	requestHex := j.encodeClientRequest(requestData)
-
-	log.Debugf("Sending client Jpake4KeyConfirmationRequest to pumpX2: %s", requestHex)
-	if err := j.gexp.Send(requestHex + "\n"); err != nil {
-		log.Warnf("Failed to send Jpake4KeyConfirmationRequest to pumpX2: %v", err)
+	if err := j.sendClientRequest(requestData); err != nil {
+		return nil, err
 	}
 
 	// Read server's round 4 response
@@ -466,30 +452,30 @@ func (j *PumpX2JPAKEAuthenticator) processRound4(requestData map[string]interfac
 	output, _, err := j.gexp.Expect(round4Regex, 30*time.Second)
 	if err != nil {
 		if j.gexp != nil {
-			log.Errorf("Failed to read JPAKE_4. Last output captured: %s", output)
+			log.Errorf("Failed to read JPAKE_4. Last output captured: %s", protocol.LogAuthText(output))
 		}
 		return nil, fmt.Errorf("failed to read JPAKE_4 from pumpX2: %w", err)
 	}
 
 	matches := round4Regex.FindStringSubmatch(output)
 	if len(matches) < 2 {
-		log.Errorf("Failed to parse JPAKE_4. Full output: %s", output)
+		log.Errorf("Failed to parse JPAKE_4. Full output: %s", protocol.LogAuthText(output))
 		return nil, fmt.Errorf("failed to parse JPAKE_4 output: %s", output)
 	}
 
 	if err := json.Unmarshal([]byte(matches[1]), &j.round4Response); err != nil {
-		log.Errorf("Failed to unmarshal JPAKE_4 JSON: %s. Error: %v", matches[1], err)
+		log.Errorf("Failed to unmarshal JPAKE_4 JSON: %s. Error: %v", protocol.LogAuthText(matches[1]), err)
 		return nil, fmt.Errorf("failed to unmarshal JPAKE_4 response: %w", err)
 	}
 
-	log.Debugf("Got server Round4 response: %+v", j.round4Response)
+	log.Debugf("Got server Round4 response: %s", protocol.LogAuthText(fmt.Sprintf("%+v", j.round4Response)))
 
 	// Read the final result with derived secret
 	resultRegex := regexp.MustCompile(`({[^{}]*"derivedSecret"[^{}]*})`)
 	resultOutput, _, err := j.gexp.Expect(resultRegex, 30*time.Second)
 	if err != nil {
 		if j.gexp != nil {
-			log.Errorf("Failed to read derivedSecret. Last output captured: %s", resultOutput)
+			log.Errorf("Failed to read derivedSecret. Last output captured: %s", protocol.LogAuthText(resultOutput))
 		}
 		return nil, fmt.Errorf("failed to read derived secret from pumpX2: %w", err)
 	}
@@ -498,7 +484,7 @@ func (j *PumpX2JPAKEAuthenticator) processRound4(requestData map[string]interfac
 	if len(resultMatches) >= 2 {
 		var result map[string]interface{}
 		if err := json.Unmarshal([]byte(resultMatches[1]), &result); err != nil {
-			log.Errorf("Failed to unmarshal result JSON: %s. Error: %v", resultMatches[1], err)
+			log.Errorf("Failed to unmarshal result JSON: %s. Error: %v", protocol.LogAuthText(resultMatches[1]), err)
 			log.Warnf("Failed to unmarshal result JSON: %v", err)
 		} else {
 			if derivedSecretHex, ok := result["derivedSecret"].(string); ok {
@@ -508,7 +494,7 @@ func (j *PumpX2JPAKEAuthenticator) processRound4(requestData map[string]interfac
 				} else {
 					log.Warnf("Failed to hex-decode derivedSecret for long-term key caching: %v", decErr)
 				}
-				log.Infof("Extracted shared secret from pumpX2: %s", derivedSecretHex)
+				log.Infof("Extracted shared secret from pumpX2: %s", protocol.LogAuthHex(derivedSecretHex))
 			}
 		}
 	}
@@ -518,14 +504,29 @@ func (j *PumpX2JPAKEAuthenticator) processRound4(requestData map[string]interfac
 	return convertServerResponseToParams(j.round4Response)
 }
 
-// encodeClientRequest encodes a client request using pumpX2's format
-// Always returns a non-empty string (uses fallback if encoding fails)
-func (j *PumpX2JPAKEAuthenticator) encodeClientRequest(requestData map[string]interface{}) string {
+// sendClientRequest forwards the client's request to jpake-server's stdin,
+// which validates the client's challenge and stops responding (failing the
+// round's read) if it is malformed
+func (j *PumpX2JPAKEAuthenticator) sendClientRequest(requestData map[string]interface{}) error {
+	requestHex, err := j.encodeClientRequest(requestData)
+	if err != nil {
+		return fmt.Errorf("failed to encode client request for pumpX2: %w", err)
+	}
+
+	log.Debugf("Sending client %v to pumpX2: %s", requestData["messageName"], protocol.LogAuthHex(requestHex))
+	if err := j.gexp.Send(requestHex + "\n"); err != nil {
+		return fmt.Errorf("failed to send client request to pumpX2: %w", err)
+	}
+	return nil
+}
+
+// encodeClientRequest encodes a client request as the space-separated hex
+// packets jpake-server reads from stdin
+func (j *PumpX2JPAKEAuthenticator) encodeClientRequest(requestData map[string]interface{}) (string, error) {
 	// Extract message name from request data
 	messageName, ok := requestData["messageName"].(string)
 	if !ok {
-		log.Warnf("Request data missing messageName")
-		return "00"
+		return "", fmt.Errorf("request data missing messageName")
 	}
 
 	// If the caller gave us the client's original raw BLE fragments, forward
@@ -538,77 +539,58 @@ func (j *PumpX2JPAKEAuthenticator) encodeClientRequest(requestData map[string]in
 	// response is the leading suspect).
 	if rawPacketsHex, ok := requestData["rawPacketsHex"].([]string); ok && len(rawPacketsHex) > 0 {
 		result := strings.Join(rawPacketsHex, " ")
-		log.Debugf("Forwarding client request verbatim (no re-encode): %s -> %s", messageName, result)
-		return result
-	}
-
-	// Try to use the bridge to encode the message if available
-	if j.bridge != nil {
-		// Build params map excluding messageName and cargo. cliparser's "encode"
-		// picks a constructor purely by matching parameter *count*, so any extra
-		// key makes it fail to find one -- "cargo" is a base Message field our
-		// output parser always includes (every message's toString() has it), but
-		// it's never an actual constructor parameter (constructors take the
-		// specific named fields, e.g. appInstanceId/centralChallenge; "cargo" is
-		// set internally from those during parse()).
-		params := make(map[string]interface{})
-		for key, value := range requestData {
-			if key != "messageName" && key != "cargo" {
-				params[key] = value
-			}
-		}
+		log.Debugf("Forwarding client request verbatim (no re-encode): %s -> %s", messageName, protocol.LogAuthText(result))
+		return result, nil
+	}
 
-		// Jpake3SessionKeyRequest has only one real field (challengeParam, an
-		// int) besides cargo, so excluding "cargo" above leaves exactly one
-		// param -- which collides with the class's OTHER one-arg constructor,
-		// Jpake3SessionKeyRequest(byte[] rawCargo). cliparser's "encode" picks
-		// whichever constructor Class.getConstructors() happens to return first
-		// for that parameter count, and empirically that's the byte[] one, which
-		// then fails to convert challengeParam's plain int/JSON-number value to
-		// byte[] ("Cannot convert java.lang.Integer to byte[]"). Route around
-		// the ambiguity by targeting that raw-cargo constructor deliberately: it
-		// reconstructs an identical message from the same bytes.
-		if messageName == "Jpake3SessionKeyRequest" {
-			params = map[string]interface{}{"cargo": requestData["cargo"]}
-		}
+	if j.bridge == nil {
+		return "", fmt.Errorf("no bridge to encode %s", messageName)
+	}
 
-		// Use bridge to encode the message
-		// Use txID 0 for simplicity - pumpX2 jpake-server doesn't validate txID
-		encoded, err := j.bridge.EncodeMessage(0, messageName, params)
-		if err == nil && len(encoded.Packets) > 0 {
-			// jpake-server reads one line from stdin and hands it directly to
-			// cliparser's "parse" command, which expects each raw BLE fragment as
-			// its own whitespace-delimited token (see Main.splitRawHexPackets) --
-			// NOT one concatenated blob.
-			result := strings.Join(encoded.Packets, " ")
-			log.Debugf("Encoded client request via bridge: %s -> %s", messageName, result)
-			return result
-		}
-		// Bridge encoding failed or returned no packets - fall through to fallback
-		if err != nil {
-			log.Debugf("Bridge encoding failed (will use fallback): %v", err)
-		} else {
-			log.Debugf("Bridge encoding returned no packets (will use fallback)")
+	// Build params map excluding messageName and cargo. cliparser's "encode"
+	// picks a constructor purely by matching parameter *count*, so any extra
+	// key makes it fail to find one -- "cargo" is a base Message field our
+	// output parser always includes (every message's toString() has it), but
+	// it's never an actual constructor parameter (constructors take the
+	// specific named fields, e.g. appInstanceId/centralChallenge; "cargo" is
+	// set internally from those during parse()).
+	params := make(map[string]interface{})
+	for key, value := range requestData {
+		if key != "messageName" && key != "cargo" {
+			params[key] = value
 		}
 	}
 
-	// Fallback: extract the hex data directly from request params
-	// pumpX2's cliparser may not support encoding JPAKE request messages,
-	// so we construct a minimal message from the raw data
-	if hexData, ok := requestData["centralChallengeHash"].(string); ok && len(hexData) > 0 {
-		previewLen := 40
-		if len(hexData) < previewLen {
-			previewLen = len(hexData)
-		}
-		log.Debugf("Using centralChallengeHash as fallback for %s: %s...", messageName, hexData[:previewLen])
-		return hexData
+	// Jpake3SessionKeyRequest has only one real field (challengeParam, an
+	// int) besides cargo, so excluding "cargo" above leaves exactly one
+	// param -- which collides with the class's OTHER one-arg constructor,
+	// Jpake3SessionKeyRequest(byte[] rawCargo). cliparser's "encode" picks
+	// whichever constructor Class.getConstructors() happens to return first
+	// for that parameter count, and empirically that's the byte[] one, which
+	// then fails to convert challengeParam's plain int/JSON-number value to
+	// byte[] ("Cannot convert java.lang.Integer to byte[]"). Route around
+	// the ambiguity by targeting that raw-cargo constructor deliberately: it
+	// reconstructs an identical message from the same bytes.
+	if messageName == "Jpake3SessionKeyRequest" {
+		params = map[string]interface{}{"cargo": requestData["cargo"]}
 	}
 
-	// For messages without centralChallengeHash (like Jpake3SessionKeyRequest),
-	// return a placeholder that won't crash pumpX2's parse function
-	// pumpX2 will reject it, but at least it won't crash on empty input
-	log.Debugf("No fallback data available for %s, using placeholder", messageName)
-	return "00"
+	// Use bridge to encode the message
+	// Use txID 0 for simplicity - pumpX2 jpake-server doesn't validate txID
+	encoded, err := j.bridge.EncodeMessage(0, messageName, params)
+	if err != nil {
+		return "", err
+	}
+	if len(encoded.Packets) == 0 {
+		return "", fmt.Errorf("encoding %s returned no packets", messageName)
+	}
+	// jpake-server reads one line from stdin and hands it directly to
+	// cliparser's "parse" command, which expects each raw BLE fragment as
+	// its own whitespace-delimited token (see Main.splitRawHexPackets) --
+	// NOT one concatenated blob.
+	result := strings.Join(encoded.Packets, " ")
+	log.Debugf("Encoded client request via bridge: %s -> %s", messageName, protocol.LogAuthText(result))
+	return result, nil
 }
 
 // GetSharedSecret returns the derived shared secret
@@ -3,8 +3,10 @@ package handler
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -44,9 +46,9 @@ func TestEncodeClientRequest_ExcludesCargoField(t *testing.T) {
 		"cargo":            "000041045483658e8ea056f5b4d1454c13740db3a9712830938ea074fb0096489f4d5a8a16fa09767adcbdc6e8f74550d91c5ebe9fa3a18f91c2e73d12e182a2cb60a64f41049da3799b6ba274f3a83ee4b8b4e456cd262292db6dd35f62b91843e1e418700c1a97be5d09e26bd5a11956ee6f4819c09f71f60522e1418aa0a9e6afb07390512011b80880ed77972d4435cdfb223a7f30c54bff805c1308796e36f5b468e62c1f",
 	}
 
-	result := auth.encodeClientRequest(requestData)
-	if result == "00" {
-		t.Fatal("encodeClientRequest fell back to the \"00\" placeholder -- encoding failed (see logs)")
+	result, err := auth.encodeClientRequest(requestData)
+	if err != nil {
+		t.Fatalf("encodeClientRequest failed: %v", err)
 	}
 
 	// A real round-trip re-encode of the exact fragments the phone sent should
@@ -331,3 +333,86 @@ func TestPumpX2JPAKEAuthenticator_FullFlowViaJar(t *testing.T) {
 		t.Error("client did not report a derived secret or HMAC validation after round 4")
 	}
 }
+
+// clientJpake1aPackets are the fragments of a client's Jpake1aRequest, as
+// forwarded to jpake-server
+var clientJpake1aPackets = []string{
+	"01042004a70000410477521493da112577faa707",
+	"0004c9c92a68e4b40cc46df17b306f52",
+}
+
+// TestJPAKEServerHelperProcess isn't a real test: it is the fake jpake-server
+// the authenticator tests start. Like the real one it prints JPAKE_1A, then
+// reads the client's Jpake1aRequest packets from stdin, answering with
+// JPAKE_1B only if they are the packets named by JPAKE_HELPER_VALID_1A and
+// otherwise exiting with an exception, as it does for a malformed challenge.
+func TestJPAKEServerHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_JPAKE_SERVER_HELPER") != "1" {
+		return
+	}
+	fmt.Println(`JPAKE_1A: {"messageName":"Jpake1aResponse","txId":"0","messageParams":[0,[65,4,1]]}`)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != os.Getenv("JPAKE_HELPER_VALID_1A") {
+		fmt.Fprintln(os.Stderr, "Exception in thread \"main\" java.lang.IllegalArgumentException: invalid JPAKE round 1 challenge")
+		os.Exit(1)
+	}
+	fmt.Println(`JPAKE_1B: {"messageName":"Jpake1bResponse","txId":"0","messageParams":[0,[65,4,2]]}`)
+	os.Exit(0)
+}
+
+// newHelperPumpX2JPAKEAuthenticator creates an authenticator whose
+// jpake-server is TestJPAKEServerHelperProcess, accepting validPackets as the
+// client's Jpake1aRequest
+func newHelperPumpX2JPAKEAuthenticator(t *testing.T, validPackets string) *PumpX2JPAKEAuthenticator {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "java")
+	contents := fmt.Sprintf("#!/bin/sh\nexec %q -test.run='^TestJPAKEServerHelperProcess$'\n", os.Args[0])
+	if err := os.WriteFile(script, []byte(contents), 0o700); err != nil {
+		t.Fatalf("Failed to write helper script: %v", err)
+	}
+	t.Setenv("GO_WANT_JPAKE_SERVER_HELPER", "1")
+	t.Setenv("JPAKE_HELPER_VALID_1A", validPackets)
+	return NewPumpX2JPAKEAuthenticator("123456", nil, "", "jar", "", script, "unused.jar")
+}
+
+// TestPumpX2JPAKEAuthenticatorForwardsClientChallenge verifies the client's
+// round 1 packets reach jpake-server, which answers a valid challenge
+func TestPumpX2JPAKEAuthenticatorForwardsClientChallenge(t *testing.T) {
+	auth := newHelperPumpX2JPAKEAuthenticator(t, strings.Join(clientJpake1aPackets, " "))
+	defer auth.Close()
+
+	params, err := auth.ProcessRound(1, map[string]interface{}{
+		"messageName":   "Jpake1aRequest",
+		"rawPacketsHex": clientJpake1aPackets,
+	})
+	if err != nil {
+		t.Fatalf("ProcessRound failed: %v", err)
+	}
+	if params["centralChallengeHash"] != "410401" {
+		t.Errorf("Expected jpake-server's JPAKE_1A challenge hash, got %v", params)
+	}
+}
+
+// TestPumpX2JPAKEAuthenticatorRejectsMalformedChallenge verifies a malformed
+// client challenge that jpake-server rejects fails the round, and that a
+// request that can't be encoded fails it without sending jpake-server a
+// placeholder
+func TestPumpX2JPAKEAuthenticatorRejectsMalformedChallenge(t *testing.T) {
+	auth := newHelperPumpX2JPAKEAuthenticator(t, strings.Join(clientJpake1aPackets, " "))
+	defer auth.Close()
+
+	_, err := auth.ProcessRound(1, map[string]interface{}{
+		"messageName":   "Jpake1aRequest",
+		"rawPacketsHex": []string{"00042004a70000deadbeef"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "JPAKE_1B") {
+		t.Fatalf("Expected jpake-server to reject the malformed challenge, got %v", err)
+	}
+
+	auth = newHelperPumpX2JPAKEAuthenticator(t, strings.Join(clientJpake1aPackets, " "))
+	defer auth.Close()
+	_, err = auth.ProcessRound(1, map[string]interface{}{"messageName": "Jpake1aRequest"})
+	if err == nil || !strings.Contains(err.Error(), "failed to encode client request") {
+		t.Errorf("Expected an encoding error, got %v", err)
+	}
+}
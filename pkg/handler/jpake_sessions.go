@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JPAKESessionInfo describes a JPAKE session for debugging a stuck pairing,
+// without any of its key material
+type JPAKESessionInfo struct {
+	SessionID string `json:"sessionId"`
+	// Mode is the authenticator running the session: go, pumpx2, or
+	// quickPair for a reconnect resumed from the cached long-term key
+	Mode string `json:"mode"`
+	// Round is the last round completed, 0 before any. Jpake1aRequest and
+	// Jpake1bRequest are both round 1, so LastMessage tells them apart.
+	Round       int    `json:"round"`
+	LastMessage string `json:"lastMessage,omitempty"`
+	Complete    bool   `json:"complete"`
+}
+
+// maxCompletedJPAKESessions is how many completed sessions Sessions keeps
+// reporting; older ones are pruned so a long-running emulator that pairs
+// repeatedly doesn't grow without bound
+const maxCompletedJPAKESessions = 16
+
+// sessionMode returns the Mode reported for sessions this manager creates
+func (m *JPAKESessionManager) sessionMode() string {
+	if m.jpakeMode == "pumpx2" {
+		return "pumpx2"
+	}
+	return "go"
+}
+
+// Advance records that sessionID completed round with messageType
+func (m *JPAKESessionManager) Advance(sessionID string, round int, messageType string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if info, exists := m.sessions[sessionID]; exists {
+		info.Round = round
+		info.LastMessage = messageType
+	}
+}
+
+// Complete closes and removes the authenticator of a finished session,
+// keeping its info so Sessions still reports it as complete
+func (m *JPAKESessionManager) Complete(sessionID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if auth, exists := m.authenticators[sessionID]; exists {
+		closeAuthenticator(sessionID, auth)
+	}
+	delete(m.authenticators, sessionID)
+	if info, exists := m.sessions[sessionID]; exists {
+		info.Complete = true
+		m.completed = append(m.completed, sessionID)
+	}
+	m.pruneCompletedLocked()
+	log.Debugf("Completed JPAKE session: %s", sessionID)
+}
+
+// pruneCompletedLocked drops the oldest completed sessions beyond
+// maxCompletedJPAKESessions. An ID reused by a newer, in-progress session is
+// left alone. m.mutex must be held.
+func (m *JPAKESessionManager) pruneCompletedLocked() {
+	for len(m.completed) > maxCompletedJPAKESessions {
+		sessionID := m.completed[0]
+		m.completed = m.completed[1:]
+		if info, exists := m.sessions[sessionID]; exists && info.Complete {
+			delete(m.sessions, sessionID)
+		}
+	}
+}
+
+// Sessions returns the in-progress and completed JPAKE sessions, ordered by
+// session ID
+func (m *JPAKESessionManager) Sessions() []JPAKESessionInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sessions := make([]JPAKESessionInfo, 0, len(m.sessions))
+	for _, info := range m.sessions {
+		sessions = append(sessions, *info)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].SessionID < sessions[j].SessionID })
+	return sessions
+}
+
+// Forget closes every authenticator and drops all session info, completed
+// sessions included, so Sessions only reports pairings started afterwards
+func (m *JPAKESessionManager) Forget() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for sessionID, auth := range m.authenticators {
+		closeAuthenticator(sessionID, auth)
+	}
+	m.authenticators = make(map[string]JPAKEAuthenticatorInterface)
+	m.sessions = make(map[string]*JPAKESessionInfo)
+	m.completed = nil
+	log.Debug("Forgot all JPAKE sessions")
+}
+
+// CompletedFullPairing returns the ID of a completed session that ran the
+// full JPAKE exchange rather than quick-pairing from a cached key, if any
+func (m *JPAKESessionManager) CompletedFullPairing() (string, bool) {
+	for _, info := range m.Sessions() {
+		if info.Complete && info.Mode != "quickPair" {
+			return info.SessionID, true
+		}
+	}
+	return "", false
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/jwoglom/faketandem/pkg/pumpx2"
@@ -264,6 +265,52 @@ func TestJPAKESessionManager_RemoveSession(t *testing.T) {
 	}
 }
 
+// fakeClosingAuthenticator is a JPAKE authenticator that records Close calls
+type fakeClosingAuthenticator struct {
+	JPAKEAuthenticator
+	closed int
+}
+
+func (f *fakeClosingAuthenticator) Close() error {
+	f.closed++
+	return nil
+}
+
+// TestJPAKESessionManager_RemoveClosesAuthenticator tests Remove, Fail and CloseAll close io.Closer authenticators
+func TestJPAKESessionManager_RemoveClosesAuthenticator(t *testing.T) {
+	manager := NewJPAKESessionManager("go", "/tmp", "gradle", "./gradlew", "java", "", state.NewPumpState())
+
+	removed := &fakeClosingAuthenticator{}
+	failed := &fakeClosingAuthenticator{}
+	remainingA := &fakeClosingAuthenticator{}
+	remainingB := &fakeClosingAuthenticator{}
+	manager.authenticators["removed"] = removed
+	manager.authenticators["failed"] = failed
+	manager.authenticators["a"] = remainingA
+	manager.authenticators["b"] = remainingB
+
+	manager.Remove("removed")
+	if removed.closed != 1 {
+		t.Errorf("Expected Remove to close authenticator once, got %d", removed.closed)
+	}
+
+	manager.Fail("failed", fmt.Errorf("round 2 failed"))
+	if failed.closed != 1 {
+		t.Errorf("Expected Fail to close authenticator once, got %d", failed.closed)
+	}
+
+	manager.CloseAll()
+	if remainingA.closed != 1 || remainingB.closed != 1 {
+		t.Errorf("Expected CloseAll to close all remaining authenticators, got %d and %d", remainingA.closed, remainingB.closed)
+	}
+	if removed.closed != 1 || failed.closed != 1 {
+		t.Error("Expected already-removed authenticators not to be closed again")
+	}
+	if len(manager.authenticators) != 0 {
+		t.Errorf("Expected no authenticators after CloseAll, got %d", len(manager.authenticators))
+	}
+}
+
 // TestJPAKEAuthenticator_IsComplete tests completion state
 func TestJPAKEAuthenticator_IsComplete(t *testing.T) {
 	auth := NewJPAKEAuthenticator("123456", &pumpx2.Bridge{})
@@ -340,3 +387,114 @@ func BenchmarkJPAKESessionManager_GetOrCreate(b *testing.B) {
 		_, _ = manager.GetOrCreate(sessionID, pairingCode, bridge, 1)
 	}
 }
+
+// TestBindAppInstanceScopesAuthSession verifies the same app instance ID
+// resumes its JPAKE session and auth, while a new one starts fresh
+func TestBindAppInstanceScopesAuthSession(t *testing.T) {
+	pumpState := state.NewPumpState()
+	pumpState.SetCentralID("central-1")
+	manager := NewJPAKESessionManager("go", "/tmp", "gradle", "./gradlew", "java", "", pumpState)
+	bridge := &pumpx2.Bridge{}
+	msgFor := func(appInstanceID int) *pumpx2.ParsedMessage {
+		return &pumpx2.ParsedMessage{Cargo: map[string]interface{}{"appInstanceId": appInstanceID}}
+	}
+
+	first := bindAppInstance(msgFor(100), pumpState, manager)
+	if first.Key() != "central-1/100" {
+		t.Fatalf("Expected session key central-1/100, got %s", first.Key())
+	}
+	auth, err := manager.GetOrCreate(first.Key(), "123456", bridge, 1)
+	if err != nil {
+		t.Fatalf("GetOrCreate returned error: %v", err)
+	}
+	pumpState.SetAuthenticated([]byte("key"))
+
+	resumed := bindAppInstance(msgFor(100), pumpState, manager)
+	if resumed != first || !pumpState.IsAuthenticated {
+		t.Errorf("Expected same app instance to resume authenticated session %s, got %s", first.Key(), resumed.Key())
+	}
+	if again, _ := manager.GetOrCreate(resumed.Key(), "123456", bridge, 1); again != auth {
+		t.Error("Expected same app instance to resume its JPAKE authenticator")
+	}
+
+	fresh := bindAppInstance(msgFor(200), pumpState, manager)
+	if fresh.Key() != "central-1/200" {
+		t.Errorf("Expected session key central-1/200, got %s", fresh.Key())
+	}
+	if pumpState.IsAuthenticated {
+		t.Error("Expected new app instance to reset authentication")
+	}
+	if _, exists := manager.authenticators[first.Key()]; exists {
+		t.Error("Expected previous app instance's JPAKE session to be removed")
+	}
+}
+
+// TestJPAKESessionManager_Sessions tests an in-progress session reports its
+// round and mode, and a completed one stays listed as complete
+func TestJPAKESessionManager_Sessions(t *testing.T) {
+	manager := NewJPAKESessionManager("go", "/tmp", "gradle", "./gradlew", "java", "", state.NewPumpState())
+	if _, err := manager.GetOrCreate("central-1/100", "123456", &pumpx2.Bridge{}, 1); err != nil {
+		t.Fatalf("GetOrCreate returned error: %v", err)
+	}
+	manager.Advance("central-1/100", 1, "Jpake1bRequest")
+
+	sessions := manager.Sessions()
+	expected := JPAKESessionInfo{SessionID: "central-1/100", Mode: "go", Round: 1, LastMessage: "Jpake1bRequest"}
+	if len(sessions) != 1 || sessions[0] != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, sessions)
+	}
+
+	manager.Advance("central-1/100", 4, "Jpake4KeyConfirmationRequest")
+	manager.Complete("central-1/100")
+	manager.RemoveAll()
+
+	sessions = manager.Sessions()
+	if len(sessions) != 1 || !sessions[0].Complete || sessions[0].Round != 4 {
+		t.Errorf("Expected the completed session to be reported complete, got %+v", sessions)
+	}
+	if _, exists := manager.authenticators["central-1/100"]; exists {
+		t.Error("Expected the completed session's authenticator to be removed")
+	}
+}
+
+// TestJPAKESessionManager_RemoveCentral verifies clearing one central's
+// sessions leaves another central's in-progress handshake intact
+func TestJPAKESessionManager_RemoveCentral(t *testing.T) {
+	manager := NewJPAKESessionManager("go", "/tmp", "gradle", "./gradlew", "java", "", state.NewPumpState())
+	for _, sessionID := range []string{"central-1/100", "central-1/200", "central-10/100"} {
+		if _, err := manager.GetOrCreate(sessionID, "123456", &pumpx2.Bridge{}, 1); err != nil {
+			t.Fatalf("GetOrCreate(%s) returned error: %v", sessionID, err)
+		}
+	}
+
+	manager.RemoveCentral("central-1")
+
+	sessions := manager.Sessions()
+	if len(sessions) != 1 || sessions[0].SessionID != "central-10/100" {
+		t.Errorf("Expected only central-10's session to remain, got %+v", sessions)
+	}
+	if _, exists := manager.authenticators["central-10/100"]; !exists {
+		t.Error("Expected central-10's authenticator to be kept")
+	}
+}
+
+// TestJPAKESessionManager_PrunesCompletedSessions tests only the most recent
+// completed sessions are kept
+func TestJPAKESessionManager_PrunesCompletedSessions(t *testing.T) {
+	manager := NewJPAKESessionManager("go", "/tmp", "gradle", "./gradlew", "java", "", state.NewPumpState())
+	for i := 0; i < maxCompletedJPAKESessions+2; i++ {
+		sessionID := fmt.Sprintf("central-1/%03d", i)
+		if _, err := manager.GetOrCreate(sessionID, "123456", &pumpx2.Bridge{}, 1); err != nil {
+			t.Fatalf("GetOrCreate returned error: %v", err)
+		}
+		manager.Complete(sessionID)
+	}
+
+	sessions := manager.Sessions()
+	if len(sessions) != maxCompletedJPAKESessions {
+		t.Fatalf("Expected %d completed sessions kept, got %d", maxCompletedJPAKESessions, len(sessions))
+	}
+	if sessions[0].SessionID != "central-1/002" {
+		t.Errorf("Expected the oldest sessions pruned, got %s first", sessions[0].SessionID)
+	}
+}
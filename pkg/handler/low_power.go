@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LowPowerHandler acknowledges a request that moves the pump into or out of
+// low power: DisconnectPumpRequest, sent by a client about to disconnect to
+// save battery, or UserInteractionRequest, which wakes it
+type LowPowerHandler struct {
+	*SimpleControlHandler
+	lowPower bool
+}
+
+// NewLowPowerHandler creates a handler for msgType that enters low power if
+// lowPower is true, and otherwise wakes the pump
+func NewLowPowerHandler(bridge *pumpx2.Bridge, msgType string, lowPower bool) *LowPowerHandler {
+	return &LowPowerHandler{
+		SimpleControlHandler: NewSimpleControlHandler(bridge, msgType),
+		lowPower:             lowPower,
+	}
+}
+
+// HandleMessage acknowledges the request, changing the low-power state once
+// the response is sent
+func (h *LowPowerHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
+	response, err := h.SimpleControlHandler.HandleMessage(msg, pumpState)
+	if err != nil {
+		return nil, err
+	}
+	response.StateChanges = append(response.StateChanges, StateChange{Type: StateChangeLowPower, Data: h.lowPower})
+	return response, nil
+}
+
+// SetLowPowerDisconnect makes entering low power also drop the connection,
+// rather than leave it to the client
+func (r *Router) SetLowPowerDisconnect(disconnect bool) {
+	r.lowPowerDisconnect = disconnect
+}
+
+// applyLowPowerChange enters or leaves low power, disconnecting on entry if
+// configured to
+func (r *Router) applyLowPowerChange(change StateChange) bool {
+	lowPower, ok := change.Data.(bool)
+	if !ok {
+		return false
+	}
+	if r.pumpState.IsLowPower() == lowPower {
+		return true
+	}
+
+	r.pumpState.SetLowPower(lowPower)
+	if !lowPower {
+		log.Info("Pump woke from low power")
+		return true
+	}
+	log.Info("Pump entered low power: only critical alerts will be notified")
+	if r.lowPowerDisconnect {
+		log.Info("Disconnecting central after entering low power")
+		r.disconnect()
+	}
+	return true
+}
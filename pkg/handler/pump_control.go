@@ -122,31 +122,42 @@ func (h *SetTempRateHandler) RequiresAuth() bool {
 func (h *SetTempRateHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState *state.PumpState) (*Response, error) {
 	log.Infof("Handling SetTempRateRequest: txID=%d cargo=%v", msg.TxID, msg.Cargo)
 
+	// SetTempRateRequest(int minutes, int percent)
 	percentage := 100
-	if val, ok := msg.Cargo["percentage"].(float64); ok {
+	if val, ok := cargoNumber(msg.Cargo, "percent"); ok {
 		percentage = int(val)
 	}
 	durationMinutes := 0
-	if val, ok := msg.Cargo["duration"].(float64); ok {
+	if val, ok := cargoNumber(msg.Cargo, "minutes"); ok {
 		durationMinutes = int(val)
 	}
 
 	basalRate := pumpState.GetBasalRate()
 	tempRate := basalRate * float64(percentage) / 100.0
-	tempEnd := time.Now().Add(time.Duration(durationMinutes) * time.Minute)
-
-	log.Infof("Setting temp rate: %d%% (%.3f U/hr) for %d minutes", percentage, tempRate, durationMinutes)
-
-	stateChanges := []StateChange{
-		{
+	tempStart := time.Now()
+	tempEnd := tempStart.Add(time.Duration(durationMinutes) * time.Minute)
+
+	// Deny a temp rate that alone would deliver more than the hourly limit
+	status := 0
+	var stateChanges []StateChange
+	if limit := pumpState.GetTherapyConfig().MaxHourlyInsulin; limit > 0 && tempRate > limit {
+		log.Warnf("Denying temp rate of %.3f U/hr: over the hourly limit of %.2f units", tempRate, limit)
+		status = 1
+		stateChanges = append(stateChanges, hourlyLimitAlert())
+	} else {
+		log.Infof("Setting temp rate: %d%% (%.3f U/hr) for %d minutes", percentage, tempRate, durationMinutes)
+
+		stateChanges = append(stateChanges, StateChange{
 			Type: StateChangeBasal,
 			Data: &state.BasalState{
-				CurrentRate:     basalRate,
-				TempBasalActive: true,
-				TempBasalRate:   tempRate,
-				TempBasalEnd:    tempEnd,
+				CurrentRate:      basalRate,
+				TempBasalActive:  true,
+				TempBasalRate:    tempRate,
+				TempBasalPercent: percentage,
+				TempBasalStart:   tempStart,
+				TempBasalEnd:     tempEnd,
 			},
-		},
+		})
 	}
 
 	// SetTempRateResponse(int status, int tempRateId). Note: as of pumpX2
@@ -159,7 +170,7 @@ func (h *SetTempRateHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpState
 		msg.TxID,
 		"SetTempRateResponse",
 		map[string]interface{}{
-			"status":     0,
+			"status":     status,
 			"tempRateId": 1,
 		},
 	)
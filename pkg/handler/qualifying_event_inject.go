@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// ErrUnknownQualifyingEvent is returned by InjectEvent for an event type it
+// can't send
+var ErrUnknownQualifyingEvent = errors.New("unknown qualifying event type")
+
+// injectableEvent is a qualifying event that can be sent on demand: how to
+// send it from request params
+type injectableEvent struct {
+	send func(qe *QualifyingEventsNotifier, p eventParams) error
+}
+
+// injectableEvents are the events InjectEvent can send. Params missing from
+// a request default to the current pump state. Like scheduled events, they
+// are synthetic: nothing in pump state changes.
+var injectableEvents = map[string]injectableEvent{
+	"bolusStart": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyBolusStart(p.uint32("bolusId", qe.pumpState.GetNextBolusID()), p.float("units", 0))
+	}},
+	"bolusComplete": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyBolusComplete(p.uint32("bolusId", qe.pumpState.GetNextBolusID()), p.float("delivered", 0), p.float("total", 0))
+	}},
+	"bolusCanceled": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyBolusCanceled(p.uint32("bolusId", qe.pumpState.GetNextBolusID()), p.float("delivered", 0), p.float("total", 0))
+	}},
+	"alert": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyAlert(p.alert())
+	}},
+	"alertCleared": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyAlertCleared(p.uint32("alertId", 0))
+	}},
+	"basalChange": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		rate := qe.pumpState.GetBasalRate()
+		return qe.NotifyBasalRateChange(p.float("oldRate", rate), p.float("newRate", rate), p.bool("tempBasal"))
+	}},
+	"reservoirLow": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyReservoirLow(p.float("units", qe.pumpState.GetReservoirLevel()))
+	}},
+	"batteryLow": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyBatteryLow(int(p.float("percentage", float64(qe.pumpState.GetBatteryLevel()))))
+	}},
+	"batteryChange": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyBatteryChange(int(p.float("percentage", float64(qe.pumpState.GetBatteryLevel()))))
+	}},
+	"pumpSuspended": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyPumpSuspended(p.string("reason", "injected"))
+	}},
+	"pumpResumed": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyPumpResumed()
+	}},
+	"cgmReading": {func(qe *QualifyingEventsNotifier, p eventParams) error {
+		return qe.NotifyCGMReading(int(p.float("egv", float64(qe.pumpState.GetCurrentEGV()))))
+	}},
+}
+
+// InjectableEventTypes returns the event types InjectEvent can send, sorted
+func InjectableEventTypes() []string {
+	names := make([]string, 0, len(injectableEvents))
+	for name := range injectableEvents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InjectEvent sends the qualifying event eventType with params, returning
+// the bitmask packet sent, or nil if it was suppressed by quiet hours or low
+// power
+func (qe *QualifyingEventsNotifier) InjectEvent(eventType string, params map[string]interface{}) ([]byte, error) {
+	event, ok := injectableEvents[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQualifyingEvent, eventType)
+	}
+
+	// Send through a copy of qe that records what reaches the central, so
+	// the packet returned is the one actually sent
+	var packet []byte
+	capturing := *qe
+	capturing.notify = func(charType bluetooth.CharacteristicType, data []byte) error {
+		if err := qe.notify(charType, data); err != nil {
+			return err
+		}
+		packet = data
+		return nil
+	}
+	if err := event.send(&capturing, eventParams(params)); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// eventParams are an injected event's params as decoded from JSON
+type eventParams map[string]interface{}
+
+func (p eventParams) float(key string, def float64) float64 {
+	if val, ok := p[key].(float64); ok {
+		return val
+	}
+	return def
+}
+
+func (p eventParams) uint32(key string, def uint32) uint32 {
+	if val, ok := p[key].(float64); ok && val >= 0 {
+		return uint32(val)
+	}
+	return def
+}
+
+func (p eventParams) bool(key string) bool {
+	val, _ := p[key].(bool)
+	return val
+}
+
+func (p eventParams) string(key, def string) string {
+	if val, ok := p[key].(string); ok && val != "" {
+		return val
+	}
+	return def
+}
+
+// alert returns the alert described by alertType, priority and message
+func (p eventParams) alert() state.Alert {
+	return state.Alert{
+		Type:     state.AlertType(p.float("alertType", 0)),
+		Priority: state.AlertPriority(p.float("priority", float64(state.PriorityInfo))),
+		Message:  p.string("message", "Injected alert"),
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/binary"
 	"fmt"
+	"time"
 
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
 	"github.com/jwoglom/faketandem/pkg/state"
@@ -27,12 +28,19 @@ const (
 	qualifyingEventBolusChange      uint32 = 1024
 	qualifyingEventRemainingInsulin uint32 = 262144
 	qualifyingEventBattery          uint32 = 65536
+	qualifyingEventCGMChange        uint32 = 32768
 )
 
-// QualifyingEventsNotifier sends qualifying event bitmask notifications
+// QualifyingEventsNotifier sends qualifying event bitmask notifications.
+// The bitmask is unframed, so events carry no txID and can't collide with
+// the txIDs of client requests.
 type QualifyingEventsNotifier struct {
 	ble       *bluetooth.Ble
 	pumpState *state.PumpState
+
+	// notify sends the bitmask to the central; defaults to ble.Notify
+	notify func(charType bluetooth.CharacteristicType, data []byte) error
+	now    func() time.Time
 }
 
 // NewQualifyingEventsNotifier creates a new qualifying events notifier
@@ -40,6 +48,8 @@ func NewQualifyingEventsNotifier(ble *bluetooth.Ble, pumpState *state.PumpState)
 	return &QualifyingEventsNotifier{
 		ble:       ble,
 		pumpState: pumpState,
+		notify:    ble.Notify,
+		now:       time.Now,
 	}
 }
 
@@ -63,10 +73,21 @@ func (qe *QualifyingEventsNotifier) NotifyBolusCanceled(bolusID uint32, delivere
 	return qe.sendBitmask(qualifyingEventBolusChange)
 }
 
-// NotifyAlert sends the ALERT qualifying event
+// NotifyAlert sends the ALERT qualifying event, unless the alert is
+// non-critical and raised during quiet hours or in low power. A suppressed
+// alert is still active, so the client sees it the next time it polls alert
+// status.
 func (qe *QualifyingEventsNotifier) NotifyAlert(alert state.Alert) error {
+	if qe.pumpState != nil && qe.pumpState.SuppressesAlert(alert, qe.now()) {
+		log.Infof("Suppressing ALERT qualifying event during quiet hours: type=%d, priority=%s, message=%s",
+			alert.Type, alert.Priority, alert.Message)
+		return nil
+	}
 	log.Infof("Sending ALERT qualifying event: type=%d, priority=%d, message=%s",
 		alert.Type, alert.Priority, alert.Message)
+	if alert.Priority == state.PriorityCritical {
+		return qe.send(qualifyingEventAlert)
+	}
 	return qe.sendBitmask(qualifyingEventAlert)
 }
 
@@ -96,6 +117,12 @@ func (qe *QualifyingEventsNotifier) NotifyBatteryLow(percentage int) error {
 	return qe.sendBitmask(qualifyingEventBattery)
 }
 
+// NotifyBatteryChange sends the BATTERY qualifying event
+func (qe *QualifyingEventsNotifier) NotifyBatteryChange(percentage int) error {
+	log.Infof("Sending BATTERY qualifying event (battery change): %d%%", percentage)
+	return qe.sendBitmask(qualifyingEventBattery)
+}
+
 // NotifyPumpSuspended sends the PUMP_SUSPEND qualifying event
 func (qe *QualifyingEventsNotifier) NotifyPumpSuspended(reason string) error {
 	log.Infof("Sending PUMP_SUSPEND qualifying event: reason=%s", reason)
@@ -108,15 +135,45 @@ func (qe *QualifyingEventsNotifier) NotifyPumpResumed() error {
 	return qe.sendBitmask(qualifyingEventPumpResume)
 }
 
-// sendBitmask sends a raw little-endian uint32 qualifying event bitmask
-// notification on the QualifyingEvents characteristic
+// NotifyHistoryLogUpdated sends nothing: pumpX2 has no qualifying event for
+// history appends, so real clients poll HistoryLogStatusRequest for the
+// high-water sequence. The change that logged the entry sends its own event.
+func (qe *QualifyingEventsNotifier) NotifyHistoryLogUpdated(sequence uint32) error {
+	log.Debugf("History log updated to sequence %d (no qualifying event)", sequence)
+	return nil
+}
+
+// NotifyCGMReading sends the CGM_CHANGE qualifying event
+func (qe *QualifyingEventsNotifier) NotifyCGMReading(egv int) error {
+	log.Infof("Sending CGM_CHANGE qualifying event: egv=%d", egv)
+	return qe.sendBitmask(qualifyingEventCGMChange)
+}
+
+// lowPower returns true while non-critical events are held back because the
+// pump is in low power
+func (qe *QualifyingEventsNotifier) lowPower() bool {
+	return qe.pumpState != nil && qe.pumpState.IsLowPower()
+}
+
+// sendBitmask sends a non-critical qualifying event bitmask, unless the pump
+// is in low power
 func (qe *QualifyingEventsNotifier) sendBitmask(bits uint32) error {
+	if qe.lowPower() {
+		log.Debugf("Not sending qualifying event bitmask 0x%08x in low power", bits)
+		return nil
+	}
+	return qe.send(bits)
+}
+
+// send sends a raw little-endian uint32 qualifying event bitmask
+// notification on the QualifyingEvents characteristic
+func (qe *QualifyingEventsNotifier) send(bits uint32) error {
 	buf := make([]byte, 4)
 	binary.LittleEndian.PutUint32(buf, bits)
 
 	log.Debugf("Sending qualifying event bitmask 0x%08x on %s", bits, bluetooth.CharQualifyingEvents)
 
-	if err := qe.ble.Notify(bluetooth.CharQualifyingEvents, buf); err != nil {
+	if err := qe.notify(bluetooth.CharQualifyingEvents, buf); err != nil {
 		return fmt.Errorf("failed to send qualifying event notification: %w", err)
 	}
 
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// TestQuietHoursSuppressOnlyNonCriticalAlerts verifies a warning raised
+// during quiet hours is stored without a qualifying event, while a critical
+// alert is still notified
+func TestQuietHoursSuppressOnlyNonCriticalAlerts(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	r.pumpState.StartTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.pumpState.SetQuietHours(&state.QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour})
+	r.qeNotifier.now = func() time.Time { return time.Date(2024, 1, 1, 23, 30, 0, 0, time.UTC) }
+
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{
+		Type: state.AlertLowReservoir, Priority: state.PriorityWarning, Message: "Low insulin",
+	}})
+	if len(*sent) != 0 {
+		t.Errorf("Expected a warning during quiet hours not to be notified, got %d packet(s)", len(*sent))
+	}
+	if alerts := r.pumpState.ActiveAlerts; len(alerts) != 1 {
+		t.Errorf("Expected the suppressed warning to still be active, got %v", alerts)
+	}
+
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{
+		Type: state.AlertOcclusion, Priority: state.PriorityCritical, Message: "Occlusion",
+	}})
+	if len(*sent) != 1 || (*sent)[0].charType != bluetooth.CharQualifyingEvents {
+		t.Fatalf("Expected a critical alert to be notified during quiet hours, got %v", *sent)
+	}
+
+	r.qeNotifier.now = func() time.Time { return time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC) }
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{
+		Type: state.AlertLowReservoir, Priority: state.PriorityWarning, Message: "Low insulin",
+	}})
+	if len(*sent) != 2 {
+		t.Errorf("Expected a warning outside quiet hours to be notified, got %d packet(s)", len(*sent))
+	}
+}
+
+// TestInjectEventSendsBitmask verifies an injected event sends its bitmask
+// and returns the packet sent, and an unknown event type is rejected
+func TestInjectEventSendsBitmask(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+
+	packet, err := r.qeNotifier.InjectEvent("batteryLow", map[string]interface{}{"percentage": 5.0})
+	if err != nil {
+		t.Fatalf("InjectEvent failed: %v", err)
+	}
+	if len(*sent) != 1 || (*sent)[0].charType != bluetooth.CharQualifyingEvents || !reflect.DeepEqual((*sent)[0].data, packet) {
+		t.Fatalf("Expected the returned packet to be sent on QualifyingEvents, got %v (returned %x)", *sent, packet)
+	}
+	if !reflect.DeepEqual(packet, []byte{0x00, 0x00, 0x01, 0x00}) {
+		t.Errorf("Expected the BATTERY bitmask, got %x", packet)
+	}
+
+	if _, err := r.qeNotifier.InjectEvent("noSuchEvent", nil); !errors.Is(err, ErrUnknownQualifyingEvent) {
+		t.Errorf("Expected ErrUnknownQualifyingEvent, got %v", err)
+	}
+	if len(*sent) != 1 {
+		t.Errorf("Expected nothing sent for an unknown event, got %v", *sent)
+	}
+
+	r.pumpState.SetLowPower(true)
+	if packet, err := r.qeNotifier.InjectEvent("batteryLow", nil); err != nil || packet != nil {
+		t.Errorf("Expected no packet returned for an event held back in low power, got %x (%v)", packet, err)
+	}
+	if len(*sent) != 1 {
+		t.Errorf("Expected nothing sent in low power, got %v", *sent)
+	}
+}
+
+// TestLowPowerStopsNonCriticalNotifications verifies DisconnectPumpRequest
+// puts the pump in low power, holding back all but critical alerts, and
+// UserInteractionRequest wakes it
+func TestLowPowerStopsNonCriticalNotifications(t *testing.T) {
+	r, runner, sent := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	disconnects := 0
+	r.disconnect = func() { disconnects++ }
+	qualifyingEvents := func() int {
+		count := 0
+		for _, packet := range *sent {
+			if packet.charType == bluetooth.CharQualifyingEvents {
+				count++
+			}
+		}
+		return count
+	}
+
+	if err := r.RouteMessage(bluetooth.CharControl, &pumpx2.ParsedMessage{MessageType: "DisconnectPumpRequest", TxID: 1}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "DisconnectPumpResponse" {
+		t.Errorf("Expected DisconnectPumpResponse, got %s", last)
+	}
+	if !r.pumpState.IsLowPower() {
+		t.Fatal("Expected DisconnectPumpRequest to enter low power")
+	}
+	if disconnects != 0 {
+		t.Errorf("Expected no disconnect by default, got %d", disconnects)
+	}
+
+	if err := r.qeNotifier.NotifyBatteryChange(50); err != nil {
+		t.Fatalf("NotifyBatteryChange failed: %v", err)
+	}
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{
+		Type: state.AlertLowReservoir, Priority: state.PriorityWarning, Message: "Low insulin",
+	}})
+	if got := qualifyingEvents(); got != 0 {
+		t.Fatalf("Expected no non-critical events in low power, got %d", got)
+	}
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{
+		Type: state.AlertOcclusion, Priority: state.PriorityCritical, Message: "Occlusion",
+	}})
+	if got := qualifyingEvents(); got != 1 {
+		t.Fatalf("Expected a critical alert to be notified in low power, got %d event(s)", got)
+	}
+
+	if err := r.RouteMessage(bluetooth.CharControl, &pumpx2.ParsedMessage{MessageType: "UserInteractionRequest", TxID: 2}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if r.pumpState.IsLowPower() {
+		t.Fatal("Expected UserInteractionRequest to wake the pump")
+	}
+	if err := r.qeNotifier.NotifyBatteryChange(49); err != nil {
+		t.Fatalf("NotifyBatteryChange failed: %v", err)
+	}
+	if got := qualifyingEvents(); got != 2 {
+		t.Errorf("Expected events to be notified again after waking, got %d event(s)", got)
+	}
+
+	r.SetLowPowerDisconnect(true)
+	if err := r.RouteMessage(bluetooth.CharControl, &pumpx2.ParsedMessage{MessageType: "DisconnectPumpRequest", TxID: 3}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if disconnects != 1 {
+		t.Errorf("Expected entering low power to disconnect once, got %d", disconnects)
+	}
+}
+
+// tickWithin runs one simulator update, failing the test if it doesn't
+// return, e.g. because a notifier re-entered the pump state lock
+func tickWithin(t *testing.T, sim *state.Simulator, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		sim.Tick()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("Simulator update deadlocked")
+	}
+}
+
+// TestSimulatorLowReservoirAlertNotifies verifies a simulator update that
+// raises the low reservoir alert sends its qualifying events through the
+// real notifier, which reads pump state, without deadlocking
+func TestSimulatorLowReservoirAlertNotifies(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	sim := state.NewSimulator(r.pumpState, time.Second)
+	sim.SetEventNotifier(r.GetQualifyingEventsNotifier())
+	sim.AddTickHook(func(ps *state.PumpState) { ps.Reservoir.CurrentUnits = 10 })
+
+	tickWithin(t, sim, 5*time.Second)
+
+	var alert, remaining bool
+	for _, bits := range qualifyingEvents(*sent) {
+		alert = alert || bits&QEAlert != 0
+		remaining = remaining || bits&QERemainingInsulin != 0
+	}
+	if !alert || !remaining {
+		t.Errorf("Expected ALERT and REMAINING_INSULIN qualifying events, got %v", qualifyingEvents(*sent))
+	}
+}
+
+// TestSimulatorBasalAndBatteryChangesNotify verifies a simulator update that
+// ends a temp rate and drains the battery sends its qualifying events
+// through the real notifier without deadlocking
+func TestSimulatorBasalAndBatteryChangesNotify(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	r.pumpState.Basal.TempBasalActive = true
+	r.pumpState.Basal.TempBasalRate = 1.5
+	r.pumpState.Basal.TempBasalEnd = time.Now().Add(-time.Minute)
+	// Long enough an update drains at least a percent of battery
+	sim := state.NewSimulator(r.pumpState, time.Hour)
+	sim.SetEventNotifier(r.GetQualifyingEventsNotifier())
+
+	tickWithin(t, sim, 5*time.Second)
+
+	var basal, battery bool
+	for _, bits := range qualifyingEvents(*sent) {
+		basal = basal || bits&QEBasalChange != 0
+		battery = battery || bits&QEBattery != 0
+	}
+	if !basal || !battery {
+		t.Errorf("Expected BASAL_CHANGE and BATTERY qualifying events, got %v", qualifyingEvents(*sent))
+	}
+	if rate := r.pumpState.GetBasalRate(); rate != r.pumpState.Basal.CurrentRate {
+		t.Errorf("Expected the profile rate after the temp rate ended, got %.2f", rate)
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// authRequiredErrorCode is the ErrorResponse errorCode sent for a request
+// made before authenticating. pumpX2's ErrorResponse.ErrorCode has no
+// dedicated value, so like busy this is UNDEFINED_ERROR.
+const authRequiredErrorCode = 0
+
+// overLimitErrorCode is the ErrorResponse errorCode sent for a request
+// beyond the pump's therapy limits, UNDEFINED_ERROR for want of a dedicated
+// value
+const overLimitErrorCode = 0
+
+// RejectReason says why the router refused a message instead of handling it
+type RejectReason int
+
+// Reject reason constants
+const (
+	RejectNoHandler RejectReason = iota + 1
+	RejectAuthRequired
+	RejectDisabled
+	RejectBusy
+	RejectAuthLockedOut
+	RejectOverLimit
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectNoHandler:
+		return "noHandler"
+	case RejectAuthRequired:
+		return "authRequired"
+	case RejectDisabled:
+		return "disabled"
+	case RejectBusy:
+		return "busy"
+	case RejectAuthLockedOut:
+		return "authLockedOut"
+	case RejectOverLimit:
+		return "overLimit"
+	default:
+		return "unknown"
+	}
+}
+
+// errorCode returns the ErrorResponse errorCode a rejection is NACKed with
+func (r RejectReason) errorCode() int {
+	switch r {
+	case RejectNoHandler, RejectDisabled:
+		return unsupportedCommandErrorCode
+	case RejectAuthRequired, RejectAuthLockedOut:
+		return authRequiredErrorCode
+	case RejectOverLimit:
+		return overLimitErrorCode
+	default:
+		return busyErrorCode
+	}
+}
+
+// RejectionError is returned by RouteMessage for a rejected message
+type RejectionError struct {
+	MessageType string
+	Reason      RejectReason
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("%s rejected: %s", e.MessageType, e.Reason)
+}
+
+// RejectionHandler is called with each message the router rejects
+type RejectionHandler func(messageType string, reason RejectReason)
+
+// disabledHandlers tracks message types whose handlers are switched off
+type disabledHandlers struct {
+	types map[string]bool
+	mtx   sync.RWMutex
+}
+
+func (d *disabledHandlers) set(messageType string, disabled bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.types == nil {
+		d.types = make(map[string]bool)
+	}
+	if disabled {
+		d.types[messageType] = true
+	} else {
+		delete(d.types, messageType)
+	}
+}
+
+func (d *disabledHandlers) disabled(messageType string) bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	return d.types[messageType]
+}
+
+// SetHandlerEnabled turns the handler for messageType off or back on. While
+// off, the message is rejected as if the pump didn't support it.
+func (r *Router) SetHandlerEnabled(messageType string, enabled bool) {
+	r.disabled.set(messageType, !enabled)
+	log.Infof("Handler for %s enabled=%v", messageType, enabled)
+}
+
+// overLimit reports whether msg asks for more than the therapy limits allow:
+// a bolus above the max bolus
+func (r *Router) overLimit(msg *pumpx2.ParsedMessage) bool {
+	if msg.MessageType != "InitiateBolusRequest" {
+		return false
+	}
+	maxBolus := r.pumpState.GetTherapyConfig().MaxBolus
+	return maxBolus > 0 && bolusRequestUnits(msg.Cargo) > maxBolus
+}
+
+// SetRejectionHandler sets the callback told of each rejected message
+func (r *Router) SetRejectionHandler(handler RejectionHandler) {
+	r.onReject = handler
+}
+
+// reject NACKs msg with the ErrorResponse for reason and reports the
+// rejection to the rejection handler
+func (r *Router) reject(charType bluetooth.CharacteristicType, msg *pumpx2.ParsedMessage, reason RejectReason) error {
+	log.Warnf("Rejecting %s (%s): txID=%d", msg.MessageType, reason, msg.TxID)
+	if r.onReject != nil {
+		r.onReject(msg.MessageType, reason)
+	}
+
+	// ErrorResponse(int requestCodeId, ErrorCode errorCode)
+	response, err := r.bridge.EncodeMessage(msg.TxID, "ErrorResponse", map[string]interface{}{
+		"requestCodeId": msg.Opcode,
+		"errorCode":     reason.errorCode(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s ErrorResponse: %w", reason, err)
+	}
+	return r.sendResponse(charType, &Response{ResponseMessage: response, Immediate: true})
+}
+
+// rejectWithError rejects msg, returning a RejectionError unless the NACK
+// couldn't be sent
+func (r *Router) rejectWithError(charType bluetooth.CharacteristicType, msg *pumpx2.ParsedMessage, reason RejectReason) error {
+	if err := r.reject(charType, msg, reason); err != nil {
+		return err
+	}
+	return &RejectionError{MessageType: msg.MessageType, Reason: reason}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReorderMode is the order held responses are released in
+type ReorderMode string
+
+const (
+	// ReorderReverse releases held responses in reverse arrival order
+	ReorderReverse ReorderMode = "reverse"
+
+	// ReorderShuffle releases held responses in a random order other than
+	// their arrival order
+	ReorderShuffle ReorderMode = "shuffle"
+)
+
+// ParseReorderMode parses a reorder mode name
+func ParseReorderMode(mode string) (ReorderMode, error) {
+	switch ReorderMode(mode) {
+	case ReorderReverse, ReorderShuffle:
+		return ReorderMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown reorder mode %q (expected %s or %s)", mode, ReorderReverse, ReorderShuffle)
+}
+
+// heldResponse is a response waiting for its reorder window to close
+type heldResponse struct {
+	messageType string
+	charType    bluetooth.CharacteristicType
+	response    *Response
+}
+
+// responseReorder holds the responses to configured request types for a
+// window, then releases them out of arrival order, to test how a client
+// copes with a pump answering out of order. Unlike latency, it changes only
+// the order: a response arriving after a window closes starts the next one,
+// so ordering between windows is preserved.
+type responseReorder struct {
+	window time.Duration // 0 disables reordering
+	mode   ReorderMode
+	types  map[string]bool
+	rng    *rand.Rand
+	held   []heldResponse
+
+	// after calls f once d has passed; defaults to time.AfterFunc
+	after func(d time.Duration, f func())
+	mtx   sync.Mutex
+}
+
+// holds returns true if responses to messageType are reordered
+func (o *responseReorder) holds(messageType string) bool {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return o.window > 0 && o.types[messageType]
+}
+
+// hold queues a response, opening a window that releases it with send if
+// none is open
+func (o *responseReorder) hold(held heldResponse, send func(heldResponse)) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	o.held = append(o.held, held)
+	log.Debugf("Holding %s response for reordering (%d held)", held.messageType, len(o.held))
+	if len(o.held) > 1 {
+		return
+	}
+	after := o.after
+	if after == nil {
+		after = func(d time.Duration, f func()) { time.AfterFunc(d, f) }
+	}
+	after(o.window, func() { o.release(send) })
+}
+
+// release sends every held response in the configured order
+func (o *responseReorder) release(send func(heldResponse)) {
+	o.mtx.Lock()
+	held := o.held
+	o.held = nil
+	order := o.order(len(held))
+	o.mtx.Unlock()
+
+	for _, i := range order {
+		log.Infof("Releasing %s response %d of %d held (%s order)", held[i].messageType, i+1, len(held), o.mode)
+		send(held[i])
+	}
+}
+
+// order returns the indices of n held responses in release order (must
+// hold mtx). A shuffle never leaves more than one response in arrival order.
+func (o *responseReorder) order(n int) []int {
+	order := make([]int, n)
+	if o.mode == ReorderShuffle && o.rng != nil {
+		order = o.rng.Perm(n)
+		inOrder := true
+		for i, idx := range order {
+			inOrder = inOrder && i == idx
+		}
+		if inOrder && n > 1 {
+			order[0], order[1] = order[1], order[0]
+		}
+		return order
+	}
+	for i := range order {
+		order[i] = n - 1 - i
+	}
+	return order
+}
+
+// SetResponseReorder holds responses to messageTypes for window and then
+// releases them in mode's order, drawing shuffles from rng. A window of 0
+// turns reordering off.
+func (r *Router) SetResponseReorder(window time.Duration, mode ReorderMode, messageTypes []string, rng *rand.Rand) {
+	r.reorder.mtx.Lock()
+	defer r.reorder.mtx.Unlock()
+
+	r.reorder.window = window
+	r.reorder.mode = mode
+	r.reorder.rng = rng
+	r.reorder.types = make(map[string]bool, len(messageTypes))
+	for _, messageType := range messageTypes {
+		r.reorder.types[messageType] = true
+	}
+	if window > 0 {
+		log.Warnf("Reordering responses to %v within %s windows (%s order)", messageTypes, window, mode)
+	}
+}
+
+// sendHeldResponse sends a response released from a reorder window
+func (r *Router) sendHeldResponse(held heldResponse) {
+	if err := r.sendResponse(held.charType, held.response); err != nil {
+		log.Errorf("Failed to send reordered %s response: %v", held.messageType, err)
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/hex"
 	"fmt"
+	"sync"
 
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
 	"github.com/jwoglom/faketandem/pkg/protocol"
@@ -26,8 +27,69 @@ type Router struct {
 	// Qualifying events notifier
 	qeNotifier *QualifyingEventsNotifier
 
+	// events is told of pump state changes made by handlers; defaults to
+	// qeNotifier
+	events state.EventNotifier
+
 	// Default handler for unknown messages
 	defaultHandler MessageHandler
+
+	// History log handler, kept to configure its page size
+	historyLog *HistoryLogHandler
+
+	// API version handler, kept to configure its accepted versions
+	apiVersion *APIVersionHandler
+
+	// notify sends a packet to the central; defaults to ble.Notify
+	notify func(charType bluetooth.CharacteristicType, data []byte) error
+
+	// indicate sends a packet as an indication, returning once the central
+	// confirms it; defaults to ble.NotifyIndicate. indicates reports which
+	// characteristics use it; defaults to ble.UsesIndications.
+	indicate  func(charType bluetooth.CharacteristicType, data []byte) error
+	indicates func(charType bluetooth.CharacteristicType) bool
+
+	// sendMutex keeps each message's packets contiguous when messages are
+	// routed concurrently
+	sendMutex sync.Mutex
+
+	// Observers of RX parse and TX send events
+	observers []protocol.MessageObserver
+
+	// Observers of each TX packet sent
+	packetObservers []protocol.PacketObserver
+
+	// Simulated busy window (see SetBusy)
+	busy busyGate
+
+	// Lockout after repeated failed authentication attempts
+	authLockout *AuthLockout
+
+	// Whether entering low power drops the connection
+	lowPowerDisconnect bool
+	// disconnect drops the central's connection; defaults to
+	// ble.ShutdownConnection
+	disconnect func()
+
+	// Handlers switched off with SetHandlerEnabled
+	disabled disabledHandlers
+
+	// Response txID offsets set with SetTxIDOffset
+	txIDOffsets txIDOffsets
+
+	// Responses held for reordering, set with SetResponseReorder
+	reorder responseReorder
+
+	// State changes applied by handlers
+	stateAudit *StateAuditLog
+
+	// onReject is told of each rejected message, if set
+	onReject RejectionHandler
+
+	// snapshotPending is 1 while a status snapshot waits for authentication
+	snapshotPending int32
+	// notificationTxID is decremented for each unsolicited message's txID
+	notificationTxID int32
 }
 
 // NewRouter creates a new message router
@@ -45,7 +107,14 @@ func NewRouter(bridge *pumpx2.Bridge, pumpState *state.PumpState, ble *bluetooth
 		settingsManager: settingsManager,
 		jpakeManager:    NewJPAKESessionManager(jpakeMode, pumpX2Path, pumpX2Mode, gradleCmd, javaCmd, pumpX2JarPath, pumpState),
 		qeNotifier:      NewQualifyingEventsNotifier(ble, pumpState),
+		stateAudit:      &StateAuditLog{},
+		authLockout:     &AuthLockout{cooldown: DefaultAuthLockoutCooldown},
 	}
+	r.notify = ble.Notify
+	r.indicate = ble.NotifyIndicate
+	r.indicates = ble.UsesIndications
+	r.disconnect = ble.ShutdownConnection
+	r.events = r.qeNotifier
 
 	// Register handlers
 	r.registerHandlers()
@@ -61,7 +130,8 @@ func (r *Router) GetSettingsManager() *settings.Manager {
 // registerHandlers registers all message handlers
 func (r *Router) registerHandlers() {
 	// Core handlers
-	r.RegisterHandler(NewAPIVersionHandler(r.bridge))
+	r.apiVersion = NewAPIVersionHandler(r.bridge)
+	r.RegisterHandler(r.apiVersion)
 	r.RegisterHandler(NewTimeSinceResetHandler(r.bridge))
 
 	// Authentication handlers
@@ -88,7 +158,11 @@ func (r *Router) registerHandlers() {
 	// this name -- the real protocol exposes this data via separate per-topic
 	// messages (InsulinStatusResponse, CurrentBasalStatusResponse,
 	// CurrentBolusStatusResponse, etc), already handled elsewhere.
-	r.RegisterHandler(NewHistoryLogHandler(r.bridge))
+	// Version-dependent field sets don't apply either: pumpX2 versions status
+	// data by message class (CurrentBatteryV1/V2, LastBolusStatusV2), not by
+	// the negotiated ApiVersion, so each class is registered separately.
+	r.historyLog = NewHistoryLogHandler(r.bridge)
+	r.RegisterHandler(r.historyLog)
 	// CreateHistoryLogRequest/Response has no corresponding class anywhere in
 	// pumpX2 -- not part of the real protocol, so no handler is registered.
 	r.RegisterHandler(NewHistoryLogStatusHandler(r.bridge))
@@ -117,14 +191,14 @@ func (r *Router) registerHandlers() {
 	// Dynamic qualifying event status handlers
 	r.RegisterHandler(NewCurrentBasalStatusHandler(r.bridge))
 	r.RegisterHandler(NewCurrentBolusStatusHandler(r.bridge))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CurrentEGVGuiDataRequest", true))
+	r.RegisterHandler(NewCurrentEGVHandler(r.bridge, "CurrentEGVGuiDataRequest"))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "HomeScreenMirrorRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CGMStatusRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "AlertStatusRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "AlarmStatusRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "LoadStatusRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "ProfileStatusRequest", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "LastBolusStatusV2Request", true))
+	r.RegisterHandler(NewLastBolusStatusHandler(r.bridge, "LastBolusStatusV2Request"))
 
 	// Notification/alarm/malfunction handlers
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "HighestAamRequest", true))
@@ -134,8 +208,8 @@ func (r *Router) registerHandlers() {
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CGMAlertStatusRequest", true))
 
 	// ControlIQ info and sleep schedule handlers
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "ControlIQInfoV1Request", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "ControlIQInfoV2Request", true))
+	r.RegisterHandler(NewControlIQInfoHandler(r.bridge, r.settingsManager, "ControlIQInfoV1Request"))
+	r.RegisterHandler(NewControlIQInfoHandler(r.bridge, r.settingsManager, "ControlIQInfoV2Request"))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "ControlIQSleepScheduleRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "BasalIQStatusRequest", true))
 	r.RegisterHandler(NewControlIQIOBHandler(r.bridge, "NonControlIQIOBRequest"))
@@ -144,21 +218,22 @@ func (r *Router) registerHandlers() {
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "ExtendedBolusStatusRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "ExtendedBolusStatusV2Request", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "LastBolusStatusV3Request", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "TempRateRequest", true))
+	r.RegisterHandler(NewTempRateHandler(r.bridge))
 	r.RegisterHandler(NewTempRateStatusHandler(r.bridge))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "LastBGRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "BolusPermissionChangeReasonRequest", true))
 
-	// Global pump settings handlers
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "GlobalMaxBolusSettingsRequest", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "BasalLimitSettingsRequest", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "LocalizationRequest", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "PumpSettingsRequest", true))
+	// Global pump settings handlers, served from PumpState's PumpConfig and
+	// TherapyConfig so they can be changed at runtime
+	r.RegisterHandler(NewGlobalsHandler(r.bridge, "GlobalMaxBolusSettingsRequest"))
+	r.RegisterHandler(NewGlobalsHandler(r.bridge, "BasalLimitSettingsRequest"))
+	r.RegisterHandler(NewGlobalsHandler(r.bridge, "LocalizationRequest"))
+	r.RegisterHandler(NewGlobalsHandler(r.bridge, "PumpSettingsRequest"))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "SendTipsControlGenericTestRequest", true))
 
 	// Pump info handlers
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "PumpFeaturesV2Request", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "PumpVersionRequest", false))
+	r.RegisterHandler(NewIdentityHandler(r.bridge, "PumpVersionRequest"))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "BleSoftwareInfoRequest", false))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CommonSoftwareInfoRequest", false))
 
@@ -183,14 +258,14 @@ func (r *Router) registerHandlers() {
 	r.RegisterHandler(NewCartridgeHandler(r.bridge, "ExitChangeCartridgeModeRequest"))
 	r.RegisterHandler(NewCartridgeHandler(r.bridge, "EnterFillTubingModeRequest"))
 	r.RegisterHandler(NewCartridgeHandler(r.bridge, "ExitFillTubingModeRequest"))
-	r.RegisterHandler(NewCartridgeHandler(r.bridge, "FillCannulaRequest"))
+	r.RegisterHandler(NewPrimeHandler(r.bridge))
 
 	// Simple control handlers (log and return success)
 	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "DismissNotificationRequest"))
 	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "PlaySoundRequest"))
 	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "ChangeTimeDateRequest"))
-	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "DisconnectPumpRequest"))
-	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "UserInteractionRequest"))
+	r.RegisterHandler(NewLowPowerHandler(r.bridge, "DisconnectPumpRequest", true))
+	r.RegisterHandler(NewLowPowerHandler(r.bridge, "UserInteractionRequest", false))
 	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "StreamDataPreflightRequest"))
 	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "ActivateShelfModeRequest"))
 	r.RegisterHandler(NewSimpleControlHandler(r.bridge, "PrimeTubingSuspendRequest"))
@@ -200,8 +275,8 @@ func (r *Router) registerHandlers() {
 	// Settings write handlers (write value, update readback)
 	r.RegisterHandler(NewSetModesHandler(r.bridge))
 	r.RegisterHandler(NewSettingsWriteHandler(r.bridge, r.settingsManager, "ChangeControlIQSettingsRequest", "ControlIQSettingsRequest"))
-	r.RegisterHandler(NewSettingsWriteHandler(r.bridge, r.settingsManager, "SetMaxBolusLimitRequest", "GlobalMaxBolusSettingsRequest"))
-	r.RegisterHandler(NewSettingsWriteHandler(r.bridge, r.settingsManager, "SetMaxBasalLimitRequest", "BasalLimitSettingsRequest"))
+	r.RegisterHandler(NewGlobalsWriteHandler(r.bridge, "SetMaxBolusLimitRequest"))
+	r.RegisterHandler(NewGlobalsWriteHandler(r.bridge, "SetMaxBasalLimitRequest"))
 	// NOTE: SetSleepScheduleResponse has both an (int status) and a (byte[]
 	// raw) single-arg constructor of the same arity; cliparser currently
 	// resolves this to the int ctor via JVM reflection order, but that
@@ -240,11 +315,11 @@ func (r *Router) registerHandlers() {
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CurrentActiveIdpValuesRequest", true))
 
 	// Phase 5: Missing status query variants
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "PumpFeaturesV1Request", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "PumpVersionBRequest", false))
+	r.RegisterHandler(NewGlobalsHandler(r.bridge, "PumpFeaturesV1Request"))
+	r.RegisterHandler(NewIdentityHandler(r.bridge, "PumpVersionBRequest"))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CgmStatusV2Request", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CurrentEgvGuiDataV2Request", true))
-	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "LastBolusStatusRequest", true))
+	r.RegisterHandler(NewCurrentEGVHandler(r.bridge, "CurrentEgvGuiDataV2Request"))
+	r.RegisterHandler(NewLastBolusStatusHandler(r.bridge, "LastBolusStatusRequest"))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CGMHardwareInfoRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CGMGlucoseAlertSettingsRequest", true))
 	r.RegisterHandler(NewGenericSettingsHandler(r.bridge, r.settingsManager, "CGMOORAlertSettingsRequest", true))
@@ -274,6 +349,30 @@ func (r *Router) RegisterHandler(handler MessageHandler) {
 	log.Debugf("Registered handler: %s (auth required: %v)", messageType, handler.RequiresAuth())
 }
 
+// AddMessageObserver registers a callback invoked for every routed (RX) and
+// sent (TX) message
+func (r *Router) AddMessageObserver(observer protocol.MessageObserver) {
+	r.observers = append(r.observers, observer)
+}
+
+// observe reports a message event to all registered observers
+func (r *Router) observe(direction string, charType bluetooth.CharacteristicType, messageType string, txID int) {
+	for _, observer := range r.observers {
+		observer(protocol.MessageEvent{
+			Direction:   direction,
+			CharType:    charType,
+			MessageType: messageType,
+			TxID:        txID,
+		})
+	}
+}
+
+// AddPacketObserver registers a callback invoked for every packet the router
+// sends to the central
+func (r *Router) AddPacketObserver(observer protocol.PacketObserver) {
+	r.packetObservers = append(r.packetObservers, observer)
+}
+
 // SetDefaultHandler sets the default handler for unknown messages
 func (r *Router) SetDefaultHandler(handler MessageHandler) {
 	r.defaultHandler = handler
@@ -282,34 +381,56 @@ func (r *Router) SetDefaultHandler(handler MessageHandler) {
 // RouteMessage routes a message to the appropriate handler
 func (r *Router) RouteMessage(charType bluetooth.CharacteristicType, msg *pumpx2.ParsedMessage) error {
 	log.Debugf("Routing message: type=%s, txID=%d, opcode=%d", msg.MessageType, msg.TxID, msg.Opcode)
+	r.observe("RX", charType, msg.MessageType, msg.TxID)
 
 	// Find handler
 	handler, exists := r.handlers[msg.MessageType]
 	if !exists {
-		if r.defaultHandler != nil {
+		if r.settingsManager.Echoes(msg.MessageType) {
+			handler = NewEchoHandler(msg.MessageType)
+		} else if r.defaultHandler != nil {
 			log.Debugf("No specific handler for %s, using default handler", msg.MessageType)
 			handler = r.defaultHandler
 		} else {
-			log.Warnf("No handler registered for message type: %s", msg.MessageType)
-			return fmt.Errorf("no handler for message type: %s", msg.MessageType)
+			return r.rejectWithError(charType, msg, RejectNoHandler)
 		}
 	}
 
+	if r.disabled.disabled(msg.MessageType) {
+		return r.rejectWithError(charType, msg, RejectDisabled)
+	}
+
 	// Check authentication requirement
 	if handler.RequiresAuth() && !r.pumpState.IsAuthenticated {
-		log.Warnf("Message %s requires authentication but pump is not authenticated", msg.MessageType)
-		// TODO: Send authentication required response
-		return fmt.Errorf("authentication required for %s", msg.MessageType)
+		return r.rejectWithError(charType, msg, RejectAuthRequired)
+	}
+	if authMessageTypes[msg.MessageType] && r.authLockout.lockedOut() {
+		return r.rejectWithError(charType, msg, RejectAuthLockedOut)
+	}
+
+	// A busy pump answers with an ErrorResponse, which isn't an error here
+	if handler.RequiresAuth() && r.IsBusy() {
+		return r.reject(charType, msg, RejectBusy)
+	}
+	if r.overLimit(msg) {
+		return r.rejectWithError(charType, msg, RejectOverLimit)
 	}
 
 	// Handle the message
-	response, err := handler.HandleMessage(msg, r.pumpState)
+	response, err := handler.HandleMessage(r.txIDOffsets.apply(msg), r.pumpState)
 	if err != nil {
 		log.Errorf("Handler error for %s: %v", msg.MessageType, err)
+		if authMessageTypes[msg.MessageType] {
+			r.authLockout.recordFailure()
+		}
 		return fmt.Errorf("handler error: %w", err)
 	}
 
 	// Process response
+	if response != nil && r.reorder.holds(msg.MessageType) {
+		r.reorder.hold(heldResponse{messageType: msg.MessageType, charType: charType, response: response}, r.sendHeldResponse)
+		return nil
+	}
 	if response != nil {
 		if err := r.sendResponse(charType, response); err != nil {
 			log.Errorf("Failed to send response: %v", err)
@@ -320,13 +441,34 @@ func (r *Router) RouteMessage(charType bluetooth.CharacteristicType, msg *pumpx2
 	return nil
 }
 
+// fixedResponseCharacteristics pins responses that must go out on a specific
+// characteristic no matter where the request arrived. History log responses
+// are notify-only on HistoryLog, while their requests arrive on another
+// characteristic, so "same as request" never applies to them.
+var fixedResponseCharacteristics = map[string]bluetooth.CharacteristicType{
+	"HistoryLogResponse":       bluetooth.CharHistoryLog,
+	"HistoryLogStreamResponse": bluetooth.CharHistoryLog,
+}
+
+// responseCharacteristic returns the characteristic to send response on
+func responseCharacteristic(requestCharType bluetooth.CharacteristicType, response *Response) bluetooth.CharacteristicType {
+	if response.ResponseMessage != nil {
+		if charType, ok := fixedResponseCharacteristics[response.ResponseMessage.MessageType]; ok {
+			return charType
+		}
+	}
+	if response.Characteristic == 0 {
+		// Default to same as request
+		return requestCharType
+	}
+	return response.Characteristic
+}
+
 // sendResponse sends a handler response
 func (r *Router) sendResponse(requestCharType bluetooth.CharacteristicType, response *Response) error {
-	// Determine characteristic to use
-	charType := response.Characteristic
-	if charType == 0 {
-		// Default to same as request
-		charType = requestCharType
+	charType := responseCharacteristic(requestCharType, response)
+	if charType != requestCharType && response.ResponseMessage != nil {
+		log.Debugf("Redirecting %s from %s to %s", response.ResponseMessage.MessageType, requestCharType, charType)
 	}
 
 	// Send main response if present
@@ -352,23 +494,56 @@ func (r *Router) sendResponse(requestCharType bluetooth.CharacteristicType, resp
 	return nil
 }
 
+// decodePackets decodes msg's packets, checking each fits in a frame on
+// charType so an oversized packet isn't truncated or dropped by the
+// controller partway through a message
+func decodePackets(charType bluetooth.CharacteristicType, msg *pumpx2.EncodedMessage) ([][]byte, error) {
+	frameSize := protocol.GetChunkSize(charType)
+	packets := make([][]byte, len(msg.Packets))
+	for i, packetHex := range msg.Packets {
+		packetData, err := hex.DecodeString(packetHex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode packet %d: %w", i, err)
+		}
+		if len(packetData) > frameSize {
+			return nil, fmt.Errorf("%s packet %d is %d bytes, exceeding the %d byte frame size of %s",
+				msg.MessageType, i, len(packetData), frameSize, charType)
+		}
+		packets[i] = packetData
+	}
+	return packets, nil
+}
+
 // sendMessage sends an encoded message on a characteristic
 func (r *Router) sendMessage(charType bluetooth.CharacteristicType, msg *pumpx2.EncodedMessage) error {
 	log.Infof("Sending %s on %s: txID=%d, %d packet(s)",
 		msg.MessageType, charType, msg.TxID, len(msg.Packets))
+	packets, err := decodePackets(charType, msg)
+	if err != nil {
+		return err
+	}
+	r.observe("TX", charType, msg.MessageType, msg.TxID)
 
-	for i, packetHex := range msg.Packets {
-		packetData, err := hex.DecodeString(packetHex)
-		if err != nil {
-			return fmt.Errorf("failed to decode packet %d: %w", i, err)
-		}
+	// Indication-based characteristics are paced by the central's
+	// confirmation of each packet before the next is sent
+	send := r.notify
+	if r.indicates(charType) {
+		send = r.indicate
+	}
+
+	r.sendMutex.Lock()
+	defer r.sendMutex.Unlock()
+	for i, packetData := range packets {
+		packetHex := msg.Packets[i]
 
 		protocol.LogPacket("TX", charType, packetData)
 
-		// Send via notification
-		if err := r.ble.Notify(charType, packetData); err != nil {
+		if err := send(charType, packetData); err != nil {
 			return fmt.Errorf("failed to send packet %d: %w", i, err)
 		}
+		for _, observer := range r.packetObservers {
+			observer(protocol.PacketEvent{Direction: "TX", CharType: charType, Data: packetData})
+		}
 
 		log.Tracef("Sent packet %d/%d: %s", i+1, len(msg.Packets), packetHex)
 	}
@@ -378,73 +553,93 @@ func (r *Router) sendMessage(charType bluetooth.CharacteristicType, msg *pumpx2.
 
 // applyStateChange applies a state change
 func (r *Router) applyStateChange(change StateChange) {
-	log.Debugf("Applying state change: type=%d", change.Type)
+	log.Debugf("Applying state change: type=%s", change.Type)
 
+	before := r.stateAuditValue(change.Type)
+	var applied bool
 	switch change.Type {
 	case StateChangeAuth:
-		r.applyAuthChange(change)
+		applied = r.applyAuthChange(change)
 	case StateChangeTime:
 		r.pumpState.UpdateTimeSinceReset()
+		applied = true
 	case StateChangeBolus:
-		r.applyBolusChange(change)
+		applied = r.applyBolusChange(change)
 	case StateChangeBasal:
-		r.applyBasalChange(change)
+		applied = r.applyBasalChange(change)
 	case StateChangeReservoir:
-		if level, ok := change.Data.(float64); ok {
+		var level float64
+		if level, applied = change.Data.(float64); applied {
 			r.pumpState.SetReservoirLevel(level)
 		}
 	case StateChangeBattery:
-		if pct, ok := change.Data.(int); ok {
+		var pct int
+		if pct, applied = change.Data.(int); applied {
 			r.pumpState.SetBatteryLevel(pct)
 		}
 	case StateChangeAlert:
-		r.applyAlertChange(change)
+		applied = r.applyAlertChange(change)
 	case StateChangeSuspend:
-		r.applySuspendChange(change)
+		applied = r.applySuspendChange(change)
+	case StateChangeLowPower:
+		applied = r.applyLowPowerChange(change)
 	default:
 		log.Warnf("Unknown state change type: %d", change.Type)
+		return
+	}
+
+	if !applied {
+		log.Warnf("Ignoring %s state change with unexpected data %T", change.Type, change.Data)
+		return
 	}
+	r.stateAudit.record(change.Type, before, r.stateAuditValue(change.Type))
 }
 
-func (r *Router) applyAuthChange(change StateChange) {
-	if authKey, ok := change.Data.([]byte); ok {
-		r.pumpState.SetAuthenticated(authKey)
-		r.bridge.SetAuthenticationKey(hex.EncodeToString(authKey))
+func (r *Router) applyAuthChange(change StateChange) bool {
+	authKey, ok := change.Data.([]byte)
+	if !ok {
+		return false
 	}
+	r.pumpState.SetAuthenticated(authKey)
+	r.authLockout.ResetFailures()
+	r.bridge.SetAuthenticationKey(hex.EncodeToString(authKey))
+	r.sendPendingStatusSnapshot()
+	return true
 }
 
-func (r *Router) applyBolusChange(change StateChange) {
+func (r *Router) applyBolusChange(change StateChange) bool {
 	bolusState, ok := change.Data.(*state.BolusState)
 	if !ok {
-		return
+		return false
 	}
 	if bolusState.Active {
-		r.pumpState.StartBolus(bolusState.UnitsTotal, bolusState.BolusID)
+		if err := r.pumpState.StartBolus(bolusState.UnitsTotal, bolusState.BolusID); err != nil {
+			log.Warnf("Ignoring bolus: %v", err)
+			return false
+		}
 		r.pumpState.AddHistoryLogEntryWithTypeID(state.HistoryBolusActivated, "BolusActivated", map[string]interface{}{
 			"bolusId": bolusState.BolusID, "units": bolusState.UnitsTotal,
 		})
-		if r.qeNotifier != nil {
-			if err := r.qeNotifier.NotifyBolusStart(bolusState.BolusID, bolusState.UnitsTotal); err != nil {
-				log.Warnf("Failed to notify bolus start: %v", err)
-			}
+		if err := r.events.NotifyBolusStart(bolusState.BolusID, bolusState.UnitsTotal); err != nil {
+			log.Warnf("Failed to notify bolus start: %v", err)
 		}
-		return
+		return true
 	}
-	currentBolus := r.pumpState.Bolus
-	r.pumpState.StopBolus()
-	if r.qeNotifier != nil && currentBolus.Active {
-		if err := r.qeNotifier.NotifyBolusCanceled(
-			currentBolus.BolusID, currentBolus.UnitsDelivered, currentBolus.UnitsTotal,
+	canceled, ok := r.pumpState.CancelBolus(bolusState.BolusID)
+	if ok {
+		if err := r.events.NotifyBolusCanceled(
+			canceled.BolusID, canceled.UnitsDelivered, canceled.UnitsTotal,
 		); err != nil {
 			log.Warnf("Failed to notify bolus canceled: %v", err)
 		}
 	}
+	return true
 }
 
-func (r *Router) applyBasalChange(change StateChange) {
+func (r *Router) applyBasalChange(change StateChange) bool {
 	basalState, ok := change.Data.(*state.BasalState)
 	if !ok {
-		return
+		return false
 	}
 	oldRate := r.pumpState.GetBasalRate()
 	r.pumpState.SetBasalState(basalState)
@@ -454,28 +649,28 @@ func (r *Router) applyBasalChange(change StateChange) {
 			"tempRate": basalState.TempBasalRate, "normalRate": basalState.CurrentRate,
 		})
 	}
-	if r.qeNotifier != nil {
-		if err := r.qeNotifier.NotifyBasalRateChange(oldRate, newRate, basalState.TempBasalActive); err != nil {
-			log.Warnf("Failed to notify basal rate change: %v", err)
-		}
+	if err := r.events.NotifyBasalRateChange(oldRate, newRate, basalState.TempBasalActive); err != nil {
+		log.Warnf("Failed to notify basal rate change: %v", err)
 	}
+	return true
 }
 
-func (r *Router) applyAlertChange(change StateChange) {
-	if alert, ok := change.Data.(state.Alert); ok {
-		r.pumpState.AddAlert(alert)
-		if r.qeNotifier != nil {
-			if err := r.qeNotifier.NotifyAlert(alert); err != nil {
-				log.Warnf("Failed to notify alert: %v", err)
-			}
-		}
+func (r *Router) applyAlertChange(change StateChange) bool {
+	alert, ok := change.Data.(state.Alert)
+	if !ok {
+		return false
 	}
+	alert = r.pumpState.AddAlert(alert)
+	if err := r.events.NotifyAlert(alert); err != nil {
+		log.Warnf("Failed to notify alert: %v", err)
+	}
+	return true
 }
 
-func (r *Router) applySuspendChange(change StateChange) {
+func (r *Router) applySuspendChange(change StateChange) bool {
 	suspended, ok := change.Data.(bool)
 	if !ok {
-		return
+		return false
 	}
 	r.pumpState.SetPumpingSuspended(suspended)
 	if suspended {
@@ -483,18 +678,38 @@ func (r *Router) applySuspendChange(change StateChange) {
 	} else {
 		r.pumpState.AddHistoryLogEntryWithTypeID(state.HistoryPumpingResumed, "PumpingResumed", nil)
 	}
-	if r.qeNotifier == nil {
-		return
-	}
 	if suspended {
-		if err := r.qeNotifier.NotifyPumpSuspended("user"); err != nil {
+		if err := r.events.NotifyPumpSuspended("user"); err != nil {
 			log.Warnf("Failed to notify pump suspended: %v", err)
 		}
 	} else {
-		if err := r.qeNotifier.NotifyPumpResumed(); err != nil {
+		if err := r.events.NotifyPumpResumed(); err != nil {
 			log.Warnf("Failed to notify pump resumed: %v", err)
 		}
 	}
+	return true
+}
+
+// SetHistoryPageSize sets the most history log entries streamed per
+// HistoryLogRequest
+func (r *Router) SetHistoryPageSize(pageSize int) {
+	r.historyLog.SetPageSize(pageSize)
+}
+
+// SetAcceptedAPIVersions sets the API versions a session can be negotiated
+// at; nil accepts any version
+func (r *Router) SetAcceptedAPIVersions(accepted *APIVersionRange) {
+	r.apiVersion.SetAcceptedVersions(accepted)
+}
+
+// SetEventNotifier sets the notifier told of pump state changes made by
+// handlers, e.g. an EventBus that includes the qualifying events notifier.
+// Nil stops notifying them.
+func (r *Router) SetEventNotifier(notifier state.EventNotifier) {
+	if notifier == nil {
+		notifier = &state.NoOpEventNotifier{}
+	}
+	r.events = notifier
 }
 
 // GetQualifyingEventsNotifier returns the qualifying events notifier
@@ -502,6 +717,11 @@ func (r *Router) GetQualifyingEventsNotifier() *QualifyingEventsNotifier {
 	return r.qeNotifier
 }
 
+// GetJPAKESessionManager returns the JPAKE session manager
+func (r *Router) GetJPAKESessionManager() *JPAKESessionManager {
+	return r.jpakeManager
+}
+
 // ResetJPAKESession clears any in-progress JPAKE authenticator. Call this on
 // BLE disconnect so a stale/broken authenticator (e.g. one whose pumpX2
 // subprocess died mid-handshake) is never reused by the next connection.
@@ -509,6 +729,58 @@ func (r *Router) ResetJPAKESession() {
 	r.jpakeManager.RemoveAll()
 }
 
+// ResetJPAKESessionsFor clears the in-progress JPAKE authenticators of the
+// central centralID only, so one central disconnecting doesn't break another's
+// handshake. An empty ID clears every authenticator.
+func (r *Router) ResetJPAKESessionsFor(centralID string) {
+	if centralID == "" {
+		r.ResetJPAKESession()
+		return
+	}
+	r.jpakeManager.RemoveCentral(centralID)
+}
+
+// Close releases resources held by the router, including any jpake-server
+// subprocesses still running for in-progress handshakes
+func (r *Router) Close() {
+	r.jpakeManager.CloseAll()
+}
+
+// SetBasalRate changes the profile basal rate at runtime, validating it
+// against the configured max basal limit, and fires the BASAL_CHANGE event
+func (r *Router) SetBasalRate(rate float64) error {
+	if rate < 0 {
+		return fmt.Errorf("basal rate must not be negative: %.2f", rate)
+	}
+	if maxRate := r.pumpState.GetTherapyConfig().MaxBasalRate; rate > maxRate {
+		return fmt.Errorf("basal rate %.2f U/hr exceeds max basal limit %.2f U/hr", rate, maxRate)
+	}
+
+	oldRate := r.pumpState.GetProfileBasalRate()
+	r.pumpState.SetBasalRate(rate)
+	newRate := r.pumpState.GetProfileBasalRate()
+	r.pumpState.AddHistoryLogEntryWithTypeID(state.HistoryBasalRateChange, "BasalRateChange", map[string]interface{}{
+		"rate": rate, "previousRate": oldRate,
+	})
+	log.Infof("Basal rate set to %.2f U/hr", rate)
+
+	if err := r.events.NotifyBasalRateChange(oldRate, newRate, false); err != nil {
+		log.Warnf("Failed to notify basal rate change: %v", err)
+	}
+	return nil
+}
+
+// SetPumpingSuspended suspends or resumes pumping as SuspendPumpingRequest
+// and ResumePumpingRequest do, recording history and notifying qualifying
+// events. It's a no-op if pumping is already in that state.
+func (r *Router) SetPumpingSuspended(suspended bool) error {
+	if r.pumpState.IsPumpingSuspended() == suspended {
+		return nil
+	}
+	r.applyStateChange(StateChange{Type: StateChangeSuspend, Data: suspended})
+	return nil
+}
+
 // GetStats returns router statistics
 func (r *Router) GetStats() map[string]interface{} {
 	return map[string]interface{}{
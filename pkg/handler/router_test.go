@@ -0,0 +1,914 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+	"github.com/jwoglom/faketandem/pkg/pumpx2/mockrunner"
+	"github.com/jwoglom/faketandem/pkg/settings"
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// fakeRunner is a pumpx2.Runner that encodes every message as a single
+// packet and records the encoded message names and params
+type fakeRunner struct {
+	mutex   sync.Mutex
+	encoded []string
+	params  []map[string]interface{}
+}
+
+func (f *fakeRunner) Parse(btChar string, rawPacketsHex []string) (string, error) {
+	return "", fmt.Errorf("fakeRunner does not parse")
+}
+
+func (f *fakeRunner) Encode(txID int, messageName string, params map[string]interface{}) (string, error) {
+	f.mutex.Lock()
+	f.encoded = append(f.encoded, messageName)
+	f.params = append(f.params, params)
+	f.mutex.Unlock()
+
+	out, err := json.Marshal(map[string]interface{}{
+		"packets": []string{fmt.Sprintf("00%02x00", txID&0xff)},
+	})
+	return string(out), err
+}
+
+// sentPacket is a packet captured by a test router instead of going over BLE
+type sentPacket struct {
+	charType  bluetooth.CharacteristicType
+	data      []byte
+	indicated bool // sent as an indication rather than a notification
+}
+
+// newTestRouter creates a router backed by a fakeRunner whose outgoing
+// packets are captured rather than sent over BLE
+func newTestRouter(t *testing.T) (*Router, *fakeRunner, *[]sentPacket) {
+	t.Helper()
+	runner := &fakeRunner{}
+	r, sent := newCapturingRouter(t, runner)
+	return r, runner, sent
+}
+
+// newCapturingRouter creates a router backed by runner whose outgoing packets
+// are captured rather than sent over BLE
+func newCapturingRouter(t *testing.T, runner pumpx2.Runner) (*Router, *[]sentPacket) {
+	t.Helper()
+	return newBridgeCapturingRouter(t, pumpx2.NewBridgeWithRunner(runner))
+}
+
+// newBridgeCapturingRouter creates a router backed by bridge whose outgoing
+// packets are captured rather than sent over BLE
+func newBridgeCapturingRouter(t *testing.T, bridge *pumpx2.Bridge) (*Router, *[]sentPacket) {
+	t.Helper()
+	pumpState := state.NewPumpState()
+	r := NewRouter(bridge, pumpState, &bluetooth.Ble{}, protocol.NewTransactionManager(0), "go", "", "", "", "", "")
+
+	var sent []sentPacket
+	var mutex sync.Mutex
+	r.notify = func(charType bluetooth.CharacteristicType, data []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sent = append(sent, sentPacket{charType: charType, data: data})
+		return nil
+	}
+	r.indicate = func(charType bluetooth.CharacteristicType, data []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sent = append(sent, sentPacket{charType: charType, data: data, indicated: true})
+		return nil
+	}
+	r.qeNotifier.notify = r.notify
+	return r, &sent
+}
+
+// TestRouterHandshakeSequence verifies the ApiVersion/TimeSinceReset handshake
+// produces the expected RX/TX sequence
+func TestRouterHandshakeSequence(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	recorder := protocol.NewSequenceRecorder()
+	r.AddMessageObserver(recorder.Observe)
+
+	for txID, messageType := range []string{"ApiVersionRequest", "TimeSinceResetRequest"} {
+		msg := &pumpx2.ParsedMessage{MessageType: messageType, TxID: txID}
+		if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+			t.Fatalf("RouteMessage(%s) failed: %v", messageType, err)
+		}
+	}
+
+	recorder.AssertSequence(t, []protocol.SequenceStep{
+		{Direction: "RX", MessageType: "ApiVersionRequest"},
+		{Direction: "TX", MessageType: "ApiVersionResponse"},
+		{Direction: "RX", MessageType: "TimeSinceResetRequest"},
+		{Direction: "TX", MessageType: "TimeSinceResetResponse"},
+	})
+
+	if len(*sent) != 2 {
+		t.Errorf("Expected 2 packets sent, got %d", len(*sent))
+	}
+}
+
+// qualifyingEvents returns the qualifying event bitmasks among sent packets
+func qualifyingEvents(sent []sentPacket) []uint32 {
+	var events []uint32
+	for _, p := range sent {
+		if p.charType == bluetooth.CharQualifyingEvents && len(p.data) == 4 {
+			events = append(events, binary.LittleEndian.Uint32(p.data))
+		}
+	}
+	return events
+}
+
+// TestSequenceRecorderReportsMismatch verifies AssertSequence fails on a differing sequence
+func TestSequenceRecorderReportsMismatch(t *testing.T) {
+	recorder := protocol.NewSequenceRecorder()
+	recorder.Observe(protocol.MessageEvent{Direction: "RX", MessageType: "ApiVersionRequest"})
+
+	fake := &fakeT{}
+	if recorder.AssertSequence(fake, []protocol.SequenceStep{{Direction: "TX", MessageType: "ApiVersionResponse"}}) {
+		t.Error("Expected AssertSequence to return false for mismatched sequence")
+	}
+	if !fake.failed {
+		t.Error("Expected AssertSequence to report an error")
+	}
+}
+
+// fakeT captures AssertSequence failures without failing the enclosing test
+type fakeT struct {
+	failed bool
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.failed = true
+}
+
+// TestRouterSetBasalRate verifies a new basal rate updates state and fires BASAL_CHANGE
+func TestRouterSetBasalRate(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+
+	if err := r.SetBasalRate(1.25); err != nil {
+		t.Fatalf("SetBasalRate failed: %v", err)
+	}
+
+	if got := r.pumpState.GetBasalRate(); got != 1.25 {
+		t.Errorf("Expected basal rate 1.25, got %.2f", got)
+	}
+	events := qualifyingEvents(*sent)
+	if len(events) != 1 || events[0] != QEBasalChange {
+		t.Errorf("Expected one BASAL_CHANGE event, got %v", events)
+	}
+}
+
+// TestRouterSetBasalRateDuringTempBasal verifies the history entry records
+// the previous profile rate, not the temp rate being delivered
+func TestRouterSetBasalRateDuringTempBasal(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+	r.pumpState.SetBasalState(&state.BasalState{CurrentRate: 1.0, TempBasalActive: true, TempBasalRate: 0.5})
+
+	if err := r.SetBasalRate(1.25); err != nil {
+		t.Fatalf("SetBasalRate failed: %v", err)
+	}
+
+	entries := r.pumpState.GetHistoryLogEntries(0, ^uint32(0))
+	if len(entries) != 1 || entries[0].Data["previousRate"] != 1.0 {
+		t.Errorf("Expected previous profile rate 1.0 in history, got %+v", entries)
+	}
+	if got := r.pumpState.GetBasalRate(); got != 0.5 {
+		t.Errorf("Expected the temp rate to keep being delivered, got %.2f", got)
+	}
+}
+
+// TestRouterSetPumpingSuspended verifies suspending through the router
+// records history and sends the qualifying event, once per actual change
+func TestRouterSetPumpingSuspended(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+
+	for _, suspended := range []bool{true, true, false} {
+		if err := r.SetPumpingSuspended(suspended); err != nil {
+			t.Fatalf("SetPumpingSuspended(%v) failed: %v", suspended, err)
+		}
+	}
+
+	var types []int
+	for _, entry := range r.pumpState.GetHistoryLogEntries(0, ^uint32(0)) {
+		types = append(types, entry.TypeID)
+	}
+	if len(types) != 2 || types[0] != state.HistoryPumpingSuspended || types[1] != state.HistoryPumpingResumed {
+		t.Errorf("Expected suspended then resumed history, got %v", types)
+	}
+	if events := qualifyingEvents(*sent); len(events) != 2 {
+		t.Errorf("Expected two qualifying events, got %v", events)
+	}
+}
+
+// TestRouterSetBasalRateRejectsAboveMax verifies rates above the max basal limit are rejected
+func TestRouterSetBasalRateRejectsAboveMax(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	original := r.pumpState.GetBasalRate()
+
+	// Default TherapyConfig allows up to 5.0 U/hr
+	if err := r.SetBasalRate(5.5); err == nil {
+		t.Error("Expected error for rate above max basal limit")
+	}
+	if err := r.SetBasalRate(-1); err == nil {
+		t.Error("Expected error for negative rate")
+	}
+
+	if got := r.pumpState.GetBasalRate(); got != original {
+		t.Errorf("Expected basal rate to stay %.2f, got %.2f", original, got)
+	}
+	if len(qualifyingEvents(*sent)) != 0 {
+		t.Error("Expected no qualifying events for rejected rates")
+	}
+}
+
+// TestRouterHandshakeWithMockRunner routes real-framed ApiVersion and
+// TimeSinceReset requests and parses the responses back off the wire
+func TestRouterHandshakeWithMockRunner(t *testing.T) {
+	r, sent := newCapturingRouter(t, mockrunner.New())
+
+	for txID, messageType := range []string{"ApiVersionRequest", "TimeSinceResetRequest"} {
+		request, err := r.bridge.EncodeMessage(txID, messageType, nil)
+		if err != nil {
+			t.Fatalf("EncodeMessage(%s) failed: %v", messageType, err)
+		}
+		msg, err := r.bridge.ParseMessage(bluetooth.CharCurrentStatus, request.Packets)
+		if err != nil {
+			t.Fatalf("ParseMessage(%s) failed: %v", messageType, err)
+		}
+		if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+			t.Fatalf("RouteMessage(%s) failed: %v", messageType, err)
+		}
+	}
+
+	if len(*sent) != 2 {
+		t.Fatalf("Expected 2 packets sent, got %d", len(*sent))
+	}
+
+	apiVersion := parseSent(t, r, (*sent)[0])
+	if apiVersion.MessageType != "ApiVersionResponse" || apiVersion.TxID != 0 {
+		t.Errorf("Expected ApiVersionResponse txID=0, got %s txID=%d", apiVersion.MessageType, apiVersion.TxID)
+	}
+	if apiVersion.Cargo["majorVersion"] != r.pumpState.GetAPIVersionMajor() ||
+		apiVersion.Cargo["minorVersion"] != r.pumpState.GetAPIVersionMinor() {
+		t.Errorf("Unexpected API version cargo: %v", apiVersion.Cargo)
+	}
+
+	timeSinceReset := parseSent(t, r, (*sent)[1])
+	if timeSinceReset.MessageType != "TimeSinceResetResponse" || timeSinceReset.TxID != 1 {
+		t.Errorf("Expected TimeSinceResetResponse txID=1, got %s txID=%d", timeSinceReset.MessageType, timeSinceReset.TxID)
+	}
+	if timeSinceReset.Cargo["pumpTimeSinceReset"] != int(r.pumpState.GetTimeSinceReset()) {
+		t.Errorf("Unexpected pumpTimeSinceReset: %v", timeSinceReset.Cargo["pumpTimeSinceReset"])
+	}
+}
+
+// parseSent parses a captured single-packet message back through the bridge
+func parseSent(t *testing.T, r *Router, p sentPacket) *pumpx2.ParsedMessage {
+	t.Helper()
+	msg, err := r.bridge.ParseMessage(p.charType, []string{hex.EncodeToString(p.data)})
+	if err != nil {
+		t.Fatalf("ParseMessage of sent packet failed: %v", err)
+	}
+	return msg
+}
+
+// TestRouterHistoryAppendSendsOnlyRealEvents verifies a history entry
+// written by the router reaches the history notifier with its sequence while
+// only the change's own qualifying event goes out over BLE
+func TestRouterHistoryAppendSendsOnlyRealEvents(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	var sequences []interface{}
+	events := state.NewEventBus()
+	events.Subscribe(r.GetQualifyingEventsNotifier())
+	events.Subscribe(state.FieldNotifier(func(fields map[string]interface{}) {
+		if seq, ok := fields["historyLogSequence"]; ok {
+			sequences = append(sequences, seq)
+		}
+	}))
+	r.pumpState.SetHistoryLogNotifier(events)
+
+	if err := r.SetBasalRate(1.0); err != nil {
+		t.Fatalf("SetBasalRate failed: %v", err)
+	}
+
+	if got := qualifyingEvents(*sent); len(got) != 1 || got[0] != QEBasalChange {
+		t.Errorf("Expected only BASAL_CHANGE, got %v", got)
+	}
+	if len(sequences) != 1 {
+		t.Errorf("Expected the history sequence to be notified once, got %v", sequences)
+	}
+}
+
+// TestRouterBusyWindowRefusesRequests verifies authenticated requests get a
+// busy ErrorResponse during the busy window, auth messages are still handled,
+// and normal handling resumes once the window ends
+func TestRouterBusyWindowRefusesRequests(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	now := time.Unix(1700000000, 0)
+	r.busy.now = func() time.Time { return now }
+
+	r.SetBusy(10 * time.Second)
+	if !r.IsBusy() {
+		t.Fatal("Expected router to be busy")
+	}
+
+	request := &pumpx2.ParsedMessage{MessageType: "BasalLimitSettingsRequest", Opcode: 138, TxID: 4}
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, request); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "ErrorResponse" {
+		t.Fatalf("Expected ErrorResponse while busy, got %s", last)
+	}
+	if params := runner.params[len(runner.params)-1]; params["requestCodeId"] != 138 || params["errorCode"] != busyErrorCode {
+		t.Errorf("Unexpected busy ErrorResponse params: %v", params)
+	}
+
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest"}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "ApiVersionResponse" {
+		t.Errorf("Expected unauthenticated request to be handled while busy, got %s", last)
+	}
+
+	now = now.Add(10 * time.Second)
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, request); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "BasalLimitSettingsResponse" {
+		t.Errorf("Expected normal handling after the busy window, got %s", last)
+	}
+}
+
+// TestRouterAuthLockout verifies repeated failed authentication attempts
+// lock out authentication for the cooldown, and that it recovers afterward
+func TestRouterAuthLockout(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	now := time.Unix(1700000000, 0)
+	r.authLockout.now = func() time.Time { return now }
+	r.authLockout.Configure(3, time.Minute)
+
+	// A quick-pair attempt with no cached long-term key always fails
+	attempt := &pumpx2.ParsedMessage{MessageType: "Jpake3SessionKeyRequest", Opcode: 38, TxID: 1}
+	for i := 0; i < 3; i++ {
+		if err := r.RouteMessage(bluetooth.CharAuthorization, attempt); err == nil {
+			t.Fatalf("Expected attempt %d to fail", i+1)
+		}
+	}
+	if status := r.authLockout.Status(); !status.LockedOut || status.LockedUntil == nil || !status.LockedUntil.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected lockout until %v, got %+v", now.Add(time.Minute), status)
+	}
+
+	encoded := len(runner.encoded)
+	err := r.RouteMessage(bluetooth.CharAuthorization, attempt)
+	var rejection *RejectionError
+	if !errors.As(err, &rejection) || rejection.Reason != RejectAuthLockedOut {
+		t.Fatalf("Expected %s RejectionError during lockout, got %v", RejectAuthLockedOut, err)
+	}
+	if len(runner.encoded) != encoded+1 || runner.encoded[encoded] != "ErrorResponse" {
+		t.Fatalf("Expected ErrorResponse during lockout, got %v", runner.encoded[encoded:])
+	}
+	if params := runner.params[len(runner.params)-1]; params["requestCodeId"] != 38 || params["errorCode"] != authRequiredErrorCode {
+		t.Errorf("Unexpected lockout ErrorResponse params: %v", params)
+	}
+
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest"}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "ApiVersionResponse" {
+		t.Errorf("Expected non-auth request to be handled during lockout, got %s", last)
+	}
+
+	now = now.Add(time.Minute)
+	if r.authLockout.Status().LockedOut {
+		t.Fatal("Expected lockout to end after the cooldown")
+	}
+	encoded = len(runner.encoded)
+	if err := r.RouteMessage(bluetooth.CharAuthorization, attempt); err == nil {
+		t.Fatal("Expected the attempt to reach the JPAKE handler after the cooldown")
+	}
+	if len(runner.encoded) != encoded {
+		t.Errorf("Expected no ErrorResponse after the cooldown, got %v", runner.encoded[encoded:])
+	}
+	if failures := r.authLockout.Status().Failures; failures != 1 {
+		t.Errorf("Expected failures to count from zero after a lockout, got %d", failures)
+	}
+}
+
+// TestRouterSendsIndicationsPerCharacteristic verifies packets go out as
+// indications only on the characteristics the BLE device marks for them
+func TestRouterSendsIndicationsPerCharacteristic(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	msg := &pumpx2.EncodedMessage{MessageType: "ApiVersionResponse", Packets: []string{"000100"}}
+
+	if err := r.sendMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+		t.Fatalf("sendMessage failed: %v", err)
+	}
+	r.ble.SetIndicate(bluetooth.CharCurrentStatus, true)
+	if err := r.sendMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+		t.Fatalf("sendMessage failed: %v", err)
+	}
+
+	if len(*sent) != 2 || (*sent)[0].indicated || !(*sent)[1].indicated {
+		t.Errorf("Expected a notification then an indication, got %+v", *sent)
+	}
+}
+
+// TestRouterHistoryLogResponseRedirectedToHistoryLog verifies a
+// HistoryLogRequest arriving on Control is answered by indications on the
+// notify-only HistoryLog characteristic, even if the handler doesn't say so
+func TestRouterHistoryLogResponseRedirectedToHistoryLog(t *testing.T) {
+	r, runner, sent := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+
+	msg := &pumpx2.ParsedMessage{MessageType: "HistoryLogRequest", TxID: 6}
+	if err := r.RouteMessage(bluetooth.CharControl, msg); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+
+	if len(runner.encoded) != 2 || runner.encoded[0] != "HistoryLogResponse" || runner.encoded[1] != "HistoryLogStreamResponse" {
+		t.Fatalf("Expected a HistoryLogResponse and HistoryLogStreamResponse, got %v", runner.encoded)
+	}
+	if len(*sent) == 0 {
+		t.Fatal("Expected the response to be sent")
+	}
+	for i, p := range *sent {
+		if p.charType != bluetooth.CharHistoryLog {
+			t.Errorf("Packet %d: expected HistoryLog, got %s", i, p.charType)
+		}
+		if !p.indicated {
+			t.Errorf("Packet %d: expected an indication", i)
+		}
+	}
+
+	unpinned := &Response{ResponseMessage: &pumpx2.EncodedMessage{MessageType: "HistoryLogResponse"}}
+	if charType := responseCharacteristic(bluetooth.CharControl, unpinned); charType != bluetooth.CharHistoryLog {
+		t.Errorf("Expected HistoryLogResponse without a characteristic to go on HistoryLog, got %s", charType)
+	}
+}
+
+// TestRouterSendMessageRejectsOversizedPacket verifies a packet larger than
+// the Control frame size is refused with a descriptive error before any of
+// the message is sent, and an in-size packet is sent
+func TestRouterSendMessageRejectsOversizedPacket(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	frameSize := protocol.GetChunkSize(bluetooth.CharControl)
+
+	oversized := &pumpx2.EncodedMessage{
+		MessageType: "BolusPermissionResponse",
+		TxID:        3,
+		Packets:     []string{hex.EncodeToString(make([]byte, frameSize)), hex.EncodeToString(make([]byte, frameSize+1))},
+	}
+	err := r.sendMessage(bluetooth.CharControl, oversized)
+	if err == nil {
+		t.Fatal("Expected an oversized packet to be rejected")
+	}
+	expected := fmt.Sprintf("BolusPermissionResponse packet 1 is %d bytes, exceeding the %d byte frame size of Control", frameSize+1, frameSize)
+	if err.Error() != expected {
+		t.Errorf("Expected error %q, got %q", expected, err)
+	}
+	if len(*sent) != 0 {
+		t.Errorf("Expected no packets sent for a rejected message, got %d", len(*sent))
+	}
+
+	inSize := &pumpx2.EncodedMessage{
+		MessageType: "BolusPermissionResponse",
+		TxID:        4,
+		Packets:     []string{hex.EncodeToString(make([]byte, frameSize))},
+	}
+	if err := r.sendMessage(bluetooth.CharControl, inSize); err != nil {
+		t.Fatalf("sendMessage failed: %v", err)
+	}
+	if len(*sent) != 1 || len((*sent)[0].data) != frameSize {
+		t.Errorf("Expected one %d byte packet sent, got %v", frameSize, *sent)
+	}
+}
+
+// TestRouterEchoesPingRequest verifies a message type in echo mode is sent
+// back with its original payload and txID, even before authenticating
+func TestRouterEchoesPingRequest(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	if err := r.GetSettingsManager().SetConfig("PingRequest", &settings.ResponseConfig{Mode: settings.ModeEcho}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+
+	packets := []string{"01090102030405060708090a0b0c0d0e0f10", "00091112"}
+	msg := &pumpx2.ParsedMessage{MessageType: "PingRequest", TxID: 9, RawPacketsHex: packets}
+	if err := r.RouteMessage(bluetooth.CharControl, msg); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+
+	if len(*sent) != len(packets) {
+		t.Fatalf("Expected %d echoed packets, got %d", len(packets), len(*sent))
+	}
+	for i, p := range *sent {
+		if p.charType != bluetooth.CharControl || hex.EncodeToString(p.data) != packets[i] {
+			t.Errorf("Packet %d: expected %s on Control, got %x on %s", i, packets[i], p.data, p.charType)
+		}
+	}
+}
+
+// TestRouterWithoutBridge verifies that without the pumpX2 bridge curated
+// messages and encoded settings responses are still served, while a response
+// that needs cliparser fails with ErrBridgeUnavailable
+func TestRouterWithoutBridge(t *testing.T) {
+	bridge := pumpx2.NewUnavailableBridge(errors.New("cliparser jar not found"), mockrunner.New())
+	r, sent := newBridgeCapturingRouter(t, bridge)
+	r.pumpState.IsAuthenticated = true
+
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", TxID: 1}); err != nil {
+		t.Fatalf("RouteMessage(ApiVersionRequest) failed: %v", err)
+	}
+	if len(*sent) == 0 || parseSent(t, r, (*sent)[0]).MessageType != "ApiVersionResponse" {
+		t.Fatalf("Expected an ApiVersionResponse from the built-in decoder, got %d packets", len(*sent))
+	}
+
+	packets := []string{"0002560200aabbccdd"}
+	if err := r.GetSettingsManager().SetConfig("PumpGlobalsRequest", &settings.ResponseConfig{Mode: settings.ModeEncoded, Packets: packets}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	*sent = nil
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "PumpGlobalsRequest", TxID: 2}); err != nil {
+		t.Fatalf("RouteMessage(PumpGlobalsRequest) failed: %v", err)
+	}
+	if len(*sent) != 1 || hex.EncodeToString((*sent)[0].data) != packets[0] {
+		t.Errorf("Expected the encoded packets sent verbatim, got %v", *sent)
+	}
+
+	err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "CurrentBatteryV2Request", TxID: 3})
+	if !errors.Is(err, pumpx2.ErrBridgeUnavailable) {
+		t.Errorf("Expected ErrBridgeUnavailable, got %v", err)
+	}
+}
+
+// TestRouterUnknownMessageGetsUnsupportedCommandNack verifies a message no
+// response can be built for is answered with an ErrorResponse naming its
+// opcode and txID rather than silence
+func TestRouterUnknownMessageGetsUnsupportedCommandNack(t *testing.T) {
+	r, sent := newCapturingRouter(t, mockrunner.New())
+
+	msg := &pumpx2.ParsedMessage{MessageType: "UnknownThingRequest", TxID: 9, Opcode: 100}
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+
+	if len(*sent) != 1 {
+		t.Fatalf("Expected 1 packet sent, got %d", len(*sent))
+	}
+	nack := parseSent(t, r, (*sent)[0])
+	if nack.MessageType != "ErrorResponse" || nack.TxID != 9 {
+		t.Fatalf("Expected ErrorResponse txID=9, got %s txID=%d", nack.MessageType, nack.TxID)
+	}
+	if nack.Cargo["requestCodeId"] != 100 || nack.Cargo["errorCode"] != unsupportedCommandErrorCode {
+		t.Errorf("Unexpected ErrorResponse cargo: %v", nack.Cargo)
+	}
+}
+
+// blockingRunner is a fakeRunner whose encodes of one message block until
+// released
+type blockingRunner struct {
+	fakeRunner
+	blockMessage string
+	release      chan struct{}
+}
+
+func (b *blockingRunner) Encode(txID int, messageName string, params map[string]interface{}) (string, error) {
+	if messageName == b.blockMessage {
+		<-b.release
+	}
+	return b.fakeRunner.Encode(txID, messageName, params)
+}
+
+// TestRouterDispatchedTransactionsNotSerialized verifies a transaction whose
+// response is slow to encode doesn't hold up another transaction's response
+func TestRouterDispatchedTransactionsNotSerialized(t *testing.T) {
+	runner := &blockingRunner{blockMessage: "ApiVersionResponse", release: make(chan struct{})}
+	r, _ := newCapturingRouter(t, runner)
+	responded := make(chan string, 2)
+	r.AddMessageObserver(func(event protocol.MessageEvent) {
+		if event.Direction == "TX" {
+			responded <- event.MessageType
+		}
+	})
+
+	d := protocol.NewDispatcher(2)
+	for txID, messageType := range map[uint8]string{1: "ApiVersionRequest", 2: "TimeSinceResetRequest"} {
+		msg := &pumpx2.ParsedMessage{MessageType: messageType, TxID: int(txID)}
+		d.Dispatch(bluetooth.CharCurrentStatus, txID, func() {
+			if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+				t.Errorf("RouteMessage(%s) failed: %v", msg.MessageType, err)
+			}
+		})
+	}
+
+	select {
+	case messageType := <-responded:
+		if messageType != "TimeSinceResetResponse" {
+			t.Errorf("Expected TimeSinceResetResponse while ApiVersion is blocked, got %s", messageType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TimeSinceResetResponse was serialized behind the blocked ApiVersionResponse")
+	}
+	close(runner.release)
+	d.Wait()
+	if messageType := <-responded; messageType != "ApiVersionResponse" {
+		t.Errorf("Expected ApiVersionResponse once released, got %s", messageType)
+	}
+}
+
+// routeRejected routes msg, expecting it to be rejected for reason with a
+// NACK carrying errorCode and a report to the rejection handler
+func routeRejected(t *testing.T, r *Router, runner *fakeRunner, msg *pumpx2.ParsedMessage, reason RejectReason, errorCode int) {
+	t.Helper()
+	var reported []RejectReason
+	r.SetRejectionHandler(func(messageType string, got RejectReason) {
+		if messageType != msg.MessageType {
+			t.Errorf("Expected rejection of %s, got %s", msg.MessageType, messageType)
+		}
+		reported = append(reported, got)
+	})
+
+	err := r.RouteMessage(bluetooth.CharCurrentStatus, msg)
+	var rejection *RejectionError
+	if !errors.As(err, &rejection) || rejection.Reason != reason {
+		t.Fatalf("Expected %s RejectionError, got %v", reason, err)
+	}
+	if len(reported) != 1 || reported[0] != reason {
+		t.Errorf("Expected rejection handler to get %s, got %v", reason, reported)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "ErrorResponse" {
+		t.Fatalf("Expected an ErrorResponse NACK, got %s", last)
+	}
+	if params := runner.params[len(runner.params)-1]; params["requestCodeId"] != msg.Opcode || params["errorCode"] != errorCode {
+		t.Errorf("Unexpected %s ErrorResponse params: %v", reason, params)
+	}
+}
+
+// TestRouterRejectsUnauthenticatedRequest verifies a request needing auth is
+// rejected with the authRequired reason before pairing
+func TestRouterRejectsUnauthenticatedRequest(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+
+	routeRejected(t, r, runner, &pumpx2.ParsedMessage{MessageType: "BasalLimitSettingsRequest", Opcode: 138, TxID: 2},
+		RejectAuthRequired, authRequiredErrorCode)
+}
+
+// TestRouterRejectsBolusOverLimit verifies a bolus above the max bolus is
+// rejected with the overLimit reason, while one within it is delivered
+func TestRouterRejectsBolusOverLimit(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.pumpState.SetAuthenticated([]byte("key"))
+	therapy := r.pumpState.GetTherapyConfig()
+	therapy.MaxBolus = 10
+	if err := r.pumpState.SetTherapyConfig(therapy); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+
+	routeRejected(t, r, runner, &pumpx2.ParsedMessage{
+		MessageType: "InitiateBolusRequest", Opcode: 158, TxID: 4,
+		Cargo: map[string]interface{}{"totalVolume": 12000, "bolusID": 1},
+	}, RejectOverLimit, overLimitErrorCode)
+	if boluses := r.pumpState.GetActiveBoluses(); len(boluses) != 0 {
+		t.Errorf("Expected no bolus started for a rejected request, got %+v", boluses)
+	}
+
+	if err := r.RouteMessage(bluetooth.CharControl, &pumpx2.ParsedMessage{
+		MessageType: "InitiateBolusRequest", Opcode: 158, TxID: 5,
+		Cargo: map[string]interface{}{"totalVolume": 10000, "bolusID": 2},
+	}); err != nil {
+		t.Fatalf("Expected a bolus at the max bolus to be accepted, got %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "InitiateBolusResponse" {
+		t.Errorf("Expected InitiateBolusResponse, got %s", last)
+	}
+}
+
+// TestRouterRejectsDisabledHandler verifies a disabled handler's message is
+// rejected with the disabled reason until it's enabled again
+func TestRouterRejectsDisabledHandler(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	r.SetHandlerEnabled("ApiVersionRequest", false)
+
+	msg := &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", Opcode: 32, TxID: 3}
+	routeRejected(t, r, runner, msg, RejectDisabled, unsupportedCommandErrorCode)
+
+	r.SetHandlerEnabled("ApiVersionRequest", true)
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+		t.Fatalf("RouteMessage failed after re-enabling: %v", err)
+	}
+	if last := runner.encoded[len(runner.encoded)-1]; last != "ApiVersionResponse" {
+		t.Errorf("Expected ApiVersionResponse once re-enabled, got %s", last)
+	}
+}
+
+// TestRouterTxIDOffset verifies a configured offset shifts the response
+// txID, wrapping within a byte, while other messages echo it exactly
+func TestRouterTxIDOffset(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	r.SetTxIDOffset("ApiVersionRequest", 3)
+
+	for _, tc := range []struct {
+		messageType string
+		txID        int
+		expected    byte
+	}{
+		{"ApiVersionRequest", 10, 13},
+		{"ApiVersionRequest", 254, 1},
+		{"TimeSinceResetRequest", 10, 10},
+	} {
+		*sent = nil
+		msg := &pumpx2.ParsedMessage{MessageType: tc.messageType, TxID: tc.txID}
+		if err := r.RouteMessage(bluetooth.CharCurrentStatus, msg); err != nil {
+			t.Fatalf("RouteMessage(%s) failed: %v", tc.messageType, err)
+		}
+		if len(*sent) != 1 || (*sent)[0].data[1] != tc.expected {
+			t.Errorf("%s txID=%d: expected response txID %d, got %v", tc.messageType, tc.txID, tc.expected, *sent)
+		}
+		if msg.TxID != tc.txID {
+			t.Errorf("Expected the request's own txID to be left alone, got %d", msg.TxID)
+		}
+	}
+
+	r.SetTxIDOffset("ApiVersionRequest", 0)
+	*sent = nil
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", TxID: 10}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if len(*sent) != 1 || (*sent)[0].data[1] != 10 {
+		t.Errorf("Expected the txID echoed once the offset is cleared, got %v", *sent)
+	}
+}
+
+// TestStatusSnapshotWaitsForAuthentication verifies the subscription status
+// snapshot is pushed on CurrentStatus, and is held until authentication when
+// requested before it
+func TestStatusSnapshotWaitsForAuthentication(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+	recorder := protocol.NewSequenceRecorder()
+	r.AddMessageObserver(recorder.Observe)
+
+	if err := r.SendStatusSnapshot(); err != nil {
+		t.Fatalf("SendStatusSnapshot failed: %v", err)
+	}
+	if len(*sent) != 0 {
+		t.Fatalf("Expected no status before authentication, got %d packets", len(*sent))
+	}
+
+	r.applyStateChange(StateChange{Type: StateChangeAuth, Data: []byte{0x01, 0x02}})
+
+	recorder.AssertSequence(t, []protocol.SequenceStep{
+		{Direction: "TX", MessageType: "CurrentBasalStatusResponse"},
+		{Direction: "TX", MessageType: "CurrentBolusStatusResponse"},
+		{Direction: "TX", MessageType: "InsulinStatusResponse"},
+		{Direction: "TX", MessageType: "CurrentBatteryV2Response"},
+	})
+	for _, p := range *sent {
+		if p.charType != bluetooth.CharCurrentStatus {
+			t.Errorf("Expected status snapshot on CurrentStatus, got %s", p.charType)
+		}
+	}
+	if txIDs := sentTxIDs(*sent); len(txIDs) == 0 || txIDs[0] != 255 {
+		t.Errorf("Expected the snapshot sent with notification txIDs from 255, got %v", txIDs)
+	}
+
+	recorder.Reset()
+	r.applyStateChange(StateChange{Type: StateChangeAuth, Data: []byte{0x01, 0x02}})
+	if steps := recorder.Steps(); len(steps) != 0 {
+		t.Errorf("Expected the deferred snapshot to be sent only once, got %v", steps)
+	}
+}
+
+// TestStatusSnapshotCancelledOnDisconnect verifies a snapshot deferred for a
+// client that disconnects isn't pushed to the next client to authenticate
+func TestStatusSnapshotCancelledOnDisconnect(t *testing.T) {
+	r, _, sent := newTestRouter(t)
+
+	if err := r.SendStatusSnapshot(); err != nil {
+		t.Fatalf("SendStatusSnapshot failed: %v", err)
+	}
+	r.CancelStatusSnapshot()
+	r.applyStateChange(StateChange{Type: StateChangeAuth, Data: []byte{0x01, 0x02}})
+
+	if len(*sent) != 0 {
+		t.Errorf("Expected no snapshot after the requesting client disconnected, got %d packets", len(*sent))
+	}
+}
+
+// reorderTestRouter returns a router reordering ApiVersionRequest responses
+// in mode, whose reorder windows close when the returned func is called
+func reorderTestRouter(t *testing.T, mode ReorderMode, seed int64) (*Router, *[]sentPacket, func()) {
+	t.Helper()
+	r, _, sent := newTestRouter(t)
+	r.SetResponseReorder(50*time.Millisecond, mode, []string{"ApiVersionRequest"}, state.NewRand(seed))
+	var closeWindow func()
+	r.reorder.after = func(d time.Duration, f func()) { closeWindow = f }
+	return r, sent, func() {
+		if closeWindow == nil {
+			t.Fatal("Expected a reorder window to be open")
+		}
+		closeWindow()
+		closeWindow = nil
+	}
+}
+
+// sentTxIDs returns the txIDs of the sent response packets
+func sentTxIDs(sent []sentPacket) []byte {
+	txIDs := make([]byte, len(sent))
+	for i, packet := range sent {
+		txIDs[i] = packet.data[1]
+	}
+	return txIDs
+}
+
+// TestRouterReordersResponsesWithinWindow verifies responses held in one
+// window are released in reverse, while responses in successive windows keep
+// their arrival order
+func TestRouterReordersResponsesWithinWindow(t *testing.T) {
+	r, sent, closeWindow := reorderTestRouter(t, ReorderReverse, 1)
+	route := func(txID int) {
+		if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", TxID: txID}); err != nil {
+			t.Fatalf("RouteMessage failed: %v", err)
+		}
+	}
+
+	route(1)
+	route(2)
+	if len(*sent) != 0 {
+		t.Fatalf("Expected responses to be held until the window closes, got %v", *sent)
+	}
+	closeWindow()
+	if got := sentTxIDs(*sent); string(got) != string([]byte{2, 1}) {
+		t.Errorf("Expected responses in reverse order [2 1], got %v", got)
+	}
+
+	*sent = nil
+	route(3)
+	closeWindow()
+	route(4)
+	closeWindow()
+	if got := sentTxIDs(*sent); string(got) != string([]byte{3, 4}) {
+		t.Errorf("Expected responses in separate windows in arrival order [3 4], got %v", got)
+	}
+
+	// Other message types are answered immediately
+	*sent = nil
+	if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "TimeSinceResetRequest", TxID: 5}); err != nil {
+		t.Fatalf("RouteMessage failed: %v", err)
+	}
+	if len(*sent) != 1 {
+		t.Errorf("Expected an unconfigured type to be sent immediately, got %v", *sent)
+	}
+}
+
+// TestRouterShufflesResponsesFromSeed verifies a shuffled window releases its
+// responses out of arrival order, in the same order for the same seed
+func TestRouterShufflesResponsesFromSeed(t *testing.T) {
+	shuffled := func(seed int64) []byte {
+		r, sent, closeWindow := reorderTestRouter(t, ReorderShuffle, seed)
+		for txID := 1; txID <= 4; txID++ {
+			if err := r.RouteMessage(bluetooth.CharCurrentStatus, &pumpx2.ParsedMessage{MessageType: "ApiVersionRequest", TxID: txID}); err != nil {
+				t.Fatalf("RouteMessage failed: %v", err)
+			}
+		}
+		closeWindow()
+		return sentTxIDs(*sent)
+	}
+
+	first, second := shuffled(42), shuffled(42)
+	if len(first) != 4 || string(first) == string([]byte{1, 2, 3, 4}) {
+		t.Errorf("Expected all 4 responses out of arrival order, got %v", first)
+	}
+	if string(first) != string(second) {
+		t.Errorf("Expected the same seed to shuffle the same way, got %v and %v", first, second)
+	}
+}
+
+// TestRouterEventsWithoutQualifyingEventsNotifier verifies state changes
+// reach the event notifier even when the router has no qualifying events
+// notifier, so websocket clients don't depend on BLE
+func TestRouterEventsWithoutQualifyingEventsNotifier(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+	r.qeNotifier = nil
+	var fields []map[string]interface{}
+	r.SetEventNotifier(state.FieldNotifier(func(f map[string]interface{}) {
+		fields = append(fields, f)
+	}))
+
+	r.applyStateChange(StateChange{Type: StateChangeSuspend, Data: true})
+	r.applyStateChange(StateChange{Type: StateChangeAlert, Data: state.Alert{Type: state.AlertLowReservoir}})
+
+	if len(fields) != 2 {
+		t.Errorf("Expected suspend and alert events, got %v", fields)
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// maxStateAuditEntries bounds the state change audit log
+const maxStateAuditEntries = 200
+
+// StateAuditEntry records one state change a handler applied, with the
+// affected pump state before and after it
+type StateAuditEntry struct {
+	Time   time.Time   `json:"time"`
+	Type   string      `json:"type"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// StateAuditLog keeps the most recent state changes applied by handlers
+type StateAuditLog struct {
+	entries []StateAuditEntry
+	now     func() time.Time
+	mtx     sync.Mutex
+}
+
+func (l *StateAuditLog) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// record adds an entry for a change of changeType from before to after
+func (l *StateAuditLog) record(changeType StateChangeType, before, after interface{}) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.entries = append(l.entries, StateAuditEntry{
+		Time:   l.clock(),
+		Type:   changeType.String(),
+		Before: before,
+		After:  after,
+	})
+	if len(l.entries) > maxStateAuditEntries {
+		l.entries = l.entries[len(l.entries)-maxStateAuditEntries:]
+	}
+}
+
+// Entries returns the most recent state changes, oldest first
+func (l *StateAuditLog) Entries() []StateAuditEntry {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	entries := make([]StateAuditEntry, len(l.entries))
+	copy(entries, l.entries)
+	return entries
+}
+
+// GetStateAuditLog returns the log of state changes applied by handlers
+func (r *Router) GetStateAuditLog() *StateAuditLog {
+	return r.stateAudit
+}
+
+// stateAuditValue returns the part of the pump state changeType affects, for
+// the audit log. The auth key itself is never recorded.
+func (r *Router) stateAuditValue(changeType StateChangeType) interface{} {
+	ps := r.pumpState
+	switch changeType {
+	case StateChangeAuth:
+		ps.RLock()
+		defer ps.RUnlock()
+		return map[string]interface{}{"authenticated": ps.IsAuthenticated}
+	case StateChangeBasal:
+		ps.RLock()
+		tempActive := ps.Basal.TempBasalActive
+		ps.RUnlock()
+		return map[string]interface{}{"rate": ps.GetBasalRate(), "tempBasalActive": tempActive}
+	case StateChangeBolus:
+		ps.RLock()
+		defer ps.RUnlock()
+		stacked := make([]uint32, len(ps.BolusQueue))
+		for i, bolus := range ps.BolusQueue {
+			stacked[i] = bolus.BolusID
+		}
+		return map[string]interface{}{
+			"active":         ps.Bolus.Active,
+			"bolusId":        ps.Bolus.BolusID,
+			"unitsTotal":     ps.Bolus.UnitsTotal,
+			"unitsDelivered": ps.Bolus.UnitsDelivered,
+			"stackedIds":     stacked,
+		}
+	case StateChangeReservoir:
+		return map[string]interface{}{"units": ps.GetReservoirLevel()}
+	case StateChangeBattery:
+		return map[string]interface{}{"percent": ps.GetBatteryLevel()}
+	case StateChangeAlert:
+		ps.RLock()
+		defer ps.RUnlock()
+		ids := make([]uint32, len(ps.ActiveAlerts))
+		for i, alert := range ps.ActiveAlerts {
+			ids[i] = alert.ID
+		}
+		return map[string]interface{}{"activeAlertIds": ids}
+	case StateChangeTime:
+		return map[string]interface{}{"timeSinceReset": ps.GetTimeSinceReset()}
+	case StateChangeSuspend:
+		return map[string]interface{}{"suspended": ps.IsPumpingSuspended()}
+	case StateChangeLowPower:
+		return map[string]interface{}{"lowPower": ps.IsLowPower()}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/state"
+)
+
+// TestApplyStateChangeRecordsAudit verifies each state change type is applied
+// to the pump state and audited with the state before and after
+func TestApplyStateChangeRecordsAudit(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+	r.stateAudit.now = func() time.Time { return time.Unix(1000, 0) }
+	ps := r.pumpState
+	ps.SetReservoirLevel(200)
+	ps.SetBatteryLevel(80)
+	ps.SetBasalRate(0.8)
+	ps.StartTime = time.Now().Add(-100 * time.Second)
+	ps.TimeSinceReset = 0
+
+	cases := []struct {
+		change StateChange
+		before interface{}
+		after  interface{}
+	}{
+		{
+			StateChange{Type: StateChangeAuth, Data: []byte{0x01, 0x02}},
+			map[string]interface{}{"authenticated": false},
+			map[string]interface{}{"authenticated": true},
+		},
+		{
+			StateChange{Type: StateChangeBasal, Data: &state.BasalState{CurrentRate: 0.8, TempBasalActive: true, TempBasalRate: 1.2}},
+			map[string]interface{}{"rate": 0.8, "tempBasalActive": false},
+			map[string]interface{}{"rate": 1.2, "tempBasalActive": true},
+		},
+		{
+			StateChange{Type: StateChangeBolus, Data: &state.BolusState{Active: true, UnitsTotal: 2.5, BolusID: 7}},
+			map[string]interface{}{"active": false, "bolusId": uint32(0), "unitsTotal": 0.0, "unitsDelivered": 0.0, "stackedIds": []uint32{}},
+			map[string]interface{}{"active": true, "bolusId": uint32(7), "unitsTotal": 2.5, "unitsDelivered": 0.0, "stackedIds": []uint32{}},
+		},
+		{
+			StateChange{Type: StateChangeReservoir, Data: 150.5},
+			map[string]interface{}{"units": 200.0},
+			map[string]interface{}{"units": 150.5},
+		},
+		{
+			StateChange{Type: StateChangeBattery, Data: 42},
+			map[string]interface{}{"percent": 80},
+			map[string]interface{}{"percent": 42},
+		},
+		{
+			StateChange{Type: StateChangeAlert, Data: state.Alert{Type: state.AlertLowReservoir}},
+			map[string]interface{}{"activeAlertIds": []uint32{}},
+			map[string]interface{}{"activeAlertIds": []uint32{1}},
+		},
+		{
+			StateChange{Type: StateChangeTime},
+			map[string]interface{}{"timeSinceReset": uint32(0)},
+			map[string]interface{}{"timeSinceReset": uint32(100)},
+		},
+		{
+			StateChange{Type: StateChangeSuspend, Data: true},
+			map[string]interface{}{"suspended": false},
+			map[string]interface{}{"suspended": true},
+		},
+	}
+
+	for _, c := range cases {
+		r.applyStateChange(c.change)
+	}
+
+	entries := r.GetStateAuditLog().Entries()
+	if len(entries) != len(cases) {
+		t.Fatalf("Expected %d audit entries, got %d: %+v", len(cases), len(entries), entries)
+	}
+	for i, c := range cases {
+		entry := entries[i]
+		if entry.Type != c.change.Type.String() || !entry.Time.Equal(time.Unix(1000, 0)) {
+			t.Errorf("Entry %d: expected %s at the fixed clock, got %s at %s", i, c.change.Type, entry.Type, entry.Time)
+		}
+		if !reflect.DeepEqual(entry.Before, c.before) {
+			t.Errorf("%s before: expected %v, got %v", c.change.Type, c.before, entry.Before)
+		}
+		if !reflect.DeepEqual(entry.After, c.after) {
+			t.Errorf("%s after: expected %v, got %v", c.change.Type, c.after, entry.After)
+		}
+	}
+}
+
+// TestApplyStateChangeIgnoresBadData verifies a change whose data has the
+// wrong type leaves the state alone and isn't audited
+func TestApplyStateChangeIgnoresBadData(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+	r.pumpState.SetBatteryLevel(80)
+
+	r.applyStateChange(StateChange{Type: StateChangeBattery, Data: "42"})
+
+	if level := r.pumpState.GetBatteryLevel(); level != 80 {
+		t.Errorf("Expected battery to stay at 80, got %d", level)
+	}
+	if entries := r.GetStateAuditLog().Entries(); len(entries) != 0 {
+		t.Errorf("Expected no audit entries, got %+v", entries)
+	}
+}
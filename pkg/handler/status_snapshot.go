@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// statusSnapshotRequests are the status requests whose responses make up the
+// snapshot pushed when a client subscribes to CurrentStatus. pumpX2 has no
+// single status message, so the snapshot is the per-topic responses a client
+// polls first after connecting.
+var statusSnapshotRequests = []string{
+	"CurrentBasalStatusRequest",
+	"CurrentBolusStatusRequest",
+	"InsulinStatusRequest",
+	"CurrentBatteryV2Request",
+}
+
+// SendStatusSnapshot pushes the current status on CurrentStatus without a
+// request, as a real pump does when a client subscribes to it. Status is
+// only sent to an authenticated client, so before authentication the
+// snapshot is held until authentication completes. The snapshot is sent as
+// notifications with txIDs from nextNotificationTxID, so a client doesn't
+// take it for the response to one of its own requests.
+func (r *Router) SendStatusSnapshot() error {
+	if !r.pumpState.IsAuthenticated {
+		log.Debugf("Deferring status snapshot until authenticated")
+		atomic.StoreInt32(&r.snapshotPending, 1)
+		return nil
+	}
+	atomic.StoreInt32(&r.snapshotPending, 0)
+
+	for _, messageType := range statusSnapshotRequests {
+		handler, exists := r.handlers[messageType]
+		if !exists || r.disabled.disabled(messageType) {
+			continue
+		}
+		msg := &pumpx2.ParsedMessage{MessageType: messageType, TxID: r.nextNotificationTxID()}
+		response, err := handler.HandleMessage(msg, r.pumpState)
+		if err != nil {
+			return fmt.Errorf("failed to build %s for status snapshot: %w", messageType, err)
+		}
+		if response == nil || response.ResponseMessage == nil {
+			continue
+		}
+		if err := r.sendMessage(bluetooth.CharCurrentStatus, response.ResponseMessage); err != nil {
+			return fmt.Errorf("failed to send status snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendPendingStatusSnapshot sends a snapshot deferred by SendStatusSnapshot
+func (r *Router) sendPendingStatusSnapshot() {
+	if !atomic.CompareAndSwapInt32(&r.snapshotPending, 1, 0) {
+		return
+	}
+	if err := r.SendStatusSnapshot(); err != nil {
+		log.Warnf("Failed to send deferred status snapshot: %v", err)
+	}
+}
+
+// CancelStatusSnapshot drops a snapshot deferred by SendStatusSnapshot. Call
+// this on BLE disconnect so a snapshot requested by a departed client isn't
+// pushed to the next client to authenticate.
+func (r *Router) CancelStatusSnapshot() {
+	atomic.StoreInt32(&r.snapshotPending, 0)
+}
+
+// nextNotificationTxID returns the txID for the next unsolicited message.
+// They count down from 255 while clients count their requests up from 0, so
+// the two don't collide on a fresh connection.
+func (r *Router) nextNotificationTxID() int {
+	return int(atomic.AddInt32(&r.notificationTxID, -1) & 0xff)
+}
@@ -13,12 +13,14 @@ import (
 // TimeSinceResetHandler handles TimeSinceResetRequest messages
 type TimeSinceResetHandler struct {
 	bridge *pumpx2.Bridge
+	now    func() time.Time
 }
 
 // NewTimeSinceResetHandler creates a new time since reset handler
 func NewTimeSinceResetHandler(bridge *pumpx2.Bridge) *TimeSinceResetHandler {
 	return &TimeSinceResetHandler{
 		bridge: bridge,
+		now:    time.Now,
 	}
 }
 
@@ -37,7 +39,8 @@ func (h *TimeSinceResetHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpSta
 	log.Infof("Handling TimeSinceResetRequest: txID=%d", msg.TxID)
 
 	// Update the time since reset
-	pumpState.UpdateTimeSinceReset()
+	now := h.now()
+	pumpState.UpdateTimeSinceResetAt(now)
 	timeSinceReset := pumpState.GetTimeSinceReset()
 
 	log.Debugf("Responding with time since reset: %d seconds", timeSinceReset)
@@ -51,7 +54,7 @@ func (h *TimeSinceResetHandler) HandleMessage(msg *pumpx2.ParsedMessage, pumpSta
 		msg.TxID,
 		"TimeSinceResetResponse",
 		map[string]interface{}{
-			"currentTime":        time.Now().Unix(),
+			"currentTime":        pumpState.PumpTime(now).Unix(),
 			"pumpTimeSinceReset": timeSinceReset,
 		},
 	)
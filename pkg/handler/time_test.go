@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+)
+
+// TestTimeSinceResetReportsDriftedCurrentTime verifies the reported current
+// time includes the pump's clock drift
+func TestTimeSinceResetReportsDriftedCurrentTime(t *testing.T) {
+	r, runner, _ := newTestRouter(t)
+	start := time.Unix(1700000000, 0)
+	r.pumpState.StartTime = start
+	r.pumpState.SetClockDrift(60)
+
+	h := r.handlers["TimeSinceResetRequest"].(*TimeSinceResetHandler)
+	h.now = func() time.Time { return start.Add(10 * time.Hour) }
+
+	params := routeGlobals(t, r, runner, &pumpx2.ParsedMessage{MessageType: "TimeSinceResetRequest", TxID: 1})
+	if want := start.Add(10*time.Hour + 10*time.Minute).Unix(); params["currentTime"] != want {
+		t.Errorf("Expected currentTime %d (10 minutes fast), got %v", want, params["currentTime"])
+	}
+}
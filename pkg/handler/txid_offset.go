@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// txIDOffsets shifts the txID responses are sent with, per request message
+// type, to check how strictly a client matches responses to requests
+type txIDOffsets struct {
+	offsets map[string]int
+	mtx     sync.RWMutex
+}
+
+func (o *txIDOffsets) set(messageType string, offset int) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if o.offsets == nil {
+		o.offsets = make(map[string]int)
+	}
+	if offset%256 == 0 {
+		delete(o.offsets, messageType)
+	} else {
+		o.offsets[messageType] = offset
+	}
+}
+
+// apply returns msg with its txID shifted by messageType's offset, wrapping
+// within a byte, or msg itself if there is no offset. Handlers encode their
+// responses with the returned txID.
+func (o *txIDOffsets) apply(msg *pumpx2.ParsedMessage) *pumpx2.ParsedMessage {
+	o.mtx.RLock()
+	offset, ok := o.offsets[msg.MessageType]
+	o.mtx.RUnlock()
+	if !ok {
+		return msg
+	}
+
+	shifted := *msg
+	shifted.TxID = ((msg.TxID+offset)%256 + 256) % 256
+	log.Warnf("Responding to %s txID=%d with offset txID=%d", msg.MessageType, msg.TxID, shifted.TxID)
+	return &shifted
+}
+
+// SetTxIDOffset makes responses to messageType carry the request's txID plus
+// offset instead of echoing it, to test whether a client rejects or
+// tolerates a mismatched response. An offset of 0 restores echoing.
+func (r *Router) SetTxIDOffset(messageType string, offset int) {
+	r.txIDOffsets.set(messageType, offset)
+	log.Infof("Response txID offset for %s set to %d", messageType, offset)
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"sync"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+)
+
+// dispatchKey identifies a transaction the same way the reassembler does
+type dispatchKey struct {
+	charType bluetooth.CharacteristicType
+	txID     uint8
+}
+
+// Dispatcher runs the work for each incoming message on a worker for its
+// transaction, so a slow pumpX2 call for one message doesn't hold up
+// messages of other transactions. Work for the same characteristic and txID
+// runs one at a time in the order dispatched, and at most maxWorkers
+// transactions are worked on at once.
+type Dispatcher struct {
+	mutex  sync.Mutex
+	queues map[dispatchKey][]func()
+	slots  chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher running up to maxWorkers transactions
+// concurrently
+func NewDispatcher(maxWorkers int) *Dispatcher {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+	return &Dispatcher{
+		queues: make(map[dispatchKey][]func()),
+		slots:  make(chan struct{}, maxWorkers),
+	}
+}
+
+// Dispatch queues work for the transaction charType/txID, starting a worker
+// for it unless one is already running
+func (d *Dispatcher) Dispatch(charType bluetooth.CharacteristicType, txID uint8, work func()) {
+	key := dispatchKey{charType: charType, txID: txID}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.wg.Add(1)
+	queue, running := d.queues[key]
+	d.queues[key] = append(queue, work)
+	if !running {
+		go d.worker(key)
+	}
+}
+
+// worker runs key's queued work until the queue drains
+func (d *Dispatcher) worker(key dispatchKey) {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	for {
+		d.mutex.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mutex.Unlock()
+			return
+		}
+		work := queue[0]
+		d.queues[key] = queue[1:]
+		d.mutex.Unlock()
+
+		work()
+		d.wg.Done()
+	}
+}
+
+// Wait blocks until all dispatched work has run
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
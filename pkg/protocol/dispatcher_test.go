@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/testutil"
+)
+
+// TestDispatcherRunsTransactionsConcurrently verifies a blocked transaction
+// doesn't hold up another, while each transaction's work stays in order
+func TestDispatcherRunsTransactionsConcurrently(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	d := NewDispatcher(2)
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	var mutex sync.Mutex
+	var order []string
+	record := func(step string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, step)
+	}
+
+	d.Dispatch(bluetooth.CharControl, 1, func() { <-release; record("1a") })
+	d.Dispatch(bluetooth.CharControl, 2, func() { record("2a") })
+	d.Dispatch(bluetooth.CharControl, 1, func() { record("1b") })
+	d.Dispatch(bluetooth.CharControl, 2, func() { record("2b"); close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Transaction 2 was serialized behind blocked transaction 1")
+	}
+	close(release)
+	d.Wait()
+
+	expected := []string{"2a", "2b", "1a", "1b"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+}
+
+// TestDispatcherBoundsWorkers verifies no more than maxWorkers transactions
+// run at once
+func TestDispatcherBoundsWorkers(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	d := NewDispatcher(1)
+	release := make(chan struct{})
+	started := make(chan uint8, 2)
+
+	for txID := uint8(1); txID <= 2; txID++ {
+		txID := txID
+		d.Dispatch(bluetooth.CharCurrentStatus, txID, func() {
+			started <- txID
+			<-release
+		})
+	}
+
+	<-started
+	select {
+	case txID := <-started:
+		t.Errorf("Expected one worker, but transaction %d started concurrently", txID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	d.Wait()
+}
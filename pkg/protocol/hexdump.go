@@ -0,0 +1,14 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// Hexdump formats data as a classic offset/hex/ASCII dump, 16 bytes per line,
+// in the same layout as `hexdump -C`:
+//
+//	00000000  48 65 6c 6c 6f 2c 20 77  6f 72 6c 64 21 0a 00 01  |Hello, world!...|
+func Hexdump(data []byte) string {
+	return strings.TrimSuffix(hex.Dump(data), "\n")
+}
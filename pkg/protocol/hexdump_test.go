@@ -0,0 +1,23 @@
+package protocol
+
+import "testing"
+
+// TestHexdumpFormat verifies the offset/hex/ASCII layout, including the
+// ASCII gutter and a partial final line
+func TestHexdumpFormat(t *testing.T) {
+	data := append([]byte("Hello, world!"), 0x0a, 0x00, 0x01, 0x7f, 0x41, 0x42)
+
+	expected := "00000000  48 65 6c 6c 6f 2c 20 77  6f 72 6c 64 21 0a 00 01  |Hello, world!...|\n" +
+		"00000010  7f 41 42                                          |.AB|"
+
+	if got := Hexdump(data); got != expected {
+		t.Errorf("Unexpected hexdump.\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestHexdumpEmpty verifies empty input produces an empty dump
+func TestHexdumpEmpty(t *testing.T) {
+	if got := Hexdump(nil); got != "" {
+		t.Errorf("Expected empty dump, got %q", got)
+	}
+}
@@ -1,7 +1,7 @@
 package protocol
 
 import (
-	"encoding/hex"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
@@ -26,6 +26,80 @@ func ParsePacketHeader(data []byte) (*PacketHeader, error) {
 	}, nil
 }
 
+// ValidatePacket checks a single BLE packet's framing: a [remaining][txId]
+// header followed by at least one byte of message. A message's length and
+// CRC can only be checked once its packets are reassembled -- see
+// ValidateMessage.
+func ValidatePacket(data []byte) error {
+	if len(data) < 3 {
+		return fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+	return nil
+}
+
+// signatureSize is the size of the [timeSinceReset][HMAC-SHA1] trailer on
+// signed messages
+const signatureSize = 24
+
+// ValidateMessage checks a reassembled [opcode][txId][cargoSize][cargo][CRC16]
+// message: its cargoSize must account for its length, allowing for a signed
+// message's trailer, and its trailing little-endian CRC16 must match the bytes
+// before it
+func ValidateMessage(message []byte) error {
+	if len(message) < messageOverhead {
+		return fmt.Errorf("message too short: %d bytes", len(message))
+	}
+	cargoSize := int(message[2])
+	if n := len(message); n != cargoSize+messageOverhead && n != cargoSize+messageOverhead+signatureSize {
+		return fmt.Errorf("cargo size %d does not match message length %d", cargoSize, n)
+	}
+	body := message[:len(message)-2]
+	if got, want := binary.LittleEndian.Uint16(message[len(message)-2:]), CRC16(body); got != want {
+		return fmt.Errorf("CRC mismatch: got 0x%04x, expected 0x%04x", got, want)
+	}
+	return nil
+}
+
+// CRC16 computes the CRC-16/CCITT-FALSE checksum trailing each message
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// FrameMessage builds the [opcode][txId][cargoSize][cargo][CRC16] message for
+// cargo, ready to be split by AssemblePackets
+func FrameMessage(opcode, txID uint8, cargo []byte) ([]byte, error) {
+	if len(cargo) > 255 {
+		return nil, fmt.Errorf("cargo too large: %d bytes", len(cargo))
+	}
+	message := make([]byte, 0, len(cargo)+messageOverhead)
+	message = append(message, opcode, txID, byte(len(cargo)))
+	message = append(message, cargo...)
+	crc := make([]byte, 2)
+	binary.LittleEndian.PutUint16(crc, CRC16(message))
+	return append(message, crc...), nil
+}
+
+// EncodePackets frames cargo as a message with its CRC and splits it into
+// packets, the counterpart of reassembling and validating them
+func EncodePackets(charType bluetooth.CharacteristicType, opcode, txID uint8, cargo []byte) ([][]byte, error) {
+	message, err := FrameMessage(opcode, txID, cargo)
+	if err != nil {
+		return nil, err
+	}
+	return AssemblePackets(charType, txID, message)
+}
+
 // GetPacketPayload extracts the payload (data after header) from a packet
 func GetPacketPayload(data []byte) ([]byte, error) {
 	if len(data) < 2 {
@@ -91,16 +165,26 @@ func AssemblePackets(charType bluetooth.CharacteristicType, txID uint8, message
 	return packets, nil
 }
 
-// LogPacket logs a packet in a readable format
+// LogPacket logs a packet in a readable format, subject to SetTraceSampling
 func LogPacket(direction string, charType bluetooth.CharacteristicType, data []byte) {
 	if len(data) < 2 {
-		log.Warnf("%s packet on %s too short: %s", direction, charType, hex.EncodeToString(data))
+		log.Warnf("%s packet on %s too short: %s", direction, charType, LogHex(charType, data))
 		return
 	}
 
 	header, _ := ParsePacketHeader(data)
+	if !sampler.shouldLog(direction, charType, header.RemainingPackets) {
+		return
+	}
 	payload, _ := GetPacketPayload(data)
 
+	// At trace level, include a full hexdump of the packet for readability
+	if log.IsLevelEnabled(log.TraceLevel) && !(charType == bluetooth.CharAuthorization && RedactingAuth()) {
+		log.Tracef("%s packet on %s: remaining=%d, txID=%d\n%s",
+			direction, charType, header.RemainingPackets, header.TxID, Hexdump(data))
+		return
+	}
+
 	log.Debugf("%s packet on %s: remaining=%d, txID=%d, payload=%s",
-		direction, charType, header.RemainingPackets, header.TxID, hex.EncodeToString(payload))
+		direction, charType, header.RemainingPackets, header.TxID, LogHex(charType, payload))
 }
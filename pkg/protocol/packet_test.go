@@ -0,0 +1,278 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	log "github.com/sirupsen/logrus"
+)
+
+// countingHook counts log entries that pass through logrus, keeping their
+// messages
+type countingHook struct {
+	count    int
+	messages []string
+}
+
+func (h *countingHook) Levels() []log.Level { return log.AllLevels }
+
+func (h *countingHook) Fire(entry *log.Entry) error {
+	h.count++
+	h.messages = append(h.messages, entry.Message)
+	return nil
+}
+
+// withCountingHook installs a counting hook at trace level for the test's duration
+func withCountingHook(t *testing.T) *countingHook {
+	hook := &countingHook{}
+	prevLevel := log.GetLevel()
+	prevHooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	log.SetLevel(log.TraceLevel)
+	log.AddHook(hook)
+	t.Cleanup(func() {
+		log.SetLevel(prevLevel)
+		log.StandardLogger().ReplaceHooks(prevHooks)
+		SetTraceSampling(TraceSampling{})
+	})
+	return hook
+}
+
+// TestLogPacketSamplesOneInN verifies 1-in-10 sampling logs exactly one of ten packets
+func TestLogPacketSamplesOneInN(t *testing.T) {
+	hook := withCountingHook(t)
+	SetTraceSampling(TraceSampling{Every: 10})
+
+	for i := 0; i < 10; i++ {
+		LogPacket("RX", bluetooth.CharHistoryLog, []byte{0x00, byte(i), 0xAA})
+	}
+
+	if hook.count != 1 {
+		t.Errorf("Expected 1 logged packet out of 10, got %d", hook.count)
+	}
+}
+
+// TestLogPacketSamplesPerCharacteristic verifies counters are independent per direction/characteristic
+func TestLogPacketSamplesPerCharacteristic(t *testing.T) {
+	hook := withCountingHook(t)
+	SetTraceSampling(TraceSampling{Every: 10})
+
+	LogPacket("RX", bluetooth.CharHistoryLog, []byte{0x00, 0x01})
+	LogPacket("TX", bluetooth.CharHistoryLog, []byte{0x00, 0x01})
+	LogPacket("RX", bluetooth.CharControl, []byte{0x00, 0x01})
+
+	if hook.count != 3 {
+		t.Errorf("Expected first packet of each stream to be logged (3), got %d", hook.count)
+	}
+}
+
+// TestLogPacketEdgesOnly verifies only the first and last packet of a message are logged
+func TestLogPacketEdgesOnly(t *testing.T) {
+	hook := withCountingHook(t)
+	SetTraceSampling(TraceSampling{EdgesOnly: true})
+
+	for remaining := 4; remaining >= 0; remaining-- {
+		LogPacket("TX", bluetooth.CharHistoryLog, []byte{byte(remaining), 0x05, 0xAA})
+	}
+
+	if hook.count != 2 {
+		t.Errorf("Expected first and last packets logged (2), got %d", hook.count)
+	}
+}
+
+// TestParseTraceSample verifies flag value parsing
+func TestParseTraceSample(t *testing.T) {
+	if s, err := ParseTraceSample("10"); err != nil || s.Every != 10 {
+		t.Errorf("Expected Every=10, got %+v (err=%v)", s, err)
+	}
+	if s, err := ParseTraceSample("edges"); err != nil || !s.EdgesOnly {
+		t.Errorf("Expected EdgesOnly, got %+v (err=%v)", s, err)
+	}
+	if _, err := ParseTraceSample("-1"); err == nil {
+		t.Error("Expected error for negative sample rate")
+	}
+	if _, err := ParseTraceSample("abc"); err == nil {
+		t.Error("Expected error for non-numeric sample rate")
+	}
+}
+
+// TestValidateMessage verifies a framed message validates, including with a
+// signature trailer outside its cargo size, and that a bad CRC or cargo size
+// is rejected
+func TestValidateMessage(t *testing.T) {
+	message, err := FrameMessage(0x20, 3, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatalf("FrameMessage failed: %v", err)
+	}
+	if err := ValidateMessage(message); err != nil {
+		t.Errorf("Expected a framed message to validate, got %v", err)
+	}
+
+	signed := append([]byte{0x20, 3, 3, 1, 2, 3}, make([]byte, signatureSize)...)
+	signed = append(signed, byte(CRC16(signed)), byte(CRC16(signed)>>8))
+	if err := ValidateMessage(signed); err != nil {
+		t.Errorf("Expected a signed message to validate, got %v", err)
+	}
+
+	badCRC := append([]byte(nil), message...)
+	badCRC[len(badCRC)-1] ^= 0xff
+	if err := ValidateMessage(badCRC); err == nil {
+		t.Error("Expected a CRC mismatch")
+	}
+	badSize := append([]byte(nil), message...)
+	badSize[2] = 4
+	if err := ValidateMessage(badSize); err == nil {
+		t.Error("Expected a cargo size mismatch")
+	}
+	if err := ValidateMessage([]byte{0x20, 3, 0, 0}); err == nil {
+		t.Error("Expected a short message to be rejected")
+	}
+}
+
+// realJpake1aRawFragments are the raw Authorization fragments of a Jpake1a
+// request (txId=4) captured from a real Tandem Mobi + official app pairing
+// attempt, the same capture as pkg/pumpx2's; its message ends in CRC 0xaac9,
+// sent little-endian as c9 aa
+var realJpake1aRawFragments = []string{
+	"09042004a70000410477521493da112577faa707",
+	"0804c9c92a68e4b40cc46df17b306f52f32631af",
+	"0704cd88d27a74f8ba9401e9aea18bcdb6f2c678",
+	"06043cd475269208b03b9c7fa5c7a342eacaed41",
+	"050404ec21fa73c0b5c2984d842a22a2db1df426",
+	"0404a2793811949552a69108f8f10aad6c8f8c22",
+	"0304cc3c9443848a1833f425b6cbef4658b2a86d",
+	"02049b162b0b6c645e1d2993d920c143c44c4b68",
+	"01047dd833cb7888682f66da86e0f0eb0b3abb59",
+	"0004135c704fbbd824ecc9aa",
+}
+
+// TestReassemblerValidatesRealCapture verifies a message captured from a
+// real app reassembles and validates, guarding the CRC's byte order
+func TestReassemblerValidatesRealCapture(t *testing.T) {
+	r := NewLazyReassembler(time.Minute, time.Now)
+	var message []byte
+	for i, fragment := range realJpake1aRawFragments {
+		packet, err := hex.DecodeString(fragment)
+		if err != nil {
+			t.Fatalf("Bad fragment %d: %v", i, err)
+		}
+		got, raw, complete, err := r.AddPacket(bluetooth.CharAuthorization, packet)
+		if err != nil {
+			t.Fatalf("AddPacket(%d) failed: %v", i, err)
+		}
+		if complete != (i == len(realJpake1aRawFragments)-1) {
+			t.Fatalf("Fragment %d/%d reported complete=%v", i+1, len(realJpake1aRawFragments), complete)
+		}
+		if complete && len(raw) != len(realJpake1aRawFragments) {
+			t.Errorf("Expected %d raw fragments, got %d", len(realJpake1aRawFragments), len(raw))
+		}
+		message = got
+	}
+
+	if message[0] != 0x20 || message[1] != 4 {
+		t.Errorf("Expected opcode 0x20 txId 4, got 0x%02x txId %d", message[0], message[1])
+	}
+	if got := CRC16(message[:len(message)-2]); got != 0xaac9 {
+		t.Errorf("Expected CRC 0xaac9, got 0x%04x", got)
+	}
+}
+
+// TestReassemblerRejectsInvalidPacket verifies a packet with no message
+// bytes is rejected without being buffered
+func TestReassemblerRejectsInvalidPacket(t *testing.T) {
+	r := NewLazyReassembler(time.Minute, time.Now)
+	if _, _, _, err := r.AddPacket(bluetooth.CharControl, []byte{0, 1}); err == nil {
+		t.Error("Expected a header-only packet to be rejected")
+	}
+	if got := r.GetStats()["activeBuffers"]; got != 0 {
+		t.Errorf("Expected no buffers, got %v", got)
+	}
+}
+
+// TestEncodePacketsRoundTrip verifies encoded packets reassemble into a
+// message that validates, for cargo sizes up to the largest
+func TestEncodePacketsRoundTrip(t *testing.T) {
+	r := NewLazyReassembler(time.Minute, time.Now)
+	for _, size := range []int{0, 1, 13, 14, 100, 255} {
+		cargo := make([]byte, size)
+		for i := range cargo {
+			cargo[i] = byte(i)
+		}
+		packets, err := EncodePackets(bluetooth.CharControl, 0x24, uint8(size), cargo)
+		if err != nil {
+			t.Fatalf("EncodePackets(%d) failed: %v", size, err)
+		}
+
+		var message []byte
+		for i, packet := range packets {
+			got, _, complete, err := r.AddPacket(bluetooth.CharControl, packet)
+			if err != nil {
+				t.Fatalf("Cargo %d: AddPacket failed: %v", size, err)
+			}
+			if complete != (i == len(packets)-1) {
+				t.Fatalf("Cargo %d: packet %d/%d reported complete=%v", size, i+1, len(packets), complete)
+			}
+			message = got
+		}
+		if !bytes.Equal(message[3:3+size], cargo) {
+			t.Errorf("Cargo %d: expected %x, got %x", size, cargo, message[3:3+size])
+		}
+	}
+
+	if _, err := EncodePackets(bluetooth.CharControl, 0x24, 1, make([]byte, 256)); err == nil {
+		t.Error("Expected a 256 byte cargo to be rejected")
+	}
+}
+
+// TestReassemblerRejectsCorruptedMessages flips random bytes after the
+// packet headers of encoded messages and verifies none of them is ever
+// returned as a complete message
+func TestReassemblerRejectsCorruptedMessages(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	r := NewLazyReassembler(time.Minute, time.Now)
+
+	for i := 0; i < 500; i++ {
+		txID := uint8(i)
+		cargo := make([]byte, rng.Intn(60))
+		rng.Read(cargo)
+		packets, err := EncodePackets(bluetooth.CharControl, 0x24, txID, cargo)
+		if err != nil {
+			t.Fatalf("EncodePackets failed: %v", err)
+		}
+
+		// Corrupt 1-3 bytes of message, leaving the [remaining][txId]
+		// headers intact so the packets still land in one buffer
+		for n := rng.Intn(3) + 1; n > 0; n-- {
+			packet := packets[rng.Intn(len(packets))]
+			packet[2+rng.Intn(len(packet)-2)] ^= byte(rng.Intn(255) + 1)
+		}
+
+		for _, packet := range packets {
+			if message, _, complete, err := r.AddPacket(bluetooth.CharControl, packet); complete {
+				t.Fatalf("Iteration %d: corrupted message %x was accepted (err=%v)", i, message, err)
+			}
+		}
+		r.Reset()
+	}
+}
+
+// TestReassemblerSurvivesRandomPackets verifies random bytes never panic the
+// reassembler or come out as a complete message
+func TestReassemblerSurvivesRandomPackets(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	r := NewLazyReassembler(time.Minute, time.Now)
+
+	for i := 0; i < 2000; i++ {
+		packet := make([]byte, rng.Intn(GetChunkSize(bluetooth.CharControl)+1))
+		rng.Read(packet)
+		if len(packet) > 0 {
+			packet[0] %= 4 // keep messages short enough to complete
+		}
+		if message, _, complete, err := r.AddPacket(bluetooth.CharControl, packet); complete && ValidateMessage(message) != nil {
+			t.Fatalf("Iteration %d: invalid message %x was accepted (err=%v)", i, message, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,30 +12,72 @@ import (
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
 )
 
+// messageOverhead is the size of a message's [opcode][txId][cargoSize]
+// header and trailing CRC16, around its cargo
+const messageOverhead = 5
+
 // PacketBuffer holds packets being assembled into a complete message
 type PacketBuffer struct {
-	CharType      bluetooth.CharacteristicType
-	TxID          uint8
-	Packets       [][]byte
+	CharType bluetooth.CharacteristicType
+	TxID     uint8
+	// Packets are keyed by their RemainingPackets position, so a message
+	// assembles in order however its packets arrive
+	Packets map[uint8][]byte
+	// ExpectedCount is one more than the highest position seen, which is the
+	// message's packet count once its first packet has arrived
 	ExpectedCount int
 	Timestamp     time.Time
 }
 
-// IsComplete returns true if all packets have been received
+// IsComplete returns true if all packets have been received. Packets are
+// only told apart by position, so the last packets of a message that arrive
+// before its first look like a complete shorter message; the packet at the
+// highest position must also look like the start of a message.
 func (pb *PacketBuffer) IsComplete() bool {
-	return len(pb.Packets) == pb.ExpectedCount
+	return len(pb.Packets) == pb.ExpectedCount && pb.startsMessage()
+}
+
+// startsMessage returns true unless the packet at the highest position
+// can't be a message's first: its cargoSize header byte declares more bytes
+// than the buffer holds. This is a heuristic -- a middle packet whose third
+// byte happens to be small passes -- but it never holds back a message that
+// arrived in order.
+func (pb *PacketBuffer) startsMessage() bool {
+	first, ok := pb.Packets[uint8(pb.ExpectedCount-1)]
+	if !ok || len(first) < 5 {
+		return true
+	}
+	size := 0
+	for _, packet := range pb.Packets {
+		size += len(packet) - 2
+	}
+	return int(first[4])+messageOverhead <= size
 }
 
-// AssembleMessage combines all packets into a single message
+// ordered returns the packets from first to last, i.e. in descending
+// RemainingPackets order
+func (pb *PacketBuffer) ordered() [][]byte {
+	packets := make([][]byte, 0, len(pb.Packets))
+	for pos := pb.ExpectedCount - 1; pos >= 0; pos-- {
+		if packet, ok := pb.Packets[uint8(pos)]; ok {
+			packets = append(packets, packet)
+		}
+	}
+	return packets
+}
+
+// AssembleMessage combines all packets into a single message, in descending
+// RemainingPackets order
 func (pb *PacketBuffer) AssembleMessage() ([]byte, error) {
 	if !pb.IsComplete() {
 		return nil, fmt.Errorf("cannot assemble incomplete message: have %d/%d packets",
 			len(pb.Packets), pb.ExpectedCount)
 	}
+	packets := pb.ordered()
 
 	// Calculate total size
 	totalSize := 0
-	for _, packet := range pb.Packets {
+	for _, packet := range packets {
 		payload, err := GetPacketPayload(packet)
 		if err != nil {
 			return nil, fmt.Errorf("invalid packet: %w", err)
@@ -44,25 +87,26 @@ func (pb *PacketBuffer) AssembleMessage() ([]byte, error) {
 
 	// Combine all payloads
 	message := make([]byte, 0, totalSize)
-	for _, packet := range pb.Packets {
+	for _, packet := range packets {
 		payload, _ := GetPacketPayload(packet)
 		message = append(message, payload...)
 	}
 
 	log.Debugf("Assembled message: txID=%d, packets=%d, size=%d bytes, hex=%s",
-		pb.TxID, len(pb.Packets), len(message), hex.EncodeToString(message))
+		pb.TxID, len(packets), len(message), hex.EncodeToString(message))
 
 	return message, nil
 }
 
 // RawPacketsHex returns the original, unstripped BLE fragments as hex strings, in
-// the order received. pumpX2's cliparser expects raw fragments (including their
+// message order. pumpX2's cliparser expects raw fragments (including their
 // [remaining][txId] framing bytes) rather than a pre-stripped, concatenated
 // payload -- the framing bytes of the first fragment carry the real opcode/txId/
 // cargoSize header that the parser needs.
 func (pb *PacketBuffer) RawPacketsHex() []string {
-	rawHex := make([]string, 0, len(pb.Packets))
-	for _, packet := range pb.Packets {
+	packets := pb.ordered()
+	rawHex := make([]string, 0, len(packets))
+	for _, packet := range packets {
 		rawHex = append(rawHex, hex.EncodeToString(packet))
 	}
 	return rawHex
@@ -70,11 +114,15 @@ func (pb *PacketBuffer) RawPacketsHex() []string {
 
 // Reassembler manages the reassembly of multi-packet messages
 type Reassembler struct {
-	buffers      map[string]*PacketBuffer
-	mutex        sync.RWMutex
-	timeout      time.Duration
-	cleanupTimer *time.Ticker
-	stopCleanup  chan bool
+	buffers        map[string]*PacketBuffer
+	mutex          sync.RWMutex
+	timeout        time.Duration
+	maxMessageSize int          // 0 means unlimited
+	cleanupTimer   *time.Ticker // nil in lazy expiry mode
+	stopCleanup    chan struct{}
+	cleanupDone    chan struct{}
+	stopOnce       sync.Once
+	now            func() time.Time
 }
 
 // NewReassembler creates a new packet reassembler
@@ -83,7 +131,9 @@ func NewReassembler(timeout time.Duration) *Reassembler {
 		buffers:      make(map[string]*PacketBuffer),
 		timeout:      timeout,
 		cleanupTimer: time.NewTicker(timeout / 2),
-		stopCleanup:  make(chan bool),
+		stopCleanup:  make(chan struct{}),
+		cleanupDone:  make(chan struct{}),
+		now:          time.Now,
 	}
 
 	// Start cleanup goroutine
@@ -92,14 +142,41 @@ func NewReassembler(timeout time.Duration) *Reassembler {
 	return r
 }
 
-// Stop stops the reassembler and cleanup goroutine
+// NewLazyReassembler creates a packet reassembler without a background cleanup
+// goroutine; stale buffers are instead purged on each AddPacket call, aged
+// by the clock now (usually time.Now)
+func NewLazyReassembler(timeout time.Duration, now func() time.Time) *Reassembler {
+	return &Reassembler{
+		buffers: make(map[string]*PacketBuffer),
+		timeout: timeout,
+		now:     now,
+	}
+}
+
+// SetMaxMessageSize rejects messages whose first packet declares more than
+// maxBytes of payload; 0 disables the limit
+func (r *Reassembler) SetMaxMessageSize(maxBytes int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maxMessageSize = maxBytes
+}
+
+// Stop stops the reassembler and waits for the cleanup goroutine to exit.
+// It is safe to call more than once.
 func (r *Reassembler) Stop() {
-	r.stopCleanup <- true
-	r.cleanupTimer.Stop()
+	if r.cleanupTimer == nil {
+		return
+	}
+	r.stopOnce.Do(func() {
+		r.cleanupTimer.Stop()
+		close(r.stopCleanup)
+	})
+	<-r.cleanupDone
 }
 
 // cleanupLoop periodically removes old incomplete buffers
 func (r *Reassembler) cleanupLoop() {
+	defer close(r.cleanupDone)
 	for {
 		select {
 		case <-r.cleanupTimer.C:
@@ -115,7 +192,12 @@ func (r *Reassembler) cleanupOldBuffers() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	now := time.Now()
+	r.expireBuffers()
+}
+
+// expireBuffers removes timed out buffers; the caller must hold r.mutex
+func (r *Reassembler) expireBuffers() {
+	now := r.now()
 	for key, buffer := range r.buffers {
 		if now.Sub(buffer.Timestamp) > r.timeout {
 			log.Warnf("Removing timed out buffer: %s (age: %v, packets: %d/%d)",
@@ -135,41 +217,58 @@ func (r *Reassembler) bufferKey(charType bluetooth.CharacteristicType, txID uint
 // the original unstripped fragments (only populated once isComplete is true) --
 // see RawPacketsHex for why callers need these instead of the stripped message.
 func (r *Reassembler) AddPacket(charType bluetooth.CharacteristicType, packet []byte) ([]byte, []string, bool, error) {
-	// Parse packet header
-	header, err := ParsePacketHeader(packet)
-	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed to parse packet header: %w", err)
+	if err := ValidatePacket(packet); err != nil {
+		return nil, nil, false, fmt.Errorf("invalid packet: %w", err)
 	}
+	header, _ := ParsePacketHeader(packet)
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	if r.cleanupTimer == nil {
+		r.expireBuffers()
+	}
+
 	key := r.bufferKey(charType, header.TxID)
 
+	// Every packet but the last is full, so the highest position seen bounds
+	// the message size before any more of it is buffered
+	expectedCount := int(header.RemainingPackets) + 1
+	if expectedSize := expectedCount * (GetChunkSize(charType) - 2); r.maxMessageSize > 0 && expectedSize > r.maxMessageSize {
+		delete(r.buffers, key)
+		return nil, nil, false, fmt.Errorf("message of up to %d bytes (%d packets) exceeds max message size %d: key=%s",
+			expectedSize, expectedCount, r.maxMessageSize, key)
+	}
+
 	// Get or create buffer
 	buffer, exists := r.buffers[key]
 	if !exists {
-		// First packet - calculate expected count
-		expectedCount := int(header.RemainingPackets) + 1
-
 		buffer = &PacketBuffer{
 			CharType:      charType,
 			TxID:          header.TxID,
-			Packets:       make([][]byte, 0, expectedCount),
+			Packets:       make(map[uint8][]byte, expectedCount),
 			ExpectedCount: expectedCount,
-			Timestamp:     time.Now(),
 		}
 		r.buffers[key] = buffer
 
 		log.Debugf("Created new packet buffer: key=%s, expectedPackets=%d", key, expectedCount)
 	}
 
-	// Add packet to buffer
-	buffer.Packets = append(buffer.Packets, packet)
-	buffer.Timestamp = time.Now() // Update timestamp
+	if _, dup := buffer.Packets[header.RemainingPackets]; dup {
+		log.Debugf("Ignoring duplicate packet: key=%s, remaining=%d", key, header.RemainingPackets)
+		return nil, nil, false, nil
+	}
 
-	log.Tracef("Added packet to buffer: key=%s, packets=%d/%d",
-		key, len(buffer.Packets), buffer.ExpectedCount)
+	// Add packet to buffer; a packet arriving ahead of the first raises the
+	// expected count
+	buffer.Packets[header.RemainingPackets] = packet
+	if expectedCount > buffer.ExpectedCount {
+		buffer.ExpectedCount = expectedCount
+	}
+	buffer.Timestamp = r.now() // Update timestamp
+
+	log.Tracef("Added packet to buffer: key=%s, remaining=%d, packets=%d/%d",
+		key, header.RemainingPackets, len(buffer.Packets), buffer.ExpectedCount)
 
 	// Check if complete
 	if buffer.IsComplete() {
@@ -184,6 +283,13 @@ func (r *Reassembler) AddPacket(charType bluetooth.CharacteristicType, packet []
 		// Remove buffer
 		delete(r.buffers, key)
 
+		if err := ValidateMessage(message); err != nil {
+			return nil, nil, false, fmt.Errorf("invalid message: key=%s: %w", key, err)
+		}
+		if message[1] != header.TxID {
+			return nil, nil, false, fmt.Errorf("invalid message: key=%s: message txID %d does not match its packets", key, message[1])
+		}
+
 		return message, rawPacketsHex, true, nil
 	}
 
@@ -205,7 +311,41 @@ func (r *Reassembler) GetStats() map[string]interface{} {
 	defer r.mutex.RUnlock()
 
 	return map[string]interface{}{
-		"activeBuffers": len(r.buffers),
-		"timeout":       r.timeout.String(),
+		"activeBuffers":  len(r.buffers),
+		"timeout":        r.timeout.String(),
+		"maxMessageSize": r.maxMessageSize,
+	}
+}
+
+// BufferDetail describes an in-flight packet buffer. Age is the time since
+// its last packet, which the buffer times out against.
+type BufferDetail struct {
+	Key             string `json:"key"`
+	Characteristic  string `json:"characteristic"`
+	TxID            uint8  `json:"txId"`
+	Packets         int    `json:"packets"`
+	ExpectedPackets int    `json:"expectedPackets"`
+	Age             string `json:"age"`
+}
+
+// BufferDetails returns the in-flight buffers ordered by key, for diagnosing
+// a client that starts a multi-packet message and never finishes it
+func (r *Reassembler) BufferDetails() []BufferDetail {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	now := r.now()
+	details := make([]BufferDetail, 0, len(r.buffers))
+	for key, buffer := range r.buffers {
+		details = append(details, BufferDetail{
+			Key:             key,
+			Characteristic:  buffer.CharType.String(),
+			TxID:            buffer.TxID,
+			Packets:         len(buffer.Packets),
+			ExpectedPackets: buffer.ExpectedCount,
+			Age:             now.Sub(buffer.Timestamp).String(),
+		})
 	}
+	sort.Slice(details, func(i, j int) bool { return details[i].Key < details[j].Key })
+	return details
 }
@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/testutil"
+)
+
+// TestReassemblerRejectsOversizedMessage verifies a first packet declaring a
+// message over the max size is rejected without buffering, while one at the
+// limit is accepted and assembled
+func TestReassemblerRejectsOversizedMessage(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	r := NewReassembler(time.Minute)
+	defer r.Stop()
+	// Control packets carry 16 payload bytes, so 2 packets is exactly 32
+	r.SetMaxMessageSize(32)
+
+	if _, _, _, err := r.AddPacket(bluetooth.CharControl, []byte{2, 1, 0xaa}); err == nil {
+		t.Error("Expected a 3 packet message to exceed the limit")
+	}
+	if got := r.GetStats()["activeBuffers"]; got != 0 {
+		t.Errorf("Expected rejected message not to be buffered, got %v buffers", got)
+	}
+
+	packets, err := EncodePackets(bluetooth.CharControl, 0x10, 2, make([]byte, 32-messageOverhead))
+	if err != nil || len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, got %d (err=%v)", len(packets), err)
+	}
+	if _, _, complete, err := r.AddPacket(bluetooth.CharControl, packets[0]); err != nil || complete {
+		t.Fatalf("Expected at-limit first packet to be buffered, got complete=%v err=%v", complete, err)
+	}
+	message, _, complete, err := r.AddPacket(bluetooth.CharControl, packets[1])
+	if err != nil || !complete || len(message) != 32 {
+		t.Errorf("Expected a complete 32 byte message, got %d bytes complete=%v err=%v", len(message), complete, err)
+	}
+}
+
+// testMessage returns a message with a 30 byte cargo, three Control packets
+// long, split into packets
+func testMessage(t *testing.T, txID uint8) ([]byte, [][]byte) {
+	t.Helper()
+	cargo := make([]byte, 30)
+	for i := range cargo {
+		cargo[i] = byte(0x20 + i)
+	}
+	message, err := FrameMessage(0x10, txID, cargo)
+	if err != nil {
+		t.Fatalf("FrameMessage failed: %v", err)
+	}
+	packets, err := AssemblePackets(bluetooth.CharControl, txID, message)
+	if err != nil || len(packets) != 3 {
+		t.Fatalf("Expected 3 packets, got %d (err=%v)", len(packets), err)
+	}
+	return message, packets
+}
+
+// TestReassemblerOrdersOutOfOrderPackets verifies packets arriving as
+// [middle, last, first] are held until the first arrives and then assembled
+// by position. The middle packet's third byte declares far more cargo than
+// two packets hold, so the pair isn't mistaken for a whole message.
+func TestReassemblerOrdersOutOfOrderPackets(t *testing.T) {
+	r := NewLazyReassembler(time.Minute, time.Now)
+	message, packets := testMessage(t, 4)
+
+	for _, packet := range [][]byte{packets[1], packets[2]} {
+		if _, _, complete, err := r.AddPacket(bluetooth.CharControl, packet); err != nil || complete {
+			t.Fatalf("Expected packet to be buffered, got complete=%v err=%v", complete, err)
+		}
+	}
+	got, rawHex, complete, err := r.AddPacket(bluetooth.CharControl, packets[0])
+	if err != nil || !complete {
+		t.Fatalf("Expected complete message, got complete=%v err=%v", complete, err)
+	}
+	if !bytes.Equal(got, message) {
+		t.Errorf("Expected %x, got %x", message, got)
+	}
+	for i, packet := range packets {
+		if rawHex[i] != hex.EncodeToString(packet) {
+			t.Errorf("Expected raw packet %d to be %x, got %s", i, packet, rawHex[i])
+		}
+	}
+}
+
+// TestReassemblerIgnoresDuplicatePackets verifies a repeated packet
+// position is dropped rather than counted toward or spliced into a message
+func TestReassemblerIgnoresDuplicatePackets(t *testing.T) {
+	r := NewLazyReassembler(time.Minute, time.Now)
+	message, packets := testMessage(t, 5)
+
+	for _, packet := range [][]byte{packets[0], packets[1], packets[1]} {
+		if _, _, complete, err := r.AddPacket(bluetooth.CharControl, packet); err != nil || complete {
+			t.Fatalf("Expected packet to be buffered, got complete=%v err=%v", complete, err)
+		}
+	}
+	if details := r.BufferDetails(); len(details) != 1 || details[0].Packets != 2 {
+		t.Fatalf("Expected the duplicate not to be counted, got %+v", details)
+	}
+	got, _, complete, err := r.AddPacket(bluetooth.CharControl, packets[2])
+	if err != nil || !complete || !bytes.Equal(got, message) {
+		t.Errorf("Expected %x, got %x complete=%v err=%v", message, got, complete, err)
+	}
+}
+
+// TestLazyReassemblerExpiresOnAddPacket verifies a lazy reassembler purges a
+// stale buffer on the next AddPacket using its clock, with no background ticker
+func TestLazyReassemblerExpiresOnAddPacket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewLazyReassembler(time.Second, func() time.Time { return now })
+	defer r.Stop()
+
+	if r.cleanupTimer != nil {
+		t.Fatal("Expected no cleanup ticker in lazy expiry mode")
+	}
+
+	// First of two packets for txID 1 is left incomplete
+	if _, _, complete, err := r.AddPacket(bluetooth.CharControl, append([]byte{1, 1}, make([]byte, 16)...)); err != nil || complete {
+		t.Fatalf("Expected first packet to be buffered, got complete=%v err=%v", complete, err)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if _, _, _, err := r.AddPacket(bluetooth.CharControl, []byte{1, 2, 0xaa}); err != nil {
+		t.Fatalf("AddPacket failed: %v", err)
+	}
+	if got := r.GetStats()["activeBuffers"]; got != 2 {
+		t.Fatalf("Expected both buffers to be live before the timeout, got %v", got)
+	}
+
+	// Past the timeout for txID 1 only; txID 2 was touched 500ms later
+	now = now.Add(700 * time.Millisecond)
+	if _, _, _, err := r.AddPacket(bluetooth.CharControl, []byte{1, 3, 0xbb}); err != nil {
+		t.Fatalf("AddPacket failed: %v", err)
+	}
+	if got := r.GetStats()["activeBuffers"]; got != 2 {
+		t.Errorf("Expected txID 1 to be expired leaving 2 buffers, got %v", got)
+	}
+
+	// The expired txID 1 starts over instead of completing the old message
+	packets, err := EncodePackets(bluetooth.CharControl, 0x10, 1, []byte{0xcc})
+	if err != nil {
+		t.Fatalf("EncodePackets failed: %v", err)
+	}
+	if _, _, complete, err := r.AddPacket(bluetooth.CharControl, packets[0]); err != nil || !complete {
+		t.Errorf("Expected a fresh single packet message for txID 1, got complete=%v err=%v", complete, err)
+	}
+}
+
+// TestReassemblerBufferDetails verifies an in-flight buffer is described
+// with its packet counts and age, and is gone once reset
+func TestReassemblerBufferDetails(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewLazyReassembler(time.Minute, func() time.Time { return now })
+
+	if _, _, _, err := r.AddPacket(bluetooth.CharControl, append([]byte{2, 7}, make([]byte, 16)...)); err != nil {
+		t.Fatalf("AddPacket failed: %v", err)
+	}
+	now = now.Add(3 * time.Second)
+
+	details := r.BufferDetails()
+	expected := BufferDetail{Key: "Control-7", Characteristic: "Control", TxID: 7, Packets: 1, ExpectedPackets: 3, Age: "3s"}
+	if len(details) != 1 || details[0] != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, details)
+	}
+
+	r.Reset()
+	if details := r.BufferDetails(); len(details) != 0 {
+		t.Errorf("Expected no buffers after reset, got %+v", details)
+	}
+}
+
+// TestReassemblerStopIsIdempotent verifies Stop can be called twice and
+// leaves no cleanup goroutine behind
+func TestReassemblerStopIsIdempotent(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	r := NewReassembler(time.Minute)
+
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Second Stop blocked")
+	}
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"fmt"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+)
+
+// SetRedactAuth turns redaction of authentication payloads in logs on or
+// off; see bluetooth.SetRedactAuth
+func SetRedactAuth(enabled bool) {
+	bluetooth.SetRedactAuth(enabled)
+}
+
+// RedactingAuth reports whether authentication payloads are redacted
+func RedactingAuth() bool {
+	return bluetooth.RedactingAuth()
+}
+
+// redacted is the placeholder logged in place of an n byte payload
+func redacted(n int) string {
+	return fmt.Sprintf("<redacted %d bytes>", n)
+}
+
+// LogHex returns data as hex for logging, or a placeholder with its length
+// if it was sent on the Authorization characteristic and auth payloads are
+// being redacted
+func LogHex(charType bluetooth.CharacteristicType, data []byte) string {
+	return bluetooth.LogHex(charType, data)
+}
+
+// LogAuthHex returns hexData, the hex of an authentication payload or key,
+// for logging, or a placeholder with its byte length if auth payloads are
+// being redacted
+func LogAuthHex(hexData string) string {
+	if RedactingAuth() {
+		return redacted(len(hexData) / 2)
+	}
+	return hexData
+}
+
+// LogAuthText returns text carrying authentication payloads, like
+// cliparser's output for an auth message, for logging, or a placeholder with
+// its length if auth payloads are being redacted
+func LogAuthText(text string) string {
+	if RedactingAuth() {
+		return redacted(len(text))
+	}
+	return text
+}
+
+// RedactHex returns a placeholder with the byte length of hexData, an
+// authentication payload, whether or not logs are being redacted. It's for
+// payloads kept or served outside the logs.
+func RedactHex(hexData string) string {
+	return redacted(len(hexData) / 2)
+}
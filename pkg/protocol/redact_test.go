@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+)
+
+// jpakePacket is an Authorization packet carrying a JPAKE key fragment
+var jpakePacket = []byte{0x00, 0x03, 0xde, 0xad, 0xbe, 0xef}
+
+// logged returns everything hook captured as one string
+func logged(hook *countingHook) string {
+	return strings.Join(hook.messages, "\n")
+}
+
+// TestLogPacketRedactsAuthorization verifies Authorization packets are
+// logged by length only, at both debug and trace level
+func TestLogPacketRedactsAuthorization(t *testing.T) {
+	hook := withCountingHook(t)
+
+	LogPacket("RX", bluetooth.CharAuthorization, jpakePacket)
+
+	out := logged(hook)
+	if strings.Contains(out, "deadbeef") || strings.Contains(out, "de ad be ef") {
+		t.Errorf("Expected Authorization payload to be redacted, got %q", out)
+	}
+	if !strings.Contains(out, "<redacted 4 bytes>") {
+		t.Errorf("Expected redaction placeholder with payload length, got %q", out)
+	}
+}
+
+// TestLogPacketShowsControlInFull verifies redaction leaves other
+// characteristics alone
+func TestLogPacketShowsControlInFull(t *testing.T) {
+	hook := withCountingHook(t)
+
+	LogPacket("RX", bluetooth.CharControl, jpakePacket)
+
+	if out := logged(hook); !strings.Contains(out, "de ad be ef") {
+		t.Errorf("Expected Control packet hexdump in full, got %q", out)
+	}
+}
+
+// TestLogPacketRedactionDisabled verifies SetRedactAuth(false) logs
+// Authorization packets in full
+func TestLogPacketRedactionDisabled(t *testing.T) {
+	hook := withCountingHook(t)
+	SetRedactAuth(false)
+	defer SetRedactAuth(true)
+
+	LogPacket("RX", bluetooth.CharAuthorization, jpakePacket)
+
+	if out := logged(hook); !strings.Contains(out, "de ad be ef") || strings.Contains(out, "redacted") {
+		t.Errorf("Expected unredacted Authorization packet, got %q", out)
+	}
+}
+
+// TestLogAuthHex verifies key material is redacted to its byte length
+func TestLogAuthHex(t *testing.T) {
+	if got := LogAuthHex("00112233"); got != "<redacted 4 bytes>" {
+		t.Errorf("Expected redacted placeholder, got %q", got)
+	}
+
+	SetRedactAuth(false)
+	defer SetRedactAuth(true)
+	if got := LogAuthHex("00112233"); got != "00112233" {
+		t.Errorf("Expected hex unchanged with redaction off, got %q", got)
+	}
+}
+
+// TestLogHexRedactsBluetoothLogs verifies the bluetooth package's own logs
+// follow SetRedactAuth
+func TestLogHexRedactsBluetoothLogs(t *testing.T) {
+	if got := bluetooth.LogHex(bluetooth.CharAuthorization, jpakePacket); got != "<redacted 6 bytes>" {
+		t.Errorf("Expected redacted placeholder, got %q", got)
+	}
+
+	SetRedactAuth(false)
+	defer SetRedactAuth(true)
+	if got := bluetooth.LogHex(bluetooth.CharAuthorization, jpakePacket); got != "0003deadbeef" {
+		t.Errorf("Expected hex with redaction off, got %q", got)
+	}
+}
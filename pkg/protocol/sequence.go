@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+)
+
+// MessageEvent describes a parsed message received from, or sent to, the central
+type MessageEvent struct {
+	Direction   string // "RX" or "TX", matching LogPacket
+	CharType    bluetooth.CharacteristicType
+	MessageType string
+	TxID        int
+}
+
+// MessageObserver is called for every RX parse and TX send
+type MessageObserver func(event MessageEvent)
+
+// PacketEvent describes a single BLE packet sent to the central
+type PacketEvent struct {
+	Direction string
+	CharType  bluetooth.CharacteristicType
+	Data      []byte
+}
+
+// PacketObserver is called for every packet sent
+type PacketObserver func(event PacketEvent)
+
+// SequenceStep is one expected (direction, messageType) pair
+type SequenceStep struct {
+	Direction   string
+	MessageType string
+}
+
+func (s SequenceStep) String() string {
+	return s.Direction + " " + s.MessageType
+}
+
+// TestingT is the subset of testing.TB used by AssertSequence, so this
+// package doesn't need to import testing
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// SequenceRecorder records the (direction, messageType) sequence of messages
+// flowing through the router so tests can assert on handler flows
+type SequenceRecorder struct {
+	steps []SequenceStep
+	mutex sync.Mutex
+}
+
+// NewSequenceRecorder creates an empty sequence recorder
+func NewSequenceRecorder() *SequenceRecorder {
+	return &SequenceRecorder{}
+}
+
+// Observe records an event; pass it to Router.AddMessageObserver
+func (s *SequenceRecorder) Observe(event MessageEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.steps = append(s.steps, SequenceStep{Direction: event.Direction, MessageType: event.MessageType})
+}
+
+// Steps returns a copy of the recorded sequence
+func (s *SequenceRecorder) Steps() []SequenceStep {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	steps := make([]SequenceStep, len(s.steps))
+	copy(steps, s.steps)
+	return steps
+}
+
+// Reset clears the recorded sequence
+func (s *SequenceRecorder) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.steps = nil
+}
+
+// AssertSequence reports an error on t unless the recorded sequence exactly
+// matches expected
+func (s *SequenceRecorder) AssertSequence(t TestingT, expected []SequenceStep) bool {
+	t.Helper()
+	actual := s.Steps()
+
+	if len(actual) == len(expected) {
+		match := true
+		for i := range expected {
+			if actual[i] != expected[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	t.Errorf("message sequence mismatch\nexpected:\n%s\nactual:\n%s", formatSteps(expected), formatSteps(actual))
+	return false
+}
+
+func formatSteps(steps []SequenceStep) string {
+	if len(steps) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(steps))
+	for i, step := range steps {
+		lines[i] = fmt.Sprintf("  %d. %s", i+1, step)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+)
+
+// TraceSampling controls how many packets LogPacket actually logs.
+// The zero value logs every packet.
+type TraceSampling struct {
+	// Every logs 1 in N packets per direction/characteristic (0 or 1 logs all)
+	Every int
+	// EdgesOnly logs only the first and last packet of each multi-packet message
+	EdgesOnly bool
+}
+
+// ParseTraceSample parses a -trace-sample value: "" or "0" (log everything),
+// a positive integer N (log 1 in N packets), or "edges" (first/last packet only)
+func ParseTraceSample(value string) (TraceSampling, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return TraceSampling{}, nil
+	}
+	if value == "edges" {
+		return TraceSampling{EdgesOnly: true}, nil
+	}
+
+	every, err := strconv.Atoi(value)
+	if err != nil || every < 0 {
+		return TraceSampling{}, fmt.Errorf("invalid trace-sample: %q (must be a non-negative integer or 'edges')", value)
+	}
+	return TraceSampling{Every: every}, nil
+}
+
+// traceSampler tracks per-direction/characteristic packet counters
+type traceSampler struct {
+	sampling atomic.Value // TraceSampling
+	mutex    sync.Mutex
+	counters map[string]*uint64
+	inMsg    map[string]bool
+}
+
+var sampler = newTraceSampler()
+
+func newTraceSampler() *traceSampler {
+	s := &traceSampler{
+		counters: make(map[string]*uint64),
+		inMsg:    make(map[string]bool),
+	}
+	s.sampling.Store(TraceSampling{})
+	return s
+}
+
+// SetTraceSampling configures packet log sampling and resets all counters
+func SetTraceSampling(sampling TraceSampling) {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+
+	sampler.sampling.Store(sampling)
+	sampler.counters = make(map[string]*uint64)
+	sampler.inMsg = make(map[string]bool)
+}
+
+// shouldLog reports whether a packet should be logged under the current sampling
+func (s *traceSampler) shouldLog(direction string, charType bluetooth.CharacteristicType, remaining uint8) bool {
+	sampling := s.sampling.Load().(TraceSampling)
+	key := direction + "/" + charType.String()
+
+	if sampling.EdgesOnly {
+		return s.isEdge(key, remaining)
+	}
+	if sampling.Every <= 1 {
+		return true
+	}
+
+	count := atomic.AddUint64(s.counter(key), 1)
+	return (count-1)%uint64(sampling.Every) == 0
+}
+
+// counter returns the packet counter for a direction/characteristic key
+func (s *traceSampler) counter(key string) *uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok {
+		c = new(uint64)
+		s.counters[key] = c
+	}
+	return c
+}
+
+// isEdge reports whether a packet is the first or last of its message
+func (s *traceSampler) isEdge(key string, remaining uint8) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	first := !s.inMsg[key]
+	s.inMsg[key] = remaining != 0
+	return first || remaining == 0
+}
@@ -15,6 +15,7 @@ type PendingRequest struct {
 	Timestamp    time.Time
 	ResponseChan chan []byte
 	Timeout      time.Duration
+	timer        *time.Timer
 }
 
 // TransactionManager manages transaction IDs and pending requests
@@ -83,24 +84,20 @@ func (tm *TransactionManager) RegisterRequest(txID uint8, messageType string, re
 
 	log.Tracef("Registered pending request: txID=%d, messageType=%s", txID, messageType)
 
-	// Start timeout goroutine
-	go tm.handleTimeout(req)
+	// Time the request out unless it completes first. Stopping the timer on
+	// completion leaves nothing running for a completed request.
+	req.timer = time.AfterFunc(req.Timeout, func() { tm.handleTimeout(req) })
 
 	return nil
 }
 
 // handleTimeout handles request timeout
 func (tm *TransactionManager) handleTimeout(req *PendingRequest) {
-	timer := time.NewTimer(req.Timeout)
-	defer timer.Stop()
-
-	<-timer.C
-
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	// Check if request still exists
-	if _, exists := tm.pendingReqs[req.TxID]; exists {
+	if pending, exists := tm.pendingReqs[req.TxID]; exists && pending == req {
 		log.Warnf("Request timed out: txID=%d, messageType=%s, age=%v",
 			req.TxID, req.MessageType, time.Since(req.Timestamp))
 
@@ -134,6 +131,7 @@ func (tm *TransactionManager) CompleteRequest(txID uint8, response []byte) error
 	}
 
 	// Remove from pending
+	req.timer.Stop()
 	delete(tm.pendingReqs, txID)
 
 	return nil
@@ -146,6 +144,7 @@ func (tm *TransactionManager) CancelRequest(txID uint8) {
 
 	if req, exists := tm.pendingReqs[txID]; exists {
 		log.Debugf("Canceling request: txID=%d, messageType=%s", txID, req.MessageType)
+		req.timer.Stop()
 		close(req.ResponseChan)
 		delete(tm.pendingReqs, txID)
 	}
@@ -166,6 +165,7 @@ func (tm *TransactionManager) ClearAll() {
 	defer tm.mutex.Unlock()
 
 	for txID, req := range tm.pendingReqs {
+		req.timer.Stop()
 		close(req.ResponseChan)
 		delete(tm.pendingReqs, txID)
 	}
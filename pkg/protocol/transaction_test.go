@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/testutil"
+)
+
+// TestCompletedRequestLeavesNoGoroutine verifies completing a request stops
+// its timeout instead of leaving a goroutine waiting out the full timeout
+func TestCompletedRequestLeavesNoGoroutine(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	tm := NewTransactionManager(time.Minute)
+
+	for txID := uint8(0); txID < 10; txID++ {
+		responses := make(chan []byte, 1)
+		if err := tm.RegisterRequest(txID, "CurrentStatus", responses); err != nil {
+			t.Fatalf("RegisterRequest failed: %v", err)
+		}
+		if err := tm.CompleteRequest(txID, []byte{txID}); err != nil {
+			t.Fatalf("CompleteRequest failed: %v", err)
+		}
+	}
+	tm.RegisterRequest(10, "CurrentStatus", make(chan []byte, 1))
+	tm.CancelRequest(10)
+}
+
+// TestRequestTimesOut verifies an uncompleted request is dropped and its
+// response channel closed once the timeout passes
+func TestRequestTimesOut(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	tm := NewTransactionManager(10 * time.Millisecond)
+	responses := make(chan []byte, 1)
+	if err := tm.RegisterRequest(1, "CurrentStatus", responses); err != nil {
+		t.Fatalf("RegisterRequest failed: %v", err)
+	}
+
+	select {
+	case _, ok := <-responses:
+		if ok {
+			t.Error("Expected the response channel to be closed, got a response")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request never timed out")
+	}
+	if _, exists := tm.GetPendingRequest(1); exists {
+		t.Error("Expected the timed out request to be dropped")
+	}
+}
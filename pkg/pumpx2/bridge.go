@@ -3,6 +3,7 @@ package pumpx2
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/jwoglom/faketandem/pkg/bluetooth"
@@ -17,19 +18,26 @@ type Bridge struct {
 	authKey        string
 	pairingCode    string
 	timeSinceReset uint32
+	invocations    *InvocationLog
+	parseFailures  *ParseFailures
 }
 
 // NewBridge creates a new pumpX2 cliparser bridge. If jarPath is non-empty, it is
 // used directly as the cliparser JAR, skipping gradle entirely regardless of mode.
 func NewBridge(pumpX2Path, mode, gradleCmd, javaCmd, jarPath string) (*Bridge, error) {
 	var runner Runner
+	invocations := NewInvocationLog(DefaultInvocationLogSize)
 
 	if mode == "gradle" {
 		log.Info("Using gradle mode for cliparser")
-		runner = NewGradleRunner(pumpX2Path, gradleCmd)
+		gradleRunner := NewGradleRunner(pumpX2Path, gradleCmd)
+		gradleRunner.SetInvocationLog(invocations)
+		runner = gradleRunner
 	} else if jarPath != "" {
 		log.Infof("Using prebuilt cliparser JAR: %s", jarPath)
-		runner = NewJarRunner(jarPath, javaCmd)
+		jarRunner := NewJarRunner(jarPath, javaCmd)
+		jarRunner.SetInvocationLog(invocations)
+		runner = jarRunner
 	} else {
 		log.Info("Using JAR mode for cliparser")
 		// Build/find the cliparser JAR
@@ -38,16 +46,58 @@ func NewBridge(pumpX2Path, mode, gradleCmd, javaCmd, jarPath string) (*Bridge, e
 			return nil, fmt.Errorf("failed to initialize cliparser JAR: %w", err)
 		}
 		log.Infof("Using cliparser JAR: %s", builtJarPath)
-		runner = NewJarRunner(builtJarPath, javaCmd)
+		jarRunner := NewJarRunner(builtJarPath, javaCmd)
+		jarRunner.SetInvocationLog(invocations)
+		runner = jarRunner
 	}
 
 	return &Bridge{
 		runner:         runner,
 		mode:           mode,
 		timeSinceReset: 0, // Will be updated as needed
+		invocations:    invocations,
+		parseFailures:  NewParseFailures(DefaultParseFailureLogSize),
 	}, nil
 }
 
+// NewBridgeWithRunner creates a bridge backed by an arbitrary Runner, e.g. a
+// fake that returns canned cliparser output in tests
+func NewBridgeWithRunner(runner Runner) *Bridge {
+	return &Bridge{
+		runner:        runner,
+		mode:          "custom",
+		parseFailures: NewParseFailures(DefaultParseFailureLogSize),
+	}
+}
+
+// RecentInvocations returns the raw output of the bridge's most recent
+// cliparser runs, oldest first. Bridges built with NewBridgeWithRunner don't
+// run cliparser and return nil.
+func (b *Bridge) RecentInvocations() []Invocation {
+	if b.invocations == nil {
+		return nil
+	}
+	return b.invocations.Recent()
+}
+
+// ParseFailureStats returns how many received messages failed to parse, by
+// characteristic, and the raw fragments of the most recent ones
+func (b *Bridge) ParseFailureStats() ParseFailureStats {
+	if b.parseFailures == nil {
+		return ParseFailureStats{ByCharacteristic: map[string]int{}}
+	}
+	return b.parseFailures.Stats()
+}
+
+// Close stops the bridge's runner if it holds a process open. Runners that
+// start a process per message have nothing to close.
+func (b *Bridge) Close() error {
+	if closer, ok := b.runner.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // SetAuthenticationKey sets the authentication key for signing messages
 func (b *Bridge) SetAuthenticationKey(key string) {
 	b.authKey = key
@@ -65,6 +115,16 @@ func (b *Bridge) SetTimeSinceReset(seconds uint32) {
 	b.timeSinceReset = seconds
 }
 
+// ParseReceivedMessage parses a message received from a client like
+// ParseMessage, counting it towards ParseFailureStats if it can't be parsed
+func (b *Bridge) ParseReceivedMessage(charType bluetooth.CharacteristicType, rawPacketsHex []string) (*ParsedMessage, error) {
+	msg, err := b.ParseMessage(charType, rawPacketsHex)
+	if err != nil && b.parseFailures != nil {
+		b.parseFailures.Record(charType, rawPacketsHex, err)
+	}
+	return msg, err
+}
+
 // ParseMessage parses a message from its raw BLE fragments into a structured
 // format. rawPacketsHex must be the original, unstripped fragment bytes
 // (including framing) in receive order -- see PacketBuffer.RawPacketsHex.
@@ -0,0 +1,92 @@
+package pumpx2
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInvocationLogSize is how many cliparser invocations a bridge keeps
+const DefaultInvocationLogSize = 50
+
+// Invocation is the raw result of one cliparser subprocess run
+type Invocation struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	ExitCode int       `json:"exitCode"` // -1 if the process couldn't be started
+}
+
+// InvocationLog is a fixed-size ring buffer of recent cliparser invocations,
+// kept so odd-but-successful output can be inspected after the fact
+type InvocationLog struct {
+	entries []Invocation
+	next    int
+	full    bool
+	mutex   sync.Mutex
+}
+
+// NewInvocationLog creates a log holding the last capacity invocations
+func NewInvocationLog(capacity int) *InvocationLog {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &InvocationLog{entries: make([]Invocation, capacity)}
+}
+
+// Add records an invocation, overwriting the oldest once full
+func (l *InvocationLog) Add(inv Invocation) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries[l.next] = inv
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns the retained invocations, oldest first
+func (l *InvocationLog) Recent() []Invocation {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.full {
+		return append([]Invocation(nil), l.entries[:l.next]...)
+	}
+	recent := make([]Invocation, 0, len(l.entries))
+	recent = append(recent, l.entries[l.next:]...)
+	return append(recent, l.entries[:l.next]...)
+}
+
+// runCommand runs cmd, recording its raw output in invocations if non-nil
+func runCommand(cmd *exec.Cmd, invocations *InvocationLog) (stdout, stderr string, err error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+
+	err = cmd.Run()
+
+	if invocations != nil {
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			exitCode = -1
+		}
+		invocations.Add(Invocation{
+			Time:     time.Now(),
+			Command:  strings.Join(cmd.Args, " "),
+			Stdout:   stdoutBuf.String(),
+			Stderr:   stderrBuf.String(),
+			ExitCode: exitCode,
+		})
+	}
+
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
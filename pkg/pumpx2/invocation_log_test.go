@@ -0,0 +1,62 @@
+package pumpx2
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+// TestInvocationLogRetainsRecent verifies invocations are returned oldest
+// first before the log fills
+func TestInvocationLogRetainsRecent(t *testing.T) {
+	l := NewInvocationLog(3)
+	if got := l.Recent(); len(got) != 0 {
+		t.Fatalf("Expected empty log, got %v", got)
+	}
+
+	l.Add(Invocation{Command: "parse 1"})
+	l.Add(Invocation{Command: "encode 2"})
+
+	got := l.Recent()
+	if len(got) != 2 || got[0].Command != "parse 1" || got[1].Command != "encode 2" {
+		t.Errorf("Expected [parse 1, encode 2], got %v", got)
+	}
+}
+
+// TestInvocationLogWrapsAtCapacity verifies the oldest invocations are
+// dropped once the log is full
+func TestInvocationLogWrapsAtCapacity(t *testing.T) {
+	l := NewInvocationLog(3)
+	for i := 1; i <= 5; i++ {
+		l.Add(Invocation{Command: fmt.Sprintf("cmd %d", i)})
+	}
+
+	got := l.Recent()
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 invocations, got %d", len(got))
+	}
+	for i, want := range []string{"cmd 3", "cmd 4", "cmd 5"} {
+		if got[i].Command != want {
+			t.Errorf("Invocation %d: expected %q, got %q", i, want, got[i].Command)
+		}
+	}
+}
+
+// TestRunCommandRecordsOutputAndExitCode verifies a failed subprocess is
+// recorded with its stdout, stderr and exit code
+func TestRunCommandRecordsOutputAndExitCode(t *testing.T) {
+	l := NewInvocationLog(2)
+	cmd := exec.Command("sh", "-c", "echo out; echo err >&2; exit 3")
+
+	if _, _, err := runCommand(cmd, l); err == nil {
+		t.Fatal("Expected error from non-zero exit")
+	}
+
+	got := l.Recent()
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 invocation, got %d", len(got))
+	}
+	if got[0].Stdout != "out\n" || got[0].Stderr != "err\n" || got[0].ExitCode != 3 {
+		t.Errorf("Unexpected invocation: %+v", got[0])
+	}
+}
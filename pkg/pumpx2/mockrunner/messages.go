@@ -0,0 +1,197 @@
+package mockrunner
+
+// fieldKind is the wire encoding of a single cargo field
+type fieldKind int
+
+const (
+	kindUint8 fieldKind = iota
+	kindUint16
+	kindUint32
+	kindBool
+	kindBytes8  // fixed-length byte array, e.g. an HMAC key
+	kindBytes20 // fixed-length byte array, e.g. a SHA-1 challenge hash
+)
+
+// size returns the number of cargo bytes a field of this kind occupies
+func (k fieldKind) size() int {
+	switch k {
+	case kindUint16:
+		return 2
+	case kindUint32:
+		return 4
+	case kindBytes8:
+		return 8
+	case kindBytes20:
+		return 20
+	default:
+		return 1
+	}
+}
+
+// isBytes returns true if fields of this kind hold a byte array rather than
+// a number
+func (k fieldKind) isBytes() bool {
+	return k == kindBytes8 || k == kindBytes20
+}
+
+// field is a named little-endian cargo field
+type field struct {
+	name string
+	kind fieldKind
+}
+
+// message describes the opcode, characteristic and cargo layout of a
+// curated pumpX2 message
+type message struct {
+	name           string
+	opcode         int8
+	characteristic string // pumpX2 Characteristic enum constant name
+	pkg            string // pumpX2 messages subpackage, for the parse output FQCN
+	fields         []field
+}
+
+// cargoSize returns the total size of the message's cargo in bytes
+func (m *message) cargoSize() int {
+	size := 0
+	for _, f := range m.fields {
+		size += f.kind.size()
+	}
+	return size
+}
+
+// messages is the curated set of messages the mock runner can encode and
+// parse. Opcodes match pumpX2; cargo layouts follow the same field order as
+// each message's pumpX2 constructor, packed without pumpX2's padding bytes.
+var messages = []*message{
+	{name: "CentralChallengeRequest", opcode: 16, characteristic: "AUTHORIZATION", pkg: "request.authentication", fields: []field{
+		{"appInstanceId", kindUint16},
+		{"centralChallenge", kindBytes8},
+	}},
+	{name: "CentralChallengeResponse", opcode: 17, characteristic: "AUTHORIZATION", pkg: "response.authentication", fields: []field{
+		{"appInstanceId", kindUint16},
+		{"centralChallengeHash", kindBytes20},
+		{"hmacKey", kindBytes8},
+	}},
+	{name: "PumpChallengeRequest", opcode: 18, characteristic: "AUTHORIZATION", pkg: "request.authentication", fields: []field{
+		{"appInstanceId", kindUint16},
+		{"pumpChallengeHash", kindBytes20},
+	}},
+	{name: "PumpChallengeResponse", opcode: 19, characteristic: "AUTHORIZATION", pkg: "response.authentication", fields: []field{
+		{"appInstanceId", kindUint16},
+		{"success", kindBool},
+	}},
+	{name: "ApiVersionRequest", opcode: 32, characteristic: "CURRENT_STATUS", pkg: "request.currentStatus"},
+	{name: "ApiVersionResponse", opcode: 33, characteristic: "CURRENT_STATUS", pkg: "response.currentStatus", fields: []field{
+		{"majorVersion", kindUint16},
+		{"minorVersion", kindUint16},
+	}},
+	{name: "CurrentBasalStatusRequest", opcode: 40, characteristic: "CURRENT_STATUS", pkg: "request.currentStatus"},
+	{name: "CurrentBasalStatusResponse", opcode: 41, characteristic: "CURRENT_STATUS", pkg: "response.currentStatus", fields: []field{
+		{"profileBasalRate", kindUint32},
+		{"currentBasalRate", kindUint32},
+		{"basalModifiedBitmask", kindUint8},
+	}},
+	{name: "CurrentBolusStatusRequest", opcode: 44, characteristic: "CURRENT_STATUS", pkg: "request.currentStatus"},
+	{name: "CurrentBolusStatusResponse", opcode: 45, characteristic: "CURRENT_STATUS", pkg: "response.currentStatus", fields: []field{
+		{"statusId", kindUint8},
+		{"bolusId", kindUint16},
+		{"timestamp", kindUint32},
+		{"requestedVolume", kindUint32},
+		{"bolusSourceId", kindUint8},
+		{"bolusTypeBitmask", kindUint8},
+	}},
+	{name: "TimeSinceResetRequest", opcode: 54, characteristic: "CURRENT_STATUS", pkg: "request.currentStatus"},
+	{name: "TimeSinceResetResponse", opcode: 55, characteristic: "CURRENT_STATUS", pkg: "response.currentStatus", fields: []field{
+		{"currentTime", kindUint32},
+		{"pumpTimeSinceReset", kindUint32},
+	}},
+	{name: "ErrorResponse", opcode: 77, characteristic: "CURRENT_STATUS", pkg: "response.currentStatus", fields: []field{
+		{"requestCodeId", kindUint8},
+		{"errorCode", kindUint8},
+	}},
+	{name: "BolusCalcDataSnapshotRequest", opcode: 114, characteristic: "CURRENT_STATUS", pkg: "request.currentStatus"},
+	{name: "BolusCalcDataSnapshotResponse", opcode: 115, characteristic: "CURRENT_STATUS", pkg: "response.currentStatus", fields: []field{
+		{"isUnacked", kindBool},
+		{"correctionFactor", kindUint16},
+		{"iob", kindUint32},
+		{"cartridgeRemainingInsulin", kindUint16},
+		{"targetBg", kindUint16},
+		{"isf", kindUint16},
+		{"carbEntryEnabled", kindBool},
+		{"carbRatio", kindUint32},
+		{"maxBolusAmount", kindUint16},
+		{"maxBolusHourlyTotal", kindUint32},
+		{"maxBolusEventsExceeded", kindBool},
+		{"maxIobEventsExceeded", kindBool},
+		{"isAutopopAllowed", kindBool},
+	}},
+	{name: "InitiateBolusRequest", opcode: -98, characteristic: "CONTROL", pkg: "request.control", fields: []field{
+		{"totalVolume", kindUint32},
+		{"bolusID", kindUint16},
+		{"bolusTypeBitmask", kindUint8},
+		{"foodVolume", kindUint32},
+		{"correctionVolume", kindUint32},
+		{"bolusCarbs", kindUint16},
+		{"bolusBG", kindUint16},
+		{"bolusIOB", kindUint32},
+		{"extendedVolume", kindUint32},
+		{"extendedSeconds", kindUint32},
+		{"extended3", kindUint32},
+	}},
+	{name: "InitiateBolusResponse", opcode: -97, characteristic: "CONTROL", pkg: "response.control", fields: []field{
+		{"status", kindUint8},
+		{"bolusId", kindUint16},
+		{"statusTypeId", kindUint8},
+	}},
+	{name: "CancelBolusRequest", opcode: -96, characteristic: "CONTROL", pkg: "request.control", fields: []field{
+		{"bolusId", kindUint32},
+	}},
+	{name: "CancelBolusResponse", opcode: -95, characteristic: "CONTROL", pkg: "response.control", fields: []field{
+		{"statusId", kindUint8},
+		{"bolusId", kindUint16},
+		{"reasonId", kindUint8},
+	}},
+	{name: "BolusPermissionRequest", opcode: -94, characteristic: "CONTROL", pkg: "request.control"},
+	{name: "BolusPermissionResponse", opcode: -93, characteristic: "CONTROL", pkg: "response.control", fields: []field{
+		{"status", kindUint8},
+		{"bolusId", kindUint16},
+		{"nackReasonId", kindUint8},
+	}},
+	{name: "BolusPermissionReleaseRequest", opcode: -16, characteristic: "CONTROL", pkg: "request.control", fields: []field{
+		{"bolusId", kindUint32},
+	}},
+	{name: "BolusPermissionReleaseResponse", opcode: -15, characteristic: "CONTROL", pkg: "response.control", fields: []field{
+		{"status", kindUint8},
+	}},
+}
+
+// messageByName returns the curated message with the given name, or nil
+func messageByName(name string) *message {
+	for _, m := range messages {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// parsePrecedence is the order cliparser's CharacteristicGuesser falls back
+// to when no characteristic is given for an ambiguous opcode
+var parsePrecedence = []string{"CONTROL", "AUTHORIZATION", "CURRENT_STATUS"}
+
+// messageByOpcode returns the curated message with the given opcode on
+// btChar. With an empty btChar it falls back to parsePrecedence.
+func messageByOpcode(btChar string, opcode int8) *message {
+	chars := parsePrecedence
+	if btChar != "" {
+		chars = []string{btChar}
+	}
+	for _, char := range chars {
+		for _, m := range messages {
+			if m.opcode == opcode && m.characteristic == char {
+				return m
+			}
+		}
+	}
+	return nil
+}
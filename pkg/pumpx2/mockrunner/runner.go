@@ -0,0 +1,265 @@
+// Package mockrunner provides a pure-Go pumpx2.Runner that encodes and parses
+// a curated set of pumpX2 messages without a JVM, so handler and router tests
+// can exercise real packet framing in CI.
+//
+// Messages are framed as pumpX2 does -- [opcode][txId][cargoSize][cargo]
+// followed by a little-endian CRC16 -- and split into [remaining][txId]
+// fragments via protocol.EncodePackets. Signed control messages are not
+// modeled: no HMAC trailer is added on encode or expected on parse.
+package mockrunner
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+)
+
+// fqcnPrefix is the Java package prefix of pumpX2's message classes
+const fqcnPrefix = "com.jwoglom.pumpx2.pump.messages."
+
+// Runner is a pumpx2.Runner backed by the curated message table
+type Runner struct{}
+
+var _ pumpx2.Runner = (*Runner)(nil)
+
+// New creates a new mock runner
+func New() *Runner {
+	return &Runner{}
+}
+
+// Supports returns true if messageName is in the curated message set
+func Supports(messageName string) bool {
+	return messageByName(messageName) != nil
+}
+
+// Encode builds messageName's cargo from params and returns JSON output in the
+// same shape as cliparser's "encode" command. Missing params encode as zero.
+func (r *Runner) Encode(txID int, messageName string, params map[string]interface{}) (string, error) {
+	m := messageByName(messageName)
+	if m == nil {
+		return "", fmt.Errorf("mockrunner: unsupported message %s", messageName)
+	}
+
+	cargo, err := encodeCargo(m, params)
+	if err != nil {
+		return "", fmt.Errorf("mockrunner: failed to encode %s: %w", messageName, err)
+	}
+
+	packets, err := protocol.EncodePackets(characteristicType(m.characteristic), uint8(m.opcode), uint8(txID), cargo)
+	if err != nil {
+		return "", fmt.Errorf("mockrunner: failed to packetize %s: %w", messageName, err)
+	}
+
+	packetsHex := make([]string, len(packets))
+	for i, p := range packets {
+		packetsHex[i] = hex.EncodeToString(p)
+	}
+
+	out, err := json.Marshal(map[string]interface{}{
+		"characteristic": m.characteristic,
+		"opcode":         m.opcode,
+		"packets":        packetsHex,
+	})
+	if err != nil {
+		return "", fmt.Errorf("mockrunner: failed to marshal output: %w", err)
+	}
+	return string(out), nil
+}
+
+// Parse decodes raw BLE fragments and returns output in the same shape as
+// cliparser's "parse" command: <opcode>\t<FQCN>\t<MessageName>[field=value,...]
+func (r *Runner) Parse(btChar string, rawPacketsHex []string) (string, error) {
+	frame, err := reassemble(rawPacketsHex)
+	if err != nil {
+		return "", fmt.Errorf("mockrunner: %w", err)
+	}
+
+	if len(frame) < 5 {
+		return "", fmt.Errorf("mockrunner: message too short: %d bytes", len(frame))
+	}
+	cargoSize := int(frame[2])
+	if len(frame) != 3+cargoSize+2 {
+		return "", fmt.Errorf("mockrunner: cargo size %d does not match message length %d", cargoSize, len(frame))
+	}
+	body := frame[:len(frame)-2]
+	if got, want := binary.LittleEndian.Uint16(frame[len(frame)-2:]), protocol.CRC16(body); got != want {
+		return "", fmt.Errorf("mockrunner: CRC mismatch: got 0x%04x, expected 0x%04x", got, want)
+	}
+
+	opcode := int8(frame[0])
+	m := messageByOpcode(btChar, opcode)
+	if m == nil {
+		return "", fmt.Errorf("mockrunner: unsupported opcode %d on %q", opcode, btChar)
+	}
+
+	values, err := decodeCargo(m, body[3:])
+	if err != nil {
+		return "", fmt.Errorf("mockrunner: failed to parse %s: %w", m.name, err)
+	}
+
+	return fmt.Sprintf("%d\t%s%s.%s\t%s[%s]\n", opcode, fqcnPrefix, m.pkg, m.name, m.name, strings.Join(values, ",")), nil
+}
+
+// reassemble strips the [remaining][txId] header from each fragment and
+// concatenates their payloads
+func reassemble(rawPacketsHex []string) ([]byte, error) {
+	if len(rawPacketsHex) == 0 {
+		return nil, fmt.Errorf("no fragments")
+	}
+
+	var frame []byte
+	for i, packetHex := range rawPacketsHex {
+		packet, err := hex.DecodeString(packetHex)
+		if err != nil {
+			return nil, fmt.Errorf("fragment %d is not valid hex: %w", i, err)
+		}
+		payload, err := protocol.GetPacketPayload(packet)
+		if err != nil {
+			return nil, fmt.Errorf("fragment %d: %w", i, err)
+		}
+		frame = append(frame, payload...)
+	}
+	return frame, nil
+}
+
+// encodeCargo packs params into m's little-endian cargo layout
+func encodeCargo(m *message, params map[string]interface{}) ([]byte, error) {
+	cargo := make([]byte, 0, m.cargoSize())
+	for _, f := range m.fields {
+		if f.kind.isBytes() {
+			value, err := toBytes(params[f.name], f.kind.size())
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			cargo = append(cargo, value...)
+			continue
+		}
+
+		value, err := toUint64(params[f.name])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		if bits := uint(f.kind.size() * 8); bits < 64 && value >= 1<<bits {
+			return nil, fmt.Errorf("field %s: value %d overflows %d bytes", f.name, value, f.kind.size())
+		}
+
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(value))
+		cargo = append(cargo, buf[:f.kind.size()]...)
+	}
+	return cargo, nil
+}
+
+// decodeCargo unpacks m's cargo into field=value strings in field order
+func decodeCargo(m *message, cargo []byte) ([]string, error) {
+	if len(cargo) != m.cargoSize() {
+		return nil, fmt.Errorf("expected %d cargo bytes, got %d", m.cargoSize(), len(cargo))
+	}
+
+	values := make([]string, 0, len(m.fields))
+	offset := 0
+	for _, f := range m.fields {
+		if f.kind.isBytes() {
+			values = append(values, fmt.Sprintf("%s=%s", f.name, formatBytes(cargo[offset:offset+f.kind.size()])))
+			offset += f.kind.size()
+			continue
+		}
+
+		buf := make([]byte, 4)
+		copy(buf, cargo[offset:offset+f.kind.size()])
+		offset += f.kind.size()
+
+		value := binary.LittleEndian.Uint32(buf)
+		if f.kind == kindBool {
+			values = append(values, fmt.Sprintf("%s=%v", f.name, value != 0))
+		} else {
+			values = append(values, fmt.Sprintf("%s=%d", f.name, value))
+		}
+	}
+	return values, nil
+}
+
+// formatBytes renders a byte array as pumpX2's toString() does, e.g. {1, -2}
+func formatBytes(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%d", int8(v))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// toBytes converts a hex string or byte slice param to a byte array of
+// exactly size bytes
+func toBytes(v interface{}, size int) ([]byte, error) {
+	var b []byte
+	switch n := v.(type) {
+	case nil:
+		return make([]byte, size), nil
+	case []byte:
+		b = n
+	case string:
+		decoded, err := hex.DecodeString(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex %q: %w", n, err)
+		}
+		b = decoded
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
+
+// toUint64 converts a JSON-style param value to an unsigned integer
+func toUint64(v interface{}) (uint64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	case int:
+		return signedToUint64(int64(n))
+	case int64:
+		return signedToUint64(n)
+	case uint32:
+		return uint64(n), nil
+	case uint64:
+		return n, nil
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("non-integer value %v", n)
+		}
+		return signedToUint64(int64(n))
+	default:
+		return 0, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// signedToUint64 rejects negative values, which no curated field supports
+func signedToUint64(n int64) (uint64, error) {
+	if n < 0 {
+		return 0, fmt.Errorf("negative value %d", n)
+	}
+	return uint64(n), nil
+}
+
+// characteristicType maps a pumpX2 Characteristic enum constant name back to
+// a CharacteristicType, for chunk sizing
+func characteristicType(btChar string) bluetooth.CharacteristicType {
+	if charType, ok := bluetooth.ParseBtChar(btChar); ok {
+		return charType
+	}
+	return bluetooth.CharCurrentStatus
+}
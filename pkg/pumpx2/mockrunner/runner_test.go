@@ -0,0 +1,249 @@
+package mockrunner
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+	"github.com/jwoglom/faketandem/pkg/pumpx2"
+)
+
+// roundTripCases holds sample params for every curated message
+var roundTripCases = map[string]map[string]interface{}{
+	"CentralChallengeRequest": {"appInstanceId": 7, "centralChallenge": "0102030405060708"},
+	"CentralChallengeResponse": {
+		"appInstanceId": 7, "centralChallengeHash": "00112233445566778899aabbccddeeff00112233", "hmacKey": "f0e1d2c3b4a59687",
+	},
+	"PumpChallengeRequest":      {"appInstanceId": 7, "pumpChallengeHash": "ffeeddccbbaa99887766554433221100ffeeddcc"},
+	"PumpChallengeResponse":     {"appInstanceId": 7, "success": true},
+	"ApiVersionRequest":         {},
+	"ApiVersionResponse":        {"majorVersion": 2, "minorVersion": 5},
+	"CurrentBasalStatusRequest": {},
+	"CurrentBasalStatusResponse": {
+		"profileBasalRate": 800, "currentBasalRate": 1600, "basalModifiedBitmask": 1,
+	},
+	"CurrentBolusStatusRequest": {},
+	"CurrentBolusStatusResponse": {
+		"statusId": 1, "bolusId": 1234, "timestamp": int64(1700000000),
+		"requestedVolume": 2500, "bolusSourceId": 7, "bolusTypeBitmask": 0,
+	},
+	"TimeSinceResetRequest":        {},
+	"TimeSinceResetResponse":       {"currentTime": int64(1700000000), "pumpTimeSinceReset": uint32(86400)},
+	"ErrorResponse":                {"requestCodeId": 100, "errorCode": 1},
+	"BolusCalcDataSnapshotRequest": {},
+	"BolusCalcDataSnapshotResponse": {
+		"isUnacked": false, "correctionFactor": 50, "iob": int64(1250),
+		"cartridgeRemainingInsulin": 20000, "targetBg": 100, "isf": 50,
+		"carbEntryEnabled": true, "carbRatio": int64(12000), "maxBolusAmount": 25000,
+		"maxBolusHourlyTotal": int64(25000), "maxBolusEventsExceeded": false,
+		"maxIobEventsExceeded": false, "isAutopopAllowed": true,
+	},
+	"InitiateBolusRequest": {
+		"totalVolume": 3000, "bolusID": 42, "bolusTypeBitmask": 8, "foodVolume": 2000,
+		"correctionVolume": 1000, "bolusCarbs": 30, "bolusBG": 180, "bolusIOB": 500,
+	},
+	"InitiateBolusResponse":          {"status": 0, "bolusId": uint32(42), "statusTypeId": 0},
+	"CancelBolusRequest":             {"bolusId": 42.0},
+	"CancelBolusResponse":            {"statusId": 0, "bolusId": uint32(42), "reasonId": 0},
+	"BolusPermissionRequest":         {},
+	"BolusPermissionResponse":        {"status": 0, "bolusId": uint32(43), "nackReasonId": 0},
+	"BolusPermissionReleaseRequest":  {"bolusId": 43},
+	"BolusPermissionReleaseResponse": {"status": 0},
+}
+
+// charTypeFromBtChar maps a pumpX2 characteristic name back for ParseMessage
+func charTypeFromBtChar(t *testing.T, btChar string) bluetooth.CharacteristicType {
+	t.Helper()
+	for _, c := range []bluetooth.CharacteristicType{bluetooth.CharCurrentStatus, bluetooth.CharControl, bluetooth.CharAuthorization} {
+		if c.ToBtChar() == btChar {
+			return c
+		}
+	}
+	t.Fatalf("unexpected characteristic %q", btChar)
+	return 0
+}
+
+// TestRoundTripCuratedMessages encodes every curated message through a bridge
+// and parses its packets back, checking the message type, txID and cargo
+func TestRoundTripCuratedMessages(t *testing.T) {
+	bridge := pumpx2.NewBridgeWithRunner(New())
+
+	for _, m := range messages {
+		params, ok := roundTripCases[m.name]
+		if !ok {
+			t.Errorf("No round trip case for %s", m.name)
+			continue
+		}
+
+		encoded, err := bridge.EncodeMessage(7, m.name, params)
+		if err != nil {
+			t.Fatalf("EncodeMessage(%s) failed: %v", m.name, err)
+		}
+		if encoded.Opcode != int(m.opcode) {
+			t.Errorf("%s: expected opcode %d, got %d", m.name, m.opcode, encoded.Opcode)
+		}
+
+		parsed, err := bridge.ParseMessage(charTypeFromBtChar(t, encoded.Characteristic), encoded.Packets)
+		if err != nil {
+			t.Fatalf("ParseMessage(%s) failed: %v", m.name, err)
+		}
+		if parsed.MessageType != m.name || parsed.TxID != 7 || parsed.Opcode != int(m.opcode) {
+			t.Errorf("%s: parsed as %s txID=%d opcode=%d", m.name, parsed.MessageType, parsed.TxID, parsed.Opcode)
+		}
+		if len(parsed.Cargo) != len(m.fields) {
+			t.Errorf("%s: expected %d cargo fields, got %d", m.name, len(m.fields), len(parsed.Cargo))
+		}
+		for _, f := range m.fields {
+			assertFieldEqual(t, m.name, f, params[f.name], parsed.Cargo[f.name])
+		}
+	}
+}
+
+// assertFieldEqual compares an encoded param against its parsed cargo value
+func assertFieldEqual(t *testing.T, messageName string, f field, sent, parsed interface{}) {
+	t.Helper()
+	if f.kind == kindBool {
+		want, _ := sent.(bool)
+		if parsed != want {
+			t.Errorf("%s.%s: expected %v, got %v", messageName, f.name, want, parsed)
+		}
+		return
+	}
+	if f.kind.isBytes() {
+		if parsed != sent {
+			t.Errorf("%s.%s: expected %v, got %v", messageName, f.name, sent, parsed)
+		}
+		return
+	}
+
+	want, err := toUint64(sent)
+	if err != nil {
+		t.Fatalf("%s.%s: bad test param: %v", messageName, f.name, err)
+	}
+	got, ok := parsed.(int)
+	if !ok || uint64(got) != want {
+		t.Errorf("%s.%s: expected %d, got %v (%T)", messageName, f.name, want, parsed, parsed)
+	}
+}
+
+// TestEncodeSplitsLongMessages verifies messages longer than one chunk are
+// fragmented with descending remaining counts
+func TestEncodeSplitsLongMessages(t *testing.T) {
+	bridge := pumpx2.NewBridgeWithRunner(New())
+	encoded, err := bridge.EncodeMessage(3, "InitiateBolusRequest", roundTripCases["InitiateBolusRequest"])
+	if err != nil {
+		t.Fatalf("EncodeMessage failed: %v", err)
+	}
+
+	// 3 header + 35 cargo + 2 CRC bytes, 16 payload bytes per Control chunk
+	if len(encoded.Packets) != 3 {
+		t.Fatalf("Expected 3 packets, got %d", len(encoded.Packets))
+	}
+	for i, p := range encoded.Packets {
+		want := hex.EncodeToString([]byte{byte(2 - i), 3})
+		if !strings.HasPrefix(p, want) {
+			t.Errorf("Packet %d: expected header %s, got %s", i, want, p[:4])
+		}
+	}
+}
+
+// TestParseRejectsCorruptedCRC verifies a flipped cargo byte fails the CRC check
+func TestParseRejectsCorruptedCRC(t *testing.T) {
+	bridge := pumpx2.NewBridgeWithRunner(New())
+	encoded, err := bridge.EncodeMessage(1, "ApiVersionResponse", roundTripCases["ApiVersionResponse"])
+	if err != nil {
+		t.Fatalf("EncodeMessage failed: %v", err)
+	}
+
+	packet, _ := hex.DecodeString(encoded.Packets[0])
+	packet[5] ^= 0xff
+	_, err = New().Parse("CURRENT_STATUS", []string{hex.EncodeToString(packet)})
+	if err == nil || !strings.Contains(err.Error(), "CRC mismatch") {
+		t.Errorf("Expected CRC mismatch error, got %v", err)
+	}
+}
+
+// realJpake1aRawFragments are the raw Authorization fragments of a Jpake1a
+// request (txId=4) captured from a real Tandem Mobi + official app pairing
+// attempt, ending in its CRC 0xaac9 sent little-endian as c9 aa
+var realJpake1aRawFragments = []string{
+	"09042004a70000410477521493da112577faa707",
+	"0804c9c92a68e4b40cc46df17b306f52f32631af",
+	"0704cd88d27a74f8ba9401e9aea18bcdb6f2c678",
+	"06043cd475269208b03b9c7fa5c7a342eacaed41",
+	"050404ec21fa73c0b5c2984d842a22a2db1df426",
+	"0404a2793811949552a69108f8f10aad6c8f8c22",
+	"0304cc3c9443848a1833f425b6cbef4658b2a86d",
+	"02049b162b0b6c645e1d2993d920c143c44c4b68",
+	"01047dd833cb7888682f66da86e0f0eb0b3abb59",
+	"0004135c704fbbd824ecc9aa",
+}
+
+// TestParseAcceptsRealCaptureCRC verifies a real app's message passes the
+// framing and CRC checks, and that reframing its cargo reproduces it byte for
+// byte. Jpake1aRequest isn't curated, so Parse stops at its opcode.
+func TestParseAcceptsRealCaptureCRC(t *testing.T) {
+	_, err := New().Parse("AUTHORIZATION", realJpake1aRawFragments)
+	if err == nil || !strings.Contains(err.Error(), "unsupported opcode 32") {
+		t.Errorf("Expected only the opcode to be unsupported, got %v", err)
+	}
+
+	frame, err := reassemble(realJpake1aRawFragments)
+	if err != nil {
+		t.Fatalf("reassemble failed: %v", err)
+	}
+	reframed, err := protocol.FrameMessage(frame[0], frame[1], frame[3:len(frame)-2])
+	if err != nil {
+		t.Fatalf("FrameMessage failed: %v", err)
+	}
+	if !bytes.Equal(reframed, frame) {
+		t.Errorf("Expected reframing to reproduce the capture\n got %x\nwant %x", reframed, frame)
+	}
+}
+
+// TestParseUsesCharacteristicToDisambiguate verifies an opcode only known on
+// one characteristic isn't resolved on another
+func TestParseUsesCharacteristicToDisambiguate(t *testing.T) {
+	bridge := pumpx2.NewBridgeWithRunner(New())
+	encoded, err := bridge.EncodeMessage(1, "ApiVersionRequest", nil)
+	if err != nil {
+		t.Fatalf("EncodeMessage failed: %v", err)
+	}
+
+	if _, err := New().Parse("AUTHORIZATION", encoded.Packets); err == nil {
+		t.Error("Expected opcode 32 on AUTHORIZATION to be unsupported")
+	}
+	output, err := New().Parse("", encoded.Packets)
+	if err != nil || !strings.Contains(output, "ApiVersionRequest[") {
+		t.Errorf("Expected fallback precedence to find ApiVersionRequest, got %q (%v)", output, err)
+	}
+}
+
+// TestEncodeErrors verifies unsupported messages and out of range values fail
+func TestEncodeErrors(t *testing.T) {
+	r := New()
+	if _, err := r.Encode(1, "Jpake1aRequest", nil); err == nil {
+		t.Error("Expected error for unsupported message")
+	}
+	if _, err := r.Encode(1, "ApiVersionResponse", map[string]interface{}{"majorVersion": 70000}); err == nil {
+		t.Error("Expected error for uint16 overflow")
+	}
+	if _, err := r.Encode(1, "CancelBolusRequest", map[string]interface{}{"bolusId": -1}); err == nil {
+		t.Error("Expected error for negative value")
+	}
+	if _, err := r.Encode(1, "CancelBolusRequest", map[string]interface{}{"bolusId": "1"}); err == nil {
+		t.Error("Expected error for string value")
+	}
+	if _, err := r.Encode(1, "CentralChallengeRequest", map[string]interface{}{"centralChallenge": "0102"}); err == nil {
+		t.Error("Expected error for short byte array")
+	}
+	if _, err := r.Encode(1, "CentralChallengeRequest", map[string]interface{}{"centralChallenge": "zz"}); err == nil {
+		t.Error("Expected error for invalid hex")
+	}
+	if !Supports("TimeSinceResetResponse") || Supports("Jpake1aRequest") {
+		t.Error("Supports does not match the curated message set")
+	}
+}
@@ -1,7 +1,9 @@
 package pumpx2
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"strconv"
 	"strings"
 )
@@ -26,8 +28,105 @@ func opcodeAndTxIDFromFirstFragment(rawPacketsHex []string) (opcode int, txID in
 	return int(int8(b[2])), int(b[3]), true
 }
 
+// cliparserJSONMetaKeys are the fields of cliparser's JSON output that
+// describe the message rather than belong to its cargo
+var cliparserJSONMetaKeys = map[string]bool{
+	"name":           true,
+	"messageName":    true,
+	"message":        true,
+	"params":         true,
+	"cargo":          true,
+	"opCode":         true,
+	"opcode":         true,
+	"txId":           true,
+	"characteristic": true,
+	"type":           true,
+}
+
 // parseCliparserOutput extracts the message name and cargo fields from the
-// cliparser "parse" command's stdout. The real shape varies by how many
+// cliparser "parse" command's stdout. JSON output (ReadResp mode) is
+// preferred; the toString() dump below is scraped only if the output isn't
+// JSON.
+func parseCliparserOutput(output string) (messageName string, cargo map[string]interface{}) {
+	if messageName, cargo, ok := parseCliparserJSON(output); ok {
+		return messageName, cargo
+	}
+	return parseCliparserText(output)
+}
+
+// parseCliparserJSON extracts the message name and cargo fields from
+// cliparser's JSON output, e.g.:
+//
+//	{"name":"InitiateBolusRequest","opCode":-98,"params":{"totalVolume":1500,...}}
+//
+// The JSON may follow the same leading tab-separated fields as the text
+// output. If it has no "params" (or "cargo") object, every field that isn't
+// message metadata is cargo. Numbers are converted the same way as scraped
+// field values, so handlers see an int or float64 regardless of the format.
+func parseCliparserJSON(output string) (messageName string, cargo map[string]interface{}, ok bool) {
+	tail := output
+	if idx := strings.LastIndex(output, "\t"); idx != -1 {
+		tail = output[idx+1:]
+	}
+	tail = strings.TrimSpace(tail)
+	if !strings.HasPrefix(tail, "{") {
+		return "", nil, false
+	}
+
+	var result map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(tail)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return "", nil, false
+	}
+
+	for _, key := range []string{"name", "messageName", "message"} {
+		if name, ok := result[key].(string); ok && name != "" {
+			messageName = name
+			break
+		}
+	}
+
+	fields, ok := result["params"].(map[string]interface{})
+	if !ok {
+		fields, ok = result["cargo"].(map[string]interface{})
+	}
+	if !ok {
+		fields = make(map[string]interface{})
+		for key, value := range result {
+			if !cliparserJSONMetaKeys[key] {
+				fields[key] = value
+			}
+		}
+	}
+
+	cargo = make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		cargo[key] = normalizeJSONValue(value)
+	}
+	return messageName, cargo, true
+}
+
+// normalizeJSONValue converts the json.Numbers in a decoded JSON value to an
+// int or float64, as parseFieldValue does for scraped values
+func normalizeJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return parseFieldValue(v.String())
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = normalizeJSONValue(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = normalizeJSONValue(elem)
+		}
+	}
+	return value
+}
+
+// parseCliparserText extracts the message name and cargo fields from the
+// cliparser "parse" command's toString() output. The real shape varies by how many
 // leading tab-separated fields precede the message dump:
 //
 //	<opcode>\t<FullyQualifiedClassName>\t<MessageName>[<field>=<value>,...]
@@ -42,7 +141,7 @@ func opcodeAndTxIDFromFirstFragment(rawPacketsHex []string) (opcode int, txID in
 // split on the last tab rather than the first -- splitting on the first tab
 // left the FQCN+tab+MessageName glued together as a single bogus "message
 // name" for single-fragment messages, which have no leading error field.
-func parseCliparserOutput(output string) (messageName string, cargo map[string]interface{}) {
+func parseCliparserText(output string) (messageName string, cargo map[string]interface{}) {
 	cargo = make(map[string]interface{})
 
 	tail := output
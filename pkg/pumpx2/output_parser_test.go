@@ -70,6 +70,40 @@ func TestParseCliparserOutput_NoFields(t *testing.T) {
 	}
 }
 
+func TestParseCliparserOutput_JSONInitiateBolusRequest(t *testing.T) {
+	// cliparser JSON (ReadResp mode) output for a 1.5 U bolus
+	output := "-98\tcom.jwoglom.pumpx2.pump.messages.request.control.InitiateBolusRequest\t" +
+		`{"name":"InitiateBolusRequest","opCode":-98,"txId":12,"params":{"totalVolume":1500,"bolusID":10650,` +
+		`"bolusTypeBitmask":8,"foodVolume":1500,"correctionVolume":0,"bolusCarbs":15,"bolusBG":0,"bolusIOB":0.25}}`
+
+	name, cargo := parseCliparserOutput(output)
+	if name != "InitiateBolusRequest" {
+		t.Errorf("expected message name InitiateBolusRequest, got %q", name)
+	}
+	if cargo["totalVolume"] != 1500 {
+		t.Errorf("expected totalVolume=1500, got %v (%T)", cargo["totalVolume"], cargo["totalVolume"])
+	}
+	if cargo["bolusID"] != 10650 {
+		t.Errorf("expected bolusID=10650, got %v", cargo["bolusID"])
+	}
+	if cargo["bolusIOB"] != 0.25 {
+		t.Errorf("expected bolusIOB=0.25, got %v", cargo["bolusIOB"])
+	}
+	if _, ok := cargo["opCode"]; ok {
+		t.Errorf("expected message metadata to be left out of cargo, got %v", cargo)
+	}
+}
+
+func TestParseCliparserOutput_JSONWithoutParams(t *testing.T) {
+	name, cargo := parseCliparserOutput(`{"messageName":"SetTempRateRequest","opCode":-92,"percentage":50,"minutes":30}`)
+	if name != "SetTempRateRequest" {
+		t.Errorf("expected SetTempRateRequest, got %q", name)
+	}
+	if len(cargo) != 2 || cargo["percentage"] != 50 || cargo["minutes"] != 30 {
+		t.Errorf("expected percentage and minutes cargo, got %v", cargo)
+	}
+}
+
 func TestParseFieldValue(t *testing.T) {
 	cases := []struct {
 		in   string
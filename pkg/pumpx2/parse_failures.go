@@ -0,0 +1,100 @@
+package pumpx2
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+)
+
+// DefaultParseFailureLogSize is how many failing inputs a bridge keeps
+const DefaultParseFailureLogSize = 20
+
+// ParseFailure is one received message the bridge couldn't parse
+type ParseFailure struct {
+	Time           time.Time `json:"time"`
+	Characteristic string    `json:"characteristic"`
+	Hex            []string  `json:"hex"`
+	Error          string    `json:"error"`
+}
+
+// ParseFailureStats summarizes a ParseFailures tracker
+type ParseFailureStats struct {
+	Total            int            `json:"total"`
+	ByCharacteristic map[string]int `json:"byCharacteristic"`
+	Recent           []ParseFailure `json:"recent"`
+}
+
+// ParseFailures counts received messages that failed to parse, by
+// characteristic, and keeps the raw fragments of the last few so a client
+// sending something the emulator can't decode can be spotted
+type ParseFailures struct {
+	counts  map[string]int
+	total   int
+	entries []ParseFailure
+	next    int
+	full    bool
+	mutex   sync.Mutex
+}
+
+// NewParseFailures creates a tracker retaining the last capacity failures
+func NewParseFailures(capacity int) *ParseFailures {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ParseFailures{
+		counts:  make(map[string]int),
+		entries: make([]ParseFailure, capacity),
+	}
+}
+
+// Record counts a failure to parse rawPacketsHex on charType. Fragments
+// received on the Authorization characteristic carry key material, so only
+// their lengths are kept.
+func (p *ParseFailures) Record(charType bluetooth.CharacteristicType, rawPacketsHex []string, err error) {
+	fragments := append([]string(nil), rawPacketsHex...)
+	if charType == bluetooth.CharAuthorization {
+		for i, fragment := range fragments {
+			fragments[i] = protocol.RedactHex(fragment)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.counts[charType.String()]++
+	p.total++
+	p.entries[p.next] = ParseFailure{
+		Time:           time.Now(),
+		Characteristic: charType.String(),
+		Hex:            fragments,
+		Error:          err.Error(),
+	}
+	p.next = (p.next + 1) % len(p.entries)
+	if p.next == 0 {
+		p.full = true
+	}
+}
+
+// Stats returns the failure counts and the retained failures, oldest first
+func (p *ParseFailures) Stats() ParseFailureStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	counts := make(map[string]int, len(p.counts))
+	for char, n := range p.counts {
+		counts[char] = n
+	}
+
+	var recent []ParseFailure
+	if !p.full {
+		recent = append([]ParseFailure{}, p.entries[:p.next]...)
+	} else {
+		recent = make([]ParseFailure, 0, len(p.entries))
+		recent = append(recent, p.entries[p.next:]...)
+		recent = append(recent, p.entries[:p.next]...)
+	}
+
+	return ParseFailureStats{Total: p.total, ByCharacteristic: counts, Recent: recent}
+}
@@ -0,0 +1,94 @@
+package pumpx2
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+)
+
+// TestParseFailuresCountsByCharacteristic verifies failures are counted per
+// characteristic and keep the offending hex
+func TestParseFailuresCountsByCharacteristic(t *testing.T) {
+	p := NewParseFailures(5)
+	p.Record(bluetooth.CharCurrentStatus, []string{"0001fe01"}, errors.New("bad"))
+	p.Record(bluetooth.CharCurrentStatus, []string{"0002fe01"}, errors.New("bad"))
+	p.Record(bluetooth.CharControl, []string{"0003fe01"}, errors.New("worse"))
+
+	stats := p.Stats()
+	if stats.Total != 3 {
+		t.Errorf("Expected 3 failures, got %d", stats.Total)
+	}
+	if stats.ByCharacteristic[bluetooth.CharCurrentStatus.String()] != 2 || stats.ByCharacteristic[bluetooth.CharControl.String()] != 1 {
+		t.Errorf("Unexpected counts: %v", stats.ByCharacteristic)
+	}
+	if len(stats.Recent) != 3 || stats.Recent[2].Hex[0] != "0003fe01" || stats.Recent[2].Error != "worse" {
+		t.Errorf("Unexpected recent failures: %+v", stats.Recent)
+	}
+}
+
+// TestParseFailuresWrapsAtCapacity verifies only the last failures' hex is
+// kept while the counts keep growing
+func TestParseFailuresWrapsAtCapacity(t *testing.T) {
+	p := NewParseFailures(2)
+	for i := 1; i <= 4; i++ {
+		p.Record(bluetooth.CharControl, []string{fmt.Sprintf("%02x", i)}, errors.New("bad"))
+	}
+
+	stats := p.Stats()
+	if stats.Total != 4 || stats.ByCharacteristic[bluetooth.CharControl.String()] != 4 {
+		t.Errorf("Expected 4 failures counted, got %+v", stats)
+	}
+	if len(stats.Recent) != 2 || stats.Recent[0].Hex[0] != "03" || stats.Recent[1].Hex[0] != "04" {
+		t.Errorf("Expected the last two failures, got %+v", stats.Recent)
+	}
+}
+
+// TestParseReceivedMessageRecordsFailure verifies an unparseable received
+// message is counted with its hex, while ParseMessage alone isn't counted
+func TestParseReceivedMessageRecordsFailure(t *testing.T) {
+	bridge := NewUnavailableBridge(errors.New("cliparser jar not found"), nil)
+
+	if _, err := bridge.ParseMessage(bluetooth.CharControl, []string{"00012001"}); err == nil {
+		t.Fatal("Expected ParseMessage to fail")
+	}
+	if stats := bridge.ParseFailureStats(); stats.Total != 0 {
+		t.Errorf("Expected ParseMessage not to be counted, got %+v", stats)
+	}
+
+	if _, err := bridge.ParseReceivedMessage(bluetooth.CharControl, []string{"00012001"}); err == nil {
+		t.Fatal("Expected ParseReceivedMessage to fail")
+	}
+	stats := bridge.ParseFailureStats()
+	if stats.ByCharacteristic[bluetooth.CharControl.String()] != 1 {
+		t.Errorf("Expected one Control failure, got %v", stats.ByCharacteristic)
+	}
+	if len(stats.Recent) != 1 || stats.Recent[0].Hex[0] != "00012001" {
+		t.Errorf("Expected the failing hex to be recorded, got %+v", stats.Recent)
+	}
+}
+
+// TestParseFailuresRedactsAuthorization verifies failures on Authorization
+// are counted without keeping their key material
+func TestParseFailuresRedactsAuthorization(t *testing.T) {
+	p := NewParseFailures(5)
+	p.Record(bluetooth.CharAuthorization, []string{"0001200102"}, errors.New("bad"))
+
+	stats := p.Stats()
+	if stats.ByCharacteristic[bluetooth.CharAuthorization.String()] != 1 {
+		t.Errorf("Expected one Authorization failure, got %v", stats.ByCharacteristic)
+	}
+	if len(stats.Recent) != 1 || stats.Recent[0].Hex[0] != "<redacted 5 bytes>" {
+		t.Errorf("Expected the Authorization hex to be redacted, got %+v", stats.Recent)
+	}
+}
+
+// TestZeroValueBridgeParseFailureStats verifies a bridge built without a
+// constructor reports no failures rather than panicking
+func TestZeroValueBridgeParseFailureStats(t *testing.T) {
+	var bridge Bridge
+	if stats := bridge.ParseFailureStats(); stats.Total != 0 {
+		t.Errorf("Expected no failures, got %+v", stats)
+	}
+}
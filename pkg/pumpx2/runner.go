@@ -1,7 +1,6 @@
 package pumpx2
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -9,9 +8,46 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+
 	log "github.com/sirupsen/logrus"
 )
 
+// logFragments returns space-separated fragment hex for logging, redacted
+// like other authentication payloads if btChar is the Authorization
+// characteristic
+func logFragments(btChar, hexValue string) string {
+	if btChar == bluetooth.CharAuthorization.ToBtChar() {
+		return protocol.LogAuthHex(strings.Replace(hexValue, " ", "", -1))
+	}
+	return hexValue
+}
+
+// logParseOutput returns cliparser's parse output for logging, redacted if
+// it decoded fragments received on the Authorization characteristic
+func logParseOutput(btChar, output string) string {
+	if btChar == bluetooth.CharAuthorization.ToBtChar() {
+		return protocol.LogAuthText(output)
+	}
+	return output
+}
+
+// logEncode returns an encode command's args or output for logging,
+// redacted if messageName is an authentication message
+func logEncode(messageName, text string) string {
+	if isAuthMessage(messageName) {
+		return protocol.LogAuthText(text)
+	}
+	return text
+}
+
+// isAuthMessage returns true for the JPAKE and challenge messages sent on the
+// Authorization characteristic, which carry key material
+func isAuthMessage(messageName string) bool {
+	return strings.HasPrefix(messageName, "Jpake") || strings.Contains(messageName, "Challenge")
+}
+
 // parseEnv returns the environment for a cliparser "parse" subprocess. When
 // btChar is non-empty it sets PUMPX2_CHARACTERISTIC, which cliparser's
 // CharacteristicGuesser reads to disambiguate an opcode that maps to more
@@ -40,8 +76,9 @@ type Runner interface {
 
 // GradleRunner executes cliparser via gradle
 type GradleRunner struct {
-	pumpX2Path string
-	gradleCmd  string
+	pumpX2Path  string
+	gradleCmd   string
+	invocations *InvocationLog
 }
 
 // NewGradleRunner creates a new gradle runner
@@ -52,6 +89,11 @@ func NewGradleRunner(pumpX2Path, gradleCmd string) *GradleRunner {
 	}
 }
 
+// SetInvocationLog records each cliparser run's raw output in l
+func (r *GradleRunner) SetInvocationLog(l *InvocationLog) {
+	r.invocations = l
+}
+
 // Parse parses a message using gradle cliparser. btChar identifies the
 // characteristic the raw fragments were received on -- see parseEnv.
 func (r *GradleRunner) Parse(btChar string, rawPacketsHex []string) (string, error) {
@@ -66,18 +108,13 @@ func (r *GradleRunner) Parse(btChar string, rawPacketsHex []string) (string, err
 	cmd.Dir = r.pumpX2Path
 	cmd.Env = parseEnv(btChar)
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	log.Tracef("Executing gradle parse: btChar=%s, fragments=%s", btChar, hexValue)
+	log.Tracef("Executing gradle parse: btChar=%s, fragments=%s", btChar, logFragments(btChar, hexValue))
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("gradle parse failed: %w\nStderr: %s", err, stderr.String())
+	output, stderr, err := runCommand(cmd, r.invocations)
+	if err != nil {
+		return "", fmt.Errorf("gradle parse failed: %w\nStderr: %s", err, stderr)
 	}
-
-	output := stdout.String()
-	log.Tracef("Gradle parse output: %s", output)
+	log.Tracef("Gradle parse output: %s", logParseOutput(btChar, output))
 
 	return output, nil
 }
@@ -103,26 +140,22 @@ func (r *GradleRunner) Encode(txID int, messageName string, params map[string]in
 	cmd := exec.Command(gradlePath, "cliparser", "-q", "--console=plain", "--args="+args)
 	cmd.Dir = r.pumpX2Path
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	log.Tracef("Executing gradle encode: %s", args)
+	log.Tracef("Executing gradle encode: %s", logEncode(messageName, args))
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("gradle encode failed: %w\nStderr: %s", err, stderr.String())
+	output, stderr, err := runCommand(cmd, r.invocations)
+	if err != nil {
+		return "", fmt.Errorf("gradle encode failed: %w\nStderr: %s", err, stderr)
 	}
-
-	output := stdout.String()
-	log.Tracef("Gradle encode output: %s", output)
+	log.Tracef("Gradle encode output: %s", logEncode(messageName, output))
 
 	return output, nil
 }
 
 // JarRunner executes cliparser via JAR file
 type JarRunner struct {
-	jarPath string
-	javaCmd string
+	jarPath     string
+	javaCmd     string
+	invocations *InvocationLog
 }
 
 // NewJarRunner creates a new JAR runner
@@ -133,6 +166,11 @@ func NewJarRunner(jarPath, javaCmd string) *JarRunner {
 	}
 }
 
+// SetInvocationLog records each cliparser run's raw output in l
+func (r *JarRunner) SetInvocationLog(l *InvocationLog) {
+	r.invocations = l
+}
+
 // Parse parses a message using JAR cliparser. btChar identifies the
 // characteristic the raw fragments were received on -- see parseEnv.
 func (r *JarRunner) Parse(btChar string, rawPacketsHex []string) (string, error) {
@@ -145,18 +183,13 @@ func (r *JarRunner) Parse(btChar string, rawPacketsHex []string) (string, error)
 	cmd := exec.Command(r.javaCmd, args...)
 	cmd.Env = parseEnv(btChar)
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	log.Tracef("Executing JAR parse: %s", hexValue)
+	log.Tracef("Executing JAR parse: %s", logFragments(btChar, hexValue))
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("JAR parse failed: %w\nStderr: %s", err, stderr.String())
+	output, stderr, err := runCommand(cmd, r.invocations)
+	if err != nil {
+		return "", fmt.Errorf("JAR parse failed: %w\nStderr: %s", err, stderr)
 	}
-
-	output := stdout.String()
-	log.Tracef("JAR parse output: %s", output)
+	log.Tracef("JAR parse output: %s", logParseOutput(btChar, output))
 
 	return output, nil
 }
@@ -181,18 +214,13 @@ func (r *JarRunner) Encode(txID int, messageName string, params map[string]inter
 
 	cmd := exec.Command(r.javaCmd, args...)
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	log.Tracef("Executing JAR encode: %s %s", strings.Join(args, " "), "")
+	log.Tracef("Executing JAR encode: %s", logEncode(messageName, strings.Join(args, " ")))
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("JAR encode failed: %w\nStderr: %s", err, stderr.String())
+	output, stderr, err := runCommand(cmd, r.invocations)
+	if err != nil {
+		return "", fmt.Errorf("JAR encode failed: %w\nStderr: %s", err, stderr)
 	}
-
-	output := stdout.String()
-	log.Tracef("JAR encode output: %s", output)
+	log.Tracef("JAR encode output: %s", logEncode(messageName, output))
 
 	return output, nil
 }
@@ -0,0 +1,38 @@
+package pumpx2
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jwoglom/faketandem/pkg/bluetooth"
+	"github.com/jwoglom/faketandem/pkg/protocol"
+)
+
+// TestRunnerLogsRedactAuthMessages verifies cliparser commands and output
+// for authentication messages are logged by length only, unless redaction is
+// off, while other messages are logged in full
+func TestRunnerLogsRedactAuthMessages(t *testing.T) {
+	const output = `{"centralChallengeHash":"deadbeef"}`
+
+	if got := logEncode("Jpake1aResponse", output); strings.Contains(got, "deadbeef") {
+		t.Errorf("Expected JPAKE encode output to be redacted, got %q", got)
+	}
+	if got := logEncode("CentralChallengeResponse", output); strings.Contains(got, "deadbeef") {
+		t.Errorf("Expected challenge encode output to be redacted, got %q", got)
+	}
+	if got := logParseOutput(bluetooth.CharAuthorization.ToBtChar(), output); strings.Contains(got, "deadbeef") {
+		t.Errorf("Expected Authorization parse output to be redacted, got %q", got)
+	}
+	if got := logEncode("ApiVersionResponse", output); got != output {
+		t.Errorf("Expected other encode output in full, got %q", got)
+	}
+	if got := logParseOutput(bluetooth.CharControl.ToBtChar(), output); got != output {
+		t.Errorf("Expected Control parse output in full, got %q", got)
+	}
+
+	protocol.SetRedactAuth(false)
+	defer protocol.SetRedactAuth(true)
+	if got := logEncode("Jpake1aResponse", output); got != output {
+		t.Errorf("Expected JPAKE encode output in full with redaction off, got %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package pumpx2
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBridgeUnavailable is returned for messages that need the pumpX2
+// cliparser when the bridge could not be initialized
+var ErrBridgeUnavailable = errors.New("pumpX2 bridge unavailable")
+
+// unavailableRunner stands in for cliparser when it couldn't be set up. Each
+// call is tried on fallback, if any, and otherwise fails with
+// ErrBridgeUnavailable.
+type unavailableRunner struct {
+	cause    error
+	fallback Runner
+}
+
+func (r *unavailableRunner) unavailable(op string, err error) error {
+	if err != nil {
+		log.Debugf("pumpX2 bridge unavailable; fallback %s failed: %v", op, err)
+	}
+	return fmt.Errorf("%w: %v", ErrBridgeUnavailable, r.cause)
+}
+
+func (r *unavailableRunner) Parse(btChar string, rawPacketsHex []string) (string, error) {
+	if r.fallback == nil {
+		return "", r.unavailable("parse", nil)
+	}
+	output, err := r.fallback.Parse(btChar, rawPacketsHex)
+	if err != nil {
+		return "", r.unavailable("parse", err)
+	}
+	return output, nil
+}
+
+func (r *unavailableRunner) Encode(txID int, messageName string, params map[string]interface{}) (string, error) {
+	if r.fallback == nil {
+		return "", r.unavailable("encode", nil)
+	}
+	output, err := r.fallback.Encode(txID, messageName, params)
+	if err != nil {
+		return "", r.unavailable("encode", err)
+	}
+	return output, nil
+}
+
+// NewUnavailableBridge creates a bridge for running without pumpX2 after
+// NewBridge failed with cause. Messages fallback can handle, e.g. a
+// mockrunner's curated set, still work; everything else fails with an error
+// wrapping ErrBridgeUnavailable. fallback may be nil.
+func NewUnavailableBridge(cause error, fallback Runner) *Bridge {
+	return &Bridge{
+		runner:        &unavailableRunner{cause: cause, fallback: fallback},
+		mode:          "unavailable",
+		parseFailures: NewParseFailures(DefaultParseFailureLogSize),
+	}
+}
+
+// Available returns false for a bridge created with NewUnavailableBridge
+func (b *Bridge) Available() bool {
+	return b.mode != "unavailable"
+}
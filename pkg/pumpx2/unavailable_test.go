@@ -0,0 +1,24 @@
+package pumpx2
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestUnavailableBridgeWrapsCause verifies a bridge without a fallback fails
+// parse and encode with ErrBridgeUnavailable naming why it is unavailable
+func TestUnavailableBridgeWrapsCause(t *testing.T) {
+	bridge := NewUnavailableBridge(errors.New("cliparser jar not found"), nil)
+	if bridge.Available() {
+		t.Error("Expected the bridge to report itself unavailable")
+	}
+
+	_, err := bridge.EncodeMessage(1, "ApiVersionResponse", nil)
+	if !errors.Is(err, ErrBridgeUnavailable) || !strings.Contains(err.Error(), "cliparser jar not found") {
+		t.Errorf("Expected ErrBridgeUnavailable with its cause, got %v", err)
+	}
+	if _, err := bridge.ParseMessage(0, []string{"00012001"}); !errors.Is(err, ErrBridgeUnavailable) {
+		t.Errorf("Expected ErrBridgeUnavailable, got %v", err)
+	}
+}
@@ -208,24 +208,6 @@ func registerPumpInfoDefaults(manager *Manager) {
 		"pumpFeaturesBitmask":   0,
 	})
 
-	// PumpVersionResponse's real constructor takes 10 fields (long armSwVer,
-	// long mspSwVer, long configABits, long configBBits, long serialNum, long
-	// partNum, String pumpRev, long pcbaSN, String pcbaRev, long modelNum) --
-	// values below are a real captured Tandem Mobi PumpVersionResponse from
-	// pumpX2's own test fixtures (PumpVersionResponseTest.testPumpVersionResponse_Mobi).
-	registerConstant(manager, "PumpVersionRequest", map[string]interface{}{
-		"armSwVer":    int64(3628697757),
-		"mspSwVer":    0,
-		"configABits": 0,
-		"configBBits": 0,
-		"serialNum":   1226976,
-		"partNum":     1013045,
-		"pumpRev":     "0",
-		"pcbaSN":      232700077,
-		"pcbaRev":     "0",
-		"modelNum":    1004000,
-	})
-
 	// BleSoftwareInfoResponse(int softDeviceId, int softDeviceMajorVersion,
 	// int softDeviceMinorVersion, int softDeviceBugfixVersion, long softDeviceVersion,
 	// int softDeviceSubVersion)
@@ -441,40 +423,6 @@ func registerBolusAndBasalDefaults(manager *Manager) {
 
 // registerGlobalSettingsDefaults registers defaults for global pump settings messages
 func registerGlobalSettingsDefaults(manager *Manager) {
-	// GlobalMaxBolusSettingsResponse(int maxBolus, int maxBolusDefault)
-	registerConstant(manager, "GlobalMaxBolusSettingsRequest", map[string]interface{}{
-		"maxBolus":        2500, // 25.0 units * 100
-		"maxBolusDefault": 2500,
-	})
-
-	// BasalLimitSettingsResponse(long basalLimit, long basalLimitDefault)
-	registerConstant(manager, "BasalLimitSettingsRequest", map[string]interface{}{
-		"basalLimit":        500, // 5.0 U/hr * 100
-		"basalLimitDefault": 500,
-	})
-
-	// LocalizationResponse(int glucoseUOM, int languageSelected, int regionSetting,
-	// long languagesAvailableBitmask)
-	registerConstant(manager, "LocalizationRequest", map[string]interface{}{
-		"glucoseUOM":                0, // mg/dL
-		"languageSelected":          0, // English
-		"regionSetting":             0,
-		"languagesAvailableBitmask": 1,
-	})
-
-	// PumpSettingsResponse(int lowInsulinThreshold, int cannulaPrimeSize,
-	// int autoShutdownEnabled, int autoShutdownDuration, int featureLock,
-	// int oledTimeout, int status)
-	registerConstant(manager, "PumpSettingsRequest", map[string]interface{}{
-		"lowInsulinThreshold":  20,
-		"cannulaPrimeSize":     0,
-		"autoShutdownEnabled":  0,
-		"autoShutdownDuration": 0,
-		"featureLock":          0,
-		"oledTimeout":          0,
-		"status":               0,
-	})
-
 	// SendTipsControlGenericTestResponse(int status)
 	registerConstant(manager, "SendTipsControlGenericTestRequest", map[string]interface{}{
 		"status": 0,
@@ -522,29 +470,6 @@ func registerAdditionalStatusDefaults(manager *Manager) {
 		"currentIsf":             50,
 	})
 
-	// PumpFeaturesV1Response's real constructor takes a BigInteger, but it has
-	// an ambiguous 1-arg raw byte[] constructor that cliparser's constructor
-	// lookup resolves to first -- pass raw cargo bytes instead (size=8).
-	registerConstant(manager, "PumpFeaturesV1Request", map[string]interface{}{
-		"raw": "0504000000000000",
-	})
-
-	// PumpVersionBResponse(String softwareName, long configurationBitsA,
-	// long configurationBitsB, long serialNumber, long modelNumber,
-	// String pumpRevision, long pcbPartNumberA, long pcbSerialNumberA,
-	// String pcbRevisionNumberA)
-	registerConstant(manager, "PumpVersionBRequest", map[string]interface{}{
-		"softwareName":       "1.1.1",
-		"configurationBitsA": 0,
-		"configurationBitsB": 0,
-		"serialNumber":       123456,
-		"modelNumber":        1000,
-		"pumpRevision":       "A",
-		"pcbPartNumberA":     0,
-		"pcbSerialNumberA":   0,
-		"pcbRevisionNumberA": "A",
-	})
-
 	// CgmStatusV2Response(int sessionStateId, long lastCalibrationTimestamp,
 	// long sensorStartedTimestamp, int transmitterBatteryStatusId,
 	// long sessionDurationSeconds, long sessionTimeRemainingSeconds,
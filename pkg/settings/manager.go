@@ -1,8 +1,10 @@
 package settings
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -21,8 +23,46 @@ const (
 
 	// ModeTimeBased returns values based on elapsed time since first request
 	ModeTimeBased ResponseMode = "time_based"
+
+	// ModeEcho sends the request back unchanged, with the same txID, for
+	// clients that send a ping on a message type and expect it echoed
+	ModeEcho ResponseMode = "echo"
+
+	// ModeEncoded sends pre-encoded packets verbatim, without the pumpX2
+	// bridge. The packets keep the txID they were encoded with.
+	ModeEncoded ResponseMode = "encoded"
+
+	// ModeError always answers with an ErrorResponse carrying ErrorCode,
+	// to test a client's handling of NACKs
+	ModeError ResponseMode = "error"
+
+	// ModeProbabilistic answers with an ErrorResponse carrying ErrorCode a
+	// fraction ErrorProbability of the time, and Value otherwise
+	ModeProbabilistic ResponseMode = "probabilistic"
 )
 
+// maxErrorCode is the largest errorCode an ErrorResponse can carry
+const maxErrorCode = 255
+
+// ErrorResponse returns the response GetResponse gives for a configured
+// error, which handlers encode as an ErrorResponse with errorCode
+func ErrorResponse(errorCode int) map[string]interface{} {
+	return map[string]interface{}{
+		"status":    "error",
+		"errorCode": errorCode,
+	}
+}
+
+// ErrorCode returns the errorCode of a response from GetResponse, and
+// whether it is an error at all
+func ErrorCode(response map[string]interface{}) (int, bool) {
+	if response["status"] != "error" {
+		return 0, false
+	}
+	errorCode, ok := response["errorCode"].(int)
+	return errorCode, ok
+}
+
 // ResponseConfig defines the configuration for a message type's response
 type ResponseConfig struct {
 	// Mode determines the response behavior
@@ -38,6 +78,17 @@ type ResponseConfig struct {
 	// Must match length of Values array
 	TimingSeconds []int `json:"timing_seconds,omitempty"`
 
+	// Packets is used for ModeEncoded - the response's raw packets, in hex
+	Packets []string `json:"packets,omitempty"`
+
+	// ErrorCode is used for ModeError and ModeProbabilistic - the
+	// ErrorResponse errorCode sent
+	ErrorCode int `json:"error_code,omitempty"`
+
+	// ErrorProbability is used for ModeProbabilistic - the fraction of
+	// requests, from 0 to 1, answered with an error instead of Value
+	ErrorProbability float64 `json:"error_probability,omitempty"`
+
 	// CurrentIndex tracks the current position (for ModeIncremental)
 	CurrentIndex int `json:"current_index,omitempty"`
 
@@ -48,6 +99,7 @@ type ResponseConfig struct {
 // Manager manages configurable settings responses
 type Manager struct {
 	configs map[string]*ResponseConfig
+	rng     *rand.Rand // decides ModeProbabilistic errors
 	mutex   sync.RWMutex
 }
 
@@ -55,9 +107,18 @@ type Manager struct {
 func NewManager() *Manager {
 	return &Manager{
 		configs: make(map[string]*ResponseConfig),
+		rng:     rand.New(rand.NewSource(1)),
 	}
 }
 
+// SetRand sets the generator that decides which ModeProbabilistic requests
+// get an error
+func (m *Manager) SetRand(rng *rand.Rand) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rng = rng
+}
+
 // RegisterDefault registers a default configuration for a message type
 func (m *Manager) RegisterDefault(messageType string, config *ResponseConfig) error {
 	m.mutex.Lock()
@@ -94,6 +155,18 @@ func (m *Manager) GetResponse(messageType string) (map[string]interface{}, error
 	case ModeTimeBased:
 		return m.getTimeBasedResponse(config)
 
+	case ModeEcho:
+		return nil, fmt.Errorf("echo mode has no configured response for %s", messageType)
+
+	case ModeEncoded:
+		return nil, fmt.Errorf("encoded mode has no response values for %s", messageType)
+
+	case ModeError:
+		return ErrorResponse(config.ErrorCode), nil
+
+	case ModeProbabilistic:
+		return m.getProbabilisticResponse(config)
+
 	default:
 		return nil, fmt.Errorf("unknown response mode: %s", config.Mode)
 	}
@@ -158,6 +231,16 @@ func (m *Manager) getTimeBasedResponse(config *ResponseConfig) (map[string]inter
 	return config.Values[valueIndex], nil
 }
 
+// getProbabilisticResponse returns an error a fraction ErrorProbability of
+// the time, and the constant value otherwise
+func (m *Manager) getProbabilisticResponse(config *ResponseConfig) (map[string]interface{}, error) {
+	if m.rng.Float64() < config.ErrorProbability {
+		log.Debugf("Probabilistic response: error (errorCode=%d)", config.ErrorCode)
+		return ErrorResponse(config.ErrorCode), nil
+	}
+	return m.getConstantResponse(config)
+}
+
 // SetConfig updates the configuration for a message type
 func (m *Manager) SetConfig(messageType string, config *ResponseConfig) error {
 	m.mutex.Lock()
@@ -177,6 +260,30 @@ func (m *Manager) SetConfig(messageType string, config *ResponseConfig) error {
 	return nil
 }
 
+// Echoes returns true if messageType is configured to echo requests back
+func (m *Manager) Echoes(messageType string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	config, exists := m.configs[messageType]
+	return exists && config.Mode == ModeEcho
+}
+
+// EncodedPackets returns the pre-encoded response packets for messageType,
+// if it is configured in encoded mode
+func (m *Manager) EncodedPackets(messageType string) ([]string, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	config, exists := m.configs[messageType]
+	if !exists || config.Mode != ModeEncoded {
+		return nil, false
+	}
+	packets := make([]string, len(config.Packets))
+	copy(packets, config.Packets)
+	return packets, true
+}
+
 // GetConfig retrieves the current configuration for a message type
 func (m *Manager) GetConfig(messageType string) (*ResponseConfig, error) {
 	m.mutex.RLock()
@@ -277,20 +384,56 @@ func (m *Manager) validateConfig(config *ResponseConfig) error {
 			}
 		}
 
+	case ModeEcho:
+
+	case ModeEncoded:
+		if len(config.Packets) == 0 {
+			return fmt.Errorf("encoded mode requires non-empty 'packets' array")
+		}
+		for i, packet := range config.Packets {
+			if _, err := hex.DecodeString(packet); err != nil {
+				return fmt.Errorf("packet %d is not valid hex: %w", i, err)
+			}
+		}
+
+	case ModeError:
+		if config.ErrorCode < 0 || config.ErrorCode > maxErrorCode {
+			return fmt.Errorf("error_code must be between 0 and %d", maxErrorCode)
+		}
+
+	case ModeProbabilistic:
+		if config.Value == nil {
+			return fmt.Errorf("probabilistic mode requires 'value' field")
+		}
+		if config.ErrorCode < 0 || config.ErrorCode > maxErrorCode {
+			return fmt.Errorf("error_code must be between 0 and %d", maxErrorCode)
+		}
+		if config.ErrorProbability < 0 || config.ErrorProbability > 1 {
+			return fmt.Errorf("error_probability must be between 0 and 1")
+		}
+
 	default:
-		return fmt.Errorf("unknown response mode: %s (valid modes: constant, incremental, time_based)", config.Mode)
+		return fmt.Errorf("unknown response mode: %s (valid modes: constant, incremental, time_based, echo, encoded, error, probabilistic)", config.Mode)
 	}
 
 	return nil
 }
 
-// MarshalJSON implements custom JSON marshaling to handle time formatting
+// MarshalJSON implements custom JSON marshaling to handle time formatting.
+// A constant mode value is always included, even when empty, so the config
+// unmarshals back into one that validates.
 func (c *ResponseConfig) MarshalJSON() ([]byte, error) {
 	type Alias ResponseConfig
+	var value *map[string]interface{}
+	if c.Value != nil || c.Mode == ModeConstant {
+		value = &c.Value
+	}
 	return json.Marshal(&struct {
-		StartTime string `json:"start_time,omitempty"`
+		StartTime string                  `json:"start_time,omitempty"`
+		Value     *map[string]interface{} `json:"value,omitempty"`
 		*Alias
 	}{
+		Value: value,
 		StartTime: func() string {
 			if c.StartTime.IsZero() {
 				return ""
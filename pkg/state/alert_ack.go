@@ -0,0 +1,113 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (p AlertPriority) String() string {
+	switch p {
+	case PriorityInfo:
+		return "info"
+	case PriorityWarning:
+		return "warning"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// AlertAutoAck is how long an alert of each priority stays active before the
+// simulator acknowledges it on the user's behalf. Priorities without a
+// timeout are never auto-acknowledged, and critical alerts never are.
+type AlertAutoAck map[AlertPriority]time.Duration
+
+// ParseAlertAutoAck parses an -alert-auto-ack value: "" (never auto-ack) or
+// comma-separated priority=duration pairs, e.g. "info=30s,warning=10m"
+func ParseAlertAutoAck(value string) (AlertAutoAck, error) {
+	timeouts := AlertAutoAck{}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return timeouts, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid alert-auto-ack entry: %q (must be priority=duration)", pair)
+		}
+		var priority AlertPriority
+		switch parts[0] {
+		case "info":
+			priority = PriorityInfo
+		case "warning":
+			priority = PriorityWarning
+		case "critical":
+			return nil, fmt.Errorf("invalid alert-auto-ack entry: %q (critical alerts are never auto-acknowledged)", pair)
+		default:
+			return nil, fmt.Errorf("invalid alert-auto-ack priority: %q (must be info or warning)", parts[0])
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid alert-auto-ack timeout: %q (must be a positive duration)", parts[1])
+		}
+		timeouts[priority] = timeout
+	}
+	return timeouts, nil
+}
+
+// SetAlertAutoAck makes the simulator acknowledge and prune alerts once they
+// are older than their priority's timeout. A condition that is still present
+// raises a new alert on the next update, like a pump reminder.
+func (s *Simulator) SetAlertAutoAck(timeouts AlertAutoAck) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.alertAutoAck = make(AlertAutoAck, len(timeouts))
+	for priority, timeout := range timeouts {
+		if priority == PriorityCritical {
+			log.Warnf("Ignoring alert auto-ack timeout for critical alerts")
+			continue
+		}
+		s.alertAutoAck[priority] = timeout
+	}
+}
+
+// autoAckAlerts prunes alerts older than their priority's timeout and
+// notifies that they were cleared once the pumpState mutex is released (must
+// hold pumpState mutex)
+func (s *Simulator) autoAckAlerts(timeouts AlertAutoAck) {
+	if len(timeouts) == 0 {
+		return
+	}
+
+	now := s.clock()
+	remaining := s.pumpState.ActiveAlerts[:0]
+	for _, alert := range s.pumpState.ActiveAlerts {
+		timeout, ok := timeouts[alert.Priority]
+		if ok && alert.Priority != PriorityCritical && now.Sub(alert.Timestamp) >= timeout {
+			log.Infof("Auto-acknowledging %s alert %d (%s) after %s", alert.Priority, alert.ID, alert.Message, timeout)
+			s.notifyAlertCleared(alert.ID)
+			continue
+		}
+		remaining = append(remaining, alert)
+	}
+	s.pumpState.ActiveAlerts = remaining
+}
+
+// notifyAlertCleared sends an alert cleared notification once the pumpState
+// mutex is released (must hold pumpState mutex)
+func (s *Simulator) notifyAlertCleared(alertID uint32) {
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
+	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyAlertCleared(alertID); err != nil {
+			log.Warnf("Failed to notify alert cleared: %v", err)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// alertNotifier records cleared alert IDs
+type alertNotifier struct {
+	NoOpEventNotifier
+	cleared []uint32
+}
+
+func (n *alertNotifier) NotifyAlertCleared(alertID uint32) error {
+	n.cleared = append(n.cleared, alertID)
+	return nil
+}
+
+// TestAlertAutoAckPerPriority verifies an info alert is acknowledged and
+// pruned once past its timeout while a critical alert persists
+func TestAlertAutoAckPerPriority(t *testing.T) {
+	ps := NewPumpState()
+	notifier := &alertNotifier{}
+	sim := NewSimulator(ps, time.Second)
+	sim.SetEventNotifier(notifier)
+	sim.SetAlertAutoAck(AlertAutoAck{PriorityInfo: 30 * time.Second, PriorityCritical: time.Second})
+
+	old := time.Now().Add(-time.Minute)
+	ps.AddAlert(Alert{ID: 1, Type: AlertBasalSuspended, Priority: PriorityInfo, Timestamp: old})
+	ps.AddAlert(Alert{ID: 2, Type: AlertOcclusion, Priority: PriorityCritical, Timestamp: old})
+	ps.AddAlert(Alert{ID: 3, Type: AlertCartridgeExpired, Priority: PriorityInfo, Timestamp: time.Now()})
+
+	sim.checkAlerts()
+
+	if len(notifier.cleared) != 1 || notifier.cleared[0] != 1 {
+		t.Errorf("Expected only info alert 1 to be cleared, got %v", notifier.cleared)
+	}
+	var remaining []uint32
+	for _, alert := range ps.ActiveAlerts {
+		remaining = append(remaining, alert.ID)
+	}
+	if len(remaining) != 2 || remaining[0] != 2 || remaining[1] != 3 {
+		t.Errorf("Expected critical alert and recent info alert to remain, got %v", remaining)
+	}
+}
+
+// stateReadingNotifier reads the pump state when an alert is cleared, as
+// the qualifying events notifier does
+type stateReadingNotifier struct {
+	NoOpEventNotifier
+	ps        *PumpState
+	remaining []int
+}
+
+func (n *stateReadingNotifier) NotifyAlertCleared(alertID uint32) error {
+	n.remaining = append(n.remaining, len(n.ps.GetActiveAlerts()))
+	return nil
+}
+
+// TestAlertAutoAckUsesSimulatorClock verifies alerts time out by the
+// simulator's clock, and are notified after the pump state is unlocked
+func TestAlertAutoAckUsesSimulatorClock(t *testing.T) {
+	ps := NewPumpState()
+	notifier := &stateReadingNotifier{ps: ps}
+	sim := NewSimulator(ps, time.Second)
+	sim.SetEventNotifier(notifier)
+	sim.SetAlertAutoAck(AlertAutoAck{PriorityInfo: 30 * time.Second})
+
+	raised := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := raised.Add(10 * time.Second)
+	sim.SetClock(func() time.Time { return now })
+	ps.AddAlert(Alert{Type: AlertBasalSuspended, Priority: PriorityInfo, Timestamp: raised})
+
+	sim.checkAlerts()
+	if len(notifier.remaining) != 0 {
+		t.Fatalf("Expected the alert to stay active before its timeout, got %d clear(s)", len(notifier.remaining))
+	}
+
+	now = raised.Add(time.Minute)
+	sim.checkAlerts()
+	if len(notifier.remaining) != 1 || notifier.remaining[0] != 0 {
+		t.Errorf("Expected one clear seen after the alert was pruned, got %v", notifier.remaining)
+	}
+}
+
+// TestParseAlertAutoAck verifies flag value parsing
+func TestParseAlertAutoAck(t *testing.T) {
+	timeouts, err := ParseAlertAutoAck("info=30s, warning=10m")
+	if err != nil {
+		t.Fatalf("ParseAlertAutoAck failed: %v", err)
+	}
+	if timeouts[PriorityInfo] != 30*time.Second || timeouts[PriorityWarning] != 10*time.Minute {
+		t.Errorf("Unexpected timeouts %v", timeouts)
+	}
+
+	for _, value := range []string{"critical=1m", "info", "info=-1s", "urgent=1m"} {
+		if _, err := ParseAlertAutoAck(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
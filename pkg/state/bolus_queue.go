@@ -0,0 +1,112 @@
+package state
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxStackedBoluses is how many boluses the pump tracks at once: the one
+// delivering plus those stacked behind it
+const MaxStackedBoluses = 3
+
+// startBolus starts b, or stacks it behind the delivering bolus. Stacked
+// boluses are delivered one at a time in the order they were started. (must
+// hold mutex)
+func (ps *PumpState) startBolus(b *BolusState, now time.Time) error {
+	b.Active = true
+	b.UnitsDelivered = 0
+	if !ps.Bolus.Active {
+		b.StartTime = now
+		*ps.Bolus = *b
+		log.Infof("Started bolus: %.2f units, ID=%d", b.UnitsTotal, b.BolusID)
+		return nil
+	}
+
+	if 1+len(ps.BolusQueue) >= MaxStackedBoluses {
+		return fmt.Errorf("cannot start bolus %d: %d boluses already active", b.BolusID, MaxStackedBoluses)
+	}
+	ps.BolusQueue = append(ps.BolusQueue, b)
+	log.Infof("Stacked bolus: %.2f units, ID=%d, behind %d active", b.UnitsTotal, b.BolusID, len(ps.BolusQueue))
+	return nil
+}
+
+// finishBolus ends the delivering bolus, recording it as the last bolus, and
+// starts the next stacked one, if any (must hold mutex)
+func (ps *PumpState) finishBolus(now time.Time) {
+	ps.Bolus.Active = false
+	last := *ps.Bolus
+	ps.LastBolus = &last
+	if len(ps.BolusQueue) == 0 {
+		return
+	}
+	next := ps.BolusQueue[0]
+	ps.BolusQueue = ps.BolusQueue[1:]
+	next.StartTime = now
+	*ps.Bolus = *next
+	log.Infof("Started stacked bolus: %.2f units, ID=%d", next.UnitsTotal, next.BolusID)
+}
+
+// CancelBolus cancels the active bolus with bolusID, or the delivering bolus
+// if bolusID is 0. Canceling the delivering bolus starts the next stacked
+// one. It returns the canceled bolus, and false if no such bolus was active.
+func (ps *PumpState) CancelBolus(bolusID uint32) (BolusState, bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if ps.Bolus.Active && (bolusID == 0 || ps.Bolus.BolusID == bolusID) {
+		canceled := *ps.Bolus
+		log.Infof("Canceled bolus %d: delivered %.2f of %.2f units",
+			canceled.BolusID, canceled.UnitsDelivered, canceled.UnitsTotal)
+		ps.finishBolus(time.Now())
+		return canceled, true
+	}
+	for i, queued := range ps.BolusQueue {
+		if queued.BolusID == bolusID {
+			ps.BolusQueue = append(ps.BolusQueue[:i:i], ps.BolusQueue[i+1:]...)
+			log.Infof("Canceled stacked bolus %d before delivery", bolusID)
+			return *queued, true
+		}
+	}
+	return BolusState{}, false
+}
+
+// GetActiveBoluses returns copies of the active boluses, the delivering one
+// first and then those stacked behind it in delivery order
+func (ps *PumpState) GetActiveBoluses() []BolusState {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.activeBoluses()
+}
+
+// activeBoluses returns copies of the active boluses (must hold mutex)
+func (ps *PumpState) activeBoluses() []BolusState {
+	if !ps.Bolus.Active {
+		return nil
+	}
+	boluses := []BolusState{*ps.Bolus}
+	for _, queued := range ps.BolusQueue {
+		boluses = append(boluses, *queued)
+	}
+	return boluses
+}
+
+// GetLastBolus returns a copy of the bolus that most recently finished or was
+// stopped, and false if none has
+func (ps *PumpState) GetLastBolus() (BolusState, bool) {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	if ps.LastBolus == nil {
+		return BolusState{}, false
+	}
+	return *ps.LastBolus, true
+}
+
+// CanStackBolus returns whether another bolus can be started now, i.e.
+// fewer than MaxStackedBoluses are active
+func (ps *PumpState) CanStackBolus() bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return !ps.Bolus.Active || 1+len(ps.BolusQueue) < MaxStackedBoluses
+}
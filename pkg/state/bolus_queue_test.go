@@ -0,0 +1,111 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// activeBolusIDs returns the IDs of ps's active boluses in delivery order
+func activeBolusIDs(ps *PumpState) []uint32 {
+	var ids []uint32
+	for _, bolus := range ps.GetActiveBoluses() {
+		ids = append(ids, bolus.BolusID)
+	}
+	return ids
+}
+
+// TestStackedBolusesDeliveredInOrder verifies a bolus started during another
+// is tracked behind it and only starts delivering once the first completes
+func TestStackedBolusesDeliveredInOrder(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	sim := NewSimulator(ps, time.Second)
+
+	if err := ps.StartBolus(1.0, 1); err != nil {
+		t.Fatalf("StartBolus(1) failed: %v", err)
+	}
+	if err := ps.StartBolus(0.5, 2); err != nil {
+		t.Fatalf("StartBolus(2) failed: %v", err)
+	}
+	if ids := activeBolusIDs(ps); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("Expected boluses [1 2] active, got %v", ids)
+	}
+
+	// Enough time for bolus 1 and then some; bolus 2 mustn't get the excess
+	ps.Bolus.StartTime = time.Now().Add(-30 * time.Second)
+	sim.Tick()
+	boluses := ps.GetActiveBoluses()
+	if len(boluses) != 1 || boluses[0].BolusID != 2 {
+		t.Fatalf("Expected only bolus 2 active after bolus 1 completed, got %+v", boluses)
+	}
+	if boluses[0].UnitsDelivered > 0.1 {
+		t.Errorf("Expected bolus 2 to just be starting, got %.2f units delivered", boluses[0].UnitsDelivered)
+	}
+	if last, ok := ps.GetLastBolus(); !ok || last.BolusID != 1 || last.UnitsDelivered < 1.0 {
+		t.Errorf("Expected bolus 1 to be the last bolus, fully delivered, got %+v", last)
+	}
+
+	ps.Bolus.StartTime = time.Now().Add(-20 * time.Second)
+	sim.Tick()
+	if ps.IsBolusActive() {
+		t.Errorf("Expected no bolus active, got %v", activeBolusIDs(ps))
+	}
+
+	var completed []interface{}
+	for _, entry := range ps.GetHistoryLogEntries(0, ^uint32(0)) {
+		if entry.TypeID == HistoryBolusCompleted {
+			completed = append(completed, entry.Data["bolusId"])
+		}
+	}
+	if len(completed) != 2 || completed[0] != uint32(1) || completed[1] != uint32(2) {
+		t.Errorf("Expected bolus 1 then 2 completed, got %v", completed)
+	}
+	if iob := ps.GetIOB(); iob < 1.49 || iob > 1.51 {
+		t.Errorf("Expected 1.5 units on board, got %.2f", iob)
+	}
+}
+
+// TestBolusStackLimit verifies no more than MaxStackedBoluses are tracked
+func TestBolusStackLimit(t *testing.T) {
+	ps := NewPumpState()
+	for id := uint32(1); id <= MaxStackedBoluses; id++ {
+		if err := ps.StartBolus(1.0, id); err != nil {
+			t.Fatalf("StartBolus(%d) failed: %v", id, err)
+		}
+	}
+	if ps.CanStackBolus() {
+		t.Error("Expected no room for another bolus")
+	}
+	if err := ps.StartBolus(1.0, 99); err == nil {
+		t.Error("Expected a bolus over the stack limit to fail")
+	}
+	if ids := activeBolusIDs(ps); len(ids) != MaxStackedBoluses {
+		t.Errorf("Expected %d active boluses, got %v", MaxStackedBoluses, ids)
+	}
+}
+
+// TestCancelStackedBolus verifies canceling a stacked bolus drops it without
+// touching the delivering one, and canceling the delivering one starts the next
+func TestCancelStackedBolus(t *testing.T) {
+	ps := NewPumpState()
+	ps.StartBolus(1.0, 1)
+	ps.StartBolus(2.0, 2)
+	ps.StartBolus(3.0, 3)
+
+	if canceled, ok := ps.CancelBolus(2); !ok || canceled.UnitsTotal != 2.0 {
+		t.Fatalf("Expected stacked bolus 2 canceled, got %+v (ok=%v)", canceled, ok)
+	}
+	if ids := activeBolusIDs(ps); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Fatalf("Expected boluses [1 3] active, got %v", ids)
+	}
+
+	if canceled, ok := ps.CancelBolus(0); !ok || canceled.BolusID != 1 {
+		t.Fatalf("Expected delivering bolus 1 canceled, got %+v (ok=%v)", canceled, ok)
+	}
+	if !ps.Bolus.Active || ps.Bolus.BolusID != 3 || ps.Bolus.UnitsDelivered != 0 {
+		t.Errorf("Expected bolus 3 delivering from the start, got %+v", ps.Bolus)
+	}
+	if _, ok := ps.CancelBolus(42); ok {
+		t.Error("Expected canceling an unknown bolus to fail")
+	}
+}
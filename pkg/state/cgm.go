@@ -0,0 +1,98 @@
+package state
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bounds of a simulated CGM reading (mg/dL), matching the Dexcom reporting range
+const (
+	cgmMinEGV = 40
+	cgmMaxEGV = 400
+)
+
+// updateCGM sets the current CGM reading from the replay source if one is
+// set, or else the model if one is set, otherwise random-walks it by up to
+// the configured noise, while a CGM session is active
+func (s *Simulator) updateCGM() {
+	s.mutex.Lock()
+	noise, rng, replay := s.cgmNoise, s.rng, s.cgmReplay
+	model, modelStart := s.cgmModel, s.cgmModelStart
+	s.mutex.Unlock()
+	if replay != nil {
+		s.replayCGM(replay)
+		return
+	}
+	if model != nil {
+		s.modelCGM(model, modelStart)
+		return
+	}
+	if noise <= 0 {
+		return
+	}
+
+	s.pumpState.mutex.Lock()
+	defer s.pumpState.mutex.Unlock()
+	if !s.pumpState.CGM.SessionActive {
+		return
+	}
+
+	egv := clampEGV(s.pumpState.CGM.CurrentEGV + rng.Intn(2*noise+1) - noise)
+	s.pumpState.recordCGMReading(egv, s.clock())
+}
+
+// clampEGV bounds egv to the reportable range
+func clampEGV(egv int) int {
+	if egv < cgmMinEGV {
+		return cgmMinEGV
+	} else if egv > cgmMaxEGV {
+		return cgmMaxEGV
+	}
+	return egv
+}
+
+// recordCGMReading sets the current reading taken at, and the trend from the
+// previous reading; callers must hold the lock
+func (ps *PumpState) recordCGMReading(egv int, at time.Time) {
+	cgm := ps.CGM
+	if !cgm.LastReading.IsZero() && at.After(cgm.LastReading) {
+		cgm.TrendRate = float64(egv-cgm.CurrentEGV) / at.Sub(cgm.LastReading).Minutes()
+	}
+	cgm.CurrentEGV = egv
+	cgm.LastReading = at
+}
+
+// GetCGMTrendRate returns the rate the CGM reading is changing, in mg/dL
+// per minute
+func (ps *PumpState) GetCGMTrendRate() float64 {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return ps.CGM.TrendRate
+}
+
+// replayCGM applies the replay's current reading, notifying when it changes
+func (s *Simulator) replayCGM(replay *CGMReplay) {
+	egv := replay.Current()
+
+	s.pumpState.mutex.Lock()
+	changed := s.pumpState.CGM.SessionActive && s.pumpState.CGM.CurrentEGV != egv
+	if changed {
+		s.pumpState.recordCGMReading(egv, s.clock())
+	}
+	s.pumpState.mutex.Unlock()
+
+	if changed {
+		s.notifyCGMReading(egv)
+	}
+}
+
+// notifyCGMReading notifies of a new CGM reading
+func (s *Simulator) notifyCGMReading(egv int) {
+	if s.eventNotifier != nil {
+		if err := s.eventNotifier.NotifyCGMReading(egv); err != nil {
+			log.Warnf("Failed to notify CGM reading: %v", err)
+		}
+	}
+}
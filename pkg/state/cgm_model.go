@@ -0,0 +1,62 @@
+package state
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultCGMModelPeriod is the length of one glucose cycle of a CGMModel
+// without a period set
+const DefaultCGMModelPeriod = 3 * time.Hour
+
+// CGMModel evolves the simulated CGM reading as a sine wave around a
+// baseline, pulled down by the insulin on board beyond what the basal rate
+// sustains -- so a bolus, manual or Control-IQ, lowers glucose until its
+// insulin has acted
+type CGMModel struct {
+	Baseline  int           // mg/dL the wave is centered on
+	Amplitude int           // mg/dL the wave swings either side of the baseline
+	Period    time.Duration // of one cycle; DefaultCGMModelPeriod if zero
+}
+
+// egv returns the modelled reading elapsed into the cycle, with iob units
+// on board against a steady basalRate, each unit lowering glucose by isf. At
+// a steady basal rate IOB settles at basalHours of basal.
+func (m *CGMModel) egv(elapsed time.Duration, iob, basalRate, basalHours, isf float64) int {
+	period := m.Period
+	if period <= 0 {
+		period = DefaultCGMModelPeriod
+	}
+	wave := float64(m.Amplitude) * math.Sin(2*math.Pi*elapsed.Seconds()/period.Seconds())
+	excessIOB := math.Max(0, iob-basalRate*basalHours)
+	return clampEGV(int(math.Round(float64(m.Baseline) + wave - isf*excessIOB)))
+}
+
+// SetCGMModel evolves the CGM reading by model, starting its cycle now, in
+// place of the noise generator; replayed readings still take precedence. A
+// nil model returns to the noise generator.
+func (s *Simulator) SetCGMModel(model *CGMModel) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cgmModel = model
+	s.cgmModelStart = s.clock()
+}
+
+// modelCGM applies the model's current reading, notifying when it changes
+func (s *Simulator) modelCGM(model *CGMModel, start time.Time) {
+	now := s.clock()
+
+	s.pumpState.mutex.Lock()
+	ps := s.pumpState
+	egv := model.egv(now.Sub(start), ps.iobAt(now), ps.Basal.CurrentRate,
+		basalIOBHours(ps.insulinDuration()), ps.ControlIQ.CorrectionFactor)
+	changed := s.pumpState.CGM.SessionActive && s.pumpState.CGM.CurrentEGV != egv
+	if changed {
+		s.pumpState.recordCGMReading(egv, now)
+	}
+	s.pumpState.mutex.Unlock()
+
+	if changed {
+		s.notifyCGMReading(egv)
+	}
+}
@@ -0,0 +1,186 @@
+package state
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCGMInterval is the spacing assumed after the last reading of a
+// single-reading file, matching a Dexcom sensor's 5 minute cadence
+const defaultCGMInterval = 5 * time.Minute
+
+// CGMReading is one replayed glucose value, Offset after the first reading
+type CGMReading struct {
+	Offset time.Duration
+	EGV    int
+}
+
+// CGMReplay replays recorded glucose readings against the wall clock in
+// place of the simulated CGM reading
+type CGMReplay struct {
+	readings []CGMReading
+	period   time.Duration // length of one pass, including the last reading's interval
+	loop     bool
+	start    time.Time
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewCGMReplay creates a replay of readings, which must be non-empty and
+// sorted by offset. With loop set, it restarts from the first reading after
+// the last one's interval elapses; otherwise it holds the last reading.
+func NewCGMReplay(readings []CGMReading, loop bool) *CGMReplay {
+	interval := defaultCGMInterval
+	if n := len(readings); n > 1 {
+		interval = readings[n-1].Offset - readings[n-2].Offset
+	}
+	return &CGMReplay{
+		readings: readings,
+		period:   readings[len(readings)-1].Offset + interval,
+		loop:     loop,
+		now:      time.Now,
+	}
+}
+
+// Current returns the reading in effect now. The replay starts on the first call.
+func (r *CGMReplay) Current() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	elapsed := now.Sub(r.start)
+	if r.loop && r.period > 0 {
+		elapsed %= r.period
+	}
+
+	i := sort.Search(len(r.readings), func(i int) bool {
+		return r.readings[i].Offset > elapsed
+	})
+	if i == 0 {
+		i = 1
+	}
+	return r.readings[i-1].EGV
+}
+
+// LoadCGMFile reads timestamped glucose readings from a .csv file of
+// "timestamp,glucose" rows (an optional header row is skipped) or a .json
+// array of {"timestamp": ..., "glucose": ...} objects. Timestamps are RFC3339
+// or unix seconds; readings are returned sorted, offset from the earliest.
+func LoadCGMFile(path string) ([]CGMReading, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CGM file: %w", err)
+	}
+	defer f.Close()
+
+	var times []time.Time
+	var egvs []int
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		times, egvs, err = readCGMCSV(f)
+	case ".json":
+		times, egvs, err = readCGMJSON(f)
+	default:
+		return nil, fmt.Errorf("unsupported CGM file type %q (must be .csv or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(times) == 0 {
+		return nil, fmt.Errorf("CGM file %s has no readings", path)
+	}
+	return cgmReadings(times, egvs), nil
+}
+
+// cgmReadings converts absolute readings to sorted offsets from the earliest
+func cgmReadings(times []time.Time, egvs []int) []CGMReading {
+	readings := make([]CGMReading, len(times))
+	first := times[0]
+	for _, t := range times {
+		if t.Before(first) {
+			first = t
+		}
+	}
+	for i := range times {
+		readings[i] = CGMReading{Offset: times[i].Sub(first), EGV: egvs[i]}
+	}
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].Offset < readings[j].Offset
+	})
+	return readings
+}
+
+func readCGMCSV(r io.Reader) ([]time.Time, []int, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CGM CSV: %w", err)
+	}
+
+	var times []time.Time
+	var egvs []int
+	for i, row := range rows {
+		if len(row) < 2 {
+			return nil, nil, fmt.Errorf("CGM CSV row %d: expected timestamp,glucose", i+1)
+		}
+		egv, err := strconv.Atoi(strings.TrimSpace(row[1]))
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, nil, fmt.Errorf("CGM CSV row %d: invalid glucose %q", i+1, row[1])
+		}
+		t, err := parseCGMTimestamp(strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("CGM CSV row %d: %w", i+1, err)
+		}
+		times = append(times, t)
+		egvs = append(egvs, egv)
+	}
+	return times, egvs, nil
+}
+
+func readCGMJSON(r io.Reader) ([]time.Time, []int, error) {
+	var entries []struct {
+		Timestamp json.RawMessage `json:"timestamp"`
+		Glucose   int             `json:"glucose"`
+	}
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, nil, fmt.Errorf("failed to read CGM JSON: %w", err)
+	}
+
+	times := make([]time.Time, len(entries))
+	egvs := make([]int, len(entries))
+	for i, entry := range entries {
+		t, err := parseCGMTimestamp(strings.Trim(string(entry.Timestamp), `"`))
+		if err != nil {
+			return nil, nil, fmt.Errorf("CGM JSON entry %d: %w", i, err)
+		}
+		times[i] = t
+		egvs[i] = entry.Glucose
+	}
+	return times, egvs, nil
+}
+
+// parseCGMTimestamp parses an RFC3339 or unix seconds timestamp
+func parseCGMTimestamp(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q (must be RFC3339 or unix seconds)", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cgmNotifier records CGM readings notified by the simulator
+type cgmNotifier struct {
+	NoOpEventNotifier
+	readings []int
+}
+
+func (n *cgmNotifier) NotifyCGMReading(egv int) error {
+	n.readings = append(n.readings, egv)
+	return nil
+}
+
+// writeCGMFile writes contents to a temp file with the given name
+func writeCGMFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+// TestCGMReplayFollowsFileTimestamps verifies replayed values become the
+// current glucose at their offsets and are notified as they change
+func TestCGMReplayFollowsFileTimestamps(t *testing.T) {
+	readings, err := LoadCGMFile(writeCGMFile(t, "cgm.csv",
+		"timestamp,glucose\n"+
+			"2024-01-01T00:00:00Z,100\n"+
+			"2024-01-01T00:05:00Z,150\n"+
+			"2024-01-01T00:10:00Z,210\n"))
+	if err != nil {
+		t.Fatalf("LoadCGMFile failed: %v", err)
+	}
+
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	notifier := &cgmNotifier{}
+	sim := NewSimulator(ps, time.Second)
+	sim.SetEventNotifier(notifier)
+	replay := NewCGMReplay(readings, false)
+	now := time.Unix(1700000000, 0)
+	replay.now = func() time.Time { return now }
+	sim.SetCGMReplay(replay)
+
+	steps := []struct {
+		advance time.Duration
+		want    int
+	}{
+		{0, 100},
+		{4 * time.Minute, 100},
+		{time.Minute, 150},
+		{5 * time.Minute, 210},
+		{time.Hour, 210}, // holds the last reading without looping
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		sim.updateCGM()
+		if egv := ps.GetCurrentEGV(); egv != step.want {
+			t.Errorf("After %s: expected %d, got %d", step.advance, step.want, egv)
+		}
+	}
+	if len(notifier.readings) != 3 {
+		t.Errorf("Expected 3 CGM reading events, got %v", notifier.readings)
+	}
+}
+
+// TestCGMReplayLoopsFromBeginning verifies a looping replay restarts from the
+// first reading one interval after the last
+func TestCGMReplayLoopsFromBeginning(t *testing.T) {
+	readings, err := LoadCGMFile(writeCGMFile(t, "cgm.json",
+		`[{"timestamp": 1700000000, "glucose": 90}, {"timestamp": 1700000300, "glucose": 180}]`))
+	if err != nil {
+		t.Fatalf("LoadCGMFile failed: %v", err)
+	}
+
+	replay := NewCGMReplay(readings, true)
+	now := time.Unix(1700000000, 0)
+	replay.now = func() time.Time { return now }
+
+	for _, step := range []struct {
+		at   time.Duration
+		want int
+	}{
+		{0, 90},
+		{5 * time.Minute, 180},
+		{10 * time.Minute, 90},
+		{15 * time.Minute, 180},
+	} {
+		now = time.Unix(1700000000, 0).Add(step.at)
+		if egv := replay.Current(); egv != step.want {
+			t.Errorf("At %s: expected %d, got %d", step.at, step.want, egv)
+		}
+	}
+}
+
+// TestLoadCGMFileRejectsBadInput verifies malformed files are reported
+func TestLoadCGMFileRejectsBadInput(t *testing.T) {
+	for name, contents := range map[string]string{
+		"empty.csv":   "timestamp,glucose\n",
+		"bad.csv":     "2024-01-01T00:00:00Z,100\nyesterday,120\n",
+		"cgm.txt":     "2024-01-01T00:00:00Z,100\n",
+		"broken.json": `[{"timestamp": "soon", "glucose": 100}]`,
+	} {
+		if _, err := LoadCGMFile(writeCGMFile(t, name, contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
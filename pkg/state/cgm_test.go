@@ -0,0 +1,91 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// cgmSequence ticks a noisy simulator seeded with seed and returns the CGM
+// readings it produced
+func cgmSequence(seed int64, ticks int) []int {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	sim := NewSimulator(ps, time.Second)
+	sim.SetRand(NewRand(seed))
+	sim.SetCGMNoise(10)
+
+	readings := make([]int, ticks)
+	for i := range readings {
+		sim.updateCGM()
+		readings[i] = ps.GetCurrentEGV()
+	}
+	return readings
+}
+
+// TestCGMNoiseReproducibleFromSeed verifies the same seed reproduces the same
+// CGM readings and a different seed diverges
+func TestCGMNoiseReproducibleFromSeed(t *testing.T) {
+	first := cgmSequence(42, 50)
+	second := cgmSequence(42, 50)
+	other := cgmSequence(43, 50)
+
+	diverged := false
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Reading %d differs with the same seed: %d vs %d", i, first[i], second[i])
+		}
+		if first[i] != other[i] {
+			diverged = true
+		}
+		if first[i] < cgmMinEGV || first[i] > cgmMaxEGV {
+			t.Errorf("Reading %d out of range: %d", i, first[i])
+		}
+	}
+	if !diverged {
+		t.Error("Expected a different seed to produce different readings")
+	}
+}
+
+// TestCGMHeldSteadyWithoutNoise verifies the reading doesn't move by default
+func TestCGMHeldSteadyWithoutNoise(t *testing.T) {
+	ps := NewPumpState()
+	sim := NewSimulator(ps, time.Second)
+	sim.updateCGM()
+	if egv := ps.GetCurrentEGV(); egv != 120 {
+		t.Errorf("Expected steady reading of 120, got %d", egv)
+	}
+}
+
+// TestCGMModelFollowsWaveAndIOB verifies the modelled reading follows the
+// sine wave, with a trend from the last reading, and that insulin on board
+// beyond the basal rate's lowers it
+func TestCGMModelFollowsWaveAndIOB(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	sim := NewSimulator(ps, time.Second)
+	now := time.Unix(1000, 0)
+	sim.now = func() time.Time { return now }
+	sim.SetCGMModel(&CGMModel{Baseline: 150, Amplitude: 40, Period: 4 * time.Hour})
+
+	sim.updateCGM()
+	if egv := ps.GetCurrentEGV(); egv != 150 {
+		t.Errorf("Expected the baseline at the start of the cycle, got %d", egv)
+	}
+
+	// A quarter cycle in, the wave peaks
+	now = now.Add(time.Hour)
+	sim.updateCGM()
+	if egv := ps.GetCurrentEGV(); egv != 190 {
+		t.Errorf("Expected the peak of 190, got %d", egv)
+	}
+	if rate := ps.GetCGMTrendRate(); rate != 40.0/60 {
+		t.Errorf("Expected a trend of 40 mg/dL over 60 minutes, got %v", rate)
+	}
+
+	// 2 units beyond the basal rate's steady IOB, at an ISF of 50
+	ps.RecordDelivery(now, ps.GetBasalRate()*basalIOBHours(DefaultInsulinDuration*time.Minute)+2)
+	sim.updateCGM()
+	if egv := ps.GetCurrentEGV(); egv != 90 {
+		t.Errorf("Expected IOB to lower the peak by 100 to 90, got %d", egv)
+	}
+}
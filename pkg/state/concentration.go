@@ -0,0 +1,99 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InsulinConcentration is the insulin's strength in units per mL
+type InsulinConcentration int
+
+// Supported insulin concentrations
+const (
+	U100 InsulinConcentration = 100
+	U200 InsulinConcentration = 200
+)
+
+func (c InsulinConcentration) String() string {
+	return fmt.Sprintf("U-%d", int(c))
+}
+
+// ParseInsulinConcentration parses a concentration such as "U-200" or "200"
+func ParseInsulinConcentration(s string) (InsulinConcentration, error) {
+	value := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "U"), "-")
+	switch value {
+	case "100":
+		return U100, nil
+	case "200":
+		return U200, nil
+	default:
+		return 0, fmt.Errorf("invalid insulin concentration %q (must be U-100 or U-200)", s)
+	}
+}
+
+// Pump model names reported in PumpVersionResponse
+const (
+	ModelTSlimX2 = "t:slim X2"
+	ModelMobi    = "Tandem Mobi"
+)
+
+// ModelInsulinConcentration returns the default insulin concentration for a
+// pump model: U-200 for Mobi, which supports it, and U-100 otherwise
+func ModelInsulinConcentration(model string) InsulinConcentration {
+	if model == ModelMobi {
+		return U200
+	}
+	return U100
+}
+
+// SetInsulinConcentration switches the reservoir to concentration c. The
+// insulin volume in the reservoir stays the same, so the units it holds are
+// rescaled.
+func (ps *PumpState) SetInsulinConcentration(c InsulinConcentration) error {
+	if c != U100 && c != U200 {
+		return fmt.Errorf("unsupported insulin concentration %s", c)
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.setInsulinConcentration(c)
+	return nil
+}
+
+// setInsulinConcentration switches to c keeping the volume (must hold mutex)
+func (ps *PumpState) setInsulinConcentration(c InsulinConcentration) {
+	old := ps.Reservoir.Concentration
+	if old == c {
+		return
+	}
+	if old != 0 {
+		scale := float64(c) / float64(old)
+		ps.Reservoir.CurrentUnits *= scale
+		ps.Reservoir.MaxUnits *= scale
+	}
+	ps.Reservoir.Concentration = c
+}
+
+// GetInsulinConcentration returns the reservoir's insulin concentration
+func (ps *PumpState) GetInsulinConcentration() InsulinConcentration {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.Reservoir.Concentration
+}
+
+// GetReservoirVolume returns the insulin volume left in the reservoir, in mL
+func (ps *PumpState) GetReservoirVolume() float64 {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.Reservoir.CurrentUnits / float64(ps.Reservoir.Concentration)
+}
+
+// deductFromReservoir takes units delivered out of the reservoir (must hold
+// mutex). It only counts units: the volume they used, units/concentration mL,
+// follows from the reservoir's concentration in GetReservoirVolume.
+func (ps *PumpState) deductFromReservoir(units float64) {
+	ps.Reservoir.CurrentUnits -= units
+	if ps.Reservoir.CurrentUnits < 0 {
+		ps.Reservoir.CurrentUnits = 0
+	}
+}
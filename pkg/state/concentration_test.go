@@ -0,0 +1,97 @@
+package state
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// bolusVolume delivers a bolus of units through the simulator at
+// concentration and returns the reservoir volume it used, in mL
+func bolusVolume(t *testing.T, concentration InsulinConcentration, units float64) float64 {
+	t.Helper()
+	ps := NewPumpState()
+	if err := ps.SetInsulinConcentration(concentration); err != nil {
+		t.Fatalf("SetInsulinConcentration failed: %v", err)
+	}
+	sim := NewSimulator(ps, time.Second)
+
+	before := ps.GetReservoirVolume()
+	ps.StartBolus(units, 1)
+	ps.Bolus.StartTime = time.Now().Add(-time.Hour) // fully due
+	sim.updateBolusDelivery()
+	if delivered := ps.Bolus.UnitsDelivered; delivered != units {
+		t.Fatalf("Expected %.2f units delivered, got %.2f", units, delivered)
+	}
+	return before - ps.GetReservoirVolume()
+}
+
+// TestU200BolusUsesHalfTheVolume verifies the same bolus uses half the
+// reservoir volume at U-200 that it does at U-100
+func TestU200BolusUsesHalfTheVolume(t *testing.T) {
+	u100 := bolusVolume(t, U100, 5)
+	u200 := bolusVolume(t, U200, 5)
+
+	if math.Abs(u100-0.05) > 1e-9 {
+		t.Errorf("Expected 5 units of U-100 to use 0.05 mL, got %.4f", u100)
+	}
+	if math.Abs(u200-u100/2) > 1e-9 {
+		t.Errorf("Expected U-200 to use half the volume of U-100 (%.4f mL), got %.4f", u100/2, u200)
+	}
+}
+
+// TestSetInsulinConcentrationKeepsVolume verifies switching concentration
+// rescales the reported units for the same reservoir volume
+func TestSetInsulinConcentrationKeepsVolume(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetReservoirLevel(150)
+	volume := ps.GetReservoirVolume()
+
+	if err := ps.SetInsulinConcentration(U200); err != nil {
+		t.Fatalf("SetInsulinConcentration failed: %v", err)
+	}
+	if units := ps.GetReservoirLevel(); units != 300 {
+		t.Errorf("Expected 300 units of U-200 in the same volume, got %.2f", units)
+	}
+	if v := ps.GetReservoirVolume(); v != volume {
+		t.Errorf("Expected the volume to stay %.2f mL, got %.2f", volume, v)
+	}
+	if err := ps.SetInsulinConcentration(InsulinConcentration(300)); err == nil {
+		t.Error("Expected U-300 to be rejected")
+	}
+}
+
+// TestModelSetsDefaultConcentration verifies Mobi defaults to U-200 and
+// other models to U-100
+func TestModelSetsDefaultConcentration(t *testing.T) {
+	ps := NewPumpState()
+	if c := ps.GetInsulinConcentration(); c != U100 {
+		t.Errorf("Expected t:slim X2 to default to U-100, got %s", c)
+	}
+
+	id := ps.GetIdentity()
+	id.Model = ModelMobi
+	if err := ps.SetIdentity(id); err != nil {
+		t.Fatalf("SetIdentity failed: %v", err)
+	}
+	if c := ps.GetInsulinConcentration(); c != U200 {
+		t.Errorf("Expected Mobi to default to U-200, got %s", c)
+	}
+
+	if c := ModelInsulinConcentration("Mobile Pump"); c != U100 {
+		t.Errorf("Expected only the exact Mobi model to default to U-200, got %s", c)
+	}
+}
+
+// TestParseInsulinConcentration verifies accepted spellings and rejection of
+// unsupported strengths
+func TestParseInsulinConcentration(t *testing.T) {
+	for input, want := range map[string]InsulinConcentration{"U-100": U100, "u200": U200, "200": U200} {
+		if got, err := ParseInsulinConcentration(input); err != nil || got != want {
+			t.Errorf("ParseInsulinConcentration(%q) = %s, %v; want %s", input, got, err, want)
+		}
+	}
+	if _, err := ParseInsulinConcentration("U-500"); err == nil {
+		t.Error("Expected U-500 to be rejected")
+	}
+}
@@ -0,0 +1,189 @@
+package state
+
+import (
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Control-IQ treatment decision thresholds. These approximate the published
+// Control-IQ behavior: an automatic correction bolus of 60% of the computed
+// correction (to a 110 mg/dL target) when glucose is above 180 mg/dL, at
+// most once per hour and capped at 6 units, and an increased basal rate when
+// glucose is above 160 mg/dL.
+const (
+	controlIQTarget             = 110
+	controlIQAutoBolusThreshold = 180
+	controlIQBasalThreshold     = 160
+	controlIQAutoBolusFraction  = 0.6
+	controlIQMaxAutoBolus       = 6.0
+	controlIQAutoBolusInterval  = time.Hour
+	controlIQMaxBasalMultiplier = 2.0
+)
+
+// ControlIQDecisionType identifies an automatic treatment decision
+type ControlIQDecisionType string
+
+const (
+	// DecisionAutoCorrectionBolus is an automatic correction bolus
+	DecisionAutoCorrectionBolus ControlIQDecisionType = "auto_correction_bolus"
+
+	// DecisionBasalIncrease is an automatic increase of the basal rate
+	DecisionBasalIncrease ControlIQDecisionType = "basal_increase"
+
+	// DecisionBasalResume returns to the profile basal rate
+	DecisionBasalResume ControlIQDecisionType = "basal_resume"
+)
+
+// ControlIQDecision records a single automatic treatment decision
+type ControlIQDecision struct {
+	Type      ControlIQDecisionType
+	EGV       int
+	Units     float64 // bolus units, for DecisionAutoCorrectionBolus
+	Rate      float64 // new basal rate in units/hr, for basal decisions
+	PrevRate  float64 // basal rate before the decision, for basal decisions
+	BolusID   uint32
+	Timestamp time.Time
+}
+
+// ControlIQState holds the simulated Control-IQ algorithm state
+type ControlIQState struct {
+	Enabled           bool
+	CorrectionFactor  float64 // mg/dL per unit (ISF)
+	AdjustedBasalRate float64 // 0 when no basal adjustment is active
+	LastAutoBolus     time.Time
+	Decisions         []ControlIQDecision
+}
+
+// SetCurrentEGV sets the current estimated glucose value (mg/dL)
+func (ps *PumpState) SetCurrentEGV(egv int) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.CGM.CurrentEGV = egv
+}
+
+// SetControlIQEnabled enables or disables simulated Control-IQ decisions
+func (ps *PumpState) SetControlIQEnabled(enabled bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.ControlIQ.Enabled = enabled
+	if !enabled {
+		ps.ControlIQ.AdjustedBasalRate = 0
+	}
+}
+
+// GetControlIQ returns a copy of the simulated Control-IQ state
+func (ps *PumpState) GetControlIQ() ControlIQState {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	if ps.ControlIQ == nil {
+		return ControlIQState{}
+	}
+	ciq := *ps.ControlIQ
+	ciq.Decisions = append([]ControlIQDecision(nil), ps.ControlIQ.Decisions...)
+	return ciq
+}
+
+// GetControlIQDecisions returns a copy of the recorded Control-IQ decisions
+func (ps *PumpState) GetControlIQDecisions() []ControlIQDecision {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	decisions := make([]ControlIQDecision, len(ps.ControlIQ.Decisions))
+	copy(decisions, ps.ControlIQ.Decisions)
+	return decisions
+}
+
+// updateControlIQ makes simulated Control-IQ treatment decisions from the
+// current CGM reading, starting automatic correction boluses and adjusting
+// basal like the real algorithm would
+func (s *Simulator) updateControlIQ() {
+	s.pumpState.mutex.Lock()
+	decision, ok := s.evaluateControlIQ(time.Now())
+	s.pumpState.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	s.recordControlIQDecision(decision)
+}
+
+// evaluateControlIQ computes and applies a decision (must hold mutex)
+func (s *Simulator) evaluateControlIQ(now time.Time) (ControlIQDecision, bool) {
+	ps := s.pumpState
+	ciq := ps.ControlIQ
+	if !ciq.Enabled || !ps.CGM.SessionActive || ps.PumpingSuspended {
+		return ControlIQDecision{}, false
+	}
+
+	egv := ps.CGM.CurrentEGV
+	if egv > controlIQAutoBolusThreshold && !ps.Bolus.Active && now.Sub(ciq.LastAutoBolus) >= controlIQAutoBolusInterval {
+		units := controlIQAutoBolusFraction * (float64(egv-controlIQTarget)/ciq.CorrectionFactor - ps.iobAt(now))
+		units = math.Min(math.Round(units*100)/100, controlIQMaxAutoBolus)
+		if units >= 0.05 {
+			bolusID := uint32(now.Unix() % 0x10000)
+			ps.Bolus.Active = true
+			ps.Bolus.UnitsTotal = units
+			ps.Bolus.UnitsDelivered = 0
+			ps.Bolus.StartTime = now
+			ps.Bolus.BolusID = bolusID
+			ps.Bolus.Automatic = true
+			ciq.LastAutoBolus = now
+			return s.appendDecision(ControlIQDecision{
+				Type: DecisionAutoCorrectionBolus, EGV: egv, Units: units, BolusID: bolusID, Timestamp: now,
+			}), true
+		}
+	}
+
+	if egv > controlIQBasalThreshold && ciq.AdjustedBasalRate == 0 {
+		ciq.AdjustedBasalRate = ps.Basal.CurrentRate * controlIQMaxBasalMultiplier
+		return s.appendDecision(ControlIQDecision{
+			Type: DecisionBasalIncrease, EGV: egv, Rate: ciq.AdjustedBasalRate, PrevRate: ps.Basal.CurrentRate, Timestamp: now,
+		}), true
+	}
+
+	if egv <= controlIQBasalThreshold && ciq.AdjustedBasalRate != 0 {
+		prevRate := ciq.AdjustedBasalRate
+		ciq.AdjustedBasalRate = 0
+		return s.appendDecision(ControlIQDecision{
+			Type: DecisionBasalResume, EGV: egv, Rate: ps.Basal.CurrentRate, PrevRate: prevRate, Timestamp: now,
+		}), true
+	}
+
+	return ControlIQDecision{}, false
+}
+
+// appendDecision records a decision in state (must hold mutex)
+func (s *Simulator) appendDecision(decision ControlIQDecision) ControlIQDecision {
+	s.pumpState.ControlIQ.Decisions = append(s.pumpState.ControlIQ.Decisions, decision)
+	log.Infof("Control-IQ decision: %s (egv=%d, units=%.2f, rate=%.2f)",
+		decision.Type, decision.EGV, decision.Units, decision.Rate)
+	return decision
+}
+
+// recordControlIQDecision adds history entries and fires qualifying events
+// for a decision, the same way a manual bolus or basal change would
+func (s *Simulator) recordControlIQDecision(decision ControlIQDecision) {
+	switch decision.Type {
+	case DecisionAutoCorrectionBolus:
+		s.addHistoryEntryWithTypeID(HistoryBolusActivated, "BolusActivated", map[string]interface{}{
+			"bolusId": decision.BolusID, "units": decision.Units, "automatic": true, "egv": decision.EGV,
+		})
+		if s.eventNotifier != nil {
+			if err := s.eventNotifier.NotifyBolusStart(decision.BolusID, decision.Units); err != nil {
+				log.Warnf("Failed to notify auto bolus start: %v", err)
+			}
+		}
+	case DecisionBasalIncrease, DecisionBasalResume:
+		s.addHistoryEntryWithTypeID(HistoryBasalRateChange, "BasalRateChange", map[string]interface{}{
+			"rate": decision.Rate, "previousRate": decision.PrevRate, "automatic": true, "egv": decision.EGV,
+		})
+		if s.eventNotifier != nil {
+			if err := s.eventNotifier.NotifyBasalRateChange(decision.PrevRate, decision.Rate, false); err != nil {
+				log.Warnf("Failed to notify Control-IQ basal change: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingNotifier records qualifying event calls made by the simulator
+type recordingNotifier struct {
+	NoOpEventNotifier
+	bolusStarts  []float64
+	basalChanges []float64
+}
+
+func (n *recordingNotifier) NotifyBolusStart(bolusID uint32, units float64) error {
+	n.bolusStarts = append(n.bolusStarts, units)
+	return nil
+}
+
+func (n *recordingNotifier) NotifyBasalRateChange(oldRate, newRate float64, tempBasal bool) error {
+	n.basalChanges = append(n.basalChanges, newRate)
+	return nil
+}
+
+// TestControlIQHighCGMTriggersAutoCorrection verifies a high CGM reading starts
+// an automatic correction bolus recorded in state and history
+func TestControlIQHighCGMTriggersAutoCorrection(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetCurrentEGV(260)
+	notifier := &recordingNotifier{}
+	sim := NewSimulator(ps, time.Second)
+	sim.SetEventNotifier(notifier)
+
+	sim.updateControlIQ()
+
+	decisions := ps.GetControlIQDecisions()
+	if len(decisions) != 1 || decisions[0].Type != DecisionAutoCorrectionBolus {
+		t.Fatalf("Expected one auto correction decision, got %+v", decisions)
+	}
+	// 60% of (260-110)/50 = 1.8 units
+	if decisions[0].Units != 1.8 {
+		t.Errorf("Expected 1.8 units, got %.2f", decisions[0].Units)
+	}
+	if !ps.IsBolusActive() {
+		t.Error("Expected auto correction bolus to be active")
+	}
+	if len(notifier.bolusStarts) != 1 {
+		t.Errorf("Expected one bolus start event, got %d", len(notifier.bolusStarts))
+	}
+
+	entries := ps.GetHistoryLogEntries(0, ^uint32(0))
+	if len(entries) != 1 || entries[0].TypeID != HistoryBolusActivated || entries[0].Data["automatic"] != true {
+		t.Errorf("Expected one automatic BolusActivated history entry, got %+v", entries)
+	}
+
+	// A second evaluation within the hour must not start another auto bolus
+	ps.StopBolus()
+	sim.updateControlIQ()
+	for _, d := range ps.GetControlIQDecisions() {
+		if d.Type == DecisionAutoCorrectionBolus && d.BolusID != decisions[0].BolusID {
+			t.Error("Expected no second auto correction bolus within the interval")
+		}
+	}
+}
+
+// TestControlIQBasalIncreaseAndResume verifies moderately high CGM raises the
+// basal rate and returning in range restores it
+func TestControlIQBasalIncreaseAndResume(t *testing.T) {
+	ps := NewPumpState()
+	profileRate := ps.GetBasalRate()
+	notifier := &recordingNotifier{}
+	sim := NewSimulator(ps, time.Second)
+	sim.SetEventNotifier(notifier)
+
+	ps.SetCurrentEGV(170)
+	sim.updateControlIQ()
+	if got := ps.GetBasalRate(); got != profileRate*controlIQMaxBasalMultiplier {
+		t.Errorf("Expected increased basal rate %.2f, got %.2f", profileRate*controlIQMaxBasalMultiplier, got)
+	}
+
+	ps.SetCurrentEGV(120)
+	sim.updateControlIQ()
+	if got := ps.GetBasalRate(); got != profileRate {
+		t.Errorf("Expected basal rate to return to %.2f, got %.2f", profileRate, got)
+	}
+	if len(notifier.basalChanges) != 2 {
+		t.Errorf("Expected two basal change events, got %d", len(notifier.basalChanges))
+	}
+}
+
+// TestControlIQDisabled verifies no decisions are made when Control-IQ is off
+func TestControlIQDisabled(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	ps.SetCurrentEGV(300)
+	sim := NewSimulator(ps, time.Second)
+
+	sim.updateControlIQ()
+
+	if len(ps.GetControlIQDecisions()) != 0 || ps.IsBolusActive() {
+		t.Error("Expected no Control-IQ decisions while disabled")
+	}
+}
@@ -0,0 +1,104 @@
+package state
+
+import "sync"
+
+// EventBus is an EventNotifier that fans each event out to every subscriber,
+// so pump state changes can reach BLE qualifying events and monitoring
+// clients alike. A subscriber's error is returned but doesn't stop delivery
+// to the rest.
+type EventBus struct {
+	subscribers []EventNotifier
+	mutex       sync.RWMutex
+}
+
+// NewEventBus creates an event bus with no subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe adds a notifier told of every subsequent event
+func (b *EventBus) Subscribe(notifier EventNotifier) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers = append(b.subscribers, notifier)
+}
+
+// publish calls notify on each subscriber, returning the first error
+func (b *EventBus) publish(notify func(EventNotifier) error) error {
+	b.mutex.RLock()
+	subscribers := b.subscribers
+	b.mutex.RUnlock()
+
+	var firstErr error
+	for _, subscriber := range subscribers {
+		if err := notify(subscriber); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NotifyBolusStart publishes a bolus start
+func (b *EventBus) NotifyBolusStart(bolusID uint32, units float64) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyBolusStart(bolusID, units) })
+}
+
+// NotifyBolusComplete publishes a bolus completion
+func (b *EventBus) NotifyBolusComplete(bolusID uint32, delivered float64, total float64) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyBolusComplete(bolusID, delivered, total) })
+}
+
+// NotifyBolusCanceled publishes a bolus cancellation
+func (b *EventBus) NotifyBolusCanceled(bolusID uint32, delivered float64, total float64) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyBolusCanceled(bolusID, delivered, total) })
+}
+
+// NotifyAlert publishes an alert
+func (b *EventBus) NotifyAlert(alert Alert) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyAlert(alert) })
+}
+
+// NotifyAlertCleared publishes a cleared alert
+func (b *EventBus) NotifyAlertCleared(alertID uint32) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyAlertCleared(alertID) })
+}
+
+// NotifyBasalRateChange publishes a basal rate change
+func (b *EventBus) NotifyBasalRateChange(oldRate, newRate float64, tempBasal bool) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyBasalRateChange(oldRate, newRate, tempBasal) })
+}
+
+// NotifyReservoirLow publishes a low reservoir
+func (b *EventBus) NotifyReservoirLow(units float64) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyReservoirLow(units) })
+}
+
+// NotifyBatteryLow publishes a low battery
+func (b *EventBus) NotifyBatteryLow(percentage int) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyBatteryLow(percentage) })
+}
+
+// NotifyBatteryChange publishes a battery level change
+func (b *EventBus) NotifyBatteryChange(percentage int) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyBatteryChange(percentage) })
+}
+
+// NotifyPumpSuspended publishes a pump suspension
+func (b *EventBus) NotifyPumpSuspended(reason string) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyPumpSuspended(reason) })
+}
+
+// NotifyPumpResumed publishes a pump resumption
+func (b *EventBus) NotifyPumpResumed() error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyPumpResumed() })
+}
+
+// NotifyHistoryLogUpdated publishes a history log append
+func (b *EventBus) NotifyHistoryLogUpdated(sequence uint32) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyHistoryLogUpdated(sequence) })
+}
+
+// NotifyCGMReading publishes a new CGM reading
+func (b *EventBus) NotifyCGMReading(egv int) error {
+	return b.publish(func(n EventNotifier) error { return n.NotifyCGMReading(egv) })
+}
@@ -0,0 +1,78 @@
+package state
+
+// FieldNotifier is an EventNotifier that flattens each event into the pump
+// state fields it changed, for consumers that only display or record state
+// such as websocket clients and session captures
+type FieldNotifier func(fields map[string]interface{})
+
+var _ EventNotifier = FieldNotifier(nil)
+
+func (n FieldNotifier) send(fields map[string]interface{}) error {
+	n(fields)
+	return nil
+}
+
+// NotifyBolusStart sends the started bolus
+func (n FieldNotifier) NotifyBolusStart(bolusID uint32, units float64) error {
+	return n.send(map[string]interface{}{"bolusActive": true, "bolusId": bolusID, "bolusUnitsTotal": units})
+}
+
+// NotifyBolusComplete sends the completed bolus
+func (n FieldNotifier) NotifyBolusComplete(bolusID uint32, delivered float64, total float64) error {
+	return n.send(map[string]interface{}{"bolusActive": false, "bolusId": bolusID, "bolusUnitsDelivered": delivered, "bolusUnitsTotal": total})
+}
+
+// NotifyBolusCanceled sends the canceled bolus
+func (n FieldNotifier) NotifyBolusCanceled(bolusID uint32, delivered float64, total float64) error {
+	return n.send(map[string]interface{}{"bolusActive": false, "bolusCanceled": true, "bolusId": bolusID, "bolusUnitsDelivered": delivered, "bolusUnitsTotal": total})
+}
+
+// NotifyAlert sends the raised alert
+func (n FieldNotifier) NotifyAlert(alert Alert) error {
+	return n.send(map[string]interface{}{"alert": alert})
+}
+
+// NotifyAlertCleared sends the cleared alert's ID
+func (n FieldNotifier) NotifyAlertCleared(alertID uint32) error {
+	return n.send(map[string]interface{}{"alertCleared": alertID})
+}
+
+// NotifyBasalRateChange sends the new basal rate
+func (n FieldNotifier) NotifyBasalRateChange(oldRate, newRate float64, tempBasal bool) error {
+	return n.send(map[string]interface{}{"basalRate": newRate, "tempBasalActive": tempBasal})
+}
+
+// NotifyReservoirLow sends the low reservoir level
+func (n FieldNotifier) NotifyReservoirLow(units float64) error {
+	return n.send(map[string]interface{}{"reservoirUnits": units, "reservoirLow": true})
+}
+
+// NotifyBatteryLow sends the low battery level
+func (n FieldNotifier) NotifyBatteryLow(percentage int) error {
+	return n.send(map[string]interface{}{"batteryPercentage": percentage, "batteryLow": true})
+}
+
+// NotifyBatteryChange sends the new battery level
+func (n FieldNotifier) NotifyBatteryChange(percentage int) error {
+	return n.send(map[string]interface{}{"batteryPercentage": percentage})
+}
+
+// NotifyPumpSuspended sends the suspension
+func (n FieldNotifier) NotifyPumpSuspended(reason string) error {
+	return n.send(map[string]interface{}{"pumpingSuspended": true, "suspendReason": reason})
+}
+
+// NotifyPumpResumed sends the resumption
+func (n FieldNotifier) NotifyPumpResumed() error {
+	return n.send(map[string]interface{}{"pumpingSuspended": false})
+}
+
+// NotifyHistoryLogUpdated sends the new history high-water sequence
+func (n FieldNotifier) NotifyHistoryLogUpdated(sequence uint32) error {
+	return n.send(map[string]interface{}{"historyLogSequence": sequence})
+}
+
+// NotifyCGMReading sends the new CGM reading
+func (n FieldNotifier) NotifyCGMReading(egv int) error {
+	return n.send(map[string]interface{}{"cgmReading": egv})
+}
@@ -0,0 +1,164 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduledEventFuncs send each event a schedule can fire. The events are
+// synthetic: they describe the current pump state but nothing changes, so a
+// client's event handling can be soak-tested on a steady pump.
+var scheduledEventFuncs = map[string]func(n EventNotifier, ps *PumpState) error{
+	"bolusComplete": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyBolusComplete(ps.GetNextBolusID(), 0, 0)
+	},
+	"basalChange": func(n EventNotifier, ps *PumpState) error {
+		rate := ps.GetBasalRate()
+		return n.NotifyBasalRateChange(rate, rate, false)
+	},
+	"reservoirLow": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyReservoirLow(ps.GetReservoirLevel())
+	},
+	"batteryLow": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyBatteryLow(ps.GetBatteryLevel())
+	},
+	"batteryChange": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyBatteryChange(ps.GetBatteryLevel())
+	},
+	"pumpSuspended": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyPumpSuspended("scheduled")
+	},
+	"pumpResumed": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyPumpResumed()
+	},
+	"cgmReading": func(n EventNotifier, ps *PumpState) error {
+		return n.NotifyCGMReading(ps.GetCurrentEGV())
+	},
+}
+
+// ScheduledEvent fires Event every Interval after the schedule starts, or
+// only once, Interval after it starts, if Once is set
+type ScheduledEvent struct {
+	Event    string
+	Interval time.Duration
+	Once     bool
+}
+
+// ParseEventSchedule parses an -event-schedule value: "" (no events) or
+// comma-separated entries of event=interval to repeat an event, or
+// event@delay to fire it once, e.g. "bolusComplete=5m,batteryLow@10m"
+func ParseEventSchedule(value string) ([]ScheduledEvent, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var schedule []ScheduledEvent
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		sep := strings.IndexAny(entry, "=@")
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid event-schedule entry: %q (must be event=interval or event@delay)", entry)
+		}
+		event := ScheduledEvent{Event: entry[:sep], Once: entry[sep] == '@'}
+		if _, ok := scheduledEventFuncs[event.Event]; !ok {
+			return nil, fmt.Errorf("invalid event-schedule event: %q (must be one of %s)", event.Event, strings.Join(scheduledEventNames(), ", "))
+		}
+		interval, err := time.ParseDuration(entry[sep+1:])
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid event-schedule interval: %q (must be a positive duration)", entry[sep+1:])
+		}
+		event.Interval = interval
+		schedule = append(schedule, event)
+	}
+	return schedule, nil
+}
+
+// scheduledEventNames returns the events a schedule can fire, sorted
+func scheduledEventNames() []string {
+	names := make([]string, 0, len(scheduledEventFuncs))
+	for name := range scheduledEventFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scheduledEventState is a scheduled event and when it next fires
+type scheduledEventState struct {
+	ScheduledEvent
+	next time.Time
+	done bool
+}
+
+// EventScheduler fires synthetic qualifying events on a schedule,
+// independent of the simulated pump state evolving
+type EventScheduler struct {
+	pumpState *PumpState
+	events    []*scheduledEventState
+	mutex     sync.Mutex
+}
+
+// NewEventScheduler creates a scheduler whose intervals count from start
+func NewEventScheduler(pumpState *PumpState, schedule []ScheduledEvent, start time.Time) *EventScheduler {
+	events := make([]*scheduledEventState, len(schedule))
+	for i, event := range schedule {
+		events[i] = &scheduledEventState{ScheduledEvent: event, next: start.Add(event.Interval)}
+	}
+	return &EventScheduler{pumpState: pumpState, events: events}
+}
+
+// Fire sends every event due by now to notifier, returning how many were
+// sent. A repeating event that fell more than an interval behind fires once
+// for each interval missed.
+func (e *EventScheduler) Fire(now time.Time, notifier EventNotifier) int {
+	e.mutex.Lock()
+	var due []string
+	for _, event := range e.events {
+		for !event.done && !event.next.After(now) {
+			due = append(due, event.Event)
+			if event.Once {
+				event.done = true
+			} else {
+				event.next = event.next.Add(event.Interval)
+			}
+		}
+	}
+	e.mutex.Unlock()
+
+	for _, name := range due {
+		log.Infof("Firing scheduled %s event", name)
+		if err := scheduledEventFuncs[name](notifier, e.pumpState); err != nil {
+			log.Warnf("Failed to notify scheduled %s event: %v", name, err)
+		}
+	}
+	return len(due)
+}
+
+// SetEventSchedule fires the scheduled synthetic events on each update,
+// counting their intervals from now; an empty schedule fires none
+func (s *Simulator) SetEventSchedule(schedule []ScheduledEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(schedule) == 0 {
+		s.eventScheduler = nil
+		return
+	}
+	s.eventScheduler = NewEventScheduler(s.pumpState, schedule, time.Now())
+}
+
+// fireScheduledEvents sends any scheduled events that are due
+func (s *Simulator) fireScheduledEvents() {
+	s.mutex.Lock()
+	scheduler, notifier := s.eventScheduler, s.eventNotifier
+	s.mutex.Unlock()
+
+	if scheduler != nil && notifier != nil {
+		scheduler.Fire(time.Now(), notifier)
+	}
+}
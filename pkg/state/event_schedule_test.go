@@ -0,0 +1,94 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// scheduleNotifier counts the scheduled events it is sent
+type scheduleNotifier struct {
+	NoOpEventNotifier
+	bolusComplete int
+	batteryLow    int
+}
+
+func (n *scheduleNotifier) NotifyBolusComplete(bolusID uint32, delivered float64, total float64) error {
+	n.bolusComplete++
+	return nil
+}
+
+func (n *scheduleNotifier) NotifyBatteryLow(percentage int) error {
+	n.batteryLow++
+	return nil
+}
+
+// TestEventSchedulerFiresOnInterval verifies a 5 minute bolusComplete fires
+// four times over 20 simulated minutes while a one-off batteryLow fires once
+func TestEventSchedulerFiresOnInterval(t *testing.T) {
+	schedule, err := ParseEventSchedule("bolusComplete=5m,batteryLow@10m")
+	if err != nil {
+		t.Fatalf("ParseEventSchedule failed: %v", err)
+	}
+	start := time.Unix(1000, 0)
+	scheduler := NewEventScheduler(NewPumpState(), schedule, start)
+	notifier := &scheduleNotifier{}
+
+	for elapsed := time.Second; elapsed <= 20*time.Minute; elapsed += time.Second {
+		scheduler.Fire(start.Add(elapsed), notifier)
+	}
+
+	if notifier.bolusComplete != 4 {
+		t.Errorf("Expected 4 bolusComplete events, got %d", notifier.bolusComplete)
+	}
+	if notifier.batteryLow != 1 {
+		t.Errorf("Expected 1 batteryLow event, got %d", notifier.batteryLow)
+	}
+}
+
+// TestEventSchedulerCatchesUpMissedIntervals verifies a clock jump fires a
+// repeating event once per interval it skipped
+func TestEventSchedulerCatchesUpMissedIntervals(t *testing.T) {
+	start := time.Unix(1000, 0)
+	scheduler := NewEventScheduler(NewPumpState(), []ScheduledEvent{{Event: "bolusComplete", Interval: 5 * time.Minute}}, start)
+	notifier := &scheduleNotifier{}
+
+	if fired := scheduler.Fire(start.Add(17*time.Minute), notifier); fired != 3 || notifier.bolusComplete != 3 {
+		t.Errorf("Expected 3 events after 17 minutes, got %d", notifier.bolusComplete)
+	}
+}
+
+// TestParseEventScheduleRejectsInvalid verifies unknown events and bad
+// intervals are refused
+func TestParseEventScheduleRejectsInvalid(t *testing.T) {
+	for _, value := range []string{"bolusComplete", "explode=5m", "bolusComplete=soon", "batteryLow@-1m"} {
+		if _, err := ParseEventSchedule(value); err == nil {
+			t.Errorf("Expected ParseEventSchedule(%q) to fail", value)
+		}
+	}
+}
+
+// TestSimulatorSkipsScheduledEventsInLowPower verifies scheduled events
+// aren't fired while the pump is in low power, and resume once it wakes
+func TestSimulatorSkipsScheduledEventsInLowPower(t *testing.T) {
+	schedule, err := ParseEventSchedule("batteryLow=1ns")
+	if err != nil {
+		t.Fatalf("ParseEventSchedule failed: %v", err)
+	}
+	ps := NewPumpState()
+	sim := NewSimulator(ps, time.Second)
+	notifier := &scheduleNotifier{}
+	sim.SetEventNotifier(notifier)
+	sim.SetEventSchedule(schedule)
+
+	ps.SetLowPower(true)
+	sim.Tick()
+	if notifier.batteryLow != 0 {
+		t.Fatalf("Expected no scheduled events in low power, got %d", notifier.batteryLow)
+	}
+
+	ps.SetLowPower(false)
+	sim.Tick()
+	if notifier.batteryLow == 0 {
+		t.Error("Expected scheduled events to fire after waking")
+	}
+}
@@ -27,11 +27,21 @@ type EventNotifier interface {
 	// NotifyBatteryLow notifies about low battery
 	NotifyBatteryLow(percentage int) error
 
+	// NotifyBatteryChange notifies that the battery level changed
+	NotifyBatteryChange(percentage int) error
+
 	// NotifyPumpSuspended notifies that the pump was suspended
 	NotifyPumpSuspended(reason string) error
 
 	// NotifyPumpResumed notifies that the pump was resumed
 	NotifyPumpResumed() error
+
+	// NotifyHistoryLogUpdated notifies that history entries up to sequence
+	// are available
+	NotifyHistoryLogUpdated(sequence uint32) error
+
+	// NotifyCGMReading notifies that a new CGM reading is available
+	NotifyCGMReading(egv int) error
 }
 
 // NoOpEventNotifier is a no-op implementation of EventNotifier
@@ -77,6 +87,11 @@ func (n *NoOpEventNotifier) NotifyBatteryLow(percentage int) error {
 	return nil
 }
 
+// NotifyBatteryChange is a no-op implementation
+func (n *NoOpEventNotifier) NotifyBatteryChange(percentage int) error {
+	return nil
+}
+
 // NotifyPumpSuspended is a no-op implementation
 func (n *NoOpEventNotifier) NotifyPumpSuspended(reason string) error {
 	return nil
@@ -86,3 +101,13 @@ func (n *NoOpEventNotifier) NotifyPumpSuspended(reason string) error {
 func (n *NoOpEventNotifier) NotifyPumpResumed() error {
 	return nil
 }
+
+// NotifyHistoryLogUpdated is a no-op implementation
+func (n *NoOpEventNotifier) NotifyHistoryLogUpdated(sequence uint32) error {
+	return nil
+}
+
+// NotifyCGMReading is a no-op implementation
+func (n *NoOpEventNotifier) NotifyCGMReading(egv int) error {
+	return nil
+}
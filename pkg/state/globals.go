@@ -0,0 +1,183 @@
+package state
+
+import "fmt"
+
+// Glucose display units, matching LocalizationResponse's glucoseUOM
+const (
+	GlucoseUnitMgdl = 0
+	GlucoseUnitMmol = 1
+)
+
+// Accepted remote BG entry range in each glucose unit, matching the range a
+// meter can report (20-600 mg/dL, or 1.1-33.3 mmol/L)
+const (
+	MinBGMgdl = 20.0
+	MaxBGMgdl = 600.0
+	MinBGMmol = 1.1
+	MaxBGMmol = 33.3
+)
+
+// GlucoseUnitName returns the display name of a glucose unit
+func GlucoseUnitName(unit int) string {
+	if unit == GlucoseUnitMmol {
+		return "mmol/L"
+	}
+	return "mg/dL"
+}
+
+// ParseGlucoseUnit parses a glucose unit display name
+func ParseGlucoseUnit(name string) (int, error) {
+	switch name {
+	case "mg/dL":
+		return GlucoseUnitMgdl, nil
+	case "mmol/L":
+		return GlucoseUnitMmol, nil
+	default:
+		return 0, fmt.Errorf("invalid glucose unit %q (must be mg/dL or mmol/L)", name)
+	}
+}
+
+// BGRange returns the accepted BG entry range in unit
+func BGRange(unit int) (min, max float64) {
+	if unit == GlucoseUnitMmol {
+		return MinBGMmol, MaxBGMmol
+	}
+	return MinBGMgdl, MaxBGMgdl
+}
+
+// PumpConfig holds pump-wide global preferences
+type PumpConfig struct {
+	GlucoseUnit         int  `json:"glucoseUnit"`         // GlucoseUnitMgdl or GlucoseUnitMmol
+	SettingsLocked      bool `json:"settingsLocked"`      // feature lock on pump settings
+	LowInsulinThreshold int  `json:"lowInsulinThreshold"` // units remaining that trigger the low insulin alert
+	AutoShutdownEnabled bool `json:"autoShutdownEnabled"`
+	AutoShutdownHours   int  `json:"autoShutdownHours"`
+
+	Region   Region `json:"region"`   // market the pump is set up for, see SetRegion
+	Features uint64 `json:"features"` // Feature* bits the pump supports
+}
+
+// TherapyConfig holds global therapy limits
+type TherapyConfig struct {
+	MaxBolus     float64 `json:"maxBolus"`     // units
+	MaxBasalRate float64 `json:"maxBasalRate"` // units/hr
+	MaxIOB       float64 `json:"maxIob"`       // units
+
+	// MaxHourlyInsulin caps basal plus bolus delivered in any rolling hour
+	// (units); 0 disables the limit
+	MaxHourlyInsulin float64 `json:"maxHourlyInsulin"`
+
+	// InsulinDuration is the duration of insulin action IOB is computed
+	// over (minutes); 0 uses DefaultInsulinDuration
+	InsulinDuration int `json:"insulinDuration"`
+}
+
+// defaultPumpConfig returns the pump config of a freshly set up US pump
+func defaultPumpConfig() *PumpConfig {
+	us := regionDefaults[RegionUS]
+	return &PumpConfig{
+		GlucoseUnit:         us.GlucoseUnit,
+		LowInsulinThreshold: 20,
+		Region:              RegionUS,
+		Features:            us.Features,
+	}
+}
+
+// defaultTherapyConfig returns the therapy limits of a freshly set up US pump
+func defaultTherapyConfig() *TherapyConfig {
+	therapy := regionDefaults[RegionUS].Therapy
+	return &therapy
+}
+
+// GetPumpConfig returns a copy of the pump config
+func (ps *PumpState) GetPumpConfig() PumpConfig {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return *ps.PumpConfig
+}
+
+// Validate returns an error if the pump config has out of range values
+func (c PumpConfig) Validate() error {
+	if c.GlucoseUnit != GlucoseUnitMgdl && c.GlucoseUnit != GlucoseUnitMmol {
+		return fmt.Errorf("invalid glucose unit: %d", c.GlucoseUnit)
+	}
+	if c.LowInsulinThreshold < 0 || c.AutoShutdownHours < 0 {
+		return fmt.Errorf("low insulin threshold and auto shutdown hours must not be negative")
+	}
+	if _, err := DefaultsForRegion(c.Region); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetPumpConfig validates and replaces the pump config. An empty region is
+// stored as US.
+func (ps *PumpState) SetPumpConfig(cfg PumpConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	cfg.Region = cfg.Region.orDefault()
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.PumpConfig = &cfg
+	return nil
+}
+
+// GetGlucoseUnit returns the glucose units preference
+func (ps *PumpState) GetGlucoseUnit() int {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.PumpConfig.GlucoseUnit
+}
+
+// SetGlucoseUnit sets the glucose units preference, which BG entries are
+// then given in
+func (ps *PumpState) SetGlucoseUnit(unit int) error {
+	if unit != GlucoseUnitMgdl && unit != GlucoseUnitMmol {
+		return fmt.Errorf("invalid glucose unit: %d", unit)
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	cfg := *ps.PumpConfig
+	cfg.GlucoseUnit = unit
+	ps.PumpConfig = &cfg
+	return nil
+}
+
+// GetTherapyConfig returns a copy of the therapy limits
+func (ps *PumpState) GetTherapyConfig() TherapyConfig {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return *ps.TherapyConfig
+}
+
+// Validate returns an error if any therapy limit isn't positive, the
+// optional hourly limit is negative, or the insulin duration is out of range
+func (c TherapyConfig) Validate() error {
+	if c.MaxBolus <= 0 || c.MaxBasalRate <= 0 || c.MaxIOB <= 0 {
+		return fmt.Errorf("therapy limits must be positive: maxBolus=%.2f, maxBasalRate=%.2f, maxIob=%.2f",
+			c.MaxBolus, c.MaxBasalRate, c.MaxIOB)
+	}
+	if c.MaxHourlyInsulin < 0 {
+		return fmt.Errorf("hourly insulin limit must not be negative: %.2f", c.MaxHourlyInsulin)
+	}
+	if c.InsulinDuration != 0 && (c.InsulinDuration < MinInsulinDuration || c.InsulinDuration > MaxInsulinDuration) {
+		return fmt.Errorf("insulin duration must be %d-%d minutes: %d",
+			MinInsulinDuration, MaxInsulinDuration, c.InsulinDuration)
+	}
+	return nil
+}
+
+// SetTherapyConfig validates and replaces the therapy limits
+func (ps *PumpState) SetTherapyConfig(cfg TherapyConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.TherapyConfig = &cfg
+	return nil
+}
@@ -0,0 +1,80 @@
+package state
+
+import "time"
+
+// hourlyLimitWindow is the rolling window TherapyConfig.MaxHourlyInsulin
+// covers
+const hourlyLimitWindow = time.Hour
+
+// insulinDelivery is insulin delivered at one time, basal or bolus
+type insulinDelivery struct {
+	time  time.Time
+	units float64
+}
+
+// recordDelivery adds units delivered at now to the rolling hourly total
+// and to the insulin on board (must hold mutex)
+func (ps *PumpState) recordDelivery(now time.Time, units float64) {
+	if units <= 0 {
+		return
+	}
+	ps.hourlyDelivery = append(ps.hourlyDelivery, insulinDelivery{time: now, units: units})
+	ps.addInsulinDose(now, units)
+}
+
+// deliveredLastHour drops deliveries older than the rolling window and
+// returns the insulin delivered within it (must hold mutex)
+func (ps *PumpState) deliveredLastHour(now time.Time) float64 {
+	cutoff := now.Add(-hourlyLimitWindow)
+	i := 0
+	for i < len(ps.hourlyDelivery) && !ps.hourlyDelivery[i].time.After(cutoff) {
+		i++
+	}
+	ps.hourlyDelivery = ps.hourlyDelivery[i:]
+
+	total := 0.0
+	for _, d := range ps.hourlyDelivery {
+		total += d.units
+	}
+	return total
+}
+
+// hourlyRemaining returns how much more insulin may be delivered at now
+// before reaching the hourly limit. limited is false if there is no limit
+// (must hold mutex).
+func (ps *PumpState) hourlyRemaining(now time.Time) (units float64, limited bool) {
+	limit := ps.TherapyConfig.MaxHourlyInsulin
+	if limit <= 0 {
+		return 0, false
+	}
+	remaining := limit - ps.deliveredLastHour(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// RecordDelivery adds units delivered at now to the rolling hourly total
+// and to the insulin on board
+func (ps *PumpState) RecordDelivery(now time.Time, units float64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.recordDelivery(now, units)
+}
+
+// DeliveredLastHour returns the basal and bolus insulin delivered in the hour
+// up to now
+func (ps *PumpState) DeliveredLastHour(now time.Time) float64 {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.deliveredLastHour(now)
+}
+
+// HourlyInsulinRemaining returns how much more insulin may be delivered at
+// now before reaching TherapyConfig.MaxHourlyInsulin. limited is false if
+// the limit is disabled.
+func (ps *PumpState) HourlyInsulinRemaining(now time.Time) (units float64, limited bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.hourlyRemaining(now)
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// setHourlyLimit sets the hourly insulin limit, keeping the other defaults
+func setHourlyLimit(t *testing.T, ps *PumpState, units float64) {
+	t.Helper()
+	cfg := ps.GetTherapyConfig()
+	cfg.MaxHourlyInsulin = units
+	if err := ps.SetTherapyConfig(cfg); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+}
+
+// TestHourlyLimitResetsOnRollingWindow verifies deliveries stop counting
+// against the hourly limit once they're an hour old
+func TestHourlyLimitResetsOnRollingWindow(t *testing.T) {
+	ps := NewPumpState()
+	setHourlyLimit(t, ps, 10)
+	start := time.Unix(1700000000, 0)
+
+	ps.RecordDelivery(start, 6)
+	ps.RecordDelivery(start.Add(30*time.Minute), 3)
+
+	if remaining, limited := ps.HourlyInsulinRemaining(start.Add(45 * time.Minute)); !limited || remaining != 1 {
+		t.Errorf("Expected 1 unit left 45 minutes in, got %.2f (limited=%v)", remaining, limited)
+	}
+	if remaining, _ := ps.HourlyInsulinRemaining(start.Add(61 * time.Minute)); remaining != 7 {
+		t.Errorf("Expected the first delivery to roll off after an hour leaving 7 units, got %.2f", remaining)
+	}
+	if remaining, _ := ps.HourlyInsulinRemaining(start.Add(91 * time.Minute)); remaining != 10 {
+		t.Errorf("Expected the full limit once both deliveries rolled off, got %.2f", remaining)
+	}
+
+	setHourlyLimit(t, ps, 0)
+	if _, limited := ps.HourlyInsulinRemaining(start); limited {
+		t.Error("Expected a zero limit to disable it")
+	}
+}
+
+// TestHourlyLimitStopsBolusAndHoldsBasal verifies the simulator delivers no
+// more than the hourly limit, stopping a bolus short and raising an alert
+func TestHourlyLimitStopsBolusAndHoldsBasal(t *testing.T) {
+	ps := NewPumpState()
+	setHourlyLimit(t, ps, 2)
+	sim := NewSimulator(ps, time.Second)
+	ps.RecordDelivery(time.Now(), 1.9)
+
+	ps.StartBolus(1.0, 7)
+	ps.Bolus.StartTime = time.Now().Add(-10 * time.Second) // 0.5 units due
+	sim.updateBolusDelivery()
+
+	if ps.IsBolusActive() {
+		t.Error("Expected bolus to stop at the hourly limit")
+	}
+	if delivered := ps.Bolus.UnitsDelivered; delivered < 0.0999 || delivered > 0.1001 {
+		t.Errorf("Expected 0.1 units delivered before the limit, got %.4f", delivered)
+	}
+
+	reservoir := ps.Reservoir.CurrentUnits
+	sim.updateBasalDelivery()
+	if ps.Reservoir.CurrentUnits != reservoir {
+		t.Errorf("Expected basal held at the hourly limit, reservoir went %.4f -> %.4f", reservoir, ps.Reservoir.CurrentUnits)
+	}
+
+	alerts := 0
+	for _, alert := range ps.ActiveAlerts {
+		if alert.Type == AlertHourlyInsulinLimit {
+			alerts++
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("Expected one hourly limit alert, got %d", alerts)
+	}
+}
+
+// canceledNotifier records canceled bolus IDs
+type canceledNotifier struct {
+	NoOpEventNotifier
+	canceled []uint32
+}
+
+func (n *canceledNotifier) NotifyBolusCanceled(bolusID uint32, delivered float64, total float64) error {
+	n.canceled = append(n.canceled, bolusID)
+	return nil
+}
+
+// TestHourlyLimitDropsStackedBolusWithHistory verifies a bolus stacked
+// behind one stopped at the hourly limit is logged to history and notified
+// as canceled rather than dropped silently
+func TestHourlyLimitDropsStackedBolusWithHistory(t *testing.T) {
+	ps := NewPumpState()
+	setHourlyLimit(t, ps, 2)
+	notifier := &canceledNotifier{}
+	sim := NewSimulator(ps, time.Second)
+	sim.SetEventNotifier(notifier)
+	ps.RecordDelivery(time.Now(), 1.9)
+
+	ps.StartBolus(1.0, 7)
+	ps.StartBolus(0.5, 8)
+	ps.Bolus.StartTime = time.Now().Add(-10 * time.Second)
+	sim.updateBolusDelivery()
+
+	if len(ps.BolusQueue) != 0 {
+		t.Errorf("Expected the stacked bolus to be dropped, got %d queued", len(ps.BolusQueue))
+	}
+	if len(notifier.canceled) != 1 || notifier.canceled[0] != 8 {
+		t.Errorf("Expected bolus 8 notified as canceled, got %v", notifier.canceled)
+	}
+	var dropped bool
+	for _, entry := range ps.GetHistoryLogEntries(0, ps.HistoryLog.NextSequence) {
+		if entry.TypeID == HistoryBolusCompleted && entry.Data["bolusId"] == uint32(8) {
+			dropped = entry.Data["unitsDelivered"] == 0.0
+		}
+	}
+	if !dropped {
+		t.Error("Expected a history entry for bolus 8 with nothing delivered")
+	}
+}
+
+// TestBasalDeliveryUsesSimulatorClock verifies temp basal expiry, delivery
+// records and the hourly limit window all follow the simulator's clock
+func TestBasalDeliveryUsesSimulatorClock(t *testing.T) {
+	ps := NewPumpState()
+	setHourlyLimit(t, ps, 2)
+	sim := NewSimulator(ps, time.Second)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	sim.SetClock(func() time.Time { return now })
+
+	ps.Basal.TempBasalActive = true
+	ps.Basal.TempBasalRate = 3
+	ps.Basal.TempBasalEnd = start.Add(30 * time.Minute)
+	ps.RecordDelivery(start.Add(-time.Minute), 2)
+
+	reservoir := ps.Reservoir.CurrentUnits
+	sim.updateBasalDelivery()
+	if !ps.Basal.TempBasalActive {
+		t.Error("Expected the temp basal to run until its end by the simulator clock")
+	}
+	if ps.Reservoir.CurrentUnits != reservoir {
+		t.Errorf("Expected basal held at the hourly limit, reservoir went %.4f -> %.4f", reservoir, ps.Reservoir.CurrentUnits)
+	}
+	var raised time.Time
+	for _, alert := range ps.ActiveAlerts {
+		if alert.Type == AlertHourlyInsulinLimit {
+			raised = alert.Timestamp
+		}
+	}
+	if !raised.Equal(start) {
+		t.Errorf("Expected the hourly limit alert raised at %v, got %v", start, raised)
+	}
+
+	now = start.Add(time.Hour)
+	sim.updateBasalDelivery()
+	if ps.Basal.TempBasalActive {
+		t.Error("Expected the temp basal to expire by the simulator clock")
+	}
+	if remaining, _ := ps.HourlyInsulinRemaining(now); remaining >= 2 {
+		t.Errorf("Expected the basal delivered an hour in to be recorded at the simulator time, got %.4f remaining", remaining)
+	}
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PumpIdentity holds the model, serial and firmware the pump reports in
+// PumpVersionResponse and PumpVersionBResponse
+type PumpIdentity struct {
+	SerialNumber    string `json:"serialNumber"` // decimal digits; the protocol carries it as a long
+	Model           string `json:"model"`
+	ModelNumber     int64  `json:"modelNumber"`
+	FirmwareVersion string `json:"firmwareVersion"`
+}
+
+// serialFormats gives the number of decimal digits in the serial number of
+// each model family, matched against the lowercased model name. Both t:slim X2
+// and Mobi serials are eight digits.
+var serialFormats = []struct {
+	family string
+	digits int
+}{
+	{"mobi", 8},
+	{"slim", 8},
+}
+
+// ValidateSerial returns an error if serial isn't in model's serial number
+// format, so a misconfigured serial fails up front rather than when a client
+// refuses to pair. A serial for an unknown model only has to be a
+// non-negative integer, as the protocol carries it as a long.
+func ValidateSerial(serial, model string) error {
+	if _, err := (PumpIdentity{SerialNumber: serial}).SerialNumberValue(); err != nil {
+		return err
+	}
+
+	name := strings.ToLower(model)
+	for _, format := range serialFormats {
+		if !strings.Contains(name, format.family) {
+			continue
+		}
+		// A leading zero wouldn't survive the round trip through a long
+		if len(serial) != format.digits || strings.HasPrefix(serial, "0") {
+			return fmt.Errorf("%s serial number must be %d digits not starting with 0: %q", model, format.digits, serial)
+		}
+		return nil
+	}
+	return nil
+}
+
+// Validate returns an error if the identity can't be encoded in a version
+// response or its serial isn't in its model's format
+func (id PumpIdentity) Validate() error {
+	if err := ValidateSerial(id.SerialNumber, id.Model); err != nil {
+		return err
+	}
+	if id.ModelNumber < 0 {
+		return fmt.Errorf("model number must not be negative: %d", id.ModelNumber)
+	}
+	return nil
+}
+
+// SerialNumberValue returns the serial number as the long the protocol carries
+func (id PumpIdentity) SerialNumberValue() (int64, error) {
+	serial, err := strconv.ParseInt(id.SerialNumber, 10, 64)
+	if err != nil || serial < 0 {
+		return 0, fmt.Errorf("serial number must be a non-negative integer: %q", id.SerialNumber)
+	}
+	return serial, nil
+}
+
+// GetIdentity returns the pump's identity
+func (ps *PumpState) GetIdentity() PumpIdentity {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return PumpIdentity{
+		SerialNumber:    ps.SerialNumber,
+		Model:           ps.Model,
+		ModelNumber:     ps.ModelNumber,
+		FirmwareVersion: ps.FirmwareVersion,
+	}
+}
+
+// SetIdentity validates and replaces the pump's identity. Changing the
+// model switches to the new model's default insulin concentration.
+func (ps *PumpState) SetIdentity(id PumpIdentity) error {
+	if err := id.Validate(); err != nil {
+		return err
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if id.Model != ps.Model {
+		ps.setInsulinConcentration(ModelInsulinConcentration(id.Model))
+	}
+	ps.SerialNumber = id.SerialNumber
+	ps.Model = id.Model
+	ps.ModelNumber = id.ModelNumber
+	ps.FirmwareVersion = id.FirmwareVersion
+	return nil
+}
@@ -0,0 +1,50 @@
+package state
+
+import "testing"
+
+// TestValidateSerial verifies t:slim X2 and Mobi serials must be eight
+// digits not starting with 0, while an unknown model accepts any
+// non-negative integer
+func TestValidateSerial(t *testing.T) {
+	tests := []struct {
+		serial string
+		model  string
+		valid  bool
+	}{
+		{"11223344", "t:slim X2", true},
+		{"90817263", "Tandem Mobi", true},
+		{"1122334", "t:slim X2", false},
+		{"112233445", "t:slim X2", false},
+		{"01223344", "t:slim X2", false},
+		{"976", "Tandem Mobi", false},
+		{"bi 976", "Tandem Mobi", false},
+		{"9081726a", "Tandem Mobi", false},
+		{"-1223344", "t:slim X2", false},
+		{"123", "Custom Pump", true},
+		{"abc", "Custom Pump", false},
+	}
+	for _, tt := range tests {
+		err := ValidateSerial(tt.serial, tt.model)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateSerial(%q, %q): expected valid, got %v", tt.serial, tt.model, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ValidateSerial(%q, %q): expected an error", tt.serial, tt.model)
+		}
+	}
+}
+
+// TestSetIdentityRejectsSerialForModel verifies a serial in the wrong format
+// for the configured model leaves the identity unchanged
+func TestSetIdentityRejectsSerialForModel(t *testing.T) {
+	ps := NewPumpState()
+	id := ps.GetIdentity()
+	id.Model = "Tandem Mobi"
+	id.SerialNumber = "976"
+	if err := ps.SetIdentity(id); err == nil {
+		t.Fatal("Expected a 3 digit Mobi serial to be rejected")
+	}
+	if got := ps.GetIdentity(); got.SerialNumber != "11223344" || got.Model != "t:slim X2" {
+		t.Errorf("Expected the identity to be unchanged, got %+v", got)
+	}
+}
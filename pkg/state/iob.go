@@ -0,0 +1,118 @@
+package state
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultInsulinDuration is the duration of insulin action (minutes) used
+// when TherapyConfig.InsulinDuration is unset
+const DefaultInsulinDuration = 300
+
+// MinInsulinDuration and MaxInsulinDuration bound the duration of insulin
+// action (minutes) the pump accepts
+const (
+	MinInsulinDuration = 120
+	MaxInsulinDuration = 480
+)
+
+// insulinPeakTime is when a rapid-acting insulin dose is most active
+const insulinPeakTime = 75 * time.Minute
+
+// doseMergeWindow merges deliveries this close together into one dose, so
+// basal delivered every simulator update doesn't grow the dose list unbounded
+const doseMergeWindow = time.Minute
+
+// iobFraction returns the fraction of a dose still on board elapsed after it
+// was delivered, by the exponential insulin activity curve Loop and oref0
+// use, for a duration of insulin action dia
+func iobFraction(elapsed, dia time.Duration) float64 {
+	if elapsed <= 0 {
+		return 1
+	}
+	if elapsed >= dia {
+		return 0
+	}
+
+	// The curve needs its peak before half the duration of action
+	td := dia.Minutes()
+	tp := math.Min(insulinPeakTime.Minutes(), td/3)
+	t := elapsed.Minutes()
+
+	tau := tp * (1 - tp/td) / (1 - 2*tp/td)
+	a := 2 * tau / td
+	s := 1 / (1 - a + (1+a)*math.Exp(-td/tau))
+	return 1 - s*(1-a)*((t*t/(tau*td*(1-a))-t/tau-1)*math.Exp(-t/tau)+1)
+}
+
+// basalIOBHours returns how many hours of a steady basal rate are on board
+// once IOB settles, for a duration of insulin action dia
+func basalIOBHours(dia time.Duration) float64 {
+	hours := 0.0
+	for t := time.Duration(0); t < dia; t += time.Minute {
+		hours += iobFraction(t, dia) / 60
+	}
+	return hours
+}
+
+// insulinDuration returns the configured duration of insulin action (must
+// hold mutex)
+func (ps *PumpState) insulinDuration() time.Duration {
+	minutes := ps.TherapyConfig.InsulinDuration
+	if minutes == 0 {
+		minutes = DefaultInsulinDuration
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// addInsulinDose tracks units delivered at now towards IOB (must hold mutex)
+func (ps *PumpState) addInsulinDose(now time.Time, units float64) {
+	if n := len(ps.insulinDoses); n > 0 && now.Sub(ps.insulinDoses[n-1].time) < doseMergeWindow {
+		ps.insulinDoses[n-1].units += units
+		return
+	}
+	ps.insulinDoses = append(ps.insulinDoses, insulinDelivery{time: now, units: units})
+}
+
+// iobAt drops doses that have finished acting and returns the sum of the
+// insulin still on board from the rest at now (must hold mutex)
+func (ps *PumpState) iobAt(now time.Time) float64 {
+	dia := ps.insulinDuration()
+	i := 0
+	for i < len(ps.insulinDoses) && now.Sub(ps.insulinDoses[i].time) >= dia {
+		i++
+	}
+	ps.insulinDoses = ps.insulinDoses[i:]
+
+	iob := 0.0
+	for _, dose := range ps.insulinDoses {
+		iob += dose.units * iobFraction(now.Sub(dose.time), dia)
+	}
+	return iob
+}
+
+// GetIOB returns the insulin on board now
+func (ps *PumpState) GetIOB() float64 {
+	return ps.IOBAt(time.Now())
+}
+
+// IOBAt returns the insulin on board at now: what remains of each basal and
+// bolus dose by the insulin activity curve for the configured duration of
+// action
+func (ps *PumpState) IOBAt(now time.Time) float64 {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.iobAt(now)
+}
+
+// SetIOB replaces the insulin on board with a single dose of units delivered
+// now, which then decays like any other dose
+func (ps *PumpState) SetIOB(units float64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.insulinDoses = nil
+	if units > 0 {
+		ps.addInsulinDose(time.Now(), units)
+	}
+}
@@ -0,0 +1,109 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// TestIOBFractionCurve verifies a dose starts fully on board and decays
+// steadily to nothing at the end of the duration of insulin action
+func TestIOBFractionCurve(t *testing.T) {
+	for _, minutes := range []int{MinInsulinDuration, DefaultInsulinDuration, MaxInsulinDuration} {
+		dia := time.Duration(minutes) * time.Minute
+		if f := iobFraction(0, dia); f != 1 {
+			t.Errorf("DIA %d: expected all on board at delivery, got %.4f", minutes, f)
+		}
+		if f := iobFraction(dia-time.Second, dia); f < 0 || f > 0.001 {
+			t.Errorf("DIA %d: expected almost none on board at the end, got %.4f", minutes, f)
+		}
+		if f := iobFraction(dia, dia); f != 0 {
+			t.Errorf("DIA %d: expected none on board after the duration, got %.4f", minutes, f)
+		}
+
+		prev := 1.0
+		for elapsed := time.Minute; elapsed < dia; elapsed += time.Minute {
+			f := iobFraction(elapsed, dia)
+			if f > prev {
+				t.Fatalf("DIA %d: IOB rose from %.4f to %.4f at %v", minutes, prev, f, elapsed)
+			}
+			prev = f
+		}
+	}
+
+	// Little insulin has acted before the peak, most of it by half the DIA
+	dia := DefaultInsulinDuration * time.Minute
+	if f := iobFraction(30*time.Minute, dia); f < 0.85 {
+		t.Errorf("Expected most of a dose on board after 30 minutes, got %.4f", f)
+	}
+	if f := iobFraction(dia/2, dia); f < 0.2 || f > 0.5 {
+		t.Errorf("Expected 20-50%% of a dose on board at half the DIA, got %.4f", f)
+	}
+}
+
+// TestIOBSumsDosesByDuration verifies IOB sums what remains of each dose and
+// a longer duration of insulin action keeps insulin on board longer
+func TestIOBSumsDosesByDuration(t *testing.T) {
+	ps := NewPumpState()
+	start := time.Unix(1700000000, 0)
+	ps.RecordDelivery(start, 2)
+	ps.RecordDelivery(start.Add(2*time.Hour), 3)
+
+	dia := DefaultInsulinDuration * time.Minute
+	at := start.Add(3 * time.Hour)
+	want := 2*iobFraction(3*time.Hour, dia) + 3*iobFraction(time.Hour, dia)
+	if iob := ps.IOBAt(at); iob != want {
+		t.Errorf("Expected %.4f units on board, got %.4f", want, iob)
+	}
+
+	cfg := ps.GetTherapyConfig()
+	cfg.InsulinDuration = MaxInsulinDuration
+	if err := ps.SetTherapyConfig(cfg); err != nil {
+		t.Fatalf("SetTherapyConfig failed: %v", err)
+	}
+	if iob := ps.IOBAt(at); iob <= want {
+		t.Errorf("Expected a longer DIA to leave more than %.4f units on board, got %.4f", want, iob)
+	}
+
+	if iob := ps.IOBAt(start.Add(10 * time.Hour)); iob != 0 {
+		t.Errorf("Expected no insulin on board once every dose finished acting, got %.4f", iob)
+	}
+}
+
+// TestSetIOBReplacesDoses verifies SetIOB drops earlier doses for a single
+// dose delivered now, and setting zero clears insulin on board
+func TestSetIOBReplacesDoses(t *testing.T) {
+	ps := NewPumpState()
+	ps.RecordDelivery(time.Now().Add(-time.Hour), 5)
+
+	ps.SetIOB(2)
+	now := time.Now()
+	if iob := ps.IOBAt(now); iob < 1.99 || iob > 2 {
+		t.Errorf("Expected 2 units on board, got %.4f", iob)
+	}
+	if iob := ps.IOBAt(now.Add(time.Hour)); iob >= 2 {
+		t.Errorf("Expected the set IOB to decay, got %.4f", iob)
+	}
+
+	ps.SetIOB(0)
+	if iob := ps.GetIOB(); iob != 0 {
+		t.Errorf("Expected no insulin on board, got %.4f", iob)
+	}
+}
+
+// TestTherapyConfigValidatesInsulinDuration verifies the duration of insulin
+// action is unset or within the pump's range
+func TestTherapyConfigValidatesInsulinDuration(t *testing.T) {
+	cfg := TherapyConfig{MaxBolus: 25, MaxBasalRate: 5, MaxIOB: 15}
+	for _, minutes := range []int{0, MinInsulinDuration, MaxInsulinDuration} {
+		cfg.InsulinDuration = minutes
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected insulin duration %d to be valid: %v", minutes, err)
+		}
+	}
+	for _, minutes := range []int{-1, MinInsulinDuration - 1, MaxInsulinDuration + 1} {
+		cfg.InsulinDuration = minutes
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected insulin duration %d to be rejected", minutes)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package state
+
+import "sync/atomic"
+
+// SetLowPower enters or leaves the low-power state a client requests when it
+// disconnects to save battery. While low power, the simulator skips
+// synthetic scheduled events and only critical alerts are announced.
+func (ps *PumpState) SetLowPower(lowPower bool) {
+	var v int32
+	if lowPower {
+		v = 1
+	}
+	atomic.StoreInt32(&ps.lowPower, v)
+}
+
+// IsLowPower returns true while the pump is in the low-power state. It
+// doesn't take the mutex, so notifiers may call it while it's held.
+func (ps *PumpState) IsLowPower() bool {
+	return atomic.LoadInt32(&ps.lowPower) == 1
+}
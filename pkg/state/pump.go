@@ -12,6 +12,7 @@ type PumpState struct {
 	// Identity
 	SerialNumber    string
 	Model           string
+	ModelNumber     int64
 	FirmwareVersion string
 	APIVersionMajor int
 	APIVersionMinor int
@@ -20,6 +21,7 @@ type PumpState struct {
 	TimeSinceReset uint32 // seconds since pump was turned on
 	CurrentTime    time.Time
 	StartTime      time.Time // When simulation started
+	ClockDrift     float64   // seconds the pump clock gains per hour of real time (negative runs slow)
 
 	// Authentication
 	AuthKey         []byte
@@ -35,11 +37,21 @@ type PumpState struct {
 	// so the emulator can honor that quick-pair flow.
 	LongTermKey []byte
 
+	// AppSession is the app the current auth session belongs to, nil until
+	// the connected app sends its appInstanceId
+	AppSession *AppSession
+	centralID  string
+
 	// Insulin Delivery
 	Basal *BasalState
 	Bolus *BolusState
-	IOB   float64 // Insulin on board
-	TDD   float64 // Total daily dose
+	// BolusQueue holds boluses stacked behind the one delivering, in the
+	// order they'll be delivered
+	BolusQueue []*BolusState
+	// LastBolus is the bolus that most recently finished or was stopped,
+	// nil until one has
+	LastBolus *BolusState
+	TDD       float64 // Total daily dose
 
 	// Physical State
 	Reservoir *ReservoirState
@@ -52,38 +64,66 @@ type PumpState struct {
 	// History Log
 	HistoryLog *HistoryLogState
 
+	// Control-IQ automatic treatment decisions
+	ControlIQ *ControlIQState
+
+	// Global preferences and therapy limits
+	PumpConfig    *PumpConfig
+	TherapyConfig *TherapyConfig
+
 	// Pump mode
 	PumpingSuspended bool
 	ControlIQMode    int // 0=Normal, 1=Sleep, 2=Exercise
 
 	// Alerts/Alarms
 	ActiveAlerts []Alert
+	lastAlertID  uint32
+
+	// Daily window when non-critical alerts aren't announced, nil if none
+	QuietHours *QuietHours
+
+	// Low-power state a client puts the pump in to save battery, 1 if set;
+	// accessed atomically rather than under mutex
+	lowPower int32
+
+	// Insulin delivered within the last hour, for the hourly limit
+	hourlyDelivery []insulinDelivery
+
+	// Insulin delivered within the duration of insulin action, for IOB
+	insulinDoses []insulinDelivery
+
+	// Notifications queued while the mutex is held, sent by unlock
+	afterUnlockFns []func()
 
 	mutex sync.RWMutex
 }
 
 // BasalState represents basal delivery state
 type BasalState struct {
-	CurrentRate     float64 // units/hr
-	TempBasalActive bool
-	TempBasalRate   float64
-	TempBasalEnd    time.Time
+	CurrentRate      float64   `json:"currentRate"` // units/hr
+	TempBasalActive  bool      `json:"tempBasalActive"`
+	TempBasalRate    float64   `json:"tempBasalRate"`
+	TempBasalPercent int       `json:"tempBasalPercent"` // temp rate as a percentage of CurrentRate
+	TempBasalStart   time.Time `json:"tempBasalStart"`
+	TempBasalEnd     time.Time `json:"tempBasalEnd"`
 }
 
 // BolusState represents active bolus state
 type BolusState struct {
-	Active         bool
-	UnitsDelivered float64
-	UnitsTotal     float64
-	StartTime      time.Time
-	BolusID        uint32
+	Active         bool      `json:"active"`
+	UnitsDelivered float64   `json:"unitsDelivered"`
+	UnitsTotal     float64   `json:"unitsTotal"`
+	StartTime      time.Time `json:"startTime"`
+	BolusID        uint32    `json:"bolusId"`
+	Automatic      bool      `json:"automatic"` // started by Control-IQ rather than the user
 }
 
 // ReservoirState represents reservoir state
 type ReservoirState struct {
-	CurrentUnits float64
-	MaxUnits     float64
-	LastFill     time.Time
+	CurrentUnits  float64
+	MaxUnits      float64
+	LastFill      time.Time
+	Concentration InsulinConcentration
 }
 
 // BatteryState represents battery state
@@ -100,25 +140,29 @@ type CartridgeState struct {
 
 // CGMState represents CGM sensor state
 type CGMState struct {
-	SensorType    int    // CGM sensor type ordinal
-	SessionActive bool   // Whether a CGM session is active
-	CurrentEGV    int    // Current estimated glucose value (mg/dL)
-	TransmitterID string // CGM transmitter ID
+	SensorType    int    `json:"sensorType"`    // CGM sensor type ordinal
+	SessionActive bool   `json:"sessionActive"` // Whether a CGM session is active
+	CurrentEGV    int    `json:"currentEgv"`    // Current estimated glucose value (mg/dL)
+	TransmitterID string `json:"transmitterId"` // CGM transmitter ID
+
+	TrendRate   float64   `json:"trendRate"`   // mg/dL per minute, from the last two readings
+	LastReading time.Time `json:"lastReading"` // when the simulator last took a reading
 }
 
 // HistoryLogEntry represents a single history log entry
 type HistoryLogEntry struct {
-	Sequence  uint32
-	TypeID    int    // Numeric type ID matching pumpX2 history log types
-	Type      string // Human-readable type name
-	Timestamp time.Time
-	Data      map[string]interface{}
+	Sequence  uint32                 `json:"sequence"`
+	TypeID    int                    `json:"typeId"` // Numeric type ID matching pumpX2 history log types
+	Type      string                 `json:"type"`   // Human-readable type name
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
 }
 
 // HistoryLogState represents history log storage
 type HistoryLogState struct {
 	NextSequence uint32
 	Entries      []HistoryLogEntry
+	notifier     EventNotifier // told of each appended entry, if set
 	mutex        sync.Mutex
 }
 
@@ -141,6 +185,7 @@ const (
 	AlertCartridgeExpired
 	AlertOcclusion
 	AlertBasalSuspended
+	AlertHourlyInsulinLimit
 )
 
 // AlertPriority indicates alert severity
@@ -158,7 +203,8 @@ func NewPumpState() *PumpState {
 
 	return &PumpState{
 		SerialNumber:    "11223344",
-		Model:           "t:slim X2",
+		Model:           ModelTSlimX2,
+		ModelNumber:     1004000, // modelNum from a captured Tandem Mobi PumpVersionResponse
 		FirmwareVersion: "7.6.0.0",
 		APIVersionMajor: 2,
 		APIVersionMinor: 5,
@@ -179,13 +225,13 @@ func NewPumpState() *PumpState {
 			Active: false,
 		},
 
-		IOB: 0.0,
 		TDD: 0.0,
 
 		Reservoir: &ReservoirState{
-			CurrentUnits: 200.0,
-			MaxUnits:     300.0,
-			LastFill:     now,
+			CurrentUnits:  200.0,
+			MaxUnits:      300.0,
+			LastFill:      now,
+			Concentration: ModelInsulinConcentration(ModelTSlimX2),
 		},
 
 		Battery: &BatteryState{
@@ -205,6 +251,14 @@ func NewPumpState() *PumpState {
 			TransmitterID: "80AB12",
 		},
 
+		ControlIQ: &ControlIQState{
+			Enabled:          true,
+			CorrectionFactor: 50.0,
+		},
+
+		PumpConfig:    defaultPumpConfig(),
+		TherapyConfig: defaultTherapyConfig(),
+
 		HistoryLog: &HistoryLogState{
 			NextSequence: 1,
 			Entries:      make([]HistoryLogEntry, 0),
@@ -224,12 +278,56 @@ func (ps *PumpState) GetTimeSinceReset() uint32 {
 
 // UpdateTimeSinceReset updates the time since reset
 func (ps *PumpState) UpdateTimeSinceReset() {
+	ps.UpdateTimeSinceResetAt(time.Now())
+}
+
+// UpdateTimeSinceResetAt updates the time since reset as of real time now
+func (ps *PumpState) UpdateTimeSinceResetAt(now time.Time) {
 	ps.mutex.Lock()
 	defer ps.mutex.Unlock()
 
-	elapsed := time.Since(ps.StartTime)
-	ps.TimeSinceReset = uint32(elapsed.Seconds())
-	ps.CurrentTime = time.Now()
+	ps.updateTimeSinceReset(now)
+}
+
+// updateTimeSinceReset updates the time since reset and current time as of
+// real time now, in pump time; callers must hold the lock
+func (ps *PumpState) updateTimeSinceReset(now time.Time) {
+	pumpNow := ps.pumpTime(now)
+	ps.TimeSinceReset = uint32(pumpNow.Sub(ps.StartTime).Seconds())
+	ps.CurrentTime = pumpNow
+}
+
+// SetClockDrift sets how many seconds the pump clock gains per hour of real
+// time since StartTime; negative values make it run slow
+func (ps *PumpState) SetClockDrift(secondsPerHour float64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.ClockDrift = secondsPerHour
+}
+
+// GetClockDrift returns the pump clock drift in seconds per hour
+func (ps *PumpState) GetClockDrift() float64 {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return ps.ClockDrift
+}
+
+// PumpTime returns the time the pump's clock shows at real time now
+func (ps *PumpState) PumpTime(now time.Time) time.Time {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return ps.pumpTime(now)
+}
+
+// pumpTime applies the drift accumulated since StartTime to now; callers
+// must hold the lock
+func (ps *PumpState) pumpTime(now time.Time) time.Time {
+	elapsed := now.Sub(ps.StartTime)
+	drift := time.Duration(float64(elapsed) * ps.ClockDrift / time.Hour.Seconds())
+	return now.Add(drift)
 }
 
 // SetAuthenticated marks the pump as authenticated
@@ -343,9 +441,28 @@ func (ps *PumpState) GetBasalRate() float64 {
 	ps.mutex.RLock()
 	defer ps.mutex.RUnlock()
 
+	return ps.effectiveBasalRate()
+}
+
+// GetProfileBasalRate returns the profile (non-temp) basal rate in units/hr,
+// ignoring any temp rate or Control-IQ adjustment
+func (ps *PumpState) GetProfileBasalRate() float64 {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return ps.Basal.CurrentRate
+}
+
+// effectiveBasalRate returns the rate actually being delivered: a temp rate
+// takes precedence over a Control-IQ adjustment, which takes precedence over
+// the profile rate (must hold mutex)
+func (ps *PumpState) effectiveBasalRate() float64 {
 	if ps.Basal.TempBasalActive {
 		return ps.Basal.TempBasalRate
 	}
+	if ps.ControlIQ != nil && ps.ControlIQ.AdjustedBasalRate > 0 {
+		return ps.ControlIQ.AdjustedBasalRate
+	}
 	return ps.Basal.CurrentRate
 }
 
@@ -354,25 +471,27 @@ func (ps *PumpState) GetNextBolusID() uint32 {
 	ps.mutex.RLock()
 	defer ps.mutex.RUnlock()
 
-	// Simple incrementing ID based on time
-	return uint32(time.Now().Unix() % 1000000)
+	// Simple incrementing ID based on time, past any active bolus's so
+	// stacked boluses get distinct IDs. Bolus IDs are 16 bits on the wire.
+	id := uint32(time.Now().Unix() % 0x10000)
+	for _, bolus := range ps.activeBoluses() {
+		if bolus.BolusID >= id {
+			id = bolus.BolusID + 1
+		}
+	}
+	return id
 }
 
-// StartBolus starts a bolus delivery
-func (ps *PumpState) StartBolus(units float64, bolusID uint32) {
+// StartBolus starts a bolus delivery, or stacks it behind the bolus already
+// delivering. It fails if MaxStackedBoluses are already active.
+func (ps *PumpState) StartBolus(units float64, bolusID uint32) error {
 	ps.mutex.Lock()
 	defer ps.mutex.Unlock()
 
-	ps.Bolus.Active = true
-	ps.Bolus.UnitsTotal = units
-	ps.Bolus.UnitsDelivered = 0
-	ps.Bolus.StartTime = time.Now()
-	ps.Bolus.BolusID = bolusID
-
-	log.Infof("Started bolus: %.2f units, ID=%d", units, bolusID)
+	return ps.startBolus(&BolusState{UnitsTotal: units, BolusID: bolusID}, time.Now())
 }
 
-// StopBolus stops an active bolus
+// StopBolus stops the delivering bolus, starting the next stacked one
 func (ps *PumpState) StopBolus() {
 	ps.mutex.Lock()
 	defer ps.mutex.Unlock()
@@ -380,7 +499,7 @@ func (ps *PumpState) StopBolus() {
 	if ps.Bolus.Active {
 		log.Infof("Stopped bolus: delivered %.2f of %.2f units",
 			ps.Bolus.UnitsDelivered, ps.Bolus.UnitsTotal)
-		ps.Bolus.Active = false
+		ps.finishBolus(time.Now())
 	}
 }
 
@@ -392,8 +511,8 @@ func (ps *PumpState) UpdateBolusDelivery(delivered float64) {
 	if ps.Bolus.Active {
 		ps.Bolus.UnitsDelivered = delivered
 		if ps.Bolus.UnitsDelivered >= ps.Bolus.UnitsTotal {
-			ps.Bolus.Active = false
 			log.Infof("Bolus complete: %.2f units delivered", ps.Bolus.UnitsDelivered)
+			ps.finishBolus(time.Now())
 		}
 	}
 }
@@ -439,14 +558,27 @@ func (ps *PumpState) GetHistoryLogCount() int {
 	return len(ps.HistoryLog.Entries)
 }
 
-// AddHistoryLogEntry adds a new history log entry.
-// Uses its own mutex so it can be called while pumpState mutex is held.
+// AddHistoryLogEntry adds a new history log entry (must NOT hold mutex)
 func (ps *PumpState) AddHistoryLogEntry(entryType string, data map[string]interface{}) {
 	ps.AddHistoryLogEntryWithTypeID(0, entryType, data)
 }
 
-// AddHistoryLogEntryWithTypeID adds a history log entry with a specific type ID.
+// AddHistoryLogEntryWithTypeID adds a history log entry with a specific type
+// ID (must NOT hold mutex, since the history notifier may read pump state)
 func (ps *PumpState) AddHistoryLogEntryWithTypeID(typeID int, entryType string, data map[string]interface{}) {
+	ps.notifyHistoryLogUpdated(ps.appendHistoryLogEntry(typeID, entryType, data))
+}
+
+// addHistoryLogEntry adds a history log entry, notifying it once unlock
+// releases the mutex (must hold mutex)
+func (ps *PumpState) addHistoryLogEntry(typeID int, entryType string, data map[string]interface{}) {
+	entry := ps.appendHistoryLogEntry(typeID, entryType, data)
+	ps.afterUnlock(func() { ps.notifyHistoryLogUpdated(entry) })
+}
+
+// appendHistoryLogEntry appends a history log entry under the history log's
+// own mutex and returns it
+func (ps *PumpState) appendHistoryLogEntry(typeID int, entryType string, data map[string]interface{}) HistoryLogEntry {
 	ps.HistoryLog.mutex.Lock()
 	defer ps.HistoryLog.mutex.Unlock()
 
@@ -459,6 +591,29 @@ func (ps *PumpState) AddHistoryLogEntryWithTypeID(typeID int, entryType string,
 	}
 	ps.HistoryLog.Entries = append(ps.HistoryLog.Entries, entry)
 	ps.HistoryLog.NextSequence++
+	return entry
+}
+
+// notifyHistoryLogUpdated tells the history notifier, if set, of entry
+func (ps *PumpState) notifyHistoryLogUpdated(entry HistoryLogEntry) {
+	ps.HistoryLog.mutex.Lock()
+	notifier := ps.HistoryLog.notifier
+	ps.HistoryLog.mutex.Unlock()
+
+	if notifier != nil {
+		if err := notifier.NotifyHistoryLogUpdated(entry.Sequence); err != nil {
+			log.Warnf("Failed to notify history log update: %v", err)
+		}
+	}
+}
+
+// SetHistoryLogNotifier sets the notifier told of each appended history
+// entry, from any writer of the history. It's told after the mutex is
+// released, so it may read pump state.
+func (ps *PumpState) SetHistoryLogNotifier(notifier EventNotifier) {
+	ps.HistoryLog.mutex.Lock()
+	defer ps.HistoryLog.mutex.Unlock()
+	ps.HistoryLog.notifier = notifier
 }
 
 // GetHistoryLogEntries returns history log entries in a sequence range
@@ -475,6 +630,25 @@ func (ps *PumpState) GetHistoryLogEntries(startSeq, endSeq uint32) []HistoryLogE
 	return entries
 }
 
+// GetHistoryLogPage returns up to limit history log entries in a sequence
+// range, oldest first. If entries in the range remain past the page, more is
+// true and next is the sequence to resume from.
+func (ps *PumpState) GetHistoryLogPage(startSeq, endSeq uint32, limit int) (page []HistoryLogEntry, next uint32, more bool) {
+	ps.HistoryLog.mutex.Lock()
+	defer ps.HistoryLog.mutex.Unlock()
+
+	for _, entry := range ps.HistoryLog.Entries {
+		if entry.Sequence < startSeq || entry.Sequence > endSeq {
+			continue
+		}
+		if len(page) == limit {
+			return page, entry.Sequence, true
+		}
+		page = append(page, entry)
+	}
+	return page, 0, false
+}
+
 // SetPumpingSuspended sets the pumping suspended state
 func (ps *PumpState) SetPumpingSuspended(suspended bool) {
 	ps.mutex.Lock()
@@ -506,6 +680,13 @@ func (ps *PumpState) SetBasalState(basal *BasalState) {
 	ps.Basal = basal
 }
 
+// SetBasalRate updates the profile (non-temp) basal rate in units/hr
+func (ps *PumpState) SetBasalRate(rate float64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.Basal.CurrentRate = rate
+}
+
 // SetReservoirLevel updates the reservoir level
 func (ps *PumpState) SetReservoirLevel(units float64) {
 	ps.mutex.Lock()
@@ -520,11 +701,71 @@ func (ps *PumpState) SetBatteryLevel(pct int) {
 	ps.Battery.Percentage = pct
 }
 
-// AddAlert adds an alert to the active alerts list
-func (ps *PumpState) AddAlert(alert Alert) {
+// afterUnlock queues fn to run once unlock releases the mutex, for
+// notifications whose receivers read pump state (must hold mutex)
+func (ps *PumpState) afterUnlock(fn func()) {
+	ps.afterUnlockFns = append(ps.afterUnlockFns, fn)
+}
+
+// unlock releases the mutex and then runs the functions queued by
+// afterUnlock while it was held
+func (ps *PumpState) unlock() {
+	fns := ps.afterUnlockFns
+	ps.afterUnlockFns = nil
+	ps.mutex.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// AddAlert adds an alert to the active alerts list, assigning it the next
+// alert ID if it has none, and returns the alert as added
+func (ps *PumpState) AddAlert(alert Alert) Alert {
+	ps.mutex.Lock()
+	defer ps.unlock()
+	return ps.addAlert(alert)
+}
+
+// GetActiveAlerts returns a copy of the active alerts
+func (ps *PumpState) GetActiveAlerts() []Alert {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return append([]Alert{}, ps.ActiveAlerts...)
+}
+
+// SetActiveAlerts replaces the active alerts, e.g. when restoring a state
+// dump. Unlike AddAlert the alerts keep their IDs and aren't logged to
+// history again; later alerts are numbered after the highest ID.
+func (ps *PumpState) SetActiveAlerts(alerts []Alert) {
 	ps.mutex.Lock()
 	defer ps.mutex.Unlock()
+
+	ps.ActiveAlerts = append([]Alert(nil), alerts...)
+	for _, alert := range alerts {
+		if alert.ID > ps.lastAlertID {
+			ps.lastAlertID = alert.ID
+		}
+	}
+}
+
+// addAlert adds an alert, assigning it the next alert ID if it has none, and
+// logs it to history (must hold mutex). IDs keep counting up so a pruned alert's ID isn't
+// reused.
+func (ps *PumpState) addAlert(alert Alert) Alert {
+	if alert.ID == 0 {
+		ps.lastAlertID++
+		alert.ID = ps.lastAlertID
+	} else if alert.ID > ps.lastAlertID {
+		ps.lastAlertID = alert.ID
+	}
 	ps.ActiveAlerts = append(ps.ActiveAlerts, alert)
+	ps.addHistoryLogEntry(HistoryAlertActivated, "AlertActivated", map[string]interface{}{
+		"alertId":   alert.ID,
+		"alertType": int(alert.Type),
+		"priority":  int(alert.Priority),
+	})
+	return alert
 }
 
 // SetControlIQMode sets the ControlIQ mode
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// historyNotifier records history log update sequences
+type historyNotifier struct {
+	NoOpEventNotifier
+	sequences []uint32
+}
+
+func (n *historyNotifier) NotifyHistoryLogUpdated(sequence uint32) error {
+	n.sequences = append(n.sequences, sequence)
+	return nil
+}
+
+// TestAddHistoryLogEntryNotifiesSequence verifies each appended entry fires
+// the history log updated event with the new high-water sequence
+func TestAddHistoryLogEntryNotifiesSequence(t *testing.T) {
+	ps := NewPumpState()
+	notifier := &historyNotifier{}
+	ps.SetHistoryLogNotifier(notifier)
+
+	ps.AddHistoryLogEntry("first", nil)
+	ps.AddHistoryLogEntryWithTypeID(HistoryBasalRateChange, "BasalRateChange", nil)
+	// Logged with the mutex held, and notified once it's released
+	ps.AddAlert(Alert{Type: AlertLowReservoir, Priority: PriorityWarning})
+
+	if len(notifier.sequences) != 3 || notifier.sequences[0] != 1 || notifier.sequences[1] != 2 || notifier.sequences[2] != 3 {
+		t.Fatalf("Expected sequences [1 2 3], got %v", notifier.sequences)
+	}
+	entries := ps.GetHistoryLogEntries(2, 2)
+	if len(entries) != 1 || entries[0].Type != "BasalRateChange" {
+		t.Errorf("Expected notified sequence 2 to be the BasalRateChange entry, got %+v", entries)
+	}
+}
+
+// TestAddHistoryLogEntryWithoutNotifier verifies appending works before a
+// notifier is set
+func TestAddHistoryLogEntryWithoutNotifier(t *testing.T) {
+	ps := NewPumpState()
+	ps.AddHistoryLogEntry("first", nil)
+	if ps.GetHistoryLogCount() != 1 {
+		t.Errorf("Expected 1 entry, got %d", ps.GetHistoryLogCount())
+	}
+}
+
+// TestGetHistoryLogPageLimitsAndResumes verifies a page stops at the limit
+// and reports the sequence the next page starts from
+func TestGetHistoryLogPageLimitsAndResumes(t *testing.T) {
+	ps := NewPumpState()
+	for i := 0; i < 5; i++ {
+		ps.AddHistoryLogEntry("entry", nil)
+	}
+
+	page, next, more := ps.GetHistoryLogPage(1, 5, 3)
+	if len(page) != 3 || !more || next != 4 {
+		t.Fatalf("Expected 3 entries resuming at 4, got %d entries, more=%v, next=%d", len(page), more, next)
+	}
+	page, _, more = ps.GetHistoryLogPage(next, 5, 3)
+	if len(page) != 2 || more || page[0].Sequence != 4 {
+		t.Errorf("Expected final page [4 5], got %+v, more=%v", page, more)
+	}
+}
+
+// TestClockDriftDivergesAtConfiguredRate verifies the pump's time and time
+// since reset gain the configured drift for every hour of real time
+func TestClockDriftDivergesAtConfiguredRate(t *testing.T) {
+	for _, drift := range []float64{36, -18} {
+		ps := NewPumpState()
+		start := time.Unix(1700000000, 0)
+		ps.StartTime = start
+		ps.SetClockDrift(drift)
+
+		for hours := 1; hours <= 24; hours++ {
+			now := start.Add(time.Duration(hours) * time.Hour)
+			ps.updateTimeSinceReset(now)
+
+			wantDrift := time.Duration(float64(hours) * drift * float64(time.Second))
+			if got := ps.CurrentTime.Sub(now); got != wantDrift {
+				t.Fatalf("drift %v after %dh: expected pump clock off by %s, got %s", drift, hours, wantDrift, got)
+			}
+			if want := uint32((time.Duration(hours)*time.Hour + wantDrift).Seconds()); ps.GetTimeSinceReset() != want {
+				t.Fatalf("drift %v after %dh: expected time since reset %d, got %d", drift, hours, want, ps.GetTimeSinceReset())
+			}
+		}
+	}
+}
+
+// TestLowPowerReadableWhileLocked verifies the low-power flag can be read
+// with the mutex held, as notifiers do from inside simulator updates
+func TestLowPowerReadableWhileLocked(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetLowPower(true)
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if !ps.IsLowPower() {
+		t.Error("Expected low power to be set")
+	}
+	ps.SetLowPower(false)
+	if ps.IsLowPower() {
+		t.Error("Expected low power to be cleared")
+	}
+}
@@ -0,0 +1,92 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily do-not-disturb window during which the pump stores
+// non-critical alerts without announcing them. Start and End are offsets
+// from midnight; a window whose End is before its Start spans midnight.
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// parseTimeOfDay parses a "15:04" time of day into an offset from midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q (must be HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseQuietHours parses a -quiet-hours value: "" (no quiet hours) or a
+// start-end time of day range, e.g. "22:00-07:00"
+func ParseQuietHours(value string) (*QuietHours, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid quiet hours: %q (must be start-end, e.g. 22:00-07:00)", value)
+	}
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid quiet hours: %q (start and end must differ)", value)
+	}
+	return &QuietHours{Start: start, End: end}, nil
+}
+
+func (q QuietHours) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(q.Start) + "-" + format(q.End)
+}
+
+// Contains reports whether t's time of day falls within the window
+func (q QuietHours) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
+
+// SetQuietHours sets the daily quiet hours window; nil disables it
+func (ps *PumpState) SetQuietHours(quiet *QuietHours) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.QuietHours = quiet
+}
+
+// GetQuietHours returns the quiet hours window, or nil if none is set
+func (ps *PumpState) GetQuietHours() *QuietHours {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.QuietHours
+}
+
+// SuppressesAlert reports whether alert's notification should be held back
+// because it is non-critical and raised during quiet hours by the pump clock
+func (ps *PumpState) SuppressesAlert(alert Alert, now time.Time) bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	if ps.QuietHours == nil || alert.Priority == PriorityCritical {
+		return false
+	}
+	return ps.QuietHours.Contains(ps.pumpTime(now))
+}
@@ -0,0 +1,34 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// TestQuietHoursSpanningMidnight verifies a window ending before it starts
+// covers the late evening and early morning but not the day
+func TestQuietHoursSpanningMidnight(t *testing.T) {
+	quiet, err := ParseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatalf("ParseQuietHours failed: %v", err)
+	}
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for hour, expected := range map[int]bool{21: false, 22: true, 2: true, 6: true, 7: false, 12: false} {
+		if got := quiet.Contains(day.Add(time.Duration(hour) * time.Hour)); got != expected {
+			t.Errorf("Contains(%02d:00) = %v, expected %v", hour, got, expected)
+		}
+	}
+}
+
+// TestParseQuietHoursRejectsInvalid verifies malformed windows are refused
+func TestParseQuietHoursRejectsInvalid(t *testing.T) {
+	for _, value := range []string{"22:00", "25:00-07:00", "22:00-22:00", "ten-eleven"} {
+		if _, err := ParseQuietHours(value); err == nil {
+			t.Errorf("Expected ParseQuietHours(%q) to fail", value)
+		}
+	}
+	if quiet, err := ParseQuietHours(""); err != nil || quiet != nil {
+		t.Errorf("Expected no quiet hours for an empty value, got %v, %v", quiet, err)
+	}
+}
@@ -0,0 +1,38 @@
+package state
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// lockedSource is a rand.Source safe for use from multiple goroutines, so
+// one seeded generator can be shared by every randomized subsystem
+type lockedSource struct {
+	src   rand.Source64
+	mutex sync.Mutex
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.src.Seed(seed)
+}
+
+// NewRand returns a goroutine-safe generator seeded with seed. Randomized
+// behavior should draw from one shared generator rather than the global
+// math/rand so a whole session is reproducible from its seed.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
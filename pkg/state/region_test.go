@@ -0,0 +1,56 @@
+package state
+
+import "testing"
+
+// TestSetRegionAppliesEUDefaults verifies selecting the EU region replaces
+// the US defaults with the EU max bolus, glucose unit and features
+func TestSetRegionAppliesEUDefaults(t *testing.T) {
+	ps := NewPumpState()
+	if ps.GetRegion() != RegionUS || ps.GetTherapyConfig().MaxBolus != 25.0 || !ps.HasFeature(FeatureBasalIQ) {
+		t.Fatalf("Expected a new pump to have US defaults, got region %s, therapy %+v", ps.GetRegion(), ps.GetTherapyConfig())
+	}
+
+	if err := ps.SetRegion(RegionEU); err != nil {
+		t.Fatalf("SetRegion failed: %v", err)
+	}
+	if ps.GetRegion() != RegionEU {
+		t.Errorf("Expected region EU, got %s", ps.GetRegion())
+	}
+	if therapy := ps.GetTherapyConfig(); therapy.MaxBolus != 20.0 || therapy.MaxHourlyInsulin != 20.0 {
+		t.Errorf("Expected EU max bolus and hourly limit of 20 units, got %+v", therapy)
+	}
+	if ps.GetGlucoseUnit() != GlucoseUnitMmol {
+		t.Errorf("Expected EU pumps to display mmol/L, got %s", GlucoseUnitName(ps.GetGlucoseUnit()))
+	}
+	if ps.HasFeature(FeatureBasalIQ) || !ps.HasFeature(FeatureControlIQ|FeatureDexcomG6) {
+		t.Errorf("Expected EU features Control-IQ and G6 without Basal-IQ, got %#x", ps.GetPumpConfig().Features)
+	}
+}
+
+// TestParseRegion verifies region names are case-insensitive and unknown
+// regions are rejected
+func TestParseRegion(t *testing.T) {
+	if region, err := ParseRegion(" eu "); err != nil || region != RegionEU {
+		t.Errorf("Expected EU, got %q (%v)", region, err)
+	}
+	if _, err := ParseRegion("JP"); err == nil {
+		t.Error("Expected an unsupported region to be rejected")
+	}
+	if err := NewPumpState().SetRegion("JP"); err == nil {
+		t.Error("Expected SetRegion to reject an unsupported region")
+	}
+}
+
+// TestEmptyRegionIsUS verifies a pump config without a region validates and
+// is stored as US
+func TestEmptyRegionIsUS(t *testing.T) {
+	ps := NewPumpState()
+	cfg := ps.GetPumpConfig()
+	cfg.Region = ""
+	if err := ps.SetPumpConfig(cfg); err != nil {
+		t.Fatalf("Expected an empty region to be accepted, got %v", err)
+	}
+	if ps.GetRegion() != RegionUS {
+		t.Errorf("Expected region US, got %q", ps.GetRegion())
+	}
+}
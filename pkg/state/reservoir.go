@@ -0,0 +1,88 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// BolusDeliveryRate is how fast a bolus is delivered, in units/second
+// (3 units/minute)
+const BolusDeliveryRate = 0.05
+
+// deliveryRate returns the insulin currently being delivered in units/hr:
+// the effective basal rate plus any active bolus (must hold mutex)
+func (ps *PumpState) deliveryRate() float64 {
+	if ps.PumpingSuspended {
+		return 0
+	}
+	rate := ps.effectiveBasalRate()
+	if ps.Bolus.Active {
+		rate += BolusDeliveryRate * 3600
+	}
+	return rate
+}
+
+// MinutesUntilEmpty estimates how long the reservoir lasts at the current
+// delivery rate. ok is false when nothing is being delivered, since there's
+// no meaningful estimate.
+func (ps *PumpState) MinutesUntilEmpty() (minutes int, ok bool) {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	rate := ps.deliveryRate()
+	if rate <= 0 {
+		return 0, false
+	}
+	return int(ps.Reservoir.CurrentUnits / rate * 60), true
+}
+
+// Prime deducts the insulin used to fill the cannula or tubing from the
+// reservoir, records the prime at now, and logs it to history. It fails
+// without changing anything if the reservoir can't cover units.
+func (ps *PumpState) Prime(units float64, now time.Time) error {
+	if units <= 0 {
+		return fmt.Errorf("invalid prime amount: %.2f units (must be positive)", units)
+	}
+
+	ps.mutex.Lock()
+	if ps.Reservoir.CurrentUnits < units {
+		remaining := ps.Reservoir.CurrentUnits
+		ps.mutex.Unlock()
+		return fmt.Errorf("reservoir has %.2f units, not enough to prime %.2f units", remaining, units)
+	}
+	ps.deductFromReservoir(units)
+	ps.Cartridge.LastPrime = now
+	remaining := ps.Reservoir.CurrentUnits
+	ps.mutex.Unlock()
+
+	ps.AddHistoryLogEntryWithTypeID(HistoryCannulaFilled, "CannulaFilled", map[string]interface{}{
+		"primeSize":      units,
+		"reservoirUnits": remaining,
+	})
+	return nil
+}
+
+// RemoveCartridge records the cartridge being removed at the start of a
+// cartridge change
+func (ps *PumpState) RemoveCartridge() {
+	ps.mutex.RLock()
+	remaining := ps.Reservoir.CurrentUnits
+	ps.mutex.RUnlock()
+
+	ps.AddHistoryLogEntryWithTypeID(HistoryCartridgeRemoved, "CartridgeRemoved", map[string]interface{}{
+		"reservoirUnits": remaining,
+	})
+}
+
+// InsertCartridge records a new cartridge inserted at the end of a cartridge
+// change, restarting the count of days since the change
+func (ps *PumpState) InsertCartridge() {
+	ps.mutex.Lock()
+	ps.Cartridge.DaysSinceChange = 0
+	remaining := ps.Reservoir.CurrentUnits
+	ps.mutex.Unlock()
+
+	ps.AddHistoryLogEntryWithTypeID(HistoryCartridgeInserted, "CartridgeInserted", map[string]interface{}{
+		"reservoirUnits": remaining,
+	})
+}
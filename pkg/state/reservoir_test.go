@@ -0,0 +1,82 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMinutesUntilEmptyAtKnownRate verifies the estimate from basal alone and
+// with an active bolus
+func TestMinutesUntilEmptyAtKnownRate(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetReservoirLevel(100)
+	ps.SetBasalRate(2.0)
+
+	if minutes, ok := ps.MinutesUntilEmpty(); !ok || minutes != 3000 {
+		t.Errorf("Expected 3000 minutes at 2 U/hr, got %d (ok=%v)", minutes, ok)
+	}
+
+	ps.StartBolus(5, 1)
+	// 2 U/hr basal + 180 U/hr bolus
+	if minutes, ok := ps.MinutesUntilEmpty(); !ok || minutes != 32 {
+		t.Errorf("Expected 32 minutes during a bolus, got %d (ok=%v)", minutes, ok)
+	}
+}
+
+// TestMinutesUntilEmptyZeroRate verifies no estimate is reported when nothing
+// is being delivered
+func TestMinutesUntilEmptyZeroRate(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetBasalRate(0)
+	if _, ok := ps.MinutesUntilEmpty(); ok {
+		t.Error("Expected no estimate at a zero basal rate")
+	}
+
+	ps.SetBasalRate(1.0)
+	ps.SetPumpingSuspended(true)
+	if _, ok := ps.MinutesUntilEmpty(); ok {
+		t.Error("Expected no estimate while suspended")
+	}
+}
+
+// TestPrimeDeductsFromReservoir verifies priming uses reservoir insulin,
+// records the prime time, and logs a history entry
+func TestPrimeDeductsFromReservoir(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetReservoirLevel(100)
+	primedAt := time.Unix(1700000000, 0)
+
+	if err := ps.Prime(0.3, primedAt); err != nil {
+		t.Fatalf("Prime failed: %v", err)
+	}
+
+	if units := ps.Reservoir.CurrentUnits; units < 99.6999 || units > 99.7001 {
+		t.Errorf("Expected 99.7 units left after priming, got %.4f", units)
+	}
+	if !ps.Cartridge.LastPrime.Equal(primedAt) {
+		t.Errorf("Expected LastPrime %s, got %s", primedAt, ps.Cartridge.LastPrime)
+	}
+	entries := ps.GetHistoryLogEntries(0, ^uint32(0))
+	if len(entries) != 1 || entries[0].TypeID != HistoryCannulaFilled || entries[0].Data["primeSize"] != 0.3 {
+		t.Errorf("Expected one CannulaFilled history entry, got %+v", entries)
+	}
+}
+
+// TestPrimeFailsWithoutEnoughInsulin verifies a prime the reservoir can't
+// cover changes nothing
+func TestPrimeFailsWithoutEnoughInsulin(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetReservoirLevel(0.2)
+	lastPrime := ps.Cartridge.LastPrime
+
+	if err := ps.Prime(0.3, time.Now()); err == nil {
+		t.Fatal("Expected prime to fail with 0.2 units in the reservoir")
+	}
+	if ps.Reservoir.CurrentUnits != 0.2 || !ps.Cartridge.LastPrime.Equal(lastPrime) {
+		t.Errorf("Expected a failed prime to leave state unchanged, got %.2f units primed at %s",
+			ps.Reservoir.CurrentUnits, ps.Cartridge.LastPrime)
+	}
+	if entries := ps.GetHistoryLogEntries(0, ^uint32(0)); len(entries) != 0 {
+		t.Errorf("Expected no history entry, got %+v", entries)
+	}
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AppSession identifies the app an auth session belongs to: the BLE central
+// it connected from plus the appInstanceId it sent while authenticating.
+// Real pumps track the paired app this way, so a reinstalled or second app
+// on the same phone doesn't inherit another instance's session.
+type AppSession struct {
+	CentralID     string `json:"centralId"`
+	AppInstanceID uint32 `json:"appInstanceId"`
+}
+
+// Key returns the session key used to scope JPAKE sessions
+func (s AppSession) Key() string {
+	return fmt.Sprintf("%s/%d", s.CentralID, s.AppInstanceID)
+}
+
+// SetCentralID records the ID of the central that just connected. The next
+// BindAppInstance pairs it with the app's instance ID.
+func (ps *PumpState) SetCentralID(centralID string) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.centralID = centralID
+}
+
+// GetAppSession returns the current app session, or the connected central
+// with instance ID 0 if no app has identified itself yet
+func (ps *PumpState) GetAppSession() AppSession {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	if ps.AppSession != nil {
+		return *ps.AppSession
+	}
+	return AppSession{CentralID: ps.centralID}
+}
+
+// BindAppInstance scopes the auth session to appInstanceID on the connected
+// central. If a different app session was bound, authentication is reset so
+// the new app starts a fresh session, and the previous session is returned
+// with changed=true so callers can drop its in-progress state. Binding the
+// same session again resumes it.
+func (ps *PumpState) BindAppInstance(appInstanceID uint32) (previous AppSession, changed bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	session := AppSession{CentralID: ps.centralID, AppInstanceID: appInstanceID}
+	if ps.AppSession == nil {
+		ps.AppSession = &session
+		return session, false
+	}
+
+	previous = *ps.AppSession
+	if previous == session {
+		return previous, false
+	}
+
+	log.Infof("App session changed from %s to %s; starting a fresh auth session", previous.Key(), session.Key())
+	ps.AppSession = &session
+	ps.IsAuthenticated = false
+	ps.AuthKey = nil
+	return previous, true
+}
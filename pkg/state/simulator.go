@@ -1,6 +1,7 @@
 package state
 
 import (
+	"math/rand"
 	"sync"
 	"time"
 
@@ -12,10 +13,21 @@ type Simulator struct {
 	pumpState      *PumpState
 	eventNotifier  EventNotifier
 	running        bool
-	stopChan       chan bool
+	stopChan       chan struct{} // closed to stop the running simulation loop
+	loopDone       chan struct{} // closed when the simulation loop has returned
 	ticker         *time.Ticker
 	updateInterval time.Duration
+	rng            *rand.Rand
+	cgmNoise       int        // most the CGM reading moves per update (mg/dL), 0 holds it steady
+	cgmReplay      *CGMReplay // recorded readings replayed instead of noise, if set
+	cgmModel       *CGMModel  // glucose model evolved instead of noise, if set
+	cgmModelStart  time.Time  // start of the model's first cycle
+	alertAutoAck   AlertAutoAck
+	eventScheduler *EventScheduler // synthetic events fired on a schedule, if set
+	timeSeries     *TimeSeries     // sampled each update, if set
+	tickHooks      []func(*PumpState)
 	mutex          sync.Mutex
+	now            func() time.Time
 }
 
 // NewSimulator creates a new background simulator
@@ -24,18 +36,80 @@ func NewSimulator(pumpState *PumpState, updateInterval time.Duration) *Simulator
 		pumpState:      pumpState,
 		eventNotifier:  &NoOpEventNotifier{}, // Default to no-op
 		running:        false,
-		stopChan:       make(chan bool),
 		updateInterval: updateInterval,
+		rng:            NewRand(1),
 	}
 }
 
-// SetEventNotifier sets the event notifier for qualifying events
+func (s *Simulator) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// SetClock makes the simulator read the time from now instead of the wall
+// clock, e.g. so tests can advance bolus and basal delivery. Call it before
+// Start.
+func (s *Simulator) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// SetRand sets the generator the simulator draws all randomness from
+func (s *Simulator) SetRand(rng *rand.Rand) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rng = rng
+}
+
+// SetCGMNoise makes the CGM reading random-walk by up to maxStep mg/dL each
+// update; 0 holds it steady
+func (s *Simulator) SetCGMNoise(maxStep int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cgmNoise = maxStep
+}
+
+// SetEventNotifier sets the event notifier for qualifying events. History
+// log updates are notified separately, see PumpState.SetHistoryLogNotifier.
 func (s *Simulator) SetEventNotifier(notifier EventNotifier) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.eventNotifier = notifier
 }
 
+// SetCGMReplay replays recorded readings as the CGM reading, in place of
+// the noise generator
+func (s *Simulator) SetCGMReplay(replay *CGMReplay) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cgmReplay = replay
+}
+
+// AddTickHook registers fn to run every update, after the built-in delivery
+// and battery updates and before alerts are checked, so state it changes
+// (e.g. a custom reservoir drain) raises alerts and is sampled in the same
+// update. fn runs with the pump state locked, so it must read and write the
+// state's fields directly rather than call its locking methods.
+func (s *Simulator) AddTickHook(fn func(*PumpState)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tickHooks = append(s.tickHooks, fn)
+}
+
+// runTickHooks runs the registered tick hooks in registration order
+func (s *Simulator) runTickHooks() {
+	s.mutex.Lock()
+	hooks := s.tickHooks
+	s.mutex.Unlock()
+
+	s.pumpState.mutex.Lock()
+	defer s.pumpState.mutex.Unlock()
+	for _, hook := range hooks {
+		hook(s.pumpState)
+	}
+}
+
 // Start begins the background simulation
 func (s *Simulator) Start() {
 	s.mutex.Lock()
@@ -45,40 +119,54 @@ func (s *Simulator) Start() {
 	}
 	s.running = true
 	s.ticker = time.NewTicker(s.updateInterval)
+	s.stopChan = make(chan struct{})
+	s.loopDone = make(chan struct{})
+	ticker, stop, done := s.ticker, s.stopChan, s.loopDone
 	s.mutex.Unlock()
 
 	log.Infof("Starting background simulator with update interval: %v", s.updateInterval)
 
-	go s.simulationLoop()
+	go s.simulationLoop(ticker, stop, done)
 }
 
-// Stop halts the background simulation
+// Stop halts the background simulation, returning once the simulation loop
+// has exited
 func (s *Simulator) Stop() {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	if !s.running {
+		s.mutex.Unlock()
 		return
 	}
 
 	log.Info("Stopping background simulator")
 	s.running = false
 	s.ticker.Stop()
-	s.stopChan <- true
+	close(s.stopChan)
+	done := s.loopDone
+	s.mutex.Unlock()
+
+	// Wait without holding the mutex, which an in-progress update may need
+	<-done
 }
 
-// simulationLoop runs the background simulation
-func (s *Simulator) simulationLoop() {
+// simulationLoop runs the background simulation until stop is closed
+func (s *Simulator) simulationLoop(ticker *time.Ticker, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
 	for {
 		select {
-		case <-s.ticker.C:
+		case <-ticker.C:
 			s.update()
-		case <-s.stopChan:
+		case <-stop:
 			return
 		}
 	}
 }
 
+// Tick runs a single simulation update synchronously, e.g. from tests
+func (s *Simulator) Tick() {
+	s.update()
+}
+
 // update performs a single simulation update
 func (s *Simulator) update() {
 	// Update time
@@ -87,136 +175,199 @@ func (s *Simulator) update() {
 	// Update bolus delivery
 	s.updateBolusDelivery()
 
+	// Update the CGM reading
+	s.updateCGM()
+
+	// Make Control-IQ treatment decisions from the current CGM reading
+	s.updateControlIQ()
+
 	// Update basal delivery
 	s.updateBasalDelivery()
 
 	// Update battery
 	s.updateBattery()
 
+	// Run custom per-update logic
+	s.runTickHooks()
+
 	// Check for alerts
 	s.checkAlerts()
+
+	// Fire scheduled synthetic events, unless the pump is saving power
+	if !s.pumpState.IsLowPower() {
+		s.fireScheduledEvents()
+	}
+
+	// Sample the state fields that change every update
+	s.sampleTimeSeries()
 }
 
-// updateBolusDelivery simulates bolus insulin delivery
+// updateBolusDelivery simulates bolus insulin delivery. The completion is
+// recorded and notified after releasing the pumpState mutex, since history
+// and qualifying event notifiers read pump state.
 func (s *Simulator) updateBolusDelivery() {
 	s.pumpState.mutex.Lock()
-	defer s.pumpState.mutex.Unlock()
+	completed, ok := s.advanceBolus(s.clock())
+	s.pumpState.unlock()
 
-	if !s.pumpState.Bolus.Active {
+	if !ok {
 		return
 	}
 
-	// Calculate delivery rate (units per second)
-	// Assume bolus delivers at 0.05 units/second (3 units/minute)
-	deliveryRate := 0.05 // units/second
-	elapsed := time.Since(s.pumpState.Bolus.StartTime).Seconds()
-	expectedDelivered := deliveryRate * elapsed
+	// Record history log entry
+	s.addHistoryEntryWithTypeID(HistoryBolusCompleted, "BolusCompleted", map[string]interface{}{
+		"bolusId":        completed.BolusID,
+		"unitsDelivered": completed.UnitsDelivered,
+		"unitsTotal":     completed.UnitsTotal,
+	})
+
+	// Notify qualifying event
+	if s.eventNotifier != nil {
+		if err := s.eventNotifier.NotifyBolusComplete(completed.BolusID, completed.UnitsDelivered, completed.UnitsTotal); err != nil {
+			log.Warnf("Failed to notify bolus complete: %v", err)
+		}
+	}
+}
+
+// advanceBolus delivers the active bolus up to now, returning it and true if
+// it completed (must hold mutex)
+func (s *Simulator) advanceBolus(now time.Time) (BolusState, bool) {
+	if !s.pumpState.Bolus.Active {
+		return BolusState{}, false
+	}
+
+	// Hold the bolus while pumping is suspended: delivery is paced from
+	// StartTime, so move it up to resume where it left off
+	if s.pumpState.PumpingSuspended {
+		held := time.Duration(s.pumpState.Bolus.UnitsDelivered / BolusDeliveryRate * float64(time.Second))
+		s.pumpState.Bolus.StartTime = now.Add(-held)
+		return BolusState{}, false
+	}
+
+	elapsed := now.Sub(s.pumpState.Bolus.StartTime).Seconds()
+	expectedDelivered := BolusDeliveryRate * elapsed
 
 	if expectedDelivered > s.pumpState.Bolus.UnitsTotal {
 		expectedDelivered = s.pumpState.Bolus.UnitsTotal
 	}
 
-	// Update delivered amount
+	// Stop the bolus short rather than exceed the hourly limit
 	oldDelivered := s.pumpState.Bolus.UnitsDelivered
+	limitReached := false
+	if remaining, limited := s.pumpState.hourlyRemaining(now); limited && expectedDelivered-oldDelivered > remaining {
+		expectedDelivered = oldDelivered + remaining
+		limitReached = true
+	}
+
+	// Update delivered amount
 	s.pumpState.Bolus.UnitsDelivered = expectedDelivered
 
 	// Deduct from reservoir
 	deltaDelivered := s.pumpState.Bolus.UnitsDelivered - oldDelivered
 	if deltaDelivered > 0 {
-		s.pumpState.Reservoir.CurrentUnits -= deltaDelivered
-		if s.pumpState.Reservoir.CurrentUnits < 0 {
-			s.pumpState.Reservoir.CurrentUnits = 0
-		}
+		s.pumpState.recordDelivery(now, deltaDelivered)
+		s.pumpState.deductFromReservoir(deltaDelivered)
 	}
 
-	// Check if bolus is complete
-	if s.pumpState.Bolus.UnitsDelivered >= s.pumpState.Bolus.UnitsTotal {
-		bolusID := s.pumpState.Bolus.BolusID
-		unitsDelivered := s.pumpState.Bolus.UnitsDelivered
-		unitsTotal := s.pumpState.Bolus.UnitsTotal
-
-		s.pumpState.Bolus.Active = false
-		log.Infof("Bolus delivery complete: %.2f units delivered", s.pumpState.Bolus.UnitsDelivered)
-
-		// Update IOB (simple calculation - in reality this would decay over time)
-		s.pumpState.IOB += s.pumpState.Bolus.UnitsTotal
-		s.pumpState.TDD += s.pumpState.Bolus.UnitsTotal
-
-		// Record history log entry
-		s.addHistoryEntryWithTypeID(HistoryBolusCompleted, "BolusCompleted", map[string]interface{}{
-			"bolusId":        bolusID,
-			"unitsDelivered": unitsDelivered,
-			"unitsTotal":     unitsTotal,
-		})
-
-		// Notify qualifying event
-		if s.eventNotifier != nil {
-			if err := s.eventNotifier.NotifyBolusComplete(bolusID, unitsDelivered, unitsTotal); err != nil {
-				log.Warnf("Failed to notify bolus complete: %v", err)
+	if limitReached {
+		log.Warnf("Bolus stopped at hourly insulin limit: %.2f of %.2f units delivered",
+			s.pumpState.Bolus.UnitsDelivered, s.pumpState.Bolus.UnitsTotal)
+		if len(s.pumpState.BolusQueue) > 0 {
+			log.Warnf("Dropping %d stacked boluses at hourly insulin limit", len(s.pumpState.BolusQueue))
+			for _, dropped := range s.pumpState.BolusQueue {
+				s.dropStackedBolus(*dropped)
 			}
+			s.pumpState.BolusQueue = nil
 		}
+		s.raiseHourlyLimitAlert(now)
+	}
+
+	// Check if bolus is complete
+	if !limitReached && s.pumpState.Bolus.UnitsDelivered < s.pumpState.Bolus.UnitsTotal {
+		return BolusState{}, false
 	}
+
+	completed := *s.pumpState.Bolus
+	log.Infof("Bolus delivery complete: %.2f units delivered", completed.UnitsDelivered)
+	s.pumpState.finishBolus(now)
+	s.pumpState.TDD += completed.UnitsDelivered
+	return completed, true
+}
+
+// dropStackedBolus records a stacked bolus dropped undelivered at the hourly
+// limit and notifies it as canceled once the pumpState mutex is released
+// (must hold pumpState mutex)
+func (s *Simulator) dropStackedBolus(bolus BolusState) {
+	s.pumpState.addHistoryLogEntry(HistoryBolusCompleted, "BolusCompleted", map[string]interface{}{
+		"bolusId":        bolus.BolusID,
+		"unitsDelivered": 0.0,
+		"unitsTotal":     bolus.UnitsTotal,
+		"reason":         "hourlyInsulinLimit",
+	})
+
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
+	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyBolusCanceled(bolus.BolusID, 0, bolus.UnitsTotal); err != nil {
+			log.Warnf("Failed to notify dropped bolus: %v", err)
+		}
+	})
 }
 
 // updateBasalDelivery simulates basal insulin delivery
 func (s *Simulator) updateBasalDelivery() {
 	s.pumpState.mutex.Lock()
-	defer s.pumpState.mutex.Unlock()
+	defer s.pumpState.unlock()
+
+	now := s.clock()
 
 	// Calculate basal delivery since last update
-	basalRate := s.pumpState.Basal.CurrentRate
+	basalRate := s.pumpState.effectiveBasalRate()
 	if s.pumpState.Basal.TempBasalActive {
-		basalRate = s.pumpState.Basal.TempBasalRate
-
 		// Check if temp basal has expired
-		if time.Now().After(s.pumpState.Basal.TempBasalEnd) {
+		if now.After(s.pumpState.Basal.TempBasalEnd) {
 			log.Info("Temp basal expired, returning to normal basal rate")
 			oldRate := s.pumpState.Basal.TempBasalRate
 			s.pumpState.Basal.TempBasalActive = false
-			basalRate = s.pumpState.Basal.CurrentRate
+			basalRate = s.pumpState.effectiveBasalRate()
 
-			s.pumpState.AddHistoryLogEntryWithTypeID(HistoryTempRateCompleted, "TempRateCompleted", map[string]interface{}{
+			s.pumpState.addHistoryLogEntry(HistoryTempRateCompleted, "TempRateCompleted", map[string]interface{}{
 				"tempRate":   oldRate,
 				"normalRate": basalRate,
 			})
-
-			if s.eventNotifier != nil {
-				if err := s.eventNotifier.NotifyBasalRateChange(oldRate, basalRate, false); err != nil {
-					log.Warnf("Failed to notify temp rate expired: %v", err)
-				}
-			}
+			s.notifyBasalRateChange(oldRate, basalRate)
 		}
 	}
 
+	// Nothing is delivered while pumping is suspended
+	if s.pumpState.PumpingSuspended {
+		basalRate = 0
+	}
+
 	// Basal rate is in units/hour, convert to units/second
 	basalPerSecond := basalRate / 3600.0
 
-	// Deliver basal for the update interval
+	// Deliver basal for the update interval, holding it at the hourly limit
 	basalDelivered := basalPerSecond * s.updateInterval.Seconds()
+	if remaining, limited := s.pumpState.hourlyRemaining(now); limited && basalDelivered > remaining {
+		basalDelivered = remaining
+		s.raiseHourlyLimitAlert(now)
+	}
+	s.pumpState.recordDelivery(now, basalDelivered)
 
 	// Deduct from reservoir
-	s.pumpState.Reservoir.CurrentUnits -= basalDelivered
-	if s.pumpState.Reservoir.CurrentUnits < 0 {
-		s.pumpState.Reservoir.CurrentUnits = 0
-	}
+	s.pumpState.deductFromReservoir(basalDelivered)
 
-	// Update IOB and TDD
-	s.pumpState.IOB += basalDelivered
+	// Update TDD
 	s.pumpState.TDD += basalDelivered
-
-	// Decay IOB slightly (very simplified - real IOB calculation is complex)
-	// Assume insulin action time of ~4 hours
-	iobDecayPerSecond := s.pumpState.IOB / (4.0 * 3600.0)
-	s.pumpState.IOB -= iobDecayPerSecond * s.updateInterval.Seconds()
-	if s.pumpState.IOB < 0 {
-		s.pumpState.IOB = 0
-	}
 }
 
 // updateBattery simulates battery drain
 func (s *Simulator) updateBattery() {
 	s.pumpState.mutex.Lock()
-	defer s.pumpState.mutex.Unlock()
+	defer s.pumpState.unlock()
 
 	// Simple battery drain simulation
 	// Assume battery lasts ~7 days (168 hours)
@@ -224,10 +375,14 @@ func (s *Simulator) updateBattery() {
 	drainPerSecond := 100.0 / (7.0 * 24.0 * 3600.0)
 	drainAmount := drainPerSecond * s.updateInterval.Seconds()
 
+	oldPercentage := s.pumpState.Battery.Percentage
 	s.pumpState.Battery.Percentage -= int(drainAmount * 100) // Scale for percentage
 	if s.pumpState.Battery.Percentage < 0 {
 		s.pumpState.Battery.Percentage = 0
 	}
+	if s.pumpState.Battery.Percentage != oldPercentage {
+		s.notifyBatteryChange(s.pumpState.Battery.Percentage)
+	}
 
 	// Log battery level changes at significant thresholds
 	if s.pumpState.Battery.Percentage == 50 || s.pumpState.Battery.Percentage == 20 || s.pumpState.Battery.Percentage == 10 {
@@ -237,9 +392,14 @@ func (s *Simulator) updateBattery() {
 
 // checkAlerts checks for alert conditions
 func (s *Simulator) checkAlerts() {
+	s.mutex.Lock()
+	autoAck := s.alertAutoAck
+	s.mutex.Unlock()
+
 	s.pumpState.mutex.Lock()
-	defer s.pumpState.mutex.Unlock()
+	defer s.pumpState.unlock()
 
+	s.autoAckAlerts(autoAck)
 	s.checkReservoirAlert()
 	s.checkBatteryAlerts()
 }
@@ -271,31 +431,85 @@ func (s *Simulator) checkBatteryAlerts() {
 	}
 }
 
-// notifyAlert sends an alert notification
+// raiseHourlyLimitAlert raises the hourly insulin limit alert unless it's
+// already active, reporting the hour up to now (must hold mutex)
+func (s *Simulator) raiseHourlyLimitAlert(now time.Time) {
+	if s.hasAlert(AlertHourlyInsulinLimit) {
+		return
+	}
+	log.Warnf("Hourly insulin limit alert: %.2f units delivered in the last hour",
+		s.pumpState.deliveredLastHour(now))
+	s.notifyAlert(s.addAlert(AlertHourlyInsulinLimit, PriorityWarning, "Hourly insulin limit reached"))
+}
+
+// notifyAlert sends an alert notification once the pumpState mutex is
+// released (must hold pumpState mutex)
 func (s *Simulator) notifyAlert(alert Alert) {
-	if s.eventNotifier != nil {
-		if err := s.eventNotifier.NotifyAlert(alert); err != nil {
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
+	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyAlert(alert); err != nil {
 			log.Warnf("Failed to notify alert: %v", err)
 		}
-	}
+	})
 }
 
-// notifyReservoirLow sends a reservoir low notification
+// notifyReservoirLow sends a reservoir low notification once the pumpState
+// mutex is released (must hold pumpState mutex)
 func (s *Simulator) notifyReservoirLow(units float64) {
-	if s.eventNotifier != nil {
-		if err := s.eventNotifier.NotifyReservoirLow(units); err != nil {
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
+	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyReservoirLow(units); err != nil {
 			log.Warnf("Failed to notify reservoir low: %v", err)
 		}
-	}
+	})
 }
 
-// notifyBatteryLow sends a battery low notification
+// notifyBatteryLow sends a battery low notification once the pumpState
+// mutex is released (must hold pumpState mutex)
 func (s *Simulator) notifyBatteryLow(percentage int) {
-	if s.eventNotifier != nil {
-		if err := s.eventNotifier.NotifyBatteryLow(percentage); err != nil {
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
+	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyBatteryLow(percentage); err != nil {
 			log.Warnf("Failed to notify battery low: %v", err)
 		}
+	})
+}
+
+// notifyBasalRateChange sends a basal rate change notification once the
+// pumpState mutex is released (must hold pumpState mutex)
+func (s *Simulator) notifyBasalRateChange(oldRate, newRate float64) {
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
 	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyBasalRateChange(oldRate, newRate, false); err != nil {
+			log.Warnf("Failed to notify temp rate expired: %v", err)
+		}
+	})
+}
+
+// notifyBatteryChange sends a battery level notification once the pumpState
+// mutex is released (must hold pumpState mutex)
+func (s *Simulator) notifyBatteryChange(percentage int) {
+	notifier := s.eventNotifier
+	if notifier == nil {
+		return
+	}
+	s.pumpState.afterUnlock(func() {
+		if err := notifier.NotifyBatteryChange(percentage); err != nil {
+			log.Warnf("Failed to notify battery change: %v", err)
+		}
+	})
 }
 
 // hasAlert checks if an alert type is already active (must hold mutex)
@@ -311,15 +525,13 @@ func (s *Simulator) hasAlert(alertType AlertType) bool {
 // addAlert adds a new alert (must hold mutex) and returns the alert
 func (s *Simulator) addAlert(alertType AlertType, priority AlertPriority, message string) Alert {
 	alert := Alert{
-		ID:           uint32(len(s.pumpState.ActiveAlerts) + 1),
 		Type:         alertType,
 		Priority:     priority,
 		Message:      message,
-		Timestamp:    time.Now(),
+		Timestamp:    s.clock(),
 		Acknowledged: false,
 	}
-	s.pumpState.ActiveAlerts = append(s.pumpState.ActiveAlerts, alert)
-	return alert
+	return s.pumpState.addAlert(alert)
 }
 
 // addHistoryEntryWithTypeID adds a typed history log entry (must NOT hold pumpState mutex)
@@ -0,0 +1,92 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jwoglom/faketandem/pkg/testutil"
+)
+
+// TestSimulatorStopLeavesNoGoroutine verifies a stopped simulator's loop has
+// exited, including when it is restarted and stopped again mid-update
+func TestSimulatorStopLeavesNoGoroutine(t *testing.T) {
+	testutil.AssertNoLeaks(t)
+	sim := NewSimulator(NewPumpState(), time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		sim.Start()
+		time.Sleep(5 * time.Millisecond)
+		sim.Stop()
+	}
+	sim.Stop()
+
+	if running := sim.GetStats()["running"]; running != false {
+		t.Errorf("Expected the simulator to be stopped, got running=%v", running)
+	}
+}
+
+// TestSimulatorDeliversNothingWhileSuspended verifies a suspended pump
+// delivers no basal or bolus insulin, and that the held bolus resumes where
+// it left off rather than catching up
+func TestSimulatorDeliversNothingWhileSuspended(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	sim := NewSimulator(ps, time.Minute)
+
+	if err := ps.StartBolus(5.0, 1); err != nil {
+		t.Fatalf("StartBolus failed: %v", err)
+	}
+	ps.UpdateBolusDelivery(1.0)
+	ps.SetPumpingSuspended(true)
+	reservoir := ps.GetReservoirLevel()
+
+	// Long enough suspended that a catch-up would finish the bolus
+	ps.mutex.Lock()
+	ps.Bolus.StartTime = time.Now().Add(-time.Hour)
+	ps.mutex.Unlock()
+	sim.Tick()
+
+	if level := ps.GetReservoirLevel(); level != reservoir {
+		t.Errorf("Expected no insulin delivered while suspended, reservoir went %v -> %v", reservoir, level)
+	}
+
+	ps.SetPumpingSuspended(false)
+	sim.Tick()
+	bolus := ps.GetActiveBoluses()
+	if len(bolus) != 1 || bolus[0].UnitsDelivered < 1.0 || bolus[0].UnitsDelivered > 1.1 {
+		t.Errorf("Expected the bolus to resume from 1 unit delivered, got %+v", bolus)
+	}
+}
+
+// TestSimulatorRunsTickHooks verifies registered hooks run once per update
+// in order, and that state a hook changes is seen by the same update's alert
+// checks
+func TestSimulatorRunsTickHooks(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	sim := NewSimulator(ps, time.Second)
+
+	var calls []string
+	sim.AddTickHook(func(ps *PumpState) {
+		calls = append(calls, "drain")
+		ps.Reservoir.CurrentUnits -= 65
+	})
+	sim.AddTickHook(func(ps *PumpState) {
+		calls = append(calls, "observe")
+	})
+
+	start := ps.GetReservoirLevel()
+	for i := 0; i < 3; i++ {
+		sim.Tick()
+	}
+
+	if len(calls) != 6 || calls[0] != "drain" || calls[1] != "observe" {
+		t.Errorf("Expected both hooks to run in order each tick, got %v", calls)
+	}
+	if level := ps.GetReservoirLevel(); level > start-195 {
+		t.Errorf("Expected the hook to drain 195 units from %v, got %v", start, level)
+	}
+	if !sim.hasAlert(AlertLowReservoir) {
+		t.Error("Expected the drained reservoir to raise a low reservoir alert")
+	}
+}
@@ -0,0 +1,97 @@
+package state
+
+import "fmt"
+
+// DeliverySnapshot is the basal, bolus, CGM and history state of a pump,
+// for saving it whole and restoring it later
+type DeliverySnapshot struct {
+	Basal      BasalState   `json:"basal"`
+	Bolus      BolusState   `json:"bolus"`
+	BolusQueue []BolusState `json:"bolusQueue"`
+	LastBolus  *BolusState  `json:"lastBolus,omitempty"`
+	CGM        CGMState     `json:"cgm"`
+
+	History             []HistoryLogEntry `json:"history"`
+	NextHistorySequence uint32            `json:"nextHistorySequence"`
+}
+
+// Validate returns an error if the snapshot couldn't be a pump's state
+func (d DeliverySnapshot) Validate() error {
+	if d.Basal.CurrentRate < 0 || d.Basal.TempBasalRate < 0 {
+		return fmt.Errorf("basal rates must not be negative")
+	}
+	if len(d.BolusQueue) > 0 && !d.Bolus.Active {
+		return fmt.Errorf("boluses can only be stacked behind an active bolus")
+	}
+	if 1+len(d.BolusQueue) > MaxStackedBoluses {
+		return fmt.Errorf("at most %d boluses can be active, got %d", MaxStackedBoluses, 1+len(d.BolusQueue))
+	}
+	var last uint32
+	for i, entry := range d.History {
+		if i > 0 && entry.Sequence <= last {
+			return fmt.Errorf("history sequence %d follows %d", entry.Sequence, last)
+		}
+		last = entry.Sequence
+	}
+	if len(d.History) > 0 && d.NextHistorySequence <= last {
+		return fmt.Errorf("next history sequence %d must follow the last entry's %d", d.NextHistorySequence, last)
+	}
+	return nil
+}
+
+// GetDeliverySnapshot returns a copy of the pump's basal, bolus, CGM and
+// history state
+func (ps *PumpState) GetDeliverySnapshot() DeliverySnapshot {
+	ps.mutex.RLock()
+	snapshot := DeliverySnapshot{
+		Basal: *ps.Basal,
+		Bolus: *ps.Bolus,
+		CGM:   *ps.CGM,
+	}
+	for _, queued := range ps.BolusQueue {
+		snapshot.BolusQueue = append(snapshot.BolusQueue, *queued)
+	}
+	if ps.LastBolus != nil {
+		last := *ps.LastBolus
+		snapshot.LastBolus = &last
+	}
+	ps.mutex.RUnlock()
+
+	ps.HistoryLog.mutex.Lock()
+	defer ps.HistoryLog.mutex.Unlock()
+	snapshot.History = append([]HistoryLogEntry{}, ps.HistoryLog.Entries...)
+	snapshot.NextHistorySequence = ps.HistoryLog.NextSequence
+	return snapshot
+}
+
+// RestoreDeliverySnapshot replaces the pump's basal, bolus, CGM and history
+// state with snapshot's. History notifiers aren't told of the restored
+// entries, since none were appended.
+func (ps *PumpState) RestoreDeliverySnapshot(snapshot DeliverySnapshot) error {
+	if err := snapshot.Validate(); err != nil {
+		return err
+	}
+
+	ps.mutex.Lock()
+	basal, bolus, cgm := snapshot.Basal, snapshot.Bolus, snapshot.CGM
+	ps.Basal = &basal
+	ps.Bolus = &bolus
+	ps.CGM = &cgm
+	ps.BolusQueue = nil
+	for i := range snapshot.BolusQueue {
+		queued := snapshot.BolusQueue[i]
+		ps.BolusQueue = append(ps.BolusQueue, &queued)
+	}
+	ps.LastBolus = nil
+	if snapshot.LastBolus != nil {
+		last := *snapshot.LastBolus
+		ps.LastBolus = &last
+	}
+	ps.mutex.Unlock()
+
+	ps.HistoryLog.mutex.Lock()
+	defer ps.HistoryLog.mutex.Unlock()
+	ps.HistoryLog.Entries = append([]HistoryLogEntry{}, snapshot.History...)
+	ps.HistoryLog.NextSequence = snapshot.NextHistorySequence
+	return nil
+}
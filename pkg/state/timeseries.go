@@ -0,0 +1,133 @@
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxTimeSeriesPoints bounds each field's series; at one simulator update a
+// second that's the last hour of a field changing every update
+const maxTimeSeriesPoints = 3600
+
+// TimeSeriesPoint is a field's value from Time until the next point
+type TimeSeriesPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// TimeSeries keeps the recent history of each numeric pump state field, for
+// plotting how state evolved over a session. A point is only recorded when
+// the field's value changes.
+type TimeSeries struct {
+	series map[string][]TimeSeriesPoint
+	now    func() time.Time
+	mtx    sync.Mutex
+}
+
+// NewTimeSeries creates an empty time series store
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{series: make(map[string][]TimeSeriesPoint)}
+}
+
+func (ts *TimeSeries) clock() time.Time {
+	if ts.now != nil {
+		return ts.now()
+	}
+	return time.Now()
+}
+
+// Record appends each numeric field whose value changed. Its signature
+// matches FieldNotifier, so the store can subscribe to the event bus;
+// booleans are recorded as 0 or 1, an alert as its type, and non-numeric
+// fields are skipped.
+func (ts *TimeSeries) Record(fields map[string]interface{}) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	now := ts.clock()
+	for field, raw := range fields {
+		value, ok := timeSeriesValue(raw)
+		if !ok {
+			continue
+		}
+		points := ts.series[field]
+		if len(points) > 0 && points[len(points)-1].Value == value {
+			continue
+		}
+		points = append(points, TimeSeriesPoint{Time: now, Value: value})
+		if len(points) > maxTimeSeriesPoints {
+			points = points[len(points)-maxTimeSeriesPoints:]
+		}
+		ts.series[field] = points
+	}
+}
+
+// timeSeriesValue converts a state field value to a series value
+func timeSeriesValue(raw interface{}) (float64, bool) {
+	switch v := raw.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case Alert:
+		return float64(v.Type), true
+	}
+	return 0, false
+}
+
+// Series returns field's recorded points, oldest first
+func (ts *TimeSeries) Series(field string) []TimeSeriesPoint {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	points := make([]TimeSeriesPoint, len(ts.series[field]))
+	copy(points, ts.series[field])
+	return points
+}
+
+// Fields returns the fields with recorded points, sorted
+func (ts *TimeSeries) Fields() []string {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	fields := make([]string, 0, len(ts.series))
+	for field := range ts.series {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// SetTimeSeries samples the pump state fields the simulator evolves every
+// update -- which change too gradually to raise events -- into ts
+func (s *Simulator) SetTimeSeries(ts *TimeSeries) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.timeSeries = ts
+}
+
+// sampleTimeSeries records the gradually changing fields, if sampling
+func (s *Simulator) sampleTimeSeries() {
+	s.mutex.Lock()
+	ts := s.timeSeries
+	s.mutex.Unlock()
+	if ts == nil {
+		return
+	}
+
+	s.pumpState.mutex.Lock()
+	fields := map[string]interface{}{
+		"reservoirLevel": s.pumpState.Reservoir.CurrentUnits,
+		"batteryLevel":   s.pumpState.Battery.Percentage,
+		"iob":            s.pumpState.iobAt(s.clock()),
+		"cgmReading":     s.pumpState.CGM.CurrentEGV,
+	}
+	s.pumpState.mutex.Unlock()
+	ts.Record(fields)
+}
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTimeSeriesRecordsReservoirDecline verifies basal delivery over
+// simulator updates records a strictly decreasing reservoir series, queryable
+// by field alongside the other sampled fields
+func TestTimeSeriesRecordsReservoirDecline(t *testing.T) {
+	ps := NewPumpState()
+	ps.SetControlIQEnabled(false)
+	sim := NewSimulator(ps, time.Minute)
+	ts := NewTimeSeries()
+	sim.SetTimeSeries(ts)
+
+	for i := 0; i < 5; i++ {
+		sim.Tick()
+	}
+
+	points := ts.Series("reservoirLevel")
+	if len(points) != 5 {
+		t.Fatalf("Expected a point per update, got %d: %+v", len(points), points)
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].Value >= points[i-1].Value {
+			t.Errorf("Expected reservoir to decline, got %v after %v", points[i].Value, points[i-1].Value)
+		}
+	}
+
+	// The steady CGM reading is only recorded once
+	if got := ts.Series("cgmReading"); len(got) != 1 || got[0].Value != 120 {
+		t.Errorf("Expected a single cgmReading point of 120, got %+v", got)
+	}
+	fields := ts.Fields()
+	if len(fields) != 4 || fields[0] != "batteryLevel" || fields[3] != "reservoirLevel" {
+		t.Errorf("Expected the four sampled fields, got %v", fields)
+	}
+}
+
+// TestTimeSeriesRecordsEventFields verifies the store subscribes to events as
+// a FieldNotifier, converting booleans and skipping non-numeric fields
+func TestTimeSeriesRecordsEventFields(t *testing.T) {
+	ts := NewTimeSeries()
+	notifier := FieldNotifier(ts.Record)
+
+	_ = notifier.NotifyBolusStart(3, 2.5)
+	_ = notifier.NotifyBolusComplete(3, 2.5, 2.5)
+	_ = notifier.NotifyPumpSuspended("test")
+
+	bolus := ts.Series("bolusActive")
+	if len(bolus) != 2 || bolus[0].Value != 1 || bolus[1].Value != 0 {
+		t.Errorf("Expected bolusActive to go 1 then 0, got %+v", bolus)
+	}
+	if got := ts.Series("suspendReason"); len(got) != 0 {
+		t.Errorf("Expected the string suspend reason to be skipped, got %+v", got)
+	}
+}
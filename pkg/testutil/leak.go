@@ -0,0 +1,69 @@
+// Package testutil holds helpers shared by the emulator's tests
+package testutil
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leakTimeout is how long goroutines started during a test get to exit
+// after it ends before they are reported as leaked
+const leakTimeout = 2 * time.Second
+
+// Goroutines returns the stack of every running goroutine, keyed by the
+// goroutine's "goroutine N" header
+func Goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		header := stack
+		if i := bytes.IndexByte(stack, '['); i > 0 {
+			header = stack[:i]
+		}
+		stacks[strings.TrimSpace(string(header))] = string(stack)
+	}
+	return stacks
+}
+
+// LeakedGoroutines returns the stacks of goroutines running now that weren't
+// in the before snapshot from Goroutines, waiting up to timeout for them to exit
+func LeakedGoroutines(before map[string]string, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		var leaked []string
+		for header, stack := range Goroutines() {
+			if _, existed := before[header]; !existed {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// AssertNoLeaks fails t if goroutines started during the test are still
+// running a short while after it ends. Call it first thing in the test, so
+// components the test stops with defer are stopped before the check.
+func AssertNoLeaks(t testing.TB) {
+	t.Helper()
+	before := Goroutines()
+	t.Cleanup(func() {
+		for _, stack := range LeakedGoroutines(before, leakTimeout) {
+			t.Errorf("Leaked goroutine:\n%s", stack)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLeakedGoroutinesReportsRunningGoroutine verifies a goroutine started
+// after the snapshot is reported while it runs, and not once it has exited
+func TestLeakedGoroutinesReportsRunningGoroutine(t *testing.T) {
+	before := Goroutines()
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		<-stop
+	}()
+
+	if leaked := LeakedGoroutines(before, 50*time.Millisecond); len(leaked) != 1 {
+		t.Errorf("Expected the blocked goroutine to be reported, got %d: %v", len(leaked), leaked)
+	}
+
+	close(stop)
+	<-exited
+	if leaked := LeakedGoroutines(before, leakTimeout); len(leaked) != 0 {
+		t.Errorf("Expected no leaks once the goroutine exited, got %v", leaked)
+	}
+}
@@ -35,6 +35,9 @@ const elements = {
   modeSelect: document.getElementById("mode-select"),
   configMeta: document.getElementById("config-meta"),
   constantValue: document.getElementById("constant-value"),
+  encodedPackets: document.getElementById("encoded-packets"),
+  errorCode: document.getElementById("error-code"),
+  errorProbability: document.getElementById("error-probability"),
   incrementalValues: document.getElementById("incremental-values"),
   timeBasedValues: document.getElementById("time-based-values"),
   addIncrementalBtn: document.getElementById("add-incremental"),
@@ -447,6 +450,9 @@ const loadConfig = (messageType, config) => {
   elements.messageTypeInput.value = messageType;
   elements.modeSelect.value = config.mode || "constant";
   elements.constantValue.value = config.value ? JSON.stringify(config.value, null, 2) : "";
+  elements.encodedPackets.value = (config.packets || []).join("\n");
+  elements.errorCode.value = config.error_code ?? 0;
+  elements.errorProbability.value = config.error_probability ?? 0;
   elements.incrementalValues.innerHTML = "";
   elements.timeBasedValues.innerHTML = "";
   (config.values || []).forEach((value) => {
@@ -473,7 +479,7 @@ const updateEditorVisibility = () => {
   const mode = elements.modeSelect.value;
   document.querySelectorAll(".editor-section").forEach((section) => {
     const sectionMode = section.dataset.mode;
-    section.style.display = sectionMode === mode ? "block" : "none";
+    section.style.display = sectionMode.split(" ").includes(mode) ? "block" : "none";
   });
 };
 
@@ -534,6 +540,43 @@ const buildConfigPayload = () => {
     return { payload: { mode, values, timing_seconds: timingSeconds } };
   }
 
+  if (mode === "echo") {
+    return { payload: { mode } };
+  }
+
+  if (mode === "encoded") {
+    const packets = elements.encodedPackets.value
+      .split("\n")
+      .map((line) => line.trim())
+      .filter((line) => line);
+    if (!packets.length) {
+      return { error: "Encoded mode requires at least one packet." };
+    }
+    if (packets.some((packet) => !/^([0-9a-fA-F]{2})+$/.test(packet))) {
+      return { error: "Each packet must be hex." };
+    }
+    return { payload: { mode, packets } };
+  }
+
+  if (mode === "error" || mode === "probabilistic") {
+    const errorCode = Number(elements.errorCode.value);
+    if (!Number.isInteger(errorCode) || errorCode < 0 || errorCode > 255) {
+      return { error: "Error code must be an integer from 0 to 255." };
+    }
+    if (mode === "error") {
+      return { payload: { mode, error_code: errorCode } };
+    }
+    const errorProbability = Number(elements.errorProbability.value);
+    if (Number.isNaN(errorProbability) || errorProbability < 0 || errorProbability > 1) {
+      return { error: "Error probability must be between 0 and 1." };
+    }
+    const parsed = safeJsonParse(elements.constantValue.value, "Value");
+    if (parsed.error) {
+      return { error: parsed.error };
+    }
+    return { payload: { mode, value: parsed.value, error_code: errorCode, error_probability: errorProbability } };
+  }
+
   return { error: "Unsupported mode." };
 };
 